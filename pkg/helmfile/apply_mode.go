@@ -0,0 +1,33 @@
+package helmfile
+
+import "context"
+
+const (
+	ApplyModeApply = "apply"
+	ApplyModeSync  = "sync"
+)
+
+// applyReleases runs helmfile apply, or helmfile sync with the same options when apply_mode is sync.
+// Sync upgrades every release matching the selectors without running helm-diff first, which converges
+// the releases that a misbehaving diff would leave as is.
+func applyReleases(ctx context.Context, fs *ReleaseSet, executor HelmfileExecutor, opts *ApplyOptions) (*Result, error) {
+	if fs.ApplyMode != ApplyModeSync {
+		return executor.Apply(ctx, opts)
+	}
+
+	return executor.Sync(ctx, &SyncOptions{
+		BaseOptions:          opts.BaseOptions,
+		Concurrency:          opts.Concurrency,
+		ReleasesValues:       opts.ReleasesValues,
+		Set:                  opts.Set,
+		ReleasesValuesFiles:  opts.ReleasesValuesFiles,
+		SkipSchemaValidation: opts.SkipSchemaValidation,
+		SkipCRDs:             opts.SkipCRDs,
+		NoHooks:              opts.NoHooks,
+		ReuseValues:          opts.ReuseValues,
+		ResetValues:          opts.ResetValues,
+		SyncArgs:             opts.SyncArgs,
+		Wait:                 opts.Wait,
+		WaitForJobs:          opts.WaitForJobs,
+	})
+}
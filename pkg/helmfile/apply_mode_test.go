@@ -0,0 +1,124 @@
+package helmfile
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+func TestApplyReleases(t *testing.T) {
+	fs := &ReleaseSet{Selectors: []interface{}{"tier=backend"}, Concurrency: 2, SkipSchemaValidation: true, SkipCRDs: true, NoHooks: true, ReuseValues: true, SyncArgs: "--atomic", Wait: true, WaitForJobs: true}
+	opts := buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})
+
+	t.Run("apply", func(t *testing.T) {
+		executor := &fakeExecutor{applyOutput: "UPDATED RELEASES:\n"}
+
+		if _, err := applyReleases(context.Background(), fs, executor, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(executor.appliedSelectors) != 1 || len(executor.syncedSelectors) != 0 {
+			t.Errorf("expected helmfile apply to run, got applies %v and syncs %v", executor.appliedSelectors, executor.syncedSelectors)
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		syncFS := *fs
+		syncFS.ApplyMode = ApplyModeSync
+
+		var got *SyncOptions
+		executor := &syncRecordingExecutor{fakeExecutor: &fakeExecutor{}, opts: &got}
+
+		if _, err := applyReleases(context.Background(), &syncFS, executor, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(executor.appliedSelectors) != 0 {
+			t.Errorf("expected helmfile apply not to run, got %v", executor.appliedSelectors)
+		}
+
+		want := &SyncOptions{
+			BaseOptions:          opts.BaseOptions,
+			Concurrency:          2,
+			ReleasesValues:       opts.ReleasesValues,
+			ReleasesValuesFiles:  opts.ReleasesValuesFiles,
+			SkipSchemaValidation: true,
+			SkipCRDs:             true,
+			NoHooks:              true,
+			ReuseValues:          true,
+			SyncArgs:             "--atomic",
+			Wait:                 true,
+			WaitForJobs:          true,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected sync options:\nwant: %+v\ngot:  %+v", want, got)
+		}
+	})
+}
+
+// syncRecordingExecutor records the options of the last sync
+type syncRecordingExecutor struct {
+	*fakeExecutor
+
+	opts **SyncOptions
+}
+
+func (e *syncRecordingExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
+	*e.opts = opts
+	return e.fakeExecutor.Sync(ctx, opts)
+}
+
+func TestApplyEachReleaseWithSync(t *testing.T) {
+	executor := &fakeExecutor{
+		listOutput: `[{"name":"app","namespace":"default","enabled":true,"installed":true,"labels":"","chart":"charts/app","version":""},` +
+			`{"name":"db","namespace":"default","enabled":true,"installed":true,"labels":"","chart":"charts/db","version":""}]`,
+	}
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	fs := &ReleaseSet{ContinueOnError: true, ApplyMode: ApplyModeSync}
+
+	if _, err := applyEachRelease(context.Background(), fs, buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), d, executor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"name=app,namespace=default", "name=db,namespace=default"}; !reflect.DeepEqual(executor.syncedSelectors, want) {
+		t.Errorf("expected the releases to be synced one by one with %v, got %v", want, executor.syncedSelectors)
+	}
+}
+
+func TestUpdateReleaseSetWithSync(t *testing.T) {
+	resetShutdown(t)
+
+	f := newDiffCacheFixture(t)
+	f.fs.ApplyMode = ApplyModeSync
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	executor := &fakeExecutor{syncOutput: "Upgrading release=app, chart=charts/app\n\nUPDATED RELEASES:\nNAME   CHART        VERSION\napp    charts/app   1.0.0\n"}
+
+	if err := UpdateReleaseSet(&sdk.Context{}, f.fs, d, executor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(executor.appliedSelectors) != 0 {
+		t.Errorf("expected helmfile apply not to run, got %v", executor.appliedSelectors)
+	}
+
+	if got := d.Get(KeyApplyOutput).(string); !strings.Contains(got, "UPDATED RELEASES:") {
+		t.Errorf("expected apply_output to have the output of helmfile sync, got %q", got)
+	}
+
+	want := releaseStatusList([]releaseStatus{{Name: "app", Chart: "charts/app", Version: "1.0.0", Changed: true, Action: ReleaseActionUpgrade}})
+	if got := d.Get(KeyReleaseStatus); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected release_status to have the upgraded release, got %v", got)
+	}
+}
+
+func TestBuildApplyOptionsDiffOnInstall(t *testing.T) {
+	if !buildApplyOptions(&ReleaseSet{}, &helmfileFiles{Path: "helmfile.yaml"}).SkipDiffOnInstall {
+		t.Errorf("expected the diff of the releases that are not installed to be skipped by default")
+	}
+
+	if buildApplyOptions(&ReleaseSet{DiffOnInstall: true}, &helmfileFiles{Path: "helmfile.yaml"}).SkipDiffOnInstall {
+		t.Errorf("expected the releases that are not installed to be diffed with skip_diff_on_install = false")
+	}
+}
@@ -0,0 +1,185 @@
+package helmfile
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ErrorCategoryAuthFailure is the category of the errors due to the kubeconfig exec plugin failing to get credentials
+const ErrorCategoryAuthFailure = "AuthFailure"
+
+// minAWSCLIVersions are the first AWS CLI versions whose aws eks get-token outputs the client.authentication.k8s.io/v1beta1
+// ExecCredential that the generated kubeconfig requests
+const minAWSCLIVersions = "v2.7.0, or v1.24.0 for AWS CLI v1"
+
+// execCredentialFailure is a known signature of the kubeconfig exec plugin failing, in the output of helm and helmfile
+type execCredentialFailure struct {
+	pattern *regexp.Regexp
+
+	// reason explains the failure. %s is replaced with the first submatch of the pattern, if any.
+	reason string
+
+	remediation string
+}
+
+// execCredentialFailures are tried in order, so the failures of the aws CLI come before the generic exec plugin failures
+// that client-go reports along with them
+var execCredentialFailures = []execCredentialFailure{
+	{
+		pattern:     regexp.MustCompile(`Error when retrieving token from sso: Token has expired|The SSO session associated with this profile has expired|Error loading SSO Token`),
+		reason:      "the AWS SSO session has expired or was never started",
+		remediation: "run aws sso login with the AWS profile of the exec plugin and retry",
+	},
+	{
+		pattern:     regexp.MustCompile(`The config profile \(([^)]+)\) could not be found`),
+		reason:      "the AWS profile %s is not in the AWS config of the machine running terraform",
+		remediation: "check aws_profile and the AWS_PROFILE of environment_variables, or add the profile to ~/.aws/config",
+	},
+	{
+		pattern:     regexp.MustCompile(`exec: executable (\S+) not found|exec: "([^"]+)": executable file not found`),
+		reason:      "the exec plugin command %s is not installed or not in the PATH of terraform",
+		remediation: "install the AWS CLI " + minAWSCLIVersions + " or later where terraform runs",
+	},
+	{
+		pattern:     regexp.MustCompile(`decoding stdout: couldn't get version/kind|exec plugin is configured to use API version \S+, plugin returned version|exec plugin: invalid apiVersion`),
+		reason:      "the exec plugin printed credentials that client-go couldn't parse, most likely because the AWS CLI is too old",
+		remediation: "upgrade the AWS CLI to " + minAWSCLIVersions + " or later where terraform runs",
+	},
+	{
+		pattern:     regexp.MustCompile(`getting credentials: exec: executable (\S+) failed with exit code \d+`),
+		reason:      "the exec plugin command %s failed",
+		remediation: "run the exec plugin command below to see its error, and check the AWS credentials of the machine running terraform",
+	},
+}
+
+// authFailureError is an error due to the kubeconfig exec plugin failing to get credentials.
+// It explains the failure along with the exec plugin used, so that it isn't mistaken for a provider bug.
+type authFailureError struct {
+	reason      string
+	remediation string
+
+	// kubeconfig describes the kubeconfig the exec plugin is configured in
+	kubeconfig string
+
+	// plugin is the command line of the exec plugin, or empty when the kubeconfig couldn't be read
+	plugin string
+
+	err error
+}
+
+func (e *authFailureError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s: the kubeconfig exec plugin failed to get credentials: %s\n", ErrorCategoryAuthFailure, e.reason)
+	fmt.Fprintf(&b, "  kubeconfig: %s\n", e.kubeconfig)
+	if e.plugin != "" {
+		fmt.Fprintf(&b, "  exec plugin: %s\n", e.plugin)
+	}
+	fmt.Fprintf(&b, "  remediation: %s\n\n", e.remediation)
+	b.WriteString(e.err.Error())
+
+	return b.String()
+}
+
+func (e *authFailureError) Unwrap() error {
+	return e.err
+}
+
+// errorCategory returns the category of the error, or empty when it isn't classified
+func errorCategory(err error) string {
+	var authFailure *authFailureError
+	if errors.As(err, &authFailure) {
+		return ErrorCategoryAuthFailure
+	}
+
+	return ""
+}
+
+// classifyAuthFailure returns the error as an authFailureError when its output has one of the execCredentialFailures,
+// and the error as is otherwise
+func classifyAuthFailure(fs *ReleaseSet, err error) error {
+	if err == nil || errorCategory(err) != "" {
+		return err
+	}
+
+	msg := err.Error()
+
+	for _, f := range execCredentialFailures {
+		m := f.pattern.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+
+		reason := f.reason
+		if strings.Contains(reason, "%s") {
+			var arg string
+			for _, s := range m[1:] {
+				if s != "" {
+					arg = s
+					break
+				}
+			}
+			reason = fmt.Sprintf(reason, arg)
+		}
+
+		kubeconfig, plugin := describeExecPlugin(fs)
+
+		return &authFailureError{
+			reason:      reason,
+			remediation: f.remediation,
+			kubeconfig:  kubeconfig,
+			plugin:      plugin,
+			err:         err,
+		}
+	}
+
+	return err
+}
+
+// describeExecPlugin returns the kubeconfig and the command line of the exec plugin of its current context.
+// The AWS_PROFILE of the exec plugin is included, as that is what aws eks get-token authenticates with.
+func describeExecPlugin(fs *ReleaseSet) (string, string) {
+	k, err := resolveKubeconfig(fs)
+	if err != nil {
+		return fmt.Sprintf("unknown: %v", err), ""
+	}
+
+	kubeconfig := k.Path
+	if fs.GeneratedKubeconfig != "" && fs.GeneratedKubeconfig == k.Path {
+		kubeconfig += " (generated for " + KeyEKSClusterName + ")"
+	} else if s := k.String(); s != "" {
+		kubeconfig = s
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(k.Path)}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return kubeconfig, ""
+	}
+
+	current, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return kubeconfig, ""
+	}
+
+	user, ok := config.AuthInfos[current.AuthInfo]
+	if !ok || user.Exec == nil {
+		return kubeconfig, ""
+	}
+
+	plugin := strings.Join(append([]string{user.Exec.Command}, user.Exec.Args...), " ")
+
+	for _, e := range user.Exec.Env {
+		if e.Name == "AWS_PROFILE" {
+			plugin += " (AWS_PROFILE=" + e.Value + ")"
+		}
+	}
+
+	return kubeconfig, plugin
+}
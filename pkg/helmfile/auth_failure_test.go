@@ -0,0 +1,136 @@
+package helmfile
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The errors below are captured from helmfile diff and apply with the kubeconfig generated for eks_cluster_name
+const (
+	awsCLIMissingError = `/usr/local/bin/helmfile: exit status 1
+Comparing release=app, chart=charts/app, namespace=default
+in ./helmfile-1a2b3c.yaml: command "/usr/local/bin/helm" exited with non-zero status:
+
+PATH:
+  /usr/local/bin/helm
+
+ARGS:
+  0: helm (4 bytes)
+  1: diff (4 bytes)
+  2: upgrade (7 bytes)
+
+ERROR:
+  exit status 1
+
+EXIT STATUS
+  1
+
+STDERR:
+  Error: Kubernetes cluster unreachable: Get "https://0123456789ABCDEF.gr7.us-west-2.eks.amazonaws.com/version": getting credentials: exec: executable aws not found
+
+  It looks like you are trying to use a client-go credential plugin that is not installed.
+
+  To learn more about this feature, consult the documentation available at:
+        https://kubernetes.io/docs/reference/access-authn-authz/authentication/#client-go-credential-plugins
+`
+
+	awsSSOExpiredError = `running helmfile-apply: failed processing release app: command "/usr/local/bin/helm" exited with non-zero status:
+
+STDERR:
+
+  Error when retrieving token from sso: Token has expired and refresh failed
+  Error: Kubernetes cluster unreachable: Get "https://0123456789ABCDEF.gr7.us-west-2.eks.amazonaws.com/version": getting credentials: exec: executable aws failed with exit code 255
+`
+
+	awsProfileNotFoundError = `/usr/local/bin/helmfile: exit status 1
+STDERR:
+
+  The config profile (staging) could not be found
+  Error: Kubernetes cluster unreachable: Get "https://0123456789ABCDEF.gr7.us-west-2.eks.amazonaws.com/version": getting credentials: exec: executable aws failed with exit code 255
+`
+
+	awsCLITooOldError = `/usr/local/bin/helmfile: exit status 1
+STDERR:
+  Error: Kubernetes cluster unreachable: Get "https://0123456789ABCDEF.gr7.us-west-2.eks.amazonaws.com/version": getting credentials: exec plugin is configured to use API version client.authentication.k8s.io/v1beta1, plugin returned version client.authentication.k8s.io/v1alpha1
+`
+)
+
+func TestClassifyAuthFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	kubeconfigYAML, err := generateKubeconfigYAML(&EKSClusterConfig{
+		ClusterName: "prod",
+		Region:      "us-west-2",
+		Endpoint:    "https://0123456789ABCDEF.gr7.us-west-2.eks.amazonaws.com",
+		CA:          "Y2E=",
+		AWSProfile:  "staging",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(kubeconfigYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ReleaseSet{Kubeconfig: kubeconfig, GeneratedKubeconfig: kubeconfig}
+
+	tests := []struct {
+		name   string
+		err    string
+		reason string
+	}{
+		{name: "aws CLI missing", err: awsCLIMissingError, reason: "the exec plugin command aws is not installed"},
+		{name: "SSO expired", err: awsSSOExpiredError, reason: "the AWS SSO session has expired"},
+		{name: "wrong profile", err: awsProfileNotFoundError, reason: "the AWS profile staging is not in the AWS config"},
+		{name: "aws CLI too old", err: awsCLITooOldError, reason: "most likely because the AWS CLI is too old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cause := errors.New(tt.err)
+
+			err := classifyAuthFailure(fs, fmt.Errorf("running helmfile diff: %w", cause))
+
+			if got := errorCategory(err); got != ErrorCategoryAuthFailure {
+				t.Fatalf("expected the error to be categorized as %s, got %q", ErrorCategoryAuthFailure, got)
+			}
+			if !errors.Is(err, cause) {
+				t.Error("expected the original error to be wrapped")
+			}
+
+			msg := err.Error()
+			for _, want := range []string{
+				tt.reason,
+				"kubeconfig: " + kubeconfig + " (generated for eks_cluster_name)",
+				"exec plugin: aws eks get-token --cluster-name prod --region us-west-2 (AWS_PROFILE=staging)",
+				tt.err,
+			} {
+				if !strings.Contains(msg, want) {
+					t.Errorf("expected the error to contain %q, got:\n%s", want, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyAuthFailureUnrelatedErrors(t *testing.T) {
+	fs := &ReleaseSet{}
+
+	if err := classifyAuthFailure(fs, nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	unreachable := errors.New(`Error: Kubernetes cluster unreachable: Get "https://127.0.0.1:6443/version": dial tcp 127.0.0.1:6443: connect: connection refused`)
+	if err := classifyAuthFailure(fs, unreachable); err != unreachable {
+		t.Errorf("expected the error as is, got %v", err)
+	}
+	if got := errorCategory(unreachable); got != "" {
+		t.Errorf("expected no category, got %q", got)
+	}
+}
@@ -0,0 +1,53 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// capabilitiesArgs returns the helm flags that set .Capabilities.KubeVersion and .Capabilities.APIVersions,
+// for the helmfile commands that can pass them to helm only as extra args
+func capabilitiesArgs(kubeVersion string, apiVersions []string) []string {
+	var args []string
+
+	if kubeVersion != "" {
+		args = append(args, "--kube-version="+kubeVersion)
+	}
+
+	for _, v := range apiVersions {
+		args = append(args, "--api-versions="+v)
+	}
+
+	return args
+}
+
+// validateKubeVersion validates kube_version the way helm template parses --kube-version
+func validateKubeVersion(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return nil, nil
+	}
+
+	if _, err := chartutil.ParseKubeVersion(s); err != nil {
+		return nil, []error{fmt.Errorf("%s: invalid Kubernetes version %q: %v", k, s, err)}
+	}
+
+	return nil, nil
+}
+
+// validateAPIVersion validates that each of api_versions can be passed within the space-separated args
+// that helmfile passes to helm
+func validateAPIVersion(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	if s == "" || strings.ContainsAny(s, " \t\n") {
+		return nil, []error{fmt.Errorf("%s: invalid API version %q: API versions can't be empty or contain spaces", k, s)}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,43 @@
+package helmfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCapabilitiesArgs(t *testing.T) {
+	got := capabilitiesArgs("1.29.0", []string{"monitoring.coreos.com/v1", "policy/v1beta1"})
+
+	expected := []string{"--kube-version=1.29.0", "--api-versions=monitoring.coreos.com/v1", "--api-versions=policy/v1beta1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if got := capabilitiesArgs("", nil); len(got) != 0 {
+		t.Errorf("expected no args, got %q", got)
+	}
+}
+
+func TestValidateKubeVersion(t *testing.T) {
+	for _, v := range []string{"1.29.0", "v1.29.0", "1.29"} {
+		if _, errs := validateKubeVersion(v, KeyKubeVersion); len(errs) != 0 {
+			t.Errorf("unexpected errors for %s: %v", v, errs)
+		}
+	}
+
+	if _, errs := validateKubeVersion("latest", KeyKubeVersion); len(errs) != 1 {
+		t.Errorf("expected an error for an invalid version, got %v", errs)
+	}
+}
+
+func TestValidateAPIVersion(t *testing.T) {
+	if _, errs := validateAPIVersion("monitoring.coreos.com/v1/ServiceMonitor", KeyAPIVersions); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	for _, v := range []string{"", "policy/v1 policy/v1beta1"} {
+		if _, errs := validateAPIVersion(v, KeyAPIVersions); len(errs) != 1 {
+			t.Errorf("expected an error for %q, got %v", v, errs)
+		}
+	}
+}
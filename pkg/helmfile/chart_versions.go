@@ -0,0 +1,199 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"gopkg.in/yaml.v2"
+)
+
+// exactChartVersionPattern matches the chart versions that are not constraints, so that they are used as is
+var exactChartVersionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// showChart runs helm show chart with the args and returns the Chart.yaml. It is a variable to be replaced in tests.
+var showChart = func(ctx context.Context, fs *ReleaseSet, args ...string) (string, error) {
+	return runReleaseSetHelm(ctx, fs, append([]string{"show", "chart"}, args...)...)
+}
+
+// resolveChartVersions returns the concrete chart version that each release would install, by namespace/name,
+// and the releases whose version couldn't be resolved, which are skipped with a warning.
+func resolveChartVersions(ctx *sdk.Context, fs *ReleaseSet) (map[string]string, []string, error) {
+	build, err := runBuild(ctx, fs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("running helmfile build: %w", err)
+	}
+
+	output, err := removeNondeterministicBuildLogLines(build.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	releases, err := parseBuildReleases(output)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolved := map[string]string{}
+
+	var unresolved []string
+
+	for _, r := range releases {
+		v, err := resolveChartVersion(context.Background(), fs, r)
+		if err != nil {
+			logf("Warning: not resolving the chart version of release %s: %v", r.ID(), err)
+			unresolved = append(unresolved, r.ID())
+			continue
+		}
+
+		resolved[r.ID()] = v
+	}
+
+	return resolved, unresolved, nil
+}
+
+// resolveChartVersion returns the version of the chart of the release.
+//
+// Exact versions are used as is. Local charts are read from their Chart.yaml relative to the working directory.
+// The version constraints, including the empty one for the latest version, are resolved by helm against the
+// repository of the chart, like helmfile does on apply.
+func resolveChartVersion(ctx context.Context, fs *ReleaseSet, r buildRelease) (string, error) {
+	if exactChartVersionPattern.MatchString(r.Version) {
+		return strings.TrimPrefix(r.Version, "v"), nil
+	}
+
+	var args []string
+
+	switch {
+	case strings.HasPrefix(r.Chart, "oci://"):
+		args = []string{r.Chart}
+	case r.Repository != nil && r.Repository.OCI:
+		args = []string{"oci://" + strings.TrimSuffix(r.Repository.URL, "/") + "/" + strings.TrimPrefix(r.Chart, r.Repository.Name+"/")}
+	case r.Repository != nil:
+		args = []string{strings.TrimPrefix(r.Chart, r.Repository.Name+"/"), "--repo", r.Repository.URL}
+	case isLocalChart(r.Chart):
+		path := r.Chart
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(fs.WorkingDirectory, path)
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(path, "Chart.yaml"))
+		if err != nil {
+			return "", err
+		}
+
+		return chartVersion(string(bs))
+	default:
+		// The repository is one added to helm outside of the helmfile
+		args = []string{r.Chart}
+	}
+
+	if r.Version != "" {
+		args = append(args, "--version", r.Version)
+	}
+
+	out, err := showChart(ctx, fs, args...)
+	if err != nil {
+		return "", err
+	}
+
+	return chartVersion(out)
+}
+
+// isLocalChart returns true when the chart is a path rather than a chart in a repository
+func isLocalChart(chart string) bool {
+	return strings.HasPrefix(chart, ".") || filepath.IsAbs(chart) || !strings.Contains(chart, "/")
+}
+
+// chartVersion returns the version in the Chart.yaml
+func chartVersion(chartYAML string) (string, error) {
+	var chart struct {
+		Version string `yaml:"version"`
+	}
+
+	if err := yaml.Unmarshal([]byte(chartYAML), &chart); err != nil {
+		return "", fmt.Errorf("parsing Chart.yaml: %w", err)
+	}
+
+	if chart.Version == "" {
+		return "", fmt.Errorf("no version in Chart.yaml")
+	}
+
+	return chart.Version, nil
+}
+
+// chartVersionChanges renders the lines added to diff_output for the releases whose resolved chart version differs from
+// the one recorded by the last apply. The releases without a recorded version are new, and left to the diff.
+func chartVersionChanges(recorded map[string]interface{}, resolved map[string]string) string {
+	var ids []string
+	for id := range resolved {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+
+	for _, id := range ids {
+		previous, ok := recorded[id].(string)
+		if !ok || previous == resolved[id] {
+			continue
+		}
+
+		fmt.Fprintf(&b, "chart %s: %s → %s\n", id, previous, resolved[id])
+	}
+
+	return b.String()
+}
+
+// reportChartVersionChanges resolves the chart versions of the releases to record them in resolved_chart_versions,
+// and returns the diff with the changes from the recorded versions appended.
+// Failing to resolve them only logs a warning, as it must never fail the plan.
+func reportChartVersionChanges(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, diff string) string {
+	recorded, _ := d.Get(KeyResolvedChartVersions).(map[string]interface{})
+
+	resolved, ok := recordChartVersions(ctx, fs, d, recorded)
+	if !ok {
+		return diff
+	}
+
+	changes := chartVersionChanges(recorded, resolved)
+	if changes == "" {
+		return diff
+	}
+
+	if diff != "" && !strings.HasSuffix(diff, "\n") {
+		diff += "\n"
+	}
+
+	return diff + changes
+}
+
+// recordChartVersions sets resolved_chart_versions to the resolved chart versions of the releases.
+// The releases whose version couldn't be resolved keep their recorded version, so that a transient failure doesn't
+// show up as a change. Failing to resolve any version only logs a warning and returns false.
+func recordChartVersions(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, recorded map[string]interface{}) (map[string]string, bool) {
+	resolved, unresolved, err := resolveChartVersions(ctx, fs)
+	if err != nil {
+		logf("Warning: not updating %s: %v", KeyResolvedChartVersions, err)
+		return nil, false
+	}
+
+	versions := make(map[string]interface{}, len(resolved))
+	for id, v := range resolved {
+		versions[id] = v
+	}
+	for _, id := range unresolved {
+		if v, ok := recorded[id]; ok {
+			versions[id] = v
+		}
+	}
+
+	d.Set(KeyResolvedChartVersions, versions)
+
+	return resolved, true
+}
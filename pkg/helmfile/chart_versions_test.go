@@ -0,0 +1,227 @@
+package helmfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// fakeShowChart resolves the constraints against the versions 1.2.3, 1.2.5, 1.3.0 and 2.0.0 of any chart
+func fakeShowChart(t *testing.T, calls *[][]string) func(context.Context, *ReleaseSet, ...string) (string, error) {
+	return func(ctx context.Context, fs *ReleaseSet, args ...string) (string, error) {
+		*calls = append(*calls, args)
+
+		resolved := map[string]string{
+			"":             "2.0.0",
+			"~1.2":         "1.2.5",
+			"^1.2":         "1.3.0",
+			">=1.0 <1.3":   "1.2.5",
+			"1.2.x":        "1.2.5",
+			"1.2":          "1.2.5",
+			"~1.2.3-beta":  "1.2.5",
+			"1.2.3 || 2.x": "2.0.0",
+		}
+
+		var constraint string
+		for i, a := range args {
+			if a == "--version" {
+				constraint = args[i+1]
+			}
+		}
+
+		v, ok := resolved[constraint]
+		if !ok {
+			t.Fatalf("unexpected constraint %q", constraint)
+		}
+
+		return "apiVersion: v2\nname: app\nversion: " + v + "\n", nil
+	}
+}
+
+func TestResolveChartVersion(t *testing.T) {
+	var calls [][]string
+
+	show := showChart
+	t.Cleanup(func() { showChart = show })
+	showChart = fakeShowChart(t, &calls)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "charts", "local"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "charts", "local", "Chart.yaml"), []byte("apiVersion: v2\nname: local\nversion: 0.1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ReleaseSet{WorkingDirectory: dir}
+
+	stable := &chartRepository{Name: "stable", URL: "https://charts.example.com"}
+	ecr := &chartRepository{Name: "ecr", URL: "123456789012.dkr.ecr.us-west-2.amazonaws.com/charts/", OCI: true}
+
+	tests := []struct {
+		name     string
+		release  buildRelease
+		want     string
+		wantArgs []string
+	}{
+		{name: "exact", release: buildRelease{Chart: "stable/app", Version: "1.2.3", Repository: stable}, want: "1.2.3"},
+		{name: "exact with v", release: buildRelease{Chart: "stable/app", Version: "v1.2.3"}, want: "1.2.3"},
+		{name: "exact prerelease", release: buildRelease{Chart: "stable/app", Version: "1.2.3-rc.1+build.5"}, want: "1.2.3-rc.1+build.5"},
+		{name: "tilde", release: buildRelease{Chart: "stable/app", Version: "~1.2", Repository: stable}, want: "1.2.5", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", "~1.2"}},
+		{name: "caret", release: buildRelease{Chart: "stable/app", Version: "^1.2", Repository: stable}, want: "1.3.0", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", "^1.2"}},
+		{name: "range", release: buildRelease{Chart: "stable/app", Version: ">=1.0 <1.3", Repository: stable}, want: "1.2.5", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", ">=1.0 <1.3"}},
+		{name: "wildcard", release: buildRelease{Chart: "stable/app", Version: "1.2.x", Repository: stable}, want: "1.2.5", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", "1.2.x"}},
+		{name: "partial", release: buildRelease{Chart: "stable/app", Version: "1.2", Repository: stable}, want: "1.2.5", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", "1.2"}},
+		{name: "prerelease constraint", release: buildRelease{Chart: "stable/app", Version: "~1.2.3-beta", Repository: stable}, want: "1.2.5", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", "~1.2.3-beta"}},
+		{name: "or", release: buildRelease{Chart: "stable/app", Version: "1.2.3 || 2.x", Repository: stable}, want: "2.0.0", wantArgs: []string{"app", "--repo", "https://charts.example.com", "--version", "1.2.3 || 2.x"}},
+		{name: "latest", release: buildRelease{Chart: "stable/app", Repository: stable}, want: "2.0.0", wantArgs: []string{"app", "--repo", "https://charts.example.com"}},
+		{name: "oci chart", release: buildRelease{Chart: "oci://registry.example.com/charts/app", Version: "~1.2"}, want: "1.2.5", wantArgs: []string{"oci://registry.example.com/charts/app", "--version", "~1.2"}},
+		{name: "oci repository", release: buildRelease{Chart: "ecr/app", Version: "~1.2", Repository: ecr}, want: "1.2.5", wantArgs: []string{"oci://123456789012.dkr.ecr.us-west-2.amazonaws.com/charts/app", "--version", "~1.2"}},
+		{name: "repository added to helm", release: buildRelease{Chart: "bitnami/app", Version: "~1.2"}, want: "1.2.5", wantArgs: []string{"bitnami/app", "--version", "~1.2"}},
+		{name: "local", release: buildRelease{Chart: "./charts/local"}, want: "0.1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+
+			got, err := resolveChartVersion(context.Background(), fs, tt.release)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+
+			var wantCalls [][]string
+			if tt.wantArgs != nil {
+				wantCalls = [][]string{tt.wantArgs}
+			}
+			if !reflect.DeepEqual(calls, wantCalls) {
+				t.Errorf("unexpected helm show chart runs:\nwant: %q\ngot:  %q", wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestParseBuildReleasesCharts(t *testing.T) {
+	releases, err := parseBuildReleases(`---
+repositories:
+- name: stable
+  url: https://charts.example.com
+- name: ecr
+  url: 123456789012.dkr.ecr.us-west-2.amazonaws.com/charts
+  oci: true
+releases:
+- name: app
+  chart: stable/app
+  version: ~1.2
+- name: api
+  chart: ecr/api
+  version: 1.2
+- name: local
+  chart: ./charts/local
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 3 {
+		t.Fatalf("expected 3 releases, got %d", len(releases))
+	}
+
+	if r := releases[0]; r.Chart != "stable/app" || r.Version != "~1.2" || r.Repository == nil || r.Repository.URL != "https://charts.example.com" {
+		t.Errorf("unexpected release: %+v", r)
+	}
+	if r := releases[1]; r.Version != "1.2" || r.Repository == nil || !r.Repository.OCI {
+		t.Errorf("unexpected release: %+v", r)
+	}
+	if r := releases[2]; r.Repository != nil {
+		t.Errorf("expected no repository for a local chart, got %+v", r.Repository)
+	}
+}
+
+func TestChartVersionChanges(t *testing.T) {
+	recorded := map[string]interface{}{
+		"default/app": "1.2.3",
+		"default/db":  "2.0.0",
+	}
+	resolved := map[string]string{
+		"default/app": "1.2.5",
+		"default/db":  "2.0.0",
+		"default/new": "0.1.0",
+	}
+
+	if got, want := chartVersionChanges(recorded, resolved), "chart default/app: 1.2.3 → 1.2.5\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if got := chartVersionChanges(nil, resolved); got != "" {
+		t.Errorf("expected no changes without recorded versions, got %q", got)
+	}
+}
+
+func TestReportChartVersionChanges(t *testing.T) {
+	f := newDiffCacheFixture(t)
+	f.write("build.yaml", `---
+repositories:
+- name: stable
+  url: https://charts.example.com
+releases:
+- name: app
+  namespace: default
+  chart: stable/app
+  version: ~1.2
+- name: db
+  namespace: default
+  chart: stable/db
+  version: 2.0.0
+- name: web
+  namespace: default
+  chart: stable/web
+  version: ^9
+`, 0644)
+
+	var calls [][]string
+
+	show := showChart
+	t.Cleanup(func() { showChart = show })
+	fake := fakeShowChart(t, &calls)
+	showChart = func(ctx context.Context, fs *ReleaseSet, args ...string) (string, error) {
+		if args[0] == "web" {
+			calls = append(calls, args)
+			return "", os.ErrNotExist
+		}
+		return fake(ctx, fs, args...)
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{
+		KeyResolvedChartVersions: map[string]interface{}{"default/app": "1.2.3", "default/db": "2.0.0", "default/web": "9.0.0"},
+	}}
+
+	diff := reportChartVersionChanges(&sdk.Context{}, f.fs, d, "default, app, Deployment (apps) has changed:")
+
+	if want := "default, app, Deployment (apps) has changed:\nchart default/app: 1.2.3 → 1.2.5\n"; diff != want {
+		t.Errorf("unexpected diff:\nwant: %q\ngot:  %q", want, diff)
+	}
+
+	// The version of web that failed to resolve is kept as recorded
+	want := map[string]interface{}{"default/app": "1.2.5", "default/db": "2.0.0", "default/web": "9.0.0"}
+	if got := d.Get(KeyResolvedChartVersions); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected resolved chart versions:\nwant: %v\ngot:  %v", want, got)
+	}
+
+	// Without changes, the diff is left as is
+	if got := reportChartVersionChanges(&sdk.Context{}, f.fs, d, ""); got != "" {
+		t.Errorf("expected no diff, got %q", got)
+	}
+
+	if calls := f.diffCalls(); len(calls) != 0 {
+		t.Errorf("expected helmfile diff not to run, got %v", calls)
+	}
+}
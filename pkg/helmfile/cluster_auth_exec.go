@@ -0,0 +1,164 @@
+package helmfile
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+const (
+	ExecAPIVersionV1Alpha1 = "client.authentication.k8s.io/v1alpha1"
+	ExecAPIVersionV1Beta1  = "client.authentication.k8s.io/v1beta1"
+	ExecAPIVersionV1       = "client.authentication.k8s.io/v1"
+
+	// clusterAuthExecName is the name of the cluster, context and user of the kubeconfig generated for cluster_auth_exec
+	clusterAuthExecName = "cluster"
+)
+
+// ClusterConfig contains the configuration needed to generate a kubeconfig for a cluster authenticated by
+// an exec plugin like gke-gcloud-auth-plugin or kubelogin
+type ClusterConfig struct {
+	Name     string
+	Endpoint string
+	CA       string
+	Exec     ExecConfig
+}
+
+// clusterAuthExecSchema is the schema of cluster_auth_exec
+func clusterAuthExecSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		MaxItems:    1,
+		Description: "Exec plugin like gke-gcloud-auth-plugin or kubelogin that the kubeconfig generated for cluster_endpoint and cluster_ca authenticates with",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"command": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "Command of the exec plugin",
+				},
+				"args": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Elem:        &schema.Schema{Type: schema.TypeString},
+					Description: "Arguments of the command",
+				},
+				"env": {
+					Type:        schema.TypeMap,
+					Optional:    true,
+					Sensitive:   true,
+					Elem:        &schema.Schema{Type: schema.TypeString},
+					Description: "Environment variables of the command",
+				},
+				"api_version": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      ExecAPIVersionV1Beta1,
+					ValidateFunc: validation.StringInSlice([]string{ExecAPIVersionV1Beta1, ExecAPIVersionV1}, false),
+					Description:  "API version of the ExecCredential the plugin returns",
+				},
+			},
+		},
+	}
+}
+
+// getClusterAuthExec returns the cluster_auth_exec block, if any
+func getClusterAuthExec(d ResourceRead) map[string]interface{} {
+	blocks, ok := d.Get(KeyClusterAuthExec).([]interface{})
+	if !ok || len(blocks) == 0 {
+		return nil
+	}
+
+	block, _ := blocks[0].(map[string]interface{})
+
+	return block
+}
+
+// getClusterConfig returns the config of cluster_auth_exec, cluster_endpoint and cluster_ca, or nil when
+// cluster_auth_exec is not set
+func getClusterConfig(d ResourceRead) *ClusterConfig {
+	block := getClusterAuthExec(d)
+	if block == nil {
+		return nil
+	}
+
+	exec := ExecConfig{APIVersion: ExecAPIVersionV1Beta1}
+
+	exec.Command, _ = block["command"].(string)
+
+	if v, _ := block["api_version"].(string); v != "" {
+		exec.APIVersion = v
+	}
+
+	exec.InteractiveMode = execInteractiveMode(exec.APIVersion)
+
+	args, _ := block["args"].([]interface{})
+	for _, a := range args {
+		arg, _ := a.(string)
+		exec.Args = append(exec.Args, arg)
+	}
+
+	env, _ := block["env"].(map[string]interface{})
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+
+	// Sorted so that the kubeconfig is the same across operations
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, _ := env[name].(string)
+		exec.Env = append(exec.Env, ExecEnvVar{Name: name, Value: value})
+	}
+
+	return &ClusterConfig{
+		Name:     clusterAuthExecName,
+		Endpoint: d.Get(KeyClusterEndpoint).(string),
+		CA:       d.Get(KeyClusterCA).(string),
+		Exec:     exec,
+	}
+}
+
+// validateClusterAuthExec validates that cluster_auth_exec, cluster_endpoint and cluster_ca are provided together
+// and not along with eks_cluster_name
+func validateClusterAuthExec(d ResourceRead) error {
+	hasExec := getClusterAuthExec(d) != nil
+	endpoint := d.Get(KeyClusterEndpoint).(string)
+	ca := d.Get(KeyClusterCA).(string)
+
+	if !hasExec && endpoint == "" && ca == "" {
+		return nil
+	}
+
+	if d.Get(KeyEKSClusterName).(string) != "" {
+		return fmt.Errorf("'eks_cluster_name' cannot be provided with 'cluster_auth_exec', 'cluster_endpoint' or 'cluster_ca'")
+	}
+
+	if !hasExec || endpoint == "" || ca == "" {
+		return fmt.Errorf("cluster_auth_exec, cluster_endpoint and cluster_ca must be provided together")
+	}
+
+	return nil
+}
+
+// execInteractiveMode returns the interactiveMode required by the apiVersion of an exec plugin, which never
+// prompts as terraform runs it non-interactively
+func execInteractiveMode(apiVersion string) string {
+	if apiVersion == ExecAPIVersionV1 {
+		return "Never"
+	}
+
+	return ""
+}
+
+// generateClusterKubeconfigYAML creates a kubeconfig YAML string with the exec plugin of the config
+func generateClusterKubeconfigYAML(config *ClusterConfig) (string, error) {
+	logf("Generating kubeconfig YAML for cluster %s authenticated by %s", config.Endpoint, config.Exec.Command)
+
+	return marshalKubeconfig(config.Name, config.Endpoint, config.CA, UserDetail{Exec: config.Exec})
+}
@@ -0,0 +1,123 @@
+package helmfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestGenerateClusterKubeconfigYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		exec     map[string]interface{}
+		expected ExecConfig
+	}{
+		{
+			name: "gke-gcloud-auth-plugin",
+			exec: map[string]interface{}{
+				"command":     "gke-gcloud-auth-plugin",
+				"api_version": ExecAPIVersionV1Beta1,
+			},
+			expected: ExecConfig{
+				APIVersion: ExecAPIVersionV1Beta1,
+				Command:    "gke-gcloud-auth-plugin",
+				Args:       []string{},
+			},
+		},
+		{
+			name: "kubelogin",
+			exec: map[string]interface{}{
+				"command": "kubelogin",
+				"args": []interface{}{
+					"get-token", "--login", "spn", "--environment", "AzurePublicCloud",
+					"--server-id", "6dae42f8-4368-4678-94ff-3960e28e3630",
+				},
+				"env": map[string]interface{}{
+					"AAD_SERVICE_PRINCIPAL_CLIENT_SECRET": "secret",
+					"AAD_SERVICE_PRINCIPAL_CLIENT_ID":     "client-id",
+				},
+				"api_version": ExecAPIVersionV1,
+			},
+			expected: ExecConfig{
+				APIVersion: ExecAPIVersionV1,
+				Command:    "kubelogin",
+				Args: []string{
+					"get-token", "--login", "spn", "--environment", "AzurePublicCloud",
+					"--server-id", "6dae42f8-4368-4678-94ff-3960e28e3630",
+				},
+				Env: []ExecEnvVar{
+					{Name: "AAD_SERVICE_PRINCIPAL_CLIENT_ID", Value: "client-id"},
+					{Name: "AAD_SERVICE_PRINCIPAL_CLIENT_SECRET", Value: "secret"},
+				},
+				InteractiveMode: "Never",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &mockResourceRead{data: map[string]interface{}{
+				KeyClusterAuthExec: []interface{}{tt.exec},
+				KeyClusterEndpoint: "https://34.123.45.67",
+				KeyClusterCA:       "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+			}}
+
+			config := getClusterConfig(d)
+			if config == nil {
+				t.Fatal("expected the cluster config of cluster_auth_exec")
+			}
+
+			yamlStr, err := generateClusterKubeconfigYAML(config)
+			if err != nil {
+				t.Fatalf("generateClusterKubeconfigYAML() error = %v", err)
+			}
+
+			var kubeconfig KubeconfigData
+			if err := yaml.Unmarshal([]byte(yamlStr), &kubeconfig); err != nil {
+				t.Fatalf("failed to parse generated YAML: %v", err)
+			}
+
+			if len(kubeconfig.Clusters) != 1 || kubeconfig.Clusters[0].Cluster.Server != "https://34.123.45.67" {
+				t.Errorf("unexpected clusters: %+v", kubeconfig.Clusters)
+			}
+
+			if kubeconfig.Clusters[0].Cluster.CertificateAuthorityData != "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t" {
+				t.Errorf("unexpected certificate authority data: %s", kubeconfig.Clusters[0].Cluster.CertificateAuthorityData)
+			}
+
+			if kubeconfig.CurrentContext != clusterAuthExecName {
+				t.Errorf("expected current-context %s, got %s", clusterAuthExecName, kubeconfig.CurrentContext)
+			}
+
+			if len(kubeconfig.Users) != 1 {
+				t.Fatalf("expected 1 user, got %d", len(kubeconfig.Users))
+			}
+
+			user := kubeconfig.Users[0].User
+
+			if !reflect.DeepEqual(user.Exec, tt.expected) {
+				t.Errorf("unexpected exec config:\nexpected %+v\ngot      %+v", tt.expected, user.Exec)
+			}
+
+			if user.Token != "" {
+				t.Errorf("expected no token, got %s", user.Token)
+			}
+
+			if strings.Contains(yamlStr, "get-token --cluster-name") || strings.Contains(yamlStr, "command: aws") {
+				t.Errorf("expected no aws eks get-token in the kubeconfig:\n%s", yamlStr)
+			}
+		})
+	}
+}
+
+func TestGetClusterConfigWithoutClusterAuthExec(t *testing.T) {
+	d := &mockResourceRead{data: map[string]interface{}{
+		KeyClusterAuthExec: []interface{}{},
+	}}
+
+	if config := getClusterConfig(d); config != nil {
+		t.Errorf("expected no cluster config, got %+v", config)
+	}
+}
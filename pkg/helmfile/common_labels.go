@@ -0,0 +1,235 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const kustomizeTransformersKey = "transformers"
+
+// commonLabelsTransformer returns a kustomize LabelTransformer adding the labels to the metadata.labels of every
+// object. Unlike the commonLabels of kustomize, it leaves the selectors and the pod templates alone, as changing the
+// selector of an existing Deployment fails.
+func commonLabelsTransformer(labels map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := yaml.MapSlice{}
+	for _, k := range keys {
+		values = append(values, yaml.MapItem{Key: k, Value: fmt.Sprintf("%v", labels[k])})
+	}
+
+	return yaml.Marshal(yaml.MapSlice{
+		{Key: "apiVersion", Value: "builtin"},
+		{Key: "kind", Value: "LabelTransformer"},
+		{Key: "metadata", Value: yaml.MapSlice{{Key: "name", Value: "terraform-common-labels"}}},
+		{Key: "labels", Value: values},
+		{Key: "fieldSpecs", Value: []yaml.MapSlice{{{Key: "path", Value: "metadata/labels"}, {Key: "create", Value: true}}}},
+	})
+}
+
+// injectCommonLabels writes kustomize LabelTransformers for the labels into dir, and adds them to the transformers
+// of the releases in the helmfile content. Helmfile applies the transformers to the rendered manifests with chartify,
+// so that the labels end up on every object of the releases, and in the diff of the releases.
+//
+// The labels declared in content win: the keys in the top-level commonLabels of the document of a release or in the
+// labels of the release are left out of its transformer, and the releases declaring every label are left alone,
+// so that they are not chartified for nothing. The transformer runs before the transformers the release already
+// declares, so that those override the labels too.
+//
+// The commonLabels of helmfile are left alone, as they only label the releases for the selectors.
+// Like injectKustomizePatches, it operates on the text line-by-line as the content can be a Go template, and covers
+// the releases of every document of the content.
+// It returns the paths to the transformer files along with the content, to be removed after the operation.
+func injectCommonLabels(content, dir string, labels map[string]interface{}) (_ string, _ []string, err error) {
+	if len(labels) == 0 {
+		return content, nil, nil
+	}
+
+	lines := strings.Split(content, "\n")
+
+	items := releaseItems(lines)
+	if len(items) == 0 {
+		logf("Warning: no releases found in content to add %s to", KeyCommonLabels)
+
+		return content, nil, nil
+	}
+
+	var written []string
+	seen := map[string]bool{}
+
+	// The transformer files are referenced by no helmfile on errors
+	defer func() {
+		if err != nil {
+			removeTempFiles(false, written...)
+		}
+	}()
+
+	declared := documentCommonLabels(lines)
+
+	// Lines to insert before the line at the index, and lines to replace, so that indices of the original lines stay valid
+	inserts := map[int][]string{}
+	replaced := map[int]string{}
+
+	for _, item := range items {
+		missing := map[string]interface{}{}
+		for k, v := range labels {
+			if !declared[item.document][k] && !item.labels[k] {
+				missing[k] = v
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		path, err := writeCommonLabelsTransformer(dir, missing)
+		if err != nil {
+			return "", nil, err
+		}
+		if !seen[path] {
+			seen[path] = true
+			written = append(written, path)
+		}
+
+		line, ok := item.keys[kustomizeTransformersKey]
+		if !ok {
+			inserts[item.end] = append(inserts[item.end],
+				fmt.Sprintf("%s%s:", item.indent, kustomizeTransformersKey),
+				fmt.Sprintf("%s- %s", item.indent, strconv.Quote(path)),
+			)
+			continue
+		}
+
+		l := lines[line]
+		colon := strings.Index(l, ":")
+		value := strings.TrimSpace(l[colon+1:])
+
+		switch {
+		case value == "" || strings.HasPrefix(value, "#"):
+			// A block sequence, whose items can be indented either like the key or deeper
+			indent := item.indent
+			if next := nextContentLine(lines, line+1); next >= 0 && strings.HasPrefix(strings.TrimLeft(lines[next], " "), "- ") {
+				indent = lines[next][:len(lines[next])-len(strings.TrimLeft(lines[next], " "))]
+			}
+			inserts[line+1] = append(inserts[line+1], fmt.Sprintf("%s- %s", indent, strconv.Quote(path)))
+		case strings.HasPrefix(value, "["):
+			open := strings.Index(l, "[")
+			sep := ", "
+			if strings.HasPrefix(strings.TrimSpace(l[open+1:]), "]") {
+				sep = ""
+			}
+			replaced[line] = l[:open+1] + strconv.Quote(path) + sep + l[open+1:]
+		default:
+			return "", nil, fmt.Errorf("%s: release %q declares %s in content that are not a YAML list, like a template. Declare them as a list so that the labels can be added to them", KeyCommonLabels, item.name, kustomizeTransformersKey)
+		}
+	}
+
+	result := make([]string, 0, len(lines)+2*len(items))
+	for i, l := range lines {
+		result = append(result, inserts[i]...)
+		if r, ok := replaced[i]; ok {
+			l = r
+		}
+		result = append(result, l)
+	}
+	result = append(result, inserts[len(lines)]...)
+
+	return strings.Join(result, "\n"), written, nil
+}
+
+// writeCommonLabelsTransformer writes the LabelTransformer of the labels into dir and returns its absolute path.
+// The file is named after the hash of the labels, so that a change in the labels changes the generated helmfile,
+// and the releases with the same labels share it.
+func writeCommonLabelsTransformer(dir string, labels map[string]interface{}) (string, error) {
+	transformer, err := commonLabelsTransformer(labels)
+	if err != nil {
+		return "", fmt.Errorf("generating %s transformer: %w", KeyCommonLabels, err)
+	}
+
+	path, err := filepath.Abs(filepath.Join(dir, fmt.Sprintf("common-labels-%x.yaml", sha256.Sum256(transformer))))
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeTempFile(path, transformer, 0644); err != nil {
+		return "", fmt.Errorf("writing %s transformer: %w", KeyCommonLabels, err)
+	}
+
+	return path, nil
+}
+
+// documentCommonLabels returns the keys of the top-level commonLabels of each document of the helmfile content
+// by the index of the document, as separated by ---
+func documentCommonLabels(lines []string) map[int]map[string]bool {
+	declared := map[int]map[string]bool{}
+
+	document := 0
+	inCommonLabels := false
+	childIndent := -1
+
+	for _, l := range lines {
+		l = strings.TrimRight(l, "\r")
+		trimmed := strings.TrimLeft(l, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(l) - len(trimmed)
+
+		if indent == 0 {
+			if isDocumentSeparator(trimmed) {
+				document++
+			}
+			inCommonLabels = strings.TrimRight(trimmed, " \t") == "commonLabels:"
+			childIndent = -1
+			continue
+		}
+
+		if !inCommonLabels {
+			continue
+		}
+
+		if childIndent < 0 {
+			childIndent = indent
+		}
+
+		if j := strings.Index(trimmed, ":"); j > 0 && indent == childIndent {
+			if declared[document] == nil {
+				declared[document] = map[string]bool{}
+			}
+			declared[document][unquoteLabelKey(trimmed[:j])] = true
+		}
+	}
+
+	return declared
+}
+
+// nextContentLine returns the index of the first line from start that is neither empty nor a comment, or -1
+func nextContentLine(lines []string, start int) int {
+	for i := start; i < len(lines); i++ {
+		if trimmed := strings.TrimSpace(lines[i]); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return i
+		}
+	}
+	return -1
+}
+
+func unquoteLabelKey(k string) string {
+	k = strings.TrimSpace(k)
+
+	if uk, err := strconv.Unquote(k); err == nil {
+		return uk
+	}
+
+	return strings.Trim(k, "'")
+}
@@ -0,0 +1,250 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestInjectCommonLabels(t *testing.T) {
+	labels := map[string]interface{}{
+		"terraform.io/resource-address": "helmfile_release_set.mystack",
+		"team":                          "platform",
+	}
+
+	transformer := `apiVersion: builtin
+kind: LabelTransformer
+metadata:
+  name: terraform-common-labels
+labels:
+  team: platform
+  terraform.io/resource-address: helmfile_release_set.mystack
+fieldSpecs:
+- path: metadata/labels
+  create: true
+`
+
+	// %[1]q is the transformer of every label, and %[2]q the one without team, for the releases declaring it
+	tests := []struct {
+		name     string
+		content  string
+		labels   map[string]interface{}
+		expected string
+		files    int
+		wantErr  bool
+	}{
+		{
+			name:     "no labels",
+			content:  "releases:\n- name: myapp\n",
+			expected: "releases:\n- name: myapp\n",
+		},
+		{
+			name: "transformer added to every release",
+			content: `commonLabels:
+  tier: backend
+releases:
+- name: myapp
+  chart: ./charts/myapp
+- name: other
+  chart: ./charts/other
+`,
+			labels: labels,
+			expected: `commonLabels:
+  tier: backend
+releases:
+- name: myapp
+  chart: ./charts/myapp
+  transformers:
+  - %[1]q
+- name: other
+  chart: ./charts/other
+  transformers:
+  - %[1]q
+`,
+			files: 1,
+		},
+		{
+			name:     "release declaring transformers",
+			content:  "releases:\n- name: myapp\n  transformers:\n  - ./labels.yaml\n  chart: ./charts/myapp\n",
+			labels:   labels,
+			expected: "releases:\n- name: myapp\n  transformers:\n  - %[1]q\n  - ./labels.yaml\n  chart: ./charts/myapp\n",
+			files:    1,
+		},
+		{
+			name:     "release declaring indented transformers",
+			content:  "releases:\n- name: myapp\n  transformers: # labels\n    - ./labels.yaml\n",
+			labels:   labels,
+			expected: "releases:\n- name: myapp\n  transformers: # labels\n    - %[1]q\n    - ./labels.yaml\n",
+			files:    1,
+		},
+		{
+			name:     "release declaring transformers in flow style",
+			content:  "releases:\n- name: myapp\n  transformers: [./labels.yaml]\n- name: other\n  transformers: []\n",
+			labels:   labels,
+			expected: "releases:\n- name: myapp\n  transformers: [%[1]q, ./labels.yaml]\n- name: other\n  transformers: [%[1]q]\n",
+			files:    1,
+		},
+		{
+			name:    "release declaring transformers with a template",
+			content: "releases:\n- name: myapp\n  transformers: {{ .Values.transformers | toYaml | nindent 4 }}\n",
+			labels:  labels,
+			wantErr: true,
+		},
+		{
+			name:     "label declared in the commonLabels of content",
+			content:  "commonLabels:\n  team: core\nreleases:\n- name: myapp\n",
+			labels:   labels,
+			expected: "commonLabels:\n  team: core\nreleases:\n- name: myapp\n  transformers:\n  - %[2]q\n",
+			files:    1,
+		},
+		{
+			name:     "label declared by a release",
+			content:  "releases:\n- name: myapp\n  labels:\n    team: core\n- name: other\n",
+			labels:   labels,
+			expected: "releases:\n- name: myapp\n  labels:\n    team: core\n  transformers:\n  - %[2]q\n- name: other\n  transformers:\n  - %[1]q\n",
+			files:    2,
+		},
+		{
+			name:     "release declaring every label",
+			content:  "releases:\n- name: myapp\n  labels:\n    team: core\n    terraform.io/resource-address: elsewhere\n",
+			labels:   labels,
+			expected: "releases:\n- name: myapp\n  labels:\n    team: core\n    terraform.io/resource-address: elsewhere\n",
+		},
+		{
+			name: "releases in multiple documents",
+			content: `commonLabels:
+  team: core
+releases:
+- name: first
+---
+bases:
+- environments.yaml
+---
+releases:
+- name: second
+`,
+			labels: labels,
+			expected: `commonLabels:
+  team: core
+releases:
+- name: first
+  transformers:
+  - %[2]q
+---
+bases:
+- environments.yaml
+---
+releases:
+- name: second
+  transformers:
+  - %[1]q
+`,
+			files: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			got, files, err := injectCommonLabels(tt.content, dir, tt.labels)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(files) != tt.files {
+				t.Fatalf("expected %d transformer files, got %v", tt.files, files)
+			}
+
+			all := transformerPath(t, dir, labels)
+			withoutTeam := transformerPath(t, dir, map[string]interface{}{"terraform.io/resource-address": labels["terraform.io/resource-address"]})
+
+			for _, path := range files {
+				if path != all && path != withoutTeam {
+					t.Errorf("unexpected transformer file %s", path)
+				}
+				if _, err := ioutil.ReadFile(path); err != nil {
+					t.Error(err)
+				}
+			}
+
+			if len(files) > 0 && files[0] == all {
+				bs, _ := ioutil.ReadFile(all)
+				if string(bs) != transformer {
+					t.Errorf("unexpected transformer.\nExpected:\n%s\nGot:\n%s", transformer, string(bs))
+				}
+			}
+
+			expected := tt.expected
+			if strings.Contains(expected, "%[") {
+				expected = fmt.Sprintf(expected, all, withoutTeam)
+			}
+			if got != expected {
+				t.Errorf("injectCommonLabels() mismatch.\nExpected:\n%s\nGot:\n%s", expected, got)
+			}
+
+			// The injection must be deterministic so that the temp helmfile name and the diff stay stable
+			if again, _, _ := injectCommonLabels(tt.content, dir, tt.labels); again != got {
+				t.Errorf("injectCommonLabels() is not deterministic:\n%s\nvs\n%s", got, again)
+			}
+		})
+	}
+}
+
+// transformerPath returns the path the transformer of the labels is written to in dir
+func transformerPath(t *testing.T, dir string, labels map[string]interface{}) string {
+	t.Helper()
+
+	bs, err := commonLabelsTransformer(labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("common-labels-%x.yaml", sha256.Sum256(bs)))
+}
+
+// TestReleaseItems tests that the releases of every document are found, along with their keys and labels
+func TestReleaseItems(t *testing.T) {
+	content := `releases:
+- name: first
+  labels:
+    team: core
+    tier: "backend"
+  values:
+  - labels:
+      ignored: true
+---
+helmDefaults:
+  wait: true
+releases:
+  - name: second
+    chart: ./charts/second
+`
+
+	items := releaseItems(strings.Split(content, "\n"))
+
+	var got []string
+	for _, item := range items {
+		var labels []string
+		for l := range item.labels {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+
+		got = append(got, fmt.Sprintf("%s@%d%v", item.name, item.document, labels))
+	}
+
+	if want := []string{"first@0[team tier]", "second@1[]"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("unexpected release items: got %v, want %v", got, want)
+	}
+}
@@ -2,6 +2,8 @@ package helmfile
 
 import (
 	"fmt"
+	"reflect"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 	"go.uber.org/zap"
@@ -10,19 +12,227 @@ import (
 type ProviderInstance struct {
 	MaxDiffOutputLen int
 	Executor         HelmfileExecutor
+
+	// LibraryExecutor runs the embedded helmfile for the release sets overriding executor to library
+	LibraryExecutor HelmfileExecutor
+
+	// ExecutorName is either ExecutorLibrary or ExecutorBinary, which the release sets use unless they override it
+	ExecutorName string
+
+	// MaxOutputLen is the maximum length of apply_output and template_output
+	MaxOutputLen int
+
+	// ContentSizeWarningBytes and MaxContentSizeBytes are the soft and hard limits on the size of content and values
+	ContentSizeWarningBytes int
+	MaxContentSizeBytes     int
+
+	// DefaultSelectors is the list of label selectors that are ANDed into every resource's selectors
+	DefaultSelectors []string
+
+	// MetricsFile is the path to the node_exporter textfile to which the metrics of each operation are written
+	MetricsFile string
+
+	// EKSClusterCache reuses the DescribeCluster of eks_cluster_name across the resources
+	EKSClusterCache *EKSClusterCache
+
+	// StaleKubeconfigMaxAge is the age after which the temporary kubeconfigs of crashed runs are removed
+	StaleKubeconfigMaxAge time.Duration
+
+	// DefaultConcurrency is the concurrency of the release sets that leave it at 0
+	DefaultConcurrency int
+
+	// Operations limits how many helmfile operations run at once across the resources. It is nil when unlimited.
+	Operations *operationLimiter
+
+	// ConfigHash is the fingerprint of the provider config, recorded in provider_config_hash
+	// so that a change in the provider config is detected as a change of the resources
+	ConfigHash string
 }
 
-func New(d *schema.ResourceData) *ProviderInstance {
-	// Always use library executor
-	logger, err := zap.NewDevelopment()
+// providerConfig is the configuration of the provider block. It is fingerprinted as a whole into
+// provider_config_hash, so that an attribute added to the provider is never left out of the hash.
+type providerConfig struct {
+	MaxDiffOutputLen        int
+	MaxOutputLen            int
+	ContentSizeWarningBytes int
+	MaxContentSizeBytes     int
+	DefaultSelectors        []string
+	MetricsFile             string
+	Executor                string
+	EKSClusterCacheTTL      time.Duration
+	StaleKubeconfigMaxAge   time.Duration
+	DefaultConcurrency      int
+	MaxParallelOperations   int
+}
+
+// defaultProviderConfig is the configuration of a provider block leaving every attribute at its default
+var defaultProviderConfig = providerConfig{
+	MaxDiffOutputLen:        DefaultMaxDiffOutputLen,
+	MaxOutputLen:            DefaultMaxOutputLen,
+	ContentSizeWarningBytes: DefaultContentSizeWarningBytes,
+	MaxContentSizeBytes:     DefaultMaxContentSizeBytes,
+	Executor:                ExecutorLibrary,
+	EKSClusterCacheTTL:      DefaultEKSClusterCacheTTL,
+	StaleKubeconfigMaxAge:   DefaultStaleKubeconfigMaxAge,
+}
+
+// readProviderConfig reads the provider block, failing on a duration that doesn't parse
+func readProviderConfig(d *schema.ResourceData) (providerConfig, error) {
+	c := providerConfig{
+		MaxDiffOutputLen:        d.Get(KeyMaxDiffOutputLen).(int),
+		MaxOutputLen:            d.Get(KeyMaxOutputLen).(int),
+		ContentSizeWarningBytes: d.Get(KeyContentSizeWarningBytes).(int),
+		MaxContentSizeBytes:     d.Get(KeyMaxContentSizeBytes).(int),
+		MetricsFile:             d.Get(KeyMetricsFile).(string),
+		Executor:                d.Get(KeyExecutor).(string),
+		DefaultConcurrency:      d.Get(KeyDefaultConcurrency).(int),
+		MaxParallelOperations:   d.Get(KeyMaxParallelOperations).(int),
+	}
+
+	if c.Executor == "" {
+		c.Executor = ExecutorLibrary
+	}
+
+	for _, s := range d.Get(KeyDefaultSelectors).([]interface{}) {
+		c.DefaultSelectors = append(c.DefaultSelectors, s.(string))
+	}
+
+	var err error
+
+	if c.EKSClusterCacheTTL, err = durationOrDefault(d, KeyEKSClusterCacheTTL, DefaultEKSClusterCacheTTL); err != nil {
+		return c, err
+	}
+
+	if c.StaleKubeconfigMaxAge, err = durationOrDefault(d, KeyStaleKubeconfigMaxAge, DefaultStaleKubeconfigMaxAge); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+func New(d *schema.ResourceData) (*ProviderInstance, error) {
+	// The commands run by the eksctl SDK are logged with their output, which can echo the sensitive values
+	redactLogOutput()
+
+	c, err := readProviderConfig(d)
 	if err != nil {
-		// This should rarely fail, but log it if it does
-		logf("Failed to create logger for library executor: %v", err)
-		panic(fmt.Sprintf("Failed to create logger: %v", err))
+		return nil, err
+	}
+
+	executor, err := newExecutor(c.Executor, "helmfile")
+	if err != nil {
+		return nil, err
+	}
+
+	// The release sets overriding executor to library still run the embedded helmfile
+	library := executor
+	if c.Executor != ExecutorLibrary {
+		if library, err = newExecutor(ExecutorLibrary, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	configHash, err := providerConfigHash(c)
+	if err != nil {
+		return nil, fmt.Errorf("computing %s: %w", KeyProviderConfigHash, err)
 	}
 
 	return &ProviderInstance{
-		MaxDiffOutputLen: d.Get(KeyMaxDiffOutputLen).(int),
-		Executor:         NewLibraryExecutor(logger.Sugar()),
+		MaxDiffOutputLen:        c.MaxDiffOutputLen,
+		MaxOutputLen:            c.MaxOutputLen,
+		ContentSizeWarningBytes: c.ContentSizeWarningBytes,
+		MaxContentSizeBytes:     c.MaxContentSizeBytes,
+		Executor:                executor,
+		LibraryExecutor:         library,
+		ExecutorName:            c.Executor,
+		DefaultSelectors:        c.DefaultSelectors,
+		MetricsFile:             c.MetricsFile,
+		EKSClusterCache:         NewEKSClusterCache(c.EKSClusterCacheTTL),
+		StaleKubeconfigMaxAge:   c.StaleKubeconfigMaxAge,
+		DefaultConcurrency:      c.DefaultConcurrency,
+		Operations:              newOperationLimiter(c.MaxParallelOperations),
+		ConfigHash:              configHash,
+	}, nil
+}
+
+// durationOrDefault parses the duration attribute of the provider, which is the default when it is not set
+func durationOrDefault(d *schema.ResourceData, key string, def time.Duration) (time.Duration, error) {
+	s, _ := d.Get(key).(string)
+	if s == "" {
+		return def, nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", key, err)
+	}
+
+	return parsed, nil
+}
+
+// newExecutor returns the executor of the name. The binary executor runs bin unless the options specify HelmfileBinary.
+func newExecutor(name, bin string) (HelmfileExecutor, error) {
+	if name == ExecutorBinary {
+		return NewBinaryExecutor(bin), nil
 	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		return nil, fmt.Errorf("creating logger for library executor: %w", err)
+	}
+
+	return NewLibraryExecutor(logger.Sugar()), nil
+}
+
+// executorFor returns the executor of the release set, which is the provider's unless the resource overrides it.
+// The binary executor runs the helmfile binary of the release set, or the helmfile and helm versions it pins.
+// Either is limited by max_parallel_operations.
+func (p *ProviderInstance) executorFor(fs *ReleaseSet) HelmfileExecutor {
+	name := fs.Executor
+	if name == "" {
+		name = p.ExecutorName
+	}
+
+	executor := p.Executor
+
+	switch {
+	case name == ExecutorBinary:
+		executor = newReleaseSetBinaryExecutor(fs)
+	case p.ExecutorName == ExecutorBinary:
+		executor = p.LibraryExecutor
+	}
+
+	// The lock is taken before a slot of max_parallel_operations, so that waiting for the lock never holds a slot
+	return lockExecutor(limitExecutor(executor, p.Operations), fs)
+}
+
+// applyDefaults sets provider-level defaults to the release set unless the resource opted out of them
+func (p *ProviderInstance) applyDefaults(fs *ReleaseSet) {
+	fs.MaxDiffOutputLen = p.MaxDiffOutputLen
+	fs.MaxOutputLen = p.MaxOutputLen
+	fs.DefaultConcurrency = p.DefaultConcurrency
+	fs.Operations = p.Operations
+
+	if !fs.IgnoreDefaultSelectors {
+		fs.DefaultSelectors = p.DefaultSelectors
+	}
+}
+
+// providerConfigHash returns the hash of the provider config. It is empty while every attribute is at its default,
+// so that upgrading the provider doesn't show a change in every resource.
+func providerConfigHash(c providerConfig) (string, error) {
+	if reflect.DeepEqual(c, defaultProviderConfig) {
+		return "", nil
+	}
+
+	return HashObject(c)
+}
+
+// setProviderConfigHash records the provider config fingerprint to the resource
+func setProviderConfigHash(d ResourceReadWrite, p *ProviderInstance) error {
+	if err := d.Set(KeyProviderConfigHash, p.ConfigHash); err != nil {
+		return fmt.Errorf("setting %s: %w", KeyProviderConfigHash, err)
+	}
+
+	return nil
 }
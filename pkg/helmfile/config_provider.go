@@ -1,6 +1,8 @@
 package helmfile
 
 import (
+	"strings"
+
 	"go.uber.org/zap"
 )
 
@@ -16,11 +18,26 @@ type baseConfigProvider struct {
 	selectors            []interface{}
 	valuesFiles          []interface{}
 	values               []interface{}
+	stateValuesSet       map[string]interface{}
 	environmentVariables map[string]interface{}
 	kubeconfig           string
+	skipDeps             bool
+	skipNeeds            bool
+	includeNeeds         bool
+	includeTransitive    bool
+	extraArgs            []string
 	logger               *zap.SugaredLogger
 }
 
+// withStateValues layers the state values over the state values set, as the helmfile binary does with --state-values-set
+func withStateValues(stateValuesSet, stateValues map[string]interface{}) map[string]interface{} {
+	if len(stateValues) == 0 {
+		return stateValuesSet
+	}
+
+	return mergeValues(mergeValues(map[string]interface{}{}, stateValuesSet), typedStateValues(stateValues))
+}
+
 func newBaseConfigProvider(opts BaseOptions, logger *zap.SugaredLogger) *baseConfigProvider {
 	return &baseConfigProvider{
 		fileOrDir:            opts.FileOrDir,
@@ -32,14 +49,22 @@ func newBaseConfigProvider(opts BaseOptions, logger *zap.SugaredLogger) *baseCon
 		selectors:            opts.Selectors,
 		valuesFiles:          opts.ValuesFiles,
 		values:               opts.Values,
+		stateValuesSet:       withStateValues(opts.StateValuesSet, opts.StateValues),
 		environmentVariables: opts.EnvironmentVariables,
 		kubeconfig:           opts.Kubeconfig,
+		skipDeps:             opts.SkipDeps,
+		skipNeeds:            opts.SkipNeeds,
+		includeNeeds:         opts.IncludeNeeds,
+		includeTransitive:    opts.IncludeTransitiveNeeds,
+		extraArgs:            opts.ExtraArgs,
 		logger:               logger,
 	}
 }
 
-// Implement app.ConfigProvider interface
-func (c *baseConfigProvider) Args() string                       { return "" }
+// Implement app.ConfigProvider interface.
+// EnableLiveOutput is false even with LiveOutput, as helmfile would then write the output of helm to os.Stdout past
+// the capture, which forwards the output to the debug log by itself.
+func (c *baseConfigProvider) Args() string                       { return strings.Join(c.extraArgs, " ") }
 func (c *baseConfigProvider) ConfigFile() string                 { return "" }
 func (c *baseConfigProvider) HelmBinary() string                 { return c.helmBinary }
 func (c *baseConfigProvider) KustomizeBinary() string            { return "" }
@@ -49,16 +74,16 @@ func (c *baseConfigProvider) KubeContext() string                { return c.kube
 func (c *baseConfigProvider) Namespace() string                  { return c.namespace }
 func (c *baseConfigProvider) Chart() string                      { return "" }
 func (c *baseConfigProvider) Selectors() []string                { return convertSelectorsToStrings(c.selectors) }
-func (c *baseConfigProvider) StateValuesSet() map[string]any     { return nil }
+func (c *baseConfigProvider) StateValuesSet() map[string]any     { return c.stateValuesSet }
 func (c *baseConfigProvider) StateValuesFiles() []string         { return convertToStringSlice(c.valuesFiles) }
 func (c *baseConfigProvider) Environment() string                { return c.environment }
 func (c *baseConfigProvider) Logger() *zap.SugaredLogger         { return c.logger }
 func (c *baseConfigProvider) Validate() bool                     { return false }
 func (c *baseConfigProvider) EmbedValues() bool                  { return false }
-func (c *baseConfigProvider) IncludeTransitiveNeeds() bool       { return false }
-func (c *baseConfigProvider) IncludeNeeds() bool                 { return false }
+func (c *baseConfigProvider) IncludeTransitiveNeeds() bool       { return c.includeTransitive }
+func (c *baseConfigProvider) IncludeNeeds() bool                 { return c.includeNeeds || c.includeTransitive }
 func (c *baseConfigProvider) Interactive() bool                  { return false }
-func (c *baseConfigProvider) SkipDeps() bool                     { return false }
+func (c *baseConfigProvider) SkipDeps() bool                     { return c.skipDeps }
 func (c *baseConfigProvider) IncludeCRDs() bool                  { return true }
 func (c *baseConfigProvider) DisableForceUpdate() bool           { return false }
 func (c *baseConfigProvider) Env() string                        { return c.environment }
@@ -73,47 +98,60 @@ func (c *baseConfigProvider) SequentialHelmfiles() bool          { return false
 type applyConfigProvider struct {
 	*baseConfigProvider
 	concurrency       int
+	set               []string
+	helmValuesFiles   []string
 	suppressSecrets   bool
 	skipDiffOnInstall bool
+	skipSchemaValidation bool
+	skipCRDs          bool
+	noHooks           bool
+	reuseValues       bool
+	resetValues       bool
+	diffArgs          string
+	syncArgs          string
+	wait              bool
+	waitForJobs       bool
+	context           int
+	suppressOutputLineRegex []string
 }
 
 // Implement additional methods for ApplyConfigProvider
 func (c *applyConfigProvider) Concurrency() int          { return c.concurrency }
-func (c *applyConfigProvider) Values() []string          { return convertToStringSlice(c.values) }
-func (c *applyConfigProvider) Set() []string             { return nil }
+func (c *applyConfigProvider) Values() []string          { return append(convertToStringSlice(c.values), c.helmValuesFiles...) }
+func (c *applyConfigProvider) Set() []string             { return c.set }
 func (c *applyConfigProvider) OutputDir() string         { return "" }
 func (c *applyConfigProvider) OutputDirTemplate() string { return "" }
 func (c *applyConfigProvider) OutputFileTemplate() string{ return "" }
 func (c *applyConfigProvider) ShowOnly() []string        { return nil }
 func (c *applyConfigProvider) KubeVersion() string       { return "" }
-func (c *applyConfigProvider) NoHooks() bool             { return false }
+func (c *applyConfigProvider) NoHooks() bool             { return c.noHooks }
 func (c *applyConfigProvider) SkipTests() bool           { return false }
 func (c *applyConfigProvider) SkipCleanup() bool         { return false }
-func (c *applyConfigProvider) SkipNeeds() bool           { return false }
+func (c *applyConfigProvider) SkipNeeds() bool           { return c.skipNeeds && !c.IncludeNeeds() }
 func (c *applyConfigProvider) PostRenderer() string      { return "" }
 func (c *applyConfigProvider) PostRendererArgs() []string{ return nil }
-func (c *applyConfigProvider) Wait() bool                { return false }
-func (c *applyConfigProvider) WaitForJobs() bool         { return false }
+func (c *applyConfigProvider) Wait() bool                { return c.wait }
+func (c *applyConfigProvider) WaitForJobs() bool         { return c.waitForJobs }
 func (c *applyConfigProvider) SuppressSecrets() bool     { return c.suppressSecrets }
 func (c *applyConfigProvider) SuppressDiff() bool        { return false }
 func (c *applyConfigProvider) Suppress() []string        { return nil }
 func (c *applyConfigProvider) ShowSecrets() bool         { return !c.suppressSecrets }
-func (c *applyConfigProvider) Context() int              { return 3 }
+func (c *applyConfigProvider) Context() int              { return c.context }
 func (c *applyConfigProvider) DiffOutput() string        { return "" }
 func (c *applyConfigProvider) DetailedExitcode() bool    { return false }
 func (c *applyConfigProvider) Color() bool               { return false }
 func (c *applyConfigProvider) NoColor() bool             { return true }
 func (c *applyConfigProvider) Cascade() string           { return "" }
-func (c *applyConfigProvider) DiffArgs() string          { return "" }
+func (c *applyConfigProvider) DiffArgs() string          { return c.diffArgs }
 func (c *applyConfigProvider) IncludeTests() bool        { return false }
-func (c *applyConfigProvider) ResetValues() bool         { return false }
-func (c *applyConfigProvider) ReuseValues() bool         { return false }
-func (c *applyConfigProvider) SkipCRDs() bool            { return false }
+func (c *applyConfigProvider) ResetValues() bool         { return c.resetValues }
+func (c *applyConfigProvider) ReuseValues() bool         { return c.reuseValues }
+func (c *applyConfigProvider) SkipCRDs() bool            { return c.skipCRDs }
 func (c *applyConfigProvider) SkipDiffOnInstall() bool   { return c.skipDiffOnInstall }
 func (c *applyConfigProvider) StripTrailingCR() bool     { return false }
-func (c *applyConfigProvider) SuppressOutputLineRegex() []string { return nil }
-func (c *applyConfigProvider) SyncArgs() string          { return "" }
-func (c *applyConfigProvider) SkipSchemaValidation() bool { return false }
+func (c *applyConfigProvider) SuppressOutputLineRegex() []string { return c.suppressOutputLineRegex }
+func (c *applyConfigProvider) SyncArgs() string          { return syncArgs(c.noHooks, c.syncArgs) }
+func (c *applyConfigProvider) SkipSchemaValidation() bool { return c.skipSchemaValidation }
 func (c *applyConfigProvider) HideNotes() bool           { return false }
 func (c *applyConfigProvider) TakeOwnership() bool       { return false }
 func (c *applyConfigProvider) WaitRetries() int          { return 0 }
@@ -123,18 +161,66 @@ func (c *applyConfigProvider) TrackTimeout() int         { return 0 }
 func (c *applyConfigProvider) TrackLogs() bool           { return false }
 func (c *applyConfigProvider) EnforceNeedsAreInstalled() bool { return false }
 
+// syncConfigProvider implements app.SyncConfigProvider
+type syncConfigProvider struct {
+	*baseConfigProvider
+	concurrency          int
+	set                  []string
+	helmValuesFiles      []string
+	skipSchemaValidation bool
+	skipCRDs             bool
+	noHooks              bool
+	reuseValues          bool
+	resetValues          bool
+	syncArgs             string
+	wait                 bool
+	waitForJobs          bool
+}
+
+func (c *syncConfigProvider) Concurrency() int               { return c.concurrency }
+func (c *syncConfigProvider) Values() []string               { return append(convertToStringSlice(c.values), c.helmValuesFiles...) }
+func (c *syncConfigProvider) Set() []string                  { return c.set }
+func (c *syncConfigProvider) PostRenderer() string           { return "" }
+func (c *syncConfigProvider) PostRendererArgs() []string     { return nil }
+func (c *syncConfigProvider) SkipSchemaValidation() bool     { return c.skipSchemaValidation }
+func (c *syncConfigProvider) HideNotes() bool                { return false }
+func (c *syncConfigProvider) TakeOwnership() bool            { return false }
+func (c *syncConfigProvider) Cascade() string                { return "" }
+func (c *syncConfigProvider) SkipCRDs() bool                 { return c.skipCRDs }
+func (c *syncConfigProvider) Wait() bool                     { return c.wait }
+func (c *syncConfigProvider) WaitRetries() int               { return 0 }
+func (c *syncConfigProvider) WaitForJobs() bool              { return c.waitForJobs }
+func (c *syncConfigProvider) SyncArgs() string               { return syncArgs(c.noHooks, c.syncArgs) }
+func (c *syncConfigProvider) SkipNeeds() bool                { return c.skipNeeds && !c.IncludeNeeds() }
+func (c *syncConfigProvider) SyncReleaseLabels() bool        { return false }
+func (c *syncConfigProvider) TrackMode() string              { return "" }
+func (c *syncConfigProvider) TrackTimeout() int              { return 0 }
+func (c *syncConfigProvider) TrackLogs() bool                { return false }
+func (c *syncConfigProvider) EnforceNeedsAreInstalled() bool { return false }
+func (c *syncConfigProvider) ResetValues() bool              { return c.resetValues }
+func (c *syncConfigProvider) ReuseValues() bool              { return c.reuseValues }
+
 // diffConfigProvider implements app.DiffConfigProvider
 type diffConfigProvider struct {
 	*baseConfigProvider
 	concurrency      int
+	set              []string
+	helmValuesFiles  []string
 	detailedExitcode bool
 	suppressSecrets  bool
 	context          int
+	skipSchemaValidation bool
+	skipCRDs         bool
+	noHooks          bool
+	reuseValues      bool
+	resetValues      bool
+	suppressOutputLineRegex []string
+	diffArgs         string
 }
 
 func (c *diffConfigProvider) Concurrency() int           { return c.concurrency }
-func (c *diffConfigProvider) Values() []string           { return convertToStringSlice(c.values) }
-func (c *diffConfigProvider) Set() []string              { return nil }
+func (c *diffConfigProvider) Values() []string           { return append(convertToStringSlice(c.values), c.helmValuesFiles...) }
+func (c *diffConfigProvider) Set() []string              { return c.set }
 func (c *diffConfigProvider) DetailedExitcode() bool     { return c.detailedExitcode }
 func (c *diffConfigProvider) SuppressSecrets() bool      { return c.suppressSecrets }
 func (c *diffConfigProvider) Context() int               { return c.context }
@@ -147,33 +233,39 @@ func (c *diffConfigProvider) OutputDirTemplate() string  { return "" }
 func (c *diffConfigProvider) OutputFileTemplate() string { return "" }
 func (c *diffConfigProvider) ShowOnly() []string         { return nil }
 func (c *diffConfigProvider) KubeVersion() string        { return "" }
-func (c *diffConfigProvider) NoHooks() bool              { return false }
+func (c *diffConfigProvider) NoHooks() bool              { return c.noHooks }
 func (c *diffConfigProvider) SkipTests() bool            { return false }
 func (c *diffConfigProvider) SkipCleanup() bool          { return false }
-func (c *diffConfigProvider) SkipNeeds() bool            { return false }
+func (c *diffConfigProvider) SkipNeeds() bool            { return c.skipNeeds && !c.IncludeNeeds() }
 func (c *diffConfigProvider) PostRenderer() string       { return "" }
 func (c *diffConfigProvider) PostRendererArgs() []string { return nil }
-func (c *diffConfigProvider) DiffArgs() string           { return "" }
+func (c *diffConfigProvider) DiffArgs() string           { return c.diffArgs }
 func (c *diffConfigProvider) DiffOutput() string         { return "" }
 func (c *diffConfigProvider) IncludeTests() bool         { return false }
-func (c *diffConfigProvider) ResetValues() bool          { return false }
-func (c *diffConfigProvider) ReuseValues() bool          { return false }
-func (c *diffConfigProvider) SkipCRDs() bool             { return false }
+func (c *diffConfigProvider) ResetValues() bool          { return c.resetValues }
+func (c *diffConfigProvider) ReuseValues() bool          { return c.reuseValues }
+func (c *diffConfigProvider) SkipCRDs() bool             { return c.skipCRDs }
 func (c *diffConfigProvider) SkipDiffOnInstall() bool    { return false }
 func (c *diffConfigProvider) StripTrailingCR() bool      { return false }
 func (c *diffConfigProvider) SuppressDiff() bool         { return false }
-func (c *diffConfigProvider) SuppressOutputLineRegex() []string { return nil }
-func (c *diffConfigProvider) SkipSchemaValidation() bool  { return false }
+func (c *diffConfigProvider) SuppressOutputLineRegex() []string { return c.suppressOutputLineRegex }
+func (c *diffConfigProvider) SkipSchemaValidation() bool  { return c.skipSchemaValidation }
 func (c *diffConfigProvider) TakeOwnership() bool         { return false }
 func (c *diffConfigProvider) EnforceNeedsAreInstalled() bool { return false }
 
 // templateConfigProvider implements app.TemplateConfigProvider
 type templateConfigProvider struct {
 	*baseConfigProvider
-	concurrency       int
-	includeCRDs       bool
-	outputDir         string
-	outputDirTemplate string
+	concurrency        int
+	includeCRDs        bool
+	outputDir          string
+	outputDirTemplate  string
+	outputFileTemplate string
+	skipSchemaValidation bool
+	noHooks            bool
+	skipTests          bool
+	kubeVersion        string
+	args               string
 }
 
 func (c *templateConfigProvider) Concurrency() int            { return c.concurrency }
@@ -181,35 +273,91 @@ func (c *templateConfigProvider) Values() []string            { return convertTo
 func (c *templateConfigProvider) Set() []string               { return nil }
 func (c *templateConfigProvider) OutputDir() string           { return c.outputDir }
 func (c *templateConfigProvider) OutputDirTemplate() string   { return c.outputDirTemplate }
-func (c *templateConfigProvider) OutputFileTemplate() string  { return "" }
+func (c *templateConfigProvider) OutputFileTemplate() string  { return c.outputFileTemplate }
 func (c *templateConfigProvider) ShowOnly() []string          { return nil }
-func (c *templateConfigProvider) KubeVersion() string         { return "" }
-func (c *templateConfigProvider) NoHooks() bool               { return false }
-func (c *templateConfigProvider) SkipTests() bool             { return false }
+func (c *templateConfigProvider) KubeVersion() string         { return c.kubeVersion }
+func (c *templateConfigProvider) NoHooks() bool               { return c.noHooks }
+func (c *templateConfigProvider) SkipTests() bool             { return c.skipTests }
 func (c *templateConfigProvider) SkipCleanup() bool           { return false }
-func (c *templateConfigProvider) SkipNeeds() bool             { return false }
+func (c *templateConfigProvider) SkipNeeds() bool             { return c.skipNeeds && !c.IncludeNeeds() }
 func (c *templateConfigProvider) PostRenderer() string        { return "" }
 func (c *templateConfigProvider) PostRendererArgs() []string  { return nil }
 
+// Override Args for template to pass the API versions to helm template along with extra_args
+func (c *templateConfigProvider) Args() string {
+	return strings.TrimSpace(c.baseConfigProvider.Args() + " " + c.args)
+}
+
 // Override IncludeCRDs for template
 func (c *templateConfigProvider) IncludeCRDs() bool          { return c.includeCRDs }
-func (c *templateConfigProvider) SkipSchemaValidation() bool  { return false }
+func (c *templateConfigProvider) SkipSchemaValidation() bool  { return c.skipSchemaValidation }
 func (c *templateConfigProvider) EnforceNeedsAreInstalled() bool { return false }
 
 // destroyConfigProvider implements app.DestroyConfigProvider
 type destroyConfigProvider struct {
 	*baseConfigProvider
-	concurrency int
+	concurrency   int
+	cascade       string
+	deleteWait    bool
+	deleteTimeout int
 }
 
 func (c *destroyConfigProvider) Concurrency() int  { return c.concurrency }
-func (c *destroyConfigProvider) Cascade() string    { return "" }
-func (c *destroyConfigProvider) DeleteTimeout() int { return 0 }
-func (c *destroyConfigProvider) DeleteWait() bool   { return false }
+func (c *destroyConfigProvider) Cascade() string    { return c.cascade }
+func (c *destroyConfigProvider) DeleteTimeout() int { return c.deleteTimeout }
+func (c *destroyConfigProvider) DeleteWait() bool   { return c.deleteWait }
 func (c *destroyConfigProvider) SkipCharts() bool   { return false }
-func (c *destroyConfigProvider) Args() string       { return "" }
+
+// lintConfigProvider implements app.LintConfigProvider
+type lintConfigProvider struct {
+	*baseConfigProvider
+	concurrency int
+}
+
+func (c *lintConfigProvider) Concurrency() int               { return c.concurrency }
+func (c *lintConfigProvider) Values() []string               { return convertToStringSlice(c.values) }
+func (c *lintConfigProvider) Set() []string                  { return nil }
+func (c *lintConfigProvider) SkipCleanup() bool              { return false }
+func (c *lintConfigProvider) SkipNeeds() bool                { return c.skipNeeds && !c.IncludeNeeds() }
+func (c *lintConfigProvider) EnforceNeedsAreInstalled() bool { return false }
+
+// printEnvConfigProvider implements app.PrintEnvConfigProvider
+type printEnvConfigProvider struct {
+	*baseConfigProvider
+}
+
+func (c *printEnvConfigProvider) Output() string { return "yaml" }
+
+// listConfigProvider implements app.ListConfigProvider
+type listConfigProvider struct {
+	*baseConfigProvider
+}
+
+func (c *listConfigProvider) Output() string   { return "json" }
+func (c *listConfigProvider) SkipCharts() bool { return true }
 
 // Helper functions
+
+// syncArgs returns the args of helm upgrade for the apply and the sync, which disable the hooks with no_hooks
+// along with sync_args
+func syncArgs(noHooks bool, args string) string {
+	if noHooks {
+		return joinArgs(noHooksSyncArgs, args)
+	}
+	return args
+}
+
+// joinArgs joins the non-empty args into the space-separated args that helmfile splits before passing them to helm
+func joinArgs(args ...string) string {
+	var nonEmpty []string
+	for _, a := range args {
+		if a != "" {
+			nonEmpty = append(nonEmpty, a)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
+
 func convertToStringSlice(items []interface{}) []string {
 	result := make([]string, 0, len(items))
 	for _, item := range items {
@@ -15,6 +15,10 @@ var (
 	_ app.DiffConfigProvider      = (*diffConfigProvider)(nil)
 	_ app.DestroyConfigProvider   = (*destroyConfigProvider)(nil)
 	_ app.TemplateConfigProvider  = (*templateConfigProvider)(nil)
+	_ app.PrintEnvConfigProvider  = (*printEnvConfigProvider)(nil)
+	_ app.ListConfigProvider      = (*listConfigProvider)(nil)
+	_ app.LintConfigProvider      = (*lintConfigProvider)(nil)
+	_ app.SyncConfigProvider      = (*syncConfigProvider)(nil)
 )
 
 func TestConfigProviderInterfaces(t *testing.T) {
@@ -24,6 +28,7 @@ func TestConfigProviderInterfaces(t *testing.T) {
 		FileOrDir:   "/tmp/helmfile.yaml",
 		HelmBinary:  "helm",
 		Environment: "default",
+		KubeContext: "my-ctx",
 	}, logger)
 
 	t.Run("baseConfigProvider satisfies ConfigProvider", func(t *testing.T) {
@@ -36,6 +41,10 @@ func TestConfigProviderInterfaces(t *testing.T) {
 		if base.Env() != "default" {
 			t.Errorf("expected default, got %s", base.Env())
 		}
+		// helmfile passes the kube context to helm as --kube-context
+		if base.KubeContext() != "my-ctx" {
+			t.Errorf("expected my-ctx, got %s", base.KubeContext())
+		}
 		// New v1.x methods should return safe defaults
 		if base.EnforcePluginVerification() {
 			t.Error("expected EnforcePluginVerification to be false")
@@ -55,18 +64,33 @@ func TestConfigProviderInterfaces(t *testing.T) {
 		cfg := &applyConfigProvider{
 			baseConfigProvider: base,
 			concurrency:        2,
+			set:                []string{"image.tag=1.19"},
+			helmValuesFiles:    []string{"/tmp/releases-values.yaml"},
 			suppressSecrets:    true,
 			skipDiffOnInstall:  true,
 		}
 		if cfg.Concurrency() != 2 {
 			t.Errorf("expected 2, got %d", cfg.Concurrency())
 		}
-		if !cfg.SuppressSecrets() {
-			t.Error("expected SuppressSecrets to be true")
+		if len(cfg.Set()) != 1 || cfg.Set()[0] != "image.tag=1.19" {
+			t.Errorf("expected releases_values in Set, got %v", cfg.Set())
+		}
+		if len(cfg.Values()) != 1 || cfg.Values()[0] != "/tmp/releases-values.yaml" {
+			t.Errorf("expected releases_values_string file in Values, got %v", cfg.Values())
+		}
+		if !cfg.SuppressSecrets() || cfg.ShowSecrets() {
+			t.Error("expected SuppressSecrets to be true and ShowSecrets to be false")
 		}
 		if !cfg.SkipDiffOnInstall() {
 			t.Error("expected SkipDiffOnInstall to be true")
 		}
+		if cfg.Wait() || cfg.WaitForJobs() {
+			t.Error("expected Wait and WaitForJobs to be false by default")
+		}
+		cfg.wait, cfg.waitForJobs = true, true
+		if !cfg.Wait() || !cfg.WaitForJobs() {
+			t.Error("expected Wait and WaitForJobs to be true")
+		}
 		// New v1.x methods
 		if cfg.SkipSchemaValidation() {
 			t.Error("expected SkipSchemaValidation to be false")
@@ -117,6 +141,7 @@ func TestConfigProviderInterfaces(t *testing.T) {
 			baseConfigProvider: base,
 			includeCRDs:        true,
 			outputDir:          "/tmp/out",
+			outputFileTemplate: "{{ .Release.Name }}.yaml",
 		}
 		if !cfg.IncludeCRDs() {
 			t.Error("expected IncludeCRDs to be true")
@@ -124,8 +149,98 @@ func TestConfigProviderInterfaces(t *testing.T) {
 		if cfg.OutputDir() != "/tmp/out" {
 			t.Errorf("expected /tmp/out, got %s", cfg.OutputDir())
 		}
+		if cfg.OutputFileTemplate() != "{{ .Release.Name }}.yaml" {
+			t.Errorf("expected output file template, got %s", cfg.OutputFileTemplate())
+		}
 		if cfg.SkipSchemaValidation() {
 			t.Error("expected SkipSchemaValidation to be false")
 		}
 	})
 }
+
+func TestHooksConfigProviders(t *testing.T) {
+	base := newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml"}, zap.NewNop().Sugar())
+
+	t.Run("apply", func(t *testing.T) {
+		cfg := &applyConfigProvider{baseConfigProvider: base}
+		if cfg.NoHooks() || cfg.SyncArgs() != "" {
+			t.Errorf("expected the hooks to be run and diffed by default, got NoHooks %v and SyncArgs %q", cfg.NoHooks(), cfg.SyncArgs())
+		}
+
+		cfg.noHooks = true
+		if !cfg.NoHooks() {
+			t.Error("expected NoHooks to be true so that helm-diff leaves out the hooks")
+		}
+		if cfg.SyncArgs() != "--no-hooks" {
+			t.Errorf("expected helm upgrade to run with --no-hooks, got SyncArgs %q", cfg.SyncArgs())
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		cfg := &syncConfigProvider{baseConfigProvider: base, noHooks: true}
+		if cfg.SyncArgs() != "--no-hooks" {
+			t.Errorf("expected helm upgrade to run with --no-hooks, got SyncArgs %q", cfg.SyncArgs())
+		}
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		cfg := &diffConfigProvider{baseConfigProvider: base, noHooks: true}
+		if !cfg.NoHooks() {
+			t.Error("expected NoHooks to be true")
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		cfg := &templateConfigProvider{baseConfigProvider: base}
+		if cfg.NoHooks() || cfg.SkipTests() {
+			t.Error("expected the hooks and the tests to be rendered by default")
+		}
+
+		cfg.noHooks, cfg.skipTests = true, true
+		if !cfg.NoHooks() || !cfg.SkipTests() {
+			t.Error("expected NoHooks and SkipTests to be true")
+		}
+	})
+}
+
+func TestSkipDepsAndNeedsConfigProviders(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		base := newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml", SkipDeps: skip, SkipNeeds: skip}, zap.NewNop().Sugar())
+
+		if base.SkipDeps() != skip {
+			t.Errorf("expected SkipDeps %v, got %v", skip, base.SkipDeps())
+		}
+
+		providers := map[string]interface{ SkipNeeds() bool }{
+			"apply":    &applyConfigProvider{baseConfigProvider: base},
+			"sync":     &syncConfigProvider{baseConfigProvider: base},
+			"diff":     &diffConfigProvider{baseConfigProvider: base},
+			"template": &templateConfigProvider{baseConfigProvider: base},
+			"lint":     &lintConfigProvider{baseConfigProvider: base},
+		}
+		for name, p := range providers {
+			if p.SkipNeeds() != skip {
+				t.Errorf("expected SkipNeeds %v for %s, got %v", skip, name, p.SkipNeeds())
+			}
+		}
+	}
+}
+
+func TestIncludeNeedsConfigProviders(t *testing.T) {
+	base := newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml", SkipNeeds: true, IncludeNeeds: true}, zap.NewNop().Sugar())
+
+	if !base.IncludeNeeds() || base.IncludeTransitiveNeeds() {
+		t.Errorf("expected IncludeNeeds without IncludeTransitiveNeeds, got %v and %v", base.IncludeNeeds(), base.IncludeTransitiveNeeds())
+	}
+
+	apply := &applyConfigProvider{baseConfigProvider: base}
+	if apply.SkipNeeds() {
+		t.Error("expected include_needs to take precedence over skip_needs")
+	}
+
+	base = newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml", IncludeTransitiveNeeds: true}, zap.NewNop().Sugar())
+
+	if !base.IncludeNeeds() || !base.IncludeTransitiveNeeds() {
+		t.Errorf("expected include_transitive_needs to imply include_needs, got %v and %v", base.IncludeNeeds(), base.IncludeTransitiveNeeds())
+	}
+}
@@ -0,0 +1,215 @@
+package helmfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/helmfile/helmfile/pkg/environment"
+	"github.com/helmfile/helmfile/pkg/filesystem"
+	"github.com/helmfile/helmfile/pkg/state"
+	"github.com/helmfile/helmfile/pkg/tmpl"
+	"gopkg.in/yaml.v2"
+)
+
+// renderedContent is the structure of a helmfile content rendered and parsed without running helmfile
+type renderedContent struct {
+	// Releases are the releases by namespace/name
+	Releases map[string]map[string]interface{}
+
+	// Keys are the top-level keys other than releases, where a later part overrides an earlier one like in helmfile
+	Keys map[string]interface{}
+}
+
+// renderContent renders each part of the content like helmfile does, layering the inline and file values of the environment
+// declared in earlier parts before the given values, and parses the rendered YAML.
+// Nested helmfiles and bases are not followed.
+func renderContent(content string, enableGoTemplate bool, env, workingDirectory string, values []interface{}) (*renderedContent, error) {
+	if env == "" {
+		env = "default"
+	}
+
+	overrides, err := inlineStateValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := workingDirectory
+	if baseDir == "" {
+		baseDir = "."
+	}
+
+	rc := &renderedContent{
+		Releases: map[string]map[string]interface{}{},
+		Keys:     map[string]interface{}{},
+	}
+
+	fs := filesystem.DefaultFileSystem()
+	envValues := map[string]interface{}{}
+
+	for i, part := range splitValuesDocuments(content) {
+		vals := mergeValues(mergeValues(map[string]interface{}{}, envValues), overrides)
+
+		if enableGoTemplate {
+			data := state.NewEnvironmentTemplateData(environment.Environment{Name: env}, "", vals)
+
+			part, err = tmpl.NewFileRenderer(fs, baseDir, data).RenderTemplateContentToString([]byte(part))
+			if err != nil {
+				return nil, fmt.Errorf("rendering part %d: %w", i, err)
+			}
+		}
+
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte(part), &doc); err != nil {
+			return nil, fmt.Errorf("parsing part %d: %w", i, err)
+		}
+
+		if doc == nil {
+			continue
+		}
+
+		m, ok := stringKeyedValues(doc).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("parsing part %d: expected a map, got %T", i, doc)
+		}
+
+		for k, v := range m {
+			if k == "releases" {
+				releases, _ := v.([]interface{})
+				for _, r := range releases {
+					release, ok := r.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					rc.Releases[contentReleaseID(release)] = release
+				}
+				continue
+			}
+
+			rc.Keys[k] = v
+		}
+
+		if err := loadEnvironmentValues(m, env, fs, baseDir, vals, envValues); err != nil {
+			return nil, fmt.Errorf("loading values of environment %s in part %d: %w", env, i, err)
+		}
+	}
+
+	return rc, nil
+}
+
+// loadEnvironmentValues merges the values of the environment declared in the part into envValues.
+// The values are either inline maps or paths to values files relative to baseDir, which are rendered when they end with .gotmpl.
+func loadEnvironmentValues(part map[string]interface{}, env string, fs *filesystem.FileSystem, baseDir string, vals, envValues map[string]interface{}) error {
+	environments, _ := part["environments"].(map[string]interface{})
+	e, _ := environments[env].(map[string]interface{})
+	entries, _ := e["values"].([]interface{})
+
+	for _, entry := range entries {
+		switch typed := entry.(type) {
+		case map[string]interface{}:
+			mergeValues(envValues, typed)
+		case string:
+			data := state.NewEnvironmentTemplateData(environment.Environment{Name: env}, "", vals)
+
+			path := typed
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+
+			bs, err := tmpl.NewFileRenderer(fs, baseDir, data).RenderToBytes(path)
+			if err != nil {
+				return err
+			}
+
+			var v interface{}
+			if err := yaml.Unmarshal(bs, &v); err != nil {
+				return fmt.Errorf("parsing %s: %w", typed, err)
+			}
+
+			if m, ok := stringKeyedValues(v).(map[string]interface{}); ok {
+				mergeValues(envValues, m)
+			}
+		}
+	}
+
+	return nil
+}
+
+func contentReleaseID(release map[string]interface{}) string {
+	name := fmt.Sprintf("%v", release["name"])
+
+	if ns, ok := release["namespace"].(string); ok && ns != "" {
+		return ns + "/" + name
+	}
+
+	return name
+}
+
+// diffRenderedContents returns the added, removed and changed releases and top-level keys between the two contents,
+// one per line sorted by release and key, or an empty string when they are identical
+func diffRenderedContents(old, new *renderedContent) string {
+	var lines []string
+
+	for _, id := range unionKeys(old.Releases, new.Releases) {
+		o, inOld := old.Releases[id]
+		n, inNew := new.Releases[id]
+
+		switch {
+		case !inOld:
+			lines = append(lines, fmt.Sprintf("+ release %s", id))
+		case !inNew:
+			lines = append(lines, fmt.Sprintf("- release %s", id))
+		default:
+			var changed []string
+			for _, k := range unionKeys(o, n) {
+				if !reflect.DeepEqual(o[k], n[k]) {
+					changed = append(changed, k)
+				}
+			}
+			if len(changed) > 0 {
+				lines = append(lines, fmt.Sprintf("~ release %s: %s", id, strings.Join(changed, ", ")))
+			}
+		}
+	}
+
+	for _, k := range unionKeys(old.Keys, new.Keys) {
+		o, inOld := old.Keys[k]
+		n, inNew := new.Keys[k]
+
+		switch {
+		case !inOld:
+			lines = append(lines, fmt.Sprintf("+ key %s", k))
+		case !inNew:
+			lines = append(lines, fmt.Sprintf("- key %s", k))
+		case !reflect.DeepEqual(o, n):
+			lines = append(lines, fmt.Sprintf("~ key %s", k))
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// unionKeys returns the sorted keys of the two maps, which must be maps with string keys
+func unionKeys(a, b interface{}) []string {
+	seen := map[string]bool{}
+
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			seen[k.String()] = true
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,153 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffRenderedContents(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "env.yaml"), []byte("replicas: 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	old := `environments:
+  default:
+    values:
+    - version: 1.0.0
+    - env.yaml
+---
+repositories:
+- name: stable
+  url: https://charts.example.com
+releases:
+- name: myapp
+  namespace: default
+  chart: stable/myapp
+  version: {{ .Values.version }}
+  set:
+  - name: replicas
+    value: {{ .Values.replicas }}
+- name: removed
+  chart: stable/removed
+`
+
+	tests := []struct {
+		name string
+		new  string
+		want string
+	}{
+		{
+			name: "identical after rendering",
+			new: `environments:
+  default:
+    values:
+    - version: 1.0.0
+    - env.yaml
+---
+releases:
+- chart: stable/myapp
+  name: myapp
+  namespace: default
+  version: 1.0.0
+  set:
+  - name: replicas
+    value: 2
+- name: removed
+  chart: stable/removed
+repositories:
+- name: stable
+  url: https://charts.example.com
+`,
+		},
+		{
+			name: "changed, added and removed",
+			new: `environments:
+  default:
+    values:
+    - version: 1.0.0
+    - env.yaml
+---
+releases:
+- name: myapp
+  namespace: default
+  chart: stable/myapp
+  version: 1.1.0
+  set:
+  - name: replicas
+    value: 2
+- name: added
+  namespace: kube-system
+  chart: stable/added
+helmDefaults:
+  wait: true
+`,
+			want: `~ release default/myapp: version
++ release kube-system/added
+- release removed
++ key helmDefaults
+- key repositories
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := renderContent(old, true, "", dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := renderContent(tt.new, true, "", dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := diffRenderedContents(o, n); got != tt.want {
+				t.Errorf("unexpected diff: want:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderContentValues(t *testing.T) {
+	content := `environments:
+  default:
+    values:
+    - version: 1.0.0
+  production:
+    values:
+    - version: 2.0.0
+---
+releases:
+- name: myapp
+  chart: stable/myapp
+  version: {{ .Values.version }}
+`
+
+	tests := []struct {
+		env    string
+		values []interface{}
+		want   string
+	}{
+		{env: "default", want: "1.0.0"},
+		{env: "production", want: "2.0.0"},
+		{env: "production", values: []interface{}{"version: 3.0.0\n"}, want: "3.0.0"},
+	}
+
+	for _, tt := range tests {
+		rc, err := renderContent(content, true, tt.env, "", tt.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := rc.Releases["myapp"]["version"]; got != tt.want {
+			t.Errorf("unexpected version for environment %s and values %v: want %s, got %v", tt.env, tt.values, tt.want, got)
+		}
+	}
+
+	if _, err := renderContent(content, false, "default", "", nil); err == nil {
+		t.Error("expected the template expressions to be parsed as-is without enable_go_template")
+	}
+}
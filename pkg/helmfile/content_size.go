@@ -0,0 +1,77 @@
+package helmfile
+
+import (
+	"fmt"
+)
+
+const (
+	// DefaultMaxDiffOutputLen is the default maximum length of diff_output
+	DefaultMaxDiffOutputLen = 4096
+
+	// DefaultMaxOutputLen is the default maximum length of apply_output and template_output
+	DefaultMaxOutputLen = 1024 * 1024
+
+	// DefaultContentSizeWarningBytes is the default size of content and values above which a warning is logged
+	DefaultContentSizeWarningBytes = 512 * 1024
+
+	// DefaultMaxContentSizeBytes is the default size of content and values above which the plan fails.
+	// It leaves room below the 4 MB default message size limit of the gRPC connection between Terraform and the provider.
+	DefaultMaxContentSizeBytes = 3 * 1024 * 1024
+)
+
+// maxOutputLen returns the maximum length of apply_output and template_output for the release set
+func maxOutputLen(fs *ReleaseSet) int {
+	if fs.MaxOutputLen == 0 {
+		return DefaultMaxOutputLen
+	}
+
+	return fs.MaxOutputLen
+}
+
+// warnContentSize is the ValidateFunc of content and each of values, which returns a warning that Terraform shows as
+// a diagnostic when the value is larger than DefaultContentSizeWarningBytes. The ValidateFunc has no access to the
+// provider config, so content_size_warning_bytes only sets the threshold of the warning logged on plan.
+func warnContentSize(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok || len(s) <= DefaultContentSizeWarningBytes {
+		return nil, nil
+	}
+
+	return []string{fmt.Sprintf("%s is %d bytes, which exceeds %d bytes. Terraform fails opaquely on messages near 4 MB, "+
+		"so consider keeping it in a file referenced from content by helmfiles: [{path: ...}] or passed with values_files", k, len(s), DefaultContentSizeWarningBytes)}, nil
+}
+
+// validateContentSize fails when content or any values entry is larger than maxBytes, which would otherwise result in
+// an opaque "message too large" gRPC error from Terraform, and logs a warning when it is larger than warnBytes.
+// Zero disables the respective limit.
+func validateContentSize(fs *ReleaseSet, warnBytes, maxBytes int) error {
+	attrs := []struct {
+		name  string
+		value string
+	}{
+		{name: KeyContent, value: fs.Content},
+	}
+
+	for i, v := range fs.Values {
+		attrs = append(attrs, struct {
+			name  string
+			value string
+		}{name: fmt.Sprintf("%s[%d]", KeyValues, i), value: fmt.Sprintf("%v", v)})
+	}
+
+	for _, a := range attrs {
+		size := len(a.value)
+
+		if maxBytes > 0 && size > maxBytes {
+			return fmt.Errorf("%s is %d bytes, which exceeds max_content_size_bytes of %d. "+
+				"Keep it in a file instead, referenced from content by helmfiles: [{path: ...}] or passed with values_files, or raise max_content_size_bytes in the provider config", a.name, size, maxBytes)
+		}
+
+		if warnBytes > 0 && size > warnBytes {
+			logf("Warning: %s is %d bytes, which exceeds content_size_warning_bytes of %d. "+
+				"Consider keeping it in a file referenced from content by helmfiles: [{path: ...}] or passed with values_files", a.name, size, warnBytes)
+		}
+	}
+
+	return nil
+}
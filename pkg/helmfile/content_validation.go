@@ -0,0 +1,174 @@
+package helmfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template/parse"
+
+	goyaml "github.com/goccy/go-yaml"
+	goyamlparser "github.com/goccy/go-yaml/parser"
+)
+
+// checkYAML returns an error telling the line and the column of the first YAML error in s.
+// yaml.v2 decides whether s is valid, as helmfile decodes it the same way. It only tells the line of the error,
+// so the error is reported as go-yaml tells it with the column, unless go-yaml accepts s.
+func checkYAML(s string) error {
+	_, err := decodeYAMLDocuments(s)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *goyaml.SyntaxError
+	if _, perr := goyamlparser.ParseBytes([]byte(s), 0); errors.As(perr, &syntaxErr) && syntaxErr.Token != nil {
+		pos := syntaxErr.Token.Position
+		return fmt.Errorf("invalid YAML at line %d, column %d: %s", pos.Line, pos.Column, syntaxErr.Message)
+	}
+
+	return fmt.Errorf("invalid YAML: %w", err)
+}
+
+// checkGoTemplate returns an error when s doesn't parse as a Go template. The functions are not checked,
+// as helmfile adds its own and sprig's, which only it knows.
+func checkGoTemplate(s string) error {
+	t := parse.New(KeyContent)
+	t.Mode = parse.SkipFuncCheck
+
+	if _, err := t.Parse(s, "", "", map[string]*parse.Tree{}); err != nil {
+		return fmt.Errorf("invalid Go template: %w", err)
+	}
+
+	return nil
+}
+
+// validateYAML is the ValidateFunc of each of values, which are passed to helmfile as state values files as is
+func validateYAML(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	if err := checkYAML(s); err != nil {
+		return nil, []error{fmt.Errorf("%s: %w", k, err)}
+	}
+
+	return nil, nil
+}
+
+// validateContent validates content on plan, so that a typo fails the plan instead of the apply. The content is
+// parsed as a Go template when enable_go_template is true, as the template may not be YAML before it is rendered.
+// Otherwise it is parsed as YAML, falling back to a Go template for the content with template actions, as helmfile
+// before 1.0 renders every helmfile.yaml as a template.
+func validateContent(content, path string, goTemplate bool) error {
+	// ReleaseSetSchema can't have ConflictsWith, as it is nested in the schema of helmfile_embedding_example
+	if content != "" && path != "" {
+		return fmt.Errorf("%s and %s cannot both be set", KeyContent, KeyPath)
+	}
+
+	if content == "" {
+		if path == "" {
+			return fmt.Errorf("either %s or %s must be set, as helmfile would otherwise run with no releases", KeyContent, KeyPath)
+		}
+
+		return nil
+	}
+
+	if goTemplate {
+		if err := checkGoTemplate(content); err != nil {
+			return fmt.Errorf("%s: %w", KeyContent, err)
+		}
+
+		return nil
+	}
+
+	err := checkYAML(content)
+	if err != nil && strings.Contains(content, "{{") && checkGoTemplate(content) == nil {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", KeyContent, err)
+	}
+
+	return nil
+}
+
+// resolvePath returns the absolute path of path, which is relative to workingDir unless it is absolute itself
+func resolvePath(path, workingDir string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workingDir, path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute path to %s: %w", path, err)
+	}
+
+	return abs, nil
+}
+
+// validatePath validates that path is a helmfile, or a directory like helmfile.d with helmfiles in it, so that a
+// missing one fails the plan with the path it is resolved to, instead of failing deep inside helmfile
+func validatePath(path, workingDir string) error {
+	if path == "" {
+		return nil
+	}
+
+	abs, err := resolvePath(path, workingDir)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(abs)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s %q does not exist at %s, resolved against %s %q", KeyPath, path, abs, KeyWorkingDirectory, workingDir)
+	} else if err != nil {
+		return fmt.Errorf("%s %q resolved to %s: %w", KeyPath, path, abs, err)
+	}
+
+	if !info.IsDir() {
+		return nil
+	}
+
+	// helmfile loads the helmfiles in the directory in the alphabetical order, ignoring the other files
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.gotmpl"} {
+		if matches, _ := filepath.Glob(filepath.Join(abs, pattern)); len(matches) > 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s %q resolved to %s is a directory without helmfiles in it, like *.yaml or *.yaml.gotmpl", KeyPath, path, abs)
+}
+
+// validateWorkingDirectory validates that working_directory exists or can be created by creating only itself,
+// as a typo in its parent would otherwise create a whole tree of directories on apply
+func validateWorkingDirectory(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("getting absolute path to %s: %w", dir, err)
+	}
+
+	if info, err := os.Stat(abs); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s %q resolved to %s is not a directory", KeyWorkingDirectory, dir, abs)
+		}
+
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("%s %q resolved to %s: %w", KeyWorkingDirectory, dir, abs, err)
+	}
+
+	parent := filepath.Dir(abs)
+
+	if info, err := os.Stat(parent); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s %q resolved to %s cannot be created, as its parent %s is not an existing directory", KeyWorkingDirectory, dir, abs, parent)
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestValidateContent(t *testing.T) {
+	testcases := []struct {
+		name       string
+		content    string
+		path       string
+		goTemplate bool
+		// wantErr is the substrings of the error, or none when the content is valid
+		wantErr []string
+	}{
+		{
+			name:    "valid yaml",
+			content: "releases:\n- name: app\n  chart: charts/app\n",
+		},
+		{
+			name:    "malformed yaml",
+			content: "releases:\n- name: app\n  chart: [charts/app\n",
+			wantErr: []string{KeyContent, "invalid YAML at line 3, column 10"},
+		},
+		{
+			name:    "mis-indented yaml",
+			content: "releases:\n- name: app\n   chart: charts/app\n",
+			wantErr: []string{KeyContent, "invalid YAML at line 2, column 9", "mapping value is not allowed"},
+		},
+		{
+			name:       "malformed go template",
+			content:    "releases:\n- name: {{ .Values.name }\n",
+			goTemplate: true,
+			wantErr:    []string{KeyContent, "invalid Go template"},
+		},
+		{
+			name:       "unclosed go template action",
+			content:    "{{ if .Values.enabled }}\nreleases: []\n",
+			goTemplate: true,
+			wantErr:    []string{KeyContent, "invalid Go template"},
+		},
+		{
+			// The braces of the actions are flow mappings in YAML, which are not valid before the template is rendered
+			name:       "valid go template with yaml-invalid braces",
+			content:    "releases:\n- name: {{ .Values.name }}\n  chart: charts/app\n  installed: {{ .Values.installed | default true }}\n",
+			goTemplate: true,
+		},
+		{
+			name:       "go template with unknown functions",
+			content:    "releases:\n- name: {{ requiredEnv \"NAME\" | quote }}\n",
+			goTemplate: true,
+		},
+		{
+			name:    "go template without enable_go_template",
+			content: "releases:\n- name: {{ .Values.name }}\n",
+		},
+		{
+			name:    "malformed yaml and go template",
+			content: "releases:\n- name: {{ .Values.name }\n",
+			wantErr: []string{KeyContent, "invalid YAML"},
+		},
+		{
+			name:    "empty content without path",
+			wantErr: []string{"either content or path must be set"},
+		},
+		{
+			name:    "content with path",
+			content: "releases: []\n",
+			path:    "helmfile.yaml",
+			wantErr: []string{"content and path cannot both be set"},
+		},
+		{
+			name: "empty content with path",
+			path: "helmfile.yaml",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateContent(tc.content, tc.path, tc.goTemplate)
+
+			if len(tc.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected an error containing %q, got none", tc.wantErr)
+			}
+
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected the error to contain %q, got %q", want, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseSetSchemaValidatesValues(t *testing.T) {
+	values := []interface{}{
+		"replicas: 1\n",
+		"image:\n  tag: v1\n   repository: app\n",
+	}
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		KeyContent: "releases: []",
+		KeyValues:  values,
+	})
+
+	_, errs := (&schema.Resource{Schema: ReleaseSetSchema}).Validate(config)
+	if len(errs) != 1 {
+		t.Fatalf("expected an error for the malformed values entry, got %v", errs)
+	}
+
+	for _, want := range []string{"values.1", "invalid YAML at line 2, column 8"} {
+		if !strings.Contains(errs[0].Error(), want) {
+			t.Errorf("expected the error to contain %q, got %q", want, errs[0].Error())
+		}
+	}
+}
+
+func TestReleaseSetSchemaWarnsLargeContent(t *testing.T) {
+	large := "# " + strings.Repeat("a", DefaultContentSizeWarningBytes) + "\nreleases: []\n"
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		KeyContent: large,
+		KeyValues:  []interface{}{"replicas: 1\n", large},
+	})
+
+	warns, errs := (&schema.Resource{Schema: ReleaseSetSchema}).Validate(config)
+	if len(errs) != 0 {
+		t.Fatalf("expected only warnings above the soft limit, got %v", errs)
+	}
+
+	sort.Strings(warns)
+
+	if len(warns) != 2 || !strings.HasPrefix(warns[0], "content is ") || !strings.HasPrefix(warns[1], "values.1 is ") {
+		t.Errorf("expected a warning for content and values.1, got %v", warns)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, "helmfile.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "helmfile.d", "00-infra.yaml.gotmpl"), []byte("releases: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "empty.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "helmfile.yaml"), []byte("releases: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name       string
+		path       string
+		workingDir string
+		// wantErr is the substrings of the error, or none when the path is valid
+		wantErr []string
+	}{
+		{
+			name:       "relative file",
+			path:       "helmfile.yaml",
+			workingDir: dir,
+		},
+		{
+			name:       "relative file in parent",
+			path:       "../helmfile.yaml",
+			workingDir: filepath.Join(dir, "helmfile.d"),
+		},
+		{
+			name: "absolute file",
+			path: filepath.Join(dir, "helmfile.yaml"),
+		},
+		{
+			name:       "missing file",
+			path:       "helmfile.prod.yaml",
+			workingDir: dir,
+			wantErr:    []string{"does not exist at " + filepath.Join(dir, "helmfile.prod.yaml"), KeyWorkingDirectory},
+		},
+		{
+			name:       "helmfile.d directory",
+			path:       "helmfile.d",
+			workingDir: dir,
+		},
+		{
+			name:       "directory without helmfiles",
+			path:       "empty.d",
+			workingDir: dir,
+			wantErr:    []string{filepath.Join(dir, "empty.d") + " is a directory without helmfiles"},
+		},
+		{
+			name:       "missing directory",
+			path:       "missing.d/",
+			workingDir: dir,
+			wantErr:    []string{"does not exist at " + filepath.Join(dir, "missing.d")},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePath(tc.path, tc.workingDir)
+
+			if len(tc.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected an error containing %q, got none", tc.wantErr)
+			}
+
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected the error to contain %q, got %q", want, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestValidateWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(file, []byte("releases: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, valid := range []string{"", dir, filepath.Join(dir, "new")} {
+		if err := validateWorkingDirectory(valid); err != nil {
+			t.Errorf("unexpected error for %q: %v", valid, err)
+		}
+	}
+
+	if err := validateWorkingDirectory(filepath.Join(dir, "typo", "new")); err == nil || !strings.Contains(err.Error(), "its parent "+filepath.Join(dir, "typo")+" is not an existing directory") {
+		t.Errorf("expected an error for the missing parent, got %v", err)
+	}
+
+	if err := validateWorkingDirectory(file); err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("expected an error for the file, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package helmfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// listedRelease is a release in the JSON output of helmfile list
+type listedRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// ID returns the namespace-qualified name of the release used in failed_releases
+func (r listedRelease) ID() string {
+	if r.Namespace == "" {
+		return r.Name
+	}
+	return r.Namespace + "/" + r.Name
+}
+
+// Selector returns the label selector that matches only this release
+func (r listedRelease) Selector() string {
+	if r.Namespace == "" {
+		return "name=" + r.Name
+	}
+	return fmt.Sprintf("name=%s,namespace=%s", r.Name, r.Namespace)
+}
+
+// parseReleaseList parses the JSON output of helmfile list
+func parseReleaseList(output string) ([]listedRelease, error) {
+	output = strings.TrimSpace(output)
+	if output == "" || output == "null" {
+		return nil, nil
+	}
+
+	var releases []listedRelease
+	if err := json.Unmarshal([]byte(output), &releases); err != nil {
+		return nil, fmt.Errorf("parsing helmfile list output: %w", err)
+	}
+
+	return releases, nil
+}
+
+// applyEachRelease applies the releases one by one for continue_on_error.
+//
+// helmfile apply aborts on the first failing release, so we list the releases matching the selectors
+// and run a selector-scoped apply for each of them instead, recording the failed ones in failed_releases.
+// It fails only when more than max_failed_releases releases failed.
+func applyEachRelease(ctx context.Context, fs *ReleaseSet, opts *ApplyOptions, d ResourceReadWrite, executor HelmfileExecutor) (*Result, error) {
+	listResult, err := executor.List(ctx, &ListOptions{BaseOptions: opts.BaseOptions})
+	if err != nil {
+		return listResult, fmt.Errorf("listing releases: %w", err)
+	}
+
+	releases, err := parseReleaseList(listResult.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	var output strings.Builder
+
+	failed := []string{}
+	failedReleases := []listedRelease{}
+
+	for _, r := range releases {
+		releaseOpts := *opts
+		releaseOpts.Selector = nil
+		releaseOpts.Selectors = []interface{}{r.Selector()}
+
+		result, err := applyReleases(ctx, fs, executor, &releaseOpts)
+		if result != nil {
+			output.WriteString(result.Output)
+		}
+
+		err = schemaValidationError(result, err)
+		if err != nil {
+			logf("Release %s failed, continuing with the remaining releases: %v", r.ID(), err)
+			output.WriteString(fmt.Sprintf("Release %s failed: %v\n", r.ID(), err))
+			failed = append(failed, r.ID())
+			failedReleases = append(failedReleases, r)
+		}
+	}
+
+	d.Set(KeyFailedReleases, failed)
+
+	// Summarize the failures in the same format as helmfile, as the failing applies may not have printed it
+	if len(failedReleases) > 0 {
+		output.WriteString("\nFAILED RELEASES:\nNAME   NAMESPACE\n")
+		for _, r := range failedReleases {
+			output.WriteString(fmt.Sprintf("%s   %s\n", r.Name, r.Namespace))
+		}
+	}
+
+	if len(failed) > fs.MaxFailedReleases {
+		err := fmt.Errorf("%d release(s) failed, exceeding max_failed_releases of %d: %s", len(failed), fs.MaxFailedReleases, strings.Join(failed, ", "))
+		return &Result{
+			Output:   output.String(),
+			ExitCode: 1,
+			Error:    err,
+		}, err
+	}
+
+	// Tolerated failures are reported with a non-zero exit code but no error
+	exitCode := 0
+	if len(failed) > 0 {
+		exitCode = 1
+	}
+
+	return &Result{
+		Output:   output.String(),
+		ExitCode: exitCode,
+		Error:    nil,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package helmfile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEachRelease(t *testing.T) {
+	listOutput := `[{"name":"app","namespace":"default","enabled":true,"installed":true,"labels":"","chart":"charts/app","version":""},` +
+		`{"name":"metrics-exporter","namespace":"monitoring","enabled":true,"installed":true,"labels":"","chart":"charts/exporter","version":""},` +
+		`{"name":"cluster-wide","namespace":"","enabled":true,"installed":true,"labels":"","chart":"charts/cluster","version":""}]`
+
+	tests := []struct {
+		name              string
+		maxFailedReleases int
+		wantErr           bool
+	}{
+		{name: "fails when exceeding max_failed_releases", maxFailedReleases: 0, wantErr: true},
+		{name: "succeeds within max_failed_releases", maxFailedReleases: 1, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &fakeExecutor{
+				listOutput:       listOutput,
+				failingSelectors: map[string]bool{"name=metrics-exporter,namespace=monitoring": true},
+			}
+			d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+			fs := &ReleaseSet{ContinueOnError: true, MaxFailedReleases: tt.maxFailedReleases}
+
+			result, err := applyEachRelease(context.Background(), fs, buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), d, executor)
+			if tt.wantErr && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			wantApplied := []string{"name=app,namespace=default", "name=metrics-exporter,namespace=monitoring", "name=cluster-wide"}
+			if !reflect.DeepEqual(executor.appliedSelectors, wantApplied) {
+				t.Errorf("expected releases to be applied with %v, got %v", wantApplied, executor.appliedSelectors)
+			}
+
+			wantSummary := Summary{HasChanges: true, ChangedReleaseCount: 1, Failed: true}
+			if got := applySummary(result, err != nil); got != wantSummary {
+				t.Errorf("expected summary %+v, got %+v", wantSummary, got)
+			}
+
+			wantFailed := []string{"monitoring/metrics-exporter"}
+			if got := d.Get(KeyFailedReleases); !reflect.DeepEqual(got, wantFailed) {
+				t.Errorf("expected failed_releases %v, got %v", wantFailed, got)
+			}
+		})
+	}
+}
+
+// TestApplyEachReleaseScopesToRelease tests that each apply targets only its release, without the selectors of the
+// release set that helmfile would OR with the selector of the release
+func TestApplyEachReleaseScopesToRelease(t *testing.T) {
+	executor := &fakeExecutor{listOutput: `[{"name":"app","namespace":"default"}]`}
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	fs := &ReleaseSet{ContinueOnError: true}
+
+	opts := buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})
+	opts.Selector = map[string]interface{}{"tier": "backend"}
+
+	if _, err := applyEachRelease(context.Background(), fs, opts, d, executor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"name=app,namespace=default"}; !reflect.DeepEqual(executor.appliedSelectors, want) {
+		t.Errorf("expected the release to be applied with %v, got %v", want, executor.appliedSelectors)
+	}
+}
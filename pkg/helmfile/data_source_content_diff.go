@@ -0,0 +1,99 @@
+package helmfile
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+const KeyOldContent = "old_content"
+const KeyNewContent = "new_content"
+const KeyContentDiff = "diff"
+const KeyIdentical = "identical"
+
+func dataSourceHelmfileContentDiff() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceHelmfileContentDiffRead,
+		Schema: map[string]*schema.Schema{
+			KeyOldContent: {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The current helmfile content",
+			},
+			KeyNewContent: {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The new helmfile content",
+			},
+			KeyValues: {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Description: "Values layered over the environment values when rendering both contents",
+			},
+			KeyEnvironment: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "default",
+				Description: "The helmfile environment whose values are used when rendering both contents",
+			},
+			KeyEnableGoTemplate: {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "When true, renders both contents as Go templates like enable_go_template of helmfile_release_set",
+			},
+			KeyWorkingDirectory: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Directory that environment values files and template functions like readFile are relative to",
+			},
+			KeyContentDiff: {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The added (+), removed (-) and changed (~) releases and top-level keys, one per line",
+			},
+			KeyIdentical: {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "True when the two contents render to the same releases and top-level keys",
+			},
+		},
+	}
+}
+
+func dataSourceHelmfileContentDiffRead(d *schema.ResourceData, meta interface{}) error {
+	var values []interface{}
+	if v := d.Get(KeyValues); v != nil {
+		values = v.([]interface{})
+	}
+
+	env := d.Get(KeyEnvironment).(string)
+	enableGoTemplate := d.Get(KeyEnableGoTemplate).(bool)
+	workingDirectory := d.Get(KeyWorkingDirectory).(string)
+
+	old, err := renderContent(d.Get(KeyOldContent).(string), enableGoTemplate, env, workingDirectory, values)
+	if err != nil {
+		return fmt.Errorf("rendering %s: %w", KeyOldContent, err)
+	}
+
+	new, err := renderContent(d.Get(KeyNewContent).(string), enableGoTemplate, env, workingDirectory, values)
+	if err != nil {
+		return fmt.Errorf("rendering %s: %w", KeyNewContent, err)
+	}
+
+	diff := diffRenderedContents(old, new)
+
+	id, err := HashObject([]interface{}{d.Get(KeyOldContent), d.Get(KeyNewContent), values, env, enableGoTemplate, workingDirectory})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(id)
+	d.Set(KeyContentDiff, diff)
+	d.Set(KeyIdentical, diff == "")
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package helmfile
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+const KeyChangedReleases = "changed_releases"
+
+func dataSourceHelmfileDiff() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceHelmfileDiffRead,
+		Schema: dataSourceReleaseSetSchema(map[string]*schema.Schema{
+			KeyKubeconfig: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the kubeconfig of the cluster that the releases are diffed against",
+			},
+			KeyDiffContext: {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultDiffContext,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "Number of unchanged lines shown around the changed lines in diff_output",
+			},
+			KeyDiffSuppressLineRegex: {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateDiffSuppressLineRegex,
+				},
+				Description: "Regexes of the lines removed from diff_output, like checksum annotations that change on every render",
+			},
+			KeyDiffOutput: {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The output of helmfile diff",
+			},
+			KeySummaryHasChanges: {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "True when helmfile diff found changes, like helmfile diff --detailed-exitcode exiting with 2",
+			},
+			KeyChangedReleases: {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Names of the releases with changes, in the order helmfile diffed them",
+			},
+		}),
+	}
+}
+
+func dataSourceHelmfileDiffRead(d *schema.ResourceData, meta interface{}) error {
+	provider := meta.(*ProviderInstance)
+
+	fs, err := newDataSourceReleaseSet(d)
+	if err != nil {
+		return err
+	}
+
+	fs.DiffContext = d.Get(KeyDiffContext).(int)
+	for _, r := range d.Get(KeyDiffSuppressLineRegex).([]interface{}) {
+		fs.DiffSuppressLineRegex = append(fs.DiffSuppressLineRegex, r.(string))
+	}
+
+	provider.applyDefaults(fs)
+
+	if err := validateDefaultSelectors(fs); err != nil {
+		return err
+	}
+
+	ctx, done := startOperation(0)
+	defer done()
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		return fmt.Errorf("preparing helmfile file: %w", err)
+	}
+	defer files.remove()
+	defer removeOnShutdown(files.Path)()
+
+	// The output is truncated after the changed releases are parsed, so that none of them is missed
+	result, err := provider.executorFor(fs).Diff(ctx, buildDiffOptions(fs, files, 0))
+	if err != nil {
+		if result != nil && result.Output != "" {
+			return fmt.Errorf("running helmfile diff: %w\nOutput:\n%s", err, result.Output)
+		}
+		return fmt.Errorf("running helmfile diff: %w", err)
+	}
+
+	diff, err := removeNondeterministicTemplateAndDiffLogLines(result.stdout())
+	if err != nil {
+		return err
+	}
+
+	id, err := HashObject([]interface{}{fs.Content, fs.WorkingDirectory, fs.Environment, d.Get(KeyValues), fs.ValuesFiles, effectiveSelectors(fs), fs.Kubeconfig, fs.EnableGoTemplate})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(id)
+	d.Set(KeyDiffOutput, truncateOutput(diff, provider.MaxDiffOutputLen, "helmfile-diff output", KeyMaxDiffOutputLen))
+	d.Set(KeySummaryHasChanges, result.ExitCode == 2)
+	d.Set(KeyChangedReleases, changedReleaseNames(diff))
+
+	return nil
+}
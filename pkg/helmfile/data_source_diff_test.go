@@ -0,0 +1,182 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func TestChangedReleaseNames(t *testing.T) {
+	diff := `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+-   replicas: 1
++   replicas: 2
+
+Comparing release=exporter, chart=charts/exporter, namespace=monitoring
+Comparing release=db, chart=charts/db, namespace=default
+default, db, Service (v1) has been added:
+`
+
+	if got, want := changedReleaseNames(diff), []string{"app", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if got := changedReleaseNames(""); len(got) != 0 {
+		t.Errorf("expected no changed releases, got %v", got)
+	}
+}
+
+func TestDataSourceHelmfileDiffRead(t *testing.T) {
+	dir, _ := newFakeBinaryHelmfile(t)
+
+	diff := "Comparing release=app, chart=charts/app, namespace=default\ndefault, app, Deployment (apps) has changed:\n"
+	for name, content := range map[string]string{"stdout": diff, "exit-code": "2"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The binary executor runs the helmfile binary of the data source
+	provider := &ProviderInstance{ExecutorName: ExecutorBinary}
+
+	d := schema.TestResourceDataRaw(t, dataSourceHelmfileDiff().Schema, map[string]interface{}{
+		KeyContent:               "releases: []",
+		KeyWorkingDirectory:      dir,
+		KeyKubeconfig:            "/tmp/kubeconfig",
+		KeyBin:                   filepath.Join(dir, "helmfile"),
+		KeyDiffContext:           5,
+		KeyDiffSuppressLineRegex: []interface{}{"checksum/"},
+	})
+
+	if err := dataSourceHelmfileDiffRead(d, provider); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !d.Get(KeySummaryHasChanges).(bool) {
+		t.Error("expected has_changes for the detailed exit code 2")
+	}
+
+	if got := d.Get(KeyChangedReleases).([]interface{}); !reflect.DeepEqual(got, []interface{}{"app"}) {
+		t.Errorf("expected app to be changed, got %v", got)
+	}
+
+	if got := d.Get(KeyDiffOutput).(string); got != diff {
+		t.Errorf("unexpected diff_output:\nwant: %q\ngot:  %q", diff, got)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.Contains(got, "--context 5") || !strings.Contains(got, "--suppress-output-line-regex checksum/") {
+		t.Errorf("expected helmfile diff to run with the diff context and the suppress regexes, got %s", got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "exit-code")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dataSourceHelmfileDiffRead(d, provider); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d.Get(KeySummaryHasChanges).(bool) {
+		t.Error("expected no changes for the exit code 0")
+	}
+}
+
+// TestAccHelmfileDiff_modified installs a release with one replica and diffs it against a helmfile with two
+// replicas and against the same helmfile, which only the former has changes for
+func TestAccHelmfileDiff_modified(t *testing.T) {
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-diff-data-source-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileDiffConfig_modified(releaseID, chartDir),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.helmfile_diff.unchanged", "has_changes", "false"),
+					resource.TestCheckResourceAttr("data.helmfile_diff.unchanged", "changed_releases.#", "0"),
+					resource.TestCheckResourceAttr("data.helmfile_diff.modified", "has_changes", "true"),
+					resource.TestCheckResourceAttr("data.helmfile_diff.modified", "changed_releases.#", "1"),
+					resource.TestCheckResourceAttr("data.helmfile_diff.modified", "changed_releases.0", "diff-"+releaseID),
+				),
+			},
+		},
+	})
+}
+
+func testAccHelmfileDiffConfig_modified(randVal, dir string) string {
+	return fmt.Sprintf(`
+locals {
+  helmfile = <<EOF
+releases:
+- name: diff-%[1]s
+  chart: %[2]s/chart
+  values:
+  - replicaCount: {{ .Values.replicaCount }}
+EOF
+}
+
+resource "helmfile_release_set" "the_product" {
+  content = local.helmfile
+
+  enable_go_template = true
+
+  values = ["replicaCount: 1"]
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+}
+
+data "helmfile_diff" "unchanged" {
+  content = local.helmfile
+
+  enable_go_template = true
+
+  values = ["replicaCount: 1"]
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  depends_on = [helmfile_release_set.the_product]
+}
+
+data "helmfile_diff" "modified" {
+  content = local.helmfile
+
+  enable_go_template = true
+
+  values = ["replicaCount: 2"]
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  depends_on = [helmfile_release_set.the_product]
+}
+`, randVal, dir)
+}
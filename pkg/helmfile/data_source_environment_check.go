@@ -0,0 +1,145 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk/tfsdk"
+)
+
+const KeyChecks = "checks"
+const KeyEnvironmentCheckName = "name"
+const KeyEnvironmentCheckStatus = "status"
+const KeyEnvironmentCheckDetail = "detail"
+const KeyOK = "ok"
+const KeyFailOnError = "fail_on_error"
+const KeyCheckTimeout = "check_timeout"
+
+func dataSourceHelmfileEnvironmentCheck() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceHelmfileEnvironmentCheckRead,
+		Schema: map[string]*schema.Schema{
+			KeyHelmBin: {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "helm",
+			},
+			KeyKubeconfig: {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			KeyEnvironmentVariables: {
+				Type:     schema.TypeMap,
+				Optional: true,
+			},
+			KeyAWSRegion: {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			KeyAWSProfile: {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			KeyAWSAssumeRole: tfsdk.SchemaAssumeRole(),
+			KeyEKSClusterName: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Name of the EKS cluster that is described to generate the kubeconfig when kubeconfig is not set",
+			},
+			KeyEKSClusterRegion: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Region of the EKS cluster. Defaults to aws_region",
+			},
+			KeyFailOnError: {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "When true, fails the plan when any check fails",
+			},
+			KeyCheckTimeout: {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     DefaultEnvironmentCheckTimeout,
+				Description: "Number of seconds each check can take",
+			},
+			KeyChecks: {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Results of the checks in the order they were run",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						KeyEnvironmentCheckName: {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "One of helm_version, helm_plugins, eks_describe_cluster, kubeconfig and cluster_version",
+						},
+						KeyEnvironmentCheckStatus: {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Either ok, failed or skipped",
+						},
+						KeyEnvironmentCheckDetail: {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The result of a successful check, or why it failed or was skipped",
+						},
+					},
+				},
+			},
+			KeyOK: {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "True when no check failed",
+			},
+		},
+	}
+}
+
+func dataSourceHelmfileEnvironmentCheckRead(d *schema.ResourceData, meta interface{}) error {
+	t := environmentCheckTarget{
+		HelmBin:        d.Get(KeyHelmBin).(string),
+		Kubeconfig:     d.Get(KeyKubeconfig).(string),
+		EKSClusterName: d.Get(KeyEKSClusterName).(string),
+		EKSRegion:      getEKSRegion(d),
+		Timeout:        time.Duration(d.Get(KeyCheckTimeout).(int)) * time.Second,
+	}
+
+	if environmentVariables := d.Get(KeyEnvironmentVariables); environmentVariables != nil {
+		t.EnvironmentVariables = environmentVariables.(map[string]interface{})
+	}
+
+	if t.EKSClusterName != "" {
+		t.Context = newContext(d)
+	}
+
+	checks := runEnvironmentChecks(t)
+
+	var failed []string
+	for _, c := range checks {
+		if c.Status == EnvironmentCheckStatusFailed {
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name, c.Detail))
+		}
+	}
+
+	id, err := HashObject([]interface{}{t.HelmBin, t.Kubeconfig, t.EnvironmentVariables, t.EKSClusterName, t.EKSRegion})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(id)
+	d.Set(KeyChecks, environmentChecksToList(checks))
+	d.Set(KeyOK, len(failed) == 0)
+
+	if len(failed) > 0 {
+		if d.Get(KeyFailOnError).(bool) {
+			return fmt.Errorf("environment checks failed:\n%s", strings.Join(failed, "\n"))
+		}
+
+		logf("Warning: environment checks failed:\n%s", strings.Join(failed, "\n"))
+	}
+
+	return nil
+}
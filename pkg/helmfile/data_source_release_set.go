@@ -0,0 +1,148 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// dataSourceReleaseSetSchema returns the schema of a data source that runs helmfile like helmfile_release_set does,
+// which is the inputs shared by those data sources along with the attributes of its own.
+// kubeconfig is left to each of them, as only some need the cluster.
+func dataSourceReleaseSetSchema(attrs map[string]*schema.Schema) map[string]*schema.Schema {
+	s := map[string]*schema.Schema{
+		KeyContent: {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The helmfile content. Either content or path must be set",
+		},
+		KeyPath: {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Path to the helmfile, relative to working_directory. Either content or path must be set",
+		},
+		KeyWorkingDirectory: {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Default:     "",
+			Description: "Directory that helmfile runs in, which the charts and values files of the helmfile are relative to",
+		},
+		KeyEnvironment: {
+			Type:     schema.TypeString,
+			Optional: true,
+			Default:  "",
+		},
+		KeyValues: {
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type: schema.TypeString,
+			},
+		},
+		KeyValuesFiles: {
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type: schema.TypeString,
+			},
+		},
+		KeySelectors: {
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type: schema.TypeString,
+			},
+		},
+		KeyKubeVersion: {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ValidateFunc: validateKubeVersion,
+			Description:  "Kubernetes version of .Capabilities.KubeVersion, like 1.29.0",
+		},
+		KeyAPIVersions: {
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type:         schema.TypeString,
+				ValidateFunc: validateAPIVersion,
+			},
+			Description: "API versions added to .Capabilities.APIVersions, like monitoring.coreos.com/v1",
+		},
+		KeyEnableGoTemplate: {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     false,
+			Description: "When true, renders the helmfile as a Go template like enable_go_template of helmfile_release_set",
+		},
+		KeyEnvironmentVariables: {
+			Type:     schema.TypeMap,
+			Optional: true,
+		},
+		KeyBin: {
+			Type:     schema.TypeString,
+			Optional: true,
+			Default:  "helmfile",
+		},
+		KeyHelmBin: {
+			Type:     schema.TypeString,
+			Optional: true,
+			Default:  "helm",
+		},
+	}
+
+	for k, v := range attrs {
+		s[k] = v
+	}
+
+	return s
+}
+
+// newDataSourceReleaseSet returns the release set of the inputs of the data source, whose content is read from path
+// when content is not set
+func newDataSourceReleaseSet(d *schema.ResourceData) (*ReleaseSet, error) {
+	fs := &ReleaseSet{
+		Content:          d.Get(KeyContent).(string),
+		WorkingDirectory: d.Get(KeyWorkingDirectory).(string),
+		Environment:      d.Get(KeyEnvironment).(string),
+		Values:           d.Get(KeyValues).([]interface{}),
+		ValuesFiles:      d.Get(KeyValuesFiles).([]interface{}),
+		Selectors:        d.Get(KeySelectors).([]interface{}),
+		KubeVersion:      d.Get(KeyKubeVersion).(string),
+		EnableGoTemplate: d.Get(KeyEnableGoTemplate).(bool),
+		Kubeconfig:       d.Get(KeyKubeconfig).(string),
+		Bin:              d.Get(KeyBin).(string),
+		HelmBin:          d.Get(KeyHelmBin).(string),
+	}
+
+	for _, v := range d.Get(KeyAPIVersions).([]interface{}) {
+		fs.APIVersions = append(fs.APIVersions, v.(string))
+	}
+
+	if environmentVariables := d.Get(KeyEnvironmentVariables); environmentVariables != nil {
+		fs.EnvironmentVariables = environmentVariables.(map[string]interface{})
+	}
+
+	path := d.Get(KeyPath).(string)
+
+	switch {
+	case fs.Content != "" && path != "":
+		return nil, fmt.Errorf("%s and %s cannot both be set", KeyContent, KeyPath)
+	case path != "":
+		path, err := resolvePath(path, fs.WorkingDirectory)
+		if err != nil {
+			return nil, err
+		}
+
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", KeyPath, err)
+		}
+
+		fs.Content = string(bs)
+	case fs.Content == "":
+		return nil, fmt.Errorf("either %s or %s must be set", KeyContent, KeyPath)
+	}
+
+	return fs, nil
+}
@@ -0,0 +1,93 @@
+package helmfile
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+const KeyRendered = "rendered"
+const KeyManifests = "manifests"
+
+func dataSourceHelmfileTemplate() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceHelmfileTemplateRead,
+		Schema: dataSourceReleaseSetSchema(map[string]*schema.Schema{
+			KeyKubeconfig: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the kubeconfig, for the charts that look up the cluster. Not needed otherwise",
+			},
+			KeyRendered: {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The manifests rendered by helmfile template as multi-document YAML",
+			},
+			KeyManifests: {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Each of the rendered manifests keyed by kind/namespace/name, whose namespace is empty when the manifest doesn't set it",
+			},
+		}),
+	}
+}
+
+func dataSourceHelmfileTemplateRead(d *schema.ResourceData, meta interface{}) error {
+	provider := meta.(*ProviderInstance)
+
+	fs, err := newDataSourceReleaseSet(d)
+	if err != nil {
+		return err
+	}
+
+	provider.applyDefaults(fs)
+
+	if err := validateDefaultSelectors(fs); err != nil {
+		return err
+	}
+
+	ctx, done := startOperation(0)
+	defer done()
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		return fmt.Errorf("preparing helmfile file: %w", err)
+	}
+	defer files.remove()
+	defer removeOnShutdown(files.Path)()
+
+	opts := buildTemplateOptions(fs, files)
+
+	// Rendering needs no cluster access, so the kubeconfig that is not set is left empty
+	// rather than resolved to the current directory
+	if k, err := resolveKubeconfig(fs); err != nil || k.Source == "" {
+		opts.Kubeconfig = ""
+	}
+
+	result, err := provider.executorFor(fs).Template(ctx, opts)
+	if err = schemaValidationError(result, err); err != nil {
+		if result != nil && result.Output != "" {
+			return fmt.Errorf("running helmfile template: %w\nOutput:\n%s", err, result.Output)
+		}
+		return fmt.Errorf("running helmfile template: %w", err)
+	}
+
+	rendered := result.stdout()
+
+	manifests, err := templateManifests(rendered)
+	if err != nil {
+		return fmt.Errorf("splitting helmfile template output: %w", err)
+	}
+
+	id, err := HashObject([]interface{}{fs.Content, fs.WorkingDirectory, fs.Environment, d.Get(KeyValues), fs.ValuesFiles, effectiveSelectors(fs), fs.KubeVersion, fs.APIVersions, fs.EnableGoTemplate})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(id)
+	d.Set(KeyRendered, rendered)
+	d.Set(KeyManifests, manifests)
+
+	return nil
+}
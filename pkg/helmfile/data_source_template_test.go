@@ -0,0 +1,97 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func TestNewTemplateReleaseSet(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "helmfile.yaml"), []byte("releases: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := schema.TestResourceDataRaw(t, dataSourceHelmfileTemplate().Schema, map[string]interface{}{
+		KeyPath:             "helmfile.yaml",
+		KeyWorkingDirectory: dir,
+		KeyKubeVersion:      "1.29.0",
+		KeyAPIVersions:      []interface{}{"monitoring.coreos.com/v1"},
+	})
+
+	fs, err := newDataSourceReleaseSet(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fs.Content != "releases: []\n" || fs.KubeVersion != "1.29.0" || len(fs.APIVersions) != 1 {
+		t.Errorf("expected the content read from path along with the capabilities, got %+v", fs)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{},
+		{KeyContent: "releases: []", KeyPath: "helmfile.yaml"},
+	} {
+		if _, err := newDataSourceReleaseSet(schema.TestResourceDataRaw(t, dataSourceHelmfileTemplate().Schema, raw)); err == nil || !strings.Contains(err.Error(), KeyPath) {
+			t.Errorf("expected exactly one of content and path to be required for %v, got %v", raw, err)
+		}
+	}
+}
+
+// TestAccHelmfileTemplate_basic renders podinfo without a kubeconfig and finds its Deployment among the manifests
+func TestAccHelmfileTemplate_basic(t *testing.T) {
+	dataSourceName := "data.helmfile_template.podinfo"
+	releaseID := acctest.RandString(8)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+		},
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileTemplateConfig_basic(releaseID),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(dataSourceName, "rendered", regexp.MustCompile(`kind: Deployment`)),
+					resource.TestMatchResourceAttr(dataSourceName, "manifests.Deployment//podinfo-"+releaseID, regexp.MustCompile(`ghcr.io/stefanprodan/podinfo:6.5.4`)),
+				),
+			},
+		},
+	})
+}
+
+func testAccHelmfileTemplateConfig_basic(randVal string) string {
+	return fmt.Sprintf(`
+data "helmfile_template" "podinfo" {
+  content = <<EOF
+repositories:
+- name: sp
+  url: https://stefanprodan.github.io/podinfo
+
+releases:
+- name: podinfo-%[1]s
+  chart: sp/podinfo
+  version: 6.5.4
+  labels:
+    app: podinfo
+EOF
+
+  helm_binary = "helm"
+
+  selectors = ["app=podinfo"]
+
+  kube_version = "1.29.0"
+}
+`, randVal)
+}
@@ -0,0 +1,620 @@
+package helmfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DefaultDiffCacheTTL is the default duration after which the diff cache entries expire
+const DefaultDiffCacheTTL = time.Hour
+
+const (
+	diffCacheSettingsFile = "settings.json"
+	diffCacheEntryExt     = ".json"
+)
+
+// diffCacheNow returns the current time for the TTL of the diff cache entries. It is a variable to be replaced in tests.
+var diffCacheNow = time.Now
+
+// diffCacheRelease is a release of the helmfile build output along with the key and the live revision looked up in the diff cache
+type diffCacheRelease struct {
+	listedRelease
+
+	// Key is the hash of the release ID, the release spec with the embedded values and the cluster identity
+	Key string
+
+	// Revision is the revision of the release in the cluster, or zero when it is not installed
+	Revision int
+}
+
+// diffCacheEntry is the cached verdict of helmfile diff for a release
+type diffCacheEntry struct {
+	Key       string    `json:"key"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Compared is false when helmfile diff didn't compare the release, like when it is not matched by the selectors
+	Compared bool `json:"compared"`
+
+	// Lines are the section of the release in the helmfile diff output starting with the "Comparing release=" header,
+	// which is the header only when the release has no changes
+	Lines []string `json:"lines,omitempty"`
+}
+
+func (e diffCacheEntry) changed() bool {
+	return len(e.Lines) > 1 && diffRelease{Lines: e.Lines[1:]}.changed()
+}
+
+// diffCacheSettings are the settings that affect the helmfile diff output of every release.
+// The cache is ignored as a whole when they differ from the ones of the cached run.
+type diffCacheSettings struct {
+	Flags                []string
+	DryRun               bool
+	Selector             map[string]interface{}
+	Selectors            []interface{}
+	ReleasesValues       map[string]interface{}
+	ReleasesValuesString map[string]interface{}
+	SetValues            []SetValue
+	KubeVersion          string
+	APIVersions          []string
+	DiffArgs             string
+}
+
+// runCachedDiff runs helmfile diff only for the releases that are missing in diff_cache_dir, and assembles the output
+// from the sections of the releases in the order of the helmfile.
+//
+// A cached section is reused when the release spec with the embedded values, the chart version and the cluster are unchanged,
+// the live revision of the release hasn't moved, and the entry hasn't expired. Any failure to use the cache falls back to
+// running helmfile diff for all the releases.
+func runCachedDiff(ctx *sdk.Context, fs *ReleaseSet, conf DiffConfig) (*State, error) {
+	if fs.DiffCacheDir == "" {
+		return runDiff(ctx, fs, conf)
+	}
+
+	// helmfile build lists only the releases matching the selectors, without the needs that helmfile diff would include
+	if (fs.IncludeNeeds || fs.IncludeTransitiveNeeds) && len(effectiveSelectors(fs)) > 0 {
+		logf("[DEBUG] Running helmfile diff without %s, which doesn't track the needs of the selected releases", KeyDiffCacheDir)
+		return runDiff(ctx, fs, conf)
+	}
+
+	releases, settings, err := diffCacheReleases(ctx, fs, conf)
+	if err != nil {
+		logf("Warning: running helmfile diff without %s: %v", KeyDiffCacheDir, err)
+		return runDiff(ctx, fs, conf)
+	}
+
+	entries := loadDiffCache(fs, settings, releases)
+
+	var misses []diffCacheRelease
+	for _, r := range releases {
+		if _, ok := entries[r.ID()]; !ok {
+			misses = append(misses, r)
+		}
+	}
+
+	logf("[DEBUG] Diff cache has %d of %d release(s). Running helmfile diff for the others", len(releases)-len(misses), len(releases))
+
+	var stderr string
+
+	if len(misses) > 0 {
+		missConf := conf
+		missConf.Selectors = diffCacheSelectors(fs, misses)
+		missConf.KeepUnchangedOutput = true
+
+		state, err := runDiff(ctx, fs, missConf)
+		var diffErr *diffCommandError
+		if errors.As(err, &diffErr) {
+			// The releases compared before the failure are assembled with the cached ones, without caching them
+			sections := diffSections(diffErr.Output)
+			for _, r := range misses {
+				if lines, ok := sections[r.ID()]; ok {
+					entries[r.ID()] = diffCacheEntry{Compared: true, Lines: lines}
+				}
+			}
+
+			diffErr.Output = assembleCachedDiff(releases, entries)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stderr = state.Stderr
+
+		sections := diffSections(state.Output)
+
+		missEntries := map[string]diffCacheEntry{}
+		for _, r := range misses {
+			lines, compared := sections[r.ID()]
+
+			e := diffCacheEntry{
+				Key:       r.Key,
+				Revision:  r.Revision,
+				CreatedAt: diffCacheNow(),
+				Compared:  compared,
+				Lines:     lines,
+			}
+
+			entries[r.ID()] = e
+			missEntries[r.ID()] = e
+		}
+
+		if err := saveDiffCache(fs.DiffCacheDir, settings, misses, missEntries); err != nil {
+			logf("Warning: writing %s: %v", KeyDiffCacheDir, err)
+		}
+	}
+
+	return &State{Output: assembleCachedDiff(releases, entries), Stderr: stderr}, nil
+}
+
+// diffCacheReleases returns the releases of the helmfile build output with their cache keys and live revisions,
+// and the hash of the diff cache settings
+func diffCacheReleases(ctx *sdk.Context, fs *ReleaseSet, conf DiffConfig) ([]diffCacheRelease, string, error) {
+	fs = releaseSetForDiff(fs)
+
+	settings, err := diffCacheHash(diffCacheSettings{
+		Flags:                diffOutputFlags(fs),
+		DryRun:               conf.DryRun,
+		Selector:             fs.Selector,
+		Selectors:            effectiveSelectors(fs),
+		ReleasesValues:       fs.ReleasesValues,
+		ReleasesValuesString: fs.ReleasesValuesString,
+		SetValues:            fs.SetValues,
+		KubeVersion:          fs.KubeVersion,
+		APIVersions:          fs.APIVersions,
+		DiffArgs:             fs.DiffArgs,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	cluster, namespace, err := clusterIdentity(fs)
+	if err != nil {
+		return nil, "", fmt.Errorf("identifying the cluster: %w", err)
+	}
+
+	build, err := runBuild(ctx, fs, "--embed-values")
+	if err != nil {
+		return nil, "", fmt.Errorf("running helmfile build: %w", err)
+	}
+
+	output, err := removeNondeterministicBuildLogLines(build.Output)
+	if err != nil {
+		return nil, "", err
+	}
+
+	specs, err := parseBuildReleases(output)
+	if err != nil {
+		return nil, "", err
+	}
+
+	revisions, err := helmReleaseRevisions(fs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	releases := make([]diffCacheRelease, 0, len(specs))
+	for _, s := range specs {
+		r := diffCacheRelease{listedRelease: s.listedRelease}
+
+		live := r.listedRelease
+		if live.Namespace == "" {
+			live.Namespace = namespace
+		}
+		r.Revision = revisions[live.ID()]
+
+		key := sha256.New()
+		fmt.Fprintf(key, "%s\n%s\n%s\n", r.ID(), s.Hash, cluster)
+		r.Key = fmt.Sprintf("%x", key.Sum(nil))
+
+		releases = append(releases, r)
+	}
+
+	return releases, settings, nil
+}
+
+// diffCacheHash returns the SHA-256 of the JSON of the value, which has the map keys sorted.
+// Unlike HashObject, its collisions can't make the cache reuse the verdict of another spec.
+func diffCacheHash(v interface{}) (string, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(bs)), nil
+}
+
+// buildRelease is a release in the helmfile build output, along with the hash of its spec
+type buildRelease struct {
+	listedRelease
+
+	// Hash is the hash of the release spec, which includes the chart, the version and the embedded values,
+	// and the other keys of the helmfile part like helmDefaults and repositories that affect all its releases
+	Hash string
+
+	Chart   string
+	Version string
+
+	// Repository is the repository of the helmfile part that the chart is prefixed with, if any
+	Repository *chartRepository
+}
+
+// chartRepository is a repository in the helmfile build output
+type chartRepository struct {
+	Name string
+	URL  string
+	OCI  bool
+}
+
+// parseBuildReleases parses the releases of the helmfile build --embed-values output in the order of the helmfile.
+// The documents that aren't maps, like the logs preceding the first document, are skipped.
+func parseBuildReleases(output string) ([]buildRelease, error) {
+	var releases []buildRelease
+	index := map[string]int{}
+
+	dec := yaml.NewDecoder(strings.NewReader(output))
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing helmfile build output: %w", err)
+		}
+
+		part, ok := stringKeyedValues(doc).(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		items, _ := part["releases"].([]interface{})
+		delete(part, "releases")
+
+		repositories := map[string]chartRepository{}
+		repositoryItems, _ := part["repositories"].([]interface{})
+		for _, item := range repositoryItems {
+			spec, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			repo := chartRepository{}
+			repo.Name, _ = spec["name"].(string)
+			repo.URL, _ = spec["url"].(string)
+			repo.OCI, _ = spec["oci"].(bool)
+
+			repositories[repo.Name] = repo
+		}
+
+		partHash, err := diffCacheHash(part)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			spec, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			r := buildRelease{}
+			r.Name, _ = spec["name"].(string)
+			r.Namespace, _ = spec["namespace"].(string)
+			r.Chart, _ = spec["chart"].(string)
+			if version, ok := spec["version"]; ok && version != nil {
+				// Unquoted versions like 1.2 are decoded as numbers
+				r.Version = fmt.Sprintf("%v", version)
+			}
+
+			if i := strings.Index(r.Chart, "/"); i > 0 {
+				if repo, ok := repositories[r.Chart[:i]]; ok {
+					r.Repository = &repo
+				}
+			}
+
+			specHash, err := diffCacheHash(spec)
+			if err != nil {
+				return nil, err
+			}
+			r.Hash = partHash + specHash
+
+			// A later part overrides the release like in helmfile, while keeping its position
+			if i, ok := index[r.ID()]; ok {
+				releases[i] = r
+				continue
+			}
+
+			index[r.ID()] = len(releases)
+			releases = append(releases, r)
+		}
+	}
+
+	return releases, nil
+}
+
+// clusterIdentity returns the current context and the server of its cluster in the kubeconfig, and the namespace
+// that helm uses for the releases without one
+func clusterIdentity(fs *ReleaseSet) (string, string, error) {
+	k, err := resolveKubeconfig(fs)
+	if err != nil {
+		return "", "", err
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(k.Path)}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return "", "", err
+	}
+
+	current, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return "", "", fmt.Errorf("current context %q not found in %s", config.CurrentContext, k.Path)
+	}
+
+	cluster, ok := config.Clusters[current.Cluster]
+	if !ok {
+		return "", "", fmt.Errorf("cluster %q of the current context not found in %s", current.Cluster, k.Path)
+	}
+
+	namespace := current.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	return config.CurrentContext + " " + cluster.Server, namespace, nil
+}
+
+// helmReleaseRevisions returns the revisions of the releases in the cluster by namespace/name, with a single helm list
+func helmReleaseRevisions(fs *ReleaseSet) (map[string]int, error) {
+	out, err := runReleaseSetHelm(context.Background(), fs, "list", "--all-namespaces", "--all", "--max", "0", "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("listing helm releases: %w", err)
+	}
+
+	var listed []struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		Revision  string `json:"revision"`
+	}
+	if err := json.Unmarshal([]byte(out), &listed); err != nil {
+		return nil, fmt.Errorf("parsing helm list output: %w", err)
+	}
+
+	revisions := map[string]int{}
+	for _, r := range listed {
+		revision, err := strconv.Atoi(r.Revision)
+		if err != nil {
+			return nil, fmt.Errorf("parsing revision %q of release %s/%s: %w", r.Revision, r.Namespace, r.Name, err)
+		}
+
+		revisions[listedRelease{Name: r.Name, Namespace: r.Namespace}.ID()] = revision
+	}
+
+	return revisions, nil
+}
+
+// diffCacheSelectors returns the selectors that match only the given releases among the ones matched by the selectors
+// of the release set, by ANDing the selector of each release into each of them
+func diffCacheSelectors(fs *ReleaseSet, releases []diffCacheRelease) []interface{} {
+	var base []string
+	for _, s := range effectiveSelectors(fs) {
+		base = append(base, fmt.Sprintf("%s", s))
+	}
+
+	var selectors []interface{}
+	for _, r := range releases {
+		if len(base) == 0 {
+			selectors = append(selectors, r.Selector())
+			continue
+		}
+
+		for _, s := range base {
+			selectors = append(selectors, s+","+r.Selector())
+		}
+	}
+
+	return selectors
+}
+
+// withSelectors returns a copy of the release set whose selectors are replaced with the given ones
+func withSelectors(fs *ReleaseSet, selectors []interface{}) *ReleaseSet {
+	copied := *fs
+	copied.Selector = nil
+	copied.Selectors = selectors
+	copied.DefaultSelectors = nil
+	return &copied
+}
+
+// diffSections splits the helmfile diff output into the sections of the releases by namespace/name.
+// Each section starts with the "Comparing release=" header and has no trailing empty lines.
+func diffSections(output string) map[string][]string {
+	sections := map[string][]string{}
+
+	for _, r := range parseDiff(output) {
+		lines := r.Lines
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+
+		header := fmt.Sprintf("Comparing release=%s, chart=%s, namespace=%s", r.Name, r.Chart, r.Namespace)
+
+		sections[listedRelease{Name: r.Name, Namespace: r.Namespace}.ID()] = append([]string{header}, lines...)
+	}
+
+	return sections
+}
+
+// assembleCachedDiff joins the sections of the compared releases in the order of the helmfile.
+// It is empty when no release has changes, like the output of helmfile diff that exits with 0.
+func assembleCachedDiff(releases []diffCacheRelease, entries map[string]diffCacheEntry) string {
+	var lines []string
+	var changed bool
+
+	for _, r := range releases {
+		e := entries[r.ID()]
+		if !e.Compared {
+			continue
+		}
+
+		lines = append(lines, e.Lines...)
+		changed = changed || e.changed()
+	}
+
+	if !changed {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func diffCacheEntryPath(dir string, r listedRelease) string {
+	return filepath.Join(dir, strings.TrimSuffix(diffFileName(r.Name, r.Namespace), diffFileExt)+diffCacheEntryExt)
+}
+
+// loadDiffCache returns the valid entries of the releases by namespace/name.
+// No entry is valid when the settings differ from the ones of the cached run.
+func loadDiffCache(fs *ReleaseSet, settings string, releases []diffCacheRelease) map[string]diffCacheEntry {
+	entries := map[string]diffCacheEntry{}
+
+	bs, err := ioutil.ReadFile(filepath.Join(fs.DiffCacheDir, diffCacheSettingsFile))
+	if err != nil {
+		return entries
+	}
+
+	var cached struct {
+		Settings string `json:"settings"`
+	}
+	if err := json.Unmarshal(bs, &cached); err != nil || cached.Settings != settings {
+		logf("Ignoring %s as the diff settings changed since the cached run", KeyDiffCacheDir)
+		return entries
+	}
+
+	ttl := fs.DiffCacheTTL
+	if ttl <= 0 {
+		ttl = DefaultDiffCacheTTL
+	}
+
+	for _, r := range releases {
+		bs, err := ioutil.ReadFile(diffCacheEntryPath(fs.DiffCacheDir, r.listedRelease))
+		if err != nil {
+			continue
+		}
+
+		var e diffCacheEntry
+		if err := json.Unmarshal(bs, &e); err != nil {
+			logf("Warning: ignoring the invalid diff cache entry of %s: %v", r.ID(), err)
+			continue
+		}
+
+		switch {
+		case e.Key != r.Key:
+			logf("[DEBUG] Diff cache entry of %s is stale as the release or the cluster changed", r.ID())
+		case e.Revision != r.Revision:
+			logf("[DEBUG] Diff cache entry of %s is stale as the revision moved from %d to %d", r.ID(), e.Revision, r.Revision)
+		case diffCacheNow().Sub(e.CreatedAt) >= ttl:
+			logf("[DEBUG] Diff cache entry of %s expired", r.ID())
+		default:
+			entries[r.ID()] = e
+		}
+	}
+
+	return entries
+}
+
+// saveDiffCache writes the entries of the releases, and then the settings they were computed with
+func saveDiffCache(dir, settings string, releases []diffCacheRelease, entries map[string]diffCacheEntry) error {
+	for _, r := range releases {
+		bs, err := json.MarshalIndent(entries[r.ID()], "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := writeFileAtomic(diffCacheEntryPath(dir, r.listedRelease), bs); err != nil {
+			return err
+		}
+	}
+
+	bs, err := json.Marshal(map[string]string{"settings": settings})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, diffCacheSettingsFile), bs)
+}
+
+// runDiffCommand runs helmfile diff with --detailed-exitcode, returning its stdout as the output and its stderr separately,
+// so that the logs of helmfile and the warnings of helm don't end up in diff_output. It keeps the whole output rather than
+// the tail that sdk.Run keeps. The output is empty when there are no changes unless keepUnchanged, as the diff cache needs
+// the section of every release, in which case a diff whose selectors match no release has no output.
+// diffCommandError is the failure of helmfile diff along with its output, which has the diff of the releases
+// compared before the failure
+type diffCommandError struct {
+	err error
+
+	Output string
+}
+
+func (e *diffCommandError) Error() string {
+	return e.err.Error()
+}
+
+func (e *diffCommandError) Unwrap() error {
+	return e.err
+}
+
+func runDiffCommand(ctx *sdk.Context, cmd *exec.Cmd, keepUnchanged bool) (*State, error) {
+	defer closeExtraFiles(cmd)
+
+	if cmd.Cancel == nil {
+		cmd = commandWithContext(shutdownCtx, cmd)
+	}
+
+	if ctx != nil && ctx.Creds != nil {
+		cmd.Env = append(cmd.Env,
+			"AWS_SESSION_TOKEN="+*ctx.Creds.SessionToken,
+			"AWS_SECRET_ACCESS_KEY="+*ctx.Creds.SecretAccessKey,
+			"AWS_ACCESS_KEY_ID="+*ctx.Creds.AccessKeyId,
+		)
+	}
+
+	streams := &outputStreams{}
+	streams.attach(cmd)
+
+	state := NewState()
+
+	err := cmd.Run()
+
+	state.Stderr = streams.stderr.String()
+
+	if err != nil {
+		if keepUnchanged && strings.Contains(streams.combined.String(), "no releases found that matches specified selector") {
+			return state, nil
+		}
+
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+			return nil, &diffCommandError{
+				err:    fmt.Errorf("%s: %v\n%s", cmd.Path, err, streams.combined.String()),
+				Output: streams.stdout.String(),
+			}
+		}
+	} else if !keepUnchanged {
+		return state, nil
+	}
+
+	state.Output = streams.stdout.String()
+
+	return state, nil
+}
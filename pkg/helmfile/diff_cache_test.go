@@ -0,0 +1,305 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// fakeDiffHelmfile prints build.yaml for helmfile build, and for helmfile diff compares the releases matched by
+// the name= labels of the selectors, or all of them for a selector without one, printing changed-<release> for the
+// ones with changes. The selectors of each diff are appended to diff-calls.
+const fakeDiffHelmfile = `#!/bin/sh
+dir=$(dirname "$0")
+selectors=""
+cmd=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --selector) selectors="$selectors $2"; shift 2;;
+    --file|--helm-binary|--environment|--state-values-file|--concurrency|--context|--set|--values) shift 2;;
+    build|diff) cmd=$1; shift;;
+    *) shift;;
+  esac
+done
+if [ "$cmd" = build ]; then
+  cat "$dir/build.yaml"
+  exit 0
+fi
+echo "$selectors" >> "$dir/diff-calls"
+changed=0
+for r in app db web; do
+  matched=no
+  [ -z "$selectors" ] && matched=yes
+  for s in $selectors; do
+    case "$s" in *name=$r,*) matched=yes;; *name=*) ;; *) matched=yes;; esac
+  done
+  [ $matched = yes ] || continue
+  echo "Comparing release=$r, chart=charts/$r, namespace=default"
+  if [ -f "$dir/changed-$r" ]; then
+    cat "$dir/changed-$r"
+    changed=1
+  fi
+done
+[ $changed = 1 ] && exit 2
+exit 0
+`
+
+const fakeDiffHelm = `#!/bin/sh
+cat "$(dirname "$0")/revisions.json"
+`
+
+const diffCacheBuild = `---
+#  Source: helmfile.yaml
+
+filepath: helmfile.yaml
+helmDefaults:
+  wait: true
+releases:
+- name: app
+  namespace: default
+  chart: charts/app
+  version: 1.0.0
+  values:
+  - replicas: 2
+- name: db
+  namespace: default
+  chart: charts/db
+  version: 2.0.0
+- name: web
+  namespace: default
+  chart: charts/web
+  version: 3.0.0
+  values:
+  - image: web:1
+`
+
+type diffCacheFixture struct {
+	t   *testing.T
+	dir string
+	fs  *ReleaseSet
+}
+
+func newDiffCacheFixture(t *testing.T) *diffCacheFixture {
+	dir := t.TempDir()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	f := &diffCacheFixture{t: t, dir: dir}
+
+	f.write("helmfile", fakeDiffHelmfile, 0755)
+	f.write("helm", fakeDiffHelm, 0755)
+	f.write("kubeconfig", testKubeconfig, 0644)
+	f.write("build.yaml", diffCacheBuild, 0644)
+	f.write("revisions.json", `[{"name":"app","namespace":"default","revision":"3"},{"name":"db","namespace":"default","revision":"1"}]`, 0644)
+	f.write("changed-app", `default, app, Deployment (apps) has changed:
+-   replicas: 1
++   replicas: 2
+`, 0644)
+
+	working := filepath.Join(dir, "work")
+	if err := os.MkdirAll(working, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f.fs = &ReleaseSet{
+		Bin:              filepath.Join(dir, "helmfile"),
+		HelmBin:          filepath.Join(dir, "helm"),
+		Content:          "releases: []\n",
+		WorkingDirectory: working,
+		Kubeconfig:       filepath.Join(dir, "kubeconfig"),
+		Selectors:        []interface{}{"tier=backend"},
+	}
+
+	return f
+}
+
+func (f *diffCacheFixture) write(name, content string, mode os.FileMode) {
+	if err := ioutil.WriteFile(filepath.Join(f.dir, name), []byte(content), mode); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// diffCalls returns the selectors of the helmfile diff runs since the last call
+func (f *diffCacheFixture) diffCalls() []string {
+	path := filepath.Join(f.dir, "diff-calls")
+
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		f.t.Fatal(err)
+	}
+	os.Remove(path)
+
+	var calls []string
+	for _, l := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+		calls = append(calls, strings.TrimSpace(l))
+	}
+	return calls
+}
+
+func (f *diffCacheFixture) diff(cached bool) string {
+	fs := *f.fs
+	if cached {
+		fs.DiffCacheDir = filepath.Join(f.dir, "cache")
+	}
+
+	state, err := runCachedDiff(&sdk.Context{}, &fs, DiffConfig{})
+	if err != nil {
+		f.t.Fatalf("unexpected error: %v", err)
+	}
+
+	return state.Output
+}
+
+func TestRunCachedDiffAgreesWithUncachedDiff(t *testing.T) {
+	f := newDiffCacheFixture(t)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t.Cleanup(func() { diffCacheNow = time.Now })
+	diffCacheNow = func() time.Time { return now }
+
+	allReleases := []string{"tier=backend,name=app,namespace=default tier=backend,name=db,namespace=default tier=backend,name=web,namespace=default"}
+
+	uncached := f.diff(false)
+	if !strings.Contains(uncached, "+   replicas: 2") {
+		t.Fatalf("expected the uncached diff to have the changes of app, got:\n%s", uncached)
+	}
+	f.diffCalls()
+
+	check := func(name string, wantCalls []string) {
+		t.Helper()
+
+		if got := f.diff(true); got != uncached {
+			t.Errorf("%s: expected the cached diff to agree with the uncached one:\nwant:\n%s\ngot:\n%s", name, uncached, got)
+		}
+
+		if got := f.diffCalls(); !reflect.DeepEqual(got, wantCalls) {
+			t.Errorf("%s: unexpected helmfile diff runs:\nwant: %q\ngot:  %q", name, wantCalls, got)
+		}
+	}
+
+	check("cold cache", allReleases)
+	check("warm cache", nil)
+
+	f.write("revisions.json", `[{"name":"app","namespace":"default","revision":"3"},{"name":"db","namespace":"default","revision":"2"}]`, 0644)
+	check("revision moved", []string{"tier=backend,name=db,namespace=default"})
+
+	f.write("build.yaml", strings.Replace(diffCacheBuild, "image: web:1", "image: web:2", 1), 0644)
+	check("values changed", []string{"tier=backend,name=web,namespace=default"})
+
+	now = now.Add(DefaultDiffCacheTTL)
+	check("expired", allReleases)
+
+	f.fs.DiffCacheTTL = 2 * DefaultDiffCacheTTL
+	now = now.Add(DefaultDiffCacheTTL)
+	check("custom ttl", nil)
+
+	f.fs.Selectors = []interface{}{"tier=frontend"}
+	uncached = f.diff(false)
+	f.diffCalls()
+	check("settings changed", []string{"tier=frontend,name=app,namespace=default tier=frontend,name=db,namespace=default tier=frontend,name=web,namespace=default"})
+}
+
+func TestRunCachedDiffWithoutChanges(t *testing.T) {
+	f := newDiffCacheFixture(t)
+
+	if err := os.Remove(filepath.Join(f.dir, "changed-app")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.diff(true); got != "" {
+		t.Errorf("expected no output without changes, got:\n%s", got)
+	}
+
+	if got := f.diff(true); got != "" {
+		t.Errorf("expected no output from the cache without changes, got:\n%s", got)
+	}
+
+	if calls := f.diffCalls(); len(calls) != 1 {
+		t.Errorf("expected the releases without changes to be cached, got the runs %q", calls)
+	}
+}
+
+func TestRunCachedDiffFallback(t *testing.T) {
+	f := newDiffCacheFixture(t)
+
+	// The revisions can't be looked up, so the diff runs for all the releases with the selectors of the release set
+	f.write("revisions.json", "Error: Kubernetes cluster unreachable", 0644)
+
+	if got, want := f.diff(true), f.diff(false); got != want {
+		t.Errorf("expected the diff without the cache:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	if calls := f.diffCalls(); !reflect.DeepEqual(calls, []string{"tier=backend", "tier=backend"}) {
+		t.Errorf("unexpected helmfile diff runs: %q", calls)
+	}
+}
+
+func TestRunDiffCommandSeparatesStderr(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "helmfile")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho 'Comparing release=app'\necho 'WARNING: deprecated chart' >&2\nexit 2\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := runDiffCommand(nil, exec.Command(script), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if state.Output != "Comparing release=app\n" {
+		t.Errorf("expected only the stdout in the output, got %q", state.Output)
+	}
+
+	if state.Stderr != "WARNING: deprecated chart\n" {
+		t.Errorf("expected the stderr to be kept separately, got %q", state.Stderr)
+	}
+}
+
+func TestParseBuildReleases(t *testing.T) {
+	releases, err := parseBuildReleases("Adding repo stable https://charts.helm.sh/stable\n" + diffCacheBuild + `---
+releases:
+- name: app
+  namespace: default
+  chart: charts/app
+  version: 1.1.0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, r := range releases {
+		ids = append(ids, r.ID())
+	}
+
+	if want := []string{"default/app", "default/db", "default/web"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected the releases in the order of the helmfile, got %v", ids)
+	}
+
+	again, err := parseBuildReleases(diffCacheBuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if releases[0].Hash == again[0].Hash {
+		t.Error("expected the release overridden by a later part to have a different hash")
+	}
+	if releases[1].Hash != again[1].Hash {
+		t.Error("expected the hash of the same release spec to be stable")
+	}
+}
@@ -0,0 +1,33 @@
+package helmfile
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultDiffContext is the number of lines of context of the diffs when diff_context is not set
+const defaultDiffContext = 3
+
+// diffContext returns the number of lines of context of the diffs of the release set
+func diffContext(fs *ReleaseSet) int {
+	if fs.DiffContext > 0 {
+		return fs.DiffContext
+	}
+
+	return defaultDiffContext
+}
+
+// validateDiffSuppressLineRegex validates that each of diff_suppress_line_regex compiles, as helm-diff would
+// otherwise fail on plan with an error that doesn't tell which of the release set's patterns is invalid
+func validateDiffSuppressLineRegex(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	if _, err := regexp.Compile(s); err != nil {
+		return nil, []error{fmt.Errorf("%s: invalid regex %q: %v", k, s, err)}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,56 @@
+package helmfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateDiffSuppressLineRegex(t *testing.T) {
+	if _, errs := validateDiffSuppressLineRegex(`checksum/config`, "diff_suppress_line_regex.0"); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	_, errs := validateDiffSuppressLineRegex(`rollme: (`, "diff_suppress_line_regex.1")
+	if len(errs) != 1 {
+		t.Fatalf("expected an error for the invalid regex, got %v", errs)
+	}
+
+	if msg := errs[0].Error(); !strings.Contains(msg, `"rollme: ("`) || !strings.Contains(msg, "diff_suppress_line_regex.1") {
+		t.Errorf("expected the error to name the pattern and the attribute, got %q", msg)
+	}
+}
+
+func TestDiffOutputFlags(t *testing.T) {
+	tests := []struct {
+		fs       *ReleaseSet
+		expected []string
+	}{
+		{
+			fs:       &ReleaseSet{},
+			expected: []string{"--suppress-secrets", "--context", "3"},
+		},
+		{
+			fs: &ReleaseSet{DiffContext: 1, DiffSuppressLineRegex: []string{`checksum/`, `rollme:`}},
+			expected: []string{"--suppress-secrets", "--context", "1",
+				"--suppress-output-line-regex", "checksum/", "--suppress-output-line-regex", "rollme:"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := diffOutputFlags(tt.fs); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("expected %v, got %v", tt.expected, got)
+		}
+
+		apply := buildApplyOptions(tt.fs, &helmfileFiles{Path: "helmfile.yaml"})
+		diff := buildDiffOptions(tt.fs, &helmfileFiles{Path: "helmfile.yaml"}, 0)
+
+		if apply.Context != diffContext(tt.fs) || diff.Context != diffContext(tt.fs) {
+			t.Errorf("expected context %d, got %d on apply and %d on diff", diffContext(tt.fs), apply.Context, diff.Context)
+		}
+
+		if !reflect.DeepEqual(apply.SuppressOutputLineRegex, tt.fs.DiffSuppressLineRegex) || !reflect.DeepEqual(diff.SuppressOutputLineRegex, tt.fs.DiffSuppressLineRegex) {
+			t.Errorf("expected the regexes %v, got %v on apply and %v on diff", tt.fs.DiffSuppressLineRegex, apply.SuppressOutputLineRegex, diff.SuppressOutputLineRegex)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package helmfile
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// diffReleasePattern matches the header that helmfile prints before the helm-diff output of each release
+	diffReleasePattern = regexp.MustCompile(`^Comparing release=([^,]*), chart=([^,]*), namespace=(.*)$`)
+
+	// diffObjectPattern matches the header that helm-diff prints before the hunks of each Kubernetes object,
+	// like `default, app, Deployment (apps) has changed:`. The namespace is empty for cluster-scoped objects.
+	diffObjectPattern = regexp.MustCompile(`^([^,\s]*), ([^,\s]+), (\S+) \(([^)]*)\) has (changed|been added|been removed):$`)
+)
+
+// diffRelease is the part of the helmfile-diff output for a release
+type diffRelease struct {
+	Name      string
+	Chart     string
+	Namespace string
+
+	// Lines are the lines following the release header, including the ones of the objects
+	Lines []string
+
+	// Objects are the objects with changes in the release
+	Objects []diffObject
+}
+
+// changed returns true when helm-diff printed anything for the release.
+// The notes of the releases whose diff was skipped, appended after the last release, are not part of its diff.
+func (r diffRelease) changed() bool {
+	for _, l := range r.Lines {
+		if strings.TrimSpace(l) != "" && !strings.HasPrefix(l, missingCRDDiffNotePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffObject is the part of the helmfile-diff output for a Kubernetes object
+type diffObject struct {
+	Namespace string
+	Name      string
+	Kind      string
+
+	// Lines are the object header and its hunks
+	Lines []string
+}
+
+// parseDiff splits the helmfile-diff output into releases and their objects.
+// Lines preceding the first release header, like the logs of repository updates, are dropped.
+func parseDiff(diff string) []diffRelease {
+	var releases []diffRelease
+
+	for _, l := range strings.Split(diff, "\n") {
+		if m := diffReleasePattern.FindStringSubmatch(l); m != nil {
+			releases = append(releases, diffRelease{Name: m[1], Chart: m[2], Namespace: m[3]})
+			continue
+		}
+
+		if len(releases) == 0 {
+			continue
+		}
+
+		r := &releases[len(releases)-1]
+		r.Lines = append(r.Lines, l)
+
+		if m := diffObjectPattern.FindStringSubmatch(l); m != nil {
+			r.Objects = append(r.Objects, diffObject{Namespace: m[1], Name: m[2], Kind: m[3], Lines: []string{l}})
+			continue
+		}
+
+		if len(r.Objects) > 0 {
+			o := &r.Objects[len(r.Objects)-1]
+			o.Lines = append(o.Lines, l)
+		}
+	}
+
+	return releases
+}
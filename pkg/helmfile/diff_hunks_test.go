@@ -0,0 +1,73 @@
+package helmfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testDiff = `Adding repo sp https://stefanprodan.github.io/podinfo
+"sp" has been added to your repositories
+
+Comparing release=app, chart=sp/podinfo, namespace=default
+default, app, Deployment (apps) has changed:
+  # Source: podinfo/templates/deployment.yaml
+-   replicas: 1
++   replicas: 2
+, app-reader, ClusterRole (rbac.authorization.k8s.io) has been added:
++ kind: ClusterRole
+default, app, Service (v1) has been removed:
+- kind: Service
+
+Comparing release=exporter, chart=charts/exporter, namespace=monitoring
+`
+
+func TestParseDiff(t *testing.T) {
+	releases := parseDiff(testDiff)
+
+	if len(releases) != 2 {
+		t.Fatalf("expected 2 releases, got %d: %+v", len(releases), releases)
+	}
+
+	app := releases[0]
+	if app.Name != "app" || app.Chart != "sp/podinfo" || app.Namespace != "default" || !app.changed() {
+		t.Errorf("unexpected release: %+v", app)
+	}
+
+	want := []diffObject{
+		{
+			Namespace: "default", Name: "app", Kind: "Deployment",
+			Lines: []string{
+				"default, app, Deployment (apps) has changed:",
+				"  # Source: podinfo/templates/deployment.yaml",
+				"-   replicas: 1",
+				"+   replicas: 2",
+			},
+		},
+		{
+			Namespace: "", Name: "app-reader", Kind: "ClusterRole",
+			Lines: []string{
+				", app-reader, ClusterRole (rbac.authorization.k8s.io) has been added:",
+				"+ kind: ClusterRole",
+			},
+		},
+		{
+			Namespace: "default", Name: "app", Kind: "Service",
+			Lines: []string{
+				"default, app, Service (v1) has been removed:",
+				"- kind: Service",
+				"",
+			},
+		},
+	}
+	if !reflect.DeepEqual(app.Objects, want) {
+		t.Errorf("unexpected objects:\nwant: %+v\ngot:  %+v", want, app.Objects)
+	}
+
+	if exporter := releases[1]; exporter.Name != "exporter" || exporter.changed() || len(exporter.Objects) != 0 {
+		t.Errorf("expected exporter without changes, got %+v", exporter)
+	}
+
+	if got := countChangedReleases(testDiff); got != 1 {
+		t.Errorf("expected 1 changed release, got %d", got)
+	}
+}
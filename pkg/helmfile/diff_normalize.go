@@ -0,0 +1,116 @@
+package helmfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultIgnoreDiffPatterns match the changed lines that differ on every render of many charts, whatever the inputs.
+// They are matched against the lines without the leading + or -.
+var defaultIgnoreDiffPatterns = []*regexp.Regexp{
+	// checksum/config and the like, the hashes of the rendered ConfigMaps and Secrets, which change along with them anyway
+	regexp.MustCompile(`^\s*checksum/[^:\s]+:`),
+	// rollme annotations set to randAlphaNum to roll the pods on every upgrade
+	regexp.MustCompile(`^\s*rollme:`),
+	// Timestamps of the render, like kubectl.kubernetes.io/restartedAt or deployTimestamp
+	regexp.MustCompile(`^\s*["']?[\w./-]*(?i:timestamp|restartedAt|deployedAt|deployTime)["']?:`),
+}
+
+// isChangedDiffLine returns true for the lines that helm-diff prints for the added and removed lines of the objects
+func isChangedDiffLine(l string) bool {
+	return strings.HasPrefix(l, "+") || strings.HasPrefix(l, "-")
+}
+
+// normalizeDiff drops the changed lines of the helmfile-diff output that match the default noisy patterns or any of
+// ignoreRegex, along with the objects left with no changed lines. It returns an empty string when no changed line is
+// left, so that the release set is treated as unchanged. Otherwise the lines other than the ones of the objects,
+// like the headers of the releases, are kept as is.
+func normalizeDiff(diff string, ignoreRegex []string) (string, error) {
+	patterns := append([]*regexp.Regexp{}, defaultIgnoreDiffPatterns...)
+
+	for _, r := range ignoreRegex {
+		p, err := regexp.Compile(r)
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid regex %q: %w", KeyIgnoreDiffRegex, r, err)
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	ignored := func(l string) bool {
+		for _, p := range patterns {
+			if p.MatchString(l[1:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var (
+		normalized []string
+
+		// object holds the lines of the object being read, which is dropped when none of its changed lines is left
+		object  []string
+		changes int
+		total   int
+	)
+
+	flush := func() {
+		if changes > 0 {
+			normalized = append(normalized, object...)
+			total += changes
+		}
+
+		object, changes = nil, 0
+	}
+
+	for _, l := range strings.Split(diff, "\n") {
+		switch {
+		case diffObjectPattern.MatchString(l):
+			flush()
+			object = []string{l}
+		case diffReleasePattern.MatchString(l):
+			flush()
+			normalized = append(normalized, l)
+		case isChangedDiffLine(l) && ignored(l):
+		case object == nil:
+			// helm-diff prints the changed lines only in the objects, unless its output format has no object headers
+			normalized = append(normalized, l)
+			if isChangedDiffLine(l) {
+				total++
+			}
+		default:
+			object = append(object, l)
+			if isChangedDiffLine(l) {
+				changes++
+			}
+		}
+	}
+
+	flush()
+
+	if total == 0 {
+		return "", nil
+	}
+
+	return strings.Join(normalized, "\n"), nil
+}
+
+// setRawDiffOutput records the helmfile-diff output before normalizeDiff for debugging, along with the normalized
+// diff_output. It is not recorded when the outputs are kept out of the state or the plan, as it has the same contents.
+func setRawDiffOutput(d ResourceReadWrite, fs *ReleaseSet, raw string, maxLen int) {
+	if fs.SensitiveOutputs || outputsInFiles(fs) {
+		logf("[DEBUG] helmfile-diff output before normalization:\n%s", raw)
+		return
+	}
+
+	raw = truncateOutput(raw, maxLen, "helmfile-diff output", KeyMaxDiffOutputLen)
+
+	if fs.ShowSecrets {
+		raw = redactSecretChanges(raw, KeySensitiveDiffOutput)
+	}
+
+	d.Set(KeyRawDiffOutput, raw)
+}
@@ -0,0 +1,84 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDiffDropsChecksumChurn(t *testing.T) {
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "diff_normalize", "checksum_churn.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := normalizeDiff(string(bs), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "" {
+		t.Errorf("expected no changes left after normalization, got:\n%s", got)
+	}
+}
+
+func TestNormalizeDiff(t *testing.T) {
+	diff := `Comparing release=app, chart=charts/app, namespace=default
+default, app-config, ConfigMap (v1) has changed:
+  # Source: app/templates/configmap.yaml
+  data:
+-   generatedAt: "2024-05-02"
++   generatedAt: "2024-05-03"
+
+default, app, Deployment (apps) has changed:
+  # Source: app/templates/deployment.yaml
+-         checksum/config: abc
++         checksum/config: def
+-       image: app:v1
++       image: app:v2
+`
+
+	got, err := normalizeDiff(diff, []string{`^\s*generatedAt:`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+  # Source: app/templates/deployment.yaml
+-       image: app:v1
++       image: app:v2
+`
+
+	if got != expected {
+		t.Errorf("unexpected normalized diff:\n%s", got)
+	}
+
+	// The churn is kept as a change without the user-supplied pattern
+	if got, err := normalizeDiff(diff, nil); err != nil || !strings.Contains(got, "generatedAt") {
+		t.Errorf("expected generatedAt to be kept without ignore_diff_regex, got %q, %v", got, err)
+	}
+
+	if _, err := normalizeDiff(diff, []string{`(`}); err == nil || !strings.Contains(err.Error(), KeyIgnoreDiffRegex) {
+		t.Errorf("expected an error naming %s for the invalid regex, got %v", KeyIgnoreDiffRegex, err)
+	}
+}
+
+func TestSetRawDiffOutput(t *testing.T) {
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	setRawDiffOutput(d, &ReleaseSet{}, "raw", 0)
+
+	if got := d.Get(KeyRawDiffOutput); got != "raw" {
+		t.Errorf("expected raw_diff_output, got %v", got)
+	}
+
+	d = &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	setRawDiffOutput(d, &ReleaseSet{SensitiveOutputs: true}, "raw", 0)
+
+	if got := d.Get(KeyRawDiffOutput); got != nil {
+		t.Errorf("expected no raw_diff_output with sensitive_outputs, got %v", got)
+	}
+}
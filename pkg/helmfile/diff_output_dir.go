@@ -0,0 +1,103 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	SplitByRelease = "release"
+	SplitByObject  = "object"
+
+	diffFileExt = ".diff"
+)
+
+var unsafeDiffFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// diffFileName joins the parts like release--namespace--kind--name.diff, replacing the characters unsafe in file names
+func diffFileName(parts ...string) string {
+	for i, p := range parts {
+		parts[i] = unsafeDiffFileNameChars.ReplaceAllString(p, "_")
+	}
+
+	return strings.Join(parts, "--") + diffFileExt
+}
+
+// splitDiff splits the helmfile-diff output into the contents of the files keyed by file name,
+// one per release or per Kubernetes object depending on splitBy
+func splitDiff(diff, splitBy string) map[string]string {
+	files := map[string]string{}
+
+	for _, r := range parseDiff(diff) {
+		if !r.changed() {
+			continue
+		}
+
+		if splitBy != SplitByObject || len(r.Objects) == 0 {
+			files[diffFileName(r.Name, r.Namespace)] = strings.Join(r.Lines, "\n")
+			continue
+		}
+
+		for _, o := range r.Objects {
+			namespace := o.Namespace
+			if namespace == "" {
+				namespace = r.Namespace
+			}
+
+			name := diffFileName(r.Name, namespace, o.Kind, o.Name)
+			files[name] += strings.Join(o.Lines, "\n")
+		}
+	}
+
+	return files
+}
+
+// writeDiffOutputDir writes the helmfile-diff output split into files into dir, and returns the paths to the files.
+// The .diff files from previous runs that are no longer part of the diff are removed, and any other file is left as is.
+func writeDiffOutputDir(dir, diff, splitBy string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", dir, err)
+	}
+
+	files := splitDiff(diff, splitBy)
+
+	stale, err := filepath.Glob(filepath.Join(dir, "*"+diffFileExt))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range stale {
+		if _, ok := files[filepath.Base(f)]; ok {
+			continue
+		}
+
+		if err := os.Remove(f); err != nil {
+			return nil, fmt.Errorf("removing stale diff file: %w", err)
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		content := strings.TrimRight(files[name], "\n") + "\n"
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("writing diff file: %w", err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,76 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiffOutputDir(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"removed--default.diff": "stale",
+		"README.md":             "not ours",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := writeDiffOutputDir(dir, testDiff, SplitByRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{filepath.Join(dir, "app--default.diff")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("unexpected files: want %v, got %v", want, files)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "removed--default.diff")); !os.IsNotExist(err) {
+		t.Errorf("expected the stale diff file to be removed, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "README.md")); err != nil {
+		t.Errorf("expected other files to be left as is, got %v", err)
+	}
+
+	files, err = writeDiffOutputDir(dir, testDiff, SplitByObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "app--default--ClusterRole--app-reader.diff"),
+		filepath.Join(dir, "app--default--Deployment--app.diff"),
+		filepath.Join(dir, "app--default--Service--app.diff"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("unexpected files: want %v, got %v", want, files)
+	}
+
+	bs, err := ioutil.ReadFile(want[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := string(bs); !strings.HasPrefix(content, "default, app, Deployment (apps) has changed:\n") || !strings.Contains(content, "+   replicas: 2\n") {
+		t.Errorf("unexpected content:\n%s", content)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "app--default.diff")); !os.IsNotExist(err) {
+		t.Errorf("expected the per-release file of the previous run to be removed, got %v", err)
+	}
+
+	if files, err := writeDiffOutputDir(dir, "", SplitByObject); err != nil || len(files) != 0 {
+		t.Errorf("expected no files without diff, got %v, %v", files, err)
+	}
+}
+
+func TestDiffFileName(t *testing.T) {
+	if got := diffFileName("app", "default", "Deployment", "app:v1/x"); got != "app--default--Deployment--app_v1_x.diff" {
+		t.Errorf("unexpected file name %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package helmfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DiffThresholdModeError = "error"
+	DiffThresholdModeWarn  = "warn"
+)
+
+// maxOffendingReleases is the number of releases with the most changes that are listed when a threshold is exceeded
+const maxOffendingReleases = 5
+
+// diffThresholdError is the error of a plan whose diff exceeds max_changed_objects or max_diff_lines
+type diffThresholdError struct {
+	msg string
+}
+
+func (e *diffThresholdError) Error() string {
+	return e.msg
+}
+
+// releaseDiffSize is the size of the diff of a release
+type releaseDiffSize struct {
+	ID             string
+	ChangedObjects int
+	DiffLines      int
+}
+
+// diffSizes returns the number of changed objects and added or removed lines of each release in the helmfile-diff output
+func diffSizes(diff string) []releaseDiffSize {
+	var sizes []releaseDiffSize
+
+	for _, r := range parseDiff(diff) {
+		size := releaseDiffSize{
+			ID:             fmt.Sprintf("%s/%s", r.Namespace, r.Name),
+			ChangedObjects: len(r.Objects),
+		}
+
+		for _, o := range r.Objects {
+			// The first line is the object header
+			for _, l := range o.Lines[1:] {
+				if strings.HasPrefix(l, "+") || strings.HasPrefix(l, "-") {
+					size.DiffLines++
+				}
+			}
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	return sizes
+}
+
+// checkDiffThresholds returns a diffThresholdError when the diff exceeds max_changed_objects or max_diff_lines,
+// or only logs a warning with diff_threshold_mode = "warn". Zero thresholds mean no limit.
+func checkDiffThresholds(fs *ReleaseSet, diff string) error {
+	if fs.MaxChangedObjects <= 0 && fs.MaxDiffLines <= 0 {
+		return nil
+	}
+
+	sizes := diffSizes(diff)
+
+	var total releaseDiffSize
+	for _, s := range sizes {
+		total.ChangedObjects += s.ChangedObjects
+		total.DiffLines += s.DiffLines
+	}
+
+	var exceeded []string
+	if fs.MaxChangedObjects > 0 && total.ChangedObjects > fs.MaxChangedObjects {
+		exceeded = append(exceeded, fmt.Sprintf("%d changed objects exceed %s = %d", total.ChangedObjects, KeyMaxChangedObjects, fs.MaxChangedObjects))
+	}
+	if fs.MaxDiffLines > 0 && total.DiffLines > fs.MaxDiffLines {
+		exceeded = append(exceeded, fmt.Sprintf("%d diff lines exceed %s = %d", total.DiffLines, KeyMaxDiffLines, fs.MaxDiffLines))
+	}
+
+	if len(exceeded) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(sizes, func(i, j int) bool {
+		if sizes[i].ChangedObjects != sizes[j].ChangedObjects {
+			return sizes[i].ChangedObjects > sizes[j].ChangedObjects
+		}
+		return sizes[i].DiffLines > sizes[j].DiffLines
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff is too large: %s. Releases with the most changes:", strings.Join(exceeded, ", "))
+	for i, s := range sizes {
+		if i == maxOffendingReleases || s.ChangedObjects == 0 {
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s: %d changed objects, %d diff lines", s.ID, s.ChangedObjects, s.DiffLines)
+	}
+
+	if fs.DiffThresholdMode == DiffThresholdModeWarn {
+		logf("Warning: %s", b.String())
+		return nil
+	}
+
+	return &diffThresholdError{msg: b.String()}
+}
@@ -0,0 +1,120 @@
+package helmfile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// syntheticDiff returns a helmfile-diff output with the given number of changed ConfigMaps per release,
+// each with one removed and one added line
+func syntheticDiff(objectsPerRelease map[string]int) string {
+	var b strings.Builder
+
+	for _, name := range []string{"small", "large", "medium"} {
+		n, ok := objectsPerRelease[name]
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&b, "Comparing release=%s, chart=charts/%s, namespace=default\n", name, name)
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "default, %s-%d, ConfigMap (v1) has changed:\n", name, i)
+			fmt.Fprintf(&b, "  # Source: %s/templates/configmap.yaml\n", name)
+			b.WriteString("-   key: old\n")
+			b.WriteString("+   key: new\n")
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func TestDiffSizes(t *testing.T) {
+	sizes := diffSizes(testDiff)
+
+	want := []releaseDiffSize{
+		{ID: "default/app", ChangedObjects: 3, DiffLines: 4},
+		{ID: "monitoring/exporter"},
+	}
+
+	if fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("unexpected sizes: want %+v, got %+v", want, sizes)
+	}
+}
+
+func TestCheckDiffThresholds(t *testing.T) {
+	diff := syntheticDiff(map[string]int{"small": 1, "large": 500, "medium": 20})
+
+	tests := []struct {
+		name string
+		fs   *ReleaseSet
+		want string
+	}{
+		{
+			name: "no limits",
+			fs:   &ReleaseSet{},
+		},
+		{
+			name: "within limits",
+			fs:   &ReleaseSet{MaxChangedObjects: 521, MaxDiffLines: 1042},
+		},
+		{
+			name: "too many changed objects",
+			fs:   &ReleaseSet{MaxChangedObjects: 100},
+			want: `diff is too large: 521 changed objects exceed max_changed_objects = 100. Releases with the most changes:
+  default/large: 500 changed objects, 1000 diff lines
+  default/medium: 20 changed objects, 40 diff lines
+  default/small: 1 changed objects, 2 diff lines`,
+		},
+		{
+			name: "too many changed objects and diff lines",
+			fs:   &ReleaseSet{MaxChangedObjects: 100, MaxDiffLines: 1000},
+			want: `diff is too large: 521 changed objects exceed max_changed_objects = 100, 1042 diff lines exceed max_diff_lines = 1000. Releases with the most changes:
+  default/large: 500 changed objects, 1000 diff lines
+  default/medium: 20 changed objects, 40 diff lines
+  default/small: 1 changed objects, 2 diff lines`,
+		},
+		{
+			name: "warn",
+			fs:   &ReleaseSet{MaxChangedObjects: 100, DiffThresholdMode: DiffThresholdModeWarn},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDiffThresholds(tt.fs, diff)
+
+			var got string
+			if err != nil {
+				var thresholdErr *diffThresholdError
+				if !errors.As(err, &thresholdErr) {
+					t.Fatalf("expected a diffThresholdError, got %T", err)
+				}
+				got = err.Error()
+			}
+
+			if got != tt.want {
+				t.Errorf("unexpected error:\nwant: %s\ngot:  %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckDiffThresholdsListsTopReleases(t *testing.T) {
+	objects := map[string]int{"small": 1, "large": 500, "medium": 20}
+	diff := syntheticDiff(objects)
+	for i := 0; i < 10; i++ {
+		diff += fmt.Sprintf("Comparing release=extra-%d, chart=charts/extra, namespace=default\ndefault, extra-%d, Secret (v1) has been added:\n+ kind: Secret\n\n", i, i)
+	}
+
+	err := checkDiffThresholds(&ReleaseSet{MaxChangedObjects: 1}, diff)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := strings.Count(err.Error(), "\n  "); got != maxOffendingReleases {
+		t.Errorf("expected %d releases to be listed, got %d:\n%s", maxOffendingReleases, got, err)
+	}
+}
@@ -0,0 +1,163 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// builtinChartURLSchemes are the chart URL schemes that helm handles without a downloader plugin
+var builtinChartURLSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"oci":   true,
+	"file":  true,
+}
+
+// chartURLSchemePattern matches the scheme of chart URLs like `chart: git+https://github.com/org/repo@charts/app?ref=v1`
+var chartURLSchemePattern = regexp.MustCompile(`(?m)^\s*(?:-\s*)?chart:\s*["']?([a-zA-Z][a-zA-Z0-9+.-]*)://`)
+
+// downloaderPlugin is the part of a helm plugin.yaml that declares the chart URL schemes handled by the plugin
+type downloaderPlugin struct {
+	Name        string `yaml:"name"`
+	Downloaders []struct {
+		Protocols []string `yaml:"protocols"`
+	} `yaml:"downloaders"`
+}
+
+// requiredDownloaderSchemes returns the sorted chart URL schemes in the content that require a helm downloader plugin
+func requiredDownloaderSchemes(content string) []string {
+	seen := map[string]bool{}
+
+	var schemes []string
+
+	for _, m := range chartURLSchemePattern.FindAllStringSubmatch(content, -1) {
+		scheme := strings.ToLower(m[1])
+		if builtinChartURLSchemes[scheme] || seen[scheme] {
+			continue
+		}
+		seen[scheme] = true
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// helmPluginsDir returns the directory helm loads plugins from, honoring HELM_PLUGINS in environment_variables
+func helmPluginsDir(fs *ReleaseSet) (string, error) {
+	if dir, ok := fs.EnvironmentVariables["HELM_PLUGINS"].(string); ok && dir != "" {
+		return dir, nil
+	}
+
+	if dir := os.Getenv("HELM_PLUGINS"); dir != "" {
+		return dir, nil
+	}
+
+	helmBin := fs.HelmBin
+	if helmBin == "" {
+		helmBin = "helm"
+	}
+
+	cmd := exec.Command(helmBin, "env", "HELM_PLUGINS")
+	cmd.Env = append(os.Environ(), readEnvironmentVariables(fs.EnvironmentVariables, "")...)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running %s env HELM_PLUGINS: %w", helmBin, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// installedDownloaderSchemes returns the chart URL schemes supported by the plugins installed in the directory,
+// keyed by the scheme with the plugin name as the value
+func installedDownloaderSchemes(pluginsDir string) (map[string]string, error) {
+	schemes := map[string]string{}
+
+	entries, err := ioutil.ReadDir(pluginsDir)
+	if os.IsNotExist(err) {
+		return schemes, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading helm plugins directory %s: %w", pluginsDir, err)
+	}
+
+	for _, e := range entries {
+		// Plugins installed from local paths are symlinks, so we don't check e.IsDir() here
+		bs, err := ioutil.ReadFile(filepath.Join(pluginsDir, e.Name(), "plugin.yaml"))
+		if err != nil {
+			continue
+		}
+
+		var p downloaderPlugin
+		if err := yaml.Unmarshal(bs, &p); err != nil {
+			logf("Ignoring helm plugin %s with invalid plugin.yaml: %v", e.Name(), err)
+			continue
+		}
+
+		for _, d := range p.Downloaders {
+			for _, protocol := range d.Protocols {
+				schemes[strings.ToLower(protocol)] = p.Name
+			}
+		}
+	}
+
+	return schemes, nil
+}
+
+// downloaderPluginInstallHint returns the command to install a well-known downloader plugin for the scheme
+func downloaderPluginInstallHint(scheme string) string {
+	switch {
+	case strings.HasPrefix(scheme, "git+"):
+		return "helm plugin install https://github.com/aslafy-z/helm-git"
+	case scheme == "s3":
+		return "helm plugin install https://github.com/hypnoglow/helm-s3"
+	case scheme == "gs":
+		return "helm plugin install https://github.com/hayorov/helm-gcs"
+	}
+	return "helm plugin install <a plugin that declares the " + scheme + " protocol in its downloaders>"
+}
+
+// checkDownloaderPlugins fails with an install hint when a chart URL scheme in the content requires
+// a helm downloader plugin that is not installed.
+// It is skipped when the helm plugins directory cannot be determined, leaving the error to helm.
+func checkDownloaderPlugins(fs *ReleaseSet) error {
+	required := requiredDownloaderSchemes(fs.Content)
+	if len(required) == 0 {
+		return nil
+	}
+
+	dir, err := helmPluginsDir(fs)
+	if err != nil {
+		logf("Skipping the helm downloader plugin check: %v", err)
+		return nil
+	}
+
+	installed, err := installedDownloaderSchemes(dir)
+	if err != nil {
+		return err
+	}
+
+	logf("Helm downloader plugins installed in %s: %v", dir, installed)
+
+	var hints []string
+	for _, scheme := range required {
+		if _, ok := installed[scheme]; !ok {
+			hints = append(hints, fmt.Sprintf("%s:// requires a downloader plugin. Install it with: %s", scheme, downloaderPluginInstallHint(scheme)))
+		}
+	}
+
+	if len(hints) > 0 {
+		return fmt.Errorf("missing helm downloader plugins in %s:\n%s", dir, strings.Join(hints, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequiredDownloaderSchemes(t *testing.T) {
+	content := `releases:
+- name: app
+  chart: git+https://github.com/org/repo@charts/app?ref=v1.0.0
+- name: other
+  chart: "git+ssh://git@github.com/org/repo@charts/other?ref=main"
+- name: podinfo
+  chart: oci://ghcr.io/stefanprodan/charts/podinfo
+- name: local
+  chart: ./charts/local
+- name: bucket
+  chart: s3://bucket/charts/app-1.0.0.tgz
+- chart: git+https://github.com/org/repo@charts/third?ref=v1.0.0
+  name: third
+`
+
+	want := []string{"git+https", "git+ssh", "s3"}
+	if got := requiredDownloaderSchemes(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckDownloaderPlugins(t *testing.T) {
+	pluginsDir := t.TempDir()
+
+	helmGit := filepath.Join(pluginsDir, "helm-git")
+	if err := os.MkdirAll(helmGit, 0755); err != nil {
+		t.Fatal(err)
+	}
+	pluginYAML := `name: "helm-git"
+version: "1.3.0"
+downloaders:
+- command: "helm-git"
+  protocols:
+  - "git+file"
+  - "git+http"
+  - "git+https"
+  - "git+ssh"
+`
+	if err := ioutil.WriteFile(filepath.Join(helmGit, "plugin.yaml"), []byte(pluginYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ReleaseSet{
+		Content:              "releases:\n- name: app\n  chart: git+https://github.com/org/repo@charts/app?ref=v1.0.0\n",
+		EnvironmentVariables: map[string]interface{}{"HELM_PLUGINS": pluginsDir},
+	}
+	if err := checkDownloaderPlugins(fs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	fs.Content += "- name: bucket\n  chart: s3://bucket/charts/app-1.0.0.tgz\n"
+	err := checkDownloaderPlugins(fs)
+	if err == nil {
+		t.Fatal("expected error for the missing s3 downloader plugin")
+	}
+	if !strings.Contains(err.Error(), "helm plugin install https://github.com/hypnoglow/helm-s3") {
+		t.Errorf("expected an install hint, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// releaseSetInputKeys are the attributes that change what helmfile diff compares the cluster against
+var releaseSetInputKeys = []string{
+	KeyValues, KeyValuesFiles, KeyContent, KeyPath, KeyWorkingDirectory,
+	KeyEnvironment, KeyEnvironmentVariables, KeyBin, KeyHelmBin,
+	KeySelector, KeySelectors, KeyKubeconfig, KeyKubeconfigContent, KeyDefaultSelectorsHash,
+	KeyCommonLabels, KeyProviderConfigHash, KeyKustomizePatches,
+	KeyDiffEnvironmentVariables, KeySensitiveEnvironmentVariables, KeyEphemeralValuesHash,
+	KeyEKSClusterEndpoint, KeyEKSClusterCA, KeyEKSClusterIdentity,
+}
+
+// detectedDrift is the helmfile diff run on refresh, along with the hash of the inputs it was run for
+type detectedDrift struct {
+	inputs string
+	state  *State
+}
+
+// detectedDrifts are the drifts detected on refresh by the ID of the release set. They live as long as the provider
+// process, which is one Terraform operation, so that the plan following the refresh reuses the diff instead of running it again.
+var detectedDrifts sync.Map
+
+// driftInputsHash returns the hash of the input attributes, which are the same on refresh and on the plan
+// when the configuration hasn't changed
+func driftInputsHash(d ResourceRead) (string, error) {
+	values := make([]interface{}, 0, len(releaseSetInputKeys))
+	for _, key := range releaseSetInputKeys {
+		values = append(values, d.Get(key))
+	}
+
+	return HashObject(values)
+}
+
+// detectDrift runs helmfile diff with the detailed exit code on refresh, and returns the diff when the releases in the
+// cluster have drifted from the release set, or an empty string otherwise. The diff is kept for the plan of the release set.
+func detectDrift(ctx context.Context, d ResourceRead, fs *ReleaseSet, executor HelmfileExecutor) (string, error) {
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		return "", fmt.Errorf("preparing helmfile file: %w", err)
+	}
+	defer files.remove()
+	defer removeOnShutdown(files.Path)()
+
+	result, err := executor.Diff(ctx, buildDiffOptions(fs, files, 0))
+	if err != nil {
+		return "", err
+	}
+
+	state := &State{Stderr: result.Stderr}
+	if result.ExitCode == 2 {
+		state.Output = result.stdout()
+	}
+
+	if inputs, err := driftInputsHash(d); err != nil {
+		logf("Warning: not keeping the drift of release set %s for the plan: %v", d.Id(), err)
+	} else {
+		detectedDrifts.Store(d.Id(), detectedDrift{inputs: inputs, state: state})
+	}
+
+	diff, err := removeNondeterministicTemplateAndDiffLogLines(state.Output)
+	if err != nil {
+		return "", err
+	}
+
+	return normalizeDiff(diff, fs.IgnoreDiffRegex)
+}
+
+// readDrift sets diff_output and dirty on refresh when the releases in the cluster have drifted.
+// Like refreshReleases, an unreachable cluster only logs a warning, so that it never fails the refresh.
+func readDrift(d ResourceReadWrite, fs *ReleaseSet, provider *ProviderInstance) {
+	ctx, done := startOperation(0)
+	defer done()
+
+	diff, err := detectDrift(ctx, d, fs, provider.executorFor(fs))
+	if err != nil {
+		logf("Warning: not detecting drift of release set %s: %v", d.Id(), classifyAuthFailure(fs, err))
+		return
+	}
+
+	if diff == "" {
+		d.Set(KeyDirty, false)
+		return
+	}
+
+	logf("Detected drift of release set %s in the cluster", d.Id())
+
+	if outputsInFiles(fs) {
+		if err := writeOutputFile(d, fs, KeyDiffOutput, diff); err != nil {
+			logf("Warning: not recording the drift of release set %s: %v", d.Id(), err)
+			return
+		}
+	} else {
+		diff = truncateOutput(diff, provider.MaxDiffOutputLen, "helmfile-diff output", KeyMaxDiffOutputLen)
+
+		d.Set(KeyDiffOutput, protectOutput(d, fs, diff, KeySensitiveDiffOutput, fs.ShowSecrets))
+	}
+
+	d.Set(KeyDirty, true)
+}
+
+// cachedDrift returns the diff run on refresh of the same operation, unless the input attributes have changed since then
+func cachedDrift(d ResourceRead) (*State, bool) {
+	v, ok := detectedDrifts.Load(d.Id())
+	if !ok {
+		return nil, false
+	}
+
+	inputs, err := driftInputsHash(d)
+	if err != nil || inputs != v.(detectedDrift).inputs {
+		return nil, false
+	}
+
+	return v.(detectedDrift).state, true
+}
+
+// forgetDrift drops the drift detected on refresh once apply has reconciled it
+func forgetDrift(id string) {
+	detectedDrifts.Delete(id)
+}
@@ -0,0 +1,201 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func newDriftTestResourceData(t *testing.T, dir string, values []interface{}) *schema.ResourceData {
+	t.Helper()
+
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:          "releases: []",
+		KeyWorkingDirectory: dir,
+		KeyKubeconfig:       writeTestKubeconfig(t),
+		KeyBin:              filepath.Join(dir, "helmfile"),
+		KeyValues:           values,
+		KeyDetectDrift:      true,
+	})
+	d.SetId("drift-" + filepath.Base(dir))
+
+	return d
+}
+
+func TestReadDrift(t *testing.T) {
+	dir, _ := newFakeBinaryHelmfile(t)
+
+	diff := "Comparing release=app, chart=charts/app, namespace=default\ndefault, app, Deployment (apps) has changed:\n-   replicas: 1\n+   replicas: 3\n"
+	for name, content := range map[string]string{"stdout": diff, "exit-code": "2"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider := &ProviderInstance{ExecutorName: ExecutorBinary}
+
+	d := newDriftTestResourceData(t, dir, nil)
+	defer forgetDrift(d.Id())
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readDrift(d, fs, provider)
+
+	if !d.Get(KeyDirty).(bool) {
+		t.Error("expected dirty for the detailed exit code 2")
+	}
+
+	if got := d.Get(KeyDiffOutput).(string); got != diff {
+		t.Errorf("unexpected diff_output:\nwant: %q\ngot:  %q", diff, got)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !containsString(got, " --detailed-exitcode") {
+		t.Errorf("expected helmfile diff to run with the detailed exit code, got %s", got)
+	}
+
+	// The plan of the same operation reuses the diff, unless the inputs have changed
+	if drift, ok := cachedDrift(d); !ok || drift.Output != diff {
+		t.Errorf("expected the drift to be kept for the plan, got %v, %v", drift, ok)
+	}
+
+	if _, ok := cachedDrift(newDriftTestResourceData(t, dir, []interface{}{"replicaCount: 3"})); ok {
+		t.Error("expected the drift not to be reused for the changed values")
+	}
+
+	// An unreachable cluster leaves dirty as is instead of failing the refresh
+	if err := ioutil.WriteFile(filepath.Join(dir, "exit-code"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	readDrift(d, fs, provider)
+
+	if !d.Get(KeyDirty).(bool) {
+		t.Error("expected dirty to be kept when helmfile diff fails")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "exit-code")); err != nil {
+		t.Fatal(err)
+	}
+
+	readDrift(d, fs, provider)
+
+	if d.Get(KeyDirty).(bool) {
+		t.Error("expected no drift for the exit code 0")
+	}
+
+	forgetDrift(d.Id())
+
+	if _, ok := cachedDrift(d); ok {
+		t.Error("expected the drift to be forgotten")
+	}
+}
+
+func TestDiffReleaseSetReusesDrift(t *testing.T) {
+	dir, _ := newFakeBinaryHelmfile(t)
+
+	// The diff file is written relative to the Terraform root module
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	d := newDriftTestResourceData(t, dir, nil)
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift := "Comparing release=app, chart=charts/app, namespace=default\ndefault, app, Deployment (apps) has changed:\n-   replicas: 1\n+   replicas: 3\n"
+
+	diff, err := DiffReleaseSet(newContext(d), fs, d, WithDiffConfig(DiffConfig{Drift: &State{Output: drift}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff != drift {
+		t.Errorf("expected the drift detected on refresh as the diff, got %q", diff)
+	}
+
+	if got := readFakeBinaryArgs(t, dir) + " "; containsString(got, " diff ") {
+		t.Errorf("expected helmfile diff not to run again, got %s", got)
+	}
+}
+
+// TestAccHelmfileReleaseSet_detectDrift upgrades the release out of band, and expects the next plan to reconcile it
+func TestAccHelmfileReleaseSet_detectDrift(t *testing.T) {
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-drift-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_detectDrift(releaseID, chartDir),
+
+				Check: testAccUpgradeReleaseOutOfBand("drift-"+releaseID, chartDir, "replicaCount=3"),
+
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: testAccHelmfileReleaseSetConfig_detectDrift(releaseID, chartDir),
+
+				Check: resource.TestCheckResourceAttr("helmfile_release_set.the_product", "dirty", "false"),
+			},
+		},
+	})
+}
+
+// testAccUpgradeReleaseOutOfBand upgrades the release made by testAccCreateDeploymentChart with helm, bypassing Terraform
+func testAccUpgradeReleaseOutOfBand(release, dir, set string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		out, err := exec.Command("helm", "--kubeconfig", os.ExpandEnv("$HOME/.kube/config"), "upgrade", release, filepath.Join(dir, "chart"), "--reuse-values", "--set", set).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("upgrading %s: %v: %s", release, err, out)
+		}
+
+		return nil
+	}
+}
+
+func testAccHelmfileReleaseSetConfig_detectDrift(randVal, dir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: drift-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  detect_drift = true
+}
+`, randVal, dir)
+}
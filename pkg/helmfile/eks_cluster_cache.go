@@ -0,0 +1,132 @@
+package helmfile
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/mutexkv"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// DefaultEKSClusterCacheTTL is how long the DescribeCluster of an EKS cluster is reused by default
+const DefaultEKSClusterCacheTTL = 10 * time.Minute
+
+var (
+	// eksDescribeClusterAttempts is how many times DescribeCluster is called when EKS throttles it
+	eksDescribeClusterAttempts = 5
+
+	// eksDescribeClusterRetryDelay is the delay before the first retry of a throttled DescribeCluster, doubled for
+	// each retry after it. Tests replace it.
+	eksDescribeClusterRetryDelay = time.Second
+)
+
+// eksDescribeClusterAPI is the part of the EKS client that fetchEKSClusterInfo uses
+type eksDescribeClusterAPI interface {
+	DescribeCluster(*eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error)
+}
+
+// describeClusterWithRetry calls DescribeCluster, retrying with an exponential backoff while EKS throttles it
+func describeClusterWithRetry(client eksDescribeClusterAPI, input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	delay := eksDescribeClusterRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		output, err := client.DescribeCluster(input)
+		if err == nil || !request.IsErrorThrottle(err) || attempt >= eksDescribeClusterAttempts {
+			return output, err
+		}
+
+		logf("Warning: DescribeCluster was throttled, retrying in %s: %v", delay, err)
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// eksClusterCacheKey identifies the DescribeCluster of a cluster by the credentials it was called with
+type eksClusterCacheKey struct {
+	ClusterName string
+	Region      string
+	Profile     string
+	RoleARN     string
+	Endpoint    string
+}
+
+func (k eksClusterCacheKey) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", k.Endpoint, k.Region, k.ClusterName, k.Profile, k.RoleARN)
+}
+
+type eksClusterCacheEntry struct {
+	config  EKSClusterConfig
+	expires time.Time
+}
+
+// EKSClusterCache reuses the DescribeCluster of the EKS clusters across the release sets of the provider and
+// across plan and apply, so that many release sets pointed at the same cluster don't get throttled by EKS
+type EKSClusterCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[eksClusterCacheKey]eksClusterCacheEntry
+
+	// fetching makes the concurrent release sets of the same cluster wait for a single DescribeCluster
+	fetching *mutexkv.MutexKV
+
+	now func() time.Time
+}
+
+// NewEKSClusterCache returns the cache that keeps the DescribeCluster of each cluster for the ttl.
+// A zero ttl disables the cache.
+func NewEKSClusterCache(ttl time.Duration) *EKSClusterCache {
+	return &EKSClusterCache{
+		ttl:      ttl,
+		entries:  map[eksClusterCacheKey]eksClusterCacheEntry{},
+		fetching: mutexkv.NewMutexKV(),
+		now:      time.Now,
+	}
+}
+
+// fetch returns the cluster info of the key, calling DescribeCluster only when it isn't cached or has expired.
+// A DescribeCluster that returns another endpoint means the cluster was recreated, so the entries of the cluster
+// cached for the other credentials are invalidated along with it.
+func (c *EKSClusterCache) fetch(ctx *sdk.Context, key eksClusterCacheKey) (*EKSClusterConfig, error) {
+	if c == nil || c.ttl <= 0 {
+		return fetchEKSClusterInfo(ctx, key.ClusterName, key.Region)
+	}
+
+	c.fetching.Lock(key.String())
+	defer c.fetching.Unlock(key.String())
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && c.now().Before(entry.expires) {
+		logf("Using the EKS cluster info of %s cached until %s", key.ClusterName, entry.expires.Format(time.RFC3339))
+
+		config := entry.config
+		return &config, nil
+	}
+
+	config, err := fetchEKSClusterInfo(ctx, key.ClusterName, key.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.entries {
+		if k.ClusterName == key.ClusterName && k.Region == key.Region && k.Endpoint == key.Endpoint && e.config.Endpoint != config.Endpoint {
+			logf("EKS cluster %s has the new endpoint %s. Invalidating the cached endpoint %s", key.ClusterName, config.Endpoint, e.config.Endpoint)
+
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = eksClusterCacheEntry{config: *config, expires: c.now().Add(c.ttl)}
+
+	return config, nil
+}
@@ -0,0 +1,160 @@
+package helmfile
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// fakeEKSClient returns the endpoint for DescribeCluster after failing with throttles throttled times
+type fakeEKSClient struct {
+	mu        sync.Mutex
+	calls     int
+	endpoint  string
+	throttled int
+}
+
+func (c *fakeEKSClient) DescribeCluster(input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+
+	if c.throttled > 0 {
+		c.throttled--
+		return nil, awserr.New("ThrottlingException", "Rate exceeded", nil)
+	}
+
+	return &eks.DescribeClusterOutput{Cluster: &eks.Cluster{
+		Name:                 input.Name,
+		Endpoint:             aws.String(c.endpoint),
+		CertificateAuthority: &eks.Certificate{Data: aws.String("LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t")},
+	}}, nil
+}
+
+func (c *fakeEKSClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls
+}
+
+func newFakeEKSClient(t *testing.T, endpoint string) (*fakeEKSClient, *sdk.Context) {
+	t.Helper()
+
+	client := &fakeEKSClient{endpoint: endpoint}
+
+	newClient := newEKSClient
+	newEKSClient = func(*session.Session, string) eksDescribeClusterAPI { return client }
+	t.Cleanup(func() { newEKSClient = newClient })
+
+	return client, &sdk.Context{Sess: newStaticCredentialsSession(t)}
+}
+
+func TestEKSClusterCache(t *testing.T) {
+	client, ctx := newFakeEKSClient(t, "https://one.eks.amazonaws.com")
+
+	now := time.Now()
+	cache := NewEKSClusterCache(10 * time.Minute)
+	cache.now = func() time.Time { return now }
+
+	key := eksClusterCacheKey{ClusterName: "my-cluster", Region: "us-west-2", Profile: "dev"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.fetch(ctx, key); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := client.callCount(); calls != 1 {
+		t.Fatalf("expected the release sets of the cluster to share a DescribeCluster, got %d calls", calls)
+	}
+
+	// The other credentials may not be allowed to describe the cluster
+	if _, err := cache.fetch(ctx, eksClusterCacheKey{ClusterName: "my-cluster", Region: "us-west-2", Profile: "prod"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls := client.callCount(); calls != 2 {
+		t.Fatalf("expected the cache to be keyed by the profile, got %d calls", calls)
+	}
+
+	now = now.Add(11 * time.Minute)
+
+	client.endpoint = "https://two.eks.amazonaws.com"
+
+	config, err := cache.fetch(ctx, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls := client.callCount(); calls != 3 || config.Endpoint != "https://two.eks.amazonaws.com" {
+		t.Fatalf("expected the expired entry to be described again, got %d calls and the endpoint %s", calls, config.Endpoint)
+	}
+
+	// The entry of the prod profile is not yet expired, but has the endpoint of the cluster before it was recreated
+	now = now.Add(-5 * time.Minute)
+
+	config, err = cache.fetch(ctx, eksClusterCacheKey{ClusterName: "my-cluster", Region: "us-west-2", Profile: "prod"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls := client.callCount(); calls != 4 || config.Endpoint != "https://two.eks.amazonaws.com" {
+		t.Errorf("expected the stale endpoint to be invalidated, got %d calls and the endpoint %s", calls, config.Endpoint)
+	}
+}
+
+func TestEKSClusterCacheDisabled(t *testing.T) {
+	client, ctx := newFakeEKSClient(t, "https://one.eks.amazonaws.com")
+
+	key := eksClusterCacheKey{ClusterName: "my-cluster", Region: "us-west-2"}
+
+	for _, cache := range []*EKSClusterCache{nil, NewEKSClusterCache(0)} {
+		for i := 0; i < 2; i++ {
+			if _, err := cache.fetch(ctx, key); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	if calls := client.callCount(); calls != 4 {
+		t.Errorf("expected every fetch to describe the cluster, got %d calls", calls)
+	}
+}
+
+func TestDescribeClusterWithRetry(t *testing.T) {
+	delay := eksDescribeClusterRetryDelay
+	eksDescribeClusterRetryDelay = time.Millisecond
+	t.Cleanup(func() { eksDescribeClusterRetryDelay = delay })
+
+	client, ctx := newFakeEKSClient(t, "https://one.eks.amazonaws.com")
+	client.throttled = 2
+
+	config, err := fetchEKSClusterInfo(ctx, "my-cluster", "us-west-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls := client.callCount(); calls != 3 || config.Endpoint != "https://one.eks.amazonaws.com" {
+		t.Errorf("expected DescribeCluster to be retried until it succeeds, got %d calls and the endpoint %s", calls, config.Endpoint)
+	}
+
+	client.throttled = eksDescribeClusterAttempts
+
+	if _, err := fetchEKSClusterInfo(ctx, "my-cluster", "us-west-2"); err == nil {
+		t.Errorf("expected an error after %d throttled attempts", eksDescribeClusterAttempts)
+	}
+}
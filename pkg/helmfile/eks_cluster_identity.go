@@ -0,0 +1,66 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// eksClusterIdentity identifies the API server of the EKS cluster by the endpoint and the fingerprint of the CA,
+// which both change when the cluster is recreated with the same name
+func eksClusterIdentity(config *EKSClusterConfig) string {
+	sum := sha256.Sum256([]byte(config.CA))
+
+	return fmt.Sprintf("%s ca-sha256:%x", config.Endpoint, sum[:8])
+}
+
+// rediscoversEKSCluster returns true when eks_cluster_endpoint and eks_cluster_ca in the state were discovered
+// by the last apply, rather than set explicitly, so that they are discovered again to detect a recreated cluster
+func rediscoversEKSCluster(d ResourceRead) bool {
+	if identity, _ := d.Get(KeyEKSClusterIdentity).(string); identity == "" {
+		return false
+	}
+
+	if c, ok := d.(diffChecker); ok && (c.HasChange(KeyEKSClusterEndpoint) || c.HasChange(KeyEKSClusterCA)) {
+		return false
+	}
+
+	return true
+}
+
+// setEKSClusterIdentity records the identity of the EKS cluster discovered for the apply
+func setEKSClusterIdentity(d ResourceReadWrite, fs *ReleaseSet) error {
+	var identity string
+	if fs.eksCluster != nil && fs.eksClusterDiscovered {
+		identity = eksClusterIdentity(fs.eksCluster)
+	}
+
+	if err := d.Set(KeyEKSClusterIdentity, identity); err != nil {
+		return fmt.Errorf("setting %s: %w", KeyEKSClusterIdentity, err)
+	}
+
+	return nil
+}
+
+// markEKSClusterChange updates eks_cluster_identity on plan when the EKS cluster discovered for eks_cluster_name
+// is not the one of the last apply, which changes the API server the releases are diffed and applied against.
+// It returns true when the cluster changed.
+func markEKSClusterChange(d ResourceReadWrite, fs *ReleaseSet) (bool, error) {
+	last, _ := d.Get(KeyEKSClusterIdentity).(string)
+	if last == "" || fs.eksCluster == nil || !fs.eksClusterDiscovered {
+		return false, nil
+	}
+
+	current := eksClusterIdentity(fs.eksCluster)
+	if current == last {
+		return false, nil
+	}
+
+	logf("The EKS cluster %s changed from %s to %s since the last apply, like when it was recreated. "+
+		"The releases are diffed and applied against the new cluster", fs.eksCluster.ClusterName, last, current)
+
+	if err := d.Set(KeyEKSClusterIdentity, current); err != nil {
+		return false, fmt.Errorf("setting %s: %w", KeyEKSClusterIdentity, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,186 @@
+package helmfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mockEKSClusterDiff is a ResourceReadWrite that reports the attributes set since it was created as changed,
+// like schema.ResourceDiff does for the attributes set with SetNew
+type mockEKSClusterDiff struct {
+	*mockDiffChecker
+	old  map[string]interface{}
+	data map[string]interface{}
+}
+
+func newMockEKSClusterDiff(state map[string]interface{}) *mockEKSClusterDiff {
+	d := &mockEKSClusterDiff{
+		mockDiffChecker: newMockDiffChecker(),
+		old:             state,
+		data:            map[string]interface{}{},
+	}
+
+	for k, v := range state {
+		d.data[k] = v
+	}
+
+	return d
+}
+
+func (d *mockEKSClusterDiff) Get(key string) interface{} {
+	if v, ok := d.data[key]; ok {
+		return v
+	}
+
+	return ""
+}
+
+func (d *mockEKSClusterDiff) Set(key string, value interface{}) error {
+	d.data[key] = value
+
+	return nil
+}
+
+func (d *mockEKSClusterDiff) HasChange(key string) bool {
+	old, ok := d.old[key]
+	if !ok {
+		old = ""
+	}
+
+	return d.mockDiffChecker.HasChange(key) || !reflect.DeepEqual(d.Get(key), old)
+}
+
+func TestEKSClusterIdentity(t *testing.T) {
+	config := &EKSClusterConfig{Endpoint: "https://one.eks.amazonaws.com", CA: "LS0tLS1CRUdJTi0tLS0t"}
+
+	identity := eksClusterIdentity(config)
+
+	if identity != eksClusterIdentity(&EKSClusterConfig{Endpoint: config.Endpoint, CA: config.CA, ClusterName: "my-cluster"}) {
+		t.Errorf("expected the identity to depend only on the endpoint and the CA")
+	}
+
+	if identity == eksClusterIdentity(&EKSClusterConfig{Endpoint: "https://two.eks.amazonaws.com", CA: config.CA}) {
+		t.Errorf("expected the identity to change with the endpoint")
+	}
+
+	if identity == eksClusterIdentity(&EKSClusterConfig{Endpoint: config.Endpoint, CA: "LS0tLS1FTkQtLS0tLQ=="}) {
+		t.Errorf("expected the identity to change with the CA")
+	}
+}
+
+func TestRediscoversEKSCluster(t *testing.T) {
+	state := map[string]interface{}{
+		KeyEKSClusterEndpoint: "https://one.eks.amazonaws.com",
+		KeyEKSClusterCA:       "LS0tLS1CRUdJTi0tLS0t",
+	}
+
+	// The endpoint and CA were set explicitly
+	if rediscoversEKSCluster(newMockEKSClusterDiff(state)) {
+		t.Errorf("expected the explicit endpoint and CA to be used without eks_cluster_identity")
+	}
+
+	state[KeyEKSClusterIdentity] = "https://one.eks.amazonaws.com ca-sha256:0123456789abcdef"
+
+	if !rediscoversEKSCluster(newMockEKSClusterDiff(state)) {
+		t.Errorf("expected the endpoint and CA discovered by the last apply to be discovered again")
+	}
+
+	// The endpoint was changed explicitly from the discovered one
+	d := newMockEKSClusterDiff(state)
+	d.data[KeyEKSClusterEndpoint] = "https://two.eks.amazonaws.com"
+
+	if rediscoversEKSCluster(d) {
+		t.Errorf("expected the explicitly changed endpoint to be used")
+	}
+}
+
+func TestMarkEKSClusterChange(t *testing.T) {
+	applied := &EKSClusterConfig{ClusterName: "my-cluster", Endpoint: "https://one.eks.amazonaws.com", CA: "LS0tLS1CRUdJTi0tLS0t"}
+	recreated := &EKSClusterConfig{ClusterName: "my-cluster", Endpoint: "https://two.eks.amazonaws.com", CA: "LS0tLS1FTkQtLS0tLQ=="}
+
+	inputKeys := []string{KeyContent, KeyEKSClusterEndpoint, KeyEKSClusterCA, KeyEKSClusterIdentity}
+
+	tests := []struct {
+		name       string
+		identity   string
+		fs         *ReleaseSet
+		changed    bool
+		diffMarked bool
+	}{
+		{
+			name:     "same cluster",
+			identity: eksClusterIdentity(applied),
+			fs:       &ReleaseSet{eksCluster: applied, eksClusterDiscovered: true},
+		},
+		{
+			name:       "recreated cluster",
+			identity:   eksClusterIdentity(applied),
+			fs:         &ReleaseSet{eksCluster: recreated, eksClusterDiscovered: true},
+			changed:    true,
+			diffMarked: true,
+		},
+		{
+			name:     "explicit endpoint and CA",
+			identity: eksClusterIdentity(applied),
+			fs:       &ReleaseSet{eksCluster: recreated},
+		},
+		{
+			name: "not applied yet",
+			fs:   &ReleaseSet{eksCluster: recreated, eksClusterDiscovered: true},
+		},
+		{
+			name:     "no EKS cluster",
+			identity: eksClusterIdentity(applied),
+			fs:       &ReleaseSet{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newMockEKSClusterDiff(map[string]interface{}{KeyEKSClusterIdentity: tt.identity})
+
+			changed, err := markEKSClusterChange(d, tt.fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if changed != tt.changed {
+				t.Errorf("expected changed %v, got %v", tt.changed, changed)
+			}
+
+			changedKeys := markDiffOutputs(d, "", inputKeys, outputAttributes{})
+
+			if d.newComputed[KeyDiffOutput] != tt.diffMarked || d.newComputed[KeyApplyOutput] != tt.diffMarked {
+				t.Errorf("expected diff_output and apply_output to be marked computed: %v, got %v", tt.diffMarked, d.newComputed)
+			}
+
+			if !tt.changed {
+				return
+			}
+
+			if got := d.Get(KeyEKSClusterIdentity); got != eksClusterIdentity(recreated) {
+				t.Errorf("expected eks_cluster_identity of the recreated cluster, got %v", got)
+			}
+
+			if reason := changeReason(changedKeys, ""); reason != ChangeReasonCluster {
+				t.Errorf("expected change reason %q, got %q", ChangeReasonCluster, reason)
+			}
+		})
+	}
+}
+
+func TestConfiguredKubeconfig(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"/path/to/kubeconfig": "/path/to/kubeconfig",
+		"/tmp/" + temporaryKubeconfigPrefix + "my-cluster-0a1b2c3d": "",
+	}
+
+	for kubeconfig, expected := range tests {
+		d := &mockResourceRead{data: map[string]interface{}{KeyKubeconfig: kubeconfig}}
+
+		if got := configuredKubeconfig(d); got != expected {
+			t.Errorf("expected %q for kubeconfig %q, got %q", expected, kubeconfig, got)
+		}
+	}
+}
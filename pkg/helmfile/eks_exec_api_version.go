@@ -0,0 +1,69 @@
+package helmfile
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	awsCLIVersionPattern = regexp.MustCompile(`aws-cli/(\d+)\.(\d+)`)
+
+	detectedEKSExecAPIVersion   string
+	detectEKSExecAPIVersionOnce sync.Once
+)
+
+// awsCLIVersion returns the output of aws --version. Tests replace it.
+var awsCLIVersion = func() (string, error) {
+	out, err := exec.Command("aws", "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("running aws --version: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// eksExecAPIVersion returns the apiVersion of the aws eks get-token exec plugin of the generated kubeconfig.
+// It is eks_exec_api_version when set, or the one detected from the installed aws CLI
+func eksExecAPIVersion(d ResourceRead) string {
+	if v, _ := d.Get(KeyEKSExecAPIVersion).(string); v != "" {
+		return v
+	}
+
+	detectEKSExecAPIVersionOnce.Do(func() {
+		detectedEKSExecAPIVersion = detectEKSExecAPIVersion()
+	})
+
+	return detectedEKSExecAPIVersion
+}
+
+// detectEKSExecAPIVersion returns v1alpha1 for the aws CLI versions that only emit v1alpha1 ExecCredentials,
+// and v1beta1 otherwise. The newer versions emit the apiVersion of the kubeconfig, and v1beta1 is accepted by
+// the clusters that don't accept v1 yet
+func detectEKSExecAPIVersion() string {
+	version, err := awsCLIVersion()
+	if err != nil {
+		logf("Warning: failed to detect the version of the aws CLI, using %s: %v", ExecAPIVersionV1Beta1, err)
+		return ExecAPIVersionV1Beta1
+	}
+
+	m := awsCLIVersionPattern.FindStringSubmatch(version)
+	if m == nil {
+		logf("Warning: unexpected aws --version output %q, using %s", version, ExecAPIVersionV1Beta1)
+		return ExecAPIVersionV1Beta1
+	}
+
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+
+	// aws-cli 1.24.0 and 2.7.0 moved to v1beta1 along with the removal of v1alpha1 from Kubernetes 1.24
+	if (major == 1 && minor < 24) || (major == 2 && minor < 7) {
+		logf("Using %s for aws CLI %s", ExecAPIVersionV1Alpha1, version)
+		return ExecAPIVersionV1Alpha1
+	}
+
+	return ExecAPIVersionV1Beta1
+}
@@ -0,0 +1,99 @@
+package helmfile
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDetectEKSExecAPIVersion(t *testing.T) {
+	orig := awsCLIVersion
+	defer func() { awsCLIVersion = orig }()
+
+	tests := []struct {
+		version  string
+		err      error
+		expected string
+	}{
+		{version: "aws-cli/1.23.9 Python/3.8.10 Linux/5.15.0 botocore/1.25.9", expected: ExecAPIVersionV1Alpha1},
+		{version: "aws-cli/1.24.0 Python/3.8.10 Linux/5.15.0 botocore/1.26.0", expected: ExecAPIVersionV1Beta1},
+		{version: "aws-cli/2.6.4 Python/3.9.11 Linux/5.15.0 exe/x86_64.ubuntu.22 prompt/off", expected: ExecAPIVersionV1Alpha1},
+		{version: "aws-cli/2.15.30 Python/3.11.8 Linux/6.5.0 exe/x86_64.ubuntu.22 prompt/off", expected: ExecAPIVersionV1Beta1},
+		{version: "unexpected", expected: ExecAPIVersionV1Beta1},
+		{err: errors.New("executable file not found in $PATH"), expected: ExecAPIVersionV1Beta1},
+	}
+
+	for _, tt := range tests {
+		awsCLIVersion = func() (string, error) { return tt.version, tt.err }
+
+		if got := detectEKSExecAPIVersion(); got != tt.expected {
+			t.Errorf("expected %s for %q, got %s", tt.expected, tt.version, got)
+		}
+	}
+}
+
+func TestEKSExecAPIVersion(t *testing.T) {
+	orig := awsCLIVersion
+	defer func() {
+		awsCLIVersion = orig
+		detectEKSExecAPIVersionOnce = sync.Once{}
+	}()
+
+	calls := 0
+	awsCLIVersion = func() (string, error) {
+		calls++
+		return "aws-cli/1.22.0 Python/3.8.10 Linux/5.15.0 botocore/1.24.0", nil
+	}
+	detectEKSExecAPIVersionOnce = sync.Once{}
+
+	d := &mockResourceRead{data: map[string]interface{}{KeyEKSExecAPIVersion: ExecAPIVersionV1}}
+	if got := eksExecAPIVersion(d); got != ExecAPIVersionV1 {
+		t.Errorf("expected eks_exec_api_version %s, got %s", ExecAPIVersionV1, got)
+	}
+
+	d = &mockResourceRead{data: map[string]interface{}{}}
+	for i := 0; i < 2; i++ {
+		if got := eksExecAPIVersion(d); got != ExecAPIVersionV1Alpha1 {
+			t.Errorf("expected the detected %s, got %s", ExecAPIVersionV1Alpha1, got)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the aws CLI to be run once, got %d", calls)
+	}
+}
+
+func TestGenerateKubeconfigYAMLExecAPIVersion(t *testing.T) {
+	tests := []struct {
+		apiVersion      string
+		expected        string
+		interactiveMode bool
+	}{
+		{apiVersion: "", expected: ExecAPIVersionV1Beta1},
+		{apiVersion: ExecAPIVersionV1Alpha1, expected: ExecAPIVersionV1Alpha1},
+		{apiVersion: ExecAPIVersionV1Beta1, expected: ExecAPIVersionV1Beta1},
+		{apiVersion: ExecAPIVersionV1, expected: ExecAPIVersionV1, interactiveMode: true},
+	}
+
+	for _, tt := range tests {
+		yamlStr, err := generateKubeconfigYAML(&EKSClusterConfig{
+			ClusterName:    "test-cluster",
+			Region:         "us-west-2",
+			Endpoint:       "https://ABC123.gr7.us-west-2.eks.amazonaws.com",
+			CA:             "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+			ExecAPIVersion: tt.apiVersion,
+		})
+		if err != nil {
+			t.Fatalf("generateKubeconfigYAML() error = %v", err)
+		}
+
+		if !strings.Contains(yamlStr, "apiVersion: "+tt.expected+"\n") {
+			t.Errorf("expected apiVersion %s in the kubeconfig:\n%s", tt.expected, yamlStr)
+		}
+
+		if got := strings.Contains(yamlStr, "interactiveMode: Never"); got != tt.interactiveMode {
+			t.Errorf("expected interactiveMode to be set: %v, got %v in the kubeconfig:\n%s", tt.interactiveMode, got, yamlStr)
+		}
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
 	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk/api"
@@ -22,6 +24,30 @@ type EKSClusterConfig struct {
 	Endpoint    string
 	CA          string
 	AWSProfile  string
+
+	// RoleARN is the role assumed by aws eks get-token, chained from the credentials in the environment
+	// like the web identity token of IRSA
+	RoleARN string
+
+	// Token is the bearer token written to the kubeconfig instead of the exec plugin, with eks_auth_mode = "native"
+	Token string
+
+	// ExecAPIVersion is the apiVersion of the exec plugin, v1beta1 when empty
+	ExecAPIVersion string
+}
+
+// execAuthEnvVars are the environment variables passed through to the aws eks get-token exec plugin when present,
+// so that it can authenticate with IRSA web identity tokens and container credentials.
+// AWS_CONTAINER_AUTHORIZATION_TOKEN is left out as the kubeconfig is written to disk. The exec plugin inherits it
+// from the environment of helm, which preserves it like the other credentials.
+var execAuthEnvVars = []string{
+	"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+}
+
+// newEKSClient returns the EKS client of the region. Tests replace it.
+var newEKSClient = func(sess *session.Session, region string) eksDescribeClusterAPI {
+	return eks.New(sess, &aws.Config{Region: aws.String(region)})
 }
 
 // fetchEKSClusterInfo retrieves EKS cluster details from AWS API
@@ -35,14 +61,14 @@ func fetchEKSClusterInfo(ctx *sdk.Context, clusterName, region string) (*EKSClus
 	}
 
 	// Create EKS client
-	eksClient := eks.New(sess, &aws.Config{Region: aws.String(region)})
+	eksClient := newEKSClient(sess, region)
 
 	// Call DescribeCluster API
 	input := &eks.DescribeClusterInput{
 		Name: aws.String(clusterName),
 	}
 
-	result, err := eksClient.DescribeCluster(input)
+	result, err := describeClusterWithRetry(eksClient, input)
 	if err != nil {
 		return nil, fmt.Errorf("describing EKS cluster %s: %w", clusterName, err)
 	}
@@ -74,6 +100,23 @@ func fetchEKSClusterInfo(ctx *sdk.Context, clusterName, region string) (*EKSClus
 	return config, nil
 }
 
+// getAssumeRoleARN returns the role_arn of the aws_assume_role block, if any
+func getAssumeRoleARN(d api.Getter) string {
+	blocks, ok := d.Get(KeyAWSAssumeRole).([]interface{})
+	if !ok || len(blocks) == 0 {
+		return ""
+	}
+
+	block, ok := blocks[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	roleARN, _ := block["role_arn"].(string)
+
+	return roleARN
+}
+
 // getEKSRegion returns the region to use for EKS operations
 // Prefers eks_cluster_region over aws_region
 func getEKSRegion(d api.Getter) string {
@@ -125,7 +168,8 @@ type UserEntry struct {
 
 // UserDetail contains user authentication details
 type UserDetail struct {
-	Exec ExecConfig `yaml:"exec"`
+	Exec  ExecConfig `yaml:"exec,omitempty"`
+	Token string     `yaml:"token,omitempty"`
 }
 
 // ExecConfig configures exec-based authentication
@@ -134,6 +178,9 @@ type ExecConfig struct {
 	Command    string       `yaml:"command"`
 	Args       []string     `yaml:"args"`
 	Env        []ExecEnvVar `yaml:"env,omitempty"`
+
+	// InteractiveMode is required by client.authentication.k8s.io/v1
+	InteractiveMode string `yaml:"interactiveMode,omitempty"`
 }
 
 // ExecEnvVar represents an environment variable for exec auth
@@ -142,64 +189,48 @@ type ExecEnvVar struct {
 	Value string `yaml:"value"`
 }
 
-// generateKubeconfigYAML creates a kubeconfig YAML string with AWS exec plugin authentication
+// generateKubeconfigYAML creates a kubeconfig YAML string with AWS exec plugin authentication,
+// or with the static token of the config when it has one
 func generateKubeconfigYAML(config *EKSClusterConfig) (string, error) {
 	logf("Generating kubeconfig YAML for cluster: %s", config.ClusterName)
 
-	// Build exec args for aws eks get-token
-	args := []string{
-		"eks",
-		"get-token",
-		"--cluster-name", config.ClusterName,
-	}
-
-	if config.Region != "" {
-		args = append(args, "--region", config.Region)
+	user := UserDetail{Token: config.Token}
+	if config.Token == "" {
+		user.Exec = awsExecConfig(config)
 	}
 
-	// Build exec env vars
-	var envVars []ExecEnvVar
-	if config.AWSProfile != "" {
-		envVars = append(envVars, ExecEnvVar{
-			Name:  "AWS_PROFILE",
-			Value: config.AWSProfile,
-		})
-	}
+	return marshalKubeconfig(config.ClusterName, config.Endpoint, config.CA, user)
+}
 
+// marshalKubeconfig creates a kubeconfig YAML string with a single cluster, context and user named name
+func marshalKubeconfig(name, endpoint, ca string, user UserDetail) (string, error) {
 	// Build kubeconfig structure
 	kubeconfig := KubeconfigData{
 		APIVersion: "v1",
 		Kind:       "Config",
 		Clusters: []ClusterEntry{
 			{
-				Name: config.ClusterName,
+				Name: name,
 				Cluster: ClusterDetail{
-					Server:                   config.Endpoint,
-					CertificateAuthorityData: config.CA,
+					Server:                   endpoint,
+					CertificateAuthorityData: ca,
 				},
 			},
 		},
 		Contexts: []ContextEntry{
 			{
-				Name: config.ClusterName,
+				Name: name,
 				Context: ContextDetail{
-					Cluster: config.ClusterName,
-					User:    config.ClusterName,
+					Cluster: name,
+					User:    name,
 				},
 			},
 		},
-		CurrentContext: config.ClusterName,
+		CurrentContext: name,
 		Users: []UserEntry{
 			{
-				Name: config.ClusterName,
-				User: UserDetail{
-					Exec: ExecConfig{
-						APIVersion: "client.authentication.k8s.io/v1beta1",
-						Command:    "aws",
-						Args:       args,
-						Env:        envVars,
-					},
-				},
+				Name: name,
+				User: user,
 			},
 		},
 	}
@@ -214,6 +245,57 @@ func generateKubeconfigYAML(config *EKSClusterConfig) (string, error) {
 	return string(yamlBytes), nil
 }
 
+// awsExecConfig returns the exec plugin that runs aws eks get-token for the cluster
+func awsExecConfig(config *EKSClusterConfig) ExecConfig {
+	// Build exec args for aws eks get-token
+	args := []string{
+		"eks",
+		"get-token",
+		"--cluster-name", config.ClusterName,
+	}
+
+	if config.Region != "" {
+		args = append(args, "--region", config.Region)
+	}
+
+	if config.RoleARN != "" {
+		args = append(args, "--role-arn", config.RoleARN)
+	}
+
+	// Build exec env vars
+	var envVars []ExecEnvVar
+	if config.AWSProfile != "" {
+		envVars = append(envVars, ExecEnvVar{
+			Name:  "AWS_PROFILE",
+			Value: config.AWSProfile,
+		})
+	}
+
+	for _, name := range execAuthEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			envVars = append(envVars, ExecEnvVar{Name: name, Value: value})
+		}
+	}
+
+	for _, kv := range awsContainerCredentialsEnvVars() {
+		kvs := strings.SplitN(kv, "=", 2)
+		envVars = append(envVars, ExecEnvVar{Name: kvs[0], Value: kvs[1]})
+	}
+
+	apiVersion := config.ExecAPIVersion
+	if apiVersion == "" {
+		apiVersion = ExecAPIVersionV1Beta1
+	}
+
+	return ExecConfig{
+		APIVersion:      apiVersion,
+		Command:         "aws",
+		Args:            args,
+		Env:             envVars,
+		InteractiveMode: execInteractiveMode(apiVersion),
+	}
+}
+
 // writeTemporaryKubeconfig writes the kubeconfig YAML to a temporary file
 func writeTemporaryKubeconfig(kubeconfigYAML, workingDir, clusterName string) (string, error) {
 	// Generate random suffix for uniqueness
@@ -224,13 +306,10 @@ func writeTemporaryKubeconfig(kubeconfigYAML, workingDir, clusterName string) (s
 	randomSuffix := hex.EncodeToString(randomBytes)
 
 	// Determine directory for temp file
-	dir := workingDir
-	if dir == "" || dir == "." {
-		dir = os.TempDir()
-	}
+	dir := kubeconfigDir(workingDir)
 
 	// Create filename
-	filename := fmt.Sprintf(".terraform-helmfile-kubeconfig-%s-%s", clusterName, randomSuffix)
+	filename := fmt.Sprintf("%s%s-%s", temporaryKubeconfigPrefix, clusterName, randomSuffix)
 	filePath := filepath.Join(dir, filename)
 
 	// Write file with restrictive permissions (owner read/write only)
@@ -238,16 +317,38 @@ func writeTemporaryKubeconfig(kubeconfigYAML, workingDir, clusterName string) (s
 		return "", fmt.Errorf("writing kubeconfig to %s: %w", filePath, err)
 	}
 
+	// Keeps the sweeps of the other provider processes from removing it
+	if err := lockKubeconfig(filePath); err != nil {
+		logf("Warning: failed to lock %s: %v", filePath, err)
+	}
+	removeOnShutdown(filePath + kubeconfigLockSuffix)
+
 	logf("Generated temporary kubeconfig at: %s", filePath)
 	return filePath, nil
 }
 
+// configuredKubeconfig returns the kubeconfig attribute, or the empty string when it is the temporary kubeconfig
+// stored by the last operation, which was removed when the operation ended and is generated again
+func configuredKubeconfig(d ResourceRead) string {
+	kubeconfig := d.Get(KeyKubeconfig).(string)
+	if strings.HasPrefix(filepath.Base(kubeconfig), temporaryKubeconfigPrefix) {
+		return ""
+	}
+
+	return kubeconfig
+}
+
 // cleanupKubeconfig removes the temporary kubeconfig file
 func cleanupKubeconfig(path string) error {
 	if path == "" {
 		return nil
 	}
 
+	shutdownCleanups.Delete(path)
+	shutdownCleanups.Delete(path + kubeconfigLockSuffix)
+
+	defer unlockKubeconfig(path)
+
 	if err := os.Remove(path); err != nil {
 		// Log but don't fail - file might already be deleted
 		if !os.IsNotExist(err) {
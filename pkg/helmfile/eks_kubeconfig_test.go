@@ -173,6 +173,54 @@ func TestGenerateKubeconfigYAML(t *testing.T) {
 	}
 }
 
+// TestGenerateKubeconfigYAMLWebIdentity tests that IRSA variables are passed to the exec plugin
+// and that aws_assume_role is chained from the web identity
+func TestGenerateKubeconfigYAMLWebIdentity(t *testing.T) {
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/eks.amazonaws.com/serviceaccount/token")
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/atlantis")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "http://169.254.170.23/v1/credentials")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "pod-identity-token")
+
+	yamlStr, err := generateKubeconfigYAML(&EKSClusterConfig{
+		ClusterName: "prod-cluster",
+		Region:      "us-east-1",
+		Endpoint:    "https://XYZ789.gr7.us-east-1.eks.amazonaws.com",
+		CA:          "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+		RoleARN:     "arn:aws:iam::210987654321:role/deployer",
+	})
+	if err != nil {
+		t.Fatalf("generateKubeconfigYAML() error = %v", err)
+	}
+
+	var kubeconfig KubeconfigData
+	if err := yaml.Unmarshal([]byte(yamlStr), &kubeconfig); err != nil {
+		t.Fatalf("Failed to parse generated YAML: %v", err)
+	}
+
+	exec := kubeconfig.Users[0].User.Exec
+
+	args := strings.Join(exec.Args, " ")
+	if !strings.Contains(args, "--role-arn arn:aws:iam::210987654321:role/deployer") {
+		t.Errorf("Expected --role-arn in exec args, got %v", exec.Args)
+	}
+
+	env := map[string]string{}
+	for _, e := range exec.Env {
+		env[e.Name] = e.Value
+	}
+
+	for _, name := range []string{"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_CONTAINER_CREDENTIALS_FULL_URI"} {
+		if env[name] != os.Getenv(name) {
+			t.Errorf("Expected %s=%s in exec env, got %q", name, os.Getenv(name), env[name])
+		}
+	}
+
+	// The token is inherited from the environment rather than written to the kubeconfig on disk
+	if strings.Contains(yamlStr, "pod-identity-token") {
+		t.Errorf("Expected AWS_CONTAINER_AUTHORIZATION_TOKEN not to be written to the kubeconfig, got:\n%s", yamlStr)
+	}
+}
+
 // TestWriteTemporaryKubeconfig tests the temporary kubeconfig file creation
 func TestWriteTemporaryKubeconfig(t *testing.T) {
 	tests := []struct {
@@ -455,7 +503,71 @@ func TestValidateEKSConfiguration(t *testing.T) {
 			name:        "Invalid - Neither kubeconfig nor EKS cluster",
 			data:        map[string]interface{}{},
 			expectError: true,
-			errorMsg:    "either 'kubeconfig' or 'eks_cluster_name' must be provided",
+			errorMsg:    "either 'kubeconfig', 'kubeconfig_content', 'eks_cluster_name' or 'cluster_auth_exec' must be provided",
+		},
+		{
+			name: "Valid - kubeconfig_content provided",
+			data: map[string]interface{}{
+				KeyKubeconfigContent: testKubeconfig,
+			},
+			expectError: false,
+		},
+		{
+			name: "Valid - Both kubeconfig_content and EKS cluster (kubeconfig_content takes precedence)",
+			data: map[string]interface{}{
+				KeyKubeconfigContent: testKubeconfig,
+				KeyEKSClusterName:    "my-cluster",
+			},
+			expectError: false,
+		},
+		{
+			name: "Invalid - Both kubeconfig and kubeconfig_content",
+			data: map[string]interface{}{
+				KeyKubeconfig:        "/path/to/kubeconfig",
+				KeyKubeconfigContent: testKubeconfig,
+			},
+			expectError: true,
+			errorMsg:    "'kubeconfig' and 'kubeconfig_content' cannot both be provided",
+		},
+		{
+			name: "Valid - cluster_auth_exec with endpoint and CA",
+			data: map[string]interface{}{
+				KeyClusterAuthExec: []interface{}{map[string]interface{}{"command": "kubelogin"}},
+				KeyClusterEndpoint: "https://example.azmk8s.io:443",
+				KeyClusterCA:       "LS0tLS1CRUdJTi0tLS0t",
+			},
+			expectError: false,
+		},
+		{
+			name: "Invalid - cluster_auth_exec without CA",
+			data: map[string]interface{}{
+				KeyClusterAuthExec: []interface{}{map[string]interface{}{"command": "kubelogin"}},
+				KeyClusterEndpoint: "https://example.azmk8s.io:443",
+			},
+			expectError: true,
+			errorMsg:    "cluster_auth_exec, cluster_endpoint and cluster_ca must be provided together",
+		},
+		{
+			name: "Invalid - cluster_endpoint and cluster_ca without cluster_auth_exec",
+			data: map[string]interface{}{
+				KeyKubeconfig:      "/path/to/kubeconfig",
+				KeyClusterEndpoint: "https://example.azmk8s.io:443",
+				KeyClusterCA:       "LS0tLS1CRUdJTi0tLS0t",
+			},
+			expectError: true,
+			errorMsg:    "cluster_auth_exec, cluster_endpoint and cluster_ca must be provided together",
+		},
+		{
+			name: "Invalid - Both EKS cluster and cluster_auth_exec",
+			data: map[string]interface{}{
+				KeyEKSClusterName:  "my-cluster",
+				KeyAWSRegion:       "us-west-2",
+				KeyClusterAuthExec: []interface{}{map[string]interface{}{"command": "kubelogin"}},
+				KeyClusterEndpoint: "https://example.azmk8s.io:443",
+				KeyClusterCA:       "LS0tLS1CRUdJTi0tLS0t",
+			},
+			expectError: true,
+			errorMsg:    "'eks_cluster_name' cannot be provided with 'cluster_auth_exec'",
 		},
 		{
 			name: "Invalid - EKS cluster without region",
@@ -0,0 +1,66 @@
+package helmfile
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk/api"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk/tfsdk"
+)
+
+// awsEndpoint returns the aws_endpoint of the resource, falling back to AWS_ENDPOINT_URL, like the endpoint of localstack
+func awsEndpoint(d api.Getter) string {
+	if endpoint, _ := d.Get(KeyAWSEndpoint).(string); endpoint != "" {
+		return endpoint
+	}
+
+	return os.Getenv("AWS_ENDPOINT_URL")
+}
+
+// eksSessionOptions returns the options of the session that describes the EKS cluster of the resource and signs its
+// tokens, which uses the aws_profile, the region of the cluster and the shared config of the resource
+func eksSessionOptions(d api.Getter) session.Options {
+	config := aws.NewConfig()
+
+	if region := getEKSRegion(d); region != "" {
+		config = config.WithRegion(region)
+	}
+
+	if endpoint := awsEndpoint(d); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+
+	profile, _ := d.Get(KeyAWSProfile).(string)
+
+	return session.Options{
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+		SharedConfigState:       session.SharedConfigEnable,
+		Config:                  *config,
+		Profile:                 profile,
+	}
+}
+
+// newEKSContext returns the context whose session is built from eksSessionOptions, assuming the role of
+// aws_assume_role if any, instead of the session of newContext, which has the aws_region of the resource
+func newEKSContext(d api.Getter) (*sdk.Context, error) {
+	sess, err := session.NewSessionWithOptions(eksSessionOptions(d))
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session: %w", err)
+	}
+
+	assumeRole := tfsdk.GetAssumeRoleConfig(d, tfsdk.SchemaOptionAWSAssumeRole(KeyAWSAssumeRole))
+	if assumeRole == nil {
+		return &sdk.Context{Sess: sess}, nil
+	}
+
+	assumed, creds, err := sdk.AssumeRole(sess, *assumeRole)
+	if err != nil {
+		return nil, fmt.Errorf("assuming role %s: %w", assumeRole.RoleARN, err)
+	}
+
+	return &sdk.Context{Sess: assumed, Creds: creds}, nil
+}
@@ -0,0 +1,88 @@
+package helmfile
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestEKSSessionOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         map[string]interface{}
+		env          string
+		wantRegion   string
+		wantEndpoint string
+		wantProfile  string
+	}{
+		{
+			name:         "eks_cluster_region over aws_region",
+			data:         map[string]interface{}{KeyEKSClusterRegion: "eu-west-1", KeyAWSRegion: "us-east-1", KeyAWSProfile: "dev"},
+			wantRegion:   "eu-west-1",
+			wantProfile:  "dev",
+			wantEndpoint: "",
+		},
+		{
+			name:         "aws_endpoint over AWS_ENDPOINT_URL",
+			data:         map[string]interface{}{KeyAWSRegion: "us-east-1", KeyAWSEndpoint: "http://localhost:4566"},
+			env:          "http://localstack:4566",
+			wantRegion:   "us-east-1",
+			wantEndpoint: "http://localhost:4566",
+		},
+		{
+			name:         "AWS_ENDPOINT_URL",
+			data:         map[string]interface{}{KeyAWSRegion: "us-east-1"},
+			env:          "http://localstack:4566",
+			wantRegion:   "us-east-1",
+			wantEndpoint: "http://localstack:4566",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ENDPOINT_URL", tt.env)
+
+			opts := eksSessionOptions(&mockResourceRead{data: tt.data})
+
+			if got := aws.StringValue(opts.Config.Region); got != tt.wantRegion {
+				t.Errorf("expected region %q, got %q", tt.wantRegion, got)
+			}
+
+			if got := aws.StringValue(opts.Config.Endpoint); got != tt.wantEndpoint {
+				t.Errorf("expected endpoint %q, got %q", tt.wantEndpoint, got)
+			}
+
+			if opts.Profile != tt.wantProfile {
+				t.Errorf("expected profile %q, got %q", tt.wantProfile, opts.Profile)
+			}
+
+			if opts.SharedConfigState != session.SharedConfigEnable {
+				t.Errorf("expected the shared config to be enabled")
+			}
+		})
+	}
+}
+
+func TestNewEKSContext(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	ctx, err := newEKSContext(&mockResourceRead{data: map[string]interface{}{
+		KeyEKSClusterRegion: "eu-west-1",
+		KeyAWSRegion:        "us-east-1",
+		KeyAWSEndpoint:      "http://localhost:4566",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := ctx.Session().Config
+	if got := aws.StringValue(config.Region); got != "eu-west-1" {
+		t.Errorf("expected the session of the region of the cluster, got %q", got)
+	}
+
+	if got := aws.StringValue(config.Endpoint); got != "http://localhost:4566" {
+		t.Errorf("expected the session of aws_endpoint, got %q", got)
+	}
+}
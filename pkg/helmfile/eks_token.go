@@ -0,0 +1,143 @@
+package helmfile
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	// EKSAuthModeExec makes the generated kubeconfig run aws eks get-token, which needs the aws CLI
+	EKSAuthModeExec = "exec"
+
+	// EKSAuthModeNative makes the provider generate the token and write it to the generated kubeconfig
+	EKSAuthModeNative = "native"
+)
+
+const (
+	eksTokenPrefix = "k8s-aws-v1."
+
+	// eksClusterIDHeader is the signed header that binds the token to the cluster
+	eksClusterIDHeader = "x-k8s-aws-id"
+
+	// eksTokenPresignExpiry is what aws-iam-authenticator presigns with. EKS accepts the token for 15 minutes
+	// since it was signed regardless.
+	eksTokenPresignExpiry = 60 * time.Second
+)
+
+// eksTokenRefreshInterval is how often the token of the generated kubeconfig is regenerated while an operation runs,
+// well within the 15 minutes the token is valid for. Tests replace it.
+var eksTokenRefreshInterval = 10 * time.Minute
+
+// generateEKSToken generates the bearer token of the EKS cluster the same way aws-iam-authenticator and
+// aws eks get-token do, which is the presigned URL of STS GetCallerIdentity with the cluster name signed in
+func generateEKSToken(sess *session.Session, clusterName, region string) (string, error) {
+	if sess == nil {
+		return "", fmt.Errorf("AWS session is nil - ensure AWS credentials are configured")
+	}
+
+	client := sts.New(sess, &aws.Config{
+		Region:              aws.String(region),
+		STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
+	})
+
+	req, _ := client.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	req.HTTPRequest.Header.Add(eksClusterIDHeader, clusterName)
+
+	presigned, err := req.Presign(eksTokenPresignExpiry)
+	if err != nil {
+		return "", fmt.Errorf("presigning sts:GetCallerIdentity for EKS cluster %s: %w", clusterName, err)
+	}
+
+	return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned)), nil
+}
+
+// writeEKSKubeconfig generates the token when the release set uses the native auth mode, and writes the kubeconfig
+// of the cluster to the path. The file is replaced by a rename so that helm never reads it half-written.
+func writeEKSKubeconfig(fs *ReleaseSet, path string) error {
+	config := *fs.eksCluster
+
+	if fs.EKSAuthMode == EKSAuthModeNative {
+		token, err := generateEKSToken(fs.eksSession, config.ClusterName, config.Region)
+		if err != nil {
+			return err
+		}
+
+		config.Token = token
+	}
+
+	kubeconfigYAML, err := generateKubeconfigYAML(&config)
+	if err != nil {
+		return fmt.Errorf("generating kubeconfig: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("writing kubeconfig to %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(kubeconfigYAML); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing kubeconfig to %s: %w", path, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing kubeconfig to %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing kubeconfig to %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// keepEKSTokenFresh regenerates the token of the generated kubeconfig every eksTokenRefreshInterval until the
+// returned func is called or the context is done. helmfile runs helm anew for each diff and upgrade, so a long apply
+// that outlives the token picks up the new one between them. It does nothing unless the auth mode is native.
+func keepEKSTokenFresh(ctx context.Context, fs *ReleaseSet) func() {
+	if fs.EKSAuthMode != EKSAuthModeNative || fs.eksCluster == nil || fs.GeneratedKubeconfig == "" {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(eksTokenRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := writeEKSKubeconfig(fs, fs.GeneratedKubeconfig); err != nil {
+					logf("Warning: failed to refresh the EKS token of %s: %v", fs.GeneratedKubeconfig, err)
+					continue
+				}
+
+				logf("[DEBUG] Refreshed the EKS token of %s", fs.GeneratedKubeconfig)
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
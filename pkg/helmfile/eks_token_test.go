@@ -0,0 +1,134 @@
+package helmfile
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"gopkg.in/yaml.v2"
+)
+
+func newStaticCredentialsSession(t *testing.T) *session.Session {
+	t.Helper()
+
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sess
+}
+
+func TestGenerateEKSToken(t *testing.T) {
+	token, err := generateEKSToken(newStaticCredentialsSession(t), "my-cluster", "us-west-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(token, eksTokenPrefix) {
+		t.Fatalf("expected the token to start with %s, got %q", eksTokenPrefix, token)
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, eksTokenPrefix))
+	if err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+
+	u, err := url.Parse(string(bs))
+	if err != nil {
+		t.Fatalf("parsing presigned URL: %v", err)
+	}
+
+	if u.Host != "sts.us-west-2.amazonaws.com" {
+		t.Errorf("expected the regional STS endpoint, got %s", u.Host)
+	}
+
+	q := u.Query()
+	if q.Get("Action") != "GetCallerIdentity" {
+		t.Errorf("expected GetCallerIdentity to be presigned, got %q", q.Get("Action"))
+	}
+
+	if !strings.Contains(q.Get("X-Amz-SignedHeaders"), eksClusterIDHeader) {
+		t.Errorf("expected %s to be signed, got %q", eksClusterIDHeader, q.Get("X-Amz-SignedHeaders"))
+	}
+}
+
+func TestGenerateKubeconfigYAMLWithToken(t *testing.T) {
+	yamlStr, err := generateKubeconfigYAML(&EKSClusterConfig{
+		ClusterName: "test-cluster",
+		Region:      "us-west-2",
+		Endpoint:    "https://ABC123.gr7.us-west-2.eks.amazonaws.com",
+		CA:          "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+		Token:       "k8s-aws-v1.abc",
+	})
+	if err != nil {
+		t.Fatalf("generateKubeconfigYAML() error = %v", err)
+	}
+
+	var kubeconfig KubeconfigData
+	if err := yaml.Unmarshal([]byte(yamlStr), &kubeconfig); err != nil {
+		t.Fatalf("Failed to parse generated YAML: %v", err)
+	}
+
+	if got := kubeconfig.Users[0].User.Token; got != "k8s-aws-v1.abc" {
+		t.Errorf("expected the token to be written, got %q", got)
+	}
+
+	if strings.Contains(yamlStr, "exec:") {
+		t.Errorf("expected no exec plugin along with the token, got:\n%s", yamlStr)
+	}
+}
+
+func TestKeepEKSTokenFresh(t *testing.T) {
+	interval := eksTokenRefreshInterval
+	eksTokenRefreshInterval = 10 * time.Millisecond
+	t.Cleanup(func() { eksTokenRefreshInterval = interval })
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ReleaseSet{
+		EKSAuthMode:         EKSAuthModeNative,
+		GeneratedKubeconfig: path,
+		eksCluster:          &EKSClusterConfig{ClusterName: "my-cluster", Region: "us-west-2", Endpoint: "https://example.com"},
+		eksSession:          newStaticCredentialsSession(t),
+	}
+
+	stop := keepEKSTokenFresh(context.Background(), fs)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(bs), "token: "+eksTokenPrefix) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the kubeconfig to be rewritten with a token, got:\n%s", bs)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop()
+
+	if fs.eksCluster.Token != "" {
+		t.Errorf("expected the cluster config to be left as is, got the token %q", fs.eksCluster.Token)
+	}
+}
@@ -0,0 +1,278 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	EnvironmentCheckStatusOK      = "ok"
+	EnvironmentCheckStatusFailed  = "failed"
+	EnvironmentCheckStatusSkipped = "skipped"
+)
+
+// DefaultEnvironmentCheckTimeout is the default number of seconds each environment check can take
+const DefaultEnvironmentCheckTimeout = 3
+
+// EnvironmentCheck is the result of a check of the toolchain and cluster access
+type EnvironmentCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+func environmentChecksToList(checks []EnvironmentCheck) []interface{} {
+	list := make([]interface{}, 0, len(checks))
+	for _, c := range checks {
+		list = append(list, map[string]interface{}{
+			KeyEnvironmentCheckName:   c.Name,
+			KeyEnvironmentCheckStatus: c.Status,
+			KeyEnvironmentCheckDetail: c.Detail,
+		})
+	}
+	return list
+}
+
+// environmentCheckTarget is what the environment checks run against, configured like a release set
+type environmentCheckTarget struct {
+	HelmBin              string
+	Kubeconfig           string
+	EnvironmentVariables map[string]interface{}
+
+	// EKSClusterName and EKSRegion are set to describe the EKS cluster and generate the kubeconfig from it
+	EKSClusterName string
+	EKSRegion      string
+
+	// Context is the AWS context of the EKS checks
+	Context *sdk.Context
+
+	// Timeout is the time each check can take
+	Timeout time.Duration
+}
+
+// runEnvironmentChecks runs the non-mutating checks of the helm binary and plugins, and of the kubeconfig and the cluster.
+// The helm checks run concurrently with the cluster checks, which run one after another as each depends on the previous.
+func runEnvironmentChecks(t environmentCheckTarget) []EnvironmentCheck {
+	var (
+		helmChecks, clusterChecks []EnvironmentCheck
+		wg                        sync.WaitGroup
+	)
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		helmChecks = []EnvironmentCheck{checkHelmVersion(t), checkHelmPlugins(t)}
+	}()
+
+	go func() {
+		defer wg.Done()
+		clusterChecks = runClusterChecks(t)
+	}()
+
+	wg.Wait()
+
+	return append(helmChecks, clusterChecks...)
+}
+
+func runClusterChecks(t environmentCheckTarget) []EnvironmentCheck {
+	var checks []EnvironmentCheck
+
+	kubeconfig := t.Kubeconfig
+
+	if t.EKSClusterName != "" && kubeconfig == "" {
+		check, path := checkEKSCluster(t)
+		checks = append(checks, check)
+		if path != "" {
+			defer os.Remove(path)
+		}
+		kubeconfig = path
+	} else {
+		fs := &ReleaseSet{Kubeconfig: kubeconfig, EnvironmentVariables: t.EnvironmentVariables}
+		if k, err := getKubeconfig(fs); err == nil {
+			kubeconfig = *k
+		}
+	}
+
+	parse := checkKubeconfig(kubeconfig)
+	checks = append(checks, parse)
+
+	if parse.Status != EnvironmentCheckStatusOK {
+		return append(checks, EnvironmentCheck{Name: "cluster_version", Status: EnvironmentCheckStatusSkipped, Detail: "no valid kubeconfig"})
+	}
+
+	return append(checks, checkClusterVersion(t, kubeconfig))
+}
+
+// runHelm runs helm with the environment variables of the target, killing it after the timeout
+func runHelm(t environmentCheckTarget, args ...string) (string, error) {
+	helmBin := t.HelmBin
+	if helmBin == "" {
+		helmBin = "helm"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+
+	out := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, helmBin, args...)
+	cmd.Env = append(os.Environ(), readEnvironmentVariables(t.EnvironmentVariables, "")...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s %s timed out after %s", helmBin, strings.Join(args, " "), t.Timeout)
+		}
+		return "", fmt.Errorf("%s %s: %w: %s", helmBin, strings.Join(args, " "), err, strings.TrimSpace(out.String()))
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+func checkHelmVersion(t environmentCheckTarget) EnvironmentCheck {
+	out, err := runHelm(t, "version", "--short")
+	if err != nil {
+		return EnvironmentCheck{Name: "helm_version", Status: EnvironmentCheckStatusFailed, Detail: err.Error()}
+	}
+
+	return EnvironmentCheck{Name: "helm_version", Status: EnvironmentCheckStatusOK, Detail: out}
+}
+
+// checkHelmPlugins lists the helm plugins, failing when helm-diff that helmfile diff and apply require is missing
+func checkHelmPlugins(t environmentCheckTarget) EnvironmentCheck {
+	out, err := runHelm(t, "plugin", "list")
+	if err != nil {
+		return EnvironmentCheck{Name: "helm_plugins", Status: EnvironmentCheckStatusFailed, Detail: err.Error()}
+	}
+
+	var plugins []string
+	for i, l := range strings.Split(out, "\n") {
+		fields := strings.Fields(l)
+		// The first line is the NAME VERSION DESCRIPTION header
+		if i == 0 || len(fields) < 2 {
+			continue
+		}
+		plugins = append(plugins, fields[0]+" "+fields[1])
+	}
+
+	detail := strings.Join(plugins, ", ")
+
+	for _, p := range plugins {
+		if strings.HasPrefix(p, "diff ") {
+			return EnvironmentCheck{Name: "helm_plugins", Status: EnvironmentCheckStatusOK, Detail: detail}
+		}
+	}
+
+	return EnvironmentCheck{
+		Name:   "helm_plugins",
+		Status: EnvironmentCheckStatusFailed,
+		Detail: fmt.Sprintf("helm-diff is not installed. Install it with: helm plugin install https://github.com/databus23/helm-diff. Installed plugins: %s", detail),
+	}
+}
+
+// checkEKSCluster describes the EKS cluster and returns the path to the kubeconfig generated from it, to be removed by the caller
+func checkEKSCluster(t environmentCheckTarget) (EnvironmentCheck, string) {
+	const name = "eks_describe_cluster"
+
+	var config *EKSClusterConfig
+	err := withTimeout(t.Timeout, func() error {
+		var err error
+		config, err = fetchEKSClusterInfo(t.Context, t.EKSClusterName, t.EKSRegion)
+		return err
+	})
+	if err != nil {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: err.Error()}, ""
+	}
+
+	kubeconfigYAML, err := generateKubeconfigYAML(config)
+	if err != nil {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: err.Error()}, ""
+	}
+
+	f, err := ioutil.TempFile("", "kubeconfig-environment-check-")
+	if err != nil {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: err.Error()}, ""
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(kubeconfigYAML); err != nil {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: err.Error()}, f.Name()
+	}
+
+	return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusOK, Detail: "endpoint " + config.Endpoint}, f.Name()
+}
+
+// checkKubeconfig parses the kubeconfig and checks that its current context exists
+func checkKubeconfig(kubeconfig string) EnvironmentCheck {
+	const name = "kubeconfig"
+
+	if kubeconfig == "" {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: "neither kubeconfig nor eks_cluster_name is set"}
+	}
+
+	config, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: err.Error()}
+	}
+
+	current, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: fmt.Sprintf("current context %q not found in %s", config.CurrentContext, kubeconfig)}
+	}
+
+	return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusOK, Detail: fmt.Sprintf("context %s of cluster %s", config.CurrentContext, current.Cluster)}
+}
+
+// checkClusterVersion gets /version of the cluster to check that it is reachable with the credentials of the kubeconfig
+func checkClusterVersion(t environmentCheckTarget, kubeconfig string) EnvironmentCheck {
+	const name = "cluster_version"
+
+	var version string
+	err := withTimeout(t.Timeout, func() error {
+		client, err := newKubernetesClient(kubeconfig)
+		if err != nil {
+			return err
+		}
+
+		info, err := client.Discovery().ServerVersion()
+		if err != nil {
+			return err
+		}
+
+		version = info.GitVersion
+		return nil
+	})
+	if err != nil {
+		return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusFailed, Detail: err.Error()}
+	}
+
+	return EnvironmentCheck{Name: name, Status: EnvironmentCheckStatusOK, Detail: version}
+}
+
+// withTimeout runs f, returning an error without waiting for it when it takes longer than the timeout
+func withTimeout(timeout time.Duration, f func() error) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
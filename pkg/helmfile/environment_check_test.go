@@ -0,0 +1,151 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+
+// writeFakeHelm writes a helm script that prints the given plugin list, or sleeps when sleep is set
+func writeFakeHelm(t *testing.T, plugins string, sleep bool) string {
+	script := `#!/bin/sh
+case "$1" in
+version) echo v3.14.0+g3fc9f4b ;;
+plugin) printf '` + plugins + `' ;;
+esac
+`
+	if sleep {
+		script = "#!/bin/sh\nexec sleep 10\n"
+	}
+
+	path := filepath.Join(t.TempDir(), "helm")
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func checkStatuses(checks []EnvironmentCheck) map[string]string {
+	statuses := map[string]string{}
+	for _, c := range checks {
+		statuses[c.Name] = c.Status
+	}
+	return statuses
+}
+
+func TestRunEnvironmentChecks(t *testing.T) {
+	client := withFakeKubernetesClient(t)
+	client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.29.0"}
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(testKubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := runEnvironmentChecks(environmentCheckTarget{
+		HelmBin:    writeFakeHelm(t, `NAME\tVERSION\tDESCRIPTION\ndiff\t3.9.4\tPreview helm upgrade changes as a diff\n`, false),
+		Kubeconfig: kubeconfig,
+		Timeout:    time.Second,
+	})
+
+	want := []EnvironmentCheck{
+		{Name: "helm_version", Status: EnvironmentCheckStatusOK, Detail: "v3.14.0+g3fc9f4b"},
+		{Name: "helm_plugins", Status: EnvironmentCheckStatusOK, Detail: "diff 3.9.4"},
+		{Name: "kubeconfig", Status: EnvironmentCheckStatusOK, Detail: "context test of cluster test"},
+		{Name: "cluster_version", Status: EnvironmentCheckStatusOK, Detail: "v1.29.0"},
+	}
+
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("unexpected checks:\nwant: %+v\ngot:  %+v", want, checks)
+	}
+}
+
+func TestRunEnvironmentChecksFailures(t *testing.T) {
+	withFakeKubernetesClient(t)
+
+	t.Run("missing helm-diff and kubeconfig", func(t *testing.T) {
+		checks := runEnvironmentChecks(environmentCheckTarget{
+			HelmBin: writeFakeHelm(t, `NAME\tVERSION\tDESCRIPTION\nsecrets\t4.5.1\tThis plugin provides secrets values encryption\n`, false),
+			Timeout: time.Second,
+		})
+
+		want := map[string]string{
+			"helm_version":    EnvironmentCheckStatusOK,
+			"helm_plugins":    EnvironmentCheckStatusFailed,
+			"kubeconfig":      EnvironmentCheckStatusFailed,
+			"cluster_version": EnvironmentCheckStatusSkipped,
+		}
+		if got := checkStatuses(checks); !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected statuses: want %v, got %v", want, got)
+		}
+
+		if !strings.Contains(checks[1].Detail, "helm plugin install https://github.com/databus23/helm-diff") {
+			t.Errorf("expected the install command of helm-diff, got %q", checks[1].Detail)
+		}
+	})
+
+	t.Run("kubeconfig from environment_variables without current context", func(t *testing.T) {
+		kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+		if err := ioutil.WriteFile(kubeconfig, []byte(strings.Replace(testKubeconfig, "current-context: test", "current-context: other", 1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		checks := runEnvironmentChecks(environmentCheckTarget{
+			HelmBin:              filepath.Join(t.TempDir(), "missing"),
+			EnvironmentVariables: map[string]interface{}{"KUBECONFIG": kubeconfig},
+			Timeout:              time.Second,
+		})
+
+		want := map[string]string{
+			"helm_version":    EnvironmentCheckStatusFailed,
+			"helm_plugins":    EnvironmentCheckStatusFailed,
+			"kubeconfig":      EnvironmentCheckStatusFailed,
+			"cluster_version": EnvironmentCheckStatusSkipped,
+		}
+		if got := checkStatuses(checks); !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected statuses: want %v, got %v", want, got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+
+		checks := runEnvironmentChecks(environmentCheckTarget{
+			HelmBin: writeFakeHelm(t, "", true),
+			Timeout: 100 * time.Millisecond,
+		})
+
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expected the checks to time out, took %s", elapsed)
+		}
+
+		if !strings.Contains(checks[0].Detail, "timed out after 100ms") {
+			t.Errorf("expected the helm version check to time out, got %q", checks[0].Detail)
+		}
+	})
+}
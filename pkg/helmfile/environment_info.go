@@ -0,0 +1,135 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"gopkg.in/yaml.v2"
+)
+
+// sensitiveKeyPattern matches keys whose values must not be stored in the terraform state as-is
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private_?key|api_?key|access_?key)`)
+
+const redactedValue = "(redacted)"
+
+// captureEnvironmentInfo runs helmfile print-env and stores the resolved environment values into environment_info.
+// It is called after a successful apply, so any failure is logged rather than returned, to not fail the apply.
+func captureEnvironmentInfo(fs *ReleaseSet, files *helmfileFiles, d ResourceReadWrite, executor HelmfileExecutor) {
+	info, err := environmentInfo(fs, files, executor)
+	if err != nil {
+		logf("Warning: %v", err)
+		return
+	}
+
+	d.Set(KeyEnvironmentInfo, info)
+}
+
+// planEnvironmentInfo shows the environment values that the apply captures into environment_info in the plan.
+// The values are unknown until apply when the inputs resolving them are, or when they can't be resolved on plan.
+func planEnvironmentInfo(d *schema.ResourceDiff, fs *ReleaseSet, executor HelmfileExecutor) {
+	for _, key := range []string{KeyContent, KeyPath, KeyEnvironment, KeyValues, KeyValuesFiles, KeyStateValues} {
+		if !d.NewValueKnown(key) {
+			d.SetNewComputed(KeyEnvironmentInfo)
+			return
+		}
+	}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		logf("Warning: preparing helmfile file to capture environment values: %v", err)
+		d.SetNewComputed(KeyEnvironmentInfo)
+		return
+	}
+	defer files.remove()
+
+	info, err := environmentInfo(fs, files, executor)
+	if err != nil {
+		logf("Warning: %v", err)
+		d.SetNewComputed(KeyEnvironmentInfo)
+		return
+	}
+
+	d.SetNew(KeyEnvironmentInfo, info)
+}
+
+// environmentInfo returns the environment values printed by helmfile print-env, redacted and truncated
+func environmentInfo(fs *ReleaseSet, files *helmfileFiles, executor HelmfileExecutor) (string, error) {
+	opts := &PrintEnvOptions{
+		BaseOptions: *buildBaseOptions(fs, files),
+	}
+
+	result, err := executor.PrintEnv(context.Background(), opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to capture environment values: %w", err)
+	}
+
+	info, err := redactEnvironmentValues(result.Output)
+	if err != nil {
+		return "", fmt.Errorf("failed to redact environment values: %w", err)
+	}
+
+	return truncateOutput(info, fs.MaxDiffOutputLen, "environment_info", KeyMaxDiffOutputLen), nil
+}
+
+// redactEnvironmentValues replaces the values of sensitive keys in the multi-document YAML printed by helmfile print-env
+func redactEnvironmentValues(s string) (string, error) {
+	dec := yaml.NewDecoder(strings.NewReader(s))
+
+	var docs []string
+
+	for {
+		var doc yaml.MapSlice
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return "", fmt.Errorf("decoding environment values: %w", err)
+		}
+
+		bs, err := yaml.Marshal(redactValue(doc))
+		if err != nil {
+			return "", fmt.Errorf("encoding environment values: %w", err)
+		}
+
+		docs = append(docs, string(bs))
+	}
+
+	buf := &bytes.Buffer{}
+	for i, doc := range docs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.WriteString(doc)
+	}
+
+	return buf.String(), nil
+}
+
+func redactValue(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case yaml.MapSlice:
+		redacted := make(yaml.MapSlice, 0, len(typed))
+		for _, item := range typed {
+			if k, ok := item.Key.(string); ok && sensitiveKeyPattern.MatchString(k) {
+				redacted = append(redacted, yaml.MapItem{Key: item.Key, Value: redactedValue})
+			} else {
+				redacted = append(redacted, yaml.MapItem{Key: item.Key, Value: redactValue(item.Value)})
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, 0, len(typed))
+		for _, item := range typed {
+			redacted = append(redacted, redactValue(item))
+		}
+		return redacted
+	default:
+		return v
+	}
+}
@@ -0,0 +1,63 @@
+package helmfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactEnvironmentValues(t *testing.T) {
+	input := `filePath: /work/helmfile.yaml
+name: default
+values:
+  db:
+    host: db.example.com
+    password: hunter2
+  apiToken: abc
+  replicas: 3
+  items:
+  - name: foo
+    secretKey: bar
+---
+filePath: /work/helmfile.d/other.yaml
+name: default
+values:
+  region: us-west-2
+`
+
+	got, err := redactEnvironmentValues(input)
+	if err != nil {
+		t.Fatalf("redactEnvironmentValues failed: %v", err)
+	}
+
+	for _, s := range []string{"hunter2", "abc", "bar"} {
+		if strings.Contains(got, s) {
+			t.Errorf("expected %q to be redacted, got:\n%s", s, got)
+		}
+	}
+
+	for _, s := range []string{"host: db.example.com", "replicas: 3", "name: foo", "region: us-west-2", "password: (redacted)"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expected %q to be kept, got:\n%s", s, got)
+		}
+	}
+
+	if n := strings.Count(got, "---\n"); n != 1 {
+		t.Errorf("expected documents to be separated once, got %d separators:\n%s", n, got)
+	}
+
+	// Keys must keep the order printed by helmfile
+	if strings.Index(got, "filePath:") > strings.Index(got, "values:") {
+		t.Errorf("expected key order to be preserved, got:\n%s", got)
+	}
+}
+
+func TestRedactEnvironmentValuesEmpty(t *testing.T) {
+	got, err := redactEnvironmentValues("")
+	if err != nil {
+		t.Fatalf("redactEnvironmentValues failed: %v", err)
+	}
+
+	if got != "" {
+		t.Errorf("expected empty output, got %q", got)
+	}
+}
@@ -22,7 +22,7 @@ func TestEnvironmentVariablesPassedToCommand(t *testing.T) {
 	fs := &ReleaseSet{
 		Content:          "test: content",
 		WorkingDirectory: tempDir,
-		Kubeconfig:       "/tmp/kubeconfig",
+		Kubeconfig:       writeTestKubeconfig(t),
 		Bin:              "helmfile",
 		HelmBin:          "helm",
 		EnvironmentVariables: map[string]interface{}{
@@ -92,7 +92,7 @@ func TestEnvironmentVariablesPassedToCommand(t *testing.T) {
 	fs2 := &ReleaseSet{
 		Content:          "test: content",
 		WorkingDirectory: tempDir,
-		Kubeconfig:       "/tmp/kubeconfig",
+		Kubeconfig:       writeTestKubeconfig(t),
 		Bin:              "helmfile",
 		HelmBin:          "helm",
 		EnvironmentVariables: map[string]interface{}{
@@ -156,6 +156,7 @@ func TestEnvironmentVariablesKubeconfigValidation(t *testing.T) {
 // TestKubeconfigInEnvironmentOnly verifies KUBECONFIG can be set via environment_variables alone
 func TestKubeconfigInEnvironmentOnly(t *testing.T) {
 	tempDir := t.TempDir()
+	kubeconfig := writeTestKubeconfig(t)
 
 	fs := &ReleaseSet{
 		Content:          "test: content",
@@ -164,7 +165,7 @@ func TestKubeconfigInEnvironmentOnly(t *testing.T) {
 		Bin:              "helmfile",
 		HelmBin:          "helm",
 		EnvironmentVariables: map[string]interface{}{
-			"KUBECONFIG":  "/path/from/env/kubeconfig",
+			"KUBECONFIG":  kubeconfig,
 			"AWS_PROFILE": "test-profile",
 		},
 	}
@@ -187,7 +188,7 @@ func TestKubeconfigInEnvironmentOnly(t *testing.T) {
 		t.Error("KUBECONFIG not found in environment")
 	}
 
-	if !strings.Contains(kubeconfigValue, "kubeconfig") {
+	if kubeconfigValue != kubeconfig {
 		t.Errorf("KUBECONFIG should contain path from environment_variables: got %s", kubeconfigValue)
 	}
 
@@ -272,3 +273,110 @@ func TestReadEnvironmentVariables(t *testing.T) {
 		})
 	}
 }
+
+// TestIRSAEnvironmentVariablesPreserved verifies that the IRSA web identity and container credentials
+// environment variables survive into the environment of both the helmfile command and the library executor
+func TestIRSAEnvironmentVariablesPreserved(t *testing.T) {
+	irsaEnv := map[string]string{
+		"AWS_WEB_IDENTITY_TOKEN_FILE":        "/var/run/secrets/eks.amazonaws.com/serviceaccount/token",
+		"AWS_ROLE_ARN":                       "arn:aws:iam::123456789012:role/atlantis",
+		"AWS_ROLE_SESSION_NAME":              "atlantis",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://169.254.170.23/v1/credentials",
+	}
+	for k, v := range irsaEnv {
+		t.Setenv(k, v)
+	}
+
+	fs := &ReleaseSet{
+		Content:          "test: content",
+		WorkingDirectory: t.TempDir(),
+		Bin:              "helmfile",
+		EnvironmentVariables: map[string]interface{}{
+			"CUSTOM_VAR": "value",
+		},
+	}
+
+	cmd, err := NewCommandWithKubeconfig(fs, "version")
+	if err != nil {
+		t.Fatalf("NewCommandWithKubeconfig failed: %v", err)
+	}
+
+	for k, v := range irsaEnv {
+		if !strings.Contains(strings.Join(cmd.Env, "\n")+"\n", k+"="+v+"\n") {
+			t.Errorf("expected %s=%s in the command environment", k, v)
+		}
+	}
+
+	preserved := lookupPreservedEnvVars()
+	for k, v := range irsaEnv {
+		if preserved[k] != v {
+			t.Errorf("expected %s=%s to be preserved for the library executor, got %q", k, v, preserved[k])
+		}
+	}
+
+	restore := setEnvironmentVariables(fs.EnvironmentVariables)
+	for k, v := range irsaEnv {
+		if got := os.Getenv(k); got != v {
+			t.Errorf("expected %s=%s in the library executor environment, got %q", k, v, got)
+		}
+	}
+	restore()
+
+	for k, v := range irsaEnv {
+		if got := os.Getenv(k); got != v {
+			t.Errorf("expected %s=%s to be kept after restoring the environment, got %q", k, v, got)
+		}
+	}
+}
+
+// TestCommandArgsDeterministic verifies that the command line and environment are byte-identical
+// across repeated constructions, even though selectors and environment variables are maps
+func TestCommandArgsDeterministic(t *testing.T) {
+	tempDir := t.TempDir()
+	kubeconfig := writeTestKubeconfig(t)
+
+	newFs := func() *ReleaseSet {
+		return &ReleaseSet{
+			Content:          "releases: []",
+			WorkingDirectory: tempDir,
+			Kubeconfig:       kubeconfig,
+			Bin:              "helmfile",
+			Selector: map[string]interface{}{
+				"tier": "backend", "app": "api", "name": "api", "env": "prod", "team": "core",
+			},
+			Selectors:   []interface{}{"tier=frontend", "app=web"},
+			ValuesFiles: []interface{}{"b.yaml", "a.yaml"},
+			EnvironmentVariables: map[string]interface{}{
+				"ZZZ": "1", "AAA": "2", "MMM": "3", "BBB": "4", "YYY": "5",
+			},
+		}
+	}
+
+	first, err := NewCommandWithKubeconfig(newFs(), "diff")
+	if err != nil {
+		t.Fatalf("NewCommandWithKubeconfig failed: %v", err)
+	}
+
+	wantArgs := strings.Join(first.Args, "\x00")
+	wantEnv := strings.Join(first.Env, "\x00")
+
+	if !strings.Contains(wantArgs, "--state-values-file\x00b.yaml\x00--state-values-file\x00a.yaml") {
+		t.Errorf("expected values files in the configured order, got %v", first.Args)
+	}
+	if !strings.Contains(wantArgs, "--selector\x00app=api\x00--selector\x00env=prod\x00--selector\x00name=api") {
+		t.Errorf("expected selectors sorted by key, got %v", first.Args)
+	}
+
+	for i := 0; i < 20; i++ {
+		cmd, err := NewCommandWithKubeconfig(newFs(), "diff")
+		if err != nil {
+			t.Fatalf("NewCommandWithKubeconfig failed: %v", err)
+		}
+		if got := strings.Join(cmd.Args, "\x00"); got != wantArgs {
+			t.Fatalf("args differ between constructions:\n%v\n%v", first.Args, cmd.Args)
+		}
+		if got := strings.Join(cmd.Env, "\x00"); got != wantEnv {
+			t.Fatalf("env differs between constructions")
+		}
+	}
+}
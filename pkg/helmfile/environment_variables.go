@@ -0,0 +1,61 @@
+package helmfile
+
+import (
+	"fmt"
+)
+
+// releaseSetForApply returns a copy of the release set whose environment variables are
+// apply_environment_variables merged over environment_variables
+func releaseSetForApply(fs *ReleaseSet) *ReleaseSet {
+	applyFs := *fs
+	applyFs.EnvironmentVariables = mergeEnvironmentVariables(fs.EnvironmentVariables, fs.ApplyEnvironmentVariables)
+	return &applyFs
+}
+
+// releaseSetForDiff returns a copy of the release set whose environment variables are
+// diff_environment_variables merged over environment_variables.
+// apply_environment_variables are dropped so that changing them doesn't change the hash of the release set
+// that runDiff uses to keep the helmfile-diff output stable.
+func releaseSetForDiff(fs *ReleaseSet) *ReleaseSet {
+	diffFs := *fs
+	diffFs.EnvironmentVariables = mergeEnvironmentVariables(fs.EnvironmentVariables, fs.DiffEnvironmentVariables)
+	diffFs.ApplyEnvironmentVariables = nil
+	return &diffFs
+}
+
+func mergeEnvironmentVariables(base, overrides map[string]interface{}) map[string]interface{} {
+	if len(overrides) == 0 {
+		return base
+	}
+
+	merged := make(map[string]interface{}, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// validateOperationEnvironmentVariables fails when the operation-specific environment variables set KUBECONFIG
+// along with the kubeconfig attribute, like environment_variables.KUBECONFIG
+func validateOperationEnvironmentVariables(fs *ReleaseSet) error {
+	if fs.Kubeconfig == "" {
+		return nil
+	}
+
+	for _, ev := range []struct {
+		key  string
+		vars map[string]interface{}
+	}{
+		{key: KeyApplyEnvironmentVariables, vars: fs.ApplyEnvironmentVariables},
+		{key: KeyDiffEnvironmentVariables, vars: fs.DiffEnvironmentVariables},
+	} {
+		if v, ok := ev.vars["KUBECONFIG"]; ok && v.(string) != "" {
+			return fmt.Errorf("validating release set: helmfile_release_set.%s.KUBECONFIG cannot be set with helmfile_release_set.kubeconfig", ev.key)
+		}
+	}
+
+	return nil
+}
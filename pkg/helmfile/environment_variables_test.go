@@ -0,0 +1,80 @@
+package helmfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReleaseSetOperationEnvironmentVariables(t *testing.T) {
+	fs := &ReleaseSet{
+		EnvironmentVariables:      map[string]interface{}{"FOO": "base", "BAR": "base"},
+		ApplyEnvironmentVariables: map[string]interface{}{"FOO": "apply", "MIGRATE": "true"},
+		DiffEnvironmentVariables:  map[string]interface{}{"BAR": "diff"},
+	}
+
+	apply := releaseSetForApply(fs)
+	if want := map[string]interface{}{"FOO": "apply", "BAR": "base", "MIGRATE": "true"}; !reflect.DeepEqual(apply.EnvironmentVariables, want) {
+		t.Errorf("unexpected apply environment variables: want %v, got %v", want, apply.EnvironmentVariables)
+	}
+
+	diff := releaseSetForDiff(fs)
+	if want := map[string]interface{}{"FOO": "base", "BAR": "diff"}; !reflect.DeepEqual(diff.EnvironmentVariables, want) {
+		t.Errorf("unexpected diff environment variables: want %v, got %v", want, diff.EnvironmentVariables)
+	}
+
+	if want := map[string]interface{}{"FOO": "base", "BAR": "base"}; !reflect.DeepEqual(fs.EnvironmentVariables, want) {
+		t.Errorf("expected the release set to be left as is, got %v", fs.EnvironmentVariables)
+	}
+}
+
+func TestReleaseSetForDiffIgnoresApplyEnvironmentVariables(t *testing.T) {
+	fs := &ReleaseSet{
+		Content:                   "releases: []",
+		EnvironmentVariables:      map[string]interface{}{"FOO": "base"},
+		ApplyEnvironmentVariables: map[string]interface{}{"MIGRATE": "true"},
+	}
+
+	first, err := HashObject(releaseSetForDiff(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.ApplyEnvironmentVariables = map[string]interface{}{"MIGRATE": "false"}
+
+	second, err := HashObject(releaseSetForDiff(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Error("expected apply_environment_variables to not change the diff fingerprint")
+	}
+}
+
+func TestOperationEnvironmentVariablesKubeconfigConflict(t *testing.T) {
+	for _, key := range []string{KeyApplyEnvironmentVariables, KeyDiffEnvironmentVariables} {
+		t.Run(key, func(t *testing.T) {
+			fs := &ReleaseSet{Kubeconfig: "kubeconfig"}
+
+			vars := map[string]interface{}{"KUBECONFIG": "other"}
+			if key == KeyApplyEnvironmentVariables {
+				fs.ApplyEnvironmentVariables = vars
+			} else {
+				fs.DiffEnvironmentVariables = vars
+			}
+
+			_, err := getKubeconfig(fs)
+			if err == nil || !strings.Contains(err.Error(), key+".KUBECONFIG cannot be set with helmfile_release_set.kubeconfig") {
+				t.Errorf("expected a conflict error naming %s, got %v", key, err)
+			}
+		})
+	}
+
+	path := writeTestKubeconfig(t)
+
+	fs := &ReleaseSet{DiffEnvironmentVariables: map[string]interface{}{"KUBECONFIG": path}}
+	if kubeconfig, err := getKubeconfig(releaseSetForDiff(fs)); err != nil || *kubeconfig != path {
+		t.Errorf("expected diff_environment_variables.KUBECONFIG to be used on diff without the kubeconfig attribute, got %v, %v", kubeconfig, err)
+	}
+}
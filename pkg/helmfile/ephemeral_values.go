@@ -0,0 +1,118 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ephemeralValues are YAML strings of sensitive state values that are never written to disk.
+// They are redacted when the release set is printed, like in the debug logs.
+type ephemeralValues []string
+
+func (v ephemeralValues) String() string {
+	return fmt.Sprintf("[%d sensitive value(s)]", len(v))
+}
+
+func (v ephemeralValues) GoString() string {
+	return v.String()
+}
+
+// getEphemeralValues reads ephemeral_values, skipping the empty ones
+func getEphemeralValues(v interface{}) ephemeralValues {
+	var values ephemeralValues
+
+	items, _ := v.([]interface{})
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
+
+// ephemeralStateValues merges the ephemeral values into the state values that are layered after all the other values
+func ephemeralStateValues(values ephemeralValues) (map[string]interface{}, error) {
+	vs := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		vs = append(vs, v)
+	}
+
+	merged, err := inlineStateValues(vs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", KeyEphemeralValues, err)
+	}
+
+	return merged, nil
+}
+
+// withEphemeralStateValues returns the state values to pass to the embedded helmfile, with the ephemeral values merged
+// over the given ones. The ephemeral values are validated by NewReleaseSet, so they are skipped if invalid.
+func withEphemeralStateValues(stateValues map[string]interface{}, values ephemeralValues) map[string]interface{} {
+	if len(values) == 0 {
+		return stateValues
+	}
+
+	ephemeral, err := ephemeralStateValues(values)
+	if err != nil {
+		return stateValues
+	}
+
+	return mergeValues(mergeValues(map[string]interface{}{}, stateValues), ephemeral)
+}
+
+// ephemeralValuesHash returns the hash of the ephemeral values that is recorded in the state to detect their changes.
+// It is empty without ephemeral values.
+func ephemeralValuesHash(values ephemeralValues) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	bs, err := json.Marshal([]string(values))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(bs)), nil
+}
+
+// ephemeralValuesFiles returns the memory-backed files of the ephemeral values for the helmfile binary, and their paths
+// as seen by helmfile when they are passed as the extra files of the command.
+func ephemeralValuesFiles(values ephemeralValues) ([]*os.File, []string, error) {
+	var (
+		files []*os.File
+		paths []string
+	)
+
+	for i, v := range values {
+		for _, doc := range splitValuesDocuments(v) {
+			f, err := memoryFile(fmt.Sprintf("%s-%d", KeyEphemeralValues, i), []byte(doc))
+			if err != nil {
+				closeFiles(files)
+				return nil, nil, err
+			}
+
+			// The extra files of a command start at the file descriptor 3 in the child process
+			paths = append(paths, fmt.Sprintf("/dev/fd/%d", 3+len(files)))
+			files = append(files, f)
+		}
+	}
+
+	return files, paths, nil
+}
+
+// closeExtraFiles closes the files passed to the command, like the ephemeral values, once it has run
+func closeExtraFiles(cmd *exec.Cmd) {
+	closeFiles(cmd.ExtraFiles)
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
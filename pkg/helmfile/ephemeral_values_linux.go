@@ -0,0 +1,25 @@
+package helmfile
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// memoryFile returns an anonymous memory-backed file with the content, which is never written to disk
+func memoryFile(name string, content []byte) (*os.File, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("creating memory file: %w", err)
+	}
+
+	f := os.NewFile(uintptr(fd), name)
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("writing memory file: %w", err)
+	}
+
+	return f, nil
+}
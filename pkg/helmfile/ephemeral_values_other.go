@@ -0,0 +1,15 @@
+//go:build !linux
+
+package helmfile
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// memoryFile fails, as there is no way to pass the ephemeral values to the helmfile binary without writing them to disk
+// on this platform
+func memoryFile(name string, content []byte) (*os.File, error) {
+	return nil, fmt.Errorf("%s can't be passed to the helmfile binary on %s without writing them to disk. It is only supported on linux", KeyEphemeralValues, runtime.GOOS)
+}
@@ -0,0 +1,165 @@
+package helmfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// fakeStateValuesHelmfile prints the content of each state values file it is given
+const fakeStateValuesHelmfile = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --state-values-file) echo "== $2"; cat "$2"; shift 2;;
+    *) shift;;
+  esac
+done
+`
+
+func TestEphemeralValuesWithHelmfileBinary(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ephemeral_values are passed to the helmfile binary only on linux")
+	}
+
+	dir := t.TempDir()
+	working := filepath.Join(dir, "work")
+	if err := os.MkdirAll(working, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "helmfile"), []byte(fakeStateValuesHelmfile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubeconfig"), []byte(testKubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	fs := &ReleaseSet{
+		Bin:              filepath.Join(dir, "helmfile"),
+		Content:          "releases: []\n",
+		WorkingDirectory: working,
+		Kubeconfig:       filepath.Join(dir, "kubeconfig"),
+		Values:           []interface{}{"replicas: 2\n"},
+		EphemeralValues:  ephemeralValues{"password: s3cr3t\n", "token: t0k3n\n---\napiKey: k3y\n"},
+	}
+
+	state, err := runTemplate(&sdk.Context{}, fs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The ephemeral values are layered after the values, one file per document
+	var got []string
+	for _, l := range strings.Split(state.Output, "\n") {
+		if l != "" && !strings.HasPrefix(l, "== ") {
+			got = append(got, l)
+		}
+	}
+	if want := []string{"replicas: 2", "password: s3cr3t", "token: t0k3n", "apiKey: k3y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected state values:\nwant: %v\ngot:  %v\noutput:\n%s", want, got, state.Output)
+	}
+	if !strings.Contains(state.Output, "== /dev/fd/3\n") {
+		t.Errorf("expected the ephemeral values to be read from the file descriptors, got:\n%s", state.Output)
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(bs), "s3cr3t") || strings.Contains(string(bs), "k3y") {
+			t.Errorf("expected the ephemeral values not to be written to disk, found them in %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The output of the fake helmfile is logged, but the release set itself must not reveal the values
+	for _, l := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(l, "Running helmfile") && strings.Contains(l, "s3cr3t") {
+			t.Errorf("expected the ephemeral values to be redacted from the debug logs, got:\n%s", l)
+		}
+	}
+}
+
+func TestEphemeralValuesWithEmbeddedHelmfile(t *testing.T) {
+	fs := &ReleaseSet{
+		ValuesHandling:  ValuesHandlingInline,
+		EphemeralValues: ephemeralValues{"db:\n  password: s3cr3t\n"},
+	}
+	files := &helmfileFiles{
+		Path:         "helmfile.yaml",
+		InlineValues: map[string]interface{}{"db": map[string]interface{}{"host": "db", "password": "placeholder"}},
+	}
+
+	opts := buildBaseOptions(fs, files)
+
+	want := map[string]interface{}{"db": map[string]interface{}{"host": "db", "password": "s3cr3t"}}
+	if !reflect.DeepEqual(opts.StateValuesSet, want) {
+		t.Errorf("expected the ephemeral values to be layered after the inline values:\nwant: %v\ngot:  %v", want, opts.StateValuesSet)
+	}
+	if files.InlineValues["db"].(map[string]interface{})["password"] != "placeholder" {
+		t.Error("expected the inline values not to be modified")
+	}
+
+	fs = &ReleaseSet{EphemeralValues: ephemeralValues{"password: s3cr3t\n"}}
+
+	if got := buildBaseOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}).StateValuesSet; !reflect.DeepEqual(got, map[string]interface{}{"password": "s3cr3t"}) {
+		t.Errorf("unexpected state values: %v", got)
+	}
+}
+
+func TestEphemeralValuesRedacted(t *testing.T) {
+	fs := ReleaseSet{EphemeralValues: ephemeralValues{"password: s3cr3t\n"}}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		if got := fmt.Sprintf(format, fs); strings.Contains(got, "s3cr3t") {
+			t.Errorf("expected the ephemeral values to be redacted with %s, got %s", format, got)
+		}
+	}
+}
+
+func TestEphemeralValuesHash(t *testing.T) {
+	if hash, err := ephemeralValuesHash(nil); err != nil || hash != "" {
+		t.Errorf("expected no hash without ephemeral values, got %q, %v", hash, err)
+	}
+
+	first, err := ephemeralValuesHash(ephemeralValues{"password: s3cr3t\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(first, "s3cr3t") {
+		t.Errorf("expected the hash not to contain the values, got %s", first)
+	}
+
+	second, _ := ephemeralValuesHash(ephemeralValues{"password: changed\n"})
+	if first == second {
+		t.Error("expected the hash to change with the values")
+	}
+}
+
+func TestEphemeralStateValuesInvalid(t *testing.T) {
+	_, err := ephemeralStateValues(ephemeralValues{"- not a map\n"})
+	if err == nil || !strings.HasPrefix(err.Error(), KeyEphemeralValues+": ") {
+		t.Errorf("expected an error about %s, got %v", KeyEphemeralValues, err)
+	}
+}
@@ -4,13 +4,25 @@ import (
 	"context"
 )
 
+const (
+	// ExecutorLibrary runs helmfile embedded in the provider
+	ExecutorLibrary = "library"
+
+	// ExecutorBinary runs the helmfile binary, for custom helmfile builds and helm plugins the embedded helmfile lacks
+	ExecutorBinary = "binary"
+)
+
 // HelmfileExecutor defines the interface for executing helmfile operations.
 // This abstraction allows for multiple implementations (binary vs library).
 type HelmfileExecutor interface {
 	// Apply runs helmfile apply/sync to deploy releases
 	Apply(ctx context.Context, opts *ApplyOptions) (*Result, error)
 
-	// Diff runs helmfile diff to show changes
+	// Sync runs helmfile sync to deploy releases without the diff that apply runs first
+	Sync(ctx context.Context, opts *SyncOptions) (*Result, error)
+
+	// Diff runs helmfile diff to show changes.
+	// With DetailedExitcode, the changes are reported as ExitCode 2 without an error, like the helmfile binary does.
 	Diff(ctx context.Context, opts *DiffOptions) (*Result, error)
 
 	// Template runs helmfile template to render manifests
@@ -22,6 +34,15 @@ type HelmfileExecutor interface {
 	// Build runs helmfile build to validate configuration
 	Build(ctx context.Context, opts *BuildOptions) (*Result, error)
 
+	// PrintEnv runs helmfile print-env to show the resolved environment values
+	PrintEnv(ctx context.Context, opts *PrintEnvOptions) (*Result, error)
+
+	// List runs helmfile list to show the releases matching the selectors as JSON
+	List(ctx context.Context, opts *ListOptions) (*Result, error)
+
+	// Lint runs helmfile lint to validate the charts and values without cluster access
+	Lint(ctx context.Context, opts *LintOptions) (*Result, error)
+
 	// Version returns the helmfile version
 	Version(ctx context.Context) (string, error)
 }
@@ -31,6 +52,10 @@ type Result struct {
 	// Output is the stdout/stderr from the operation
 	Output string
 
+	// Stdout and Stderr are the streams of Output, for the executors that capture them separately
+	Stdout string
+	Stderr string
+
 	// ExitCode is the exit code (0 for success)
 	ExitCode int
 
@@ -38,6 +63,15 @@ type Result struct {
 	Error error
 }
 
+// stdout returns the stdout of the operation, or the whole output when the executor doesn't capture the streams separately
+func (r *Result) stdout() string {
+	if r.Stdout == "" && r.Stderr == "" {
+		return r.Output
+	}
+
+	return r.Stdout
+}
+
 // BaseOptions contains common options for all helmfile operations
 type BaseOptions struct {
 	// FileOrDir is the path to helmfile.yaml or directory containing it
@@ -70,6 +104,12 @@ type BaseOptions struct {
 	// Values is a list of inline values (YAML strings)
 	Values []interface{}
 
+	// StateValuesSet are state values layered after ValuesFiles, like --state-values-set
+	StateValuesSet map[string]interface{}
+
+	// StateValues are the state values passed to helmfile as --state-values-set key=value, over all the other state values
+	StateValues map[string]interface{}
+
 	// EnvironmentVariables are environment variables to set
 	EnvironmentVariables map[string]interface{}
 
@@ -81,6 +121,32 @@ type BaseOptions struct {
 
 	// EnableGoTemplate enables Go template rendering (.gotmpl extension)
 	EnableGoTemplate bool
+
+	// LogLevel is the level of the helmfile logs captured in the output, either debug, info or warn. Empty means info
+	LogLevel string
+
+	// LiveOutput forwards the output to the debug log line by line as it is produced, in addition to returning it
+	LiveOutput bool
+
+	// LogPrefix prefixes the lines of the live output in the debug log, like the release set they are for, so that
+	// the output of the release sets applied in parallel can be told apart
+	LogPrefix string
+
+	// SkipDeps skips helm repo update and helm dependency build for the charts, like --skip-deps
+	SkipDeps bool
+
+	// SkipNeeds leaves out the needs of the releases matching the selectors, like --skip-needs
+	SkipNeeds bool
+
+	// IncludeNeeds adds the needs of the releases matching the selectors, like --include-needs
+	IncludeNeeds bool
+
+	// IncludeTransitiveNeeds adds the needs of the needs too, like --include-transitive-needs
+	IncludeTransitiveNeeds bool
+
+	// ExtraArgs are the global flags of the helmfile binary that the provider doesn't model.
+	// The embedded helmfile has no command line, so they are passed to helm like --args.
+	ExtraArgs []string
 }
 
 // ApplyOptions contains options for helmfile apply/sync
@@ -93,11 +159,91 @@ type ApplyOptions struct {
 	// ReleasesValues is a map of release-specific values
 	ReleasesValues map[string]interface{}
 
+	// Set are the name=value pairs of the set blocks, passed to helm as --set after ReleasesValues
+	Set []string
+
+	// ReleasesValuesFiles are helm values files generated from releases_values_string
+	ReleasesValuesFiles []string
+
 	// SkipDiffOnInstall skips diff when installing (helmfile >= 0.136.0)
 	SkipDiffOnInstall bool
 
 	// SuppressSecrets suppresses secret values in output
 	SuppressSecrets bool
+
+	// SkipSchemaValidation passes --skip-schema-validation to helm
+	SkipSchemaValidation bool
+
+	// SkipCRDs passes --skip-crds to helm upgrade
+	SkipCRDs bool
+
+	// NoHooks passes --no-hooks to helm upgrade and helm-diff
+	NoHooks bool
+
+	// ReuseValues passes --reuse-values to helm upgrade, merging the values of the last release into the new ones
+	ReuseValues bool
+
+	// ResetValues passes --reset-values to helm upgrade, ignoring the values of the last release
+	ResetValues bool
+
+	// DiffArgs are passed to helm-diff as is, like --diff-args
+	DiffArgs string
+
+	// SyncArgs are passed to helm upgrade as is, like --sync-args
+	SyncArgs string
+
+	// Wait passes --wait to helm upgrade, so that the apply returns after the workloads are ready
+	Wait bool
+
+	// WaitForJobs passes --wait-for-jobs to helm upgrade, so that the apply returns after the jobs completed
+	WaitForJobs bool
+
+	// Context is the number of lines of context of the diff before the upgrade
+	Context int
+
+	// SuppressOutputLineRegex are the regexes of the lines to remove from the diff before the upgrade
+	SuppressOutputLineRegex []string
+}
+
+// SyncOptions contains options for helmfile sync
+type SyncOptions struct {
+	BaseOptions
+
+	// Concurrency is the number of concurrent operations
+	Concurrency int
+
+	// ReleasesValues is a map of release-specific values
+	ReleasesValues map[string]interface{}
+
+	// Set are the name=value pairs of the set blocks, passed to helm as --set after ReleasesValues
+	Set []string
+
+	// ReleasesValuesFiles are helm values files generated from releases_values_string
+	ReleasesValuesFiles []string
+
+	// SkipSchemaValidation passes --skip-schema-validation to helm
+	SkipSchemaValidation bool
+
+	// SkipCRDs passes --skip-crds to helm upgrade
+	SkipCRDs bool
+
+	// NoHooks passes --no-hooks to helm upgrade
+	NoHooks bool
+
+	// ReuseValues passes --reuse-values to helm upgrade, merging the values of the last release into the new ones
+	ReuseValues bool
+
+	// ResetValues passes --reset-values to helm upgrade, ignoring the values of the last release
+	ResetValues bool
+
+	// SyncArgs are passed to helm upgrade as is, like --sync-args
+	SyncArgs string
+
+	// Wait passes --wait to helm upgrade, so that the sync returns after the workloads are ready
+	Wait bool
+
+	// WaitForJobs passes --wait-for-jobs to helm upgrade, so that the sync returns after the jobs completed
+	WaitForJobs bool
 }
 
 // DiffOptions contains options for helmfile diff
@@ -110,6 +256,12 @@ type DiffOptions struct {
 	// ReleasesValues is a map of release-specific values
 	ReleasesValues map[string]interface{}
 
+	// Set are the name=value pairs of the set blocks, passed to helm as --set after ReleasesValues
+	Set []string
+
+	// ReleasesValuesFiles are helm values files generated from releases_values_string
+	ReleasesValuesFiles []string
+
 	// DetailedExitcode enables detailed exit codes
 	DetailedExitcode bool
 
@@ -119,8 +271,36 @@ type DiffOptions struct {
 	// Context is the number of lines of context (default: 3)
 	Context int
 
-	// MaxDiffOutputLen is the maximum length of diff output
+	// SuppressOutputLineRegex are the regexes of the lines to remove from the diff
+	SuppressOutputLineRegex []string
+
+	// KubeVersion is passed to helm-diff as --kube-version
+	KubeVersion string
+
+	// APIVersions are passed to helm-diff as --api-versions
+	APIVersions []string
+
+	// MaxDiffOutputLen is the maximum length of diff output, beyond which it is snipped with a notice.
+	// Zero means DefaultMaxDiffOutputLen.
 	MaxDiffOutputLen int
+
+	// SkipSchemaValidation passes --skip-schema-validation to helm
+	SkipSchemaValidation bool
+
+	// SkipCRDs leaves the CRDs out of the charts that helmfile prepares for helm-diff
+	SkipCRDs bool
+
+	// NoHooks passes --no-hooks to helm-diff
+	NoHooks bool
+
+	// ReuseValues passes --reuse-values to helm-diff, merging the values of the last release into the new ones
+	ReuseValues bool
+
+	// ResetValues passes --reset-values to helm-diff, ignoring the values of the last release
+	ResetValues bool
+
+	// DiffArgs are passed to helm-diff as is, like --diff-args
+	DiffArgs string
 }
 
 // TemplateOptions contains options for helmfile template
@@ -138,6 +318,24 @@ type TemplateOptions struct {
 
 	// OutputDirTemplate is the template for output directory structure
 	OutputDirTemplate string
+
+	// OutputFileTemplate is the template for output file names
+	OutputFileTemplate string
+
+	// SkipSchemaValidation passes --skip-schema-validation to helm
+	SkipSchemaValidation bool
+
+	// NoHooks passes --no-hooks to helm template
+	NoHooks bool
+
+	// SkipTests passes --skip-tests to helm template
+	SkipTests bool
+
+	// KubeVersion is the .Capabilities.KubeVersion of the rendered charts, passed to helm template as --kube-version
+	KubeVersion string
+
+	// APIVersions are added to the .Capabilities.APIVersions of the rendered charts, passed to helm template as --api-versions
+	APIVersions []string
 }
 
 // DestroyOptions contains options for helmfile destroy
@@ -146,6 +344,15 @@ type DestroyOptions struct {
 
 	// Concurrency is the number of concurrent operations
 	Concurrency int
+
+	// Cascade is the deletion propagation of helm uninstall, either background, foreground or orphan. Empty means helm's default.
+	Cascade string
+
+	// DeleteWait makes helm uninstall wait until the resources of the releases are deleted
+	DeleteWait bool
+
+	// DeleteTimeout is the time in seconds helm uninstall waits for with DeleteWait. Zero means helmfile's default.
+	DeleteTimeout int
 }
 
 // BuildOptions contains options for helmfile build
@@ -155,3 +362,21 @@ type BuildOptions struct {
 	// EmbedValues embeds values inline (helmfile >= 0.126.0)
 	EmbedValues bool
 }
+
+// PrintEnvOptions contains options for helmfile print-env
+type PrintEnvOptions struct {
+	BaseOptions
+}
+
+// ListOptions contains options for helmfile list
+type ListOptions struct {
+	BaseOptions
+}
+
+// LintOptions contains options for helmfile lint
+type LintOptions struct {
+	BaseOptions
+
+	// Concurrency is the number of concurrent operations
+	Concurrency int
+}
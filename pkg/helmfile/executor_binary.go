@@ -0,0 +1,580 @@
+package helmfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BinaryExecutor implements HelmfileExecutor by running the helmfile binary.
+// It is for custom helmfile builds, pinned helmfile versions and helm plugins that the embedded helmfile can't use.
+type BinaryExecutor struct {
+	// bin is the helmfile binary run when the options don't specify HelmfileBinary, and that reports the version
+	bin string
+
+	// pinned is the release set whose helmfile and helm versions are installed and run instead, like with the legacy
+	// commands. It is nil unless the release set pins a version.
+	pinned *ReleaseSet
+
+	versionMu sync.Mutex
+	version   string
+}
+
+// NewBinaryExecutor creates a new BinaryExecutor that runs the helmfile binary
+func NewBinaryExecutor(bin string) *BinaryExecutor {
+	if bin == "" {
+		bin = "helmfile"
+	}
+
+	return &BinaryExecutor{
+		bin: bin,
+	}
+}
+
+// newReleaseSetBinaryExecutor creates a BinaryExecutor that runs the helmfile of the release set, or the helmfile and
+// helm versions it pins
+func newReleaseSetBinaryExecutor(fs *ReleaseSet) *BinaryExecutor {
+	e := NewBinaryExecutor(fs.Bin)
+
+	if fs.Version != "" || fs.HelmVersion != "" {
+		e.pinned = fs
+	}
+
+	return e
+}
+
+// installBinaries installs the helmfile and helm versions pinned by the release set, returning their paths
+var installBinaries = prepareBinaries
+
+// binaries returns the helmfile and helm binaries to run with the options, installing the pinned versions
+func (e *BinaryExecutor) binaries(opts BaseOptions) (string, string, error) {
+	helmfileBin, helmBin := opts.HelmfileBinary, opts.HelmBinary
+	if helmfileBin == "" {
+		helmfileBin = e.bin
+	}
+
+	if e.pinned == nil {
+		return helmfileBin, helmBin, nil
+	}
+
+	installedHelmfileBin, installedHelmBin, err := installBinaries(e.pinned)
+	if err != nil {
+		return "", "", fmt.Errorf("installing helmfile %q and helm %q: %w", e.pinned.Version, e.pinned.HelmVersion, err)
+	}
+
+	if e.pinned.Version != "" {
+		helmfileBin = *installedHelmfileBin
+	}
+
+	if e.pinned.HelmVersion != "" {
+		helmBin = *installedHelmBin
+	}
+
+	return helmfileBin, helmBin, nil
+}
+
+// Apply implements HelmfileExecutor.Apply by running helmfile apply
+func (e *BinaryExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	args := []string{"apply"}
+	args = append(args, concurrencyFlags(opts.Concurrency)...)
+	args = append(args, needsFlags(opts.SkipNeeds, opts.IncludeNeeds, opts.IncludeTransitiveNeeds)...)
+	args = append(args, releasesValuesFlags(opts.ReleasesValues, opts.Set, opts.ReleasesValuesFiles)...)
+
+	args = append(args, secretsFlags(opts.SuppressSecrets)...)
+
+	if opts.SkipDiffOnInstall {
+		args = append(args, "--skip-diff-on-install")
+	}
+
+	if opts.SkipSchemaValidation {
+		args = append(args, "--skip-schema-validation")
+	}
+
+	if opts.SkipCRDs {
+		args = append(args, "--skip-crds")
+	}
+
+	// helmfile apply passes --no-hooks only to helm-diff, so it's passed to helm upgrade too
+	if opts.NoHooks {
+		args = append(args, "--no-hooks")
+	}
+
+	if opts.DiffArgs != "" {
+		args = append(args, "--diff-args", opts.DiffArgs)
+	}
+
+	if syncArgs := syncArgs(opts.NoHooks, opts.SyncArgs); syncArgs != "" {
+		args = append(args, "--sync-args", syncArgs)
+	}
+
+	args = append(args, lastValuesFlags(opts.ReuseValues, opts.ResetValues)...)
+
+	args = append(args, waitFlags(opts.Wait, opts.WaitForJobs)...)
+	args = append(args, diffFilterFlags(opts.Context, opts.SuppressOutputLineRegex)...)
+
+	return e.run(ctx, opts.BaseOptions, args...)
+}
+
+// Sync implements HelmfileExecutor.Sync by running helmfile sync
+func (e *BinaryExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
+	args := []string{"sync"}
+	args = append(args, concurrencyFlags(opts.Concurrency)...)
+	args = append(args, needsFlags(opts.SkipNeeds, opts.IncludeNeeds, opts.IncludeTransitiveNeeds)...)
+	args = append(args, releasesValuesFlags(opts.ReleasesValues, opts.Set, opts.ReleasesValuesFiles)...)
+
+	if opts.SkipSchemaValidation {
+		args = append(args, "--skip-schema-validation")
+	}
+
+	if opts.SkipCRDs {
+		args = append(args, "--skip-crds")
+	}
+
+	if syncArgs := syncArgs(opts.NoHooks, opts.SyncArgs); syncArgs != "" {
+		args = append(args, "--sync-args", syncArgs)
+	}
+
+	args = append(args, lastValuesFlags(opts.ReuseValues, opts.ResetValues)...)
+
+	args = append(args, waitFlags(opts.Wait, opts.WaitForJobs)...)
+
+	return e.run(ctx, opts.BaseOptions, args...)
+}
+
+// waitFlags returns the flags of helmfile apply and sync that make helm wait for the workloads and the jobs.
+// The timeout of the wait is the helmDefaults.timeout injected for wait_timeout, as helmfile apply has no --timeout.
+func waitFlags(wait, waitForJobs bool) []string {
+	var args []string
+
+	if wait {
+		args = append(args, "--wait")
+	}
+
+	if waitForJobs {
+		args = append(args, "--wait-for-jobs")
+	}
+
+	return args
+}
+
+// needsFlags returns the flags of helmfile apply, sync, diff, template and lint that either leave out or add the needs
+// of the releases matching the selectors. The helmfile binary leaves them out by default unless it's given --include-needs,
+// which takes precedence over --skip-needs.
+func needsFlags(skipNeeds, includeNeeds, includeTransitiveNeeds bool) []string {
+	switch {
+	case includeTransitiveNeeds:
+		return []string{"--include-transitive-needs"}
+	case includeNeeds:
+		return []string{"--include-needs"}
+	case skipNeeds:
+		return []string{"--skip-needs"}
+	}
+
+	return nil
+}
+
+// lastValuesFlags returns the flag of helmfile apply, sync and diff that either merges the values of the last release
+// into the new ones or ignores them, overriding helmDefaults.reuseValues
+func lastValuesFlags(reuseValues, resetValues bool) []string {
+	switch {
+	case reuseValues:
+		return []string{"--reuse-values"}
+	case resetValues:
+		return []string{"--reset-values"}
+	}
+
+	return nil
+}
+
+// noHooksSyncArgs are the args of helm upgrade that disable the hooks, which helmfile apply and sync pass only as --sync-args
+const noHooksSyncArgs = "--no-hooks"
+
+// secretsFlags returns the flag of helmfile apply and diff that either suppresses the changes of Secrets,
+// or shows them with their values like the library executor does, rather than helm-diff's redacted ones
+func secretsFlags(suppressSecrets bool) []string {
+	if suppressSecrets {
+		return []string{"--suppress-secrets"}
+	}
+
+	return []string{"--show-secrets"}
+}
+
+// diffFilterFlags returns the flags of helmfile apply and diff that narrow the diff of each release
+// to the changed lines with their context, and remove the lines matching the regexes
+func diffFilterFlags(context int, suppressOutputLineRegex []string) []string {
+	var args []string
+
+	if context > 0 {
+		args = append(args, "--context", strconv.Itoa(context))
+	}
+
+	for _, r := range suppressOutputLineRegex {
+		args = append(args, "--suppress-output-line-regex", r)
+	}
+
+	return args
+}
+
+// Diff implements HelmfileExecutor.Diff by running helmfile diff
+func (e *BinaryExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result, error) {
+	args := []string{"diff"}
+	args = append(args, concurrencyFlags(opts.Concurrency)...)
+	args = append(args, needsFlags(opts.SkipNeeds, opts.IncludeNeeds, opts.IncludeTransitiveNeeds)...)
+	args = append(args, releasesValuesFlags(opts.ReleasesValues, opts.Set, opts.ReleasesValuesFiles)...)
+
+	if opts.DetailedExitcode {
+		args = append(args, "--detailed-exitcode")
+	}
+
+	args = append(args, secretsFlags(opts.SuppressSecrets)...)
+
+	args = append(args, diffFilterFlags(opts.Context, opts.SuppressOutputLineRegex)...)
+
+	if opts.NoHooks {
+		args = append(args, "--no-hooks")
+	}
+
+	args = append(args, lastValuesFlags(opts.ReuseValues, opts.ResetValues)...)
+
+	if opts.SkipSchemaValidation {
+		args = append(args, "--skip-schema-validation")
+	}
+
+	// helmfile diff has no --kube-version and --api-versions, so they are passed to helm-diff along with diff_args
+	if diffArgs := joinArgs(append([]string{opts.DiffArgs}, capabilitiesArgs(opts.KubeVersion, opts.APIVersions)...)...); diffArgs != "" {
+		args = append(args, "--diff-args", diffArgs)
+	}
+
+	result, err := e.run(ctx, opts.BaseOptions, args...)
+	if result == nil {
+		return nil, err
+	}
+
+	// The diff of a big release set can be megabytes, which would blow up the plan and the state
+	result.Output = truncateOutput(result.Output, opts.MaxDiffOutputLen, "helmfile-diff output", KeyMaxDiffOutputLen)
+	result.Stdout = truncateOutput(result.Stdout, opts.MaxDiffOutputLen, "helmfile-diff output", KeyMaxDiffOutputLen)
+
+	// With --detailed-exitcode, helmfile exits with 2 when it found changes
+	if opts.DetailedExitcode && result.ExitCode == 2 {
+		result.Error = nil
+		return result, nil
+	}
+
+	return result, err
+}
+
+// Template implements HelmfileExecutor.Template by running helmfile template
+func (e *BinaryExecutor) Template(ctx context.Context, opts *TemplateOptions) (*Result, error) {
+	args := []string{"template"}
+	args = append(args, concurrencyFlags(opts.Concurrency)...)
+	args = append(args, needsFlags(opts.SkipNeeds, opts.IncludeNeeds, opts.IncludeTransitiveNeeds)...)
+
+	if opts.IncludeCRDs {
+		args = append(args, "--include-crds")
+	}
+
+	if opts.OutputDir != "" {
+		args = append(args, "--output-dir", opts.OutputDir)
+	}
+
+	if opts.OutputDirTemplate != "" {
+		args = append(args, "--output-dir-template", opts.OutputDirTemplate)
+	}
+
+	if opts.OutputFileTemplate != "" {
+		args = append(args, "--output-file-template", opts.OutputFileTemplate)
+	}
+
+	if opts.SkipSchemaValidation {
+		args = append(args, "--skip-schema-validation")
+	}
+
+	if opts.NoHooks {
+		args = append(args, "--no-hooks")
+	}
+
+	if opts.SkipTests {
+		args = append(args, "--skip-tests")
+	}
+
+	if opts.KubeVersion != "" {
+		args = append(args, "--kube-version", opts.KubeVersion)
+	}
+
+	// helmfile template has no --api-versions, so they are passed to helm template instead
+	if apiVersions := capabilitiesArgs("", opts.APIVersions); len(apiVersions) > 0 {
+		args = append(args, "--args", strings.Join(apiVersions, " "))
+	}
+
+	return e.run(ctx, opts.BaseOptions, args...)
+}
+
+// Destroy implements HelmfileExecutor.Destroy by running helmfile destroy
+func (e *BinaryExecutor) Destroy(ctx context.Context, opts *DestroyOptions) (*Result, error) {
+	args := append([]string{"destroy"}, concurrencyFlags(opts.Concurrency)...)
+
+	if opts.Cascade != "" {
+		args = append(args, "--cascade", opts.Cascade)
+	}
+
+	// DeleteWait and DeleteTimeout reach helm uninstall via the helmDefaults injected into the helmfile
+
+	return e.run(ctx, opts.BaseOptions, args...)
+}
+
+// Build implements HelmfileExecutor.Build by running helmfile build
+func (e *BinaryExecutor) Build(ctx context.Context, opts *BuildOptions) (*Result, error) {
+	args := []string{"build"}
+
+	if opts.EmbedValues {
+		args = append(args, "--embed-values")
+	}
+
+	return e.runStdout(ctx, opts.BaseOptions, args...)
+}
+
+// PrintEnv implements HelmfileExecutor.PrintEnv by running helmfile print-env
+func (e *BinaryExecutor) PrintEnv(ctx context.Context, opts *PrintEnvOptions) (*Result, error) {
+	return e.runStdout(ctx, opts.BaseOptions, "print-env", "--output", "yaml")
+}
+
+// List implements HelmfileExecutor.List by running helmfile list
+func (e *BinaryExecutor) List(ctx context.Context, opts *ListOptions) (*Result, error) {
+	return e.runStdout(ctx, opts.BaseOptions, "list", "--output", "json", "--skip-charts")
+}
+
+// Lint implements HelmfileExecutor.Lint by running helmfile lint
+func (e *BinaryExecutor) Lint(ctx context.Context, opts *LintOptions) (*Result, error) {
+	args := append([]string{"lint"}, concurrencyFlags(opts.Concurrency)...)
+	args = append(args, needsFlags(opts.SkipNeeds, opts.IncludeNeeds, opts.IncludeTransitiveNeeds)...)
+
+	return e.run(ctx, opts.BaseOptions, args...)
+}
+
+// Version implements HelmfileExecutor.Version by running helmfile version.
+// The version is detected once per executor.
+func (e *BinaryExecutor) Version(ctx context.Context) (string, error) {
+	e.versionMu.Lock()
+	defer e.versionMu.Unlock()
+
+	if e.version != "" {
+		return e.version, nil
+	}
+
+	bin, _, err := e.binaries(BaseOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	out, err := exec.CommandContext(ctx, bin, "version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("running %s version: %w\n%s", bin, err, out)
+	}
+
+	v, err := parseVersion(string(out))
+	if err != nil {
+		return "", err
+	}
+
+	e.version = v.String()
+
+	return e.version, nil
+}
+
+// run runs helmfile with the args, returning its combined stdout and stderr as the output
+func (e *BinaryExecutor) run(ctx context.Context, opts BaseOptions, args ...string) (*Result, error) {
+	streams, exitCode, err := e.runCommand(ctx, opts, args...)
+
+	return &Result{
+		Output:   redactSensitiveValues(streams.combined.String()),
+		Stdout:   redactSensitiveValues(streams.stdout.String()),
+		Stderr:   redactSensitiveValues(streams.stderr.String()),
+		ExitCode: exitCode,
+		Error:    err,
+	}, err
+}
+
+// runStdout runs helmfile with the args, returning its stdout as the output, like the JSON of helmfile list,
+// and the stderr along with it only on failure
+func (e *BinaryExecutor) runStdout(ctx context.Context, opts BaseOptions, args ...string) (*Result, error) {
+	streams, exitCode, err := e.runCommand(ctx, opts, args...)
+
+	output := streams.stdout.String()
+	if err != nil {
+		output += streams.stderr.String()
+	}
+
+	return &Result{
+		Output:   redactSensitiveValues(output),
+		Stdout:   redactSensitiveValues(streams.stdout.String()),
+		Stderr:   redactSensitiveValues(streams.stderr.String()),
+		ExitCode: exitCode,
+		Error:    err,
+	}, err
+}
+
+// runCommand runs helmfile with the global flags of the options followed by the args, stopping it when the context is done.
+// A non-zero exit status is returned along with an error that includes the stderr, like the exit status 2 of helmfile
+// diff with --detailed-exitcode.
+func (e *BinaryExecutor) runCommand(ctx context.Context, opts BaseOptions, args ...string) (*outputStreams, int, error) {
+	streams := &outputStreams{}
+
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts)
+	if err != nil {
+		return streams, 1, err
+	}
+	defer removeValuesFiles()
+
+	bin, helmBin, err := e.binaries(base)
+	if err != nil {
+		return streams, 1, err
+	}
+	base.HelmBinary = helmBin
+
+	flags, extraFiles, err := binaryGlobalFlags(base)
+	if err != nil {
+		return streams, 1, err
+	}
+
+	cmd := exec.Command(bin, append(flags, args...)...)
+	cmd.Dir = base.WorkingDirectory
+	cmd.Env = commandEnv(&base)
+	cmd.ExtraFiles = extraFiles
+	defer closeExtraFiles(cmd)
+
+	cmd = commandWithContext(ctx, cmd)
+	streams.attach(cmd)
+
+	// The output is forwarded to the debug log as it is produced, as applies of big release sets take many minutes
+	if base.LiveOutput {
+		liveStdout, liveStderr := &liveOutputWriter{prefix: base.LogPrefix}, &liveOutputWriter{prefix: base.LogPrefix}
+		defer liveStdout.Flush()
+		defer liveStderr.Flush()
+
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, liveStdout)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, liveStderr)
+	}
+
+	logf("[DEBUG] Running helmfile: wd = %s, args = %s", cmd.Dir, strings.Join(cmd.Args, " "))
+
+	if err := cmd.Run(); err != nil {
+		exitCode := 1
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			exitCode = exitErr.ExitCode()
+		}
+
+		return streams, exitCode, fmt.Errorf("%s: %w\n%s", cmd.Path, err, redactSensitiveValues(streams.stderr.String()))
+	}
+
+	return streams, 0, nil
+}
+
+// binaryGlobalFlags returns the helmfile flags for the options, along with the memory-backed file of the state values
+// that are passed as the extra file of the command, so that the ephemeral values among them are never written to disk
+func binaryGlobalFlags(opts BaseOptions) ([]string, []*os.File, error) {
+	flags := []string{
+		"--file", opts.FileOrDir,
+		"--no-color",
+	}
+
+	if opts.HelmBinary != "" {
+		flags = append(flags, "--helm-binary", opts.HelmBinary)
+	}
+
+	if opts.KubeContext != "" {
+		flags = append(flags, "--kube-context", opts.KubeContext)
+	}
+
+	if opts.Namespace != "" {
+		flags = append(flags, "--namespace", opts.Namespace)
+	}
+
+	if opts.Environment != "" {
+		flags = append(flags, "--environment", opts.Environment)
+	}
+
+	for _, k := range sortedKeys(opts.Selector) {
+		flags = append(flags, "--selector", fmt.Sprintf("%s=%s", k, opts.Selector[k]))
+	}
+
+	for _, selector := range convertSelectorsToStrings(opts.Selectors) {
+		flags = append(flags, "--selector", selector)
+	}
+
+	for _, f := range convertToStringSlice(opts.ValuesFiles) {
+		flags = append(flags, "--state-values-file", f)
+	}
+
+	flags = append(flags, stateValuesSetFlags(opts.StateValues)...)
+
+	if opts.SkipDeps {
+		flags = append(flags, "--skip-deps")
+	}
+
+	flags = append(flags, opts.ExtraArgs...)
+
+	if len(opts.StateValuesSet) == 0 {
+		return flags, nil, nil
+	}
+
+	bs, err := yaml.Marshal(opts.StateValuesSet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling state values: %w", err)
+	}
+
+	f, err := memoryFile("state-values", bs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The extra files of a command start at the file descriptor 3 in the child process
+	flags = append(flags, "--state-values-file", "/dev/fd/3")
+
+	return flags, []*os.File{f}, nil
+}
+
+// concurrencyFlags returns the --concurrency flag, which is omitted for the default of unlimited concurrency
+func concurrencyFlags(concurrency int) []string {
+	if concurrency <= 0 {
+		return nil
+	}
+
+	return []string{"--concurrency", strconv.Itoa(concurrency)}
+}
+
+// releasesValuesFlags returns the flags that pass releases_values and releases_values_string to helm
+func releasesValuesFlags(values map[string]interface{}, set []string, valuesFiles []string) []string {
+	var flags []string
+
+	for _, s := range append(setFlagValues(values), set...) {
+		flags = append(flags, "--set", s)
+	}
+
+	for _, f := range valuesFiles {
+		flags = append(flags, "--values", f)
+	}
+
+	return flags
+}
+
+// commandEnv returns the environment of the commands run for the options: the environment variables of the options
+// over the ones of the provider, with KUBECONFIG set to the resolved kubeconfig
+func commandEnv(base *BaseOptions) []string {
+	env := append(os.Environ(), readEnvironmentVariables(base.EnvironmentVariables, "KUBECONFIG")...)
+	if base.Kubeconfig != "" {
+		env = append(env, "KUBECONFIG="+base.Kubeconfig)
+	}
+
+	return env
+}
@@ -0,0 +1,583 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBinaryHelmfile records its args and KUBECONFIG to args, quoting the args with spaces, prints the state values
+// files and the file stdout, and exits with the status in exit-code. It prints the releases of helmfile list
+// to stdout and a warning to stderr.
+const fakeBinaryHelmfile = `#!/bin/sh
+dir=$(dirname "$0")
+line="KUBECONFIG=$KUBECONFIG"
+for arg in "$@"; do
+  case "$arg" in
+    *" "*) line="$line '$arg'";;
+    *) line="$line $arg";;
+  esac
+done
+echo "$line" > "$dir/args"
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --state-values-file) cat "$2"; shift;;
+    list) echo '[{"name":"app","namespace":"default"}]';;
+    version) echo "helmfile version v0.150.0";;
+  esac
+  shift
+done
+cat "$dir/stdout" 2>/dev/null
+echo "warning from helmfile" >&2
+exit $(cat "$dir/exit-code" 2>/dev/null || echo 0)
+`
+
+func newFakeBinaryHelmfile(t *testing.T) (string, *BinaryExecutor) {
+	t.Helper()
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "helmfile")
+	if err := ioutil.WriteFile(bin, []byte(fakeBinaryHelmfile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, NewBinaryExecutor(bin)
+}
+
+func readFakeBinaryArgs(t *testing.T, dir string) string {
+	t.Helper()
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(bs))
+}
+
+func TestBinaryExecutorApply(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	opts := &ApplyOptions{
+		BaseOptions: BaseOptions{
+			FileOrDir:        "helmfile.yaml",
+			WorkingDirectory: dir,
+			Kubeconfig:       "/tmp/kubeconfig",
+			Environment:      "prod",
+			Selectors:        []interface{}{"tier=backend"},
+			ValuesFiles:      []interface{}{"values.yaml"},
+			HelmBinary:       "helm3",
+		},
+		Concurrency:         2,
+		ReleasesValues:      map[string]interface{}{"image.tag": "v1"},
+		Set:                 []string{"replicaCount=3"},
+		ReleasesValuesFiles: []string{"releases-values.yaml"},
+		SuppressSecrets:     true,
+		SkipDiffOnInstall:   true,
+	}
+
+	result, err := executor.Apply(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "KUBECONFIG=/tmp/kubeconfig --file helmfile.yaml --no-color --helm-binary helm3 --environment prod --selector tier=backend " +
+		"--state-values-file values.yaml apply --concurrency 2 --set image.tag=v1 --set replicaCount=3 --values releases-values.yaml --suppress-secrets --skip-diff-on-install"
+	if got := readFakeBinaryArgs(t, dir); got != want {
+		t.Errorf("unexpected args:\nwant: %s\ngot:  %s", want, got)
+	}
+
+	if result.ExitCode != 0 || !strings.Contains(result.Output, "warning from helmfile") {
+		t.Errorf("expected the combined output with exit code 0, got %+v", result)
+	}
+
+	if !strings.Contains(result.Stderr, "warning from helmfile") || strings.Contains(result.Stdout, "warning from helmfile") {
+		t.Errorf("expected the stderr to be kept out of the stdout, got stdout %q and stderr %q", result.Stdout, result.Stderr)
+	}
+}
+
+func TestBinaryExecutorWait(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, SuppressSecrets: true, Wait: true, WaitForJobs: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " apply --suppress-secrets --wait --wait-for-jobs") {
+		t.Errorf("expected helmfile apply to wait, got %s", got)
+	}
+
+	if _, err := executor.Sync(context.Background(), &SyncOptions{BaseOptions: base, Wait: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " sync --wait") {
+		t.Errorf("expected helmfile sync to wait, got %s", got)
+	}
+}
+
+func TestBinaryExecutorShowSecrets(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " apply --show-secrets") {
+		t.Errorf("expected helmfile apply to show secrets, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " diff --show-secrets") {
+		t.Errorf("expected helmfile diff to show secrets, got %s", got)
+	}
+}
+
+func TestBinaryExecutorDiffFilters(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+	regexes := []string{`checksum/config`, `rollme:`}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, SuppressSecrets: true, Context: 1, SuppressOutputLineRegex: regexes}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " apply --suppress-secrets --context 1 --suppress-output-line-regex checksum/config --suppress-output-line-regex rollme:") {
+		t.Errorf("expected helmfile apply to filter the diff, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base, SuppressSecrets: true, Context: 1, SuppressOutputLineRegex: regexes}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " diff --suppress-secrets --context 1 --suppress-output-line-regex checksum/config --suppress-output-line-regex rollme:") {
+		t.Errorf("expected helmfile diff to filter the diff, got %s", got)
+	}
+}
+
+func TestBinaryExecutorCapabilities(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+	apiVersions := []string{"monitoring.coreos.com/v1", "policy/v1beta1"}
+
+	if _, err := executor.Template(context.Background(), &TemplateOptions{BaseOptions: base, KubeVersion: "1.29.0", APIVersions: apiVersions}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " template --kube-version 1.29.0 --args '--api-versions=monitoring.coreos.com/v1 --api-versions=policy/v1beta1'") {
+		t.Errorf("expected helmfile template to render for the Kubernetes and API versions, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base, SuppressSecrets: true, KubeVersion: "1.29.0", APIVersions: apiVersions}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --diff-args '--kube-version=1.29.0 --api-versions=monitoring.coreos.com/v1 --api-versions=policy/v1beta1'") {
+		t.Errorf("expected helmfile diff to pass the Kubernetes and API versions to helm-diff, got %s", got)
+	}
+}
+
+func TestBinaryExecutorCRDs(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, SuppressSecrets: true, SkipCRDs: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " apply --suppress-secrets --skip-crds") {
+		t.Errorf("expected helmfile apply to skip the CRDs, got %s", got)
+	}
+
+	if _, err := executor.Sync(context.Background(), &SyncOptions{BaseOptions: base, SkipCRDs: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " sync --skip-crds") {
+		t.Errorf("expected helmfile sync to skip the CRDs, got %s", got)
+	}
+
+	if _, err := executor.Template(context.Background(), &TemplateOptions{BaseOptions: base, IncludeCRDs: false}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " template") {
+		t.Errorf("expected helmfile template to leave the CRDs out, got %s", got)
+	}
+}
+
+func TestBinaryExecutorHooks(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, SuppressSecrets: true, NoHooks: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " apply --suppress-secrets --no-hooks --sync-args --no-hooks") {
+		t.Errorf("expected helmfile apply to neither diff nor run the hooks, got %s", got)
+	}
+
+	if _, err := executor.Sync(context.Background(), &SyncOptions{BaseOptions: base, NoHooks: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " sync --sync-args --no-hooks") {
+		t.Errorf("expected helmfile sync not to run the hooks, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base, SuppressSecrets: true, NoHooks: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " diff --suppress-secrets --no-hooks") {
+		t.Errorf("expected helmfile diff not to diff the hooks, got %s", got)
+	}
+
+	if _, err := executor.Template(context.Background(), &TemplateOptions{BaseOptions: base, NoHooks: true, SkipTests: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " template --no-hooks --skip-tests") {
+		t.Errorf("expected helmfile template to leave out the hooks and the tests, got %s", got)
+	}
+
+	if got := diffOutputFlags(&ReleaseSet{NoHooks: true}); got[len(got)-1] != "--no-hooks" {
+		t.Errorf("expected helmfile diff on plan not to diff the hooks, got %v", got)
+	}
+}
+
+func TestBinaryExecutorSkipDepsAndNeeds(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir, SkipDeps: true, SkipNeeds: true}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, SuppressSecrets: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --skip-deps apply --skip-needs --suppress-secrets") {
+		t.Errorf("expected helmfile apply to skip the dependencies and the needs, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base, SuppressSecrets: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --skip-deps diff --skip-needs --suppress-secrets") {
+		t.Errorf("expected helmfile diff to skip the dependencies and the needs, got %s", got)
+	}
+
+	base.SkipDeps, base.SkipNeeds = false, false
+
+	if _, err := executor.Template(context.Background(), &TemplateOptions{BaseOptions: base}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); strings.Contains(got, "--skip-deps") || strings.Contains(got, "--skip-needs") {
+		t.Errorf("expected no flags by default, got %s", got)
+	}
+}
+
+func TestBinaryExecutorIncludeNeeds(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir, SkipNeeds: true, IncludeNeeds: true}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, SuppressSecrets: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " apply --include-needs --suppress-secrets") {
+		t.Errorf("expected helmfile apply to include the needs instead of skipping them, got %s", got)
+	}
+
+	base.IncludeTransitiveNeeds = true
+
+	if _, err := executor.Sync(context.Background(), &SyncOptions{BaseOptions: base}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " sync --include-transitive-needs") {
+		t.Errorf("expected helmfile sync to include the transitive needs, got %s", got)
+	}
+}
+
+func TestBinaryExecutorDestroyCascade(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	opts := buildDestroyOptions(&ReleaseSet{DestroyCascade: DestroyCascadeForeground, DeleteWait: true, DeleteTimeout: 90 * time.Second}, &helmfileFiles{Path: "helmfile.yaml"})
+	opts.WorkingDirectory = dir
+
+	if !opts.DeleteWait || opts.DeleteTimeout != 90 {
+		t.Errorf("expected DestroyOptions to wait for 90 seconds, got %v and %d", opts.DeleteWait, opts.DeleteTimeout)
+	}
+
+	if _, err := executor.Destroy(context.Background(), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " destroy --cascade foreground") {
+		t.Errorf("expected helmfile destroy to pass the cascade, got %s", got)
+	}
+}
+
+func TestBinaryExecutorHelmfileBinary(t *testing.T) {
+	dir, _ := newFakeBinaryHelmfile(t)
+
+	executor := NewBinaryExecutor("")
+	if _, err := executor.Destroy(context.Background(), &DestroyOptions{BaseOptions: BaseOptions{
+		FileOrDir:      "helmfile.yaml",
+		HelmfileBinary: filepath.Join(dir, "helmfile"),
+	}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, "destroy") {
+		t.Errorf("expected helmfile destroy to be run by the helmfile binary of the options, got %q", got)
+	}
+}
+
+func TestBinaryExecutorDiff(t *testing.T) {
+	tests := []struct {
+		name         string
+		exitCode     string
+		wantExitCode int
+		wantErr      bool
+	}{
+		{name: "no changes", exitCode: "0", wantExitCode: 0},
+		{name: "changes", exitCode: "2", wantExitCode: 2},
+		{name: "failure", exitCode: "1", wantExitCode: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, executor := newFakeBinaryHelmfile(t)
+			if err := ioutil.WriteFile(filepath.Join(dir, "exit-code"), []byte(tt.exitCode), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := executor.Diff(context.Background(), &DiffOptions{
+				BaseOptions:      BaseOptions{FileOrDir: "helmfile.yaml"},
+				DetailedExitcode: true,
+				SuppressSecrets:  true,
+				Context:          3,
+			})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantExitCode, result.ExitCode)
+			}
+
+			if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, "diff --detailed-exitcode --suppress-secrets --context 3") {
+				t.Errorf("unexpected args %q", got)
+			}
+		})
+	}
+}
+
+func TestBinaryExecutorList(t *testing.T) {
+	_, executor := newFakeBinaryHelmfile(t)
+
+	result, err := executor.List(context.Background(), &ListOptions{BaseOptions: BaseOptions{FileOrDir: "helmfile.yaml"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The stderr would break the JSON
+	if want := "[{\"name\":\"app\",\"namespace\":\"default\"}]\n"; result.Output != want {
+		t.Errorf("expected the stdout %q, got %q", want, result.Output)
+	}
+}
+
+func TestBinaryExecutorStateValues(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the state values are passed in memory only on linux")
+	}
+
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	result, err := executor.Template(context.Background(), &TemplateOptions{BaseOptions: BaseOptions{
+		FileOrDir:        "helmfile.yaml",
+		WorkingDirectory: dir,
+		Values:           []interface{}{"replicas: 2\n"},
+		StateValuesSet:   map[string]interface{}{"password": "s3cr3t"},
+		StateValues:      map[string]interface{}{"cluster.name": "prod"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(result.Output, "replicas: 2") || !strings.Contains(result.Output, "password: s3cr3t") {
+		t.Errorf("expected helmfile to read the values and the state values, got %q", result.Output)
+	}
+
+	got := readFakeBinaryArgs(t, dir)
+	if strings.Contains(got, "s3cr3t") {
+		t.Errorf("expected the state values not to be passed as args, got %q", got)
+	}
+
+	if !strings.Contains(got, "--state-values-set cluster.name=prod") {
+		t.Errorf("expected state_values to be passed with --state-values-set, got %q", got)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, "temp.values-*.yaml")); len(matches) > 0 {
+		t.Errorf("expected the values files to be removed, found %v", matches)
+	}
+}
+
+func TestBinaryExecutorVersion(t *testing.T) {
+	_, executor := newFakeBinaryHelmfile(t)
+
+	v, err := executor.Version(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if v != "0.150.0" {
+		t.Errorf("expected version 0.150.0, got %q", v)
+	}
+}
+
+func TestBinaryExecutorPinnedVersions(t *testing.T) {
+	dir, _ := newFakeBinaryHelmfile(t)
+
+	var installed []*ReleaseSet
+	installBinaries = func(fs *ReleaseSet) (*string, *string, error) {
+		installed = append(installed, fs)
+
+		helmfileBin, helmBin := filepath.Join(dir, "helmfile"), "/opt/shoal/bin/helm"
+
+		return &helmfileBin, &helmBin, nil
+	}
+	t.Cleanup(func() { installBinaries = prepareBinaries })
+
+	// The helmfile on PATH doesn't exist, so that the operations only succeed by running the pinned version
+	fs := &ReleaseSet{Bin: "helmfile-on-path", HelmBin: "helm", Version: "0.150.0", HelmVersion: "3.12.0", WorkingDirectory: dir}
+
+	executor := (&ProviderInstance{ExecutorName: ExecutorBinary}).executorFor(fs)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir, HelmfileBinary: fs.Bin, HelmBinary: fs.HelmBin}
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.Contains(got, " --helm-binary /opt/shoal/bin/helm ") {
+		t.Errorf("expected the pinned helm to be passed to helmfile, got %s", got)
+	}
+
+	if v, err := executor.Version(context.Background()); err != nil || v != "0.150.0" {
+		t.Errorf("expected the version of the pinned helmfile, got %q, %v", v, err)
+	}
+
+	if len(installed) == 0 || installed[0] != fs {
+		t.Errorf("expected the pinned versions of the release set to be installed, got %v", installed)
+	}
+
+	// Without pinned versions, nothing is installed and the missing helmfile on PATH runs
+	installed = nil
+
+	if _, err := newReleaseSetBinaryExecutor(&ReleaseSet{Bin: fs.Bin}).Apply(context.Background(), &ApplyOptions{BaseOptions: base}); err == nil || !strings.Contains(err.Error(), "helmfile-on-path") {
+		t.Errorf("expected helmfile-on-path to run, got %v", err)
+	}
+
+	if len(installed) != 0 {
+		t.Errorf("expected nothing to be installed, got %v", installed)
+	}
+}
+
+func TestExecutorFor(t *testing.T) {
+	library := &fakeExecutor{}
+
+	tests := []struct {
+		name     string
+		provider string
+		resource string
+		want     string
+	}{
+		{name: "provider default", provider: ExecutorLibrary, want: ExecutorLibrary},
+		{name: "resource overrides library", provider: ExecutorLibrary, resource: ExecutorBinary, want: ExecutorBinary},
+		{name: "provider binary", provider: ExecutorBinary, want: ExecutorBinary},
+		{name: "resource overrides binary", provider: ExecutorBinary, resource: ExecutorLibrary, want: ExecutorLibrary},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProviderInstance{Executor: library, LibraryExecutor: library, ExecutorName: tt.provider}
+
+			got := p.executorFor(&ReleaseSet{Executor: tt.resource, Bin: "my-helmfile"})
+
+			// The operations hold the lock on the working directory, which is shared without the isolated scratch_directory
+			locked, ok := got.(*lockedExecutor)
+			if !ok {
+				t.Fatalf("expected the executor to lock the working directory, got %T", got)
+			}
+			got = locked.HelmfileExecutor
+
+			switch e := got.(type) {
+			case *BinaryExecutor:
+				if tt.want != ExecutorBinary || e.bin != "my-helmfile" {
+					t.Errorf("expected %s executor, got binary executor running %s", tt.want, e.bin)
+				}
+			case *LibraryExecutor:
+				if tt.want != ExecutorLibrary {
+					t.Errorf("expected %s executor, got library executor", tt.want)
+				}
+			default:
+				if tt.want != ExecutorLibrary || got != library {
+					t.Errorf("expected %s executor, got %T", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBinaryExecutorLiveOutput(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	quiet, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Contains(logs.String(), "helmfile: warning from helmfile") {
+		t.Fatalf("expected no live output without LiveOutput, got:\n%s", logs.String())
+	}
+
+	live, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir, LiveOutput: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if live.Output != quiet.Output {
+		t.Errorf("expected the output to be unaffected, got %q, want %q", live.Output, quiet.Output)
+	}
+
+	if !strings.Contains(logs.String(), "helmfile: warning from helmfile") {
+		t.Errorf("expected the output to be logged, got:\n%s", logs.String())
+	}
+}
@@ -2,11 +2,15 @@ package helmfile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/helmfile/helmfile/pkg/app"
+	"github.com/helmfile/helmfile/pkg/state"
 	"go.uber.org/zap"
 )
 
@@ -14,83 +18,107 @@ import (
 // This is the new implementation approach that embeds helmfile.
 type LibraryExecutor struct {
 	logger *zap.SugaredLogger
+
+	// bin is the helmfile binary that reports the version when the build info of the provider is unavailable
+	bin string
+
+	versionMu sync.Mutex
+	version   string
 }
 
 // NewLibraryExecutor creates a new LibraryExecutor
 func NewLibraryExecutor(logger *zap.SugaredLogger) *LibraryExecutor {
 	return &LibraryExecutor{
 		logger: logger,
+		bin:    "helmfile",
 	}
 }
 
 // Apply implements HelmfileExecutor.Apply using helmfile library
 func (e *LibraryExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
-	// Build debug info about AWS environment
+	// The AWS environment is dumped to the debug log only with the debug log level, as it would otherwise bury the output
+	debug := opts.LogLevel == LogLevelDebug
+
 	var debugOutput strings.Builder
-	debugOutput.WriteString("=== PROVIDER DEBUG INFO ===\n")
-	debugOutput.WriteString("AWS Environment BEFORE setting:\n")
-	awsVars := []string{"AWS_PROFILE", "AWS_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "HOME", "AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE"}
-	for _, key := range awsVars {
-		if val, exists := os.LookupEnv(key); exists {
-			// Mask sensitive values
-			if key == "AWS_SECRET_ACCESS_KEY" || key == "AWS_SESSION_TOKEN" {
-				if len(val) > 4 {
-					val = val[:4] + "***" + val[len(val)-4:]
-				} else {
-					val = "***"
-				}
-			}
-			debugOutput.WriteString(fmt.Sprintf("  %s=%s\n", key, val))
-		} else {
-			debugOutput.WriteString(fmt.Sprintf("  %s=(not set)\n", key))
+	if debug {
+		debugOutput.WriteString("=== PROVIDER DEBUG INFO ===\n")
+		debugOutput.WriteString("AWS Environment BEFORE setting:\n")
+		writeAWSEnvironment(&debugOutput)
+		debugOutput.WriteString("Environment variables from config:\n")
+		for _, key := range sortedKeys(opts.EnvironmentVariables) {
+			debugOutput.WriteString(fmt.Sprintf("  %s=%s\n", key, maskAWSEnvironmentVariable(key, fmt.Sprintf("%v", opts.EnvironmentVariables[key]))))
 		}
 	}
-	debugOutput.WriteString(fmt.Sprintf("Environment variables from config: %v\n", opts.EnvironmentVariables))
 
 	// Set environment variables before running helmfile
 	// This ensures helm/kubectl can access AWS credentials
 	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
 	defer restoreEnv()
 
-	// Log AWS environment AFTER setting
-	debugOutput.WriteString("\nAWS Environment AFTER setting:\n")
-	for _, key := range awsVars {
-		if val, exists := os.LookupEnv(key); exists {
-			// Mask sensitive values
-			if key == "AWS_SECRET_ACCESS_KEY" || key == "AWS_SESSION_TOKEN" {
-				if len(val) > 4 {
-					val = val[:4] + "***" + val[len(val)-4:]
-				} else {
-					val = "***"
-				}
-			}
-			debugOutput.WriteString(fmt.Sprintf("  %s=%s\n", key, val))
-		} else {
-			debugOutput.WriteString(fmt.Sprintf("  %s=(not set)\n", key))
-		}
+	if debug {
+		debugOutput.WriteString("\nAWS Environment AFTER setting:\n")
+		writeAWSEnvironment(&debugOutput)
+		debugOutput.WriteString("=== END PROVIDER DEBUG INFO ===")
+
+		logf("%s", debugOutput.String())
 	}
-	debugOutput.WriteString("=== END PROVIDER DEBUG INFO ===\n\n")
+
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
 
 	// Create output capture
-	capture := NewOutputCapture()
-	captureLogger := CreateCaptureLogger(capture)
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
 
 	// Create config provider with capture logger
 	config := &applyConfigProvider{
-		baseConfigProvider: newBaseConfigProvider(opts.BaseOptions, captureLogger),
-		concurrency:        opts.Concurrency,
-		suppressSecrets:    opts.SuppressSecrets,
-		skipDiffOnInstall:  opts.SkipDiffOnInstall,
+		baseConfigProvider:      newBaseConfigProvider(base, captureLogger),
+		concurrency:             opts.Concurrency,
+		set:                     append(setFlagValues(opts.ReleasesValues), opts.Set...),
+		helmValuesFiles:         opts.ReleasesValuesFiles,
+		suppressSecrets:         opts.SuppressSecrets,
+		skipDiffOnInstall:       opts.SkipDiffOnInstall,
+		skipSchemaValidation:    opts.SkipSchemaValidation,
+		skipCRDs:                opts.SkipCRDs,
+		noHooks:                 opts.NoHooks,
+		reuseValues:             opts.ReuseValues,
+		resetValues:             opts.ResetValues,
+		diffArgs:                opts.DiffArgs,
+		syncArgs:                opts.SyncArgs,
+		wait:                    opts.Wait,
+		waitForJobs:             opts.WaitForJobs,
+		context:                 opts.Context,
+		suppressOutputLineRegex: opts.SuppressOutputLineRegex,
 	}
 
 	// Initialize helmfile app
 	helmfileApp := app.New(config)
 
-	// Run apply operation
-	err := helmfileApp.Apply(config)
+	// Run apply operation, returning the output so far when the provider is stopped
+	err = runInterruptibly(ctx, func() error {
+		return helmfileApp.Apply(config)
+	})
+
+	// helmfile diffs all the releases before upgrading any of them, so nothing has been applied when the diff of
+	// a release that is not installed yet fails. Such a release has nothing to diff against and is entirely new,
+	// so the apply is retried with the diff skipped for the releases that are not installed
+	if err != nil && !config.skipDiffOnInstall && ctx.Err() == nil && isNotInstalledDiffError(err) {
+		logf("Retrying helmfile-apply with --skip-diff-on-install, as helm-diff failed for a release that is not installed yet: %v", err)
 
-	// Get captured output and prepend debug info
-	output := debugOutput.String() + capture.String()
+		fmt.Fprintf(capture, "helm-diff failed for a release that is not installed yet, which is installed as new: %v\n", err)
+
+		config.skipDiffOnInstall = true
+		err = runInterruptibly(ctx, func() error {
+			return app.New(config).Apply(config)
+		})
+	}
+
+	// Get captured output
+	output := capture.String()
 
 	if err != nil {
 		return &Result{
@@ -107,29 +135,46 @@ func (e *LibraryExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Resul
 	}, nil
 }
 
-// Diff implements HelmfileExecutor.Diff using helmfile library
-func (e *LibraryExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result, error) {
+// Sync implements HelmfileExecutor.Sync using helmfile library
+func (e *LibraryExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
 	// Set environment variables before running helmfile
 	// This ensures helm/kubectl can access AWS credentials
 	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
 	defer restoreEnv()
 
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
 	// Create output capture
-	capture := NewOutputCapture()
-	captureLogger := CreateCaptureLogger(capture)
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
 
 	// Create config provider with capture logger
-	config := &diffConfigProvider{
-		baseConfigProvider: newBaseConfigProvider(opts.BaseOptions, captureLogger),
-		concurrency:        opts.Concurrency,
-		detailedExitcode:   opts.DetailedExitcode,
-		suppressSecrets:    opts.SuppressSecrets,
-		context:            opts.Context,
+	config := &syncConfigProvider{
+		baseConfigProvider:   newBaseConfigProvider(base, captureLogger),
+		concurrency:          opts.Concurrency,
+		set:                  append(setFlagValues(opts.ReleasesValues), opts.Set...),
+		helmValuesFiles:      opts.ReleasesValuesFiles,
+		skipSchemaValidation: opts.SkipSchemaValidation,
+		skipCRDs:             opts.SkipCRDs,
+		noHooks:              opts.NoHooks,
+		reuseValues:          opts.ReuseValues,
+		resetValues:          opts.ResetValues,
+		syncArgs:             opts.SyncArgs,
+		wait:                 opts.Wait,
+		waitForJobs:          opts.WaitForJobs,
 	}
 
 	helmfileApp := app.New(config)
 
-	err := helmfileApp.Diff(config)
+	// Run sync operation, returning the output so far when the provider is stopped
+	err = runInterruptibly(ctx, func() error {
+		return helmfileApp.Sync(config)
+	})
 
 	// Get captured output
 	output := capture.String()
@@ -149,6 +194,105 @@ func (e *LibraryExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result,
 	}, nil
 }
 
+// Diff implements HelmfileExecutor.Diff using helmfile library
+func (e *LibraryExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result, error) {
+	// Set environment variables before running helmfile
+	// This ensures helm/kubectl can access AWS credentials
+	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
+	defer restoreEnv()
+
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
+	// Create output capture
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
+
+	// Create config provider with capture logger
+	config := &diffConfigProvider{
+		baseConfigProvider:      newBaseConfigProvider(base, captureLogger),
+		concurrency:             opts.Concurrency,
+		set:                     append(setFlagValues(opts.ReleasesValues), opts.Set...),
+		helmValuesFiles:         opts.ReleasesValuesFiles,
+		detailedExitcode:        opts.DetailedExitcode,
+		suppressSecrets:         opts.SuppressSecrets,
+		context:                 opts.Context,
+		skipSchemaValidation:    opts.SkipSchemaValidation,
+		skipCRDs:                opts.SkipCRDs,
+		noHooks:                 opts.NoHooks,
+		reuseValues:             opts.ReuseValues,
+		resetValues:             opts.ResetValues,
+		suppressOutputLineRegex: opts.SuppressOutputLineRegex,
+		diffArgs:                joinArgs(append([]string{opts.DiffArgs}, capabilitiesArgs(opts.KubeVersion, opts.APIVersions)...)...),
+	}
+
+	helmfileApp := app.New(config)
+
+	err = runInterruptibly(ctx, func() error {
+		return helmfileApp.Diff(config)
+	})
+
+	// The diff of a big release set can be megabytes, which would blow up the plan and the state
+	output := truncateOutput(capture.String(), opts.MaxDiffOutputLen, "helmfile-diff output", KeyMaxDiffOutputLen)
+
+	return diffResult(output, err)
+}
+
+// notInstalledDiffMessages are the errors of helm-diff for a release that has never been deployed
+var notInstalledDiffMessages = []string{
+	"release: not found",
+	"has no deployed releases",
+}
+
+// isNotInstalledDiffError returns true when helmfile failed because helm-diff found no release to diff against
+func isNotInstalledDiffError(err error) bool {
+	for _, m := range notInstalledDiffMessages {
+		if strings.Contains(err.Error(), m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exitCoder is implemented by the errors of the helmfile app that carry the exit code of the helmfile binary
+type exitCoder interface {
+	Code() int
+}
+
+// diffResult maps the error of helmfile diff to its result like the exit status of the helmfile binary.
+// With detailed exit codes, helmfile reports the changes it found as an error with exit code 2,
+// which is returned as ExitCode 2 without an error, so that only the failures of the diff are errors.
+func diffResult(output string, err error) (*Result, error) {
+	if err == nil {
+		return &Result{
+			Output:   output,
+			ExitCode: 0,
+			Error:    nil,
+		}, nil
+	}
+
+	var appErr exitCoder
+	var releaseErr *state.ReleaseError
+	if (errors.As(err, &appErr) && appErr.Code() == 2) || (errors.As(err, &releaseErr) && releaseErr.Code == 2) {
+		return &Result{
+			Output:   output,
+			ExitCode: 2,
+			Error:    nil,
+		}, nil
+	}
+
+	return &Result{
+		Output:   output,
+		ExitCode: 1,
+		Error:    err,
+	}, err
+}
+
 // Template implements HelmfileExecutor.Template using helmfile library
 func (e *LibraryExecutor) Template(ctx context.Context, opts *TemplateOptions) (*Result, error) {
 	// Set environment variables before running helmfile
@@ -156,22 +300,37 @@ func (e *LibraryExecutor) Template(ctx context.Context, opts *TemplateOptions) (
 	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
 	defer restoreEnv()
 
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
 	// Create output capture
-	capture := NewOutputCapture()
-	captureLogger := CreateCaptureLogger(capture)
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
 
 	// Create config provider with capture logger
 	config := &templateConfigProvider{
-		baseConfigProvider: newBaseConfigProvider(opts.BaseOptions, captureLogger),
-		concurrency:        opts.Concurrency,
-		includeCRDs:        opts.IncludeCRDs,
-		outputDir:          opts.OutputDir,
-		outputDirTemplate:  opts.OutputDirTemplate,
+		baseConfigProvider:   newBaseConfigProvider(base, captureLogger),
+		concurrency:          opts.Concurrency,
+		includeCRDs:          opts.IncludeCRDs,
+		outputDir:            opts.OutputDir,
+		outputDirTemplate:    opts.OutputDirTemplate,
+		outputFileTemplate:   opts.OutputFileTemplate,
+		skipSchemaValidation: opts.SkipSchemaValidation,
+		noHooks:              opts.NoHooks,
+		skipTests:            opts.SkipTests,
+		kubeVersion:          opts.KubeVersion,
+		args:                 strings.Join(capabilitiesArgs("", opts.APIVersions), " "),
 	}
 
 	helmfileApp := app.New(config)
 
-	err := helmfileApp.Template(config)
+	err = runInterruptibly(ctx, func() error {
+		return helmfileApp.Template(config)
+	})
 
 	// Get captured output
 	output := capture.String()
@@ -198,19 +357,79 @@ func (e *LibraryExecutor) Destroy(ctx context.Context, opts *DestroyOptions) (*R
 	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
 	defer restoreEnv()
 
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
 	// Create output capture
-	capture := NewOutputCapture()
-	captureLogger := CreateCaptureLogger(capture)
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
 
 	// Create config provider with capture logger
 	config := &destroyConfigProvider{
-		baseConfigProvider: newBaseConfigProvider(opts.BaseOptions, captureLogger),
+		baseConfigProvider: newBaseConfigProvider(base, captureLogger),
+		concurrency:        opts.Concurrency,
+		cascade:            opts.Cascade,
+		deleteWait:         opts.DeleteWait,
+		deleteTimeout:      opts.DeleteTimeout,
+	}
+
+	helmfileApp := app.New(config)
+
+	err = runInterruptibly(ctx, func() error {
+		return helmfileApp.Destroy(config)
+	})
+
+	// Get captured output
+	output := capture.String()
+
+	if err != nil {
+		return &Result{
+			Output:   output,
+			ExitCode: 1,
+			Error:    err,
+		}, err
+	}
+
+	return &Result{
+		Output:   output,
+		ExitCode: 0,
+		Error:    nil,
+	}, nil
+}
+
+// Lint implements HelmfileExecutor.Lint using helmfile library
+func (e *LibraryExecutor) Lint(ctx context.Context, opts *LintOptions) (*Result, error) {
+	// Set environment variables before running helmfile
+	// This ensures helm can access the chart repositories
+	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
+	defer restoreEnv()
+
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
+	// Create output capture
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
+
+	// Create config provider with capture logger
+	config := &lintConfigProvider{
+		baseConfigProvider: newBaseConfigProvider(base, captureLogger),
 		concurrency:        opts.Concurrency,
 	}
 
 	helmfileApp := app.New(config)
 
-	err := helmfileApp.Destroy(config)
+	err = runInterruptibly(ctx, func() error {
+		return helmfileApp.Lint(config)
+	})
 
 	// Get captured output
 	output := capture.String()
@@ -230,6 +449,92 @@ func (e *LibraryExecutor) Destroy(ctx context.Context, opts *DestroyOptions) (*R
 	}, nil
 }
 
+// PrintEnv implements HelmfileExecutor.PrintEnv using helmfile library
+func (e *LibraryExecutor) PrintEnv(ctx context.Context, opts *PrintEnvOptions) (*Result, error) {
+	// Set environment variables before running helmfile
+	// This ensures helm/kubectl can access AWS credentials
+	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
+	defer restoreEnv()
+
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
+	// Create output capture
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
+
+	// Create config provider with capture logger
+	config := &printEnvConfigProvider{
+		baseConfigProvider: newBaseConfigProvider(base, captureLogger),
+	}
+
+	helmfileApp := app.New(config)
+
+	// print-env prints the environment values to os.Stdout, which is shared by every operation, so they are read from the states instead
+	output, err := printEnvironments(helmfileApp)
+
+	if err != nil {
+		return &Result{
+			Output:   output + capture.String(),
+			ExitCode: 1,
+			Error:    err,
+		}, err
+	}
+
+	return &Result{
+		Output:   output,
+		ExitCode: 0,
+		Error:    nil,
+	}, nil
+}
+
+// List implements HelmfileExecutor.List using helmfile library
+func (e *LibraryExecutor) List(ctx context.Context, opts *ListOptions) (*Result, error) {
+	// Set environment variables before running helmfile
+	// This ensures helm/kubectl can access AWS credentials
+	restoreEnv := setEnvironmentVariables(opts.EnvironmentVariables)
+	defer restoreEnv()
+
+	// Write the inline values to state values files, as helmfile reads state values only from files
+	base, removeValuesFiles, err := withStateValuesFiles(opts.BaseOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer removeValuesFiles()
+
+	// Create output capture
+	capture := newOperationCapture(base)
+	captureLogger := CreateCaptureLogger(capture, base.LogLevel)
+
+	// Create config provider with capture logger
+	config := &listConfigProvider{
+		baseConfigProvider: newBaseConfigProvider(base, captureLogger),
+	}
+
+	helmfileApp := app.New(config)
+
+	// list prints the releases to os.Stdout, which is shared by every operation, so they are read from the states instead
+	output, err := listReleases(helmfileApp)
+
+	if err != nil {
+		return &Result{
+			Output:   output + capture.String(),
+			ExitCode: 1,
+			Error:    err,
+		}, err
+	}
+
+	return &Result{
+		Output:   output,
+		ExitCode: 0,
+		Error:    nil,
+	}, nil
+}
+
 // Build implements HelmfileExecutor.Build using helmfile library
 func (e *LibraryExecutor) Build(ctx context.Context, opts *BuildOptions) (*Result, error) {
 	// Build doesn't have a direct method in app, but we can use template for validation
@@ -237,37 +542,236 @@ func (e *LibraryExecutor) Build(ctx context.Context, opts *BuildOptions) (*Resul
 	return nil, fmt.Errorf("Build operation not yet implemented for library executor")
 }
 
-// Version implements HelmfileExecutor.Version using helmfile library
+// Version implements HelmfileExecutor.Version using helmfile library.
+// It reports the version of the helmfile module the provider is built with, falling back to the version of the helmfile
+// binary when the build info is unavailable. The version is detected once per executor.
 func (e *LibraryExecutor) Version(ctx context.Context) (string, error) {
-	// The library doesn't expose a version function easily
-	// We can either:
-	// 1. Return a hardcoded version based on the imported library version
-	// 2. Call the binary version command
-	// For now, return a placeholder
-	return "library-mode", nil
+	e.versionMu.Lock()
+	defer e.versionMu.Unlock()
+
+	if e.version != "" {
+		return e.version, nil
+	}
+
+	v, ok := embeddedHelmfileVersion()
+	if !ok {
+		out, err := exec.CommandContext(ctx, e.bin, "version").CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("running %s version: %w\n%s", e.bin, err, out)
+		}
+
+		parsed, err := parseVersion(string(out))
+		if err != nil {
+			return "", err
+		}
+
+		v = parsed.String()
+	}
+
+	e.version = v
+
+	return v, nil
+}
+
+// withStateValuesFiles returns the options with the inline Values written to temporary state values files in the working
+// directory, layered before ValuesFiles like prepareHelmfileFile does, and a function that removes the files.
+// prepareHelmfileFile writes the values of a release set to files already, leaving no Values to write.
+func withStateValuesFiles(opts BaseOptions) (BaseOptions, func(), error) {
+	if len(opts.Values) == 0 {
+		return opts, func() {}, nil
+	}
+
+	paths, err := writeTempValuesFiles(opts.WorkingDirectory, "", opts.Values)
+	if err != nil {
+		return opts, nil, fmt.Errorf("writing values to state values files: %w", err)
+	}
+
+	valuesFiles := make([]interface{}, 0, len(paths)+len(opts.ValuesFiles))
+	for _, p := range paths {
+		valuesFiles = append(valuesFiles, p)
+	}
+
+	opts.ValuesFiles = append(valuesFiles, opts.ValuesFiles...)
+	opts.Values = nil
+
+	return opts, func() { removeTempFiles(false, paths...) }, nil
+}
+
+// debugAWSEnvironmentVariables are the AWS environment variables that the debug info of apply shows
+var debugAWSEnvironmentVariables = []string{"AWS_PROFILE", "AWS_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "HOME", "AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE"}
+
+// writeAWSEnvironment writes the AWS environment variables of the provider to the debug info, with the secrets masked
+func writeAWSEnvironment(w *strings.Builder) {
+	for _, key := range debugAWSEnvironmentVariables {
+		if val, exists := os.LookupEnv(key); exists {
+			fmt.Fprintf(w, "  %s=%s\n", key, maskAWSEnvironmentVariable(key, val))
+		} else {
+			fmt.Fprintf(w, "  %s=(not set)\n", key)
+		}
+	}
+}
+
+// maskAWSEnvironmentVariable masks the value of the secret AWS environment variables, keeping the first and the last
+// four characters of the longer ones to tell the credentials apart
+func maskAWSEnvironmentVariable(key, val string) string {
+	if key != "AWS_SECRET_ACCESS_KEY" && key != "AWS_SESSION_TOKEN" {
+		return val
+	}
+
+	if len(val) > 4 {
+		return val[:4] + "***" + val[len(val)-4:]
+	}
+
+	return "***"
+}
+
+// newOperationCapture creates the output capture of an operation, which streams the output to the debug log with LiveOutput
+func newOperationCapture(opts BaseOptions) *OutputCapture {
+	capture := NewOutputCapture()
+	if opts.LiveOutput {
+		capture.StreamToLog(opts.LogPrefix)
+	}
+
+	return capture
+}
+
+// preservedAWSEnvVars are the AWS environment variables from the parent process that are preserved.
+// These are needed for kubectl exec authentication to EKS clusters.
+// HOME is required for AWS CLI to resolve ~/.aws/config and ~/.aws/credentials.
+// The web identity variables are set by IRSA on EKS, where there is neither a profile nor static keys.
+var preservedAWSEnvVars = []string{
+	"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION",
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	"AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	"HOME",
+}
+
+// awsContainerCredentialsEnvVarPrefix is the prefix of the environment variables for the ECS/EKS Pod Identity
+// container credentials provider, like AWS_CONTAINER_CREDENTIALS_FULL_URI
+const awsContainerCredentialsEnvVarPrefix = "AWS_CONTAINER_CREDENTIALS_"
+
+// preservedHelmEnvVars are the helm environment variables from the parent process that are preserved,
+// so that helm finds downloader plugins like helm-git for git+https:// chart URLs
+var preservedHelmEnvVars = []string{"HELM_PLUGINS", "HELM_DATA_HOME", "HELM_CONFIG_HOME", "HELM_CACHE_HOME"}
+
+// lookupPreservedEnvVars returns the preserved AWS and helm environment variables set in the parent process
+func lookupPreservedEnvVars() map[string]string {
+	vars := make(map[string]string)
+
+	for _, key := range append(preservedAWSEnvVars, preservedHelmEnvVars...) {
+		if val, exists := os.LookupEnv(key); exists {
+			vars[key] = val
+		}
+	}
+
+	for _, kv := range awsContainerCredentialsEnvVars() {
+		kvs := strings.SplitN(kv, "=", 2)
+		vars[kvs[0]] = kvs[1]
+	}
+
+	return vars
 }
 
-// setEnvironmentVariables sets environment variables and returns a function to restore them
-// This is critical for library mode because helmfile shells out to helm, which shells out to kubectl,
-// which needs AWS credentials to authenticate to EKS clusters.
+// awsContainerCredentialsEnvVars returns the container credentials provider variables set in the parent process as KEY=VALUE
+func awsContainerCredentialsEnvVars() []string {
+	var vars []string
+
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, awsContainerCredentialsEnvVarPrefix) && strings.Contains(kv, "=") {
+			vars = append(vars, kv)
+		}
+	}
+
+	return vars
+}
+
+// processEnvironment is the process environment shared by the operations of the library executor. They set the environment
+// variables of their release set in it, which the embedded helmfile passes to helm and reads in templates, so release
+// sets applied in parallel by Terraform would otherwise see each other's credentials. The operations setting the same
+// environment variables run concurrently, while the others wait for them to restore the environment.
+var processEnvironment = &environmentLease{}
+
+type environmentLease struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// key identifies the environment variables set by the holders
+	key     string
+	holders int
+	waiting int
+	restore func()
+}
+
+// acquire sets the environment variables unless the holders have set the same ones, waiting for the holders of other
+// ones to release the environment. The returned function releases it, restoring it after the last holder.
+func (l *environmentLease) acquire(envVars map[string]interface{}) func() {
+	key := environmentKey(envVars)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+
+	// The operations with other environment variables waiting keep the later ones from joining, so that they never starve
+	if l.holders > 0 && (l.key != key || l.waiting > 0) {
+		l.waiting++
+		for l.holders > 0 {
+			l.cond.Wait()
+		}
+		l.waiting--
+	}
+
+	if l.holders == 0 {
+		l.key = key
+		l.restore = overrideEnvironmentVariables(envVars)
+	}
+	l.holders++
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.holders--
+		if l.holders == 0 {
+			l.restore()
+			l.cond.Broadcast()
+		}
+	}
+}
+
+// environmentKey returns the environment variables as sorted KEY=value lines
+func environmentKey(envVars map[string]interface{}) string {
+	var b strings.Builder
+	for _, key := range sortedKeys(envVars) {
+		fmt.Fprintf(&b, "%s=%v\n", key, envVars[key])
+	}
+
+	return b.String()
+}
+
+// setEnvironmentVariables sets the environment variables once the operations of the library executor setting other
+// ones have restored the environment. The returned function restores them after the last operation setting them.
 func setEnvironmentVariables(envVars map[string]interface{}) func() {
+	return processEnvironment.acquire(envVars)
+}
+
+// overrideEnvironmentVariables sets environment variables and returns a function to restore them.
+// The caller holds the environment lease.
+func overrideEnvironmentVariables(envVars map[string]interface{}) func() {
 	// Store original values for restoration
 	originalValues := make(map[string]string)
 	keysToUnset := make([]string, 0)
 
-	// CRITICAL: Ensure AWS environment variables from parent process are preserved
-	// These are needed for kubectl exec authentication to EKS clusters
-	// HOME is required for AWS CLI to resolve ~/.aws/config and ~/.aws/credentials
-	awsEnvVars := []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE", "HOME"}
-
 	// Build a complete environment variable map that includes AWS vars from parent
 	completeEnvVars := make(map[string]interface{})
 
-	// First, copy AWS environment variables from parent process if they exist
-	for _, key := range awsEnvVars {
-		if val, exists := os.LookupEnv(key); exists {
-			completeEnvVars[key] = val
-		}
+	// First, copy AWS and helm environment variables from parent process if they exist
+	for key, val := range lookupPreservedEnvVars() {
+		completeEnvVars[key] = val
 	}
 
 	// Then, overlay with explicitly configured environment variables (these take precedence)
@@ -0,0 +1,956 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/helmfile/helmfile/pkg/app"
+	"github.com/helmfile/helmfile/pkg/state"
+	"go.uber.org/zap"
+)
+
+func TestLibraryExecutorVersion(t *testing.T) {
+	t.Cleanup(func() { readBuildInfo = debug.ReadBuildInfo })
+
+	t.Run("provider", func(t *testing.T) {
+		readBuildInfo = debug.ReadBuildInfo
+
+		v, err := NewLibraryExecutor(nil).Version(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !semverPattern.MatchString(v) || v == "library-mode" {
+			t.Errorf("expected the version of the embedded helmfile, got %s", v)
+		}
+	})
+
+	t.Run("build info", func(t *testing.T) {
+		readBuildInfo = func() (*debug.BuildInfo, bool) {
+			return &debug.BuildInfo{Deps: []*debug.Module{
+				{Path: "github.com/helmfile/vals", Version: "v0.42.0"},
+				{Path: helmfileModulePath, Version: "v1.4.1"},
+			}}, true
+		}
+
+		v, err := NewLibraryExecutor(nil).Version(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v != "1.4.1" {
+			t.Errorf("expected 1.4.1, got %s", v)
+		}
+	})
+
+	t.Run("replaced module", func(t *testing.T) {
+		readBuildInfo = func() (*debug.BuildInfo, bool) {
+			return &debug.BuildInfo{Deps: []*debug.Module{
+				{Path: helmfileModulePath, Version: "v1.4.1", Replace: &debug.Module{Path: "github.com/example/helmfile", Version: "v1.4.2-fix.1"}},
+			}}, true
+		}
+
+		if v, _ := NewLibraryExecutor(nil).Version(context.Background()); v != "1.4.2-fix.1" {
+			t.Errorf("expected the version of the replacement, got %s", v)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+
+		bin := filepath.Join(t.TempDir(), "helmfile")
+		if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\necho helmfile version v0.150.0\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		e := NewLibraryExecutor(nil)
+		e.bin = bin
+
+		if v, err := e.Version(context.Background()); err != nil || v != "0.150.0" {
+			t.Errorf("expected the version of the binary, got %q, %v", v, err)
+		}
+
+		// The version is detected only once
+		if err := os.Remove(bin); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := e.Version(context.Background()); err != nil || v != "0.150.0" {
+			t.Errorf("expected the cached version, got %q, %v", v, err)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+
+		e := NewLibraryExecutor(nil)
+		e.bin = filepath.Join(t.TempDir(), "helmfile")
+
+		if _, err := e.Version(context.Background()); err == nil {
+			t.Error("expected an error without the build info and the binary")
+		}
+	})
+}
+
+func TestWithStateValuesFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	opts, removeValuesFiles, err := withStateValuesFiles(BaseOptions{
+		WorkingDirectory: dir,
+		ValuesFiles:      []interface{}{"values-file.yaml"},
+		Values:           []interface{}{"key: a\n", "region: us-west-2\n"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.Values != nil {
+		t.Errorf("expected the values to be passed as files only, got %v", opts.Values)
+	}
+
+	// The values are layered before values_files, like prepareHelmfileFile does
+	if len(opts.ValuesFiles) != 3 || opts.ValuesFiles[2] != "values-file.yaml" {
+		t.Fatalf("expected two state values files before values-file.yaml, got %v", opts.ValuesFiles)
+	}
+
+	var contents []string
+	for _, f := range opts.ValuesFiles[:2] {
+		bs, err := ioutil.ReadFile(f.(string))
+		if err != nil {
+			t.Fatalf("expected the state values file to exist: %v", err)
+		}
+		contents = append(contents, string(bs))
+	}
+	if want := []string{"key: a\n", "region: us-west-2\n"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("unexpected state values files: want %q, got %q", want, contents)
+	}
+
+	removeValuesFiles()
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, "temp.values-*.yaml")); len(matches) > 0 {
+		t.Errorf("expected the state values files to be removed, got %v", matches)
+	}
+}
+
+// TestLibraryExecutorInlineValues asserts that the inline values reach helmfile when the executor is used directly
+func TestLibraryExecutorInlineValues(t *testing.T) {
+	// The embedded helmfile only needs helm to report its version to print the environment
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "helm"), []byte("#!/bin/sh\necho v3.14.0+g3fc9f4b\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("environments:\n  default: {}\n---\nreleases: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewLibraryExecutor(zap.NewNop().Sugar()).PrintEnv(context.Background(), &PrintEnvOptions{
+		BaseOptions: BaseOptions{
+			FileOrDir:        helmfile,
+			WorkingDirectory: dir,
+			Environment:      "default",
+			Values:           []interface{}{"key: a\n", "region: us-west-2\n"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("print-env failed: %v", err)
+	}
+
+	for _, want := range []string{"key: a", "region: us-west-2"} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("expected %q in the environment, got:\n%s", want, result.Output)
+		}
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, "temp.values-*.yaml")); len(matches) > 0 {
+		t.Errorf("expected the state values files to be removed, got %v", matches)
+	}
+}
+
+// TestLibraryExecutorList asserts that the selected releases are listed as JSON, like helmfile list --output json
+func TestLibraryExecutorList(t *testing.T) {
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "helm"), []byte("#!/bin/sh\necho v3.14.0+g3fc9f4b\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	content := `environments:
+  default: {}
+---
+commonLabels:
+  team: core
+releases:
+- name: web
+  namespace: apps
+  chart: ./charts/web
+  labels:
+    tier: frontend
+- name: db
+  namespace: data
+  chart: ./charts/db
+  installed: false
+  labels:
+    tier: backend
+`
+	if err := ioutil.WriteFile(helmfile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewLibraryExecutor(zap.NewNop().Sugar()).List(context.Background(), &ListOptions{
+		BaseOptions: BaseOptions{
+			FileOrDir:        helmfile,
+			WorkingDirectory: dir,
+			Environment:      "default",
+			Selectors:        []interface{}{"tier=frontend"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+
+	want := `[{"name":"web","namespace":"apps","enabled":true,"installed":true,"labels":"chart:web,name:web,namespace:apps,team:core,tier:frontend","chart":"./charts/web","version":""}]`
+	if got := strings.TrimSpace(result.Output); got != want {
+		t.Errorf("unexpected releases.\nExpected:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestDiffResult(t *testing.T) {
+	changes := state.NewReleaseError(&state.ReleaseSpec{Name: "app"}, errors.New("identified at least one change"), 2)
+	failure := state.NewReleaseError(&state.ReleaseSpec{Name: "db"}, errors.New("helm exited with status 1"), 1)
+
+	tests := []struct {
+		name     string
+		err      error
+		exitCode int
+		wantErr  bool
+	}{
+		{name: "no changes", err: nil, exitCode: 0},
+		{name: "changes", err: &app.Error{Errors: []error{changes}}, exitCode: 2},
+		{name: "release with changes", err: changes, exitCode: 2},
+		{name: "failure", err: &app.Error{Errors: []error{failure}}, exitCode: 1, wantErr: true},
+		{name: "changes and failure", err: &app.Error{Errors: []error{changes, failure}}, exitCode: 1, wantErr: true},
+		{name: "other error", err: errors.New("loading helmfile.yaml: no such file"), exitCode: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := diffResult("Comparing release=app\n", tt.err)
+
+			if (err != nil) != tt.wantErr || (result.Error != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v, result error: %v", err, result.Error)
+			}
+			if result.ExitCode != tt.exitCode {
+				t.Errorf("expected exit code %d, got %d", tt.exitCode, result.ExitCode)
+			}
+			if result.Output != "Comparing release=app\n" {
+				t.Errorf("expected the output of the diff, got %q", result.Output)
+			}
+		})
+	}
+}
+
+func TestLibraryExecutorDiffMaxDiffOutputLen(t *testing.T) {
+	// The embedded helmfile only needs helm to report its version to load the helmfile
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "helm"), []byte("#!/bin/sh\necho v3.14.0+g3fc9f4b\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := func(maxLen int) string {
+		result, _ := NewLibraryExecutor(zap.NewNop().Sugar()).Diff(context.Background(), &DiffOptions{
+			BaseOptions:      BaseOptions{FileOrDir: helmfile, WorkingDirectory: dir, LogLevel: LogLevelDebug},
+			MaxDiffOutputLen: maxLen,
+		})
+		return result.Output
+	}
+
+	full := diff(1024 * 1024)
+	if strings.Contains(full, "too long") {
+		t.Fatalf("expected the whole output within the limit, got %q", full)
+	}
+
+	maxLen := len(full) - 1
+	got := diff(maxLen)
+	if len(got) > maxLen+1 || !strings.Contains(got, "helmfile-diff output was too long, and therefore snipped.") {
+		t.Errorf("expected the output to be snipped to at most %d bytes with a notice, got %d bytes: %q", maxLen+1, len(got), got)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("expected valid UTF-8, got %q", got)
+	}
+}
+
+// TestLibraryExecutorConcurrentEnvironment asserts that release sets applied in parallel don't see each other's
+// environment variables
+func TestLibraryExecutorConcurrentEnvironment(t *testing.T) {
+	dir := t.TempDir()
+
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases:\n- name: app\n  chart: ./chart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	executor := NewLibraryExecutor(zap.NewNop().Sugar())
+
+	apply := func(profile string) (*Result, error) {
+		// helm records the AWS_PROFILE it sees while it runs, which takes long enough for the other apply to set its own
+		helm := filepath.Join(dir, "helm-"+profile)
+		script := "#!/bin/sh\nsleep 0.2\necho \"$AWS_PROFILE\" >> " + filepath.Join(dir, "seen-"+profile) + "\necho v3.14.0+g3fc9f4b\n"
+		if err := ioutil.WriteFile(helm, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		return executor.Apply(context.Background(), &ApplyOptions{
+			BaseOptions: BaseOptions{
+				FileOrDir:            helmfile,
+				WorkingDirectory:     dir,
+				Environment:          "default",
+				HelmBinary:           helm,
+				EnvironmentVariables: map[string]interface{}{"AWS_PROFILE": profile},
+				LogLevel:             LogLevelDebug,
+			},
+		})
+	}
+
+	profiles := []string{"team-a", "team-b"}
+	results := make([]*Result, len(profiles))
+	errs := make([]error, len(profiles))
+
+	var wg sync.WaitGroup
+	for i, profile := range profiles {
+		wg.Add(1)
+		go func(i int, profile string) {
+			defer wg.Done()
+			results[i], errs[i] = apply(profile)
+		}(i, profile)
+	}
+	wg.Wait()
+
+	for i, profile := range profiles {
+		if errs[i] != nil {
+			t.Fatalf("apply of %s failed: %v\n%s", profile, errs[i], results[i].Output)
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "seen-"+profile))
+		if err != nil {
+			t.Fatalf("expected helm to run for %s: %v", profile, err)
+		}
+		for _, seen := range strings.Fields(string(bs)) {
+			if seen != profile {
+				t.Errorf("expected helm of %s to see AWS_PROFILE=%s, got %s", profile, profile, seen)
+			}
+		}
+
+		if strings.Contains(results[i].Output, "PROVIDER DEBUG INFO") {
+			t.Errorf("expected the debug info of %s to be logged rather than in the output, got:\n%s", profile, results[i].Output)
+		}
+	}
+
+	// Each apply logs the debug info once, showing its own AWS_PROFILE after setting it
+	infos := strings.Split(logs.String(), "=== PROVIDER DEBUG INFO ===")[1:]
+	if len(infos) != len(profiles) {
+		t.Fatalf("expected the debug info of each apply to be logged, got:\n%s", logs.String())
+	}
+	for _, info := range infos {
+		info = info[:strings.Index(info, "=== END PROVIDER DEBUG INFO ===")]
+
+		config := info[strings.Index(info, "from config"):strings.Index(info, "AFTER setting")]
+		after := info[strings.Index(info, "AFTER setting"):]
+
+		for _, profile := range profiles {
+			if strings.Contains(config, "AWS_PROFILE="+profile+"\n") && !strings.Contains(after, "AWS_PROFILE="+profile+"\n") {
+				t.Errorf("expected the debug info of %s to show its own AWS_PROFILE, got:\n%s", profile, info)
+			}
+		}
+	}
+}
+
+// TestEnvironmentLease asserts that the operations setting the same environment variables run concurrently,
+// while the ones setting other values wait for them to restore the environment
+func TestEnvironmentLease(t *testing.T) {
+	t.Setenv("AWS_PROFILE", "default")
+
+	l := &environmentLease{}
+
+	acquired := func(envVars map[string]interface{}) <-chan func() {
+		ch := make(chan func(), 1)
+		go func() { ch <- l.acquire(envVars) }()
+		return ch
+	}
+
+	releaseA := l.acquire(map[string]interface{}{"AWS_PROFILE": "team-a"})
+
+	var releaseA2 func()
+	select {
+	case releaseA2 = <-acquired(map[string]interface{}{"AWS_PROFILE": "team-a"}):
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the operation setting the same environment variables not to wait")
+	}
+
+	teamB := acquired(map[string]interface{}{"AWS_PROFILE": "team-b"})
+
+	releaseA()
+	select {
+	case <-teamB:
+		t.Fatal("expected the operation setting other environment variables to wait for all the holders")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if got := os.Getenv("AWS_PROFILE"); got != "team-a" {
+		t.Errorf("expected AWS_PROFILE=team-a while it is held, got %s", got)
+	}
+
+	releaseA2()
+
+	releaseB := <-teamB
+	if got := os.Getenv("AWS_PROFILE"); got != "team-b" {
+		t.Errorf("expected AWS_PROFILE=team-b, got %s", got)
+	}
+	releaseB()
+
+	if got := os.Getenv("AWS_PROFILE"); got != "default" {
+		t.Errorf("expected AWS_PROFILE to be restored, got %s", got)
+	}
+}
+
+// TestLibraryExecutorDiffOnInstall asserts that the apply of a release that is not installed yet succeeds
+// with skip_diff_on_install = false even when helm-diff fails for it
+func TestLibraryExecutorDiffOnInstall(t *testing.T) {
+	dir := t.TempDir()
+
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases:\n- name: app\n  chart: ./chart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// helm records its arguments, and helm-diff fails like it does for a release that has never been deployed
+	helm := filepath.Join(dir, "helm")
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\n" +
+		"case \"$*\" in *\"diff upgrade\"*) echo 'Error: release: not found' >&2; exit 1;; esac\n" +
+		"echo v3.14.0+g3fc9f4b\n"
+	if err := ioutil.WriteFile(helm, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewLibraryExecutor(zap.NewNop().Sugar()).Apply(context.Background(), &ApplyOptions{
+		BaseOptions: BaseOptions{
+			FileOrDir:        helmfile,
+			WorkingDirectory: dir,
+			Environment:      "default",
+			HelmBinary:       helm,
+		},
+		SkipDiffOnInstall: false,
+	})
+	if err != nil {
+		t.Fatalf("expected the apply to succeed, got %v\n%s", err, result.Output)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := string(bs)
+	if !strings.Contains(args, "diff upgrade") {
+		t.Errorf("expected the release to be diffed, got helm runs:\n%s", args)
+	}
+	if !strings.Contains(args, "upgrade --install app") {
+		t.Errorf("expected the release to be installed, got helm runs:\n%s", args)
+	}
+	if !strings.Contains(result.Output, "installed as new") {
+		t.Errorf("expected the output to explain that the release is installed as new, got:\n%s", result.Output)
+	}
+	if strings.Contains(result.Output, "PROVIDER DEBUG INFO") {
+		t.Errorf("expected no debug info in the output at the default log level, got:\n%s", result.Output)
+	}
+}
+
+// TestLibraryExecutorDiffFilters asserts that diff_context and diff_suppress_line_regex reach helm-diff
+func TestLibraryExecutorDiffFilters(t *testing.T) {
+	dir := t.TempDir()
+
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases:\n- name: app\n  chart: ./chart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// helmfile reads the version of helm-diff from the plugins directory, as the regexes need helm-diff 3.9.0 or later
+	plugin := filepath.Join(dir, "plugins", "diff")
+	if err := os.MkdirAll(plugin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(plugin, "plugin.yaml"), []byte("name: diff\nversion: 3.9.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HELM_PLUGINS", filepath.Join(dir, "plugins"))
+
+	helm := filepath.Join(dir, "helm")
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\necho v3.14.0+g3fc9f4b\n"
+	if err := ioutil.WriteFile(helm, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewLibraryExecutor(zap.NewNop().Sugar()).Diff(context.Background(), &DiffOptions{
+		BaseOptions:             BaseOptions{FileOrDir: helmfile, WorkingDirectory: dir, Environment: "default", HelmBinary: helm},
+		Context:                 1,
+		SuppressOutputLineRegex: []string{`checksum/config`},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, result.Output)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"--context 1", "--suppress-output-line-regex checksum/config"} {
+		if !strings.Contains(string(bs), want) {
+			t.Errorf("expected helm-diff to run with %s, got helm runs:\n%s", want, bs)
+		}
+	}
+}
+
+// TestLibraryExecutorTemplateCapabilities asserts that kube_version and api_versions reach helm template, with a helm
+// that renders a PodDisruptionBudget of the API version the chart would pick for the Kubernetes version
+func TestLibraryExecutorTemplateCapabilities(t *testing.T) {
+	dir := t.TempDir()
+
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases:\n- name: app\n  chart: ./chart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helm := filepath.Join(dir, "helm")
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\n" +
+		"case \"$*\" in\n" +
+		"  *\"template \"*\"--kube-version 1.21.0\"*) echo 'apiVersion: policy/v1beta1'; echo 'kind: PodDisruptionBudget';;\n" +
+		"  *\"template \"*) echo 'apiVersion: policy/v1'; echo 'kind: PodDisruptionBudget';;\n" +
+		"  *) echo v3.14.0+g3fc9f4b;;\n" +
+		"esac\n"
+	if err := ioutil.WriteFile(helm, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for kubeVersion, want := range map[string]string{"1.21.0": "apiVersion: policy/v1beta1", "1.29.0": "apiVersion: policy/v1\n"} {
+		result, err := NewLibraryExecutor(zap.NewNop().Sugar()).Template(context.Background(), &TemplateOptions{
+			BaseOptions: BaseOptions{FileOrDir: helmfile, WorkingDirectory: dir, Environment: "default", HelmBinary: helm},
+			KubeVersion: kubeVersion,
+			APIVersions: []string{"monitoring.coreos.com/v1"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n%s", err, result.Output)
+		}
+
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("expected %q to be rendered for %s, got:\n%s", want, kubeVersion, result.Output)
+		}
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(bs), "--api-versions=monitoring.coreos.com/v1") {
+		t.Errorf("expected helm template to run with the API versions, got helm runs:\n%s", bs)
+	}
+}
+
+// TestLibraryExecutorTemplateCRDs asserts that the CRDs are in the rendered manifests only with include_crds,
+// with a helm that renders the CRD of the chart only with --include-crds like helm template does
+func TestLibraryExecutorTemplateCRDs(t *testing.T) {
+	dir := t.TempDir()
+
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases:\n- name: app\n  chart: ./chart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helm := filepath.Join(dir, "helm")
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		"  *\"template \"*\"--include-crds\"*) echo 'kind: CustomResourceDefinition'; echo '---'; echo 'kind: Deployment';;\n" +
+		"  *\"template \"*) echo 'kind: Deployment';;\n" +
+		"  *) echo v3.14.0+g3fc9f4b;;\n" +
+		"esac\n"
+	if err := ioutil.WriteFile(helm, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, includeCRDs := range []bool{true, false} {
+		result, err := NewLibraryExecutor(zap.NewNop().Sugar()).Template(context.Background(), &TemplateOptions{
+			BaseOptions: BaseOptions{FileOrDir: helmfile, WorkingDirectory: dir, Environment: "default", HelmBinary: helm},
+			IncludeCRDs: includeCRDs,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n%s", err, result.Output)
+		}
+
+		if !strings.Contains(result.Output, "kind: Deployment") {
+			t.Errorf("expected the Deployment to be rendered, got:\n%s", result.Output)
+		}
+
+		if got := strings.Contains(result.Output, "kind: CustomResourceDefinition"); got != includeCRDs {
+			t.Errorf("expected the CRD to be rendered %v with include_crds %v, got:\n%s", includeCRDs, includeCRDs, result.Output)
+		}
+	}
+}
+
+func TestLibraryExecutorLogLevel(t *testing.T) {
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "AKIAsecretvalue1234")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	capture := NewOutputCapture()
+	logger := CreateCaptureLogger(capture, LogLevelInfo)
+	logger.Debug("resolving dependencies")
+	logger.Info("upgrading")
+
+	if out := capture.String(); strings.Contains(out, "resolving dependencies") || !strings.Contains(out, "upgrading") {
+		t.Errorf("expected only the info logs to be captured at the default log level, got:\n%s", out)
+	}
+
+	capture = NewOutputCapture()
+	logger = CreateCaptureLogger(capture, LogLevelWarn)
+	logger.Info("upgrading")
+	logger.Warn("deprecated")
+
+	if out := capture.String(); strings.Contains(out, "upgrading") || !strings.Contains(out, "deprecated") {
+		t.Errorf("expected only the warnings to be captured with the warn log level, got:\n%s", out)
+	}
+
+	// The output of the commands run by helmfile is logged at the debug level, but is the output of the operation
+	capture = NewOutputCapture()
+	logger = CreateCaptureLogger(capture, LogLevelWarn)
+	logger.Debug("exec: helm upgrade --install app ./chart")
+	logger.Debug("helm:aBcDe> Release \"app\" has been upgraded. Happy Helming!")
+
+	if out := capture.String(); strings.Contains(out, "exec: helm") || !strings.Contains(out, "Happy Helming!") {
+		t.Errorf("expected the output of helm to be captured at any log level, got:\n%s", out)
+	}
+
+	// The AWS environment is dumped to the debug log with the debug log level, with the secrets masked
+	dir := t.TempDir()
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	helmfile := filepath.Join(dir, "helmfile.yaml")
+	if err := ioutil.WriteFile(helmfile, []byte("releases:\n- name: app\n  chart: ./chart\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	helm := filepath.Join(dir, "helm")
+	if err := ioutil.WriteFile(helm, []byte("#!/bin/sh\necho v3.14.0+g3fc9f4b\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewLibraryExecutor(zap.NewNop().Sugar()).Apply(context.Background(), &ApplyOptions{
+		BaseOptions: BaseOptions{
+			FileOrDir:            helmfile,
+			WorkingDirectory:     dir,
+			Environment:          "default",
+			HelmBinary:           helm,
+			EnvironmentVariables: map[string]interface{}{"AWS_SESSION_TOKEN": "FwoGZXIvYXdzEBYaDtoken"},
+			LogLevel:             LogLevelDebug,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, result.Output)
+	}
+
+	if strings.Contains(result.Output, "PROVIDER DEBUG INFO") {
+		t.Errorf("expected the debug info to be logged rather than in the output, got:\n%s", result.Output)
+	}
+
+	for _, want := range []string{"=== PROVIDER DEBUG INFO ===", "AWS_SECRET_ACCESS_KEY=AKIA***1234", "AWS_SESSION_TOKEN=FwoG***oken"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected the debug log to contain %q, got:\n%s", want, logs.String())
+		}
+	}
+	for _, secret := range []string{"AKIAsecretvalue1234", "FwoGZXIvYXdzEBYaDtoken"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("expected %s to be masked, got:\n%s", secret, logs.String())
+		}
+	}
+}
+
+// twoContextsKubeconfig has the current context ctx-a, and ctx-b of another cluster
+const twoContextsKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: cluster-a
+  cluster:
+    server: https://cluster-a.example.com
+- name: cluster-b
+  cluster:
+    server: https://cluster-b.example.com
+contexts:
+- name: ctx-a
+  context:
+    cluster: cluster-a
+    user: user
+- name: ctx-b
+  context:
+    cluster: cluster-b
+    user: user
+current-context: ctx-a
+users:
+- name: user
+  user:
+    token: test
+`
+
+// TestKubeContext asserts that with a kubeconfig of two contexts, helm runs against the selected one rather than
+// the current one with both executors
+func TestKubeContext(t *testing.T) {
+	dir := t.TempDir()
+
+	chart := filepath.Join(dir, "chart")
+	if err := os.MkdirAll(chart, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// helm records the args of each run, and helmfile the args it runs with
+	for name, content := range map[string]string{
+		"chart/Chart.yaml": "apiVersion: v2\nname: app\nversion: 1.0.0\n",
+		"kubeconfig":       twoContextsKubeconfig,
+		"helm":             "#!/bin/sh\necho \"$*\" >> \"$(dirname \"$0\")/helm-args\"\necho v3.14.0+g3fc9f4b\n",
+		"helmfile":         "#!/bin/sh\necho \"$*\" >> \"$(dirname \"$0\")/helmfile-args\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		executor HelmfileExecutor
+		content  string
+		context  string
+		args     string
+	}{
+		{
+			name:     "library with kube context",
+			executor: NewLibraryExecutor(zap.NewNop().Sugar()),
+			content:  "releases:\n- name: app\n  chart: ./chart\n",
+			context:  "ctx-b",
+			args:     "helm-args",
+		},
+		{
+			// helmfile_release sets the kube context of the helmfile
+			name:     "library with helmDefaults",
+			executor: NewLibraryExecutor(zap.NewNop().Sugar()),
+			content:  "helmDefaults:\n  kubeContext: ctx-b\nreleases:\n- name: app\n  chart: ./chart\n",
+			args:     "helm-args",
+		},
+		{
+			name:     "binary with kube context",
+			executor: NewBinaryExecutor(filepath.Join(dir, "helmfile")),
+			content:  "releases:\n- name: app\n  chart: ./chart\n",
+			context:  "ctx-b",
+			args:     "helmfile-args",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helmfile := filepath.Join(dir, "helmfile.yaml")
+			if err := ioutil.WriteFile(helmfile, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			os.Remove(filepath.Join(dir, tt.args))
+
+			if _, err := tt.executor.Diff(context.Background(), &DiffOptions{
+				BaseOptions: BaseOptions{
+					FileOrDir:        helmfile,
+					WorkingDirectory: dir,
+					Environment:      "default",
+					HelmBinary:       filepath.Join(dir, "helm"),
+					Kubeconfig:       filepath.Join(dir, "kubeconfig"),
+					KubeContext:      tt.context,
+				},
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			bs, err := ioutil.ReadFile(filepath.Join(dir, tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(bs); !strings.Contains(got, "--kube-context ctx-b") || strings.Contains(got, "ctx-a") {
+				t.Errorf("expected helm to run against ctx-b, got:\n%s", got)
+			}
+		})
+	}
+}
+
+// fakeTemplateHelm writes a manifest of the release to the --output-dir of helm template, like helm does
+const fakeTemplateHelm = `#!/bin/sh
+release=""
+out=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    version) echo v3.14.0+g3fc9f4b; exit 0;;
+    template) release="$2"; shift;;
+    --output-dir) out="$2"; shift;;
+  esac
+  shift
+done
+[ -n "$out" ] || exit 0
+mkdir -p "$out/$release/templates"
+printf 'kind: ConfigMap\nmetadata:\n  name: %s\n' "$release" > "$out/$release/templates/configmap.yaml"
+`
+
+// TestTemplateOutputDirTemplate asserts that template_output_dir_template lays out the manifests of two releases
+// of charts in a nested directory tree by namespace and release
+func TestTemplateOutputDirTemplate(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"charts/backend/api/Chart.yaml":  "apiVersion: v2\nname: api\nversion: 1.0.0\n",
+		"charts/frontend/web/Chart.yaml": "apiVersion: v2\nname: web\nversion: 1.0.0\n",
+		"helm":                           fakeTemplateHelm,
+		"helmfile.yaml": `releases:
+- name: api
+  namespace: backend
+  chart: ./charts/backend/api
+- name: web
+  namespace: frontend
+  chart: ./charts/frontend/web
+`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "manifests")
+
+	fs := &ReleaseSet{
+		WorkingDirectory:           dir,
+		Environment:                "default",
+		HelmBin:                    filepath.Join(dir, "helm"),
+		TemplateOutputDir:          out,
+		TemplateOutputDirTemplate:  "{{ .OutputDir }}/{{ .Release.Namespace }}/{{ .Release.Name }}",
+		TemplateOutputFileTemplate: "{{ .Release.Name }}.yaml",
+	}
+
+	opts := buildTemplateOptions(fs, &helmfileFiles{Path: filepath.Join(dir, "helmfile.yaml")})
+
+	if _, err := NewLibraryExecutor(zap.NewNop().Sugar()).Template(context.Background(), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	if err := filepath.Walk(out, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(out, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"backend/api/api/templates/configmap.yaml",
+		"frontend/web/web/templates/configmap.yaml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected directory tree: want %v, got %v", want, got)
+	}
+
+	// The binary executor passes them to helmfile template
+	binDir, executor := newFakeBinaryHelmfile(t)
+	opts.WorkingDirectory = binDir
+
+	if _, err := executor.Template(context.Background(), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantArgs := " template --include-crds --output-dir " + out +
+		" --output-dir-template '{{ .OutputDir }}/{{ .Release.Namespace }}/{{ .Release.Name }}'" +
+		" --output-file-template '{{ .Release.Name }}.yaml'"
+	if got := readFakeBinaryArgs(t, binDir); !strings.HasSuffix(got, wantArgs) {
+		t.Errorf("expected helmfile template to run with%s, got %s", wantArgs, got)
+	}
+}
@@ -0,0 +1,242 @@
+package helmfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/semver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultExternalReleaseTimeout is the default number of seconds to wait for an external release
+const DefaultExternalReleaseTimeout = 300
+
+// externalReleasePollInterval is the interval between the checks of the external releases. It is a variable to be shortened in tests.
+var externalReleasePollInterval = 5 * time.Second
+
+// ExternalRelease is a helm release installed by another tool that the release set waits for before apply
+type ExternalRelease struct {
+	Name      string
+	Namespace string
+
+	// MinVersion is the minimum chart version of the release. Empty means any version.
+	MinVersion string
+
+	// Timeout is the number of seconds to wait for the release
+	Timeout int
+
+	// CheckOnPlan when true checks the release on plan too, only logging a warning when it is not ready
+	CheckOnPlan bool
+}
+
+func (r ExternalRelease) id() string {
+	return fmt.Sprintf("%s/%s", r.Namespace, r.Name)
+}
+
+func newExternalReleases(v interface{}) []ExternalRelease {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var releases []ExternalRelease
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		r := ExternalRelease{
+			Name:      m[KeyExternalReleaseName].(string),
+			Namespace: m[KeyExternalReleaseNamespace].(string),
+		}
+		r.MinVersion, _ = m[KeyExternalReleaseMinVersion].(string)
+		r.Timeout, _ = m[KeyExternalReleaseTimeout].(int)
+		r.CheckOnPlan, _ = m[KeyExternalReleaseCheckOnPlan].(bool)
+
+		releases = append(releases, r)
+	}
+
+	return releases
+}
+
+// waitForExternalReleases waits until each external release is deployed at min_version or above,
+// and fails when any of them is not within its timeout
+func waitForExternalReleases(ctx context.Context, fs *ReleaseSet) error {
+	if len(fs.WaitForExternalReleases) == 0 {
+		return nil
+	}
+
+	client, err := kubernetesClient(releaseSetForApply(fs))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range fs.WaitForExternalReleases {
+		if err := waitForExternalRelease(ctx, client, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func waitForExternalRelease(ctx context.Context, client kubernetes.Interface, r ExternalRelease) error {
+	timeout := time.Duration(r.Timeout) * time.Second
+	deadline := time.Now().Add(timeout)
+
+	for {
+		reason, err := checkExternalRelease(ctx, client, r)
+		if err != nil {
+			return err
+		}
+
+		if reason == "" {
+			return nil
+		}
+
+		if !time.Now().Add(externalReleasePollInterval).Before(deadline) {
+			return fmt.Errorf("waiting for external release %s: %s after %s", r.id(), reason, timeout)
+		}
+
+		logf("Waiting for external release %s: %s", r.id(), reason)
+
+		time.Sleep(externalReleasePollInterval)
+	}
+}
+
+// checkExternalReleasesOnPlan checks the external releases with check_on_plan once, and only logs the ones that are not ready
+// so that plans don't hang or fail on dependencies that are installed later
+func checkExternalReleasesOnPlan(ctx context.Context, fs *ReleaseSet) {
+	var releases []ExternalRelease
+	for _, r := range fs.WaitForExternalReleases {
+		if r.CheckOnPlan {
+			releases = append(releases, r)
+		}
+	}
+
+	if len(releases) == 0 {
+		return
+	}
+
+	client, err := kubernetesClient(releaseSetForApply(fs))
+	if err != nil {
+		logf("Warning: skipping the check of external releases on plan: %v", err)
+		return
+	}
+
+	for _, r := range releases {
+		reason, err := checkExternalRelease(ctx, client, r)
+		if err != nil {
+			logf("Warning: checking external release %s: %v", r.id(), err)
+		} else if reason != "" {
+			logf("Warning: external release %s: %s. Apply waits up to %ds for it", r.id(), reason, r.Timeout)
+		}
+	}
+}
+
+// checkExternalRelease returns the reason why the external release is not ready, or an empty string when it is
+func checkExternalRelease(ctx context.Context, client kubernetes.Interface, r ExternalRelease) (string, error) {
+	version, found, err := deployedChartVersion(ctx, client, r.Namespace, r.Name)
+	if err != nil {
+		return "", fmt.Errorf("getting external release %s: %w", r.id(), err)
+	}
+
+	if !found {
+		return "not installed", nil
+	}
+
+	if r.MinVersion == "" {
+		return "", nil
+	}
+
+	min, err := semver.NewVersion(r.MinVersion)
+	if err != nil {
+		return "", fmt.Errorf("parsing min_version %q of external release %s: %w", r.MinVersion, r.id(), err)
+	}
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("parsing chart version %q of external release %s: %w", version, r.id(), err)
+	}
+
+	if v.LessThan(min) {
+		return fmt.Sprintf("version %s is below min_version %s", version, r.MinVersion), nil
+	}
+
+	return "", nil
+}
+
+// deployedChartVersion returns the chart version of the deployed revision of the helm release,
+// read from the release secret that helm stores with the default secrets storage driver
+func deployedChartVersion(ctx context.Context, client kubernetes.Interface, namespace, name string) (string, bool, error) {
+	secrets, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("owner=helm,name=%s,status=deployed", name),
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	var latest []byte
+	latestRevision := -1
+	for _, s := range secrets.Items {
+		revision, _ := strconv.Atoi(s.Labels["version"])
+		if revision > latestRevision {
+			latest = s.Data["release"]
+			latestRevision = revision
+		}
+	}
+
+	if latest == nil {
+		return "", false, nil
+	}
+
+	version, err := decodeReleaseChartVersion(latest)
+	if err != nil {
+		return "", false, err
+	}
+
+	return version, true, nil
+}
+
+// decodeReleaseChartVersion decodes the base64-encoded and optionally gzipped JSON of a helm release
+func decodeReleaseChartVersion(data []byte) (string, error) {
+	bs, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return "", fmt.Errorf("decoding release: %w", err)
+	}
+
+	if bytes.HasPrefix(bs, []byte{0x1f, 0x8b, 0x08}) {
+		r, err := gzip.NewReader(bytes.NewReader(bs))
+		if err != nil {
+			return "", fmt.Errorf("decompressing release: %w", err)
+		}
+		defer r.Close()
+
+		bs, err = ioutil.ReadAll(r)
+		if err != nil {
+			return "", fmt.Errorf("decompressing release: %w", err)
+		}
+	}
+
+	var release struct {
+		Chart struct {
+			Metadata struct {
+				Version string `json:"version"`
+			} `json:"metadata"`
+		} `json:"chart"`
+	}
+	if err := json.Unmarshal(bs, &release); err != nil {
+		return "", fmt.Errorf("parsing release: %w", err)
+	}
+
+	return release.Chart.Metadata.Version, nil
+}
@@ -0,0 +1,130 @@
+package helmfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// helmReleaseSecret returns the secret in which helm stores the revision of a release
+func helmReleaseSecret(t *testing.T, namespace, name string, revision int, status, chartVersion string) *corev1.Secret {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	fmt.Fprintf(w, `{"name":%q,"chart":{"metadata":{"name":"cert-manager","version":%q}}}`, name, chartVersion)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, revision),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"owner":   "helm",
+				"name":    name,
+				"status":  status,
+				"version": fmt.Sprint(revision),
+			},
+		},
+		Data: map[string][]byte{
+			"release": []byte(base64.StdEncoding.EncodeToString(buf.Bytes())),
+		},
+	}
+}
+
+func TestCheckExternalRelease(t *testing.T) {
+	client := withFakeKubernetesClient(t,
+		helmReleaseSecret(t, "cert-manager", "cert-manager", 1, "superseded", "v1.10.0"),
+		helmReleaseSecret(t, "cert-manager", "cert-manager", 2, "deployed", "v1.12.3"),
+		helmReleaseSecret(t, "cert-manager", "failed", 1, "failed", "v1.12.3"),
+	)
+
+	tests := []struct {
+		release ExternalRelease
+		want    string
+	}{
+		{
+			release: ExternalRelease{Name: "cert-manager", Namespace: "cert-manager"},
+		},
+		{
+			release: ExternalRelease{Name: "cert-manager", Namespace: "cert-manager", MinVersion: "1.12.0"},
+		},
+		{
+			release: ExternalRelease{Name: "cert-manager", Namespace: "cert-manager", MinVersion: "1.13.0"},
+			want:    "version v1.12.3 is below min_version 1.13.0",
+		},
+		{
+			release: ExternalRelease{Name: "cert-manager", Namespace: "default"},
+			want:    "not installed",
+		},
+		{
+			release: ExternalRelease{Name: "failed", Namespace: "cert-manager"},
+			want:    "not installed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.release.id()+"@"+tt.release.MinVersion, func(t *testing.T) {
+			got, err := checkExternalRelease(context.Background(), client, tt.release)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("unexpected reason: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWaitForExternalReleasesTimeout(t *testing.T) {
+	withFakeKubernetesClient(t)
+
+	orig := externalReleasePollInterval
+	externalReleasePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { externalReleasePollInterval = orig })
+
+	fs := &ReleaseSet{
+		Kubeconfig: writeTestKubeconfig(t),
+		WaitForExternalReleases: []ExternalRelease{
+			{Name: "cert-manager", Namespace: "cert-manager", Timeout: 0},
+		},
+	}
+
+	err := waitForExternalReleases(context.Background(), fs)
+	if err == nil || !strings.Contains(err.Error(), "waiting for external release cert-manager/cert-manager: not installed") {
+		t.Errorf("expected the apply to fail naming the missing release, got %v", err)
+	}
+}
+
+func TestWaitForExternalReleasesInstalledMeanwhile(t *testing.T) {
+	client := withFakeKubernetesClient(t)
+
+	orig := externalReleasePollInterval
+	externalReleasePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { externalReleasePollInterval = orig })
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		client.CoreV1().Secrets("cert-manager").Create(context.Background(), helmReleaseSecret(t, "cert-manager", "cert-manager", 1, "deployed", "1.12.0"), metav1.CreateOptions{})
+	}()
+
+	fs := &ReleaseSet{
+		Kubeconfig: writeTestKubeconfig(t),
+		WaitForExternalReleases: []ExternalRelease{
+			{Name: "cert-manager", Namespace: "cert-manager", MinVersion: "1.12.0", Timeout: 10},
+		},
+	}
+
+	if err := waitForExternalReleases(context.Background(), fs); err != nil {
+		t.Errorf("expected to wait until the release is installed, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// managedHelmfileFlags are the helmfile flags that the provider sets from the attributes of the release set,
+// along with their shorthands, which extra_args can't override
+var managedHelmfileFlags = []struct {
+	name, shorthand string
+}{
+	{name: "--file", shorthand: "-f"},
+	{name: "--environment", shorthand: "-e"},
+	{name: "--kubeconfig"},
+}
+
+// validateExtraArg validates that each of extra_args is not one of the flags that the provider manages
+func validateExtraArg(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	if s == "" {
+		return nil, []error{fmt.Errorf("%s: args can't be empty", k)}
+	}
+
+	for _, f := range managedHelmfileFlags {
+		long := s == f.name || strings.HasPrefix(s, f.name+"=")
+		short := f.shorthand != "" && strings.HasPrefix(s, f.shorthand) && !strings.HasPrefix(s, "--")
+
+		if long || short {
+			return nil, []error{fmt.Errorf("%s: %q can't be passed, as the provider sets %s from the attributes of the release set", k, s, f.name)}
+		}
+	}
+
+	return nil, nil
+}
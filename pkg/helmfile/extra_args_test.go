@@ -0,0 +1,63 @@
+package helmfile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestValidateExtraArg(t *testing.T) {
+	for _, arg := range []string{"--allow-no-matching-release", "--enable-live-output", "--log-level=debug", "-q", "--args"} {
+		if _, errs := validateExtraArg(arg, KeyExtraArgs); len(errs) > 0 {
+			t.Errorf("expected %q to be valid, got %v", arg, errs)
+		}
+	}
+
+	for arg, flag := range map[string]string{
+		"--file":               "--file",
+		"--file=helmfile.yaml": "--file",
+		"-f":                   "--file",
+		"-fhelmfile.yaml":      "--file",
+		"--environment=prod":   "--environment",
+		"-e":                   "--environment",
+		"--kubeconfig":         "--kubeconfig",
+	} {
+		_, errs := validateExtraArg(arg, KeyExtraArgs)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "the provider sets "+flag) {
+			t.Errorf("expected %q to be rejected as %s, got %v", arg, flag, errs)
+		}
+	}
+
+	if _, errs := validateExtraArg("", KeyExtraArgs); len(errs) != 1 {
+		t.Errorf("expected an empty arg to be rejected, got %v", errs)
+	}
+}
+
+func TestBinaryExecutorExtraArgs(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir, ExtraArgs: []string{"--allow-no-matching-release", "--log-level", "debug"}}
+
+	if _, err := executor.Destroy(context.Background(), &DestroyOptions{BaseOptions: base}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --no-color --allow-no-matching-release --log-level debug destroy") {
+		t.Errorf("expected the extra args among the global flags, got %s", got)
+	}
+}
+
+func TestExtraArgsConfigProviders(t *testing.T) {
+	base := newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml", ExtraArgs: []string{"--burst-limit", "200"}}, zap.NewNop().Sugar())
+
+	if got := (&destroyConfigProvider{baseConfigProvider: base}).Args(); got != "--burst-limit 200" {
+		t.Errorf("expected the extra args as the args of helm, got %q", got)
+	}
+
+	template := &templateConfigProvider{baseConfigProvider: base, args: "--api-versions=monitoring.coreos.com/v1"}
+	if got := template.Args(); got != "--burst-limit 200 --api-versions=monitoring.coreos.com/v1" {
+		t.Errorf("expected the extra args along with the API versions, got %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+package helmfile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"go.uber.org/zap"
+)
+
+func TestNewReleaseSetDiffAndSyncArgs(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:    "releases: []",
+		KeyKubeconfig: "/tmp/kubeconfig",
+		KeyDiffArgs:   "--dry-run=server",
+		KeySyncArgs:   "--atomic --timeout 10m",
+	})
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	apply := buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})
+	diff := buildDiffOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}, 0)
+	if apply.DiffArgs != "--dry-run=server" || apply.SyncArgs != "--atomic --timeout 10m" || diff.DiffArgs != "--dry-run=server" {
+		t.Errorf("expected the args as is, got apply %q and %q, diff %q", apply.DiffArgs, apply.SyncArgs, diff.DiffArgs)
+	}
+
+	// The diffs cached without diff_args can't be reused once it's set
+	with, err := diffCacheHash(diffCacheSettings{DiffArgs: fs.DiffArgs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	without, err := diffCacheHash(diffCacheSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if with == without {
+		t.Errorf("expected diff_args to change the diff cache settings, got %s for both", with)
+	}
+}
+
+func TestDiffAndSyncArgsConfigProviders(t *testing.T) {
+	base := newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml"}, zap.NewNop().Sugar())
+
+	apply := &applyConfigProvider{baseConfigProvider: base, noHooks: true, diffArgs: "--dry-run=server", syncArgs: "--atomic"}
+	if apply.DiffArgs() != "--dry-run=server" || apply.SyncArgs() != "--no-hooks --atomic" {
+		t.Errorf("expected diff_args as is and sync_args after --no-hooks, got %q and %q", apply.DiffArgs(), apply.SyncArgs())
+	}
+
+	if sync := (&syncConfigProvider{baseConfigProvider: base, syncArgs: "--atomic"}); sync.SyncArgs() != "--atomic" {
+		t.Errorf("expected sync_args as is, got %q", sync.SyncArgs())
+	}
+}
+
+func TestBinaryExecutorDiffAndSyncArgs(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, DiffArgs: "--dry-run=server", SyncArgs: "--atomic --timeout 10m"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// sync_args with spaces must reach helmfile as the single value of --sync-args
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --diff-args --dry-run=server --sync-args '--atomic --timeout 10m'") {
+		t.Errorf("expected helmfile apply to run with the diff and sync args, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base, DiffArgs: "--dry-run=server", KubeVersion: "1.29.0"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.Contains(got, " --diff-args '--dry-run=server --kube-version=1.29.0'") {
+		t.Errorf("expected helmfile diff to pass diff_args along with the kube version, got %s", got)
+	}
+}
@@ -0,0 +1,240 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultReleaseSetTimeout is the default Terraform timeout of creating, updating and deleting a release set
+const DefaultReleaseSetTimeout = 30 * time.Minute
+
+// validateHelmTimeout validates that the helm timeout is a positive duration like 5m or 90s
+func validateHelmTimeout(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return nil, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s must be a duration like 5m or 90s: %v", k, err)}
+	}
+
+	if d <= 0 {
+		return nil, []error{fmt.Errorf("%s must be positive, got %s", k, s)}
+	}
+
+	return nil, nil
+}
+
+// parseHelmTimeout parses the helm timeout, which is empty when not set
+func parseHelmTimeout(v interface{}, k string) (time.Duration, error) {
+	s, _ := v.(string)
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", k, err)
+	}
+
+	return d, nil
+}
+
+// helmTimeoutSeconds converts the timeout to the whole seconds that helmfile passes to helm, rounding up
+func helmTimeoutSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// applyHelmDefaults returns the helmDefaults that make helmfile pass helm_timeout_apply or wait_timeout to helm upgrade
+func applyHelmDefaults(fs *ReleaseSet) map[string]interface{} {
+	timeout := fs.HelmTimeoutApply
+	if fs.WaitTimeout > 0 {
+		timeout = fs.WaitTimeout
+	}
+
+	if timeout <= 0 {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"timeout": helmTimeoutSeconds(timeout),
+	}
+}
+
+// deleteTimeout returns the --timeout of helm uninstall, which is either delete_timeout or helm_timeout_destroy
+func deleteTimeout(fs *ReleaseSet) time.Duration {
+	if fs.DeleteTimeout > 0 {
+		return fs.DeleteTimeout
+	}
+
+	return fs.HelmTimeoutDestroy
+}
+
+// destroyHelmDefaults returns the helmDefaults that make helmfile pass delete_wait and delete_timeout or
+// helm_timeout_destroy to helm uninstall. Helmfile passes the delete timeout only along with --wait.
+//
+// They are injected rather than passed as --deleteWait and --deleteTimeout, which helmfile doesn't pass on to helm uninstall.
+func destroyHelmDefaults(fs *ReleaseSet) map[string]interface{} {
+	timeout := deleteTimeout(fs)
+	if !fs.DeleteWait && timeout <= 0 {
+		return nil
+	}
+
+	defaults := map[string]interface{}{
+		"deleteWait": true,
+	}
+
+	if timeout > 0 {
+		defaults["deleteTimeout"] = helmTimeoutSeconds(timeout)
+	}
+
+	return defaults
+}
+
+// injectHelmDefaults sets the keys of the top-level helmDefaults of the helmfile content.
+//
+// The injected keys override the ones declared in the content, as they come from the attributes that are specific to the operation.
+// The content can be a Go template, so we operate on the text line-by-line rather than parsing it as YAML.
+func injectHelmDefaults(content string, defaults map[string]interface{}) string {
+	if len(defaults) == 0 {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+
+	start := -1
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "helmDefaults:") {
+			continue
+		}
+
+		if strings.TrimRight(l, " \t\r") != "helmDefaults:" {
+			// Flow-style helmDefaults like `helmDefaults: {wait: true}` can't be merged line-by-line
+			logf("Warning: skipped injecting helm timeouts because the helmDefaults in the helmfile content is not a block mapping")
+
+			return content
+		}
+
+		start = i
+		break
+	}
+
+	if start < 0 {
+		block := append([]string{"helmDefaults:"}, helmDefaultsLines(defaults, "  ")...)
+
+		return strings.Join(block, "\n") + "\n" + content
+	}
+
+	// Drop the keys declared by the user that are injected, keeping the indentation they use
+	indent := "  "
+	end := start + 1
+	result := make([]string, 0, len(lines)+len(defaults))
+	result = append(result, lines[:start+1]...)
+
+	var kept []string
+	for ; end < len(lines); end++ {
+		l := lines[end]
+		trimmed := strings.TrimSpace(l)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") && l[0] != ' ' && l[0] != '\t' {
+			break
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if len(kept) == 0 {
+				indent = l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+			}
+			if i := strings.Index(trimmed, ":"); i > 0 {
+				if _, ok := defaults[strings.TrimSpace(trimmed[:i])]; ok {
+					continue
+				}
+			}
+		}
+		kept = append(kept, l)
+	}
+
+	result = append(result, helmDefaultsLines(defaults, indent)...)
+	result = append(result, kept...)
+	result = append(result, lines[end:]...)
+
+	return strings.Join(result, "\n")
+}
+
+// helmDefaultsLines returns the YAML lines for the helmDefaults sorted by key
+func helmDefaultsLines(defaults map[string]interface{}, indent string) []string {
+	keys := make([]string, 0, len(defaults))
+	for k := range defaults {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s%s: %v", indent, k, defaults[k]))
+	}
+
+	return lines
+}
+
+// explainWaitTimeout points the error of an apply that timed out waiting for the releases at the workloads and the
+// timeout, as helm only reports the condition it was waiting for
+func explainWaitTimeout(fs *ReleaseSet, err error) error {
+	if err == nil || !(fs.Wait || fs.WaitForJobs) {
+		return err
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "timed out waiting for the condition") && !strings.Contains(msg, "context deadline exceeded") {
+		return err
+	}
+
+	timeout := "helmfile's default timeout"
+	if fs.WaitTimeout > 0 {
+		timeout = fmt.Sprintf("%s = %s", KeyWaitTimeout, fs.WaitTimeout)
+	} else if fs.HelmTimeoutApply > 0 {
+		timeout = fmt.Sprintf("%s = %s", KeyHelmTimeoutApply, fs.HelmTimeoutApply)
+	}
+
+	return fmt.Errorf("the workloads or jobs of the releases were not ready within %s. "+
+		"Check the pods and jobs of the releases, or raise %s: %w", timeout, KeyWaitTimeout, err)
+}
+
+// warnHelmTimeout logs a warning when the helm timeout is not shorter than the Terraform timeout of the operation,
+// as Terraform would give up on the operation before helm does
+func warnHelmTimeout(key string, helmTimeout time.Duration, operation string, timeout time.Duration) {
+	if helmTimeout > 0 && helmTimeout >= timeout {
+		logf("Warning: %s = %s is not shorter than the %s timeout %s of the resource", key, helmTimeout, operation, timeout)
+	}
+}
+
+// commandWithContext returns a copy of the command that is stopped when the context is done.
+// helmfile runs helm, which runs plugins like helm-diff, so the whole process group is sent SIGTERM,
+// and killed if it is still running after shutdownFlushTimeout.
+func commandWithContext(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	c := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	c.Dir = cmd.Dir
+	c.Env = cmd.Env
+	c.Stdin = cmd.Stdin
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	c.ExtraFiles = cmd.ExtraFiles
+
+	setProcessGroup(c)
+	c.Cancel = func() error {
+		time.AfterFunc(shutdownFlushTimeout, func() {
+			if c.ProcessState == nil {
+				signalProcessGroup(c, syscall.SIGKILL)
+			}
+		})
+
+		return signalProcessGroup(c, syscall.SIGTERM)
+	}
+
+	return c
+}
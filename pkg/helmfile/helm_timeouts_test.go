@@ -0,0 +1,200 @@
+package helmfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+func TestValidateHelmTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		wantErr string
+	}{
+		{value: ""},
+		{value: "10m"},
+		{value: "1m30s"},
+		{value: "10", wantErr: "must be a duration like 5m or 90s"},
+		{value: "-1m", wantErr: "must be positive"},
+		{value: "0s", wantErr: "must be positive"},
+	} {
+		_, errs := validateHelmTimeout(tc.value, KeyHelmTimeoutApply)
+		if tc.wantErr == "" {
+			if len(errs) > 0 {
+				t.Errorf("%q: unexpected errors: %v", tc.value, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.wantErr) {
+			t.Errorf("%q: expected an error containing %q, got %v", tc.value, tc.wantErr, errs)
+		}
+	}
+}
+
+func TestInjectHelmDefaults(t *testing.T) {
+	defaults := map[string]interface{}{"deleteTimeout": 300, "deleteWait": true}
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "no helmDefaults",
+			content: `releases:
+- name: myapp
+  chart: sp/podinfo
+`,
+			want: `helmDefaults:
+  deleteTimeout: 300
+  deleteWait: true
+releases:
+- name: myapp
+  chart: sp/podinfo
+`,
+		},
+		{
+			name: "existing helmDefaults",
+			content: `helmDefaults:
+    wait: true
+    deleteTimeout: 60
+    # keep this
+releases:
+- name: myapp
+`,
+			want: `helmDefaults:
+    deleteTimeout: 300
+    deleteWait: true
+    wait: true
+    # keep this
+releases:
+- name: myapp
+`,
+		},
+		{
+			name: "flow style is left as is",
+			content: `helmDefaults: {wait: true}
+releases: []
+`,
+			want: `helmDefaults: {wait: true}
+releases: []
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := injectHelmDefaults(tc.content, defaults); got != tc.want {
+				t.Errorf("unexpected content:\nwant:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestHelmDefaultsPerOperation(t *testing.T) {
+	fs := &ReleaseSet{
+		Content: `releases:
+- name: myapp
+  chart: sp/podinfo
+`,
+		WorkingDirectory:   t.TempDir(),
+		HelmTimeoutApply:   90 * time.Second,
+		HelmTimeoutDiff:    time.Minute,
+		HelmTimeoutDestroy: 1500 * time.Millisecond,
+	}
+
+	for _, tc := range []struct {
+		operation    string
+		helmDefaults map[string]interface{}
+		want         string
+	}{
+		{operation: "apply", helmDefaults: applyHelmDefaults(fs), want: "helmDefaults:\n  timeout: 90\n"},
+		{operation: "destroy", helmDefaults: destroyHelmDefaults(fs), want: "helmDefaults:\n  deleteTimeout: 2\n  deleteWait: true\n"},
+		{operation: "diff", want: "releases:\n"},
+	} {
+		t.Run(tc.operation, func(t *testing.T) {
+			files, err := prepareHelmfileFile(fs, tc.helmDefaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer files.remove()
+
+			bs, err := ioutil.ReadFile(files.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(string(bs), tc.want) {
+				t.Errorf("expected the helmfile for %s to start with %q, got:\n%s", tc.operation, tc.want, bs)
+			}
+		})
+	}
+
+	if d := applyHelmDefaults(&ReleaseSet{}); d != nil {
+		t.Errorf("expected no helmDefaults without helm_timeout_apply, got %v", d)
+	}
+
+	if d := applyHelmDefaults(&ReleaseSet{Wait: true, WaitTimeout: 2 * time.Minute}); !reflect.DeepEqual(d, map[string]interface{}{"timeout": 120}) {
+		t.Errorf("expected wait_timeout to be the timeout of helm upgrade, got %v", d)
+	}
+
+	if d := destroyHelmDefaults(&ReleaseSet{DeleteWait: true}); !reflect.DeepEqual(d, map[string]interface{}{"deleteWait": true}) {
+		t.Errorf("expected delete_wait without a timeout to keep helmfile's default timeout, got %v", d)
+	}
+
+	if d := destroyHelmDefaults(&ReleaseSet{DeleteWait: true, DeleteTimeout: 10 * time.Minute}); !reflect.DeepEqual(d, map[string]interface{}{"deleteWait": true, "deleteTimeout": 600}) {
+		t.Errorf("expected delete_timeout to be the timeout of helm uninstall, got %v", d)
+	}
+}
+
+func TestExplainWaitTimeout(t *testing.T) {
+	timedOut := errors.New("UPGRADE FAILED: timed out waiting for the condition")
+
+	if err := explainWaitTimeout(&ReleaseSet{}, timedOut); err != timedOut {
+		t.Errorf("expected the error to be kept as is without wait, got %v", err)
+	}
+
+	if err := explainWaitTimeout(&ReleaseSet{Wait: true}, errors.New("chart not found")); err.Error() != "chart not found" {
+		t.Errorf("expected the other errors to be kept as is, got %v", err)
+	}
+
+	err := explainWaitTimeout(&ReleaseSet{Wait: true, WaitTimeout: 30 * time.Second}, timedOut)
+	if !errors.Is(err, timedOut) || !strings.Contains(err.Error(), "not ready within wait_timeout = 30s") {
+		t.Errorf("expected the error to point at wait_timeout, got %v", err)
+	}
+
+	err = explainWaitTimeout(&ReleaseSet{WaitForJobs: true}, errors.New("context deadline exceeded"))
+	if !strings.Contains(err.Error(), "not ready within helmfile's default timeout") {
+		t.Errorf("expected the error to point at the default timeout, got %v", err)
+	}
+}
+
+func TestRunDiffHelmTimeout(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "helmfile")
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ReleaseSet{
+		Bin:              bin,
+		Content:          "releases: []\n",
+		WorkingDirectory: t.TempDir(),
+		HelmTimeoutDiff:  100 * time.Millisecond,
+	}
+
+	start := time.Now()
+
+	_, err := runDiff(&sdk.Context{}, fs, DiffConfig{})
+	if err == nil || !strings.Contains(err.Error(), "helmfile diff timed out after helm_timeout_diff = 100ms") {
+		t.Errorf("expected the diff to time out, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the diff to be killed, took %s", elapsed)
+	}
+}
@@ -0,0 +1,211 @@
+package helmfile
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// hookLogLinePattern matches the timestamp and level that the library executor's capture logger prefixes to each line
+	hookLogLinePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\S+)\t[A-Z]+\t(.*)$`)
+
+	// hookTriggeredPattern matches the line that helmfile logs before running a hook
+	hookTriggeredPattern = regexp.MustCompile(`^hook\[(.+)\]: triggered by event "([^"]+)"`)
+
+	// hookFailedPattern matches the error of a failed hook, prefixed with the release for release hooks
+	hookFailedPattern = regexp.MustCompile("(?:failed processing release (\\S+): )?hook\\[([^\\]]+)\\]: command `([^`]*)` failed: (.*)$")
+
+	// hookLinePattern matches any other line logged by a hook, including the line with its output that is logged after it exits
+	hookLinePattern = regexp.MustCompile(`^hook\[(.+?)\]: (.*)$`)
+
+	exitStatusPattern = regexp.MustCompile(`exit status (\d+)`)
+)
+
+const hookLogTimeLayout = "2006-01-02T15:04:05.000Z0700"
+
+// HookResult is the result of a helmfile hook parsed out of the helmfile output
+type HookResult struct {
+	// Event is the event that triggered the hook, like presync
+	Event string
+
+	// Name is the name of the hook, which is its command when the hook has no name
+	Name string
+
+	// Release is the release of the hook. It is known only for the hooks that failed.
+	Release string
+
+	// Command is the command of the hook. It is known only for the hooks that failed.
+	Command string
+
+	// ExitStatus is the exit status of the command, zero on success
+	ExitStatus int
+
+	// Duration is the time the hook took. It is known only when the output has timestamps, as with the library executor.
+	Duration time.Duration
+
+	start time.Time
+	done  bool
+}
+
+func (r HookResult) failed() bool {
+	return r.ExitStatus != 0
+}
+
+func hookResultsToList(results []HookResult) []interface{} {
+	list := make([]interface{}, 0, len(results))
+	for _, r := range results {
+		list = append(list, map[string]interface{}{
+			KeyHookResultEvent:           r.Event,
+			KeyHookResultName:            r.Name,
+			KeyHookResultRelease:         r.Release,
+			KeyHookResultCommand:         r.Command,
+			KeyHookResultExitStatus:      r.ExitStatus,
+			KeyHookResultDurationSeconds: r.Duration.Seconds(),
+		})
+	}
+	return list
+}
+
+// parseHookResults parses the results of the hooks out of the helmfile output and error.
+// Helmfile logs the hooks at the debug level, which the library executor captures with timestamps.
+// The helmfile binary logs only the errors of the failed hooks by default.
+func parseHookResults(output string) []HookResult {
+	var results []HookResult
+
+	lines := strings.Split(output, "\n")
+
+	for i, l := range lines {
+		var ts time.Time
+
+		if m := hookLogLinePattern.FindStringSubmatch(l); m != nil {
+			ts, _ = time.Parse(hookLogTimeLayout, m[1])
+			l = m[2]
+		}
+
+		if m := hookTriggeredPattern.FindStringSubmatch(l); m != nil {
+			results = append(results, HookResult{Event: m[2], Name: m[1], start: ts})
+			continue
+		}
+
+		if m := hookFailedPattern.FindStringSubmatch(l); m != nil {
+			release, name, command := m[1], m[2], m[3]
+
+			idx := lastHookResult(results, name, func(HookResult) bool { return true })
+			if idx >= 0 && results[idx].failed() {
+				// The error of a hook is printed more than once, with or without the release
+				if prev := results[idx]; prev.Command == command && (prev.Release == release || prev.Release == "" || release == "") {
+					if release != "" {
+						results[idx].Release = release
+					}
+					continue
+				}
+				idx = -1
+			}
+
+			if idx < 0 {
+				// The binary doesn't log the triggering of hooks by default
+				results = append(results, HookResult{Name: name, done: true})
+				idx = len(results) - 1
+			}
+
+			results[idx].Release = release
+			results[idx].Command = command
+			results[idx].ExitStatus = hookExitStatus(m[4], lines[i+1:])
+			continue
+		}
+
+		if m := hookLinePattern.FindStringSubmatch(l); m != nil && !strings.HasPrefix(m[2], "stateFilePath=") {
+			idx := lastHookResult(results, m[1], func(r HookResult) bool { return !r.done })
+			if idx < 0 {
+				continue
+			}
+
+			results[idx].done = true
+			if !ts.IsZero() && !results[idx].start.IsZero() {
+				results[idx].Duration = ts.Sub(results[idx].start)
+			}
+		}
+	}
+
+	return results
+}
+
+func lastHookResult(results []HookResult, name string, cond func(HookResult) bool) int {
+	for i := len(results) - 1; i >= 0; i-- {
+		if results[i].Name == name && cond(results[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// hookExitStatus returns the exit status in the error of a failed hook.
+// The error is either like "exit status 1", or the multi-line error of helmfile's runner that has an EXIT STATUS section.
+func hookExitStatus(err string, following []string) int {
+	if m := exitStatusPattern.FindStringSubmatch(err); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return status
+	}
+
+	if strings.Contains(err, "exited with non-zero status") {
+		for i, l := range following {
+			if strings.TrimSpace(l) != "EXIT STATUS" || i+1 >= len(following) {
+				continue
+			}
+
+			if status, err := strconv.Atoi(strings.TrimSpace(following[i+1])); err == nil {
+				return status
+			}
+		}
+	}
+
+	// The hook failed without an exit status, like when the command was not found
+	return 1
+}
+
+// hookFailure describes the first failed hook for the error of the operation, or returns an empty string when no hook failed
+func hookFailure(results []HookResult) string {
+	for _, r := range results {
+		if !r.failed() {
+			continue
+		}
+
+		hook := fmt.Sprintf("hook %q", r.Name)
+		if r.Event != "" {
+			hook = r.Event + " " + hook
+		}
+		if r.Release != "" {
+			hook += " of release " + r.Release
+		}
+
+		return fmt.Sprintf("%s failed: command `%s` exited with status %d", hook, r.Command, r.ExitStatus)
+	}
+
+	return ""
+}
+
+// recordHookResults sets hook_results from the output of apply, and returns the error of apply naming the failed hook if any
+func recordHookResults(d ResourceReadWrite, result *Result, err error) error {
+	var output string
+	if result != nil {
+		output = result.Output
+	}
+	if err != nil {
+		output += "\n" + err.Error()
+	}
+
+	results := parseHookResults(output)
+
+	d.Set(KeyHookResults, hookResultsToList(results))
+
+	if err != nil {
+		if failure := hookFailure(results); failure != "" {
+			return fmt.Errorf("%s: %w", failure, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,89 @@
+package helmfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHookResults(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []HookResult
+		failure string
+	}{
+		{
+			fixture: "library_v1.txt",
+			want: []HookResult{
+				{Event: "prepare", Name: "prepare-crds", Duration: 1500 * time.Millisecond},
+				{Event: "presync", Name: "migrate", Release: "myapp", Command: "./migrate.sh", ExitStatus: 3, Duration: 2250 * time.Millisecond},
+				{Event: "postsync", Name: "notify", Duration: 100 * time.Millisecond},
+			},
+			failure: "presync hook \"migrate\" of release myapp failed: command `./migrate.sh` exited with status 3",
+		},
+		{
+			fixture: "binary_v0.txt",
+			want: []HookResult{
+				{Name: "./migrate.sh", Release: "myapp", Command: "./migrate.sh", ExitStatus: 2},
+			},
+			failure: "hook \"./migrate.sh\" of release myapp failed: command `./migrate.sh` exited with status 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			bs, err := ioutil.ReadFile(filepath.Join("testdata", "hooks", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := parseHookResults(string(bs))
+
+			// Compare the exported fields only
+			for i := range got {
+				got[i].start = time.Time{}
+				got[i].done = false
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected hook results:\nwant %+v\ngot  %+v", tt.want, got)
+			}
+
+			if failure := hookFailure(got); failure != tt.failure {
+				t.Errorf("unexpected failure: want %q, got %q", tt.failure, failure)
+			}
+		})
+	}
+}
+
+func TestRecordHookResults(t *testing.T) {
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	result := &Result{
+		Output: "2026-10-15T10:00:00.000Z\tDEBUG\thook[migrate]: triggered by event \"presync\"\n" +
+			"2026-10-15T10:00:01.000Z\tDEBUG\thook[migrate]: done\n",
+		ExitCode: 1,
+	}
+
+	err := recordHookResults(d, result, errors.New("failed processing release myapp: hook[migrate]: command `./migrate.sh` failed: exit status 1"))
+	if err == nil || !strings.HasPrefix(err.Error(), "presync hook \"migrate\" of release myapp failed: command `./migrate.sh` exited with status 1: ") {
+		t.Errorf("expected the error to name the hook event and release, got %v", err)
+	}
+
+	results, ok := d.Get(KeyHookResults).([]interface{})
+	if !ok || len(results) != 1 {
+		t.Fatalf("expected one hook result, got %v", d.Get(KeyHookResults))
+	}
+
+	if got := results[0].(map[string]interface{})[KeyHookResultDurationSeconds]; got != 1.0 {
+		t.Errorf("unexpected duration: %v", got)
+	}
+
+	if err := recordHookResults(d, &Result{Output: "UPDATED RELEASES:"}, nil); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+const (
+	// IDSchemeInputs uses the hash of the inputs that identify the release set, and is the default
+	IDSchemeInputs = "inputs"
+
+	// IDSchemeRandom generates a random ID like xid, which was the default before inputs
+	IDSchemeRandom = "random"
+
+	// IDSchemeName uses the name attribute as the ID
+	IDSchemeName = "name"
+
+	// IDSchemeContentHash uses the hash of the content attribute as the ID
+	IDSchemeContentHash = "content-hash"
+)
+
+// validateIDScheme fails when the inputs of the ID scheme of the release set are missing
+func validateIDScheme(fs *ReleaseSet) error {
+	switch fs.IDScheme {
+	case "", IDSchemeInputs, IDSchemeRandom:
+	case IDSchemeName:
+		if fs.Name == "" {
+			return fmt.Errorf("%s must be set when %s is %q", KeyName, KeyIDScheme, IDSchemeName)
+		}
+	case IDSchemeContentHash:
+		if fs.Content == "" {
+			return fmt.Errorf("%s must be set when %s is %q", KeyContent, KeyIDScheme, IDSchemeContentHash)
+		}
+	default:
+		return fmt.Errorf("unsupported %s %q", KeyIDScheme, fs.IDScheme)
+	}
+
+	return nil
+}
+
+// releaseSetID generates the ID of a new release set according to its ID scheme.
+// The ID is generated only on create, so that changing the scheme never replaces existing resources.
+func releaseSetID(d ResourceRead, fs *ReleaseSet) (string, error) {
+	if err := validateIDScheme(fs); err != nil {
+		return "", err
+	}
+
+	switch fs.IDScheme {
+	case IDSchemeName:
+		return fs.Name, nil
+	case IDSchemeContentHash:
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(fs.Content)))[:16], nil
+	case IDSchemeRandom:
+		return newId(), nil
+	default:
+		return inputsID(d.Get)
+	}
+}
+
+// inputsID returns the short SHA-256 of the inputs that identify a release set, which are its working directory,
+// the path or else the content of its helmfile, its environment, its selectors, its values and the cluster it deploys to.
+// The values are part of the ID because release sets that differ only in them would otherwise share the ID, and
+// with it their scratch directory, temporary files, reports and cached drift.
+// get reads an attribute from the configuration or from the raw state, so that the state upgrader derives the same ID.
+// Selectors are sorted, as their order doesn't change the releases they select.
+func inputsID(get func(key string) interface{}) (string, error) {
+	str := func(key string) string {
+		s, _ := get(key).(string)
+		return s
+	}
+
+	source := str(KeyPath)
+	if source == "" {
+		source = fmt.Sprintf("content:%x", sha256.Sum256([]byte(str(KeyContent))))
+	}
+
+	// The temporary kubeconfig generated for EKS changes on every run, so the cluster is identified by its name instead
+	kubeconfig := str(KeyKubeconfig)
+	if strings.HasPrefix(filepath.Base(kubeconfig), temporaryKubeconfigPrefix) {
+		kubeconfig = ""
+	}
+
+	var selectors []string
+	if ss, ok := get(KeySelectors).([]interface{}); ok {
+		for _, s := range ss {
+			selectors = append(selectors, fmt.Sprintf("%v", s))
+		}
+	}
+	sort.Strings(selectors)
+
+	// The configuration reads an unset selector as an empty map while the raw state can omit it
+	selector, _ := get(KeySelector).(map[string]interface{})
+	if len(selector) == 0 {
+		selector = nil
+	}
+
+	inputs := map[string]interface{}{
+		"working_directory": str(KeyWorkingDirectory),
+		"source":            source,
+		"environment":       str(KeyEnvironment),
+		"selector":          selector,
+		"selectors":         selectors,
+		"kubeconfig":        kubeconfig,
+		"eks_cluster_name":  str(KeyEKSClusterName),
+		"cluster_endpoint":  str(KeyClusterEndpoint),
+	}
+
+	// The order of the values matters, as later values override earlier ones, so they are hashed as is
+	for _, key := range []string{KeyValuesFiles, KeyValues, KeyReleasesValues, KeyReleasesValuesString, KeySet, KeyStateValues, KeyEphemeralValues} {
+		if v := nonEmptyInput(get(key)); v != nil {
+			inputs[key] = v
+		}
+	}
+
+	bs, err := json.Marshal(inputs)
+	if err != nil {
+		return "", fmt.Errorf("hashing the inputs of the ID: %w", err)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(bs))[:16], nil
+}
+
+// nonEmptyInput returns nil for an empty list or map, which the configuration reads for an unset attribute while the
+// raw state can omit it, so that both give the same ID
+func nonEmptyInput(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+	}
+	return v
+}
+
+// upgradeReleaseSetStateV0 records the random id_scheme for release sets created before the attribute existed, as
+// their IDs were random. The IDs are kept as is, as rewriting them would break the correlation of their logs and
+// temporary files across the upgrade.
+func upgradeReleaseSetStateV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	if rawState == nil {
+		return rawState, nil
+	}
+
+	if scheme, _ := rawState[KeyIDScheme].(string); scheme == "" {
+		rawState[KeyIDScheme] = IDSchemeRandom
+	}
+
+	return rawState, nil
+}
+
+// suppressIDSchemeChange suppresses the changes of id_scheme on existing release sets, as the ID is generated only on
+// create, so that the state keeps recording the scheme their ID was actually generated with
+func suppressIDSchemeChange(k, old, new string, d *schema.ResourceData) bool {
+	return d.Id() != ""
+}
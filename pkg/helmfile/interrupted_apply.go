@@ -0,0 +1,89 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ChangeReasonInterruptedApply is the change reason of the release set whose last apply was interrupted
+// by the shutdown of the provider
+const ChangeReasonInterruptedApply = "previous apply interrupted"
+
+// interruptedApplyDir is the directory, relative to the Terraform root module like the diff files,
+// in which the partial output of the applies interrupted by the shutdown of the provider is kept
+var interruptedApplyDir = filepath.Join(".terraform", "helmfile", "interrupted")
+
+// interruptedApplyPath returns the path to the partial output of the interrupted apply of the release set.
+// A release set interrupted while being created has no ID, so its output is kept under a unique name instead.
+func interruptedApplyPath(id string) string {
+	if id == "" {
+		id = fmt.Sprintf("new-%d", time.Now().UnixNano())
+	}
+
+	return filepath.Join(interruptedApplyDir, id+".log")
+}
+
+// recordInterruptedApply keeps the partial output of the apply interrupted by the shutdown of the provider,
+// so that it can be inspected, and so that the next plan of the release set recomputes its outputs
+func recordInterruptedApply(id string, result *Result, err error) {
+	path := interruptedApplyPath(id)
+
+	var output string
+	if result != nil {
+		output = result.Output
+	}
+	output += fmt.Sprintf("\nInterrupted: %v\n", err)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logf("Warning: failed to keep the output of the interrupted apply: %v", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(path, []byte(output), 0644); err != nil {
+		logf("Warning: failed to keep the output of the interrupted apply: %v", err)
+		return
+	}
+
+	logf("Kept the output of the interrupted apply in %s", path)
+}
+
+// hasInterruptedApply returns true when the last apply of the release set was interrupted
+func hasInterruptedApply(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	_, err := os.Stat(interruptedApplyPath(id))
+
+	return err == nil
+}
+
+// clearInterruptedApply removes the output of the interrupted apply of the release set, once it is applied or deleted
+func clearInterruptedApply(id string) {
+	if id == "" {
+		return
+	}
+
+	if err := os.Remove(interruptedApplyPath(id)); err != nil && !os.IsNotExist(err) {
+		logf("Warning: failed to remove the output of the interrupted apply: %v", err)
+	}
+}
+
+// markInterruptedApply marks the outputs of the release set whose last apply was interrupted as computed,
+// as the releases may have been left partially upgraded, and reports the interruption as the change reason
+func markInterruptedApply(d newResourceDiffChecker, reason string) {
+	d.SetNewComputed(KeyDiffOutput)
+	d.SetNewComputed(KeyApplyOutput)
+	d.SetNewComputed(KeySummary)
+
+	if reason == "" {
+		reason = ChangeReasonInterruptedApply
+	} else {
+		reason = ChangeReasonInterruptedApply + ", " + reason
+	}
+
+	d.SetNew(KeyChangeReason, reason)
+}
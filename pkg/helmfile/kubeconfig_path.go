@@ -0,0 +1,245 @@
+package helmfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// resolvedKubeconfig is the kubeconfig path that is exported as KUBECONFIG, along with where it came from
+type resolvedKubeconfig struct {
+	// Path is the absolute path, or the absolute paths separated by the path list separator like KUBECONFIG
+	Path string
+
+	// Source is the attribute the path came from, like kubeconfig or environment_variables.KUBECONFIG
+	Source string
+
+	// Raw is the path as configured
+	Raw string
+
+	// paths are each path of Raw along with the absolute path it was resolved to
+	paths []kubeconfigPath
+}
+
+// kubeconfigPath is a kubeconfig path as configured and the absolute path it was resolved to
+type kubeconfigPath struct {
+	raw string
+	abs string
+}
+
+// String describes the resolution for effective_kubeconfig_source
+func (k resolvedKubeconfig) String() string {
+	if k.Source == "" {
+		return ""
+	}
+
+	// The temporary file is another one on each operation
+	if k.Source == KeyKubeconfigContent {
+		return fmt.Sprintf("%s written to a temporary file", k.Source)
+	}
+
+	if k.Raw == k.Path {
+		return fmt.Sprintf("%s = %s", k.Source, k.Path)
+	}
+
+	return fmt.Sprintf("%s = %s resolved to %s", k.Source, k.Raw, k.Path)
+}
+
+// resolveKubeconfig resolves the kubeconfig path from the kubeconfig attribute or environment_variables.KUBECONFIG.
+//
+// A leading ~ is expanded to the home directory, and $VAR and ${VAR} to environment_variables or the provider's environment.
+// Relative paths are made absolute against working_directory, which helmfile runs in. When the file doesn't exist there
+// but exists under the root module directory, which is the working directory of the provider, the latter is used.
+// Each path of a KUBECONFIG list is resolved likewise. The files are verified to exist by verify, as a kubeconfig
+// generated by another resource may not exist until apply.
+func resolveKubeconfig(fs *ReleaseSet) (*resolvedKubeconfig, error) {
+	if err := validateOperationEnvironmentVariables(fs); err != nil {
+		return nil, err
+	}
+
+	var env string
+
+	if v, ok := fs.EnvironmentVariables["KUBECONFIG"]; ok {
+		env = v.(string)
+	}
+
+	k := &resolvedKubeconfig{}
+
+	if fs.KubeconfigContentFile != "" && fs.Kubeconfig == fs.KubeconfigContentFile {
+		if env != "" {
+			return nil, fmt.Errorf("validating release set: helmfile_release_set.environment_variables.KUBECONFIG cannot be set with helmfile_release_set.kubeconfig_content")
+		}
+
+		k.Source, k.Raw, k.Path = KeyKubeconfigContent, fs.Kubeconfig, fs.Kubeconfig
+
+		return k, nil
+	}
+
+	if fs.Kubeconfig != "" {
+		if env != "" {
+			return nil, fmt.Errorf("validating release set: helmfile_release_set.environment_variables.KUBECONFIG cannot be set with helmfile_release_set.kubeconfig")
+		}
+
+		k.Source, k.Raw = KeyKubeconfig, fs.Kubeconfig
+	} else if env != "" {
+		k.Source, k.Raw = KeyEnvironmentVariables+".KUBECONFIG", env
+	}
+
+	if k.Raw == "" {
+		// Preserves the previous behavior of resolving the empty path to the current directory
+		abs, err := filepath.Abs("")
+		if err != nil {
+			return nil, xerrors.Errorf("determining absolute path for kubeconfig path: %w", err)
+		}
+
+		k.Path = abs
+
+		return k, nil
+	}
+
+	var paths []string
+
+	for _, p := range filepath.SplitList(k.Raw) {
+		if p == "" {
+			continue
+		}
+
+		abs, err := resolveKubeconfigPath(p, fs)
+		if err != nil {
+			return nil, xerrors.Errorf("determining absolute path for kubeconfig path %s: %w", p, err)
+		}
+
+		paths = append(paths, abs)
+		k.paths = append(k.paths, kubeconfigPath{raw: p, abs: abs})
+	}
+
+	k.Path = strings.Join(paths, string(os.PathListSeparator))
+
+	return k, nil
+}
+
+func resolveKubeconfigPath(p string, fs *ReleaseSet) (string, error) {
+	p = os.Expand(p, func(name string) string {
+		if v, ok := fs.EnvironmentVariables[name].(string); ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("expanding ~: %w", err)
+		}
+
+		p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+	}
+
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p), nil
+	}
+
+	// The root module directory differs from working_directory like path.module, as under Terraform Cloud
+	abs, err := filepath.Abs(filepath.Join(fs.WorkingDirectory, p))
+	if err != nil {
+		return "", err
+	}
+
+	// Earlier versions resolved the relative paths against the root module directory
+	if _, err := os.Stat(abs); err != nil && fs.WorkingDirectory != "" {
+		inRootModule, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := os.Stat(inRootModule); err == nil {
+			return inRootModule, nil
+		}
+	}
+
+	return abs, nil
+}
+
+// verify returns an error with the path as configured and the path it was resolved to when a kubeconfig file is missing,
+// as the commands run with it would otherwise fail to reach the cluster with an error that names neither
+func (k resolvedKubeconfig) verify() error {
+	for _, p := range k.paths {
+		info, err := os.Stat(p.abs)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s %q resolved to %s does not exist", k.Source, p.raw, p.abs)
+		} else if err != nil {
+			return fmt.Errorf("%s %q resolved to %s: %w", k.Source, p.raw, p.abs, err)
+		}
+
+		if info.IsDir() {
+			return fmt.Errorf("%s %q resolved to %s is a directory rather than a kubeconfig file", k.Source, p.raw, p.abs)
+		}
+	}
+
+	return nil
+}
+
+// setEffectiveKubeconfigSource records where the kubeconfig came from and the path it was resolved to, for debugging
+func setEffectiveKubeconfigSource(d ResourceReadWrite, fs *ReleaseSet) error {
+	k, err := resolveKubeconfig(fs)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Set(KeyEffectiveKubeconfigSource, k.String()); err != nil {
+		return fmt.Errorf("setting %s: %w", KeyEffectiveKubeconfigSource, err)
+	}
+
+	return nil
+}
+
+// writeKubeconfigContent writes kubeconfig_content to a temporary file in the working directory, which becomes the
+// kubeconfig of the release set until removeKubeconfigContent removes it
+func writeKubeconfigContent(fs *ReleaseSet, content string) error {
+	path, err := writeTemporaryKubeconfig(content, fs.WorkingDirectory, "content")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", KeyKubeconfigContent, err)
+	}
+
+	fs.Kubeconfig = path
+	fs.KubeconfigContentFile = path
+
+	removeOnShutdown(path)
+
+	return nil
+}
+
+// removeKubeconfigContent removes the temporary file that kubeconfig_content was written to for the operation
+func removeKubeconfigContent(fs *ReleaseSet) {
+	if fs.KubeconfigContentFile == "" {
+		return
+	}
+
+	if err := cleanupKubeconfig(fs.KubeconfigContentFile); err != nil {
+		logf("Warning: failed to remove the temporary file of %s: %v", KeyKubeconfigContent, err)
+	}
+}
+
+// withResolvedKubeconfig returns the environment variables with KUBECONFIG replaced by the resolved path,
+// so that the literal ~ or relative path is never exported
+func withResolvedKubeconfig(envVars map[string]interface{}, fs *ReleaseSet) map[string]interface{} {
+	if v, _ := envVars["KUBECONFIG"].(string); v == "" {
+		return envVars
+	}
+
+	k, err := resolveKubeconfig(fs)
+	if err != nil {
+		return envVars
+	}
+
+	resolved := make(map[string]interface{}, len(envVars))
+	for key, v := range envVars {
+		resolved[key] = v
+	}
+	resolved["KUBECONFIG"] = k.Path
+
+	return resolved
+}
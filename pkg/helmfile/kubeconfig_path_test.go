@@ -0,0 +1,327 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestResolveKubeconfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	root := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	// The root module directory resolved like filepath.Abs does, as the temp dir can be a symlink
+	root, err = filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workingDirectory := filepath.Join(root, "work")
+	if err := os.MkdirAll(workingDirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workingDirectory, "generated-kubeconfig"), []byte(testKubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "root-kubeconfig"), []byte(testKubeconfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{root, workingDirectory} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "both-kubeconfig"), []byte(testKubeconfig), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		envVars  map[string]interface{}
+		wantPath string
+	}{
+		{
+			name:     "tilde",
+			path:     "~/.kube/config",
+			wantPath: filepath.Join(home, ".kube", "config"),
+		},
+		{
+			name:     "HOME",
+			path:     "$HOME/.kube/config",
+			wantPath: filepath.Join(home, ".kube", "config"),
+		},
+		{
+			name:     "environment_variables take precedence in references",
+			path:     "${CLUSTER_DIR}/config",
+			envVars:  map[string]interface{}{"CLUSTER_DIR": "/etc/clusters/prod"},
+			wantPath: "/etc/clusters/prod/config",
+		},
+		{
+			name:     "relative to working_directory",
+			path:     "generated-kubeconfig",
+			wantPath: filepath.Join(workingDirectory, "generated-kubeconfig"),
+		},
+		{
+			name:     "relative to the root module when only there",
+			path:     "root-kubeconfig",
+			wantPath: filepath.Join(root, "root-kubeconfig"),
+		},
+		{
+			name:     "working_directory takes precedence over the root module",
+			path:     "both-kubeconfig",
+			wantPath: filepath.Join(workingDirectory, "both-kubeconfig"),
+		},
+		{
+			name:     "missing relative path resolved against working_directory",
+			path:     "kubeconfig",
+			wantPath: filepath.Join(workingDirectory, "kubeconfig"),
+		},
+		{
+			name:     "already absolute",
+			path:     "/etc/kubernetes/admin.conf",
+			wantPath: "/etc/kubernetes/admin.conf",
+		},
+		{
+			name:     "list",
+			path:     "~/a" + string(os.PathListSeparator) + "/etc/b",
+			wantPath: filepath.Join(home, "a") + string(os.PathListSeparator) + "/etc/b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("kubeconfig/"+tt.name, func(t *testing.T) {
+			fs := &ReleaseSet{Kubeconfig: tt.path, EnvironmentVariables: tt.envVars, WorkingDirectory: "work"}
+
+			k, err := resolveKubeconfig(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k.Path != tt.wantPath {
+				t.Errorf("expected %s, got %s", tt.wantPath, k.Path)
+			}
+			if k.Source != KeyKubeconfig {
+				t.Errorf("expected the source to be %s, got %s", KeyKubeconfig, k.Source)
+			}
+		})
+
+		t.Run("environment_variables/"+tt.name, func(t *testing.T) {
+			envVars := map[string]interface{}{"KUBECONFIG": tt.path}
+			for k, v := range tt.envVars {
+				envVars[k] = v
+			}
+			fs := &ReleaseSet{EnvironmentVariables: envVars, WorkingDirectory: "work"}
+
+			k, err := resolveKubeconfig(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k.Path != tt.wantPath {
+				t.Errorf("expected %s, got %s", tt.wantPath, k.Path)
+			}
+			if k.Source != "environment_variables.KUBECONFIG" {
+				t.Errorf("expected the source to be environment_variables.KUBECONFIG, got %s", k.Source)
+			}
+
+			// The resolved path is exported as KUBECONFIG instead of the literal one
+			if got := withResolvedKubeconfig(envVars, fs)["KUBECONFIG"]; got != tt.wantPath {
+				t.Errorf("expected KUBECONFIG to be exported as %s, got %v", tt.wantPath, got)
+			}
+			if envVars["KUBECONFIG"] != tt.path {
+				t.Errorf("expected environment_variables not to be modified, got %v", envVars["KUBECONFIG"])
+			}
+		})
+	}
+}
+
+func TestGetKubeconfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	workingDirectory := t.TempDir()
+
+	for _, p := range []string{filepath.Join(home, ".kube", "config"), filepath.Join(workingDirectory, "kubeconfig")} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(testKubeconfig), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantPath string
+		wantErr  []string
+	}{
+		{
+			name:     "tilde",
+			path:     "~/.kube/config",
+			wantPath: filepath.Join(home, ".kube", "config"),
+		},
+		{
+			name:     "relative",
+			path:     "kubeconfig",
+			wantPath: filepath.Join(workingDirectory, "kubeconfig"),
+		},
+		{
+			name:     "absolute",
+			path:     filepath.Join(workingDirectory, "kubeconfig"),
+			wantPath: filepath.Join(workingDirectory, "kubeconfig"),
+		},
+		{
+			name:    "missing",
+			path:    "~/.kube/missing",
+			wantErr: []string{`"~/.kube/missing"`, filepath.Join(home, ".kube", "missing"), "does not exist"},
+		},
+		{
+			name:    "directory",
+			path:    "~/.kube",
+			wantErr: []string{`"~/.kube"`, "is a directory"},
+		},
+		{
+			name:    "missing in the list",
+			path:    "kubeconfig" + string(os.PathListSeparator) + "other",
+			wantErr: []string{`"other"`, filepath.Join(workingDirectory, "other"), "does not exist"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, fs := range []*ReleaseSet{
+			{Kubeconfig: tt.path, WorkingDirectory: workingDirectory},
+			{EnvironmentVariables: map[string]interface{}{"KUBECONFIG": tt.path}, WorkingDirectory: workingDirectory},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				kubeconfig, err := getKubeconfig(fs)
+
+				if tt.wantErr != nil {
+					if err == nil {
+						t.Fatalf("expected an error, got %s", *kubeconfig)
+					}
+					for _, want := range tt.wantErr {
+						if !strings.Contains(err.Error(), want) {
+							t.Errorf("expected the error to contain %q, got %q", want, err.Error())
+						}
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if *kubeconfig != tt.wantPath {
+					t.Errorf("expected %s, got %s", tt.wantPath, *kubeconfig)
+				}
+			})
+		}
+	}
+}
+
+func TestResolvedKubeconfigString(t *testing.T) {
+	tests := []struct {
+		k    resolvedKubeconfig
+		want string
+	}{
+		{k: resolvedKubeconfig{Source: KeyKubeconfig, Raw: "~/.kube/config", Path: "/home/me/.kube/config"}, want: "kubeconfig = ~/.kube/config resolved to /home/me/.kube/config"},
+		{k: resolvedKubeconfig{Source: "environment_variables.KUBECONFIG", Raw: "/etc/kubeconfig", Path: "/etc/kubeconfig"}, want: "environment_variables.KUBECONFIG = /etc/kubeconfig"},
+		{k: resolvedKubeconfig{Path: "/cwd"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.k.String(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestKubeconfigContent(t *testing.T) {
+	fs := &ReleaseSet{WorkingDirectory: t.TempDir()}
+
+	if err := writeKubeconfigContent(fs, testKubeconfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(fs.Kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the kubeconfig to be readable by the owner only, got %s", info.Mode().Perm())
+	}
+
+	bs, err := ioutil.ReadFile(fs.Kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != testKubeconfig {
+		t.Errorf("expected the kubeconfig to be written as is, got:\n%s", bs)
+	}
+
+	k, err := resolveKubeconfig(fs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if k.Path != fs.Kubeconfig || k.String() != "kubeconfig_content written to a temporary file" {
+		t.Errorf("expected the temporary file of kubeconfig_content, got %+v", k)
+	}
+
+	// Like kubeconfig, kubeconfig_content can't be overridden by environment_variables.KUBECONFIG
+	fs.EnvironmentVariables = map[string]interface{}{"KUBECONFIG": "/etc/kubeconfig"}
+	if _, err := resolveKubeconfig(fs); err == nil || !strings.Contains(err.Error(), "cannot be set with helmfile_release_set.kubeconfig_content") {
+		t.Errorf("expected environment_variables.KUBECONFIG to conflict with kubeconfig_content, got %v", err)
+	}
+
+	removeKubeconfigContent(fs)
+
+	if _, err := os.Stat(fs.Kubeconfig); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be removed, got %v", err)
+	}
+}
+
+// writeTestKubeconfig writes a kubeconfig to a temporary directory, as the kubeconfig files are verified to exist
+// before the commands are run with them
+func writeTestKubeconfig(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestReleaseSetDiffWithKubeconfigNotYetGenerated tests that the plan succeeds with the kubeconfig generated by another
+// resource in the same apply, which doesn't exist until then
+func TestReleaseSetDiffWithKubeconfigNotYetGenerated(t *testing.T) {
+	provider, err := New(schema.TestResourceDataRaw(t, Provider().(*schema.Provider).Schema, map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		KeyContent:          "releases: []",
+		KeyWorkingDirectory: t.TempDir(),
+		KeyKubeconfig:       "generated/kubeconfig",
+		KeyDiffNewResources: true,
+	})
+
+	if _, err := resourceHelmfileReleaseSet().Diff(nil, config, provider); err != nil {
+		t.Fatalf("expected the plan to tolerate the missing kubeconfig, got %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	kustomizeStrategicMergePatchesKey = "strategicMergePatches"
+	kustomizeJSONPatchesKey           = "jsonPatches"
+)
+
+// KustomizePatches are the patches that helmfile applies to the rendered manifests of a release with chartify
+type KustomizePatches struct {
+	// Release is the name of the release in the content to patch
+	Release string
+
+	// Patches are paths to patch files, or inline patches
+	Patches []string
+}
+
+func newKustomizePatches(v interface{}) []KustomizePatches {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var patches []KustomizePatches
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		p := KustomizePatches{Release: m[KeyKustomizePatchesRelease].(string)}
+		for _, patch := range m[KeyKustomizePatchesPatches].([]interface{}) {
+			p.Patches = append(p.Patches, patch.(string))
+		}
+
+		patches = append(patches, p)
+	}
+
+	return patches
+}
+
+// isInlinePatch returns true when the patch is the content of a patch rather than a path to a patch file
+func isInlinePatch(patch string) bool {
+	return strings.Contains(strings.TrimSpace(patch), "\n") || strings.HasPrefix(strings.TrimSpace(patch), "{")
+}
+
+// writeKustomizePatch writes the patch into dir, so that it can be referenced from the generated helmfile
+// regardless of where the Terraform module is. Patch files are resolved relative to the current directory
+// of Terraform, which is the root module. The file is named after the hash of the patch content,
+// so that a change in the patch changes the generated helmfile.
+//
+// It returns the absolute path to the written file and the release field the patch belongs to.
+func writeKustomizePatch(dir, patch string) (string, string, error) {
+	content := []byte(patch)
+	if !isInlinePatch(patch) {
+		bs, err := ioutil.ReadFile(patch)
+		if err != nil {
+			return "", "", fmt.Errorf("reading kustomize patch file: %w", err)
+		}
+		content = bs
+	}
+
+	field, err := kustomizePatchField(content)
+	if err != nil {
+		return "", "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("kustomize-patch-%x.yaml", sha256.Sum256(content)))
+	if err := writeTempFile(path, content, 0644); err != nil {
+		return "", "", fmt.Errorf("writing kustomize patch: %w", err)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		removeTempFiles(false, path)
+		return "", "", err
+	}
+
+	return abs, field, nil
+}
+
+// kustomizePatchField returns jsonPatches for a JSON patch with its target like chartify expects,
+// and strategicMergePatches for anything else
+func kustomizePatchField(content []byte) (string, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(content, &m); err != nil {
+		return "", fmt.Errorf("parsing kustomize patch: %w", err)
+	}
+
+	if _, ok := m["target"]; ok {
+		return kustomizeJSONPatchesKey, nil
+	}
+
+	return kustomizeStrategicMergePatchesKey, nil
+}
+
+// injectKustomizePatches writes the patches into dir and adds them to the strategicMergePatches and jsonPatches
+// of the matching releases in the helmfile content.
+//
+// Like injectCommonLabels, it operates on the text line-by-line as the content can be a Go template.
+// It fails when a release is not found in content or already declares the patch field, rather than
+// silently leaving the patches out. It returns the paths to the patch files along with the content, to be removed
+// after the operation.
+func injectKustomizePatches(content, dir string, patches []KustomizePatches) (_ string, _ []string, err error) {
+	if len(patches) == 0 {
+		return content, nil, nil
+	}
+
+	var written []string
+
+	// The patch files are referenced by no helmfile on errors
+	defer func() {
+		if err != nil {
+			removeTempFiles(false, written...)
+		}
+	}()
+
+	lines := strings.Split(content, "\n")
+
+	// A release declared in multiple documents gets the patches in each of them
+	items := map[string][]*releaseItem{}
+	for _, item := range releaseItems(lines) {
+		items[item.name] = append(items[item.name], item)
+	}
+
+	// Patches for the same release can be split across multiple kustomize_patches, but each field can be declared once
+	var releases []string
+	fields := map[string]map[string][]string{}
+
+	for _, p := range patches {
+		if len(items[p.Release]) == 0 {
+			return "", nil, fmt.Errorf("kustomize_patches: release %q not found in content", p.Release)
+		}
+
+		if fields[p.Release] == nil {
+			releases = append(releases, p.Release)
+			fields[p.Release] = map[string][]string{}
+		}
+
+		for _, patch := range p.Patches {
+			path, field, err := writeKustomizePatch(dir, patch)
+			if err != nil {
+				return "", nil, fmt.Errorf("kustomize_patches for release %q: %w", p.Release, err)
+			}
+			written = append(written, path)
+			fields[p.Release][field] = append(fields[p.Release][field], path)
+		}
+	}
+
+	// Lines to insert before the line at the index, so that indices of the original lines stay valid
+	inserts := map[int][]string{}
+
+	for _, release := range releases {
+		for _, item := range items[release] {
+			for _, field := range []string{kustomizeStrategicMergePatchesKey, kustomizeJSONPatchesKey} {
+				paths := fields[release][field]
+				if len(paths) == 0 {
+					continue
+				}
+
+				if _, ok := item.keys[field]; ok {
+					return "", nil, fmt.Errorf("kustomize_patches: release %q already declares %s in content. Move them to kustomize_patches", release, field)
+				}
+
+				inserts[item.end] = append(inserts[item.end], fmt.Sprintf("%s%s:", item.indent, field))
+				for _, path := range paths {
+					inserts[item.end] = append(inserts[item.end], fmt.Sprintf("%s- %s", item.indent, strconv.Quote(path)))
+				}
+			}
+		}
+	}
+
+	result := make([]string, 0, len(lines))
+	for i, l := range lines {
+		result = append(result, inserts[i]...)
+		result = append(result, l)
+	}
+	result = append(result, inserts[len(lines)]...)
+
+	return strings.Join(result, "\n"), written, nil
+}
+
+// releaseItem is the location of a release entry in the lines of the helmfile content
+type releaseItem struct {
+	// name is the name of the release
+	name string
+
+	// document is the index of the YAML document of the entry, as separated by ---
+	document int
+
+	// end is the index of the line following the last non-empty line of the entry
+	end int
+
+	// indent is the indentation of the keys of the entry
+	indent string
+
+	// keys are the indices of the lines of the top-level keys of the entry
+	keys map[string]int
+
+	// labels are the keys of the labels of the entry
+	labels map[string]bool
+}
+
+// releaseItems returns the entries of the top-level releases of the helmfile content in order.
+// Every document of the content is scanned, so that the releases of the layered documents are found too.
+// A release declared in multiple documents has an entry for each of them.
+func releaseItems(lines []string) []*releaseItem {
+	var (
+		items       []*releaseItem
+		cur         *releaseItem
+		document    int
+		inReleases  bool
+		itemIndent  = -1
+		key         string
+		childIndent = -1
+	)
+
+	flush := func() {
+		if cur != nil && cur.name != "" {
+			items = append(items, cur)
+		}
+		cur = nil
+	}
+
+	for i, l := range lines {
+		l = strings.TrimRight(l, "\r")
+		trimmed := strings.TrimLeft(l, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(l) - len(trimmed)
+
+		// The next top-level key or document ends the releases
+		if indent == 0 && !strings.HasPrefix(trimmed, "- ") {
+			flush()
+			inReleases = strings.TrimRight(trimmed, " \t") == "releases:"
+			itemIndent = -1
+
+			if isDocumentSeparator(trimmed) {
+				document++
+			}
+			continue
+		}
+
+		if !inReleases {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") && (itemIndent < 0 || indent == itemIndent) {
+			flush()
+			itemIndent = indent
+			cur = &releaseItem{
+				document: document,
+				indent:   strings.Repeat(" ", indent+2),
+				keys:     map[string]int{},
+				labels:   map[string]bool{},
+			}
+			trimmed = strings.TrimPrefix(trimmed, "- ")
+			indent += 2
+		}
+
+		if cur == nil {
+			continue
+		}
+
+		cur.end = i + 1
+
+		j := strings.Index(trimmed, ":")
+
+		if indent > len(cur.indent) {
+			// The labels are the first level of keys under the labels key
+			if childIndent < 0 {
+				childIndent = indent
+			}
+			if key == "labels" && indent == childIndent && j > 0 {
+				cur.labels[unquoteLabelKey(trimmed[:j])] = true
+			}
+			continue
+		}
+
+		if indent != len(cur.indent) || j <= 0 {
+			continue
+		}
+
+		key = unquoteLabelKey(trimmed[:j])
+		childIndent = -1
+		cur.keys[key] = i
+
+		if key == "name" {
+			cur.name = unquoteLabelKey(strings.SplitN(trimmed[j+1:], "#", 2)[0])
+		}
+	}
+
+	flush()
+
+	return items
+}
@@ -0,0 +1,134 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const testStrategicMergePatch = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  patched: "true"
+`
+
+const testJSONPatch = `target:
+  kind: ConfigMap
+  name: app
+patch:
+- op: add
+  path: /data/json
+  value: "true"
+`
+
+func TestInjectKustomizePatches(t *testing.T) {
+	dir := t.TempDir()
+
+	patchFile := filepath.Join(t.TempDir(), "patch.yaml")
+	if err := ioutil.WriteFile(patchFile, []byte(testStrategicMergePatch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content := `releases:
+- name: app
+  chart: ./charts/app
+  values:
+  - replicas: 1
+
+- chart: ./charts/worker
+  name: "worker"
+environments:
+  default: {}
+`
+
+	got, _, err := injectKustomizePatches(content, dir, []KustomizePatches{
+		{Release: "app", Patches: []string{patchFile}},
+		{Release: "worker", Patches: []string{testJSONPatch}},
+		{Release: "app", Patches: []string{testJSONPatch}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smp := filepath.Join(dir, fmt.Sprintf("kustomize-patch-%x.yaml", sha256.Sum256([]byte(testStrategicMergePatch))))
+	jp := filepath.Join(dir, fmt.Sprintf("kustomize-patch-%x.yaml", sha256.Sum256([]byte(testJSONPatch))))
+
+	want := fmt.Sprintf(`releases:
+- name: app
+  chart: ./charts/app
+  values:
+  - replicas: 1
+  strategicMergePatches:
+  - %[1]s
+  jsonPatches:
+  - %[2]s
+
+- chart: ./charts/worker
+  name: "worker"
+  jsonPatches:
+  - %[2]s
+environments:
+  default: {}
+`, strconv.Quote(smp), strconv.Quote(jp))
+
+	if got != want {
+		t.Errorf("unexpected content:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	var state map[string]interface{}
+	if err := yaml.Unmarshal([]byte(got), &state); err != nil {
+		t.Errorf("expected valid YAML: %v", err)
+	}
+
+	if bs, err := ioutil.ReadFile(jp); err != nil || string(bs) != testJSONPatch {
+		t.Errorf("expected the inline patch to be written, got %q, %v", bs, err)
+	}
+}
+
+func TestInjectKustomizePatchesErrors(t *testing.T) {
+	content := `releases:
+- name: app
+  chart: ./charts/app
+  strategicMergePatches:
+  - ./patch.yaml
+`
+
+	tests := []struct {
+		name    string
+		patches []KustomizePatches
+		wantErr string
+	}{
+		{
+			name:    "missing release",
+			patches: []KustomizePatches{{Release: "missing", Patches: []string{testJSONPatch}}},
+			wantErr: `release "missing" not found`,
+		},
+		{
+			name:    "field already declared",
+			patches: []KustomizePatches{{Release: "app", Patches: []string{testStrategicMergePatch}}},
+			wantErr: "already declares strategicMergePatches",
+		},
+		{
+			name:    "missing patch file",
+			patches: []KustomizePatches{{Release: "app", Patches: []string{"./missing.yaml"}}},
+			wantErr: "reading kustomize patch file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := injectKustomizePatches(content, t.TempDir(), tt.patches)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package helmfile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"go.uber.org/zap"
+)
+
+func TestNewReleaseSetReuseAndResetValues(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:     "releases: []",
+		KeyKubeconfig:  "/tmp/kubeconfig",
+		KeyReuseValues: true,
+		KeyResetValues: true,
+	})
+
+	if _, err := NewReleaseSet(d); err == nil || !strings.Contains(err.Error(), "reuse_values and reset_values cannot both be true") {
+		t.Errorf("expected reuse_values and reset_values to conflict, got %v", err)
+	}
+
+	d = schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:     "releases: []",
+		KeyKubeconfig:  "/tmp/kubeconfig",
+		KeyReuseValues: true,
+	})
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !fs.ReuseValues || fs.ResetValues {
+		t.Errorf("expected only ReuseValues, got %v and %v", fs.ReuseValues, fs.ResetValues)
+	}
+}
+
+func TestLastValuesOptions(t *testing.T) {
+	fs := &ReleaseSet{ReuseValues: true}
+
+	apply := buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})
+	diff := buildDiffOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}, 0)
+	if !apply.ReuseValues || !diff.ReuseValues {
+		t.Errorf("expected apply and diff to reuse the values alike, got %v and %v", apply.ReuseValues, diff.ReuseValues)
+	}
+
+	// helmfile diff on plan merges the last values like apply does
+	if flags := strings.Join(diffOutputFlags(fs), " "); !strings.HasSuffix(flags, "--reuse-values") {
+		t.Errorf("expected helmfile diff on plan to run with --reuse-values, got %s", flags)
+	}
+
+	base := newBaseConfigProvider(BaseOptions{FileOrDir: "/tmp/helmfile.yaml"}, zap.NewNop().Sugar())
+	providers := map[string]interface {
+		ReuseValues() bool
+		ResetValues() bool
+	}{
+		"apply": &applyConfigProvider{baseConfigProvider: base, resetValues: true},
+		"sync":  &syncConfigProvider{baseConfigProvider: base, resetValues: true},
+		"diff":  &diffConfigProvider{baseConfigProvider: base, resetValues: true},
+	}
+	for name, p := range providers {
+		if p.ReuseValues() || !p.ResetValues() {
+			t.Errorf("expected %s to reset the values, got ReuseValues %v and ResetValues %v", name, p.ReuseValues(), p.ResetValues())
+		}
+	}
+}
+
+func TestBinaryExecutorLastValues(t *testing.T) {
+	dir, executor := newFakeBinaryHelmfile(t)
+
+	base := BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}
+
+	if _, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: base, ReuseValues: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --reuse-values") {
+		t.Errorf("expected helmfile apply to reuse the values, got %s", got)
+	}
+
+	if _, err := executor.Sync(context.Background(), &SyncOptions{BaseOptions: base, ResetValues: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " sync --reset-values") {
+		t.Errorf("expected helmfile sync to reset the values, got %s", got)
+	}
+
+	if _, err := executor.Diff(context.Background(), &DiffOptions{BaseOptions: base, ReuseValues: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFakeBinaryArgs(t, dir); !strings.HasSuffix(got, " --reuse-values") {
+		t.Errorf("expected helmfile diff to reuse the values, got %s", got)
+	}
+}
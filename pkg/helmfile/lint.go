@@ -0,0 +1,28 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+)
+
+// runLint runs helmfile lint against the content of the release set. It returns an error with the output of the lint
+// when it finds errors.
+func runLint(fs *ReleaseSet, executor HelmfileExecutor) error {
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		return fmt.Errorf("preparing helmfile file: %w", err)
+	}
+	defer files.remove()
+
+	result, err := executor.Lint(context.Background(), buildLintOptions(fs, files))
+	if err != nil {
+		if result != nil && result.Output != "" {
+			return fmt.Errorf("running helmfile lint: %w\nOutput:\n%s", err, result.Output)
+		}
+		return fmt.Errorf("running helmfile lint: %w", err)
+	}
+
+	logf("[DEBUG] helmfile lint found no errors")
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package helmfile
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunLint(t *testing.T) {
+	dir := t.TempDir()
+
+	newReleaseSet := func() *ReleaseSet {
+		return &ReleaseSet{
+			Content:          "releases:\n- name: myapp\n  chart: ./charts/myapp\n",
+			WorkingDirectory: dir,
+			Concurrency:      2,
+		}
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		executor := &fakeExecutor{
+			lintOutput: "==> Linting ./charts/myapp\n[ERROR] templates/: parse error at (myapp/templates/deployment.yaml:3): unexpected \"}\"\n",
+			lintErr:    errors.New("1 chart(s) linted, 1 chart(s) failed"),
+		}
+
+		err := runLint(newReleaseSet(), executor)
+		if err == nil {
+			t.Fatal("expected the lint errors to fail")
+		}
+		if !strings.Contains(err.Error(), "1 chart(s) failed") || !strings.Contains(err.Error(), "[ERROR] templates/: parse error") {
+			t.Errorf("expected the error to have the lint output, got %v", err)
+		}
+	})
+
+	t.Run("no errors without kubeconfig", func(t *testing.T) {
+		executor := &fakeExecutor{lintOutput: "==> Linting ./charts/myapp\n1 chart(s) linted, 0 chart(s) failed\n"}
+
+		if err := runLint(newReleaseSet(), executor); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(executor.lintOptions) != 1 {
+			t.Fatalf("expected helmfile lint to run once, got %d runs", len(executor.lintOptions))
+		}
+
+		opts := executor.lintOptions[0]
+		if opts.Kubeconfig != "" {
+			t.Errorf("expected no kubeconfig, got %s", opts.Kubeconfig)
+		}
+		if opts.Concurrency != 2 {
+			t.Errorf("expected the concurrency of the release set, got %d", opts.Concurrency)
+		}
+
+		// The temporary helmfile is removed after the lint
+		if _, err := os.Stat(opts.FileOrDir); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", opts.FileOrDir)
+		}
+	})
+}
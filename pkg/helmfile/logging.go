@@ -2,6 +2,8 @@ package helmfile
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
 )
@@ -11,11 +13,33 @@ func dump(s string, entries []map[string]interface{}) {
 	if j == nil {
 		j = []byte{}
 	}
-	log.Printf("DUMP[%s]: %s", s, string(j))
+	log.Print(redactSensitiveValues(fmt.Sprintf("DUMP[%s]: %s", s, string(j))))
 }
 
+// logf writes the message to the debug log, with the values of sensitive_environment_variables masked
 func logf(msg string, args ...interface{}) {
 	ppid := os.Getppid()
 	pid := os.Getpid()
-	log.Printf("[DEBUG] helmfile-provider(pid=%d,ppid=%d): "+msg, append([]interface{}{pid, ppid}, args...)...)
+	log.Print(redactSensitiveValues(fmt.Sprintf("[DEBUG] helmfile-provider(pid=%d,ppid=%d): "+msg, append([]interface{}{pid, ppid}, args...)...)))
+}
+
+// redactingWriter masks the sensitive values in the output of the standard logger, which also has the lines that the
+// eksctl SDK logs for the helmfile commands it runs
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write([]byte(redactSensitiveValues(string(p)))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// redactLogOutput wraps the output of the standard logger with a redactingWriter unless it is already wrapped
+func redactLogOutput() {
+	if _, ok := log.Writer().(*redactingWriter); !ok {
+		log.SetOutput(&redactingWriter{w: log.Writer()})
+	}
 }
@@ -0,0 +1,305 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ManagedNamespace is a namespace that the provider ensures to exist with the labels and annotations before apply
+type ManagedNamespace struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func newManagedNamespaces(v interface{}) []ManagedNamespace {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var namespaces []ManagedNamespace
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		namespaces = append(namespaces, ManagedNamespace{
+			Name:        m[KeyManagedNamespaceName].(string),
+			Labels:      toStringMap(m[KeyManagedNamespaceLabels]),
+			Annotations: toStringMap(m[KeyManagedNamespaceAnnotations]),
+		})
+	}
+
+	return namespaces
+}
+
+func toStringMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+
+	sm := make(map[string]string, len(m))
+	for k, v := range m {
+		sm[k] = fmt.Sprintf("%v", v)
+	}
+
+	return sm
+}
+
+// newKubernetesClient creates the client for the cluster of the kubeconfig. It is a variable to be replaced in tests.
+var newKubernetesClient = func(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig %s: %w", kubeconfig, err)
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// newDynamicClient creates the client listing arbitrary resources in the cluster of the kubeconfig.
+// It is a variable to be replaced in tests.
+var newDynamicClient = func(kubeconfig string) (dynamic.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig %s: %w", kubeconfig, err)
+	}
+
+	return dynamic.NewForConfig(config)
+}
+
+func kubernetesClient(fs *ReleaseSet) (kubernetes.Interface, error) {
+	kubeconfig, err := getKubeconfig(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKubernetesClient(*kubeconfig)
+}
+
+func kubernetesDynamicClient(fs *ReleaseSet) (dynamic.Interface, error) {
+	kubeconfig, err := getKubeconfig(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDynamicClient(*kubeconfig)
+}
+
+// reconcileManagedNamespaces ensures the managed namespaces exist with their labels and annotations,
+// and returns the report of the changes to be shown in apply_output
+func reconcileManagedNamespaces(ctx context.Context, fs *ReleaseSet) (string, error) {
+	if len(fs.ManagedNamespaces) == 0 {
+		return "", nil
+	}
+
+	client, err := kubernetesClient(fs)
+	if err != nil {
+		return "", err
+	}
+
+	var report []string
+	for _, ns := range fs.ManagedNamespaces {
+		changes, err := ensureNamespace(ctx, client, ns)
+		if err != nil {
+			return "", err
+		}
+
+		report = append(report, changes...)
+	}
+
+	if len(report) == 0 {
+		return "", nil
+	}
+
+	return "MANAGED NAMESPACES:\n" + strings.Join(report, "\n") + "\n\n", nil
+}
+
+// ensureNamespace creates the namespace or reconciles the drift of its labels and annotations.
+// Labels and annotations that are not declared are left as is, as they can be managed by others.
+func ensureNamespace(ctx context.Context, client kubernetes.Interface, ns ManagedNamespace) ([]string, error) {
+	existing, err := client.CoreV1().Namespaces().Get(ctx, ns.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        ns.Name,
+				Labels:      ns.Labels,
+				Annotations: ns.Annotations,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("creating namespace %s: %w", ns.Name, err)
+		}
+
+		return []string{fmt.Sprintf("namespace %s: created", ns.Name)}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getting namespace %s: %w", ns.Name, err)
+	}
+
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+	if existing.Annotations == nil {
+		existing.Annotations = map[string]string{}
+	}
+
+	changes := reconcileStringMap(ns.Name, "label", existing.Labels, ns.Labels)
+	changes = append(changes, reconcileStringMap(ns.Name, "annotation", existing.Annotations, ns.Annotations)...)
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	if _, err := client.CoreV1().Namespaces().Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return nil, fmt.Errorf("updating namespace %s: %w", ns.Name, err)
+	}
+
+	return changes, nil
+}
+
+// reconcileStringMap sets the desired entries to actual, and returns the description of the drift
+func reconcileStringMap(namespace, kind string, actual, desired map[string]string) []string {
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var changes []string
+	for _, k := range keys {
+		v := desired[k]
+
+		if old, ok := actual[k]; !ok {
+			changes = append(changes, fmt.Sprintf("namespace %s: %s %s: (none) -> %q", namespace, kind, k, v))
+		} else if old != v {
+			changes = append(changes, fmt.Sprintf("namespace %s: %s %s: %q -> %q", namespace, kind, k, old, v))
+		} else {
+			continue
+		}
+
+		actual[k] = v
+	}
+
+	return changes
+}
+
+// deleteManagedNamespaces deletes the managed namespaces that are empty after destroy.
+// Namespaces that still contain resources are left as is, as they may be used by others.
+func deleteManagedNamespaces(ctx context.Context, fs *ReleaseSet) error {
+	if !fs.DeleteManagedNamespaces || len(fs.ManagedNamespaces) == 0 {
+		return nil
+	}
+
+	client, err := kubernetesClient(fs)
+	if err != nil {
+		return err
+	}
+
+	dynamicClient, err := kubernetesDynamicClient(fs)
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range fs.ManagedNamespaces {
+		empty, err := isNamespaceEmpty(ctx, client, dynamicClient, ns.Name)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("checking if namespace %s is empty: %w", ns.Name, err)
+		}
+
+		if !empty {
+			logf("Leaving managed namespace %s as it is not empty", ns.Name)
+			continue
+		}
+
+		if err := client.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting namespace %s: %w", ns.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// isNamespaceEmpty returns true when the namespace has no objects of any namespaced resource the cluster serves,
+// including custom resources, besides the ones Kubernetes creates in every namespace.
+// When the discovery of some API groups fails, the namespace is reported as not empty, as its objects of those groups
+// can't be listed.
+func isNamespaceEmpty(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, namespace string) (bool, error) {
+	if _, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{}); err != nil {
+		return false, err
+	}
+
+	resources, err := discovery.ServerPreferredNamespacedResources(client.Discovery())
+	if discovery.IsGroupDiscoveryFailedError(err) {
+		logf("Leaving namespace %s as is, as the resources in it can't all be listed: %v", namespace, err)
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("discovering namespaced resources: %w", err)
+	}
+
+	for _, list := range discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, resources) {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return false, err
+		}
+
+		for _, r := range list.APIResources {
+			// Subresources are listed with the resources they belong to
+			if strings.Contains(r.Name, "/") || isIgnoredNamespacedResource(gv.Group, r.Name) {
+				continue
+			}
+
+			objects, err := dynamicClient.Resource(gv.WithResource(r.Name)).Namespace(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return false, fmt.Errorf("listing %s: %w", r.Name, err)
+			}
+
+			for _, obj := range objects.Items {
+				if !isDefaultNamespaceObject(gv.Group, r.Name, obj) {
+					logf("Namespace %s contains %s %s", namespace, r.Name, obj.GetName())
+					return false, nil
+				}
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// isIgnoredNamespacedResource returns true for the resources whose objects don't keep a namespace in use,
+// like the events that remain after the releases are deleted
+func isIgnoredNamespacedResource(group, resource string) bool {
+	return resource == "events" && (group == "" || group == "events.k8s.io")
+}
+
+// isDefaultNamespaceObject returns true for the objects that Kubernetes creates in every namespace,
+// which are the root CA config map, the default service account and its legacy token secrets
+func isDefaultNamespaceObject(group, resource string, obj unstructured.Unstructured) bool {
+	if group != "" {
+		return false
+	}
+
+	switch resource {
+	case "configmaps":
+		return obj.GetName() == "kube-root-ca.crt"
+	case "serviceaccounts":
+		return obj.GetName() == "default"
+	case "secrets":
+		typ, _, _ := unstructured.NestedString(obj.Object, "type")
+		return typ == string(corev1.SecretTypeServiceAccountToken)
+	}
+
+	return false
+}
@@ -0,0 +1,188 @@
+package helmfile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// fakeNamespacedResources are the resources served by the discovery of the fake client
+var fakeNamespacedResources = []*metav1.APIResourceList{
+	{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: []string{"get"}},
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "serviceaccounts", Kind: "ServiceAccount", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "events", Kind: "Event", Namespaced: true, Verbs: []string{"get", "list"}},
+			{Name: "namespaces", Kind: "Namespace", Verbs: []string{"get", "list"}},
+		},
+	},
+	{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "widgets", Kind: "Widget", Namespaced: true, Verbs: []string{"get", "list"}},
+		},
+	},
+}
+
+// withFakeKubernetesClient makes the managed namespaces use fake clients with the objects.
+// Unstructured objects, like custom resources, are only served by the dynamic client.
+func withFakeKubernetesClient(t *testing.T, objects ...runtime.Object) *fake.Clientset {
+	var typed []runtime.Object
+	for _, obj := range objects {
+		if _, ok := obj.(*unstructured.Unstructured); !ok {
+			typed = append(typed, obj)
+		}
+	}
+
+	client := fake.NewClientset(typed...)
+	client.Fake.Resources = fakeNamespacedResources
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme, map[schema.GroupVersionResource]string{
+		{Group: "example.com", Version: "v1", Resource: "widgets"}: "WidgetList",
+	}, objects...)
+
+	origClient, origDynamicClient := newKubernetesClient, newDynamicClient
+	newKubernetesClient = func(string) (kubernetes.Interface, error) { return client, nil }
+	newDynamicClient = func(string) (dynamic.Interface, error) { return dynamicClient, nil }
+	t.Cleanup(func() { newKubernetesClient, newDynamicClient = origClient, origDynamicClient })
+
+	return client
+}
+
+func TestReconcileManagedNamespaces(t *testing.T) {
+	client := withFakeKubernetesClient(t, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "existing",
+			Labels:      map[string]string{"team": "payments", "owner": "someone-else"},
+			Annotations: map[string]string{"note": "kept"},
+		},
+	})
+
+	fs := &ReleaseSet{
+		Kubeconfig: writeTestKubeconfig(t),
+		ManagedNamespaces: []ManagedNamespace{
+			{Name: "created", Labels: map[string]string{"team": "platform"}},
+			{Name: "existing", Labels: map[string]string{"team": "platform"}, Annotations: map[string]string{"scheduler": "spot"}},
+		},
+	}
+
+	report, err := reconcileManagedNamespaces(context.Background(), fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `MANAGED NAMESPACES:
+namespace created: created
+namespace existing: label team: "payments" -> "platform"
+namespace existing: annotation scheduler: (none) -> "spot"
+
+`
+	if report != want {
+		t.Errorf("unexpected report:\nwant:\n%s\ngot:\n%s", want, report)
+	}
+
+	existing, err := client.CoreV1().Namespaces().Get(context.Background(), "existing", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]string{"team": "platform", "owner": "someone-else"}; !reflect.DeepEqual(existing.Labels, want) {
+		t.Errorf("expected the undeclared labels to be kept, got %v", existing.Labels)
+	}
+
+	if want := map[string]string{"note": "kept", "scheduler": "spot"}; !reflect.DeepEqual(existing.Annotations, want) {
+		t.Errorf("unexpected annotations %v", existing.Annotations)
+	}
+
+	if report, err := reconcileManagedNamespaces(context.Background(), fs); err != nil || report != "" {
+		t.Errorf("expected no report without drift, got %q, %v", report, err)
+	}
+}
+
+func TestDeleteManagedNamespaces(t *testing.T) {
+	client := withFakeKubernetesClient(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "empty"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "empty"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "default-token-abcde", Namespace: "empty"}, Type: corev1.SecretTypeServiceAccountToken},
+		&corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "app.17a", Namespace: "empty"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "used"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "used"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "custom"}},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Widget",
+			"metadata":   map[string]interface{}{"name": "left-behind", "namespace": "custom"},
+		}},
+	)
+
+	fs := &ReleaseSet{
+		Kubeconfig: writeTestKubeconfig(t),
+		ManagedNamespaces: []ManagedNamespace{
+			{Name: "empty"},
+			{Name: "used"},
+			{Name: "custom"},
+			{Name: "missing"},
+		},
+	}
+
+	if err := deleteManagedNamespaces(context.Background(), fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "empty", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected namespaces to be kept without delete_managed_namespaces, got %v", err)
+	}
+
+	fs.DeleteManagedNamespaces = true
+	if err := deleteManagedNamespaces(context.Background(), fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "empty", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the empty namespace to be deleted, got %v", err)
+	}
+
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "used", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the namespace with a pod to be kept, got %v", err)
+	}
+
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "custom", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the namespace with a custom resource to be kept, got %v", err)
+	}
+}
+
+func TestNewManagedNamespaces(t *testing.T) {
+	got := newManagedNamespaces([]interface{}{
+		map[string]interface{}{
+			KeyManagedNamespaceName:        "apps",
+			KeyManagedNamespaceLabels:      map[string]interface{}{"team": "platform"},
+			KeyManagedNamespaceAnnotations: map[string]interface{}{},
+		},
+	})
+
+	want := []ManagedNamespace{{Name: "apps", Labels: map[string]string{"team": "platform"}, Annotations: map[string]string{}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if ns := newManagedNamespaces(nil); ns != nil {
+		t.Errorf("expected no namespaces, got %v", ns)
+	}
+}
@@ -0,0 +1,229 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DestroyScopeSelectors = "selectors"
+	DestroyScopeManaged   = "managed"
+)
+
+const (
+	DestroyCascadeBackground = "background"
+	DestroyCascadeForeground = "foreground"
+	DestroyCascadeOrphan     = "orphan"
+)
+
+// uninstallRelease uninstalls a release that is no longer in the helmfile content. It is a variable to be replaced in tests.
+var uninstallRelease = helmUninstall
+
+// managedListedRelease is a release in the JSON output of helmfile list, along with whether it is to be installed
+type managedListedRelease struct {
+	listedRelease
+
+	Enabled   *bool `json:"enabled"`
+	Installed *bool `json:"installed"`
+}
+
+// recordManagedReleases updates managed_releases with the releases matched by the selectors of the successful apply.
+//
+// The releases recorded by the previous applies are kept, so that the releases dropped from the content or the selectors
+// since they were installed are still destroyed with destroy_scope = "managed". Only the releases that helmfile apply
+// uninstalled because of installed: false are removed. Failing to list the releases only logs a warning.
+func recordManagedReleases(ctx context.Context, d ResourceReadWrite, executor HelmfileExecutor, base BaseOptions) {
+	result, err := executor.List(ctx, &ListOptions{BaseOptions: base})
+	if err != nil {
+		logf("Warning: not updating %s: listing releases: %v", KeyManagedReleases, err)
+		return
+	}
+
+	var listed []managedListedRelease
+
+	if output := strings.TrimSpace(result.Output); output != "" && output != "null" {
+		if err := json.Unmarshal([]byte(output), &listed); err != nil {
+			logf("Warning: not updating %s: parsing helmfile list output: %v", KeyManagedReleases, err)
+			return
+		}
+	}
+
+	d.Set(KeyManagedReleases, mergeManagedReleases(getManagedReleases(d), listed))
+}
+
+// mergeManagedReleases adds the installed releases to the previously managed ones and removes the uninstalled ones.
+// Disabled releases are left as they were, as helmfile apply doesn't touch them.
+func mergeManagedReleases(previous []string, listed []managedListedRelease) []string {
+	ids := map[string]bool{}
+	for _, id := range previous {
+		ids[id] = true
+	}
+
+	for _, r := range listed {
+		if r.Enabled != nil && !*r.Enabled {
+			continue
+		}
+
+		if r.Installed != nil && !*r.Installed {
+			delete(ids, r.ID())
+		} else {
+			ids[r.ID()] = true
+		}
+	}
+
+	managed := []string{}
+	for id := range ids {
+		managed = append(managed, id)
+	}
+	sort.Strings(managed)
+
+	return managed
+}
+
+func getManagedReleases(d ResourceReadWrite) []string {
+	var ids []string
+
+	switch items := d.Get(KeyManagedReleases).(type) {
+	case []string:
+		// Set as is by ResourceReadWriteEmbedded
+		ids = append(ids, items...)
+	case []interface{}:
+		for _, item := range items {
+			if id, ok := item.(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// parseReleaseID parses the namespace/name of a release in managed_releases
+func parseReleaseID(id string) listedRelease {
+	if i := strings.Index(id, "/"); i >= 0 {
+		return listedRelease{Namespace: id[:i], Name: id[i+1:]}
+	}
+	return listedRelease{Name: id}
+}
+
+// destroyManagedReleases destroys exactly the releases in managed_releases, each with a selector-scoped helmfile destroy.
+//
+// Releases that are no longer in the content are uninstalled with helm directly, and the ones that no longer exist are skipped.
+// It tries all the releases before failing with the ones that failed.
+// The second return value is false when there are no managed_releases recorded, like for resources created before
+// the attribute was added, in which case the caller falls back to destroying with the selectors.
+func destroyManagedReleases(ctx context.Context, fs *ReleaseSet, d ResourceReadWrite, opts *DestroyOptions, executor HelmfileExecutor) (bool, error) {
+	ids := getManagedReleases(d)
+	if len(ids) == 0 {
+		logf("Warning: %s is empty, most likely because the resource hasn't been applied since it was added. Destroying the releases matching the selectors instead", KeyManagedReleases)
+		return false, nil
+	}
+
+	var (
+		failed []string
+		gone   int
+	)
+
+	for _, id := range ids {
+		r := parseReleaseID(id)
+
+		releaseOpts := *opts
+		releaseOpts.Selector = nil
+		releaseOpts.Selectors = []interface{}{r.Selector()}
+
+		result, err := executor.Destroy(ctx, &releaseOpts)
+		if err == nil {
+			continue
+		}
+
+		if !isNothingToDestroy(result, err) {
+			failed = append(failed, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+
+		logf("[DEBUG] Release %s is not in the content anymore. Uninstalling it with helm", id)
+
+		out, err := uninstallRelease(ctx, fs, r)
+		if err == nil {
+			continue
+		}
+
+		if isNothingToDestroy(&Result{Output: out}, err) {
+			logf("Skipping release %s that no longer exists", id)
+			gone++
+			continue
+		}
+
+		failed = append(failed, fmt.Sprintf("%s: %v", id, err))
+	}
+
+	if len(failed) > 0 {
+		return true, fmt.Errorf("destroying %d managed release(s):\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+
+	if gone == len(ids) {
+		logf("Warning: none of the managed releases were left to destroy")
+
+		fs.nothingToDestroy = true
+	}
+
+	return true, nil
+}
+
+// helmUninstall runs helm uninstall for the release with the kubeconfig and the environment variables of the release set
+func helmUninstall(ctx context.Context, fs *ReleaseSet, r listedRelease) (string, error) {
+	args := []string{"uninstall", r.Name}
+	if r.Namespace != "" {
+		args = append(args, "--namespace", r.Namespace)
+	}
+	if timeout := deleteTimeout(fs); timeout > 0 {
+		args = append(args, "--wait", "--timeout", strconv.Itoa(helmTimeoutSeconds(timeout))+"s")
+	} else if fs.DeleteWait {
+		args = append(args, "--wait")
+	}
+	if fs.DestroyCascade != "" {
+		args = append(args, "--cascade", fs.DestroyCascade)
+	}
+
+	return runReleaseSetHelm(ctx, fs, args...)
+}
+
+// runReleaseSetHelm runs helm with the kubeconfig and the environment variables of the release set, in its working directory.
+// It returns the stdout, and the stderr is included in the error.
+func runReleaseSetHelm(ctx context.Context, fs *ReleaseSet, args ...string) (string, error) {
+	helmBin := fs.HelmBin
+	if helmBin == "" {
+		helmBin = "helm"
+	}
+
+	k, err := resolveKubeconfig(fs)
+	if err != nil {
+		return "", err
+	}
+
+	env := append(os.Environ(), readEnvironmentVariables(fs.EnvironmentVariables, "")...)
+	if k.Source != "" {
+		env = append(env, "KUBECONFIG="+k.Path)
+	}
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, helmBin, args...)
+	cmd.Dir = fs.WorkingDirectory
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("%s %s: %w: %s", helmBin, strings.Join(args, " "), err, strings.TrimSpace(errOut.String()))
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,177 @@
+package helmfile
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordManagedReleases(t *testing.T) {
+	executor := &fakeExecutor{
+		listOutput: `[
+  {"name":"app","namespace":"default","enabled":true,"installed":true},
+  {"name":"legacy","namespace":"default","enabled":true,"installed":false},
+  {"name":"optional","namespace":"monitoring","enabled":false,"installed":true},
+  {"name":"frontend","namespace":"default"}
+]`,
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{
+		// Installed by a previous apply with different selectors, or dropped from the content since then
+		KeyManagedReleases: []interface{}{"default/legacy", "monitoring/optional", "kube-system/renamed"},
+	}}
+
+	recordManagedReleases(context.Background(), d, executor, BaseOptions{})
+
+	want := []string{"default/app", "default/frontend", "kube-system/renamed", "monitoring/optional"}
+	if got := d.Get(KeyManagedReleases); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected managed releases:\nwant: %v\ngot:  %v", want, got)
+	}
+}
+
+func TestRecordManagedReleasesListFailure(t *testing.T) {
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{
+		KeyManagedReleases: []interface{}{"default/app"},
+	}}
+
+	recordManagedReleases(context.Background(), d, &fakeExecutor{listOutput: "not json"}, BaseOptions{})
+
+	if got := d.Get(KeyManagedReleases); !reflect.DeepEqual(got, []interface{}{"default/app"}) {
+		t.Errorf("expected managed releases to be kept when helmfile list fails, got %v", got)
+	}
+}
+
+func TestDestroyManagedReleases(t *testing.T) {
+	var uninstalled []string
+
+	uninstall := uninstallRelease
+	t.Cleanup(func() { uninstallRelease = uninstall })
+
+	uninstallRelease = func(ctx context.Context, fs *ReleaseSet, r listedRelease) (string, error) {
+		uninstalled = append(uninstalled, r.ID())
+		if r.Name == "gone" {
+			return "Error: uninstall: Release not loaded: gone: release: not found\n", errors.New("exit status 1")
+		}
+		return "release \"" + r.Name + "\" uninstalled\n", nil
+	}
+
+	noMatching := errors.New("err: no releases found that matches specified selector() and environment(default), in any helmfile")
+
+	executor := &fakeExecutor{
+		destroyErrorsBySelectors: map[string]error{
+			"name=renamed,namespace=kube-system": noMatching,
+			"name=gone,namespace=default":        noMatching,
+		},
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{
+		KeyManagedReleases: []interface{}{"default/app", "default/gone", "kube-system/renamed"},
+	}}
+	fs := &ReleaseSet{DestroyScope: DestroyScopeManaged, Selectors: []interface{}{"tier=backend"}}
+
+	destroyed, err := destroyManagedReleases(context.Background(), fs, d, buildDestroyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), executor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !destroyed {
+		t.Fatal("expected the managed releases to be destroyed")
+	}
+
+	wantDestroyed := []string{"name=app,namespace=default", "name=gone,namespace=default", "name=renamed,namespace=kube-system"}
+	if !reflect.DeepEqual(executor.destroyedSelectors, wantDestroyed) {
+		t.Errorf("expected selector-scoped destroys of only the managed releases:\nwant: %v\ngot:  %v", wantDestroyed, executor.destroyedSelectors)
+	}
+
+	wantUninstalled := []string{"default/gone", "kube-system/renamed"}
+	if !reflect.DeepEqual(uninstalled, wantUninstalled) {
+		t.Errorf("expected the releases not in the content to be uninstalled with helm:\nwant: %v\ngot:  %v", wantUninstalled, uninstalled)
+	}
+
+	if fs.nothingToDestroy {
+		t.Error("expected the destroy to be told apart from the one finding no releases")
+	}
+}
+
+func TestDestroyManagedReleasesNothingToDestroy(t *testing.T) {
+	uninstall := uninstallRelease
+	t.Cleanup(func() { uninstallRelease = uninstall })
+
+	uninstallRelease = func(ctx context.Context, fs *ReleaseSet, r listedRelease) (string, error) {
+		return "Error: uninstall: Release not loaded: " + r.Name + ": release: not found\n", errors.New("exit status 1")
+	}
+
+	noMatching := errors.New("err: no releases found that matches specified selector() and environment(default), in any helmfile")
+
+	executor := &fakeExecutor{
+		destroyErrorsBySelectors: map[string]error{
+			"name=app,namespace=default":      noMatching,
+			"name=frontend,namespace=default": noMatching,
+		},
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{
+		KeyManagedReleases: []interface{}{"default/app", "default/frontend"},
+	}}
+	fs := &ReleaseSet{DestroyScope: DestroyScopeManaged}
+
+	if _, err := destroyManagedReleases(context.Background(), fs, d, buildDestroyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), executor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !fs.nothingToDestroy {
+		t.Error("expected the destroy to tell that none of the managed releases were left")
+	}
+}
+
+func TestDestroyManagedReleasesFailure(t *testing.T) {
+	executor := &fakeExecutor{
+		destroyErrorsBySelectors: map[string]error{
+			"name=app,namespace=default": errors.New("timed out waiting for the condition"),
+		},
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{
+		KeyManagedReleases: []interface{}{"default/app", "default/frontend"},
+	}}
+	fs := &ReleaseSet{DestroyScope: DestroyScopeManaged}
+
+	_, err := destroyManagedReleases(context.Background(), fs, d, buildDestroyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), executor)
+	if err == nil || !strings.Contains(err.Error(), "default/app: timed out waiting for the condition") {
+		t.Fatalf("expected the failed release in the error, got %v", err)
+	}
+
+	if len(executor.destroyedSelectors) != 2 {
+		t.Errorf("expected the remaining releases to be destroyed after the failure, got %v", executor.destroyedSelectors)
+	}
+}
+
+func TestDestroyManagedReleasesWithoutInventory(t *testing.T) {
+	executor := &fakeExecutor{}
+
+	// Resources created before managed_releases was added have no inventory in the state
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	fs := &ReleaseSet{DestroyScope: DestroyScopeManaged}
+
+	destroyed, err := destroyManagedReleases(context.Background(), fs, d, buildDestroyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), executor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if destroyed {
+		t.Error("expected to fall back to destroying with the selectors")
+	}
+	if len(executor.destroyedSelectors) != 0 {
+		t.Errorf("expected no destroy, got %v", executor.destroyedSelectors)
+	}
+}
+
+func TestParseReleaseID(t *testing.T) {
+	if got := parseReleaseID("monitoring/metrics-exporter"); got != (listedRelease{Name: "metrics-exporter", Namespace: "monitoring"}) {
+		t.Errorf("unexpected release: %+v", got)
+	}
+
+	if got := parseReleaseID("app"); got != (listedRelease{Name: "app"}) {
+		t.Errorf("unexpected release: %+v", got)
+	}
+}
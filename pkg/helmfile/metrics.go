@@ -0,0 +1,295 @@
+package helmfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	resourceTypeReleaseSet = "helmfile_release_set"
+	resourceTypeRelease    = "helmfile_release"
+
+	operationCreate = "create"
+	operationUpdate = "update"
+	operationDelete = "delete"
+	operationDiff   = "diff"
+
+	operationResultSuccess          = "success"
+	operationResultError            = "error"
+	operationResultNothingToDestroy = "nothing_to_destroy"
+
+	metricsFileHeader = "# Metrics of the operations of terraform-provider-helmfile in the node_exporter textfile format.\n" +
+		"# Each operation of a resource updates the samples labeled with it.\n"
+)
+
+// metricFamily describes a metric in the metrics file. Families are written in this order.
+type metricFamily struct {
+	name string
+	typ  string
+	help string
+}
+
+var (
+	metricOperationsTotal = metricFamily{
+		name: "helmfile_provider_operations_total",
+		typ:  "counter",
+		help: "Number of operations run on the resource",
+	}
+	metricOperationDurationSeconds = metricFamily{
+		name: "helmfile_provider_operation_duration_seconds",
+		typ:  "gauge",
+		help: "Duration of the last operation on the resource",
+	}
+	metricOperationTimestampSeconds = metricFamily{
+		name: "helmfile_provider_operation_timestamp_seconds",
+		typ:  "gauge",
+		help: "Unix time at which the last operation on the resource finished",
+	}
+	metricReleasesChanged = metricFamily{
+		name: "helmfile_provider_releases_changed",
+		typ:  "gauge",
+		help: "Number of releases with changes in the diff of the last operation on the resource",
+	}
+	metricReleasesFailed = metricFamily{
+		name: "helmfile_provider_releases_failed",
+		typ:  "gauge",
+		help: "Number of releases that failed in the last operation on the resource",
+	}
+	metricDiffOutputBytes = metricFamily{
+		name: "helmfile_provider_diff_output_bytes",
+		typ:  "gauge",
+		help: "Size of diff_output after the last operation on the resource",
+	}
+
+	metricFamilies = []metricFamily{
+		metricOperationsTotal,
+		metricOperationDurationSeconds,
+		metricOperationTimestampSeconds,
+		metricReleasesChanged,
+		metricReleasesFailed,
+		metricDiffOutputBytes,
+	}
+)
+
+// operationMetrics records the metrics of an operation on a resource to the provider's metrics_file.
+//
+// The plugin SDK doesn't tell the provider the address of the resource, so resources are identified
+// by their type and ID. The ID is empty on the plan of a resource that isn't created yet.
+type operationMetrics struct {
+	path         string
+	resourceType string
+	operation    string
+	id           string
+	start        time.Time
+
+	// result overrides the result of the operation that succeeded, like operationResultNothingToDestroy
+	result string
+}
+
+// newOperationMetrics starts measuring an operation. It is no-op when metrics_file is not set.
+func newOperationMetrics(meta interface{}, resourceType, operation string, d ResourceRead) *operationMetrics {
+	m := &operationMetrics{
+		resourceType: resourceType,
+		operation:    operation,
+		id:           d.Id(),
+		start:        time.Now(),
+	}
+
+	if p, ok := meta.(*ProviderInstance); ok {
+		m.path = p.MetricsFile
+	}
+
+	return m
+}
+
+// setResult sets the result that the operation is recorded with when it succeeds
+func (m *operationMetrics) setResult(result string) {
+	m.result = result
+}
+
+// record writes the metrics of the finished operation. It is meant to be deferred with the operation's named error result.
+// Failing to write the metrics only logs a warning, as it must never fail the operation.
+func (m *operationMetrics) record(d ResourceRead, opErr *error) {
+	if m.path == "" {
+		return
+	}
+
+	// The ID is set at the end of create and cleared at the end of delete
+	id := d.Id()
+	if id == "" {
+		id = m.id
+	}
+
+	diff := recordedOutput(d, KeyDiffOutput)
+	applyOutput := recordedOutput(d, KeyApplyOutput)
+
+	result := operationResultSuccess
+	if opErr != nil && *opErr != nil {
+		result = operationResultError
+	} else if m.result != "" {
+		result = m.result
+	}
+
+	now := time.Now()
+
+	labels := formatMetricLabels([][2]string{
+		{"resource_type", m.resourceType},
+		{"id", id},
+		{"operation", m.operation},
+	})
+
+	resourceLabels := formatMetricLabels([][2]string{
+		{"resource_type", m.resourceType},
+		{"id", id},
+	})
+
+	update := func(samples metricSamples) {
+		counterLabels := formatMetricLabels([][2]string{
+			{"resource_type", m.resourceType},
+			{"id", id},
+			{"operation", m.operation},
+			{"result", result},
+		})
+
+		samples.add(metricOperationsTotal.name, counterLabels, 1)
+		samples.set(metricOperationDurationSeconds.name, labels, now.Sub(m.start).Seconds())
+		samples.set(metricOperationTimestampSeconds.name, labels, float64(now.Unix()))
+		samples.set(metricReleasesChanged.name, labels, float64(countChangedReleases(diff)))
+		samples.set(metricReleasesFailed.name, labels, float64(countFailedReleases(applyOutput)))
+		samples.set(metricDiffOutputBytes.name, resourceLabels, float64(len(diff)))
+	}
+
+	if err := updateMetricsFile(m.path, update); err != nil {
+		logf("Warning: failed to write metrics of %s on %s.%s to %s: %v", m.operation, m.resourceType, id, m.path, err)
+	}
+}
+
+// metricSamples maps the metric name to its samples' values keyed by the formatted labels
+type metricSamples map[string]map[string]float64
+
+func (s metricSamples) set(name, labels string, v float64) {
+	if s[name] == nil {
+		s[name] = map[string]float64{}
+	}
+	s[name][labels] = v
+}
+
+func (s metricSamples) add(name, labels string, v float64) {
+	if s[name] == nil {
+		s[name] = map[string]float64{}
+	}
+	s[name][labels] += v
+}
+
+// updateMetricsFile updates the samples in the metrics file under an exclusive lock, creating the file when missing.
+//
+// The file is rewritten rather than appended to, as the textfile format requires the samples of a metric to be grouped
+// and unique. The lock serializes the concurrent resources of this and any other provider process.
+func updateMetricsFile(path string, update func(metricSamples)) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking: %w", err)
+	}
+	defer unlockFile(f)
+
+	samples, err := parseMetrics(f)
+	if err != nil {
+		return fmt.Errorf("parsing: %w", err)
+	}
+
+	update(samples)
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, formatMetrics(samples)); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// parseMetrics reads the samples from a metrics file written by formatMetrics
+func parseMetrics(r io.Reader) (metricSamples, error) {
+	samples := metricSamples{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		i := strings.LastIndex(l, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid sample %q", l)
+		}
+
+		v, err := strconv.ParseFloat(l[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in sample %q: %w", l, err)
+		}
+
+		series := l[:i]
+		name, labels := series, ""
+		if j := strings.Index(series, "{"); j >= 0 {
+			name, labels = series[:j], series[j:]
+		}
+
+		samples.set(name, labels, v)
+	}
+
+	return samples, scanner.Err()
+}
+
+// formatMetrics renders the samples in the textfile format with the standard header
+func formatMetrics(samples metricSamples) string {
+	var b strings.Builder
+
+	b.WriteString(metricsFileHeader)
+
+	for _, f := range metricFamilies {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.typ)
+
+		series := samples[f.name]
+
+		labels := make([]string, 0, len(series))
+		for l := range series {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+
+		for _, l := range labels {
+			fmt.Fprintf(&b, "%s%s %s\n", f.name, l, strconv.FormatFloat(series[l], 'g', -1, 64))
+		}
+	}
+
+	return b.String()
+}
+
+// formatMetricLabels formats the label pairs like {k1="v1",k2="v2"}
+func formatMetricLabels(pairs [][2]string) string {
+	var kvs []string
+	for _, p := range pairs {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", p[0], strconv.Quote(p[1])))
+	}
+
+	return "{" + strings.Join(kvs, ",") + "}"
+}
@@ -0,0 +1,83 @@
+package helmfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOperationMetricsRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "helmfile.prom")
+	p := &ProviderInstance{MetricsFile: path}
+
+	diff := `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+`
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			d := &ResourceReadWriteEmbedded{m: map[string]interface{}{KeyDiffOutput: diff}}
+			var err error
+			newOperationMetrics(p, resourceTypeReleaseSet, operationDiff, d).record(d, &err)
+		}()
+	}
+	wg.Wait()
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	err := errors.New("apply failed")
+	newOperationMetrics(p, resourceTypeReleaseSet, operationCreate, d).record(d, &err)
+
+	// The error wins over the result set by the operation
+	m := newOperationMetrics(p, resourceTypeReleaseSet, operationDelete, d)
+	m.setResult(operationResultNothingToDestroy)
+	var deleteErr error
+	m.record(d, &deleteErr)
+	m.record(d, &err)
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(bs)
+
+	if !strings.HasPrefix(content, metricsFileHeader) {
+		t.Errorf("expected the standard header, got:\n%s", content)
+	}
+
+	for _, want := range []string{
+		`helmfile_provider_operations_total{resource_type="helmfile_release_set",id="",operation="diff",result="success"} 10`,
+		`helmfile_provider_operations_total{resource_type="helmfile_release_set",id="",operation="create",result="error"} 1`,
+		`helmfile_provider_operations_total{resource_type="helmfile_release_set",id="",operation="delete",result="nothing_to_destroy"} 1`,
+		`helmfile_provider_operations_total{resource_type="helmfile_release_set",id="",operation="delete",result="error"} 1`,
+		`helmfile_provider_releases_changed{resource_type="helmfile_release_set",id="",operation="diff"} 1`,
+		`helmfile_provider_diff_output_bytes{resource_type="helmfile_release_set",id=""} 0`,
+		"# TYPE helmfile_provider_operations_total counter",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("expected %q in the metrics file, got:\n%s", want, content)
+		}
+	}
+
+	if n := strings.Count(content, "# HELP helmfile_provider_operations_total"); n != 1 {
+		t.Errorf("expected the metric to be described once, got %d times", n)
+	}
+}
+
+func TestOperationMetricsRecordFailure(t *testing.T) {
+	p := &ProviderInstance{MetricsFile: filepath.Join(t.TempDir(), "missing", "helmfile.prom")}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	var err error
+	newOperationMetrics(p, resourceTypeRelease, operationUpdate, d).record(d, &err)
+
+	if err != nil {
+		t.Errorf("expected the operation error to be left as is, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package helmfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// missingCRDDiffNotePrefix starts the lines added to diff_output for the releases whose diff was skipped due to missing CRDs
+const missingCRDDiffNotePrefix = "diff skipped for release "
+
+var (
+	// missingCRDPattern matches the error of helm-diff, and of helm's server-side dry-run used by helm-diff --dry-run=server,
+	// when the release contains a custom resource whose CRD isn't installed yet
+	missingCRDPattern = regexp.MustCompile(`no matches for kind "([^"]+)" in version "([^"]+)"`)
+
+	// helmArgPattern matches the lines like `  4: myapp (5 bytes)` in the ARGS section of the helmfile's error on helm failures
+	helmArgPattern = regexp.MustCompile(`^\s*\d+: (.*) \(\d+ bytes\)$`)
+)
+
+// missingCRD is a release whose diff failed because it uses a kind whose CRD isn't installed yet
+type missingCRD struct {
+	// Release is the name of the release, or empty when it couldn't be determined from the error
+	Release string
+
+	// Cause is the "no matches for kind" message from the error
+	Cause string
+}
+
+// missingCRDDiffReleases returns the releases whose diff failed due to missing CRDs.
+// It returns false when the error is not solely due to missing CRDs, so that any other failure is still reported.
+func missingCRDDiffReleases(errMsg string) ([]missingCRD, bool) {
+	// helmfile reports each failed helm command as `command "/path/to/helm" exited with non-zero status:` followed by its details
+	blocks := strings.Split(errMsg, "exited with non-zero status:")
+
+	if len(blocks) == 1 {
+		m := missingCRDPattern.FindString(errMsg)
+		if m == "" {
+			return nil, false
+		}
+
+		return []missingCRD{{Cause: m}}, true
+	}
+
+	var missing []missingCRD
+
+	for _, b := range blocks[1:] {
+		m := missingCRDPattern.FindString(b)
+		if m == "" {
+			return nil, false
+		}
+
+		missing = append(missing, missingCRD{Release: diffReleaseName(b), Cause: m})
+	}
+
+	return missing, true
+}
+
+// diffReleaseName returns the release name from the ARGS of a failed `helm diff upgrade --allow-unreleased NAME CHART` command
+func diffReleaseName(block string) string {
+	var args []string
+	for _, l := range strings.Split(block, "\n") {
+		if m := helmArgPattern.FindStringSubmatch(l); m != nil {
+			args = append(args, m[1])
+		}
+	}
+
+	for i, a := range args {
+		if a == "upgrade" && i > 0 && args[i-1] == "diff" {
+			for _, n := range args[i+1:] {
+				if !strings.HasPrefix(n, "-") {
+					return n
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// missingCRDDiffNote renders the diff_output noting the releases whose diff was skipped
+func missingCRDDiffNote(missing []missingCRD) string {
+	var b strings.Builder
+
+	for _, m := range missing {
+		release := m.Release
+		if release == "" {
+			release = "(unknown)"
+		}
+
+		fmt.Fprintf(&b, "%s%s: CRD not yet installed (%s)\n", missingCRDDiffNotePrefix, release, m.Cause)
+	}
+
+	return b.String()
+}
+
+// countMissingCRDDiffNotes counts the releases noted by missingCRDDiffNote
+func countMissingCRDDiffNotes(diff string) int {
+	var count int
+
+	for _, l := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(l, missingCRDDiffNotePrefix) {
+			count++
+		}
+	}
+
+	return count
+}
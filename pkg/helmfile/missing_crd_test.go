@@ -0,0 +1,171 @@
+package helmfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+func TestMissingCRDDiffReleases(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []missingCRD
+	}{
+		{
+			fixture: "helm_diff.txt",
+			want: []missingCRD{
+				{Release: "cert-issuers", Cause: `no matches for kind "ClusterIssuer" in version "cert-manager.io/v1"`},
+			},
+		},
+		{
+			fixture: "server_dry_run.txt",
+			want: []missingCRD{
+				{Release: "cert-issuers", Cause: `no matches for kind "ClusterIssuer" in version "cert-manager.io/v1"`},
+				{Release: "monitors", Cause: `no matches for kind "ServiceMonitor" in version "monitoring.coreos.com/v1"`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			bs, err := ioutil.ReadFile(filepath.Join("testdata", "missing_crd", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := missingCRDDiffReleases(string(bs))
+			if !ok {
+				t.Fatal("expected the error to be classified as missing CRDs")
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected releases: want %v, got %v", tt.want, got)
+			}
+
+			note := missingCRDDiffNote(got)
+			if !strings.HasPrefix(note, "diff skipped for release cert-issuers: CRD not yet installed") {
+				t.Errorf("unexpected note: %s", note)
+			}
+
+			if s := diffSummary(note); !s.HasChanges || s.ChangedReleaseCount != len(tt.want) {
+				t.Errorf("expected the skipped releases to be counted as changed, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestMissingCRDDiffReleasesOtherErrors(t *testing.T) {
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "missing_crd", "helm_diff.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another release failing for another reason must still fail the plan
+	mixed := string(bs) + `
+err 1: command "/usr/local/bin/helm" exited with non-zero status:
+
+STDERR:
+  Error: Kubernetes cluster unreachable: connection refused
+`
+
+	for name, msg := range map[string]string{
+		"mixed":     mixed,
+		"unrelated": "Error: Kubernetes cluster unreachable: connection refused",
+	} {
+		if _, ok := missingCRDDiffReleases(msg); ok {
+			t.Errorf("%s: expected the error not to be classified as missing CRDs", name)
+		}
+	}
+
+	if _, ok := missingCRDs(&ReleaseSet{FailOnMissingCRDDiff: true}, errors.New(string(bs))); ok {
+		t.Error("expected fail_on_missing_crd_diff to restore the strict behavior")
+	}
+
+	if _, ok := missingCRDs(&ReleaseSet{}, nil); ok {
+		t.Error("expected no missing CRDs without an error")
+	}
+}
+
+// fakeMissingCRDHelmfile diffs the cert-manager release, and fails the diff of cert-issuers as its CRD isn't installed
+const fakeMissingCRDHelmfile = `#!/bin/sh
+case " $* " in
+  *" diff "*) ;;
+  *) exit 0 ;;
+esac
+cat <<'DIFF'
+Comparing release=cert-manager, chart=jetstack/cert-manager, namespace=cert-manager
+cert-manager, cert-manager, Deployment (apps) has been added:
++ apiVersion: apps/v1
++ kind: Deployment
+
+Comparing release=cert-issuers, chart=./charts/cert-issuers, namespace=cert-manager
+DIFF
+cat "$(dirname "$0")/helm_diff.txt" >&2
+exit 1
+`
+
+func TestDiffReleaseSetWithMissingCRD(t *testing.T) {
+	fixture, err := ioutil.ReadFile(filepath.Join("testdata", "missing_crd", "helm_diff.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The diff file is written relative to the Terraform root module
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	for name, content := range map[string]string{
+		"helmfile":      fakeMissingCRDHelmfile,
+		"helm_diff.txt": string(fixture),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := &ReleaseSet{
+		Bin:              filepath.Join(dir, "helmfile"),
+		Content:          "releases: []\n",
+		WorkingDirectory: dir,
+		Kubeconfig:       writeTestKubeconfig(t),
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	diff, err := DiffReleaseSet(&sdk.Context{}, fs, d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{
+		"cert-manager, cert-manager, Deployment (apps) has been added:\n+ apiVersion: apps/v1\n",
+		"diff skipped for release cert-issuers: CRD not yet installed (no matches for kind \"ClusterIssuer\" in version \"cert-manager.io/v1\")\n",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("expected the diff to contain %q, got:\n%s", want, diff)
+		}
+	}
+
+	if got := d.Get(KeyDiffOutput); got != diff {
+		t.Errorf("expected diff_output to be the diff, got:\n%v", got)
+	}
+
+	if s := diffSummary(diff); s.ChangedReleaseCount != 2 {
+		t.Errorf("expected the changed and the skipped releases to be counted, got %+v", s)
+	}
+}
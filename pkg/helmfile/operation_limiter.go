@@ -0,0 +1,106 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+)
+
+// operationLimiter limits how many helmfile operations the provider runs at once across the resources.
+// A nil limiter doesn't limit them.
+type operationLimiter struct {
+	slots chan struct{}
+}
+
+// newOperationLimiter returns the limiter of max_parallel_operations, which is nil when max is 0
+func newOperationLimiter(max int) *operationLimiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return &operationLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free slot until the context is done, returning the func that frees it
+func (l *operationLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	default:
+	}
+
+	logf("Waiting for one of the %d helmfile operations to finish, as limited by %s", cap(l.slots), KeyMaxParallelOperations)
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for %s: %w", KeyMaxParallelOperations, ctx.Err())
+	}
+}
+
+// limitedExecutor holds a slot of the limiter while each operation of the executor runs
+type limitedExecutor struct {
+	HelmfileExecutor
+
+	limiter *operationLimiter
+}
+
+// limitExecutor wraps the executor with a limitedExecutor unless the limiter is nil
+func limitExecutor(executor HelmfileExecutor, limiter *operationLimiter) HelmfileExecutor {
+	if limiter == nil {
+		return executor
+	}
+
+	return &limitedExecutor{HelmfileExecutor: executor, limiter: limiter}
+}
+
+// run runs the operation while holding a slot
+func (e *limitedExecutor) run(ctx context.Context, op func() (*Result, error)) (*Result, error) {
+	release, err := e.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return op()
+}
+
+func (e *limitedExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Apply(ctx, opts) })
+}
+
+func (e *limitedExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Sync(ctx, opts) })
+}
+
+func (e *limitedExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Diff(ctx, opts) })
+}
+
+func (e *limitedExecutor) Template(ctx context.Context, opts *TemplateOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Template(ctx, opts) })
+}
+
+func (e *limitedExecutor) Destroy(ctx context.Context, opts *DestroyOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Destroy(ctx, opts) })
+}
+
+func (e *limitedExecutor) Build(ctx context.Context, opts *BuildOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Build(ctx, opts) })
+}
+
+func (e *limitedExecutor) PrintEnv(ctx context.Context, opts *PrintEnvOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.PrintEnv(ctx, opts) })
+}
+
+func (e *limitedExecutor) List(ctx context.Context, opts *ListOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.List(ctx, opts) })
+}
+
+func (e *limitedExecutor) Lint(ctx context.Context, opts *LintOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Lint(ctx, opts) })
+}
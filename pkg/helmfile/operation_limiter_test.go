@@ -0,0 +1,172 @@
+package helmfile
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+func TestDefaultConcurrency(t *testing.T) {
+	provider := &ProviderInstance{DefaultConcurrency: 2}
+
+	defaulted := &ReleaseSet{}
+	provider.applyDefaults(defaulted)
+
+	overridden := &ReleaseSet{Concurrency: 5}
+	provider.applyDefaults(overridden)
+
+	for _, tt := range []struct {
+		fs   *ReleaseSet
+		want int
+	}{
+		{fs: defaulted, want: 2},
+		{fs: overridden, want: 5},
+		{fs: &ReleaseSet{}, want: 0},
+	} {
+		got := []int{
+			buildApplyOptions(tt.fs, &helmfileFiles{Path: "helmfile.yaml"}).Concurrency,
+			buildDiffOptions(tt.fs, &helmfileFiles{Path: "helmfile.yaml"}, 0).Concurrency,
+			buildTemplateOptions(tt.fs, &helmfileFiles{Path: "helmfile.yaml"}).Concurrency,
+			buildDestroyOptions(tt.fs, &helmfileFiles{Path: "helmfile.yaml"}).Concurrency,
+		}
+		for _, c := range got {
+			if c != tt.want {
+				t.Errorf("expected the concurrency of %+v to be %d, got %v", tt.fs.Concurrency, tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+// blockingExecutor blocks each Apply until unblock is closed, telling started when it starts
+type blockingExecutor struct {
+	*fakeExecutor
+
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (e *blockingExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	e.started <- struct{}{}
+	<-e.unblock
+
+	return &Result{}, nil
+}
+
+func TestMaxParallelOperations(t *testing.T) {
+	inner := &blockingExecutor{fakeExecutor: &fakeExecutor{}, started: make(chan struct{}, 2), unblock: make(chan struct{})}
+	provider := &ProviderInstance{ExecutorName: ExecutorLibrary, Executor: inner, Operations: newOperationLimiter(1)}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		// The release sets are in different working directories, so that only the limiter makes one wait for the other
+		fs := &ReleaseSet{WorkingDirectory: t.TempDir()}
+
+		go func() {
+			_, err := provider.executorFor(fs).Apply(context.Background(), &ApplyOptions{})
+			errs <- err
+		}()
+	}
+
+	<-inner.started
+
+	select {
+	case <-inner.started:
+		t.Fatal("expected the second apply to wait while the first holds the only slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(inner.unblock)
+
+	select {
+	case <-inner.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second apply to start once the first finished")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestOperationLimiterCanceled(t *testing.T) {
+	limiter := newOperationLimiter(1)
+
+	release, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := limiter.acquire(ctx); err == nil {
+		t.Error("expected waiting for a slot to stop when the context is done")
+	}
+
+	if newOperationLimiter(0) != nil {
+		t.Error("expected max_parallel_operations = 0 not to limit the operations")
+	}
+}
+
+func TestMaxParallelOperationsLegacyCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	bin := filepath.Join(dir, "helmfile")
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\necho \"$@\" >> \"$(dirname \"$0\")/calls\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &ProviderInstance{Operations: newOperationLimiter(1)}
+
+	fs := &ReleaseSet{
+		Bin:              bin,
+		Content:          "releases: []\n",
+		WorkingDirectory: dir,
+		Kubeconfig:       writeTestKubeconfig(t),
+	}
+	provider.applyDefaults(fs)
+
+	for name, run := range map[string]func() error{
+		"diff": func() error {
+			_, err := runDiff(&sdk.Context{}, fs, DiffConfig{})
+			return err
+		},
+		"template": func() error {
+			_, err := runTemplate(&sdk.Context{}, fs)
+			return err
+		},
+	} {
+		release, err := provider.Operations.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errs := make(chan error, 1)
+		go func() { errs <- run() }()
+
+		select {
+		case err := <-errs:
+			t.Fatalf("%s: expected the command to wait while another operation holds the only slot, got %v", name, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		release()
+
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: expected the command to run once the slot was freed", name)
+		}
+	}
+}
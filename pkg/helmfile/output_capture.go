@@ -2,48 +2,289 @@ package helmfile
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
 	"sync"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// OutputCapture captures log output from helmfile operations
+// OutputCapture captures log output from helmfile operations.
+//
+// Writes are line-buffered per writer, so that partial lines written concurrently,
+// like the ones of helm processes run for different releases, never interleave mid-line.
+// Only whole lines are committed, in the order they are completed.
 type OutputCapture struct {
-	buffer *bytes.Buffer
-	mutex  sync.Mutex
+	lines   []capturedLine
+	writers []*lineWriter
+	shared  *lineWriter
+	mutex   sync.Mutex
+
+	// live forwards each line to the debug log as it is committed, prefixed with prefix unless it is empty
+	live   bool
+	prefix string
+
+	// logf writes the live output to the debug log
+	logf func(format string, args ...interface{})
+}
+
+// capturedLine is a whole line of the output, tagged with the release it is for if known
+type capturedLine struct {
+	release string
+	text    string
 }
 
 // NewOutputCapture creates a new output capture
 func NewOutputCapture() *OutputCapture {
-	return &OutputCapture{
-		buffer: &bytes.Buffer{},
-	}
+	o := &OutputCapture{logf: logf}
+	o.shared = o.newLineWriter("")
+	return o
 }
 
-// Write implements io.Writer
+// Write implements io.Writer, buffering partial lines in a buffer shared by all the callers
 func (o *OutputCapture) Write(p []byte) (n int, err error) {
+	return o.shared.Write(p)
+}
+
+// Writer returns a writer with its own line buffer, for a goroutine or a process writing partial lines.
+// Its lines are tagged with the release unless it is empty, and grouped under a header for the release in String.
+func (o *OutputCapture) Writer(release string) io.Writer {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
-	return o.buffer.Write(p)
+	return o.newLineWriter(release)
+}
+
+func (o *OutputCapture) newLineWriter(release string) *lineWriter {
+	w := &lineWriter{capture: o, release: release}
+	o.writers = append(o.writers, w)
+	return w
+}
+
+// lineWriter commits the whole lines written to it to the capture, keeping the partial line until it is completed
+type lineWriter struct {
+	capture *OutputCapture
+	release string
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.capture.mutex.Lock()
+	defer w.capture.mutex.Unlock()
+
+	w.partial = w.capture.commitLines(w.release, append(w.partial, p...))
+
+	return len(p), nil
+}
+
+// StreamToLog makes the capture forward each line to the debug log as it is committed, so that the progress of long
+// operations shows up with TF_LOG before they return. The lines are prefixed with the prefix unless it is empty, like
+// the release set they are for. The captured output is unaffected.
+func (o *OutputCapture) StreamToLog(prefix string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.live = true
+	o.prefix = prefix
+}
+
+// commitLines commits the whole lines of buf, returning the partial line left. The caller must hold the mutex.
+// The values of sensitive_environment_variables are masked in each line, which is whole so that no value is split
+// across the writes.
+func (o *OutputCapture) commitLines(release string, buf []byte) []byte {
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		text := redactSensitiveValues(string(buf[:i]))
+		o.lines = append(o.lines, capturedLine{release: release, text: text})
+		if o.live {
+			logLiveOutput(o.logf, o.prefix, release, text)
+		}
+		buf = buf[i+1:]
+	}
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	return append([]byte(nil), buf...)
 }
 
-// String returns the captured output
+// String returns the captured output.
+// Partial lines that are not yet terminated come last.
+// When any line is tagged with a release, the lines of each release are grouped under a header,
+// after the untagged lines and in the order the releases first wrote.
 func (o *OutputCapture) String() string {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
-	return o.buffer.String()
+
+	lines := append([]capturedLine(nil), o.lines...)
+
+	for _, w := range o.writers {
+		if len(w.partial) > 0 {
+			lines = append(lines, capturedLine{release: w.release, text: redactSensitiveValues(string(w.partial))})
+		}
+	}
+
+	var (
+		untagged []string
+		releases []string
+		tagged   = map[string][]string{}
+	)
+
+	for _, l := range lines {
+		if l.release == "" {
+			untagged = append(untagged, l.text)
+			continue
+		}
+		if _, ok := tagged[l.release]; !ok {
+			releases = append(releases, l.release)
+		}
+		tagged[l.release] = append(tagged[l.release], l.text)
+	}
+
+	var buf strings.Builder
+
+	for _, l := range untagged {
+		buf.WriteString(l + "\n")
+	}
+
+	for _, r := range releases {
+		buf.WriteString(fmt.Sprintf("=== release %s ===\n", r))
+		for _, l := range tagged[r] {
+			buf.WriteString(l + "\n")
+		}
+	}
+
+	return buf.String()
 }
 
 // Reset clears the captured output
 func (o *OutputCapture) Reset() {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
-	o.buffer.Reset()
+	o.lines = nil
+	for _, w := range o.writers {
+		w.partial = nil
+	}
+}
+
+// outputStreams captures the stdout and stderr of a command separately, along with the combined output in the order
+// it was written
+type outputStreams struct {
+	mutex    sync.Mutex
+	combined bytes.Buffer
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+}
+
+// attach makes the command write its stdout and stderr to the streams
+func (s *outputStreams) attach(cmd *exec.Cmd) {
+	cmd.Stdout = &streamWriter{streams: s, buf: &s.stdout}
+	cmd.Stderr = &streamWriter{streams: s, buf: &s.stderr}
+}
+
+// streamWriter writes a stream of a command to its own buffer and to the combined output
+type streamWriter struct {
+	streams *outputStreams
+	buf     *bytes.Buffer
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.streams.mutex.Lock()
+	defer w.streams.mutex.Unlock()
+
+	w.buf.Write(p)
+	w.streams.combined.Write(p)
+
+	return len(p), nil
 }
 
-// CreateCaptureLogger creates a zap logger that captures output
-func CreateCaptureLogger(capture *OutputCapture) *zap.SugaredLogger {
+// liveOutputWriter forwards the whole lines written to it to the debug log, keeping the partial line until it is
+// completed. It is for a single stream of a process, like its stdout.
+type liveOutputWriter struct {
+	prefix  string
+	partial []byte
+}
+
+func (w *liveOutputWriter) Write(p []byte) (int, error) {
+	buf := append(w.partial, p...)
+
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		logLiveOutput(logf, w.prefix, "", string(buf[:i]))
+		buf = buf[i+1:]
+	}
+
+	w.partial = append([]byte(nil), buf...)
+
+	return len(p), nil
+}
+
+// Flush logs the partial line left once the process has exited
+func (w *liveOutputWriter) Flush() {
+	if len(w.partial) > 0 {
+		logLiveOutput(logf, w.prefix, "", string(w.partial))
+		w.partial = nil
+	}
+}
+
+// logLiveOutput logs a line of the output of a running operation with the log func, tagged with the release it is for
+// if known, and prefixed with the prefix unless it is empty
+func logLiveOutput(logf func(string, ...interface{}), prefix, release, line string) {
+	tag := "helmfile"
+	if release != "" {
+		tag = fmt.Sprintf("helmfile[%s]", release)
+	}
+
+	if prefix != "" {
+		logf("%s: %s: %s", prefix, tag, line)
+		return
+	}
+
+	logf("%s: %s", tag, line)
+}
+
+// KeyLogRelease is the logger field that tags the captured lines with the release they are for
+const KeyLogRelease = "release"
+
+const (
+	// LogLevelDebug captures the debug logs of helmfile, and dumps the AWS environment of apply to the debug log
+	LogLevelDebug = "debug"
+
+	// LogLevelInfo captures the info logs of helmfile and above
+	LogLevelInfo = "info"
+
+	// LogLevelWarn captures only the warnings and errors of helmfile
+	LogLevelWarn = "warn"
+)
+
+// captureLevel returns the zap level of the log level, which is info when it is empty
+func captureLevel(level string) zapcore.Level {
+	switch level {
+	case LogLevelDebug:
+		return zapcore.DebugLevel
+	case LogLevelWarn:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
+// commandOutputPattern matches the lines of the output of the commands run by helmfile, like helm and helm-diff, which
+// helmfile logs at the debug level prefixed with the command and the ID of the run, like "helm:aBcDe> "
+var commandOutputPattern = regexp.MustCompile(`^[^\s>]+> `)
+
+// CreateCaptureLogger creates a zap logger that captures the logs at the log level and above, along with the output
+// of the commands run by helmfile at any level, as it is the output of the operation rather than the logs
+func CreateCaptureLogger(capture *OutputCapture, level string) *zap.SugaredLogger {
 	// Create encoder config for plain text output
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "ts",
@@ -60,13 +301,83 @@ func CreateCaptureLogger(capture *OutputCapture) *zap.SugaredLogger {
 	}
 
 	// Create core that writes to our capture buffer
-	core := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(encoderConfig),
-		zapcore.AddSync(capture),
-		zapcore.DebugLevel, // Capture all levels
-	)
+	core := &captureCore{
+		// The command output is logged at the debug level, so the level is checked along with the message by Check
+		LevelEnabler: zapcore.DebugLevel,
+		level:        captureLevel(level),
+		encoder:      zapcore.NewConsoleEncoder(encoderConfig),
+		capture:      capture,
+	}
 
 	// Create logger
 	logger := zap.New(core)
 	return logger.Sugar()
 }
+
+// captureCore is a zapcore.Core that writes each entry to the capture,
+// tagged with the release from the release field of the logger or the entry when there is one
+type captureCore struct {
+	zapcore.LevelEnabler
+
+	level   zapcore.Level
+	encoder zapcore.Encoder
+	capture *OutputCapture
+	release string
+}
+
+func (c *captureCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &captureCore{
+		LevelEnabler: c.LevelEnabler,
+		level:        c.level,
+		encoder:      c.encoder.Clone(),
+		capture:      c.capture,
+		release:      c.release,
+	}
+
+	for _, f := range fields {
+		if f.Key == KeyLogRelease && f.Type == zapcore.StringType {
+			clone.release = f.String
+			continue
+		}
+		f.AddTo(clone.encoder)
+	}
+
+	return clone
+}
+
+func (c *captureCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level >= c.level || commandOutputPattern.MatchString(ent.Message) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *captureCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	release := c.release
+
+	var rest []zapcore.Field
+	for _, f := range fields {
+		if f.Key == KeyLogRelease && f.Type == zapcore.StringType {
+			release = f.String
+			continue
+		}
+		rest = append(rest, f)
+	}
+
+	buf, err := c.encoder.EncodeEntry(ent, rest)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	// Each entry is whole lines, so it is committed without going through a line buffer
+	c.capture.mutex.Lock()
+	defer c.capture.mutex.Unlock()
+	c.capture.commitLines(release, buf.Bytes())
+
+	return nil
+}
+
+func (c *captureCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package helmfile
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputCaptureConcurrentPartialLines(t *testing.T) {
+	const (
+		writers = 8
+		lines   = 200
+	)
+
+	capture := NewOutputCapture()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			out := capture.Writer("")
+
+			for i := 0; i < lines; i++ {
+				line := fmt.Sprintf("writer %d line %d\n", w, i)
+				// Write each line in chunks like a process writing partial lines
+				for len(line) > 0 {
+					n := 1 + (i+w)%5
+					if n > len(line) {
+						n = len(line)
+					}
+					out.Write([]byte(line[:n]))
+					line = line[n:]
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	next := map[int]int{}
+	for _, l := range strings.Split(strings.TrimSuffix(capture.String(), "\n"), "\n") {
+		var w, i int
+		if _, err := fmt.Sscanf(l, "writer %d line %d", &w, &i); err != nil || l != fmt.Sprintf("writer %d line %d", w, i) {
+			t.Fatalf("interleaved line %q", l)
+		}
+		if i != next[w] {
+			t.Fatalf("expected line %d of writer %d, got %q", next[w], w, l)
+		}
+		next[w]++
+	}
+
+	for w := 0; w < writers; w++ {
+		if next[w] != lines {
+			t.Errorf("expected %d lines of writer %d, got %d", lines, w, next[w])
+		}
+	}
+}
+
+func TestOutputCaptureReleases(t *testing.T) {
+	capture := NewOutputCapture()
+
+	a, b := capture.Writer("default/a"), capture.Writer("default/b")
+
+	capture.Write([]byte("building dependencies\n"))
+	b.Write([]byte("upgrading b\nwaiting"))
+	a.Write([]byte("upgrading a\n"))
+	b.Write([]byte(" for b\n"))
+	capture.Write([]byte("partial"))
+
+	want := `building dependencies
+partial
+=== release default/b ===
+upgrading b
+waiting for b
+=== release default/a ===
+upgrading a
+`
+
+	if got := capture.String(); got != want {
+		t.Errorf("unexpected output:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	capture.Reset()
+
+	if got := capture.String(); got != "" {
+		t.Errorf("expected no output after reset, got %q", got)
+	}
+}
+
+func TestCreateCaptureLoggerReleaseField(t *testing.T) {
+	capture := NewOutputCapture()
+	logger := CreateCaptureLogger(capture, LogLevelInfo)
+
+	logger.Info("preparing")
+	logger.With(KeyLogRelease, "default/myapp").Infow("upgrading", "chart", "sp/podinfo")
+	logger.Infow("done", KeyLogRelease, "default/other")
+
+	out := capture.String()
+
+	for _, want := range []string{
+		"preparing\n=== release default/myapp ===\n",
+		"upgrading\t{\"chart\": \"sp/podinfo\"}\n=== release default/other ===\n",
+		"done\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	if strings.Contains(out, `"release"`) {
+		t.Errorf("expected the release field to be extracted, got:\n%s", out)
+	}
+}
+
+func TestOutputCaptureStreamToLog(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	live := NewOutputCapture()
+	live.StreamToLog("")
+	quiet := NewOutputCapture()
+
+	for _, capture := range []*OutputCapture{live, quiet} {
+		capture.Write([]byte("Upgrading release=app"))
+		capture.Write([]byte(", chart=charts/app\n"))
+		capture.Writer("db").Write([]byte("Release \"db\" has been upgraded\n"))
+	}
+
+	if live.String() != quiet.String() {
+		t.Errorf("expected the captured output to be unaffected, got:\n%s\nwant:\n%s", live.String(), quiet.String())
+	}
+
+	for _, want := range []string{"helmfile: Upgrading release=app, chart=charts/app", `helmfile[db]: Release "db" has been upgraded`} {
+		if strings.Count(logs.String(), want) != 1 {
+			t.Errorf("expected %q to be logged once, got:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestOutputCaptureStreamToLogPrefix(t *testing.T) {
+	var logged []string
+
+	capture := NewOutputCapture()
+	capture.logf = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	capture.StreamToLog("release set myapp")
+
+	// Lines are logged once they are complete, never split mid-word
+	capture.Write([]byte("Upgrading rel"))
+	if len(logged) != 0 {
+		t.Fatalf("expected the partial line not to be logged, got %q", logged)
+	}
+
+	capture.Write([]byte("ease=app\nBuilding"))
+	capture.Writer("default/db").Write([]byte("Release \"db\" has been upgraded\n"))
+
+	want := []string{
+		"release set myapp: helmfile: Upgrading release=app",
+		`release set myapp: helmfile[default/db]: Release "db" has been upgraded`,
+	}
+	if !reflect.DeepEqual(logged, want) {
+		t.Errorf("expected the lines to be logged with the prefix as they are written:\nwant: %q\ngot:  %q", want, logged)
+	}
+
+	if !strings.Contains(capture.String(), "Upgrading release=app\nBuilding\n") {
+		t.Errorf("expected the captured output to be unaffected, got:\n%s", capture.String())
+	}
+
+	if got := liveOutputPrefix(&ReleaseSet{ID: "myapp"}); got != "release set myapp" {
+		t.Errorf("expected the live output to be prefixed with the release set, got %q", got)
+	}
+	if got := liveOutputPrefix(&ReleaseSet{}); got != "" {
+		t.Errorf("expected no prefix until the ID is known, got %q", got)
+	}
+}
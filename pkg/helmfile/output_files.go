@@ -0,0 +1,118 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// outputFileKeys are the attributes recording the path and the SHA-256 hash of the file each output is written to,
+// when store_outputs_in_state is false
+var outputFileKeys = map[string][2]string{
+	KeyDiffOutput:     {KeyDiffOutputFile, KeyDiffOutputSHA256},
+	KeyApplyOutput:    {KeyApplyOutputFile, KeyApplyOutputSHA256},
+	KeyTemplateOutput: {KeyTemplateOutputFile, KeyTemplateOutputSHA256},
+}
+
+// outputAttributes tells which attributes record the outputs of the release set
+type outputAttributes struct {
+	// Sensitive is true when the outputs are recorded in the sensitive attributes too
+	Sensitive bool
+
+	// Files is true when the outputs are written to files under output_path, and only their paths and hashes are recorded
+	Files bool
+}
+
+func outputAttributesOf(fs *ReleaseSet) outputAttributes {
+	return outputAttributes{
+		Sensitive: fs.SensitiveOutputs || fs.ShowSecrets,
+		Files:     outputsInFiles(fs),
+	}
+}
+
+// outputsInFiles returns true when the outputs are written to files under output_path instead of the state
+func outputsInFiles(fs *ReleaseSet) bool {
+	return fs.OutputPath != "" && !fs.StoreOutputsInState
+}
+
+// validateOutputFileOptions validates that output_path is set when the outputs are not stored in the state
+func validateOutputFileOptions(fs *ReleaseSet) error {
+	if !fs.StoreOutputsInState && fs.OutputPath == "" {
+		return fmt.Errorf("%s = false requires %s to be set", KeyStoreOutputsInState, KeyOutputPath)
+	}
+
+	return nil
+}
+
+// writeOutputFile writes the output to a file under output_path named after its SHA-256 hash, and records the path and
+// the hash in the attributes of the output. The file of the same output is never rewritten, so that the plan and the
+// apply of the same operation share it. Nothing is written for an empty output.
+func writeOutputFile(d ResourceReadWrite, fs *ReleaseSet, key, output string) error {
+	if output == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(output))
+	hash := hex.EncodeToString(sum[:])
+
+	path := filepath.Join(fs.OutputPath, fmt.Sprintf("%s-%s.txt", key, hash))
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(fs.OutputPath, 0755); err != nil {
+			return fmt.Errorf("creating %s: %w", KeyOutputPath, err)
+		}
+
+		// The file is renamed into place, so that a concurrent reader never sees it partially written
+		tmp, err := ioutil.TempFile(fs.OutputPath, "."+key+"-")
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", key, err)
+		}
+
+		_, err = tmp.WriteString(output)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("writing %s: %w", key, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+
+	keys := outputFileKeys[key]
+
+	d.Set(keys[0], path)
+	d.Set(keys[1], hash)
+
+	return nil
+}
+
+// recordedOutput returns the output as recorded by the last operation, from its file when it was written to output_path,
+// or from its sensitive attribute with sensitive_outputs
+func recordedOutput(d ResourceRead, key string) string {
+	if path, _ := d.Get(outputFileKeys[key][0]).(string); path != "" {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			logf("Warning: reading %s from %s: %v", key, path, err)
+			return ""
+		}
+
+		return string(bs)
+	}
+
+	if sensitive, _ := d.Get(KeySensitiveOutputs).(bool); sensitive {
+		output, _ := d.Get(sensitiveOutputKeys[key]).(string)
+		return output
+	}
+
+	output, _ := d.Get(key).(string)
+
+	return output
+}
@@ -0,0 +1,192 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func TestWriteOutputFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outputs")
+	fs := &ReleaseSet{OutputPath: dir}
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	output := "UPDATED RELEASES:\nNAME   CHART        VERSION\napp    charts/app   0.1.0\n"
+
+	if err := writeOutputFile(d, fs, KeyApplyOutput, output); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(output))
+	hash := hex.EncodeToString(sum[:])
+
+	if got := d.Get(KeyApplyOutputSHA256); got != hash {
+		t.Errorf("expected apply_output_sha256 %s, got %v", hash, got)
+	}
+
+	path := filepath.Join(dir, "apply_output-"+hash+".txt")
+	if got := d.Get(KeyApplyOutputFile); got != path {
+		t.Errorf("expected apply_output_file %s, got %v", path, got)
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bs) != output {
+		t.Errorf("unexpected contents of %s: %q", path, bs)
+	}
+
+	// The file of the same output is reused, and no temporary file is left
+	if err := writeOutputFile(d, fs, KeyApplyOutput, output); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only %s in %s, got %d files", filepath.Base(path), dir, len(files))
+	}
+
+	empty := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	if err := writeOutputFile(empty, fs, KeyDiffOutput, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(empty.m) != 0 {
+		t.Errorf("expected nothing recorded for no output, got %v", empty.m)
+	}
+}
+
+func TestSetOutputsToFiles(t *testing.T) {
+	fs := &ReleaseSet{OutputPath: t.TempDir(), SensitiveOutputs: true}
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	// The outputs too large for the state are written as is
+	output := strings.Repeat("x", DefaultMaxOutputLen+1)
+
+	if err := setApplyOutput(d, fs, output); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setTemplateOutput(d, fs, output); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for k := range d.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	want := []string{KeyApplyOutputFile, KeyApplyOutputSHA256, KeyTemplateOutputFile, KeyTemplateOutputSHA256}
+	sort.Strings(want)
+
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("expected only the paths and the hashes of the outputs to be recorded, got %v", keys)
+	}
+
+	if got := recordedOutput(d, KeyApplyOutput); got != output {
+		t.Errorf("expected the whole output from apply_output_file, got %d bytes", len(got))
+	}
+}
+
+func TestDiffReleaseSetWritesOutputFile(t *testing.T) {
+	dir, _ := newFakeBinaryHelmfile(t)
+
+	// The diff file is written relative to the Terraform root module
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:             "releases: []",
+		KeyWorkingDirectory:    dir,
+		KeyKubeconfig:          writeTestKubeconfig(t),
+		KeyBin:                 filepath.Join(dir, "helmfile"),
+		KeyOutputPath:          filepath.Join(dir, "outputs"),
+		KeyStoreOutputsInState: false,
+	})
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift := "Comparing release=app, chart=charts/app, namespace=default\ndefault, app, Deployment (apps) has changed:\n-   replicas: 1\n+   replicas: 3\n"
+
+	if _, err := DiffReleaseSet(newContext(d), fs, d, WithDiffConfig(DiffConfig{Drift: &State{Output: drift}})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := d.Get(KeyDiffOutput).(string); got != "" {
+		t.Errorf("expected no diff_output in the state, got %q", got)
+	}
+
+	if got := d.Get(KeyDiffOutputSHA256).(string); len(got) != sha256.Size*2 {
+		t.Errorf("expected the hash of the diff in diff_output_sha256, got %q", got)
+	}
+
+	if got := recordedOutput(d, KeyDiffOutput); got != drift {
+		t.Errorf("expected the diff in diff_output_file, got %q", got)
+	}
+}
+
+func TestMarkDiffOutputs_OutputFiles(t *testing.T) {
+	// The hashes tell the change in place of the outputs, which are never recorded
+	d := newMockDiffChecker(KeyValues)
+
+	markDiffOutputs(d, "", []string{KeyValues}, outputAttributes{Files: true})
+
+	for _, key := range []string{KeyDiffOutputFile, KeyDiffOutputSHA256, KeyApplyOutputFile, KeyApplyOutputSHA256} {
+		if !d.newComputed[key] {
+			t.Errorf("expected %s to be marked computed when inputs changed", key)
+		}
+	}
+
+	for _, key := range []string{KeyDiffOutput, KeyApplyOutput} {
+		if d.newComputed[key] {
+			t.Errorf("expected %s to NOT be marked computed when written to a file", key)
+		}
+	}
+}
+
+func TestValidateOutputFileOptions(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:             "releases: []",
+		KeyKubeconfig:          writeTestKubeconfig(t),
+		KeyStoreOutputsInState: false,
+	})
+
+	if _, err := NewReleaseSet(d); err == nil || !strings.Contains(err.Error(), KeyOutputPath) {
+		t.Errorf("expected store_outputs_in_state = false to require output_path, got %v", err)
+	}
+
+	// The outputs are stored in the state unless disabled, even along with output_path
+	d = schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:    "releases: []",
+		KeyKubeconfig: writeTestKubeconfig(t),
+		KeyOutputPath: t.TempDir(),
+	})
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outputsInFiles(fs) {
+		t.Error("expected the outputs to be stored in the state")
+	}
+}
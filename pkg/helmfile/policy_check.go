@@ -0,0 +1,122 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	PolicyCheckFailureModeError = "error"
+	PolicyCheckFailureModeWarn  = "warn"
+)
+
+// PolicyCheck is a command like `conftest test -` that is run against the rendered manifests on plan
+type PolicyCheck struct {
+	// Command is the argv of the policy check command. The rendered manifests are piped to its stdin.
+	Command []string
+
+	// FailureMode is either "error" to fail the plan or "warn" to only tell the failure in policy_output
+	FailureMode string
+}
+
+func newPolicyCheck(v interface{}) *PolicyCheck {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 || items[0] == nil {
+		return nil
+	}
+
+	m := items[0].(map[string]interface{})
+
+	var command []string
+	for _, c := range m[KeyPolicyCheckCommand].([]interface{}) {
+		command = append(command, c.(string))
+	}
+
+	failureMode, _ := m[KeyPolicyCheckFailureMode].(string)
+	if failureMode == "" {
+		failureMode = PolicyCheckFailureModeError
+	}
+
+	return &PolicyCheck{
+		Command:     command,
+		FailureMode: failureMode,
+	}
+}
+
+// runPolicyCheck pipes the rendered manifests to the policy check command.
+// It returns the output of the policy check command, and an error only when the plan should fail.
+func runPolicyCheck(fs *ReleaseSet, executor HelmfileExecutor) (string, error) {
+	pc := fs.PolicyCheck
+
+	if len(pc.Command) == 0 {
+		return "", fmt.Errorf("policy_check.command must not be empty")
+	}
+
+	// Fail early with a clear message instead of after rendering all the manifests
+	bin, err := exec.LookPath(pc.Command[0])
+	if err != nil {
+		return "", fmt.Errorf("looking up policy check command %q: %w", pc.Command[0], err)
+	}
+
+	manifests, err := renderPolicyCheckManifests(fs, executor)
+	if err != nil {
+		return "", err
+	}
+
+	out := &bytes.Buffer{}
+
+	cmd := exec.Command(bin, pc.Command[1:]...)
+	cmd.Dir = fs.WorkingDirectory
+	// The policy check runs on plan along with helmfile-diff, so it gets the environment of the diff.
+	// Only the environment of the options is used, so they are built without the helmfile files.
+	cmd.Env = commandEnv(buildBaseOptions(releaseSetForDiff(fs), &helmfileFiles{}))
+	// strings.Reader streams the manifests to the command without copying them into another buffer
+	cmd.Stdin = strings.NewReader(manifests)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	logf("Running policy check %s against %d bytes of rendered manifests", strings.Join(pc.Command, " "), len(manifests))
+
+	if err := cmd.Run(); err != nil {
+		// The plan can't show warnings, so the failure is told in policy_output, which the plan shows when it changes
+		if pc.FailureMode == PolicyCheckFailureModeWarn {
+			logf("Warning: policy check failed: %v\n%s", err, out.String())
+
+			return fmt.Sprintf("policy check %s failed: %v\n%s", strings.Join(pc.Command, " "), err, out.String()), nil
+		}
+
+		return out.String(), fmt.Errorf("policy check %s failed: %w\n%s", strings.Join(pc.Command, " "), err, out.String())
+	}
+
+	return out.String(), nil
+}
+
+// renderPolicyCheckManifests returns the manifests rendered by the diff path, or renders them with helmfile template.
+// The manifests are piped to the policy check, so they are printed rather than written to template_output_dir on plan.
+func renderPolicyCheckManifests(fs *ReleaseSet, executor HelmfileExecutor) (string, error) {
+	if fs.renderedManifests != "" {
+		return fs.renderedManifests, nil
+	}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		return "", fmt.Errorf("preparing helmfile file: %w", err)
+	}
+	defer files.remove()
+
+	opts := buildTemplateOptions(fs, files)
+	opts.OutputDir, opts.OutputDirTemplate, opts.OutputFileTemplate = "", "", ""
+
+	result, err := executor.Template(context.Background(), opts)
+	if err != nil {
+		if result != nil && result.Output != "" {
+			return "", fmt.Errorf("running helmfile template for policy check: %w\nOutput:\n%s", err, result.Output)
+		}
+		return "", fmt.Errorf("running helmfile template for policy check: %w", err)
+	}
+
+	return result.stdout(), nil
+}
@@ -0,0 +1,238 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeExecutor is a HelmfileExecutor that returns canned results without running helmfile
+type fakeExecutor struct {
+	templateOutput string
+	listOutput     string
+	applyOutput    string
+
+	// listOutputBySelectors are the outputs of List keyed by the space-separated selectors, defaulting to listOutput
+	listOutputBySelectors map[string]string
+
+	// failingSelectors are the selectors whose Apply fails
+	failingSelectors map[string]bool
+	appliedSelectors []string
+
+	// destroyErrorsBySelectors are the errors of Destroy keyed by the space-separated selectors
+	destroyErrorsBySelectors map[string]error
+	destroyedSelectors       []string
+
+	// syncedSelectors are the selectors of the syncs, which output syncOutput
+	syncOutput      string
+	syncedSelectors []string
+
+	// lintOutput and lintErr are the result of Lint, whose options are recorded in lintOptions
+	lintOutput  string
+	lintErr     error
+	lintOptions []*LintOptions
+
+	// templateOptions are the options of the templates
+	templateOptions []*TemplateOptions
+}
+
+func (e *fakeExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	// The selector map is recorded as well, as helmfile ORs it with the selectors
+	for _, k := range sortedKeys(opts.Selector) {
+		e.appliedSelectors = append(e.appliedSelectors, fmt.Sprintf("%s=%s", k, opts.Selector[k]))
+	}
+	for _, s := range convertSelectorsToStrings(opts.Selectors) {
+		e.appliedSelectors = append(e.appliedSelectors, s)
+		if e.failingSelectors[s] {
+			return &Result{Output: "apply failed\n", ExitCode: 1}, fmt.Errorf("release %s failed", s)
+		}
+	}
+	return &Result{Output: e.applyOutput}, nil
+}
+
+func (e *fakeExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
+	e.syncedSelectors = append(e.syncedSelectors, convertSelectorsToStrings(opts.Selectors)...)
+	return &Result{Output: e.syncOutput}, nil
+}
+
+func (e *fakeExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result, error) {
+	return &Result{}, nil
+}
+
+func (e *fakeExecutor) Template(ctx context.Context, opts *TemplateOptions) (*Result, error) {
+	e.templateOptions = append(e.templateOptions, opts)
+	return &Result{Output: e.templateOutput}, nil
+}
+
+func (e *fakeExecutor) Destroy(ctx context.Context, opts *DestroyOptions) (*Result, error) {
+	selectors := strings.Join(convertSelectorsToStrings(opts.Selectors), " ")
+	e.destroyedSelectors = append(e.destroyedSelectors, selectors)
+	if err := e.destroyErrorsBySelectors[selectors]; err != nil {
+		return &Result{Output: err.Error() + "\n", ExitCode: 1}, err
+	}
+	return &Result{}, nil
+}
+
+func (e *fakeExecutor) Build(ctx context.Context, opts *BuildOptions) (*Result, error) {
+	return &Result{}, nil
+}
+
+func (e *fakeExecutor) PrintEnv(ctx context.Context, opts *PrintEnvOptions) (*Result, error) {
+	return &Result{}, nil
+}
+
+func (e *fakeExecutor) List(ctx context.Context, opts *ListOptions) (*Result, error) {
+	if out, ok := e.listOutputBySelectors[strings.Join(convertSelectorsToStrings(opts.Selectors), " ")]; ok {
+		return &Result{Output: out}, nil
+	}
+	return &Result{Output: e.listOutput}, nil
+}
+
+func (e *fakeExecutor) Lint(ctx context.Context, opts *LintOptions) (*Result, error) {
+	e.lintOptions = append(e.lintOptions, opts)
+	return &Result{Output: e.lintOutput}, e.lintErr
+}
+
+func (e *fakeExecutor) Version(ctx context.Context) (string, error) {
+	return "fake", nil
+}
+
+func TestRunPolicyCheck(t *testing.T) {
+	manifests := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo\n"
+
+	tests := []struct {
+		name        string
+		command     []string
+		failureMode string
+		wantErr     bool
+		wantOutput  string
+	}{
+		{
+			name:        "passing policy check receives the manifests on stdin",
+			command:     []string{"sh", "-c", "grep -c ConfigMap"},
+			failureMode: PolicyCheckFailureModeError,
+			wantOutput:  "1",
+		},
+		{
+			name:        "failing policy check fails the plan in error mode",
+			command:     []string{"sh", "-c", "cat >/dev/null; echo denied; exit 1"},
+			failureMode: PolicyCheckFailureModeError,
+			wantErr:     true,
+			wantOutput:  "denied",
+		},
+		{
+			name:        "failing policy check only warns in warn mode",
+			command:     []string{"sh", "-c", "cat >/dev/null; echo denied; exit 1"},
+			failureMode: PolicyCheckFailureModeWarn,
+			wantOutput:  "policy check sh -c cat >/dev/null; echo denied; exit 1 failed: exit status 1\ndenied",
+		},
+		{
+			name:        "missing policy binary",
+			command:     []string{"this-policy-binary-does-not-exist"},
+			failureMode: PolicyCheckFailureModeWarn,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &ReleaseSet{
+				Content:          "releases: []",
+				WorkingDirectory: t.TempDir(),
+				Kubeconfig:       "/tmp/kubeconfig",
+				PolicyCheck: &PolicyCheck{
+					Command:     tt.command,
+					FailureMode: tt.failureMode,
+				},
+			}
+
+			output, err := runPolicyCheck(fs, &fakeExecutor{templateOutput: manifests})
+			if tt.wantErr && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(output, tt.wantOutput) {
+				t.Errorf("expected output to contain %q, got %q", tt.wantOutput, output)
+			}
+		})
+	}
+}
+
+func TestRunPolicyCheckManifests(t *testing.T) {
+	newReleaseSet := func() *ReleaseSet {
+		return &ReleaseSet{
+			Content:           "releases: []",
+			WorkingDirectory:  t.TempDir(),
+			Kubeconfig:        "/tmp/kubeconfig",
+			TemplateOutputDir: "manifests",
+			PolicyCheck:       &PolicyCheck{Command: []string{"cat"}},
+		}
+	}
+
+	// The manifests rendered by the diff path are reused
+	fs := newReleaseSet()
+	fs.renderedManifests = "kind: Deployment\n"
+
+	executor := &fakeExecutor{templateOutput: "kind: ConfigMap\n"}
+
+	output, err := runPolicyCheck(fs, executor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "kind: Deployment\n" || len(executor.templateOptions) != 0 {
+		t.Errorf("expected the rendered manifests to be reused without a template, got %q after %d templates", output, len(executor.templateOptions))
+	}
+
+	// Otherwise they are rendered to stdout, leaving template_output_dir alone on plan
+	output, err = runPolicyCheck(newReleaseSet(), executor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output != "kind: ConfigMap\n" {
+		t.Errorf("expected the rendered manifests, got %q", output)
+	}
+	if len(executor.templateOptions) != 1 || executor.templateOptions[0].OutputDir != "" {
+		t.Errorf("expected one template without an output dir, got %+v", executor.templateOptions)
+	}
+}
+
+// TestRunPolicyCheckEnvironment asserts that the policy check gets the environment of helmfile-diff
+func TestRunPolicyCheckEnvironment(t *testing.T) {
+	dir := t.TempDir()
+
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte("apiVersion: v1\nkind: Config\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &ReleaseSet{
+		Content:                       "releases: []",
+		WorkingDirectory:              dir,
+		Kubeconfig:                    kubeconfig,
+		EnvironmentVariables:          map[string]interface{}{"REGION": "us-east-1", "STAGE": "prod"},
+		DiffEnvironmentVariables:      map[string]interface{}{"STAGE": "plan"},
+		SensitiveEnvironmentVariables: map[string]interface{}{"TOKEN": "s3cr3t"},
+		PolicyCheck: &PolicyCheck{
+			Command: []string{"sh", "-c", `cat >/dev/null; echo "$KUBECONFIG $REGION $STAGE $TOKEN" >env`},
+		},
+	}
+	fs.EnvironmentVariables = mergeEnvironmentVariables(fs.EnvironmentVariables, fs.SensitiveEnvironmentVariables)
+
+	if _, err := runPolicyCheck(fs, &fakeExecutor{templateOutput: "kind: ConfigMap\n"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := kubeconfig + " us-east-1 plan s3cr3t\n"; string(bs) != want {
+		t.Errorf("unexpected environment of the policy check: got %q, want %q", string(bs), want)
+	}
+}
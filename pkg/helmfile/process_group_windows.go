@@ -0,0 +1,31 @@
+//go:build windows
+
+package helmfile
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on Windows, which has no process groups to signal
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// signalProcessGroup kills the command on Windows, which can't deliver signals to a process
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return cmd.Process.Kill()
+}
+
+// processAlive returns true when the process of the pid is running. On Windows, finding the process opens it,
+// which fails once it has exited.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	p.Release()
+
+	return true
+}
@@ -1,38 +1,178 @@
 package helmfile
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/hashicorp/terraform-plugin-sdk/helper/mutexkv"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 	"github.com/hashicorp/terraform-plugin-sdk/terraform"
 )
 
 const (
 	KeyMaxDiffOutputLen = "max_diff_output_len"
+	KeyDefaultSelectors = "default_selectors"
+
+	KeyMaxOutputLen            = "max_output_len"
+	KeyContentSizeWarningBytes = "content_size_warning_bytes"
+	KeyMaxContentSizeBytes     = "max_content_size_bytes"
+
+	KeyMetricsFile = "metrics_file"
+
+	KeyExecutor = "executor"
+
+	KeyEKSClusterCacheTTL = "eks_cluster_cache_ttl"
+
+	KeyStaleKubeconfigMaxAge = "stale_kubeconfig_max_age"
+
+	KeyDefaultConcurrency    = "default_concurrency"
+	KeyMaxParallelOperations = "max_parallel_operations"
 )
 
 // Provider returns a terraform.ResourceProvider.
 func Provider() terraform.ResourceProvider {
-	return &schema.Provider{
+	p := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			KeyMaxDiffOutputLen: {
 				Type:        schema.TypeInt,
 				Optional:    true,
 				ForceNew:    false,
-				Default:     4096,
+				Default:     DefaultMaxDiffOutputLen,
 				Description: "Maximum length of helmfile diff output before truncation",
 			},
+			KeyMaxOutputLen: {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    false,
+				Default:     DefaultMaxOutputLen,
+				Description: "Maximum length of apply_output and template_output before truncation",
+			},
+			KeyContentSizeWarningBytes: {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    false,
+				Default:     DefaultContentSizeWarningBytes,
+				Description: "Size in bytes of content and each values entry above which a warning is logged on plan. Terraform shows a warning for the ones above 512 KB on validate regardless, as the validation has no access to the provider config",
+			},
+			KeyMaxContentSizeBytes: {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    false,
+				Default:     DefaultMaxContentSizeBytes,
+				Description: "Size in bytes of content and each values entry above which the plan fails. Terraform fails opaquely on messages near 4 MB",
+			},
+			KeyDefaultSelectors: {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: false,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Description: "Label selectors like team=foo that are ANDed into the selectors of every resource managed by this provider. They are ANDed even into the resource selectors with the same key, and a resource selector that conflicts with them, like team=bar or team!=foo, is rejected. See effective_selectors of the resource for the selectors passed to helmfile",
+			},
+			KeyMetricsFile: {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    false,
+				Description: "Path to a file to which the metrics of each operation are written in the node_exporter textfile format, labeled by resource. Failing to write it only logs a warning",
+			},
+			KeyExecutor: {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     false,
+				Default:      ExecutorLibrary,
+				ValidateFunc: validation.StringInSlice([]string{ExecutorLibrary, ExecutorBinary}, false),
+				Description:  "Either library to run helmfile embedded in the provider, or binary to run the helmfile binary set by the binary attribute of each resource",
+			},
+			KeyEKSClusterCacheTTL: {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     false,
+				Default:      DefaultEKSClusterCacheTTL.String(),
+				ValidateFunc: validateNonNegativeDuration,
+				Description:  "Duration like 10m for which the EKS DescribeCluster of eks_cluster_name is reused across the resources and across plan and apply. 0s disables the cache",
+			},
+			KeyStaleKubeconfigMaxAge: {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     false,
+				Default:      DefaultStaleKubeconfigMaxAge.String(),
+				ValidateFunc: validateNonNegativeDuration,
+				Description:  "Age like 24h after which the temporary kubeconfigs left behind by crashed runs are removed from the working directories on configure and before each create and update. 0s disables the removal",
+			},
+			KeyDefaultConcurrency: {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ForceNew:     false,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Concurrency of helmfile for the resources that leave concurrency at 0. Defaults to helmfile's default, which runs all the releases at once",
+			},
+			KeyMaxParallelOperations: {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ForceNew:     false,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Maximum number of helmfile operations the provider runs at once across the resources, on top of Terraform's -parallelism, including the diff on plan and the build and template run for the outputs. The others wait for one of them to finish. 0 doesn't limit them",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"helmfile_release_set":       resourceHelmfileReleaseSet(),
 			"helmfile_release":           resourceHelmfileRelease(),
 			"helmfile_embedding_example": resourceHelmfileEmbeddingExample(),
 		},
-		ConfigureFunc: providerConfigure,
+		DataSourcesMap: map[string]*schema.Resource{
+			"helmfile_content_diff":      dataSourceHelmfileContentDiff(),
+			"helmfile_diff":              dataSourceHelmfileDiff(),
+			"helmfile_environment_check": dataSourceHelmfileEnvironmentCheck(),
+			"helmfile_template":          dataSourceHelmfileTemplate(),
+		},
 	}
+
+	p.ConfigureFunc = func(d *schema.ResourceData) (interface{}, error) {
+		// The running helmfile operations are stopped on Ctrl-C and on SIGTERM, flushing their partial output
+		watchProviderStop(p)
+		handleShutdownSignals()
+
+		return providerConfigure(d)
+	}
+
+	return p
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	return New(d), nil
+	p, err := New(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// The working directories of the resources are not known yet, so this covers the ones defaulting to them
+	sweepStaleKubeconfigs(os.TempDir(), p.StaleKubeconfigMaxAge)
+	sweepStaleKubeconfigs(".", p.StaleKubeconfigMaxAge)
+
+	return p, nil
+}
+
+// validateNonNegativeDuration validates that the value is a duration like 10m, which may be 0s to disable the feature
+func validateNonNegativeDuration(v interface{}, k string) ([]string, []error) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return nil, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s must be a duration like 10m: %v", k, err)}
+	}
+
+	if d < 0 {
+		return nil, []error{fmt.Errorf("%s must not be negative, got %s", k, s)}
+	}
+
+	return nil, nil
 }
 
 // This is a global MutexKV for use within this plugin.
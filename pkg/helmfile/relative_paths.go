@@ -0,0 +1,179 @@
+package helmfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pathListKeys are the keys of the lists of paths in a helmfile, which helmfile resolves relative to the helmfile
+var pathListKeys = map[string]bool{
+	"values":                true,
+	"secrets":               true,
+	"bases":                 true,
+	"helmfiles":             true,
+	"jsonPatches":           true,
+	"strategicMergePatches": true,
+	"transformers":          true,
+}
+
+var (
+	keyLinePattern      = regexp.MustCompile(`^(\s*)(- +)?([A-Za-z0-9_]+):(\s*)(.*)$`)
+	listItemLinePattern = regexp.MustCompile(`^(\s*)- +(.*)$`)
+
+	// templatePathFuncPattern matches the calls of the template functions reading a path, which helmfile resolves
+	// relative to the helmfile, with a string literal as the path
+	templatePathFuncPattern = regexp.MustCompile(`\b(readFile|readDir|readDirEntries|isFile|isDir)(\s+)"([^"]*)"`)
+
+	// templateExecPattern matches the template actions calling exec, which runs the command in the directory of the helmfile
+	templateExecPattern = regexp.MustCompile(`\{\{(-?\s*|[^}]*[\s(|])exec\s`)
+)
+
+// rebaseRelativePaths rewrites the relative paths in the helmfile content to absolute paths in workingDir, so that
+// they keep resolving to the same files when the helmfile is written to another directory. helmfile resolves them
+// relative to the directory of the helmfile rather than the directory it is run in.
+//
+// The rewritten paths are the local charts, the items of values, secrets, bases, helmfiles, jsonPatches,
+// strategicMergePatches and transformers, the path of each helmfiles entry, and the string literal paths passed to
+// the template functions reading files, like readFile. Like injectCommonLabels, it operates on the text line-by-line
+// as the content can be a Go template, so paths in flow-style lists and ones starting with template actions are left
+// as is. Template actions in the rest of a path, like envs/{{ .Environment.Name }}.yaml, are kept in the rebased path.
+// The inline patches and transformers are left as is, as they are mappings rather than paths.
+func rebaseRelativePaths(content, workingDir string) (string, error) {
+	base, err := filepath.Abs(workingDir)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute path to %s: %w", workingDir, err)
+	}
+
+	type key struct {
+		indent int
+		name   string
+	}
+
+	// The keys enclosing the current line, innermost last
+	var keys []key
+
+	parent := func() string {
+		if len(keys) == 0 {
+			return ""
+		}
+
+		return keys[len(keys)-1].name
+	}
+
+	lines := strings.Split(content, "\n")
+
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(l) - len(strings.TrimLeft(l, " "))
+		item := listItemLinePattern.MatchString(l)
+
+		// A list item can be at the same indentation as the key of the list, like `values:` followed by `- a.yaml`
+		for len(keys) > 0 && (keys[len(keys)-1].indent > indent || !item && keys[len(keys)-1].indent == indent) {
+			keys = keys[:len(keys)-1]
+		}
+
+		if m := keyLinePattern.FindStringSubmatch(l); m != nil {
+			name, value := m[3], m[5]
+
+			if value == "" {
+				keys = append(keys, key{indent: len(m[1]) + len(m[2]), name: name})
+				continue
+			}
+
+			if name == "chart" || name == "path" && parent() == "helmfiles" {
+				lines[i] = m[1] + m[2] + name + ":" + m[4] + rebasePathValue(value, base, name == "chart")
+			}
+
+			continue
+		}
+
+		if m := listItemLinePattern.FindStringSubmatch(l); m != nil && pathListKeys[parent()] {
+			lines[i] = l[:len(l)-len(m[2])] + rebasePathValue(m[2], base, false)
+		}
+	}
+
+	rebased := templatePathFuncPattern.ReplaceAllStringFunc(strings.Join(lines, "\n"), func(call string) string {
+		m := templatePathFuncPattern.FindStringSubmatch(call)
+		if !isRelativePath(m[3]) {
+			return call
+		}
+
+		return m[1] + m[2] + strconv.Quote(filepath.Join(base, m[3]))
+	})
+
+	return rebased, nil
+}
+
+// callsTemplateExec returns true when the content calls exec in a template action. helmfile runs the command in the
+// directory of the helmfile, and the relative paths in its arguments can't be told apart from other arguments.
+func callsTemplateExec(content string) bool {
+	return templateExecPattern.MatchString(content)
+}
+
+// rebasePathValue returns the YAML scalar value, which can be quoted and followed by a comment, with the relative
+// path in it made absolute in base. Anything but a relative path, like a URL, a remote chart or a template, is kept.
+func rebasePathValue(value, base string, chart bool) string {
+	path, rest := value, ""
+	if i := strings.Index(path, " #"); i >= 0 {
+		path, rest = path[:i], path[i:]
+	}
+
+	trailing := path[len(strings.TrimRight(path, " \t\r")):]
+	path = strings.TrimRight(path, " \t\r")
+
+	quote := ""
+	if len(path) >= 2 && (path[0] == '"' || path[0] == '\'') && path[len(path)-1] == path[0] {
+		quote, path = path[:1], path[1:len(path)-1]
+	}
+
+	if !isRelativePath(path) || chart && !isLocalChartPath(path) {
+		return value
+	}
+
+	// Cleaning a path with template actions could drop the actions followed by ..
+	rebased := base + string(filepath.Separator) + path
+	if !strings.Contains(path, "{{") {
+		rebased = filepath.Join(base, path)
+	}
+
+	return quote + rebased + quote + trailing + rest
+}
+
+// isRelativePath returns true when path is a relative path on the local filesystem
+func isRelativePath(path string) bool {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "~") {
+		return false
+	}
+
+	// Flow collections, block scalars, templates that can render absolute paths, and remote files like
+	// git::https://... and s3://...
+	if strings.ContainsAny(path[:1], "[{|>*&!%@`") || strings.Contains(path, "://") || strings.Contains(path, "::") {
+		return false
+	}
+
+	// Remote kustomize references are resolved by RewriteHelmfileContent
+	if _, ok := ParseGitKustomizeURL(path); ok {
+		return false
+	}
+
+	return true
+}
+
+// isLocalChartPath returns true when helmfile treats the chart as a local chart rather than repo/chart or
+// repo/chart/version, following isLocalChart of helmfile
+func isLocalChartPath(chart string) bool {
+	if strings.HasPrefix(chart, "./") || strings.HasPrefix(chart, "../") {
+		return true
+	}
+
+	segments := len(strings.Split(chart, "/"))
+
+	return !strings.Contains(chart, "/") || segments != 2 && segments != 3
+}
@@ -0,0 +1,79 @@
+package helmfile
+
+import (
+	"testing"
+)
+
+func TestRebaseRelativePaths(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "local charts",
+			content: "releases:\n- name: app\n  chart: ./charts/app\n- name: lib\n  chart: ../lib\n- name: bare\n  chart: mychart\n",
+			want:    "releases:\n- name: app\n  chart: /work/charts/app\n- name: lib\n  chart: /lib\n- name: bare\n  chart: /work/mychart\n",
+		},
+		{
+			name:    "remote charts",
+			content: "releases:\n- name: a\n  chart: bitnami/nginx\n- name: b\n  chart: oci://registry/app\n- name: c\n  chart: github.com/org/repo/app?ref=v1\n- name: d\n  chart: /charts/app\n",
+			want:    "releases:\n- name: a\n  chart: bitnami/nginx\n- name: b\n  chart: oci://registry/app\n- name: c\n  chart: github.com/org/repo/app?ref=v1\n- name: d\n  chart: /charts/app\n",
+		},
+		{
+			name:    "values and secrets of releases",
+			content: "releases:\n- name: app\n  values:\n  - values/app.yaml\n  - replicas: 1\n    tags:\n    - latest\n  - \"values/quoted.yaml\" # comment\n  secrets:\n  - secrets.yaml\n  installed: true\n",
+			want:    "releases:\n- name: app\n  values:\n  - /work/values/app.yaml\n  - replicas: 1\n    tags:\n    - latest\n  - \"/work/values/quoted.yaml\" # comment\n  secrets:\n  - /work/secrets.yaml\n  installed: true\n",
+		},
+		{
+			name:    "environments, bases and helmfiles",
+			content: "bases:\n- ../common.yaml\nenvironments:\n  default:\n    values:\n      - envs/{{ .Environment.Name }}.yaml\nhelmfiles:\n- path: sub/helmfile.yaml\n- git::https://github.com/org/repo.git@helmfile.yaml\n",
+			want:    "bases:\n- /common.yaml\nenvironments:\n  default:\n    values:\n      - /work/envs/{{ .Environment.Name }}.yaml\nhelmfiles:\n- path: /work/sub/helmfile.yaml\n- git::https://github.com/org/repo.git@helmfile.yaml\n",
+		},
+		{
+			name:    "kustomize patches and transformers",
+			content: "releases:\n- name: app\n  chart: ./charts/app\n  jsonPatches:\n  - patches/json.yaml\n  - target:\n      kind: ConfigMap\n    patch:\n    - op: add\n      path: /data/x\n      value: y\n  strategicMergePatches:\n  - patches/merge.yaml\n  transformers:\n  - transformers/labels.yaml\n  - apiVersion: builtin\n    kind: LabelTransformer\n    fieldSpecs:\n    - path: metadata/labels\n",
+			want:    "releases:\n- name: app\n  chart: /work/charts/app\n  jsonPatches:\n  - /work/patches/json.yaml\n  - target:\n      kind: ConfigMap\n    patch:\n    - op: add\n      path: /data/x\n      value: y\n  strategicMergePatches:\n  - /work/patches/merge.yaml\n  transformers:\n  - /work/transformers/labels.yaml\n  - apiVersion: builtin\n    kind: LabelTransformer\n    fieldSpecs:\n    - path: metadata/labels\n",
+		},
+		{
+			name:    "template functions reading files",
+			content: "releases:\n- name: app\n  values:\n  - config: {{ readFile \"files/config.json\" | quote }}\n    present: {{ isFile \"/etc/hosts\" }}\n    files: {{ readDir  \"files\" | toJson }}\n",
+			want:    "releases:\n- name: app\n  values:\n  - config: {{ readFile \"/work/files/config.json\" | quote }}\n    present: {{ isFile \"/etc/hosts\" }}\n    files: {{ readDir  \"/work/files\" | toJson }}\n",
+		},
+		{
+			name:    "paths left as is",
+			content: "values: [a.yaml]\nreleases:\n- name: app\n  chart: '{{ .Values.chart }}'\n  values:\n  - {{ .Values.file }}\n  labels:\n  - tier\n",
+			want:    "values: [a.yaml]\nreleases:\n- name: app\n  chart: '{{ .Values.chart }}'\n  values:\n  - {{ .Values.file }}\n  labels:\n  - tier\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := rebaseRelativePaths(tc.content, "/work")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.want {
+				t.Errorf("unexpected content:\nwant:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCallsTemplateExec(t *testing.T) {
+	testcases := []struct {
+		content string
+		want    bool
+	}{
+		{content: "releases:\n- name: app\n  values:\n  - version: {{ exec \"./version.sh\" (list \"app\") }}\n", want: true},
+		{content: "releases:\n- name: app\n  values:\n  - {{ readFile \"exec.yaml\" }}\n", want: false},
+		{content: "releases:\n- name: exec\n", want: false},
+	}
+
+	for _, tc := range testcases {
+		if got := callsTemplateExec(tc.content); got != tc.want {
+			t.Errorf("callsTemplateExec(%q) = %v, want %v", tc.content, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package helmfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChangeReasonMissingReleases is the change reason of the release set whose releases were found missing from the cluster
+// on refresh, like after a manual helm uninstall
+const ChangeReasonMissingReleases = "releases missing from the cluster"
+
+// declaredRelease is a release in the JSON output of helmfile list, along with the chart version in the content
+type declaredRelease struct {
+	managedListedRelease
+
+	Version string `json:"version"`
+}
+
+// toInstall returns true when helmfile apply installs the release
+func (r declaredRelease) toInstall() bool {
+	return (r.Enabled == nil || *r.Enabled) && (r.Installed == nil || *r.Installed)
+}
+
+// refreshReleases sets releases to the releases matching the selectors that the content declares to be installed,
+// along with whether each of them is installed in the cluster.
+// It returns an error, leaving releases as is, when either the releases or the cluster can't be listed.
+func refreshReleases(ctx context.Context, d ResourceReadWrite, fs *ReleaseSet, executor HelmfileExecutor) error {
+	if fs.Kubeconfig == "" {
+		return nil
+	}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		return fmt.Errorf("preparing helmfile file: %w", err)
+	}
+	defer files.remove()
+
+	result, err := executor.List(ctx, &ListOptions{BaseOptions: *buildBaseOptions(fs, files)})
+	if err != nil {
+		return fmt.Errorf("listing releases: %w", err)
+	}
+
+	var declared []declaredRelease
+
+	if output := strings.TrimSpace(result.Output); output != "" && output != "null" {
+		if err := json.Unmarshal([]byte(output), &declared); err != nil {
+			return fmt.Errorf("parsing helmfile list output: %w", err)
+		}
+	}
+
+	_, namespace, err := clusterIdentity(fs)
+	if err != nil {
+		return fmt.Errorf("identifying the cluster: %w", err)
+	}
+
+	revisions, err := helmReleaseRevisions(fs)
+	if err != nil {
+		return err
+	}
+
+	releases := []interface{}{}
+
+	for _, r := range declared {
+		if !r.toInstall() {
+			continue
+		}
+
+		live := r.listedRelease
+		if live.Namespace == "" {
+			live.Namespace = namespace
+		}
+		_, installed := revisions[live.ID()]
+
+		releases = append(releases, map[string]interface{}{
+			KeyReleaseName:      r.Name,
+			KeyReleaseNamespace: live.Namespace,
+			KeyReleaseInstalled: installed,
+			KeyReleaseVersion:   r.Version,
+		})
+	}
+
+	return d.Set(KeyReleases, releases)
+}
+
+// missingReleases returns the releases recorded by the last refresh as missing from the cluster, as namespace/name
+func missingReleases(d ResourceRead) []string {
+	releases, _ := d.Get(KeyReleases).([]interface{})
+
+	var missing []string
+	for _, r := range releases {
+		m, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if installed, _ := m[KeyReleaseInstalled].(bool); installed {
+			continue
+		}
+
+		name, _ := m[KeyReleaseName].(string)
+		namespace, _ := m[KeyReleaseNamespace].(string)
+
+		missing = append(missing, listedRelease{Name: name, Namespace: namespace}.ID())
+	}
+
+	sort.Strings(missing)
+
+	return missing
+}
+
+// markMissingReleases marks apply_output and releases as computed for the release set whose releases are missing
+// from the cluster, so that the next apply reinstalls them even when helmfile diff is skipped or cached,
+// and reports the missing releases as the change reason
+func markMissingReleases(d newResourceDiffChecker, missing []string, reason string) {
+	d.SetNewComputed(KeyApplyOutput)
+	d.SetNewComputed(KeyReleases)
+
+	missingReason := fmt.Sprintf("%s (%s)", ChangeReasonMissingReleases, strings.Join(missing, ", "))
+	if reason != "" {
+		missingReason += ", " + reason
+	}
+
+	d.SetNew(KeyChangeReason, missingReason)
+}
@@ -0,0 +1,66 @@
+package helmfile
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRefreshReleases(t *testing.T) {
+	f := newDiffCacheFixture(t)
+
+	executor := &fakeExecutor{
+		listOutput: `[{"name":"app","namespace":"default","enabled":true,"installed":true,"labels":"","chart":"charts/app","version":"1.0.0"},` +
+			`{"name":"db","namespace":"","enabled":true,"installed":true,"labels":"","chart":"charts/db","version":"2.0.0"},` +
+			`{"name":"web","namespace":"default","enabled":true,"installed":true,"labels":"","chart":"charts/web","version":"3.0.0"},` +
+			`{"name":"legacy","namespace":"default","enabled":true,"installed":false,"labels":"","chart":"charts/legacy","version":""}]`,
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	if err := refreshReleases(context.Background(), d, f.fs, executor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// web was uninstalled out of band, and legacy is to be uninstalled
+	want := []interface{}{
+		map[string]interface{}{KeyReleaseName: "app", KeyReleaseNamespace: "default", KeyReleaseInstalled: true, KeyReleaseVersion: "1.0.0"},
+		map[string]interface{}{KeyReleaseName: "db", KeyReleaseNamespace: "default", KeyReleaseInstalled: true, KeyReleaseVersion: "2.0.0"},
+		map[string]interface{}{KeyReleaseName: "web", KeyReleaseNamespace: "default", KeyReleaseInstalled: false, KeyReleaseVersion: "3.0.0"},
+	}
+	if got := d.Get(KeyReleases); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected releases:\nwant: %v\ngot:  %v", want, got)
+	}
+
+	missing := missingReleases(d)
+	if want := []string{"default/web"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("unexpected missing releases: want %v, got %v", want, missing)
+	}
+
+	m := newMockDiffChecker()
+	markMissingReleases(m, missing, "")
+	for _, k := range []string{KeyApplyOutput, KeyReleases} {
+		if !m.newComputed[k] {
+			t.Errorf("expected %s to be recomputed", k)
+		}
+	}
+	if got, want := m.newValues[KeyChangeReason], "releases missing from the cluster (default/web)"; got != want {
+		t.Errorf("unexpected change_reason: want %q, got %q", want, got)
+	}
+
+	// An unreachable cluster leaves releases as they were
+	f.write("helm", "#!/bin/sh\necho 'Error: Kubernetes cluster unreachable' >&2\nexit 1\n", 0755)
+
+	if err := refreshReleases(context.Background(), d, f.fs, executor); err == nil {
+		t.Error("expected the unreachable cluster to fail the refresh")
+	}
+	if got := d.Get(KeyReleases); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected releases to be left as they were, got %v", got)
+	}
+
+	// The temporary helmfiles are removed
+	if matches, _ := filepath.Glob(filepath.Join(f.fs.WorkingDirectory, "helmfile-*.yaml")); len(matches) > 0 {
+		t.Errorf("expected the temporary helmfile to be removed, got %v", matches)
+	}
+}
@@ -5,16 +5,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/helmfile/helmfile/pkg/app"
 	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
 	"golang.org/x/xerrors"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/Masterminds/semver"
 )
@@ -36,18 +42,76 @@ type ReleaseSet struct {
 	// Selectors is a OR list of selectors
 	Selectors []interface{}
 
+	// DefaultSelectors is the list of provider-level selectors that are ANDed into each of Selectors
+	DefaultSelectors []string
+
+	// IgnoreDefaultSelectors opts the resource out of the provider-level default selectors
+	IgnoreDefaultSelectors bool
+
 	EnvironmentVariables map[string]interface{}
 	WorkingDirectory     string
 	ReleasesValues       map[string]interface{}
 
+	// ValuesHandling is either files to pass Values as state values files before ValuesFiles,
+	// or inline to pass them as state values after ValuesFiles
+	ValuesHandling string
+
+	// StateValues are state values passed like --state-values-set key=value, over all the other state values
+	StateValues map[string]interface{}
+
+	// EphemeralValues are sensitive state values layered after all the other values, which are never written to disk
+	EphemeralValues ephemeralValues
+
+	// ReportChartVersionChanges when true records the resolved chart versions of the releases, and adds their changes
+	// since the last apply to the diff
+	ReportChartVersionChanges bool
+
+	// ApplyEnvironmentVariables are merged over EnvironmentVariables only on apply
+	ApplyEnvironmentVariables map[string]interface{}
+
+	// DiffEnvironmentVariables are merged over EnvironmentVariables only on diff
+	DiffEnvironmentVariables map[string]interface{}
+
+	// SensitiveEnvironmentVariables are merged over EnvironmentVariables, and their values are masked in the outputs,
+	// the debug logs and the errors
+	SensitiveEnvironmentVariables map[string]interface{}
+
+	// ReleasesValuesString are like ReleasesValues but passed to helm as strings, like --set-string.
+	// They take precedence over ReleasesValues for the same key.
+	ReleasesValuesString map[string]interface{}
+
+	// SetValues are the set blocks. The auto-typed ones are passed to helm as --set after ReleasesValues,
+	// and the string-typed ones are merged into ReleasesValuesString.
+	SetValues []SetValue
+
 	// Kubeconfig is the file path to kubeconfig which is set to the KUBECONFIG environment variable on running helmfile
 	Kubeconfig string
 
 	// GeneratedKubeconfig is the path to auto-generated kubeconfig file (for cleanup)
 	GeneratedKubeconfig string
 
+	// KubeconfigContentFile is the temporary file that kubeconfig_content is written to, removed after each operation
+	KubeconfigContentFile string
+
+	// EKSAuthMode is either exec or native, the way the generated kubeconfig authenticates to the EKS cluster
+	EKSAuthMode string
+
+	// eksCluster and eksSession are what the generated kubeconfig is written again from to refresh its token
+	eksCluster *EKSClusterConfig
+	eksSession *session.Session
+
+	// eksClusterDiscovered is true when the endpoint and CA of eksCluster were discovered with DescribeCluster
+	eksClusterDiscovered bool
+
 	Concurrency int
 
+	// DefaultConcurrency is the provider's default_concurrency, used when Concurrency is 0
+	DefaultConcurrency int
+
+	// Operations is the provider's limiter of max_parallel_operations, of which the commands run without the executor,
+	// like the diff on plan, take a slot. It is nil when unlimited.
+	Operations *operationLimiter
+
 	// Version is the version number or the semver version range for the helmfile version to use
 	Version string
 
@@ -61,6 +125,239 @@ type ReleaseSet struct {
 	// DryRun when true runs helmfile template instead of apply to render manifests without deploying
 	DryRun bool
 
+	// TemplateOutputDir, TemplateOutputDirTemplate and TemplateOutputFileTemplate control where
+	// the manifests rendered in dry_run mode are written to
+	TemplateOutputDir          string
+	TemplateOutputDirTemplate  string
+	TemplateOutputFileTemplate string
+
+	// ContinueOnError when true applies the releases one by one so that a failing release
+	// doesn't prevent the remaining ones from being applied
+	ContinueOnError bool
+
+	// MaxFailedReleases is the number of failed releases tolerated when ContinueOnError is true
+	MaxFailedReleases int
+
+	// RequireWritableWorkingDirectory when true disables the fallback to a temporary directory
+	// when the working directory is not writable
+	RequireWritableWorkingDirectory bool
+
+	// KeepTempFiles keeps the temporary files written for the operations, which are otherwise removed after them
+	KeepTempFiles bool
+
+	// WorkingDirectoryLockTimeout is how long to wait for the lock on the working directory held by another release set
+	WorkingDirectoryLockTimeout time.Duration
+
+	// ScratchDirectory is where the temporary files are written, either isolated or working_directory.
+	// It is empty for the release sets created by earlier versions of the provider, which is working_directory.
+	ScratchDirectory string
+
+	// CommonLabels are the labels added to every object rendered by the releases in Content
+	CommonLabels map[string]interface{}
+
+	// KustomizePatches are the patches injected into the releases in Content
+	KustomizePatches []KustomizePatches
+
+	// CaptureEnvironmentValues when true captures the resolved environment values into environment_info on plan and apply
+	CaptureEnvironmentValues bool
+
+	// MaxDiffOutputLen is the maximum length of outputs stored in the state. Zero means the default.
+	MaxDiffOutputLen int
+
+	// MaxOutputLen is the maximum length of apply_output and template_output stored in the state. Zero means the default.
+	MaxOutputLen int
+
+	// PolicyCheck is the command that is run against the rendered manifests on plan
+	PolicyCheck *PolicyCheck
+
+	// ManagedNamespaces are the namespaces that are ensured to exist before apply
+	ManagedNamespaces []ManagedNamespace
+
+	// DeleteManagedNamespaces when true deletes the empty ManagedNamespaces after destroy
+	DeleteManagedNamespaces bool
+
+	// WaitForExternalReleases are the helm releases installed by other tools that are waited for before apply
+	WaitForExternalReleases []ExternalRelease
+
+	// DiffOutputDir is the directory to write the helmfile-diff output to, split by SplitBy
+	DiffOutputDir string
+
+	// SplitBy is either release or object
+	SplitBy string
+
+	// IDScheme is how the ID is generated on create, either inputs, random, name or content-hash
+	IDScheme string
+
+	// ID is the ID of the release set, embedded in the names of its temporary files. It is empty until create generates it
+	ID string
+
+	// Name is the name of the release set, used as the ID with the name ID scheme
+	Name string
+
+	// FailOnMissingCRDDiff when true fails the plan when helmfile-diff fails due to CRDs that are not yet installed.
+	// By default, the affected releases are noted in diff_output and left to apply.
+	FailOnMissingCRDDiff bool
+
+	// ValidateValuesAgainstSchema when true validates the values of each release against the values.schema.json of its chart on plan
+	ValidateValuesAgainstSchema bool
+
+	// ValuesSchemaTimeout is the number of seconds to wait for the values schema validation. Zero means the default.
+	ValuesSchemaTimeout int
+
+	// MaxChangedObjects is the maximum number of changed objects in the diff of a plan. Zero means no limit.
+	MaxChangedObjects int
+
+	// MaxDiffLines is the maximum number of added or removed lines in the diff of a plan. Zero means no limit.
+	MaxDiffLines int
+
+	// DiffThresholdMode is either "error" to fail the plan or "warn" to only log when the diff exceeds a threshold
+	DiffThresholdMode string
+
+	// DiffNewResources runs helmfile diff on plan even when the release set is not yet created
+	DiffNewResources bool
+
+	// DetectDrift runs helmfile diff on refresh to surface the drift of the releases in the cluster
+	DetectDrift bool
+
+	// SkipSchemaValidation passes --skip-schema-validation to helm, so that helm doesn't validate the values
+	// against the values.schema.json of the charts
+	SkipSchemaValidation bool
+
+	// ExcludeCRDs leaves the CRDs of the charts out of the rendered manifests, for include_crds = false
+	ExcludeCRDs bool
+
+	// SkipCRDs passes --skip-crds to helm upgrade, so that the CRDs of the charts are not installed
+	SkipCRDs bool
+
+	// NoHooks passes --no-hooks to helm upgrade, helm-diff and helm template, so that the hooks are neither run nor diffed
+	NoHooks bool
+
+	// ReuseValues passes --reuse-values to helm upgrade and helm-diff, keeping the values of the last release
+	ReuseValues bool
+
+	// ResetValues passes --reset-values to helm upgrade and helm-diff, ignoring the values of the last release
+	ResetValues bool
+
+	// DiffArgs are passed to helm-diff as is on plan and apply
+	DiffArgs string
+
+	// SyncArgs are passed to helm upgrade as is on apply
+	SyncArgs string
+
+	// SkipTests leaves the test hooks of the charts out of the rendered manifests
+	SkipTests bool
+
+	// SkipDeps skips helm repo update and helm dependency build, for charts whose dependencies are vendored
+	SkipDeps bool
+
+	// SkipNeeds leaves out the needs of the releases matching the selectors
+	SkipNeeds bool
+
+	// IncludeNeeds adds the needs of the releases matching the selectors
+	IncludeNeeds bool
+
+	// IncludeTransitiveNeeds adds the needs of the needs too
+	IncludeTransitiveNeeds bool
+
+	// ExtraArgs are appended to the global flags of the helmfile binary as is
+	ExtraArgs []string
+
+	// ApplyMode is either apply to run helmfile apply, or sync to run helmfile sync without the pre-apply diff
+	ApplyMode string
+
+	// Executor is either ExecutorLibrary or ExecutorBinary to override the executor of the provider, or empty
+	Executor string
+
+	// EnableLiveOutput forwards the output of helmfile to the debug log as it is produced
+	EnableLiveOutput bool
+
+	// LogLevel is the level of the helmfile logs captured in the output of the library executor
+	LogLevel string
+
+	// OperationTimeout is the Terraform timeout of the create, update or delete, after which helmfile is stopped
+	OperationTimeout time.Duration
+
+	// LintOnPlan runs helmfile lint on plan, failing the plan when it finds errors
+	LintOnPlan bool
+
+	// DiffCacheDir is the directory to cache the helmfile diff verdicts of the releases in. Empty disables the cache.
+	DiffCacheDir string
+
+	// DiffCacheTTL is the duration after which the diff cache entries expire
+	DiffCacheTTL time.Duration
+
+	// ReportFile is the path the report of the release outcomes is written to, namespaced by the resource
+	ReportFile string
+
+	// ReportFormat is either junit or json
+	ReportFormat string
+
+	// ReportOnPlan writes the report of the diff on plan too
+	ReportOnPlan bool
+
+	// HelmTimeoutApply is passed to helm upgrade as --timeout via helmDefaults. Zero means helmfile's default.
+	HelmTimeoutApply time.Duration
+
+	// Wait and WaitForJobs make helm upgrade wait for the workloads to be ready and the jobs to complete
+	Wait        bool
+	WaitForJobs bool
+
+	// ShowSecrets diffs the changes of Secrets with their values, for suppress_secrets = false
+	ShowSecrets bool
+
+	// SensitiveOutputs records the outputs of helmfile in the sensitive attributes only
+	SensitiveOutputs bool
+
+	// OutputPath is the directory to write the outputs of helmfile to when StoreOutputsInState is false
+	OutputPath string
+
+	// StoreOutputsInState records the outputs of helmfile in the state. It is true unless disabled.
+	StoreOutputsInState bool
+
+	// DiffContext is the number of lines of context of the diffs. Zero means defaultDiffContext.
+	DiffContext int
+
+	// DiffSuppressLineRegex are the regexes of the lines that helm-diff removes from the diffs
+	DiffSuppressLineRegex []string
+
+	// IgnoreDiffRegex are the regexes of the changed lines that normalizeDiff drops along with the default noisy ones
+	IgnoreDiffRegex []string
+
+	// DiffOnInstall diffs the releases that are not installed yet on apply, for skip_diff_on_install = false
+	DiffOnInstall bool
+
+	// KubeVersion is the .Capabilities.KubeVersion of the charts rendered without a cluster
+	KubeVersion string
+
+	// APIVersions are the .Capabilities.APIVersions of the charts rendered without a cluster
+	APIVersions []string
+
+	// WaitTimeout is passed to helm upgrade as --timeout via helmDefaults, bounding the wait. Zero means helmfile's default.
+	WaitTimeout time.Duration
+
+	// HelmTimeoutDiff bounds the helmfile diff run, as helm-diff has no --timeout. Zero means no limit.
+	HelmTimeoutDiff time.Duration
+
+	// HelmTimeoutDestroy is passed to helm uninstall as --timeout via helmDefaults. Zero means helmfile's default.
+	HelmTimeoutDestroy time.Duration
+
+	// StrictDestroy when true makes the delete fail when there is nothing left to destroy.
+	// By default, releases that were already uninstalled out-of-band are treated as destroyed.
+	StrictDestroy bool
+
+	// DestroyScope is either selectors to destroy the releases matching the selectors,
+	// or managed to destroy the releases recorded in managed_releases
+	DestroyScope string
+
+	// DestroyCascade is passed to helm uninstall as --cascade. Empty means helm's default, background.
+	DestroyCascade string
+
+	// DeleteWait makes helm uninstall wait until the resources of the releases are deleted
+	DeleteWait bool
+
+	// DeleteTimeout is passed to helm uninstall as --timeout via helmDefaults, bounding the wait. Zero means helmfile's default.
+	DeleteTimeout time.Duration
+
 	// SkipDiffOnMissingFiles is the list of local files. Any file contained in the list but missing on the file system
 	// result in the provider to skip running `helmfile-diff`. Use with Terraform's `depends_on`, so that
 	// you can let another dependent Terraform resource to created required files like kubeconfig or Helmfile values
@@ -68,9 +365,37 @@ type ReleaseSet struct {
 	//
 	// See https://github.com/mumoshu/terraform-provider-helmfile/issues/38 for more information on expected use-cases.
 	SkipDiffOnMissingFiles []string
+
+	// renderedManifests is the output of helmfile template when the diff path rendered the manifests on plan,
+	// which the policy check reuses rather than rendering them again
+	renderedManifests string
+
+	// nothingToDestroy is set by DeleteReleaseSet when there were no releases left to destroy,
+	// which the metrics of the delete tell apart from a destroy that uninstalled the releases
+	nothingToDestroy bool
 }
 
-func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
+// ReleaseSetConfig configures how NewReleaseSet reads the release set
+type ReleaseSetConfig struct {
+	// EKSClusterCache reuses the DescribeCluster of eks_cluster_name. It is not cached when nil
+	EKSClusterCache *EKSClusterCache
+}
+
+type ReleaseSetOption func(*ReleaseSetConfig)
+
+// WithEKSClusterCache makes NewReleaseSet reuse the DescribeCluster of eks_cluster_name cached by c
+func WithEKSClusterCache(c *EKSClusterCache) ReleaseSetOption {
+	return func(conf *ReleaseSetConfig) {
+		conf.EKSClusterCache = c
+	}
+}
+
+func NewReleaseSet(d ResourceRead, opts ...ReleaseSetOption) (*ReleaseSet, error) {
+	var conf ReleaseSetConfig
+	for _, o := range opts {
+		o(&conf)
+	}
+
 	f := ReleaseSet{}
 
 	// environment defaults to "" for helmfile_release_set but it's always nil for helmfile_release.
@@ -98,6 +423,10 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 		}
 	}
 
+	if ignoreDefaultSelectors := d.Get(KeyIgnoreDefaultSelectors); ignoreDefaultSelectors != nil {
+		f.IgnoreDefaultSelectors = ignoreDefaultSelectors.(bool)
+	}
+
 	if valuesFiles := d.Get(KeyValuesFiles); valuesFiles != nil {
 		f.ValuesFiles = valuesFiles.([]interface{})
 	}
@@ -114,10 +443,23 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 
 	f.Values = d.Get(KeyValues).([]interface{})
 	f.ReleasesValues = d.Get(KeyReleasesValues).(map[string]interface{})
+
+	if releasesValuesString := d.Get(KeyReleasesValuesString); releasesValuesString != nil {
+		f.ReleasesValuesString = releasesValuesString.(map[string]interface{})
+	}
+
+	if stateValues := d.Get(KeyStateValues); stateValues != nil {
+		f.StateValues = stateValues.(map[string]interface{})
+	}
+
+	if set := d.Get(KeySet); set != nil {
+		f.SetValues = newSetValues(set)
+	}
+
 	f.Bin = d.Get(KeyBin).(string)
 	f.WorkingDirectory = d.Get(KeyWorkingDirectory).(string)
 
-	kubeconfig := d.Get(KeyKubeconfig).(string)
+	kubeconfig := configuredKubeconfig(d)
 	eksClusterName := d.Get(KeyEKSClusterName).(string)
 
 	// Validate EKS configuration
@@ -125,12 +467,18 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 		return nil, err
 	}
 
+	kubeconfigContent, _ := d.Get(KeyKubeconfigContent).(string)
+
 	// If EKS cluster name provided and no kubeconfig, generate it
 	var generatedKubeconfig string
-	if eksClusterName != "" && kubeconfig == "" {
-		ctx := newContext(d)
+	if eksClusterName != "" && kubeconfig == "" && kubeconfigContent == "" {
 		region := getEKSRegion(d)
 
+		ctx, err := newEKSContext(d)
+		if err != nil {
+			return nil, fmt.Errorf("configuring AWS for EKS cluster %s: %w", eksClusterName, err)
+		}
+
 		logf("Generating kubeconfig for EKS cluster: %s in region: %s", eksClusterName, region)
 
 		// Check if endpoint and CA are manually provided
@@ -139,6 +487,11 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 
 		var clusterConfig *EKSClusterConfig
 
+		// The values discovered by the last apply are discovered again, as the cluster may have been recreated since
+		if rediscoversEKSCluster(d) {
+			manualEndpoint, manualCA = "", ""
+		}
+
 		if manualEndpoint != "" && manualCA != "" {
 			// Use manually provided values
 			logf("Using manually provided EKS cluster endpoint and CA")
@@ -149,18 +502,28 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 				Endpoint:    manualEndpoint,
 				CA:          manualCA,
 				AWSProfile:  awsProfile,
+				RoleARN:     getAssumeRoleARN(d),
 			}
 		} else {
 			// Fetch cluster info from AWS
 			logf("Fetching EKS cluster info from AWS API")
 			var err error
-			clusterConfig, err = fetchEKSClusterInfo(ctx, eksClusterName, region)
+			clusterConfig, err = conf.EKSClusterCache.fetch(ctx, eksClusterCacheKey{
+				ClusterName: eksClusterName,
+				Region:      region,
+				Profile:     d.Get(KeyAWSProfile).(string),
+				RoleARN:     getAssumeRoleARN(d),
+				Endpoint:    awsEndpoint(d),
+			})
 			if err != nil {
 				return nil, fmt.Errorf("fetching EKS cluster info: %w", err)
 			}
 
 			// Add AWS profile to cluster config
 			clusterConfig.AWSProfile = d.Get(KeyAWSProfile).(string)
+			clusterConfig.RoleARN = getAssumeRoleARN(d)
+
+			f.eksClusterDiscovered = true
 
 			// Store computed values back to schema
 			if setter, ok := d.(ResourceReadWrite); ok {
@@ -169,6 +532,27 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 			}
 		}
 
+		if mode := d.Get(KeyEKSAuthMode); mode != nil {
+			f.EKSAuthMode = mode.(string)
+		}
+
+		if f.EKSAuthMode != EKSAuthModeNative {
+			clusterConfig.ExecAPIVersion = eksExecAPIVersion(d)
+		}
+
+		f.eksCluster = clusterConfig
+		f.eksSession = ctx.Session()
+
+		// The token expires in 15 minutes, so it is generated anew by each operation
+		if f.EKSAuthMode == EKSAuthModeNative {
+			token, err := generateEKSToken(f.eksSession, eksClusterName, region)
+			if err != nil {
+				return nil, fmt.Errorf("generating EKS token: %w", err)
+			}
+
+			clusterConfig.Token = token
+		}
+
 		// Generate kubeconfig YAML
 		kubeconfigYAML, err := generateKubeconfigYAML(clusterConfig)
 		if err != nil {
@@ -189,9 +573,32 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 		}
 	}
 
+	// If the exec auth of a non-EKS cluster is provided and no kubeconfig, generate it
+	if clusterConfig := getClusterConfig(d); clusterConfig != nil && kubeconfig == "" && kubeconfigContent == "" {
+		kubeconfigYAML, err := generateClusterKubeconfigYAML(clusterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("generating kubeconfig: %w", err)
+		}
+
+		generatedKubeconfig, err = writeTemporaryKubeconfig(kubeconfigYAML, f.WorkingDirectory, clusterConfig.Name)
+		if err != nil {
+			return nil, fmt.Errorf("writing kubeconfig: %w", err)
+		}
+
+		kubeconfig = generatedKubeconfig
+
+		// Store computed kubeconfig path back to schema
+		if setter, ok := d.(ResourceReadWrite); ok {
+			setter.Set(KeyKubeconfig, kubeconfig)
+		}
+	}
+
 	f.Kubeconfig = kubeconfig
 	f.GeneratedKubeconfig = generatedKubeconfig
 
+	// The kubeconfig is generated again by the next operation, so it is removed when the provider is terminated
+	removeOnShutdown(generatedKubeconfig)
+
 	f.Version = d.Get(KeyVersion).(string)
 	f.HelmVersion = d.Get(KeyHelmVersion).(string)
 	f.HelmDiffVersion = d.Get(KeyHelmDiffVersion).(string)
@@ -202,6 +609,23 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 		f.EnvironmentVariables = environmentVariables.(map[string]interface{})
 	}
 
+	if applyEnvironmentVariables := d.Get(KeyApplyEnvironmentVariables); applyEnvironmentVariables != nil {
+		f.ApplyEnvironmentVariables = applyEnvironmentVariables.(map[string]interface{})
+	}
+
+	if diffEnvironmentVariables := d.Get(KeyDiffEnvironmentVariables); diffEnvironmentVariables != nil {
+		f.DiffEnvironmentVariables = diffEnvironmentVariables.(map[string]interface{})
+	}
+
+	if sensitiveEnvironmentVariables := d.Get(KeySensitiveEnvironmentVariables); sensitiveEnvironmentVariables != nil {
+		f.SensitiveEnvironmentVariables = sensitiveEnvironmentVariables.(map[string]interface{})
+
+		// Registered before anything is logged with them
+		registerSensitiveValues(f.SensitiveEnvironmentVariables)
+
+		f.EnvironmentVariables = mergeEnvironmentVariables(f.EnvironmentVariables, f.SensitiveEnvironmentVariables)
+	}
+
 	if concurrency := d.Get(KeyConcurrency); concurrency != nil {
 		f.Concurrency = concurrency.(int)
 	}
@@ -214,18 +638,381 @@ func NewReleaseSet(d ResourceRead) (*ReleaseSet, error) {
 		f.DryRun = dryRun.(bool)
 	}
 
+	if outputDir := d.Get(KeyTemplateOutputDir); outputDir != nil {
+		f.TemplateOutputDir = outputDir.(string)
+	}
+
+	if outputDirTemplate := d.Get(KeyTemplateOutputDirTemplate); outputDirTemplate != nil {
+		f.TemplateOutputDirTemplate = outputDirTemplate.(string)
+	}
+
+	if outputFileTemplate := d.Get(KeyTemplateOutputFileTemplate); outputFileTemplate != nil {
+		f.TemplateOutputFileTemplate = outputFileTemplate.(string)
+	}
+
+	if err := validateTemplateOutputOptions(&f); err != nil {
+		return nil, err
+	}
+
+	if continueOnError := d.Get(KeyContinueOnError); continueOnError != nil {
+		f.ContinueOnError = continueOnError.(bool)
+	}
+
+	if maxFailed := d.Get(KeyMaxFailedReleases); maxFailed != nil {
+		f.MaxFailedReleases = maxFailed.(int)
+	}
+
+	if requireWritable := d.Get(KeyRequireWritableWorkingDirectory); requireWritable != nil {
+		f.RequireWritableWorkingDirectory = requireWritable.(bool)
+	}
+
+	if keep, ok := d.Get(KeyKeepTempFiles).(bool); ok {
+		f.KeepTempFiles = keep
+	}
+
+	if scratch, ok := d.Get(KeyScratchDirectory).(string); ok {
+		f.ScratchDirectory = scratch
+	}
+
+	if commonLabels := d.Get(KeyCommonLabels); commonLabels != nil {
+		f.CommonLabels = commonLabels.(map[string]interface{})
+	}
+
+	if kustomizePatches := d.Get(KeyKustomizePatches); kustomizePatches != nil {
+		f.KustomizePatches = newKustomizePatches(kustomizePatches)
+	}
+
+	if captureEnvironmentValues := d.Get(KeyCaptureEnvironmentValues); captureEnvironmentValues != nil {
+		f.CaptureEnvironmentValues = captureEnvironmentValues.(bool)
+	}
+
+	if policyCheck := d.Get(KeyPolicyCheck); policyCheck != nil {
+		f.PolicyCheck = newPolicyCheck(policyCheck)
+	}
+
+	if managedNamespaces := d.Get(KeyManagedNamespaces); managedNamespaces != nil {
+		f.ManagedNamespaces = newManagedNamespaces(managedNamespaces)
+	}
+
+	if deleteManagedNamespaces := d.Get(KeyDeleteManagedNamespaces); deleteManagedNamespaces != nil {
+		f.DeleteManagedNamespaces = deleteManagedNamespaces.(bool)
+	}
+
+	if diffOutputDir := d.Get(KeyDiffOutputDir); diffOutputDir != nil {
+		f.DiffOutputDir = diffOutputDir.(string)
+	}
+
+	if splitBy := d.Get(KeySplitBy); splitBy != nil {
+		f.SplitBy = splitBy.(string)
+	}
+
+	if idScheme := d.Get(KeyIDScheme); idScheme != nil {
+		f.IDScheme = idScheme.(string)
+	}
+
+	f.ID = d.Id()
+
+	if name := d.Get(KeyName); name != nil {
+		f.Name = name.(string)
+	}
+
+	if failOnMissingCRDDiff := d.Get(KeyFailOnMissingCRDDiff); failOnMissingCRDDiff != nil {
+		f.FailOnMissingCRDDiff = failOnMissingCRDDiff.(bool)
+	}
+
+	if strictDestroy := d.Get(KeyStrictDestroy); strictDestroy != nil {
+		f.StrictDestroy = strictDestroy.(bool)
+	}
+
+	if destroyScope := d.Get(KeyDestroyScope); destroyScope != nil {
+		f.DestroyScope = destroyScope.(string)
+	}
+
+	if destroyCascade, ok := d.Get(KeyDestroyCascade).(string); ok {
+		f.DestroyCascade = destroyCascade
+	}
+
+	if deleteWait, ok := d.Get(KeyDeleteWait).(bool); ok {
+		f.DeleteWait = deleteWait
+	}
+
+	if valuesHandling := d.Get(KeyValuesHandling); valuesHandling != nil {
+		f.ValuesHandling = valuesHandling.(string)
+	}
+
+	if reportChartVersionChanges := d.Get(KeyReportChartVersionChanges); reportChartVersionChanges != nil {
+		f.ReportChartVersionChanges = reportChartVersionChanges.(bool)
+	}
+
+	if ephemeralValues := d.Get(KeyEphemeralValues); ephemeralValues != nil {
+		f.EphemeralValues = getEphemeralValues(ephemeralValues)
+
+		if _, err := ephemeralStateValues(f.EphemeralValues); err != nil {
+			return nil, err
+		}
+	}
+
+	if waitForExternalReleases := d.Get(KeyWaitForExternalReleases); waitForExternalReleases != nil {
+		f.WaitForExternalReleases = newExternalReleases(waitForExternalReleases)
+	}
+
+	if validateValuesAgainstSchema := d.Get(KeyValidateValuesAgainstSchema); validateValuesAgainstSchema != nil {
+		f.ValidateValuesAgainstSchema = validateValuesAgainstSchema.(bool)
+	}
+
+	if valuesSchemaTimeout := d.Get(KeyValuesSchemaTimeout); valuesSchemaTimeout != nil {
+		f.ValuesSchemaTimeout = valuesSchemaTimeout.(int)
+	}
+
+	if maxChangedObjects := d.Get(KeyMaxChangedObjects); maxChangedObjects != nil {
+		f.MaxChangedObjects = maxChangedObjects.(int)
+	}
+
+	if maxDiffLines := d.Get(KeyMaxDiffLines); maxDiffLines != nil {
+		f.MaxDiffLines = maxDiffLines.(int)
+	}
+
+	if diffThresholdMode := d.Get(KeyDiffThresholdMode); diffThresholdMode != nil {
+		f.DiffThresholdMode = diffThresholdMode.(string)
+	}
+
+	if diffNewResources := d.Get(KeyDiffNewResources); diffNewResources != nil {
+		f.DiffNewResources = diffNewResources.(bool)
+	}
+
+	if detectDrift := d.Get(KeyDetectDrift); detectDrift != nil {
+		f.DetectDrift = detectDrift.(bool)
+	}
+
+	if skipSchemaValidation := d.Get(KeySkipSchemaValidation); skipSchemaValidation != nil {
+		f.SkipSchemaValidation = skipSchemaValidation.(bool)
+	}
+
+	if includeCRDs, ok := d.Get(KeyIncludeCRDs).(bool); ok {
+		f.ExcludeCRDs = !includeCRDs
+	}
+
+	if skipCRDs, ok := d.Get(KeySkipCRDs).(bool); ok {
+		f.SkipCRDs = skipCRDs
+	}
+
+	if noHooks, ok := d.Get(KeyNoHooks).(bool); ok {
+		f.NoHooks = noHooks
+	}
+
+	if reuseValues, ok := d.Get(KeyReuseValues).(bool); ok {
+		f.ReuseValues = reuseValues
+	}
+
+	if resetValues, ok := d.Get(KeyResetValues).(bool); ok {
+		f.ResetValues = resetValues
+	}
+
+	if diffArgs, ok := d.Get(KeyDiffArgs).(string); ok {
+		f.DiffArgs = diffArgs
+	}
+
+	if syncArgs, ok := d.Get(KeySyncArgs).(string); ok {
+		f.SyncArgs = syncArgs
+	}
+
+	// Both decide what helm does with the values of the last release
+	if f.ReuseValues && f.ResetValues {
+		return nil, fmt.Errorf("%s and %s cannot both be true", KeyReuseValues, KeyResetValues)
+	}
+
+	if skipTests, ok := d.Get(KeySkipTests).(bool); ok {
+		f.SkipTests = skipTests
+	}
+
+	if skipDeps, ok := d.Get(KeySkipDeps).(bool); ok {
+		f.SkipDeps = skipDeps
+	}
+
+	if skipNeeds, ok := d.Get(KeySkipNeeds).(bool); ok {
+		f.SkipNeeds = skipNeeds
+	}
+
+	if includeNeeds, ok := d.Get(KeyIncludeNeeds).(bool); ok {
+		f.IncludeNeeds = includeNeeds
+	}
+
+	if includeTransitiveNeeds, ok := d.Get(KeyIncludeTransitiveNeeds).(bool); ok {
+		f.IncludeTransitiveNeeds = includeTransitiveNeeds
+	}
+
+	if wait := d.Get(KeyWait); wait != nil {
+		f.Wait = wait.(bool)
+	}
+
+	if waitForJobs := d.Get(KeyWaitForJobs); waitForJobs != nil {
+		f.WaitForJobs = waitForJobs.(bool)
+	}
+
+	if suppressSecrets, ok := d.Get(KeySuppressSecrets).(bool); ok {
+		f.ShowSecrets = !suppressSecrets
+	}
+
+	if sensitiveOutputs, ok := d.Get(KeySensitiveOutputs).(bool); ok {
+		f.SensitiveOutputs = sensitiveOutputs
+	}
+
+	if outputPath, ok := d.Get(KeyOutputPath).(string); ok {
+		f.OutputPath = outputPath
+	}
+
+	f.StoreOutputsInState = true
+	if storeOutputsInState, ok := d.Get(KeyStoreOutputsInState).(bool); ok {
+		f.StoreOutputsInState = storeOutputsInState
+	}
+
+	if err := validateOutputFileOptions(&f); err != nil {
+		return nil, err
+	}
+
+	if skipDiffOnInstall, ok := d.Get(KeySkipDiffOnInstall).(bool); ok {
+		f.DiffOnInstall = !skipDiffOnInstall
+	}
+
+	if diffContext, ok := d.Get(KeyDiffContext).(int); ok {
+		f.DiffContext = diffContext
+	}
+
+	if regexes, ok := d.Get(KeyDiffSuppressLineRegex).([]interface{}); ok {
+		for _, r := range regexes {
+			f.DiffSuppressLineRegex = append(f.DiffSuppressLineRegex, r.(string))
+		}
+	}
+
+	if regexes, ok := d.Get(KeyIgnoreDiffRegex).([]interface{}); ok {
+		for _, r := range regexes {
+			f.IgnoreDiffRegex = append(f.IgnoreDiffRegex, r.(string))
+		}
+	}
+
+	if kubeVersion, ok := d.Get(KeyKubeVersion).(string); ok {
+		f.KubeVersion = kubeVersion
+	}
+
+	if apiVersions, ok := d.Get(KeyAPIVersions).([]interface{}); ok {
+		for _, v := range apiVersions {
+			f.APIVersions = append(f.APIVersions, v.(string))
+		}
+	}
+
+	if extraArgs, ok := d.Get(KeyExtraArgs).([]interface{}); ok {
+		for _, v := range extraArgs {
+			f.ExtraArgs = append(f.ExtraArgs, v.(string))
+		}
+	}
+
+	if applyMode := d.Get(KeyApplyMode); applyMode != nil {
+		f.ApplyMode = applyMode.(string)
+	}
+
+	if executor := d.Get(KeyExecutor); executor != nil {
+		f.Executor = executor.(string)
+	}
+
+	if enableLiveOutput := d.Get(KeyEnableLiveOutput); enableLiveOutput != nil {
+		f.EnableLiveOutput = enableLiveOutput.(bool)
+	}
+
+	if logLevel := d.Get(KeyLogLevel); logLevel != nil {
+		f.LogLevel = logLevel.(string)
+	}
+
+	if lintOnPlan := d.Get(KeyLintOnPlan); lintOnPlan != nil {
+		f.LintOnPlan = lintOnPlan.(bool)
+	}
+
+	if reportFile := d.Get(KeyReportFile); reportFile != nil {
+		f.ReportFile = reportFile.(string)
+	}
+
+	if reportFormat := d.Get(KeyReportFormat); reportFormat != nil {
+		f.ReportFormat = reportFormat.(string)
+	}
+
+	if reportOnPlan := d.Get(KeyReportOnPlan); reportOnPlan != nil {
+		f.ReportOnPlan = reportOnPlan.(bool)
+	}
+
+	var err error
+
+	if diffCacheDir := d.Get(KeyDiffCacheDir); diffCacheDir != nil {
+		f.DiffCacheDir = diffCacheDir.(string)
+	}
+
+	if f.DiffCacheTTL, err = parseHelmTimeout(d.Get(KeyDiffCacheTTL), KeyDiffCacheTTL); err != nil {
+		return nil, err
+	}
+
+	if f.WorkingDirectoryLockTimeout, err = parseHelmTimeout(d.Get(KeyWorkingDirectoryLockTimeout), KeyWorkingDirectoryLockTimeout); err != nil {
+		return nil, err
+	}
+
+	if f.HelmTimeoutApply, err = parseHelmTimeout(d.Get(KeyHelmTimeoutApply), KeyHelmTimeoutApply); err != nil {
+		return nil, err
+	}
+
+	if f.HelmTimeoutDiff, err = parseHelmTimeout(d.Get(KeyHelmTimeoutDiff), KeyHelmTimeoutDiff); err != nil {
+		return nil, err
+	}
+
+	if f.WaitTimeout, err = parseHelmTimeout(d.Get(KeyWaitTimeout), KeyWaitTimeout); err != nil {
+		return nil, err
+	}
+
+	// Both are the --timeout of helm upgrade
+	if f.WaitTimeout > 0 && f.HelmTimeoutApply > 0 {
+		return nil, fmt.Errorf("%s and %s cannot both be set, as both are passed to helm upgrade as --timeout", KeyWaitTimeout, KeyHelmTimeoutApply)
+	}
+
+	if f.HelmTimeoutDestroy, err = parseHelmTimeout(d.Get(KeyHelmTimeoutDestroy), KeyHelmTimeoutDestroy); err != nil {
+		return nil, err
+	}
+
+	if f.DeleteTimeout, err = parseHelmTimeout(d.Get(KeyDeleteTimeout), KeyDeleteTimeout); err != nil {
+		return nil, err
+	}
+
+	// Both are the --timeout of helm uninstall
+	if f.DeleteTimeout > 0 && f.HelmTimeoutDestroy > 0 {
+		return nil, fmt.Errorf("%s and %s cannot both be set, as both are passed to helm uninstall as --timeout", KeyDeleteTimeout, KeyHelmTimeoutDestroy)
+	}
+
+	// The inline kubeconfig is written to a file for this operation only, so it is not stored as the kubeconfig path.
+	// It is written last so that the file isn't left behind when the release set is invalid.
+	if kubeconfigContent != "" {
+		if err := writeKubeconfigContent(&f, kubeconfigContent); err != nil {
+			return nil, err
+		}
+	}
+
 	return &f, nil
 }
 
-func NewCommandWithKubeconfig(fs *ReleaseSet, args ...string) (*exec.Cmd, error) {
-	if fs.WorkingDirectory != "" {
-		if err := os.MkdirAll(fs.WorkingDirectory, 0755); err != nil {
-			return nil, fmt.Errorf("creating working directory %q: %w", fs.WorkingDirectory, err)
+func NewCommandWithKubeconfig(fs *ReleaseSet, args ...string) (_ *exec.Cmd, err error) {
+	dir, err := scratchDir(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The temporary files are removed once runCommand runs the command, or here when it is not created
+	var written []string
+	defer func() {
+		if err != nil {
+			removeTempFiles(fs.KeepTempFiles, written...)
 		}
+	}()
+
+	content, err := scratchContent(fs, dir)
+	if err != nil {
+		return nil, err
 	}
 
 	// Resolve remote kustomize chart references before writing the helmfile
-	content := fs.Content
 	baseDir := fs.WorkingDirectory
 	if baseDir == "" {
 		baseDir = "."
@@ -237,6 +1024,17 @@ func NewCommandWithKubeconfig(fs *ReleaseSet, args ...string) (*exec.Cmd, error)
 		content = rewritten
 	}
 
+	content, written, err = injectCommonLabels(content, dir, fs.CommonLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	content, patchFiles, err := injectKustomizePatches(content, dir, fs.KustomizePatches)
+	written = append(written, patchFiles...)
+	if err != nil {
+		return nil, err
+	}
+
 	bs := []byte(content)
 	first := sha256.New()
 	first.Write(bs)
@@ -246,11 +1044,21 @@ func NewCommandWithKubeconfig(fs *ReleaseSet, args ...string) (*exec.Cmd, error)
 	if fs.EnableGoTemplate {
 		extension = ".yaml.gotmpl"
 	}
-	fs.TmpHelmFilePath = fmt.Sprintf("helmfile-%x%s", first.Sum(nil), extension)
+	fs.TmpHelmFilePath = scratchFileName("helmfile", fs.ID, first.Sum(nil), extension)
+	if dir != fs.WorkingDirectory {
+		// The command runs in the working directory, so files in the fallback directory are referenced by absolute paths
+		fs.TmpHelmFilePath = filepath.Join(dir, fs.TmpHelmFilePath)
+	}
+
+	tmpHelmFilePath := fs.TmpHelmFilePath
+	if !filepath.IsAbs(tmpHelmFilePath) {
+		tmpHelmFilePath = filepath.Join(fs.WorkingDirectory, tmpHelmFilePath)
+	}
 
-	if err := ioutil.WriteFile(filepath.Join(fs.WorkingDirectory, fs.TmpHelmFilePath), bs, 0700); err != nil {
+	if err := writeTempFile(tmpHelmFilePath, bs, 0700); err != nil {
 		return nil, err
 	}
+	written = append(written, tmpHelmFilePath)
 
 	flags := []string{
 		"--file", fs.TmpHelmFilePath,
@@ -270,40 +1078,46 @@ func NewCommandWithKubeconfig(fs *ReleaseSet, args ...string) (*exec.Cmd, error)
 		flags = append(flags, "--environment", fs.Environment)
 	}
 
-	for k, v := range fs.Selector {
-		flags = append(flags, "--selector", fmt.Sprintf("%s=%s", k, v))
+	for _, selector := range effectiveSelectors(fs) {
+		flags = append(flags, "--selector", fmt.Sprintf("%s", selector))
 	}
 
-	for _, selector := range fs.Selectors {
-		flags = append(flags, "--selector", fmt.Sprintf("%s", selector))
+	valuesPaths, err := writeTempValuesFiles(dir, fs.ID, fs.Values)
+	if err != nil {
+		return nil, err
 	}
+	written = append(written, valuesPaths...)
 
+	var stateValuesFiles []string
 	for _, f := range fs.ValuesFiles {
-		flags = append(flags, "--state-values-file", fmt.Sprintf("%v", f))
+		stateValuesFiles = append(stateValuesFiles, fmt.Sprintf("%v", f))
+	}
+	// Layer the values like prepareHelmfileFile does for the embedded helmfile, so that diff and apply agree
+	if fs.ValuesHandling == ValuesHandlingInline {
+		stateValuesFiles = append(stateValuesFiles, valuesPaths...)
+	} else {
+		stateValuesFiles = append(valuesPaths, stateValuesFiles...)
+	}
+	for _, f := range stateValuesFiles {
+		flags = append(flags, "--state-values-file", f)
 	}
-	for _, vs := range fs.Values {
-		js := []byte(fmt.Sprintf("%s", vs))
-
-		first := sha256.New()
-		first.Write(js)
-
-		relpath := filepath.Join(
-			fs.WorkingDirectory,
-			fmt.Sprintf("temp.values-%x.yaml", first.Sum(nil)),
-		)
 
-		abspath, err := filepath.Abs(relpath)
-		if err != nil {
-			return nil, xerrors.Errorf("getting absolute path to %s: %w", abspath, err)
-		}
+	// The ephemeral values are read by helmfile from memory-backed files passed as the extra files of the command
+	ephemeralFiles, ephemeralPaths, err := ephemeralValuesFiles(fs.EphemeralValues)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ephemeralPaths {
+		flags = append(flags, "--state-values-file", p)
+	}
 
-		if err := ioutil.WriteFile(abspath, js, 0700); err != nil {
-			return nil, err
-		}
+	flags = append(flags, stateValuesSetFlags(fs.StateValues)...)
 
-		flags = append(flags, "--state-values-file", abspath)
+	if fs.SkipDeps {
+		flags = append(flags, "--skip-deps")
 	}
 
+	flags = append(flags, fs.ExtraArgs...)
 	flags = append(flags, args...)
 
 	logf("Running helmfile %s on %+v", strings.Join(flags, " "), *fs)
@@ -311,63 +1125,189 @@ func NewCommandWithKubeconfig(fs *ReleaseSet, args ...string) (*exec.Cmd, error)
 	cmd := exec.Command(*helmfileBin, flags...)
 	cmd.Dir = fs.WorkingDirectory
 	cmd.Env = append(os.Environ(), readEnvironmentVariables(fs.EnvironmentVariables, "KUBECONFIG")...)
+	cmd.ExtraFiles = ephemeralFiles
+
+	if kubeconfig, err := getKubeconfig(fs); err != nil {
+		closeExtraFiles(cmd)
+		return nil, fmt.Errorf("creating command: %w", err)
+	} else if *kubeconfig != "" {
+		cmd.Env = append(cmd.Env, "KUBECONFIG="+*kubeconfig)
+	} else {
+		closeExtraFiles(cmd)
+		return nil, fmt.Errorf("[BUG] NewCommandWithKubeconfig must not be called with empty kubeconfig path. args = %s", strings.Join(args, " "))
+	}
+
+	addCommandTempFiles(cmd, fs.KeepTempFiles, written...)
+
+	logf("[DEBUG] Generated command: wd = %s, args = %s", fs.WorkingDirectory, strings.Join(cmd.Args, " "))
+	return cmd, nil
+}
+
+// effectiveSelectors returns the selectors to be passed to helmfile, with the provider-level default selectors
+// ANDed into each of the resource's selectors.
+// Helmfile ORs multiple selectors and ANDs comma-separated labels within a selector, so we append the defaults to
+// each selector rather than adding them as separate selectors. Each key of the selector map is a selector of its own,
+// as it has always been passed as a separate --selector flag.
+// Every default label is ANDed, even when the selector has a label with the same key, so that the resource never
+// selects releases outside of the defaults. validateDefaultSelectors rejects the labels that can never both match.
+func effectiveSelectors(fs *ReleaseSet) []interface{} {
+	selectors := resourceSelectors(fs)
+	defaults := defaultSelectorLabels(fs)
+
+	if len(selectors) == 0 {
+		if len(defaults) == 0 {
+			return nil
+		}
+		return []interface{}{strings.Join(defaults, ",")}
+	}
+
+	merged := make([]interface{}, 0, len(selectors))
+	for _, s := range selectors {
+		labels := splitSelectorLabels(s)
+
+		seen := map[string]bool{}
+		for _, l := range labels {
+			seen[l] = true
+		}
+
+		for _, d := range defaults {
+			if !seen[d] {
+				labels = append(labels, d)
+			}
+		}
+
+		merged = append(merged, strings.Join(labels, ","))
+	}
+
+	return merged
+}
+
+// validateDefaultSelectors fails when a provider-level default label conflicts with a label of one of the resource's
+// selectors, like team=x with either team=y or team!=x, as the selector would then never match any release
+func validateDefaultSelectors(fs *ReleaseSet) error {
+	defaults := defaultSelectorLabels(fs)
+
+	for _, s := range resourceSelectors(fs) {
+		for _, l := range splitSelectorLabels(s) {
+			for _, d := range defaults {
+				if selectorLabelsConflict(l, d) {
+					return fmt.Errorf("the provider-level %s label %q conflicts with the label %q of the selector %q, so that it would never match any release. Remove either of them",
+						KeyDefaultSelectors, d, l, s)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// resourceSelectors returns the selectors of the resource, the keys of the selector map first
+func resourceSelectors(fs *ReleaseSet) []string {
+	var selectors []string
+	for _, k := range sortedKeys(fs.Selector) {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", k, fs.Selector[k]))
+	}
+	for _, s := range fs.Selectors {
+		selectors = append(selectors, fmt.Sprintf("%s", s))
+	}
+	return selectors
+}
 
-	if kubeconfig, err := getKubeconfig(fs); err != nil {
-		return nil, fmt.Errorf("creating command: %w", err)
-	} else if *kubeconfig != "" {
-		cmd.Env = append(cmd.Env, "KUBECONFIG="+*kubeconfig)
-	} else {
-		return nil, fmt.Errorf("[BUG] NewCommandWithKubeconfig must not be called with empty kubeconfig path. args = %s", strings.Join(args, " "))
+// defaultSelectorLabels returns the labels of the provider-level default selectors in effect for the release set
+func defaultSelectorLabels(fs *ReleaseSet) []string {
+	var labels []string
+	for _, s := range fs.DefaultSelectors {
+		labels = append(labels, splitSelectorLabels(s)...)
 	}
+	return labels
+}
 
-	logf("[DEBUG] Generated command: wd = %s, args = %s", fs.WorkingDirectory, strings.Join(cmd.Args, " "))
-	return cmd, nil
+// splitSelectorLabels splits a selector into its comma-separated labels
+func splitSelectorLabels(selector string) []string {
+	var labels []string
+	for _, l := range strings.Split(selector, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
 }
 
-func getKubeconfig(fs *ReleaseSet) (*string, error) {
-	var rel string
+// parseSelectorLabel splits a label of a selector into its key, its operator, which is either = or !=, and its value.
+// The operator is empty for a label without one.
+func parseSelectorLabel(label string) (key, op, value string) {
+	if i := strings.Index(label, "!="); i >= 0 {
+		return strings.TrimSpace(label[:i]), "!=", strings.TrimSpace(label[i+2:])
+	}
+	if i := strings.Index(label, "="); i >= 0 {
+		return strings.TrimSpace(label[:i]), "=", strings.TrimSpace(strings.TrimPrefix(label[i+1:], "="))
+	}
+	return strings.TrimSpace(label), "", ""
+}
 
-	att := fs.Kubeconfig
+// selectorLabelsConflict returns true when no release can match both labels, which are either equalities of the same
+// key to different values, or an equality and an inequality of the same key and value
+func selectorLabelsConflict(a, b string) bool {
+	aKey, aOp, aValue := parseSelectorLabel(a)
+	bKey, bOp, bValue := parseSelectorLabel(b)
 
-	var env string
+	if aKey != bKey || aOp == "" || bOp == "" {
+		return false
+	}
 
-	if v, ok := fs.EnvironmentVariables["KUBECONFIG"]; ok {
-		env = v.(string)
+	if aOp == "=" && bOp == "=" {
+		return aValue != bValue
 	}
 
-	if att != "" {
-		if env != "" {
-			return nil, fmt.Errorf("validating release set: helmfile_release_set.environment_variables.KUBECONFIG cannot be set with helmfile_release_set.kubeconfig")
-		}
+	return aOp != bOp && aValue == bValue
+}
 
-		rel = att
-	} else {
-		rel = env
+// defaultSelectorsHash returns the hash of the default selectors in effect for the release set.
+// It is recorded in the state so that changes in the provider config are detected as changes of the resource.
+func defaultSelectorsHash(fs *ReleaseSet) (string, error) {
+	if len(fs.DefaultSelectors) == 0 {
+		return "", nil
 	}
 
-	abs, err := filepath.Abs(rel)
+	return HashObject(fs.DefaultSelectors)
+}
+
+// getKubeconfig returns the resolved kubeconfig path to run the commands with, failing when the file doesn't exist.
+// See resolveKubeconfig for how it is resolved.
+func getKubeconfig(fs *ReleaseSet) (*string, error) {
+	k, err := resolveKubeconfig(fs)
 	if err != nil {
-		return nil, xerrors.Errorf("determining absolute path for kubeconfig path %s: %w", rel, err)
+		return nil, err
+	}
+
+	if err := k.verify(); err != nil {
+		return nil, err
 	}
 
-	return &abs, nil
+	return &k.Path, nil
 }
 
 func CreateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, executor HelmfileExecutor) error {
 	logf("[DEBUG] Creating release set resource...")
 
+	opCtx, done := startOperation(fs.OperationTimeout)
+	defer done()
+	defer keepEKSTokenFresh(opCtx, fs)()
+
 	// Prepare helmfile file
-	tmpFile, err := prepareHelmfileFile(fs)
+	files, err := prepareHelmfileFile(fs, applyHelmDefaults(fs))
 	if err != nil {
 		return fmt.Errorf("preparing helmfile file: %w", err)
 	}
-	defer os.Remove(tmpFile)
+	defer files.remove()
+	defer removeOnShutdown(files.Path)()
 
 	// Handle dry_run mode - just render templates without applying
 	if fs.DryRun {
 		logf("[DEBUG] Running in dry_run mode - rendering templates only...")
-		opts := buildTemplateOptions(fs, tmpFile)
-		result, err := executor.Template(context.Background(), opts)
+		opts := buildTemplateOptions(fs, files)
+		result, err := executor.Template(opCtx, opts)
+		err = schemaValidationError(result, err)
 		if err != nil {
 			// Include output in error message for better debugging
 			if result != nil && result.Output != "" {
@@ -375,7 +1315,14 @@ func CreateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 			}
 			return fmt.Errorf("running helmfile template: %w", err)
 		}
-		d.Set(KeyTemplateOutput, result.Output)
+		if err := setTemplateOutput(d, fs, result.stdout()); err != nil {
+			return err
+		}
+		if result.Stderr != "" {
+			logf("helmfile-template stderr:\n%s", result.Stderr)
+			d.Set(KeyStderrOutput, truncateOutput(result.Stderr, maxOutputLen(fs), "helmfile-template stderr", KeyMaxOutputLen))
+		}
+		d.Set(KeySummary, Summary{}.toList())
 		logf("[DEBUG] Template rendered successfully, output length: %d bytes", len(result.Output))
 		return nil
 	}
@@ -393,15 +1340,49 @@ func CreateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 		}
 	}()
 
+	// Wait outside of the lock so that other release sets in the working directory are not blocked meanwhile
+	if err := waitForExternalReleases(opCtx, fs); err != nil {
+		return err
+	}
+
 	// Use executor interface for apply
-	opts := buildApplyOptions(fs, tmpFile)
+	opts := buildApplyOptions(fs, files)
 
 	//obtain exclusive lock
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
-	result, err := executor.Apply(context.Background(), opts)
+	namespacesReport, err := reconcileManagedNamespaces(opCtx, fs)
 	if err != nil {
+		return fmt.Errorf("reconciling managed namespaces: %w", err)
+	}
+
+	// Reset the failures of the previous apply, which may have run with continue_on_error
+	d.Set(KeyFailedReleases, []string{})
+
+	var result *Result
+	if fs.ContinueOnError {
+		result, err = applyEachRelease(opCtx, fs, opts, d, executor)
+	} else {
+		result, err = applyReleases(opCtx, fs, executor, opts)
+		err = recordSchemaValidationFailures(d, result, err)
+	}
+	err = recordHookResults(d, result, err)
+	if err != nil {
+		d.Set(KeySummary, applySummary(result, true).toList())
+		setReleaseStatus(d, result)
+		setApplySummary(d, result)
+
+		// Keep the partial output of the apply stopped along with the provider or by the timeout
+		if opCtx.Err() != nil {
+			if result != nil {
+				if err := setApplyOutput(d, fs, namespacesReport+result.Output); err != nil {
+					logf("Warning: not keeping the output of the interrupted apply: %v", err)
+				}
+			}
+			recordInterruptedApply(d.Id(), result, err)
+		}
+
 		// Include output in error message for better debugging
 		if result != nil && result.Output != "" {
 			return fmt.Errorf("running helmfile-apply: %w\nOutput:\n%s", err, result.Output)
@@ -409,7 +1390,23 @@ func CreateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 		return fmt.Errorf("running helmfile-apply: %w", err)
 	}
 
-	d.Set(KeyApplyOutput, result.Output)
+	if err := setApplyOutput(d, fs, namespacesReport+result.Output); err != nil {
+		return err
+	}
+	d.Set(KeySummary, applySummary(result, false).toList())
+	setReleaseStatus(d, result)
+	setApplySummary(d, result)
+
+	recordManagedReleases(opCtx, d, executor, opts.BaseOptions)
+
+	if fs.ReportChartVersionChanges {
+		recorded, _ := d.Get(KeyResolvedChartVersions).(map[string]interface{})
+		recordChartVersions(ctx, fs, d, recorded)
+	}
+
+	if fs.CaptureEnvironmentValues {
+		captureEnvironmentInfo(fs, files, d, executor)
+	}
 
 	return nil
 }
@@ -431,7 +1428,16 @@ func ReadReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite) error
 	// an empty string against an empty string, which is ovbiously not what we want.
 	d.Set(KeyDiffOutput, "")
 	d.Set(KeyApplyOutput, "")
+	d.Set(KeyRawDiffOutput, "")
+	d.Set(KeySensitiveDiffOutput, "")
+	d.Set(KeySensitiveApplyOutput, "")
 	d.Set(KeyTemplateOutput, "")
+	d.Set(KeySensitiveTemplateOutput, "")
+	for _, keys := range outputFileKeys {
+		d.Set(keys[0], "")
+		d.Set(keys[1], "")
+	}
+	d.Set(KeyStderrOutput, "")
 
 	if fs.Kubeconfig == "" {
 		logf("Skipping helmfile-build due to that kubeconfig is empty, which means that this operation has been called on a helmfile resource that depends on in-existent resource")
@@ -464,14 +1470,29 @@ func runBuild(ctx *sdk.Context, fs *ReleaseSet, flags ...string) (*State, error)
 	if err != nil {
 		return nil, err
 	}
-	// NOTE: Do not defer os.Remove(fs.TmpHelmFilePath) here.
-	// The caller (CreateReleaseSet/UpdateReleaseSet via getDiffFile) manages
-	// cleanup. Removing it here races with the library executor's Apply.
+	// NOTE: Do not os.Remove(fs.TmpHelmFilePath) here. runCommand removes the temporary files of the command
+	// once the library executor's Apply, which may use the same files, is done with them.
 
 	//obtain exclusive lock
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
+	unlock, err := lockWorkingDirectory(shutdownCtx, fs)
+	if err != nil {
+		closeExtraFiles(cmd)
+		removeCommandTempFiles(cmd)
+		return nil, err
+	}
+	defer unlock()
+
+	release, err := fs.Operations.acquire(shutdownCtx)
+	if err != nil {
+		closeExtraFiles(cmd)
+		removeCommandTempFiles(cmd)
+		return nil, err
+	}
+	defer release()
+
 	state := NewState()
 	return runCommand(ctx, cmd, state, false)
 }
@@ -485,29 +1506,24 @@ func getHelmfileVersion(ctx *sdk.Context, fs *ReleaseSet) (*semver.Version, erro
 	if err != nil {
 		return nil, fmt.Errorf("creating command: %w", err)
 	}
-	// NOTE: Do not defer os.Remove(fs.TmpHelmFilePath) here.
-	// The caller (CreateReleaseSet/UpdateReleaseSet) manages cleanup of the
-	// helmfile temp file. Removing it here races with the library executor's
-	// Apply which needs the same file.
+	// NOTE: Do not os.Remove(fs.TmpHelmFilePath) here. runCommand removes the temporary files of the command
+	// once the library executor's Apply, which may use the same files, is done with them.
 
 	//obtain exclusive lock
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
+	// helmfile version doesn't read the working directory, so it doesn't wait for the lock on it
+
 	state := NewState()
 	st, err := runCommand(ctx, cmd, state, false)
 	if err != nil {
 		return nil, fmt.Errorf("running command: %w", err)
 	}
 
-	splits := strings.Split(strings.TrimSpace(st.Output), " ")
-
-	versionPart := strings.TrimLeft(splits[len(splits)-1], "v")
-
-	v, err := semver.NewVersion(versionPart)
-
+	v, err := parseVersion(st.Output)
 	if err != nil {
-		logf("Failed to parse %q as semver: %v", versionPart, err)
+		logf("Warning: failed to detect helmfile version: %v", err)
 	}
 
 	return v, nil
@@ -518,18 +1534,37 @@ func runTemplate(ctx *sdk.Context, fs *ReleaseSet) (*State, error) {
 		"template",
 	}
 
+	if fs.SkipSchemaValidation {
+		args = append(args, "--skip-schema-validation")
+	}
+
 	cmd, err := NewCommandWithKubeconfig(fs, args...)
 	if err != nil {
 		return nil, err
 	}
-	// NOTE: Do not defer os.Remove(fs.TmpHelmFilePath) here.
-	// The caller manages cleanup. Removing it here races with the library
-	// executor's Apply which needs the same file.
+	// NOTE: Do not os.Remove(fs.TmpHelmFilePath) here. runCommand removes the temporary files of the command
+	// once the library executor's Apply, which may use the same files, is done with them.
 
 	//obtain exclusive lock
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
+	unlock, err := lockWorkingDirectory(shutdownCtx, fs)
+	if err != nil {
+		closeExtraFiles(cmd)
+		removeCommandTempFiles(cmd)
+		return nil, err
+	}
+	defer unlock()
+
+	release, err := fs.Operations.acquire(shutdownCtx)
+	if err != nil {
+		closeExtraFiles(cmd)
+		removeCommandTempFiles(cmd)
+		return nil, err
+	}
+	defer release()
+
 	state := NewState()
 	return runCommand(ctx, cmd, state, false)
 }
@@ -538,6 +1573,30 @@ type DiffConfig struct {
 	DryRun           bool
 	Kubeconfig       string
 	MaxDiffOutputLen int
+
+	// Selectors when not empty replace the selectors of the release set, like the ones generated for the releases missing in the diff cache
+	Selectors []interface{}
+
+	// KeepUnchangedOutput keeps the whole output even when there are no changes, for the diff cache to see the releases that were compared
+	KeepUnchangedOutput bool
+
+	// Drift is the helmfile diff run on refresh for detect_drift, used instead of running helmfile diff again
+	Drift *State
+}
+
+// diffOutputFlags returns the flags of helmfile diff that affect the output of each release
+func diffOutputFlags(fs *ReleaseSet) []string {
+	flags := append(secretsFlags(!fs.ShowSecrets), diffFilterFlags(diffContext(fs), fs.DiffSuppressLineRegex)...)
+
+	// The hooks that apply won't run are left out of the diff too
+	if fs.NoHooks {
+		flags = append(flags, "--no-hooks")
+	}
+
+	// The diff merges the values of the last release the way apply does, so that they don't show up as removed
+	flags = append(flags, lastValuesFlags(fs.ReuseValues, fs.ResetValues)...)
+
+	return flags
 }
 
 type DiffOption func(*DiffConfig)
@@ -549,35 +1608,86 @@ func WithDiffConfig(c DiffConfig) DiffOption {
 }
 
 func runDiff(ctx *sdk.Context, fs *ReleaseSet, conf DiffConfig) (*State, error) {
+	fs = releaseSetForDiff(fs)
+
 	args := []string{
 		"diff",
-		"--concurrency", strconv.Itoa(fs.Concurrency),
+		"--concurrency", strconv.Itoa(concurrency(fs)),
 		"--detailed-exitcode",
-		"--suppress-secrets",
-		"--context", "3",
 	}
 
-	for k, v := range fs.ReleasesValues {
-		args = append(args, "--set", fmt.Sprintf("%s=%s", k, v))
+	args = append(args, needsFlags(fs.SkipNeeds, fs.IncludeNeeds, fs.IncludeTransitiveNeeds)...)
+	args = append(args, diffOutputFlags(fs)...)
+
+	for _, set := range append(setFlagValues(releasesSetValues(fs)), setFlagsOfSetValues(fs.SetValues)...) {
+		args = append(args, "--set", set)
+	}
+
+	var releasesValuesFiles []string
+	if stringValues := releasesStringValues(fs); len(stringValues) > 0 {
+		dir, err := scratchDir(fs)
+		if err != nil {
+			return nil, err
+		}
+
+		files, err := writeReleasesStringValuesFile(dir, fs.ID, stringValues)
+		if err != nil {
+			return nil, err
+		}
+
+		releasesValuesFiles = files
+
+		for _, f := range files {
+			args = append(args, "--values", f)
+		}
 	}
 
 	if conf.DryRun {
 		args = append(args, "--dry-run")
 	}
 
-	cmd, err := NewCommandWithKubeconfig(fs, args...)
+	// helmfile diff has no --skip-schema-validation, --kube-version and --api-versions, so they are passed to helm-diff
+	// along with diff_args
+	var diffArgs []string
+	if fs.DiffArgs != "" {
+		diffArgs = append(diffArgs, fs.DiffArgs)
+	}
+
+	if fs.SkipSchemaValidation {
+		diffArgs = append(diffArgs, "--skip-schema-validation")
+	}
+
+	diffArgs = append(diffArgs, capabilitiesArgs(fs.KubeVersion, fs.APIVersions)...)
+
+	if len(diffArgs) > 0 {
+		args = append(args, "--diff-args", strings.Join(diffArgs, " "))
+	}
+
+	cmdFs := fs
+	if len(conf.Selectors) > 0 {
+		cmdFs = withSelectors(fs, conf.Selectors)
+	}
+
+	cmd, err := NewCommandWithKubeconfig(cmdFs, args...)
 	if err != nil {
+		removeTempFiles(fs.KeepTempFiles, releasesValuesFiles...)
 		return nil, err
 	}
-	// NOTE: Do not defer os.Remove(fs.TmpHelmFilePath) here.
-	// The caller manages cleanup. Removing it here races with the library
-	// executor's Apply which needs the same file.
+	addCommandTempFiles(cmd, fs.KeepTempFiles, releasesValuesFiles...)
+	fs.TmpHelmFilePath = cmdFs.TmpHelmFilePath
+	// The command is replaced with the one bound to the timeout below, so its temporary files are removed here
+	// rather than by runCommand. They are kept while the library executor's Apply uses the same files.
+	defer removeCommandTempFiles(cmd)
 
 	// Use the stable directory for storing temporary charts and values files
 	// so that helmfile-diff output becomes stables and terraform plan doesn't break.
 	// See https://github.com/roboll/helmfile/pull/1622
 
-	hash, err := HashObject(fs)
+	// The limiter is left out, as its address changes from the plan to the apply
+	hashed := *fs
+	hashed.Operations = nil
+
+	hash, err := HashObject(&hashed)
 	if err != nil {
 		return nil, xerrors.Errorf("computing hash of object: %w", err)
 	}
@@ -611,8 +1721,32 @@ func runDiff(ctx *sdk.Context, fs *ReleaseSet, conf DiffConfig) (*State, error)
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
-	state := NewState()
-	diff, err := runCommand(ctx, cmd, state, true)
+	unlock, err := lockWorkingDirectory(shutdownCtx, fs)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	release, err := fs.Operations.acquire(shutdownCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// helm-diff has no --timeout, so the whole diff is bounded instead
+	timeoutCtx := shutdownCtx
+	if fs.HelmTimeoutDiff > 0 {
+		var cancel context.CancelFunc
+		timeoutCtx, cancel = context.WithTimeout(timeoutCtx, fs.HelmTimeoutDiff)
+		defer cancel()
+
+		cmd = commandWithContext(timeoutCtx, cmd)
+	}
+
+	diff, err := runDiffCommand(ctx, cmd, conf.KeepUnchangedOutput)
+	if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("running command: helmfile diff timed out after %s = %s", KeyHelmTimeoutDiff, fs.HelmTimeoutDiff)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("running command: %w", err)
 	}
@@ -684,6 +1818,8 @@ func getDiffFile(ctx *sdk.Context, fs *ReleaseSet) (string, error) {
 		if err != nil {
 			return "", err
 		}
+
+		fs.renderedManifests = tmpl.Output
 	}
 
 	hash := sha256.New()
@@ -730,6 +1866,15 @@ func readDiffFile(ctx *sdk.Context, fs *ReleaseSet) (string, error) {
 	return string(bs), nil
 }
 
+// runDriftOrCachedDiff returns the drift detected on refresh when there is one, and runs helmfile diff otherwise
+func runDriftOrCachedDiff(ctx *sdk.Context, fs *ReleaseSet, conf DiffConfig) (*State, error) {
+	if conf.Drift != nil {
+		return conf.Drift, nil
+	}
+
+	return runCachedDiff(ctx, fs, conf)
+}
+
 // DiffReleaseSet detects diff to be included in the terraform plan by runnning `helmfile diff`.
 // Beware that this function MUST be idempotent and the result is reliable.
 //
@@ -756,7 +1901,25 @@ func DiffReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, opts
 
 	diff, err := readDiffFile(ctx, fs)
 	if err != nil {
-		state, err := runDiff(ctx, fs, diffConf)
+		state, err := runDriftOrCachedDiff(ctx, fs, diffConf)
+
+		var missingCRDNote string
+		if missing, ok := missingCRDs(fs, err); ok {
+			// The CRDs are installed by the apply that this error would block, so we let the plan show pending changes instead
+			logf("Warning: skipped helmfile-diff of %d release(s) whose CRDs are not yet installed. "+
+				"Set %s = true to fail instead: %v", len(missing), KeyFailOnMissingCRDDiff, err)
+
+			missingCRDNote = missingCRDDiffNote(missing)
+
+			// The diff of the other releases, compared before the failure, is kept along with the note
+			state = NewState()
+			var diffErr *diffCommandError
+			if errors.As(err, &diffErr) {
+				state.Output = diffErr.Output
+			}
+
+			err = nil
+		}
 		if err != nil {
 			logf("[DEBUG] Diff error detected: %v", err)
 
@@ -765,7 +1928,10 @@ func DiffReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, opts
 
 			// We return the error to stop terraform from modifying the state AND
 			// let the user knows about the error.
-			return "", fmt.Errorf("running helmfile diff: %w", err)
+			if failure := hookFailure(parseHookResults(err.Error())); failure != "" {
+				return "", fmt.Errorf("running helmfile diff: %s: %w", failure, err)
+			}
+			return "", fmt.Errorf("running helmfile diff: %w", schemaValidationError(nil, err))
 		}
 
 		// We should ideally show this like `~ diff_output = <DIFF> -> (known after apply)`,
@@ -790,41 +1956,84 @@ func DiffReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, opts
 			if err != nil {
 				return "", err
 			}
+		}
+
+		if missingCRDNote != "" {
+			if diff != "" && !strings.HasSuffix(diff, "\n") {
+				diff += "\n"
+			}
+
+			diff += missingCRDNote
+		}
 
+		if state.Output != "" || missingCRDNote != "" {
 			if err := writeDiffFile(ctx, fs, diff); err != nil {
 				return "", err
 			}
 		}
+
+		// The warnings of helm and helmfile would otherwise be part of the diff
+		if state.Stderr != "" {
+			logf("helmfile-diff stderr:\n%s", state.Stderr)
+
+			stderr, err := removeNondeterministicTemplateAndDiffLogLines(state.Stderr)
+			if err != nil {
+				return "", err
+			}
+
+			d.Set(KeyStderrOutput, truncateOutput(stderr, diffConf.MaxDiffOutputLen, "helmfile-diff stderr", KeyMaxDiffOutputLen))
+		}
+	}
+
+	// The lines that change on every render would otherwise make every plan show changes
+	raw := diff
+	diff, err = normalizeDiff(raw, fs.IgnoreDiffRegex)
+	if err != nil {
+		return "", err
 	}
 
-	// Executing d.Set(KeyDiffOutput, "") still internally records the update to the state
-	// even if d.Get(KeyDiffOutput) is already "", which breaks our acceptance test.
-	// Guard against that here.
 	if diff != "" {
-		maxDiffOutputLen := diffConf.MaxDiffOutputLen
+		setRawDiffOutput(d, fs, raw, diffConf.MaxDiffOutputLen)
+	} else if raw != "" {
+		logf("Ignoring the helmfile-diff output with no changes left after dropping the noisy lines")
+	}
 
-		if maxDiffOutputLen == 0 {
-			const DefaultMaxDiffOutputLen = 4096
+	if fs.ReportChartVersionChanges {
+		diff = reportChartVersionChanges(ctx, fs, d, diff)
+	}
 
-			maxDiffOutputLen = DefaultMaxDiffOutputLen
+	// The files are written before the truncation, as they are meant for the diffs too large for diff_output
+	if fs.DiffOutputDir != "" {
+		files, err := writeDiffOutputDir(fs.DiffOutputDir, diff, fs.SplitBy)
+		if err != nil {
+			return "", fmt.Errorf("writing %s: %w", KeyDiffOutputDir, err)
 		}
 
-		notice := "...\n" +
-			"helmfile-diff output was too long, and therefore snipped.\n" +
-			fmt.Sprintf("Set max_diff_output_len in the provider config, which is currently %d, to a larger value to see more.", maxDiffOutputLen)
-		noticeLen := len(notice)
+		d.Set(KeyDiffOutputFiles, files)
+	}
 
-		if len(diff) > maxDiffOutputLen {
-			i := maxDiffOutputLen - noticeLen - 1
-			for ; diff[i] != '\n'; i-- {
+	// The thresholds are checked before the truncation, so that a large diff can't get through by being truncated
+	if err := checkDiffThresholds(fs, diff); err != nil {
+		return "", err
+	}
 
-			}
-			if i < 0 {
-				i = 0
-			}
-			diff = diff[:i+1] + "\n" + notice
+	// Executing d.Set(KeyDiffOutput, "") still internally records the update to the state
+	// even if d.Get(KeyDiffOutput) is already "", which breaks our acceptance test.
+	// Guard against that here.
+	if diff != "" && outputsInFiles(fs) {
+		if err := writeOutputFile(d, fs, KeyDiffOutput, diff); err != nil {
+			return "", err
+		}
+	} else if diff != "" {
+		diff = truncateOutput(diff, diffConf.MaxDiffOutputLen, "helmfile-diff output", KeyMaxDiffOutputLen)
+
+		shown := protectOutput(d, fs, diff, KeySensitiveDiffOutput, fs.ShowSecrets)
+		d.Set(KeyDiffOutput, shown)
+
+		// The note left by sensitive_outputs has no releases to summarize, so the whole diff is returned instead
+		if !fs.SensitiveOutputs {
+			diff = shown
 		}
-		d.Set(KeyDiffOutput, diff)
 	}
 
 	//var previousApplyOutput string
@@ -845,6 +2054,52 @@ func DiffReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, opts
 	return diff, nil
 }
 
+// missingCRDs returns the releases whose diff failed only because the CRDs of their custom resources are not yet installed,
+// unless fail_on_missing_crd_diff is set
+func missingCRDs(fs *ReleaseSet, err error) ([]missingCRD, bool) {
+	if err == nil || fs.FailOnMissingCRDDiff {
+		return nil, false
+	}
+
+	return missingCRDDiffReleases(err.Error())
+}
+
+// truncateOutput snips the output at the last line break that fits within maxLen, and appends a notice
+// that tells the user how to see the whole output.
+func truncateOutput(output string, maxLen int, name, setting string) string {
+	if maxLen == 0 {
+		maxLen = DefaultMaxDiffOutputLen
+	}
+
+	if len(output) <= maxLen {
+		return output
+	}
+
+	notice := "...\n" +
+		fmt.Sprintf("%s was too long, and therefore snipped.\n", name) +
+		fmt.Sprintf("Set %s in the provider config, which is currently %d, to a larger value to see more.", setting, maxLen)
+	noticeLen := len(notice)
+
+	i := maxLen - noticeLen - 1
+	for ; i >= 0 && output[i] != '\n'; i-- {
+
+	}
+	if i < 0 {
+		// Without a line break to snip at, snip at the last rune that fits so as not to emit invalid UTF-8
+		end := maxLen - noticeLen
+		if end < 0 {
+			end = 0
+		}
+		for end > 0 && !utf8.RuneStart(output[end]) {
+			end--
+		}
+
+		return output[:end] + "\n" + notice
+	}
+
+	return output[:i+1] + "\n" + notice
+}
+
 // Until https://github.com/roboll/helmfile/pull/1383 and Helmfile v0.125.1,
 // various helmfile command was running `helm repo up` to update Helm chart repositories before diff/template/apply.
 // `helm repo up` seems to update repositories concnurrently, in an nondeterministic order, which makes the stdout printed by the command
@@ -908,18 +2163,24 @@ func removeNondeterministicBuildLogLines(s string) (string, error) {
 func UpdateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, executor HelmfileExecutor) error {
 	logf("[DEBUG] Updating release set resource...")
 
+	opCtx, done := startOperation(fs.OperationTimeout)
+	defer done()
+	defer keepEKSTokenFresh(opCtx, fs)()
+
 	// Prepare helmfile file
-	tmpFile, err := prepareHelmfileFile(fs)
+	files, err := prepareHelmfileFile(fs, applyHelmDefaults(fs))
 	if err != nil {
 		return fmt.Errorf("preparing helmfile file: %w", err)
 	}
-	defer os.Remove(tmpFile)
+	defer files.remove()
+	defer removeOnShutdown(files.Path)()
 
 	// Handle dry_run mode - just render templates without applying
 	if fs.DryRun {
 		logf("[DEBUG] Running in dry_run mode - rendering templates only...")
-		opts := buildTemplateOptions(fs, tmpFile)
-		result, err := executor.Template(context.Background(), opts)
+		opts := buildTemplateOptions(fs, files)
+		result, err := executor.Template(opCtx, opts)
+		err = schemaValidationError(result, err)
 		if err != nil {
 			// Include output in error message for better debugging
 			if result != nil && result.Output != "" {
@@ -927,7 +2188,14 @@ func UpdateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 			}
 			return fmt.Errorf("running helmfile template: %w", err)
 		}
-		d.Set(KeyTemplateOutput, result.Output)
+		if err := setTemplateOutput(d, fs, result.stdout()); err != nil {
+			return err
+		}
+		if result.Stderr != "" {
+			logf("helmfile-template stderr:\n%s", result.Stderr)
+			d.Set(KeyStderrOutput, truncateOutput(result.Stderr, maxOutputLen(fs), "helmfile-template stderr", KeyMaxOutputLen))
+		}
+		d.Set(KeySummary, Summary{}.toList())
 		logf("[DEBUG] Template rendered successfully, output length: %d bytes", len(result.Output))
 		return nil
 	}
@@ -953,15 +2221,49 @@ func UpdateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 	// when diff_output was marked as computed (SetNewComputed) during
 	// CustomizeDiff, which causes d.Get(KeyDiffOutput) to return "".
 
+	// Wait outside of the lock so that other release sets in the working directory are not blocked meanwhile
+	if err := waitForExternalReleases(opCtx, fs); err != nil {
+		return err
+	}
+
 	// Use executor interface for apply
-	opts := buildApplyOptions(fs, tmpFile)
+	opts := buildApplyOptions(fs, files)
 
 	//obtain exclusive lock
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
-	result, err := executor.Apply(context.Background(), opts)
+	namespacesReport, err := reconcileManagedNamespaces(opCtx, fs)
+	if err != nil {
+		return fmt.Errorf("reconciling managed namespaces: %w", err)
+	}
+
+	// Reset the failures of the previous apply, which may have run with continue_on_error
+	d.Set(KeyFailedReleases, []string{})
+
+	var result *Result
+	if fs.ContinueOnError {
+		result, err = applyEachRelease(opCtx, fs, opts, d, executor)
+	} else {
+		result, err = applyReleases(opCtx, fs, executor, opts)
+		err = recordSchemaValidationFailures(d, result, err)
+	}
+	err = recordHookResults(d, result, err)
 	if err != nil {
+		d.Set(KeySummary, applySummary(result, true).toList())
+		setReleaseStatus(d, result)
+		setApplySummary(d, result)
+
+		// Keep the partial output of the apply stopped along with the provider or by the timeout
+		if opCtx.Err() != nil {
+			if result != nil {
+				if err := setApplyOutput(d, fs, namespacesReport+result.Output); err != nil {
+					logf("Warning: not keeping the output of the interrupted apply: %v", err)
+				}
+			}
+			recordInterruptedApply(d.Id(), result, err)
+		}
+
 		// Include output in error message for better debugging
 		if result != nil && result.Output != "" {
 			return fmt.Errorf("running helmfile-apply: %w\nOutput:\n%s", err, result.Output)
@@ -969,14 +2271,36 @@ func UpdateReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 		return fmt.Errorf("running helmfile-apply: %w", err)
 	}
 
-	d.Set(KeyApplyOutput, result.Output)
+	if err := setApplyOutput(d, fs, namespacesReport+result.Output); err != nil {
+		return err
+	}
+	d.Set(KeySummary, applySummary(result, false).toList())
+	setReleaseStatus(d, result)
+	setApplySummary(d, result)
+
+	clearInterruptedApply(d.Id())
+
+	recordManagedReleases(opCtx, d, executor, opts.BaseOptions)
+
+	if fs.ReportChartVersionChanges {
+		recorded, _ := d.Get(KeyResolvedChartVersions).(map[string]interface{})
+		recordChartVersions(ctx, fs, d, recorded)
+	}
+
+	if fs.CaptureEnvironmentValues {
+		captureEnvironmentInfo(fs, files, d, executor)
+	}
 
 	return nil
 }
 
-func DeleteReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, executor HelmfileExecutor) error {
+func DeleteReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, executor HelmfileExecutor) (finalErr error) {
 	logf("[DEBUG] Deleting release set resource...")
 
+	opCtx, done := startOperation(fs.OperationTimeout)
+	defer done()
+	defer keepEKSTokenFresh(opCtx, fs)()
+
 	// Cleanup generated kubeconfig before destroying resources
 	// Do this first to ensure cleanup happens even if destroy fails
 	if fs.GeneratedKubeconfig != "" {
@@ -993,26 +2317,116 @@ func DeleteReleaseSet(ctx *sdk.Context, fs *ReleaseSet, d ResourceReadWrite, exe
 	// stored in Terraform state that have since expired).
 	fs.Content = stripRepositoriesSection(fs.Content)
 
+	// The files left by the past operations, like the ones stopped along with the provider, are swept once the
+	// release set is destroyed, after the files of the destroy itself are removed
+	defer func() {
+		if finalErr != nil {
+			return
+		}
+
+		if err := sweepTempFiles(fs); err != nil {
+			logf("Warning: %v", err)
+		}
+	}()
+
 	// Prepare helmfile file
-	tmpFile, err := prepareHelmfileFile(fs)
+	files, err := prepareHelmfileFile(fs, destroyHelmDefaults(fs))
 	if err != nil {
 		return fmt.Errorf("preparing helmfile file: %w", err)
 	}
-	defer os.Remove(tmpFile)
+	defer files.remove()
+	defer removeOnShutdown(files.Path)()
 
 	// Use executor interface for destroy
-	opts := buildDestroyOptions(fs, tmpFile)
+	opts := buildDestroyOptions(fs, files)
 
 	//obtain exclusive lock
 	mutexKV.Lock(fs.WorkingDirectory)
 	defer mutexKV.Unlock(fs.WorkingDirectory)
 
-	_, err = executor.Destroy(context.Background(), opts)
+	if fs.DestroyScope == DestroyScopeManaged {
+		destroyed, err := destroyManagedReleases(opCtx, fs, d, opts, executor)
+		if err != nil {
+			return err
+		}
+
+		if destroyed {
+			return deleteManagedNamespaces(opCtx, fs)
+		}
+	}
+
+	result, err := executor.Destroy(opCtx, opts)
 	if err != nil {
+		if !fs.StrictDestroy && isNothingToDestroy(result, err) {
+			// The releases were most likely uninstalled out-of-band. Failing here would leave the resource
+			// stuck in the state until the user runs `terraform state rm`, so we treat it as already destroyed.
+			logf("Warning: treating helmfile-destroy as successful because there were no releases left to destroy. Set strict_destroy = true to fail instead: %v", err)
+
+			fs.nothingToDestroy = true
+
+			return deleteManagedNamespaces(opCtx, fs)
+		}
+
 		return err
 	}
 
-	return nil
+	return deleteManagedNamespaces(opCtx, fs)
+}
+
+// nothingToDestroyMessages are the messages printed by helmfile and helm when there is no release to be destroyed.
+// They are used to classify binary executor results, where the only thing we get is the output and the exit code.
+var nothingToDestroyMessages = []string{
+	"no releases found that matches specified selector",
+	"no matching releases",
+	"release: not found",
+}
+
+// helmfileErrorPattern matches the start of each error listed by helmfile when it fails with more than one error,
+// like `err 0: release "foo" in "helmfile.yaml" failed: ...`, each of which is the failure of a release
+var helmfileErrorPattern = regexp.MustCompile(`(?m)^err \d+: `)
+
+// isNothingToDestroy returns true when the helmfile-destroy failure is due to that there were no releases to destroy.
+// When helmfile lists more than one error, every one of them must be that of a release not found, so that a partial
+// destroy where another release failed for another reason is still reported as failed.
+func isNothingToDestroy(result *Result, err error) bool {
+	// The library executor returns typed errors that we can inspect directly.
+	var noMatching *app.NoMatchingHelmfileError
+	if errors.As(err, &noMatching) {
+		return true
+	}
+
+	var output string
+	if result != nil {
+		output = result.Output
+	}
+
+	for _, s := range []string{err.Error(), output} {
+		if !helmfileErrorPattern.MatchString(s) {
+			continue
+		}
+
+		// The text before the first error is the logs and the number of errors
+		for _, e := range helmfileErrorPattern.Split(s, -1)[1:] {
+			if !isNothingToDestroyMessage(e) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return isNothingToDestroyMessage(err.Error()) || isNothingToDestroyMessage(output)
+}
+
+// isNothingToDestroyMessage returns true when s contains any of nothingToDestroyMessages
+func isNothingToDestroyMessage(s string) bool {
+	for _, m := range nothingToDestroyMessages {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // stripRepositoriesSection removes the top-level "repositories:" block from
@@ -1066,8 +2480,10 @@ func ImportReleaseSet(d *schema.ResourceData) (*schema.ResourceData, error) {
 		return nil, fmt.Errorf("reading %s: %w", helmfileYamlPath, err)
 	}
 
+	// The configuration isn't available on import, so imported release sets always get a random ID
 	d.SetId(newId())
 
+	d.Set(KeyIDScheme, IDSchemeRandom)
 	d.Set(KeyConcurrency, 0)
 	d.Set(KeyBin, "helmfile")
 	d.Set(KeyHelmBin, "helm")
@@ -1077,22 +2493,44 @@ func ImportReleaseSet(d *schema.ResourceData) (*schema.ResourceData, error) {
 	return d, nil
 }
 
+// validateTemplateOutputOptions validates that the output file template is set only along with an output directory
+func validateTemplateOutputOptions(fs *ReleaseSet) error {
+	if fs.TemplateOutputFileTemplate != "" && fs.TemplateOutputDir == "" && fs.TemplateOutputDirTemplate == "" {
+		return fmt.Errorf("template_output_file_template requires either template_output_dir or template_output_dir_template to be set")
+	}
+
+	return nil
+}
+
 // validateEKSConfiguration validates EKS-related configuration parameters
 func validateEKSConfiguration(d ResourceRead) error {
-	kubeconfig := d.Get(KeyKubeconfig).(string)
+	kubeconfig := configuredKubeconfig(d)
+	kubeconfigContent, _ := d.Get(KeyKubeconfigContent).(string)
 	eksClusterName := d.Get(KeyEKSClusterName).(string)
 	eksClusterRegion := d.Get(KeyEKSClusterRegion).(string)
 	awsRegion := d.Get(KeyAWSRegion).(string)
 	eksEndpoint := d.Get(KeyEKSClusterEndpoint).(string)
 	eksCA := d.Get(KeyEKSClusterCA).(string)
 
-	// Either kubeconfig or eks_cluster_name must be provided
-	if kubeconfig == "" && eksClusterName == "" {
-		return fmt.Errorf("either 'kubeconfig' or 'eks_cluster_name' must be provided")
+	// kubeconfig and kubeconfig_content are mutually exclusive
+	if kubeconfig != "" && kubeconfigContent != "" {
+		return fmt.Errorf("'kubeconfig' and 'kubeconfig_content' cannot both be provided")
+	}
+
+	// The generic exec auth is accepted only as a whole, and not along with eks_cluster_name
+	if err := validateClusterAuthExec(d); err != nil {
+		return err
+	}
+
+	clusterAuthExec := getClusterAuthExec(d) != nil
+
+	// Either kubeconfig, kubeconfig_content, eks_cluster_name or cluster_auth_exec must be provided
+	if kubeconfig == "" && kubeconfigContent == "" && eksClusterName == "" && !clusterAuthExec {
+		return fmt.Errorf("either 'kubeconfig', 'kubeconfig_content', 'eks_cluster_name' or 'cluster_auth_exec' must be provided")
 	}
 
-	// If kubeconfig is provided, skip EKS validation (kubeconfig takes precedence)
-	if kubeconfig != "" {
+	// If kubeconfig or kubeconfig_content is provided, skip EKS validation (they take precedence)
+	if kubeconfig != "" || kubeconfigContent != "" {
 		return nil
 	}
 
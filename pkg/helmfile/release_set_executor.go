@@ -2,26 +2,70 @@ package helmfile
 
 import (
 	"crypto/sha256"
-	"fmt"
-	"io/ioutil"
-	"os"
 	"path/filepath"
-
-	"golang.org/x/xerrors"
 )
 
-// prepareHelmfileFile writes the helmfile content to a temporary file and returns the path
-// It also writes temporary values files and updates fs.ValuesFiles with their paths
-func prepareHelmfileFile(fs *ReleaseSet) (string, error) {
-	if fs.WorkingDirectory != "" {
-		if err := os.MkdirAll(fs.WorkingDirectory, 0755); err != nil {
-			return "", fmt.Errorf("creating working directory %q: %w", fs.WorkingDirectory, err)
-		}
+// helmfileFiles are the temporary files that prepareHelmfileFile writes for an operation. They are passed to helmfile
+// along with the options built from the release set, which is never modified, so that every operation preparing the
+// same release set, like the diff and the apply, gets the same options.
+type helmfileFiles struct {
+	// Path is the path to the helmfile
+	Path string
+
+	// ValuesFiles are the state values files of the values with the files ValuesHandling, layered before values_files
+	ValuesFiles []string
+
+	// InlineValues are the values merged with the inline ValuesHandling
+	InlineValues map[string]interface{}
+
+	// ReleasesValuesFiles are the helm values files generated from ReleasesValuesString
+	ReleasesValuesFiles []string
+
+	// KustomizePatchFiles are the patch files of KustomizePatches referenced from the helmfile
+	KustomizePatchFiles []string
+
+	// CommonLabelsFiles are the kustomize transformer files of CommonLabels referenced from the helmfile
+	CommonLabelsFiles []string
+
+	// Keep keeps the files after the operation, like with keep_temp_files
+	Keep bool
+}
+
+// remove removes the files after the operation, unless another running operation uses them
+func (f *helmfileFiles) remove() {
+	var paths []string
+
+	if f.Path != "" {
+		paths = append(paths, f.Path)
+	}
+
+	paths = append(paths, f.ValuesFiles...)
+	paths = append(paths, f.ReleasesValuesFiles...)
+	paths = append(paths, f.KustomizePatchFiles...)
+	paths = append(paths, f.CommonLabelsFiles...)
+
+	removeTempFiles(f.Keep, paths...)
+}
+
+// prepareHelmfileFile writes the helmfile content and the values to temporary files and returns their paths
+// The helmDefaults of the operation, like the helm timeout of apply, are injected into the content
+func prepareHelmfileFile(fs *ReleaseSet, helmDefaults map[string]interface{}) (*helmfileFiles, error) {
+	if err := checkDownloaderPlugins(fs); err != nil {
+		return nil, err
+	}
+
+	dir, err := scratchDir(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := scratchContent(fs, dir)
+	if err != nil {
+		return nil, err
 	}
 
 	// Resolve remote kustomize chart references before writing the helmfile
-	content := fs.Content
-	baseDir := fs.WorkingDirectory
+	baseDir := dir
 	if baseDir == "" {
 		baseDir = "."
 	}
@@ -33,6 +77,21 @@ func prepareHelmfileFile(fs *ReleaseSet) (string, error) {
 		content = rewritten
 	}
 
+	content = injectHelmDefaults(content, helmDefaults)
+
+	files := &helmfileFiles{Keep: fs.KeepTempFiles}
+
+	content, files.CommonLabelsFiles, err = injectCommonLabels(content, dir, fs.CommonLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	content, files.KustomizePatchFiles, err = injectKustomizePatches(content, dir, fs.KustomizePatches)
+	if err != nil {
+		files.remove()
+		return nil, err
+	}
+
 	bs := []byte(content)
 	first := sha256.New()
 	first.Write(bs)
@@ -42,113 +101,214 @@ func prepareHelmfileFile(fs *ReleaseSet) (string, error) {
 	if fs.EnableGoTemplate {
 		extension = ".yaml.gotmpl"
 	}
-	tmpFile := fmt.Sprintf("helmfile-%x%s", first.Sum(nil), extension)
-	tmpFilePath := filepath.Join(fs.WorkingDirectory, tmpFile)
+	tmpFile := scratchFileName("helmfile", fs.ID, first.Sum(nil), extension)
 
-	if err := ioutil.WriteFile(tmpFilePath, bs, 0700); err != nil {
-		return "", err
+	// The helmfile is referenced by its absolute path, as helmfile runs in the working directory rather than the scratch directory
+	tmpFilePath, err := filepath.Abs(filepath.Join(dir, tmpFile))
+	if err != nil {
+		return nil, err
 	}
 
-	// Also write values files and collect their paths
-	tempValuesPaths := make([]interface{}, 0, len(fs.Values))
-	for _, vs := range fs.Values {
-		js := []byte(fmt.Sprintf("%s", vs))
+	if err := writeTempFile(tmpFilePath, bs, 0700); err != nil {
+		files.remove()
+		return nil, err
+	}
 
-		valuesHash := sha256.New()
-		valuesHash.Write(js)
+	files.Path = tmpFilePath
 
-		relpath := filepath.Join(
-			fs.WorkingDirectory,
-			fmt.Sprintf("temp.values-%x.yaml", valuesHash.Sum(nil)),
-		)
+	if err := prepareValues(fs, dir, files); err != nil {
+		files.remove()
+		return nil, err
+	}
 
-		abspath, err := filepath.Abs(relpath)
-		if err != nil {
-			return "", xerrors.Errorf("getting absolute path to %s: %w", abspath, err)
-		}
+	files.ReleasesValuesFiles, err = writeReleasesStringValuesFile(dir, fs.ID, releasesStringValues(fs))
+	if err != nil {
+		files.remove()
+		return nil, err
+	}
 
-		if err := ioutil.WriteFile(abspath, js, 0700); err != nil {
-			return "", err
-		}
+	return files, nil
+}
 
-		// Add the temp file path to ValuesFiles so library executor can find it
-		tempValuesPaths = append(tempValuesPaths, abspath)
+// prepareValues converts fs.Values for the library executor according to the values handling
+func prepareValues(fs *ReleaseSet, dir string, files *helmfileFiles) error {
+	if fs.ValuesHandling == ValuesHandlingInline {
+		// The values are passed as state values layered after values_files, without writing files.
+		// fs.Values is kept for the helmfile binary, which writes and layers them the same way.
+		var err error
+		files.InlineValues, err = inlineStateValues(fs.Values)
+		return err
 	}
 
-	// Merge temp values paths with existing ValuesFiles
-	// Put temp values first, then existing ValuesFiles (later values override earlier ones in helmfile)
-	if len(tempValuesPaths) > 0 {
-		fs.ValuesFiles = append(tempValuesPaths, fs.ValuesFiles...)
-	}
+	var err error
+	files.ValuesFiles, err = writeTempValuesFiles(dir, fs.ID, fs.Values)
 
-	// Clear fs.Values since we've converted them all to files
-	// This prevents the library executor from trying to use the YAML content as file paths
-	fs.Values = nil
+	return err
+}
+
+// liveOutputPrefix returns the prefix of the live output of the release set, which is empty until its ID is known
+func liveOutputPrefix(fs *ReleaseSet) string {
+	if fs.ID == "" {
+		return ""
+	}
 
-	return tmpFilePath, nil
+	return "release set " + fs.ID
 }
 
 // buildBaseOptions creates BaseOptions from ReleaseSet
-func buildBaseOptions(fs *ReleaseSet, tmpFile string) *BaseOptions {
-	kubeconfig, _ := getKubeconfig(fs)
+func buildBaseOptions(fs *ReleaseSet, files *helmfileFiles) *BaseOptions {
+	// The operations without cluster access, like lint and template, run without the kubeconfig file
 	kubeconfigPath := ""
-	if kubeconfig != nil {
-		kubeconfigPath = *kubeconfig
-	}
-
-	return &BaseOptions{
-		FileOrDir:            tmpFile,
-		WorkingDirectory:     fs.WorkingDirectory,
-		Kubeconfig:           kubeconfigPath,
-		Environment:          fs.Environment,
-		Selector:             fs.Selector,
-		Selectors:            fs.Selectors,
-		ValuesFiles:          fs.ValuesFiles,
-		Values:               fs.Values,
-		EnvironmentVariables: fs.EnvironmentVariables,
-		HelmBinary:           fs.HelmBin,
-		HelmfileBinary:       fs.Bin,
-		EnableGoTemplate:     fs.EnableGoTemplate,
+	if k, err := resolveKubeconfig(fs); err == nil {
+		kubeconfigPath = k.Path
+	}
+
+	// The helmfile is in the scratch directory, while helmfile runs in the working directory so that the paths in
+	// values_files and the like keep resolving relative to it
+	opts := &BaseOptions{
+		FileOrDir:              files.Path,
+		WorkingDirectory:       fs.WorkingDirectory,
+		Kubeconfig:             kubeconfigPath,
+		Environment:            fs.Environment,
+		Selectors:              effectiveSelectors(fs),
+		ValuesFiles:            fs.ValuesFiles,
+		Values:                 fs.Values,
+		EnvironmentVariables:   withResolvedKubeconfig(fs.EnvironmentVariables, fs),
+		HelmBinary:             fs.HelmBin,
+		HelmfileBinary:         fs.Bin,
+		EnableGoTemplate:       fs.EnableGoTemplate,
+		LiveOutput:             fs.EnableLiveOutput,
+		LogPrefix:              liveOutputPrefix(fs),
+		LogLevel:               fs.LogLevel,
+		StateValues:            fs.StateValues,
+		SkipDeps:               fs.SkipDeps,
+		SkipNeeds:              fs.SkipNeeds,
+		IncludeNeeds:           fs.IncludeNeeds,
+		IncludeTransitiveNeeds: fs.IncludeTransitiveNeeds,
+		ExtraArgs:              fs.ExtraArgs,
+	}
+
+	if fs.ValuesHandling == ValuesHandlingInline {
+		// The values are state values rather than helm values files
+		opts.Values = nil
+		opts.StateValuesSet = files.InlineValues
+	} else if len(files.ValuesFiles) > 0 {
+		// The values written to files are layered before values_files, in a new slice so that fs.ValuesFiles is kept as is.
+		// Otherwise the executors would write the values to files again.
+		valuesFiles := make([]interface{}, 0, len(files.ValuesFiles)+len(fs.ValuesFiles))
+		for _, p := range files.ValuesFiles {
+			valuesFiles = append(valuesFiles, p)
+		}
+
+		opts.ValuesFiles = append(valuesFiles, fs.ValuesFiles...)
+		opts.Values = nil
+	}
+
+	// The ephemeral values are passed in memory, after all the other values
+	opts.StateValuesSet = withEphemeralStateValues(opts.StateValuesSet, fs.EphemeralValues)
+
+	return opts
+}
+
+// concurrency returns the concurrency of the release set, which is the provider's default_concurrency when it is 0
+func concurrency(fs *ReleaseSet) int {
+	if fs.Concurrency == 0 {
+		return fs.DefaultConcurrency
 	}
+
+	return fs.Concurrency
 }
 
 // buildApplyOptions creates ApplyOptions from ReleaseSet
-func buildApplyOptions(fs *ReleaseSet, tmpFile string) *ApplyOptions {
+func buildApplyOptions(fs *ReleaseSet, files *helmfileFiles) *ApplyOptions {
 	return &ApplyOptions{
-		BaseOptions:       *buildBaseOptions(fs, tmpFile),
-		Concurrency:       fs.Concurrency,
-		ReleasesValues:    fs.ReleasesValues,
-		SuppressSecrets:   true,
-		SkipDiffOnInstall: true, // Skip diff on install to avoid exit code 1 "errors"
+		BaseOptions:             *buildBaseOptions(releaseSetForApply(fs), files),
+		Concurrency:             concurrency(fs),
+		ReleasesValues:          releasesSetValues(fs),
+		Set:                     setFlagsOfSetValues(fs.SetValues),
+		ReleasesValuesFiles:     files.ReleasesValuesFiles,
+		SuppressSecrets:         !fs.ShowSecrets,
+		SkipDiffOnInstall:       !fs.DiffOnInstall,
+		SkipSchemaValidation:    fs.SkipSchemaValidation,
+		SkipCRDs:                fs.SkipCRDs,
+		NoHooks:                 fs.NoHooks,
+		ReuseValues:             fs.ReuseValues,
+		ResetValues:             fs.ResetValues,
+		DiffArgs:                fs.DiffArgs,
+		SyncArgs:                fs.SyncArgs,
+		Wait:                    fs.Wait,
+		WaitForJobs:             fs.WaitForJobs,
+		Context:                 diffContext(fs),
+		SuppressOutputLineRegex: fs.DiffSuppressLineRegex,
 	}
 }
 
 // buildDiffOptions creates DiffOptions from ReleaseSet
-func buildDiffOptions(fs *ReleaseSet, tmpFile string, maxLen int) *DiffOptions {
+func buildDiffOptions(fs *ReleaseSet, files *helmfileFiles, maxLen int) *DiffOptions {
 	return &DiffOptions{
-		BaseOptions:      *buildBaseOptions(fs, tmpFile),
-		Concurrency:      fs.Concurrency,
-		ReleasesValues:   fs.ReleasesValues,
-		DetailedExitcode: true,
-		SuppressSecrets:  true,
-		Context:          3,
-		MaxDiffOutputLen: maxLen,
+		BaseOptions:             *buildBaseOptions(releaseSetForDiff(fs), files),
+		Concurrency:             concurrency(fs),
+		ReleasesValues:          releasesSetValues(fs),
+		Set:                     setFlagsOfSetValues(fs.SetValues),
+		ReleasesValuesFiles:     files.ReleasesValuesFiles,
+		DetailedExitcode:        true,
+		SuppressSecrets:         !fs.ShowSecrets,
+		Context:                 diffContext(fs),
+		SuppressOutputLineRegex: fs.DiffSuppressLineRegex,
+		MaxDiffOutputLen:        maxLen,
+		SkipSchemaValidation:    fs.SkipSchemaValidation,
+		SkipCRDs:                fs.SkipCRDs,
+		NoHooks:                 fs.NoHooks,
+		ReuseValues:             fs.ReuseValues,
+		ResetValues:             fs.ResetValues,
+		DiffArgs:                fs.DiffArgs,
+		KubeVersion:             fs.KubeVersion,
+		APIVersions:             fs.APIVersions,
 	}
 }
 
 // buildTemplateOptions creates TemplateOptions from ReleaseSet
-func buildTemplateOptions(fs *ReleaseSet, tmpFile string) *TemplateOptions {
+func buildTemplateOptions(fs *ReleaseSet, files *helmfileFiles) *TemplateOptions {
 	return &TemplateOptions{
-		BaseOptions: *buildBaseOptions(fs, tmpFile),
-		Concurrency: fs.Concurrency,
-		IncludeCRDs: true,
+		BaseOptions:          *buildBaseOptions(fs, files),
+		Concurrency:          concurrency(fs),
+		IncludeCRDs:          !fs.ExcludeCRDs,
+		OutputDir:            fs.TemplateOutputDir,
+		OutputDirTemplate:    fs.TemplateOutputDirTemplate,
+		OutputFileTemplate:   fs.TemplateOutputFileTemplate,
+		SkipSchemaValidation: fs.SkipSchemaValidation,
+		NoHooks:              fs.NoHooks,
+		SkipTests:            fs.SkipTests,
+		KubeVersion:          fs.KubeVersion,
+		APIVersions:          fs.APIVersions,
 	}
 }
 
+// buildLintOptions creates LintOptions from ReleaseSet
+func buildLintOptions(fs *ReleaseSet, files *helmfileFiles) *LintOptions {
+	opts := &LintOptions{
+		BaseOptions: *buildBaseOptions(fs, files),
+		Concurrency: concurrency(fs),
+	}
+
+	// Lint needs no cluster access, so the kubeconfig that is not set is left empty
+	// rather than resolved to the current directory
+	if k, err := resolveKubeconfig(fs); err != nil || k.Source == "" {
+		opts.Kubeconfig = ""
+	}
+
+	return opts
+}
+
 // buildDestroyOptions creates DestroyOptions from ReleaseSet
-func buildDestroyOptions(fs *ReleaseSet, tmpFile string) *DestroyOptions {
+func buildDestroyOptions(fs *ReleaseSet, files *helmfileFiles) *DestroyOptions {
+	timeout := deleteTimeout(fs)
+
 	return &DestroyOptions{
-		BaseOptions: *buildBaseOptions(fs, tmpFile),
-		Concurrency: fs.Concurrency,
+		BaseOptions:   *buildBaseOptions(fs, files),
+		Concurrency:   concurrency(fs),
+		Cascade:       fs.DestroyCascade,
+		DeleteWait:    fs.DeleteWait || timeout > 0,
+		DeleteTimeout: helmTimeoutSeconds(timeout),
 	}
 }
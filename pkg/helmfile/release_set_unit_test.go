@@ -1,10 +1,20 @@
 package helmfile
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/helmfile/helmfile/pkg/app"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"gopkg.in/yaml.v2"
 )
 
 func TestStripRepositoriesSection(t *testing.T) {
@@ -128,7 +138,7 @@ func TestGoTemplateFileExtension(t *testing.T) {
 			fs := &ReleaseSet{
 				Content:           "test: content",
 				WorkingDirectory:  tempDir,
-				Kubeconfig:        "/tmp/kubeconfig",
+				Kubeconfig:        writeTestKubeconfig(t),
 				EnableGoTemplate:  tt.enableGoTemplate,
 				Bin:               "helmfile",  // Set binary name
 				HelmBin:           "helm",      // Set helm binary name
@@ -175,7 +185,7 @@ releases:
 	fs := &ReleaseSet{
 		Content:          testContent,
 		WorkingDirectory: tempDir,
-		Kubeconfig:       "/tmp/kubeconfig",
+		Kubeconfig:       writeTestKubeconfig(t),
 		EnableGoTemplate: true,
 		Bin:              "helmfile",
 		HelmBin:          "helm",
@@ -233,7 +243,7 @@ func TestDryRunField(t *testing.T) {
 			fs := &ReleaseSet{
 				Content:          "test: content",
 				WorkingDirectory: tempDir,
-				Kubeconfig:       "/tmp/kubeconfig",
+				Kubeconfig:       writeTestKubeconfig(t),
 				DryRun:           tt.dryRun,
 				EnableGoTemplate: tt.enableGoTemplate,
 				Bin:              "helmfile",
@@ -269,3 +279,624 @@ func TestDryRunField(t *testing.T) {
 		})
 	}
 }
+
+// TestIsNothingToDestroy tests the classification of helmfile-destroy failures
+// that are due to the releases being already uninstalled
+func TestIsNothingToDestroy(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *Result
+		err      error
+		expected bool
+	}{
+		{
+			name:     "library executor no matching helmfile error",
+			result:   &Result{ExitCode: 1},
+			err:      fmt.Errorf("running destroy: %w", &app.NoMatchingHelmfileError{}),
+			expected: true,
+		},
+		{
+			name:     "binary executor output with no matching releases",
+			result:   &Result{Output: "err: no releases found that matches specified selector(app=foo) and environment(default), in any helmfile", ExitCode: 1},
+			err:      errors.New("exit status 1"),
+			expected: true,
+		},
+		{
+			name:     "helm release not found",
+			result:   nil,
+			err:      errors.New("uninstall: Release not loaded: myapp: release: not found"),
+			expected: true,
+		},
+		{
+			name:   "every release not found",
+			result: nil,
+			err: errors.New("2 errors:\n" +
+				"err 0: release \"foo\" failed: uninstall: Release not loaded: foo: release: not found\n" +
+				"err 1: release \"bar\" failed: uninstall: Release not loaded: bar: release: not found"),
+			expected: true,
+		},
+		{
+			name: "one release not found and another failed",
+			result: &Result{Output: "Deleting foo\nDeleting bar\nin ./helmfile.yaml: 2 errors:\n" +
+				"err 0: release \"foo\" failed: uninstall: Release not loaded: foo: release: not found\n" +
+				"err 1: release \"bar\" failed: Error: Kubernetes cluster unreachable", ExitCode: 1},
+			err:      errors.New("exit status 1"),
+			expected: false,
+		},
+		{
+			name:     "unrelated error",
+			result:   &Result{Output: "Error: Kubernetes cluster unreachable", ExitCode: 1},
+			err:      errors.New("exit status 1"),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNothingToDestroy(tt.result, tt.err); got != tt.expected {
+				t.Errorf("isNothingToDestroy() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestDeleteReleaseSetNothingToDestroy tests that a destroy finding no releases succeeds and is told apart,
+// unless strict_destroy is set
+func TestDeleteReleaseSetNothingToDestroy(t *testing.T) {
+	noMatching := errors.New("err: no releases found that matches specified selector(tier=backend) and environment(default), in any helmfile")
+
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict_destroy=%v", strict), func(t *testing.T) {
+			f, d := newTempFilesFixture(t)
+			f.fs.StrictDestroy = strict
+
+			executor := &fakeExecutor{destroyErrorsBySelectors: map[string]error{"tier=backend": noMatching}}
+
+			err := DeleteReleaseSet(&sdk.Context{}, f.fs, d, executor)
+			if strict != (err != nil) {
+				t.Fatalf("unexpected error with strict_destroy=%v: %v", strict, err)
+			}
+
+			if f.fs.nothingToDestroy == strict {
+				t.Errorf("expected nothingToDestroy to be %v, got %v", !strict, f.fs.nothingToDestroy)
+			}
+		})
+	}
+}
+
+// TestEffectiveSelectors tests that provider-level default selectors are ANDed into the resource's selectors
+func TestEffectiveSelectors(t *testing.T) {
+	tests := []struct {
+		name             string
+		selector         map[string]interface{}
+		selectors        []interface{}
+		defaultSelectors []string
+		expected         []interface{}
+	}{
+		{
+			name:      "no default selectors",
+			selectors: []interface{}{"app=foo", "app=bar"},
+			expected:  []interface{}{"app=foo", "app=bar"},
+		},
+		{
+			name:             "default selectors without resource selectors",
+			defaultSelectors: []string{"team=x", "tier=backend"},
+			expected:         []interface{}{"team=x,tier=backend"},
+		},
+		{
+			name:             "default selectors ANDed into each resource selector",
+			selectors:        []interface{}{"app=foo", "app=bar"},
+			defaultSelectors: []string{"team=x"},
+			expected:         []interface{}{"app=foo,team=x", "app=bar,team=x"},
+		},
+		{
+			name:             "default selectors ANDed into the selector map",
+			selector:         map[string]interface{}{"app": "foo"},
+			selectors:        []interface{}{"app=bar"},
+			defaultSelectors: []string{"team=x,tier=backend"},
+			expected:         []interface{}{"app=foo,team=x,tier=backend", "app=bar,team=x,tier=backend"},
+		},
+		{
+			name:             "default ANDed into a resource selector with the same key",
+			selectors:        []interface{}{"team!=z,app=foo"},
+			defaultSelectors: []string{"team=x", "tier=backend"},
+			expected:         []interface{}{"team!=z,app=foo,team=x,tier=backend"},
+		},
+		{
+			name:             "default already in the resource selector",
+			selectors:        []interface{}{"team=x,app=foo"},
+			defaultSelectors: []string{"team=x"},
+			expected:         []interface{}{"team=x,app=foo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &ReleaseSet{
+				Selector:         tt.selector,
+				Selectors:        tt.selectors,
+				DefaultSelectors: tt.defaultSelectors,
+			}
+
+			got := effectiveSelectors(fs)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("effectiveSelectors() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestValidateDefaultSelectors tests that the default labels that no release could match along with the labels of the
+// resource's selectors are rejected, while the others are ANDed
+func TestValidateDefaultSelectors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		selector  map[string]interface{}
+		selectors []interface{}
+		conflict  string
+	}{
+		{name: "other keys", selectors: []interface{}{"app=foo"}},
+		{name: "same label", selectors: []interface{}{"team=x,app=foo"}},
+		{name: "inequality of another value", selectors: []interface{}{"team!=y"}},
+		{name: "equality of another value", selectors: []interface{}{"app=foo", "team=y"}, conflict: "team=y"},
+		{name: "inequality of the same value", selectors: []interface{}{"team!=x"}, conflict: "team!=x"},
+		{name: "selector map", selector: map[string]interface{}{"team": "y"}, conflict: "team=y"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &ReleaseSet{
+				Selector:         tt.selector,
+				Selectors:        tt.selectors,
+				DefaultSelectors: []string{"team=x"},
+			}
+
+			err := validateDefaultSelectors(fs)
+			if tt.conflict == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), `"team=x"`) || !strings.Contains(err.Error(), fmt.Sprintf("%q", tt.conflict)) {
+				t.Errorf("expected an error naming the conflicting labels, got %v", err)
+			}
+		})
+	}
+}
+
+// TestSetEffectiveSelectors tests that the selectors passed to helmfile are recorded in the state
+func TestSetEffectiveSelectors(t *testing.T) {
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	fs := &ReleaseSet{Selectors: []interface{}{"app=foo", "app=bar"}, DefaultSelectors: []string{"team=x"}}
+
+	if err := setEffectiveSelectors(d, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := d.Get(KeyEffectiveSelectors), []interface{}{"app=foo,team=x", "app=bar,team=x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected %s: got %v, want %v", KeyEffectiveSelectors, got, want)
+	}
+}
+
+// TestDefaultSelectorsArgs tests that the default selectors never end up as a selector of their own, which helmfile
+// would OR with the resource's selectors
+func TestDefaultSelectorsArgs(t *testing.T) {
+	fs := &ReleaseSet{
+		Bin:              "helmfile",
+		WorkingDirectory: t.TempDir(),
+		Kubeconfig:       writeTestKubeconfig(t),
+		Selector:         map[string]interface{}{"app": "foo"},
+		DefaultSelectors: []string{"team=x"},
+	}
+
+	cmd, err := NewCommandWithKubeconfig(fs, "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var selectors []string
+	for i, a := range cmd.Args {
+		if a == "--selector" {
+			selectors = append(selectors, cmd.Args[i+1])
+		}
+	}
+
+	if want := []string{"app=foo,team=x"}; !reflect.DeepEqual(selectors, want) {
+		t.Errorf("unexpected selectors: got %v, want %v", selectors, want)
+	}
+
+	flags, _, err := binaryGlobalFlags(*buildBaseOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(flags, " "); !strings.Contains(got, "--selector app=foo,team=x") || strings.Count(got, "--selector") != 1 {
+		t.Errorf("unexpected binary executor flags: %s", got)
+	}
+}
+
+// TestApplyDefaultsIgnoreDefaultSelectors tests that ignore_default_selectors opts the resource out of the defaults
+func TestApplyDefaultsIgnoreDefaultSelectors(t *testing.T) {
+	provider := &ProviderInstance{DefaultSelectors: []string{"team=x"}}
+
+	fs := &ReleaseSet{}
+	provider.applyDefaults(fs)
+	if !reflect.DeepEqual(fs.DefaultSelectors, []string{"team=x"}) {
+		t.Errorf("expected default selectors to be applied, got %v", fs.DefaultSelectors)
+	}
+
+	ignored := &ReleaseSet{IgnoreDefaultSelectors: true}
+	provider.applyDefaults(ignored)
+	if len(ignored.DefaultSelectors) != 0 {
+		t.Errorf("expected default selectors to be ignored, got %v", ignored.DefaultSelectors)
+	}
+
+	if h, _ := defaultSelectorsHash(ignored); h != "" {
+		t.Errorf("expected empty hash when default selectors are ignored, got %q", h)
+	}
+	if h, _ := defaultSelectorsHash(fs); h == "" {
+		t.Error("expected non-empty hash when default selectors are applied")
+	}
+}
+
+// TestTruncateOutput tests that long outputs are snipped at a line break with a notice
+func TestTruncateOutput(t *testing.T) {
+	short := "line1\nline2\n"
+	if got := truncateOutput(short, 100, "helmfile-diff output", KeyMaxDiffOutputLen); got != short {
+		t.Errorf("expected short output to be kept as-is, got %q", got)
+	}
+
+	long := strings.Repeat("0123456789\n", 100)
+	got := truncateOutput(long, 300, "helmfile-diff output", KeyMaxDiffOutputLen)
+	if len(got) > 300+1 {
+		t.Errorf("expected output to be at most 301 bytes, got %d", len(got))
+	}
+	if !strings.Contains(got, "helmfile-diff output was too long, and therefore snipped.") {
+		t.Errorf("expected notice in truncated output, got %q", got)
+	}
+
+	// An output without any line break must not make the truncation panic
+	noLineBreak := strings.Repeat("x", 1000)
+	if got := truncateOutput(noLineBreak, 300, "environment_info", KeyMaxDiffOutputLen); !strings.Contains(got, "environment_info was too long") {
+		t.Errorf("expected notice in truncated output, got %q", got)
+	}
+
+	// Nor must it snip a multi-byte rune in the middle
+	multiByte := strings.Repeat("é", 1000)
+	got = truncateOutput(multiByte, 301, "helmfile-diff output", KeyMaxDiffOutputLen)
+	if !utf8.ValidString(got) {
+		t.Errorf("expected valid UTF-8, got %q", got)
+	}
+	if len(got) > 301+1 || !strings.HasPrefix(got, "éé") {
+		t.Errorf("expected the output to be snipped to at most 302 bytes, got %d bytes: %q", len(got), got)
+	}
+}
+
+// TestTemplateOutputOptions tests that the template output options are validated and passed to the executor
+func TestTemplateOutputOptions(t *testing.T) {
+	invalid := &ReleaseSet{TemplateOutputFileTemplate: "{{ .Release.Name }}.yaml"}
+	if err := validateTemplateOutputOptions(invalid); err == nil {
+		t.Error("expected error when template_output_file_template is set without an output directory")
+	}
+
+	fs := &ReleaseSet{
+		TemplateOutputDirTemplate:  "{{ .OutputDir }}/{{ .Release.Namespace }}/{{ .Release.Name }}",
+		TemplateOutputFileTemplate: "{{ .Release.Name }}.yaml",
+	}
+	if err := validateTemplateOutputOptions(fs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	opts := buildTemplateOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})
+	if opts.OutputDirTemplate != fs.TemplateOutputDirTemplate {
+		t.Errorf("expected output dir template %q, got %q", fs.TemplateOutputDirTemplate, opts.OutputDirTemplate)
+	}
+	if opts.OutputFileTemplate != fs.TemplateOutputFileTemplate {
+		t.Errorf("expected output file template %q, got %q", fs.TemplateOutputFileTemplate, opts.OutputFileTemplate)
+	}
+}
+
+// TestScratchDirReadOnlyWorkingDirectory tests the fallback to a temporary directory when the working directory is read-only
+func TestScratchDirReadOnlyWorkingDirectory(t *testing.T) {
+	if !isReadOnlyError(&os.PathError{Op: "open", Path: "x", Err: syscall.EROFS}) {
+		t.Error("expected EROFS to be detected as a read-only error")
+	}
+
+	writable := t.TempDir()
+	dir, err := scratchDir(&ReleaseSet{WorkingDirectory: writable})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dir != writable {
+		t.Errorf("expected the writable working directory %q, got %q", writable, dir)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for root")
+	}
+
+	readOnly := t.TempDir()
+	if err := os.Chmod(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(readOnly, 0755)
+
+	fs := &ReleaseSet{WorkingDirectory: readOnly, Content: "releases: []\n", Values: []interface{}{"foo: bar\n"}}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer files.remove()
+
+	if !filepath.IsAbs(files.Path) || strings.HasPrefix(files.Path, readOnly) {
+		t.Errorf("expected an absolute path outside of the working directory, got %q", files.Path)
+	}
+	if len(files.ValuesFiles) != 1 || !filepath.IsAbs(files.ValuesFiles[0]) {
+		t.Errorf("expected an absolute temporary values file path, got %v", files.ValuesFiles)
+	}
+
+	if _, err := scratchDir(&ReleaseSet{WorkingDirectory: readOnly, RequireWritableWorkingDirectory: true}); err == nil {
+		t.Error("expected error when require_writable_working_directory is set")
+	}
+}
+
+// TestValidateContentSize tests the soft and hard limits on the size of content and values
+func TestValidateContentSize(t *testing.T) {
+	small := strings.Repeat("a", 100)
+	large := strings.Repeat("a", 1000)
+
+	if err := validateContentSize(&ReleaseSet{Content: large, Values: []interface{}{small}}, 500, 2000); err != nil {
+		t.Errorf("expected only a warning above the soft limit, got %v", err)
+	}
+
+	err := validateContentSize(&ReleaseSet{Content: small, Values: []interface{}{small, large}}, 100, 500)
+	if err == nil || !strings.Contains(err.Error(), "values[1]") {
+		t.Errorf("expected error naming values[1] above the hard limit, got %v", err)
+	}
+
+	if err := validateContentSize(&ReleaseSet{Content: large}, 0, 0); err != nil {
+		t.Errorf("expected no error when the limits are disabled, got %v", err)
+	}
+
+	if got := maxOutputLen(&ReleaseSet{}); got != DefaultMaxOutputLen {
+		t.Errorf("expected default max output len %d, got %d", DefaultMaxOutputLen, got)
+	}
+}
+
+// TestReleasesValuesString tests that releases_values_string keeps values typed as strings and wins over releases_values
+func TestReleasesValuesString(t *testing.T) {
+	fs := &ReleaseSet{
+		ReleasesValues: map[string]interface{}{
+			"image.tag":    "1.19",
+			"replicaCount": "2",
+		},
+		ReleasesValuesString: map[string]interface{}{
+			"image.tag":         "1.20",
+			"feature.enabled":   "true",
+			"annotations.a\\.b": "1.10",
+		},
+	}
+
+	if got, want := setFlagValues(releasesSetValues(fs)), []string{"replicaCount=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected releases_values_string keys to be dropped from --set, got %v, want %v", got, want)
+	}
+
+	files, err := writeReleasesStringValuesFile(t.TempDir(), "", fs.ReleasesValuesString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("expected one values file, got %v", files)
+	}
+
+	bs, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values map[string]map[string]interface{}
+	if err := yaml.Unmarshal(bs, &values); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := values["image"]["tag"]; got != "1.20" {
+		t.Errorf("expected image.tag to be the string 1.20, got %#v", got)
+	}
+
+	if got := values["feature"]["enabled"]; got != "true" {
+		t.Errorf("expected feature.enabled to be the string true, got %#v", got)
+	}
+
+	if got := values["annotations"]["a.b"]; got != "1.10" {
+		t.Errorf("expected annotations.a\\.b to be the string 1.10, got %#v", got)
+	}
+
+	if files, err := writeReleasesStringValuesFile(t.TempDir(), "", nil); err != nil || files != nil {
+		t.Errorf("expected no values file without releases_values_string, got %v, %v", files, err)
+	}
+}
+
+// TestReleaseSetID tests the generation and validation of the ID per id_scheme
+func TestReleaseSetID(t *testing.T) {
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{KeyContent: "releases: []\n"}}
+
+	if id, err := releaseSetID(d, &ReleaseSet{IDScheme: IDSchemeName, Name: "mystack"}); err != nil || id != "mystack" {
+		t.Errorf("expected the name as the ID, got %q, %v", id, err)
+	}
+
+	first, err := releaseSetID(d, &ReleaseSet{IDScheme: IDSchemeContentHash, Content: "releases: []\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := releaseSetID(d, &ReleaseSet{IDScheme: IDSchemeContentHash, Content: "releases: []\n"})
+	if first != second || len(first) != 16 {
+		t.Errorf("expected a stable content hash ID, got %q and %q", first, second)
+	}
+
+	if random, err := releaseSetID(d, &ReleaseSet{IDScheme: IDSchemeRandom}); err != nil || random == "" || random == first {
+		t.Errorf("expected a random ID, got %q, %v", random, err)
+	}
+
+	want, _ := inputsID(d.Get)
+	if id, err := releaseSetID(d, &ReleaseSet{}); err != nil || id != want || len(id) != 16 {
+		t.Errorf("expected the ID derived from the inputs by default, got %q, %v", id, err)
+	}
+
+	for _, fs := range []*ReleaseSet{
+		{IDScheme: IDSchemeName},
+		{IDScheme: IDSchemeContentHash},
+		{IDScheme: "uuid"},
+	} {
+		if _, err := releaseSetID(d, fs); err == nil {
+			t.Errorf("expected error for %+v", fs)
+		}
+	}
+}
+
+// TestInputsID tests that the ID derived from the inputs is stable regardless of the order of the attributes,
+// and differs between release sets that deploy different helmfiles or values, or to different places
+func TestInputsID(t *testing.T) {
+	inputs := func(overrides map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{
+			KeyWorkingDirectory: "infra/apps",
+			KeyContent:          "releases: []\n",
+			KeyEnvironment:      "prod",
+			KeyKubeconfig:       "/home/me/.kube/config",
+			KeySelectors:        []interface{}{"tier=frontend", "tier=backend"},
+			KeySelector:         map[string]interface{}{"app": "web", "team": "core"},
+		}
+		for k, v := range overrides {
+			m[k] = v
+		}
+		return m
+	}
+
+	id := func(raw map[string]interface{}) string {
+		t.Helper()
+		id, err := inputsID((&ResourceReadWriteEmbedded{m: raw}).Get)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	base := id(inputs(nil))
+
+	for name, raw := range map[string]map[string]interface{}{
+		"selectors reordered": inputs(map[string]interface{}{KeySelectors: []interface{}{"tier=backend", "tier=frontend"}}),
+		"selector reordered":  inputs(map[string]interface{}{KeySelector: map[string]interface{}{"team": "core", "app": "web"}}),
+		"unrelated attribute": inputs(map[string]interface{}{KeyConcurrency: 3}),
+		"empty values":        inputs(map[string]interface{}{KeyValues: []interface{}{}, KeyStateValues: map[string]interface{}{}}),
+	} {
+		if got := id(raw); got != base {
+			t.Errorf("%s: expected the ID %s to be stable, got %s", name, base, got)
+		}
+	}
+
+	eks := id(inputs(map[string]interface{}{KeyKubeconfig: "", KeyEKSClusterName: "prod"}))
+	if got := id(inputs(map[string]interface{}{KeyKubeconfig: "/tmp/" + temporaryKubeconfigPrefix + "abc", KeyEKSClusterName: "prod"})); got != eks {
+		t.Errorf("expected the temporary kubeconfig of the EKS cluster not to change the ID, got %s and %s", eks, got)
+	}
+
+	// Release sets that differ only in their values must not share the scratch directory and the temporary files
+	first := id(inputs(map[string]interface{}{KeyValues: []interface{}{"replicas: 1\n"}}))
+	second := id(inputs(map[string]interface{}{KeyValues: []interface{}{"replicas: 2\n"}}))
+	if first == second {
+		t.Errorf("expected the release sets that differ only in values to have different IDs, got %s for both", first)
+	}
+
+	seen := map[string]string{"base": base, "eks": eks}
+	for name, raw := range map[string]map[string]interface{}{
+		"working directory": inputs(map[string]interface{}{KeyWorkingDirectory: "infra/platform"}),
+		"content":           inputs(map[string]interface{}{KeyContent: "releases:\n- name: web\n"}),
+		"path":              inputs(map[string]interface{}{KeyPath: "helmfile.yaml"}),
+		"environment":       inputs(map[string]interface{}{KeyEnvironment: "staging"}),
+		"selectors":         inputs(map[string]interface{}{KeySelectors: []interface{}{"tier=frontend"}}),
+		"selector":          inputs(map[string]interface{}{KeySelector: map[string]interface{}{"app": "api"}}),
+		"kubeconfig":        inputs(map[string]interface{}{KeyKubeconfig: "/home/me/.kube/staging"}),
+		"cluster endpoint":  inputs(map[string]interface{}{KeyKubeconfig: "", KeyClusterEndpoint: "https://example.com"}),
+		"values":            inputs(map[string]interface{}{KeyValues: []interface{}{"replicas: 3\n"}}),
+		"releases values":   inputs(map[string]interface{}{KeyReleasesValues: map[string]interface{}{"replicas": "3"}}),
+		"state values":      inputs(map[string]interface{}{KeyStateValues: map[string]interface{}{"cluster.name": "prod"}}),
+		"set":               inputs(map[string]interface{}{KeySet: []interface{}{map[string]interface{}{KeySetName: "replicas", KeySetValue: "3"}}}),
+	} {
+		got := id(raw)
+		for other, otherID := range seen {
+			if got == otherID {
+				t.Errorf("expected the release sets with different %s and %s to have different IDs, got %s for both", name, other, got)
+			}
+		}
+		seen[name] = got
+	}
+}
+
+// TestUpgradeReleaseSetStateV0 tests that the IDs are kept, and that release sets created before id_scheme existed
+// record the random scheme their IDs were generated with
+func TestUpgradeReleaseSetStateV0(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		scheme interface{}
+		want   string
+	}{
+		{name: "unset", scheme: nil, want: IDSchemeRandom},
+		{name: "empty", scheme: "", want: IDSchemeRandom},
+		{name: "inputs", scheme: IDSchemeInputs, want: IDSchemeInputs},
+		{name: "explicit random", scheme: IDSchemeRandom, want: IDSchemeRandom},
+		{name: "name", scheme: IDSchemeName, want: IDSchemeName},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			state := map[string]interface{}{
+				"id":                "bq0c7rlp1dmfcg8pjrt0",
+				KeyIDScheme:         tt.scheme,
+				KeyWorkingDirectory: "infra/apps",
+				KeyContent:          "releases: []\n",
+			}
+
+			upgraded, err := upgradeReleaseSetStateV0(state, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if upgraded["id"] != "bq0c7rlp1dmfcg8pjrt0" {
+				t.Errorf("expected the ID to be kept, got %v", upgraded["id"])
+			}
+
+			if upgraded[KeyIDScheme] != tt.want {
+				t.Errorf("expected the id_scheme %s, got %v", tt.want, upgraded[KeyIDScheme])
+			}
+		})
+	}
+}
+
+// TestSuppressIDSchemeChange tests that id_scheme can be chosen on create, while its changes on existing release sets
+// are ignored, as they keep their ID
+func TestSuppressIDSchemeChange(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{KeyIDScheme: IDSchemeInputs})
+
+	if suppressIDSchemeChange(KeyIDScheme, "", IDSchemeInputs, d) {
+		t.Error("expected id_scheme not to be suppressed on create")
+	}
+
+	d.SetId("bq0c7rlp1dmfcg8pjrt0")
+
+	if !suppressIDSchemeChange(KeyIDScheme, IDSchemeRandom, IDSchemeInputs, d) {
+		t.Error("expected the change of id_scheme on an existing release set to be suppressed")
+	}
+}
+
+func TestScratchFileName(t *testing.T) {
+	sum := []byte{0xab, 0xcd}
+
+	if got := scratchFileName("helmfile", "", sum, ".yaml"); got != "helmfile-abcd.yaml" {
+		t.Errorf("unexpected file name without an ID: %s", got)
+	}
+
+	if got := scratchFileName("temp.values", "0123456789abcdef", sum, ".yaml"); got != "temp.values-0123456789abcdef-abcd.yaml" {
+		t.Errorf("unexpected file name with an ID: %s", got)
+	}
+
+	if got := scratchFileName("helmfile", "team/app stack", sum, ".yaml.gotmpl"); got != "helmfile-team_app_stack-abcd.yaml.gotmpl" {
+		t.Errorf("expected the unsafe characters of the ID to be replaced, got %s", got)
+	}
+}
@@ -0,0 +1,222 @@
+package helmfile
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// ReleaseActionInstall is the action of a release that wasn't installed in the cluster
+	ReleaseActionInstall = "install"
+
+	// ReleaseActionUpgrade is the action of an installed release with changes
+	ReleaseActionUpgrade = "upgrade"
+
+	// ReleaseActionNone is the action of a release without changes
+	ReleaseActionNone = "none"
+)
+
+var (
+	// upgradingReleasePattern matches the line that helmfile prints before it runs helm upgrade --install on a release
+	upgradingReleasePattern = regexp.MustCompile(`^Upgrading release=([^,]*), chart=([^,]*)(?:, namespace=(.*))?$`)
+
+	// installingReleasePattern matches the line that helm upgrade --install prints for a release that wasn't installed
+	installingReleasePattern = regexp.MustCompile(`^Release "(.*)" does not exist\. Installing it now\.$`)
+)
+
+// diffSectionEnds are the lines that helmfile apply prints after the helm-diff output of the releases it compared
+var diffSectionEnds = []string{"\nAffected releases are:", "\nNo affected releases", "\nUpgrading release="}
+
+// releaseNotPresentLine is printed by helm-diff for a release that isn't installed, before its whole contents as added
+const releaseNotPresentLine = "Release was not present in Helm."
+
+// releaseStatus is the outcome of the last apply for a release
+type releaseStatus struct {
+	Name      string
+	Namespace string
+	Chart     string
+	Version   string
+	Changed   bool
+	Action    string
+}
+
+// parseReleaseStatus parses the output of helmfile apply or sync into the status of each release, in the order helmfile
+// printed them. helmfile apply prints the helm-diff output of every release it compares, which tells the releases
+// without changes and the ones that weren't installed. The releases it upgrades are told by the Upgrading lines and the
+// UPDATED RELEASES table, which has the chart version. helmfile sync prints no diff, so only the upgraded releases are known.
+func parseReleaseStatus(output string) []releaseStatus {
+	var statuses []releaseStatus
+
+	// find returns the status of the release, matching any namespace when the namespace is unknown
+	find := func(name, namespace string) *releaseStatus {
+		for i := range statuses {
+			s := &statuses[i]
+			if s.Name != name {
+				continue
+			}
+
+			if namespace == "" || s.Namespace == "" || s.Namespace == namespace {
+				if s.Namespace == "" {
+					s.Namespace = namespace
+				}
+				return s
+			}
+		}
+
+		statuses = append(statuses, releaseStatus{Name: name, Namespace: namespace, Action: ReleaseActionNone})
+
+		return &statuses[len(statuses)-1]
+	}
+
+	changed := func(s *releaseStatus) {
+		s.Changed = true
+		if s.Action == ReleaseActionNone {
+			s.Action = ReleaseActionUpgrade
+		}
+	}
+
+	// The lines following the diff would otherwise be taken as the changes of the last release compared
+	diff := output
+	for _, end := range diffSectionEnds {
+		if i := strings.Index(diff, end); i >= 0 {
+			diff = diff[:i]
+		}
+	}
+
+	for _, r := range parseDiff(diff) {
+		s := find(r.Name, r.Namespace)
+		s.Chart = r.Chart
+
+		if !r.changed() {
+			continue
+		}
+
+		changed(s)
+
+		for _, l := range r.Lines {
+			if strings.Contains(l, releaseNotPresentLine) {
+				s.Action = ReleaseActionInstall
+				break
+			}
+		}
+	}
+
+	for _, l := range strings.Split(output, "\n") {
+		l = strings.TrimSpace(l)
+
+		if m := upgradingReleasePattern.FindStringSubmatch(l); m != nil {
+			s := find(m[1], m[3])
+			s.Chart = m[2]
+			changed(s)
+		} else if m := installingReleasePattern.FindStringSubmatch(l); m != nil {
+			s := find(m[1], "")
+			s.Changed = true
+			s.Action = ReleaseActionInstall
+		}
+	}
+
+	for _, r := range parseUpdatedReleases(output) {
+		s := find(r.Name, r.Namespace)
+		if r.Chart != "" {
+			s.Chart = r.Chart
+		}
+		s.Version = r.Version
+		changed(s)
+	}
+
+	return statuses
+}
+
+// tableColumnPattern matches the column names in the header of the tables printed by helmfile
+var tableColumnPattern = regexp.MustCompile(`\S+`)
+
+// parseUpdatedReleases parses the rows of the UPDATED RELEASES tables printed by helmfile apply and sync.
+// The columns are sliced at the offsets of the column names in the header, as a release without a version leaves
+// its cell blank, and helmfile versions before 0.144 print no NAMESPACE column.
+func parseUpdatedReleases(output string) []releaseStatus {
+	var (
+		releases []releaseStatus
+		columns  map[string][2]int
+		inTable  bool
+	)
+
+	for _, l := range strings.Split(output, "\n") {
+		t := strings.TrimSpace(l)
+
+		if strings.HasSuffix(t, " RELEASES:") {
+			inTable = t == "UPDATED RELEASES:"
+			columns = nil
+			continue
+		}
+
+		if !inTable {
+			continue
+		}
+
+		if t == "" {
+			if columns != nil {
+				inTable = false
+			}
+			continue
+		}
+
+		if columns == nil {
+			columns = map[string][2]int{}
+			locs := tableColumnPattern.FindAllStringIndex(l, -1)
+			for i, loc := range locs {
+				end := -1
+				if i+1 < len(locs) {
+					end = locs[i+1][0]
+				}
+				columns[l[loc[0]:loc[1]]] = [2]int{loc[0], end}
+			}
+			continue
+		}
+
+		column := func(name string) string {
+			c, ok := columns[name]
+			if !ok || c[0] >= len(l) {
+				return ""
+			}
+			if c[1] < 0 || c[1] > len(l) {
+				return strings.TrimSpace(l[c[0]:])
+			}
+			return strings.TrimSpace(l[c[0]:c[1]])
+		}
+
+		releases = append(releases, releaseStatus{
+			Name:      column("NAME"),
+			Namespace: column("NAMESPACE"),
+			Chart:     column("CHART"),
+			Version:   column("VERSION"),
+		})
+	}
+
+	return releases
+}
+
+func releaseStatusList(statuses []releaseStatus) []interface{} {
+	list := make([]interface{}, 0, len(statuses))
+
+	for _, s := range statuses {
+		list = append(list, map[string]interface{}{
+			KeyReleaseName:      s.Name,
+			KeyReleaseNamespace: s.Namespace,
+			KeyReleaseChart:     s.Chart,
+			KeyReleaseVersion:   s.Version,
+			KeyReleaseChanged:   s.Changed,
+			KeyReleaseAction:    s.Action,
+		})
+	}
+
+	return list
+}
+
+// setReleaseStatus sets release_status to the status of each release parsed from the output of the apply
+func setReleaseStatus(d ResourceReadWrite, result *Result) {
+	if result == nil {
+		return
+	}
+
+	d.Set(KeyReleaseStatus, releaseStatusList(parseReleaseStatus(result.Output)))
+}
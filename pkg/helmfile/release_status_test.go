@@ -0,0 +1,71 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseReleaseStatus(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []releaseStatus
+	}{
+		{
+			fixture: "helmfile_apply.txt",
+			want: []releaseStatus{
+				{Name: "my.app", Namespace: "team-a", Chart: "oci://registry.example.com/charts/app", Version: "1.2.3", Changed: true, Action: ReleaseActionInstall},
+				{Name: "api", Namespace: "backend", Chart: "charts/api", Changed: true, Action: ReleaseActionUpgrade},
+				{Name: "db", Namespace: "backend", Chart: "bitnami/postgresql", Action: ReleaseActionNone},
+			},
+		},
+		{
+			fixture: "helmfile_sync_legacy.txt",
+			want: []releaseStatus{
+				{Name: "web", Chart: "charts/web", Version: "2.0.0", Changed: true, Action: ReleaseActionUpgrade},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			bs, err := ioutil.ReadFile(filepath.Join("testdata", "release_status", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := parseReleaseStatus(string(bs)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected release status:\nwant: %+v\ngot:  %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseReleaseStatusReleasesOfSameName(t *testing.T) {
+	output := `Comparing release=app, chart=charts/app, namespace=staging
+Comparing release=app, chart=charts/app, namespace=prod
+prod, app, ConfigMap (v1) has changed:
+-   key: a
++   key: b
+
+Upgrading release=app, chart=charts/app, namespace=prod
+
+UPDATED RELEASES:
+NAME   NAMESPACE   CHART        VERSION   DURATION
+app    prod        charts/app   0.1.0           1s
+`
+
+	want := []releaseStatus{
+		{Name: "app", Namespace: "staging", Chart: "charts/app", Action: ReleaseActionNone},
+		{Name: "app", Namespace: "prod", Chart: "charts/app", Version: "0.1.0", Changed: true, Action: ReleaseActionUpgrade},
+	}
+
+	if got := parseReleaseStatus(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected release status:\nwant: %+v\ngot:  %+v", want, got)
+	}
+
+	if got := parseReleaseStatus(""); len(got) != 0 {
+		t.Errorf("expected no release status for no output, got %+v", got)
+	}
+}
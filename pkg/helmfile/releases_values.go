@@ -0,0 +1,103 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// releasesSetValues returns releases_values without the keys that are also in releases_values_string.
+//
+// releases_values is passed as --set, which helm merges after --values. Dropping the duplicated keys here
+// lets releases_values_string, which is passed as a values file, take precedence in both the binary and library modes.
+func releasesSetValues(fs *ReleaseSet) map[string]interface{} {
+	stringValues := releasesStringValues(fs)
+	if len(stringValues) == 0 {
+		return fs.ReleasesValues
+	}
+
+	values := map[string]interface{}{}
+	for k, v := range fs.ReleasesValues {
+		if _, overridden := stringValues[k]; !overridden {
+			values[k] = v
+		}
+	}
+
+	return values
+}
+
+// setFlagValues returns the values as sorted key=value pairs for helm --set
+func setFlagValues(values map[string]interface{}) []string {
+	var set []string
+	for _, k := range sortedKeys(values) {
+		set = append(set, fmt.Sprintf("%s=%s", k, values[k]))
+	}
+	return set
+}
+
+// releasesStringValuesYAML renders releases_values_string as a helm values file in which every value is a string,
+// so that values like "1.20" and "true" are not coerced into numbers and booleans as they are with --set.
+// Keys are dot-separated paths like with --set-string, where "\." escapes a literal dot.
+func releasesStringValuesYAML(values map[string]interface{}) (string, error) {
+	root := map[string]interface{}{}
+
+	for _, k := range sortedKeys(values) {
+		path := splitValuesKeyPath(k)
+
+		m := root
+		for _, p := range path[:len(path)-1] {
+			child, ok := m[p].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				m[p] = child
+			}
+			m = child
+		}
+
+		m[path[len(path)-1]] = fmt.Sprintf("%v", values[k])
+	}
+
+	bs, err := yaml.Marshal(root)
+	if err != nil {
+		return "", fmt.Errorf("marshaling releases_values_string: %w", err)
+	}
+
+	return string(bs), nil
+}
+
+// splitValuesKeyPath splits a --set-string key like a.b\.c into its path elements a and b.c
+func splitValuesKeyPath(key string) []string {
+	var path []string
+
+	var cur strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key) && key[i+1] == '.':
+			cur.WriteByte('.')
+			i++
+		case key[i] == '.':
+			path = append(path, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(key[i])
+		}
+	}
+
+	return append(path, cur.String())
+}
+
+// writeReleasesStringValuesFile writes releases_values_string to a temporary values file in dir
+// and returns its path, or nothing when releases_values_string is empty
+func writeReleasesStringValuesFile(dir, id string, values map[string]interface{}) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	content, err := releasesStringValuesYAML(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeTempValuesFiles(dir, id, []interface{}{content})
+}
@@ -135,18 +135,23 @@ func sanitizePath(s string) string {
 func RewriteHelmfileContent(content string, baseDir string) (string, []string, error) {
 	var cleanupDirs []string
 
-	// Ensure the base directory for clones exists
-	kustomizeDir := filepath.Join(baseDir, ".kustomize-cache")
-	if err := os.MkdirAll(kustomizeDir, 0755); err != nil {
-		return content, nil, fmt.Errorf("creating kustomize cache dir: %w", err)
-	}
-
 	// Find chart references that look like git URLs
 	// We look for patterns like:
 	//   chart: github.com/org/repo/path?ref=tag
 	// in YAML content
 	chartPattern := regexp.MustCompile(`(?m)(chart:\s*)((?:github\.com|gitlab\.com|bitbucket\.org)/[^\s#]+\?ref=[^\s#]+)`)
 
+	// The cache is not created in the working directory of the content without remote references
+	if !chartPattern.MatchString(content) {
+		return content, nil, nil
+	}
+
+	// Ensure the base directory for clones exists
+	kustomizeDir := filepath.Join(baseDir, ".kustomize-cache")
+	if err := os.MkdirAll(kustomizeDir, 0755); err != nil {
+		return content, nil, fmt.Errorf("creating kustomize cache dir: %w", err)
+	}
+
 	modified := chartPattern.ReplaceAllStringFunc(content, func(match string) string {
 		submatches := chartPattern.FindStringSubmatch(match)
 		if len(submatches) < 3 {
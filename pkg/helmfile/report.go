@@ -0,0 +1,500 @@
+package helmfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReportFormatJUnit = "junit"
+	ReportFormatJSON  = "json"
+)
+
+const (
+	ReleaseOutcomePassed  = "passed"
+	ReleaseOutcomeFailed  = "failed"
+	ReleaseOutcomeSkipped = "skipped"
+)
+
+// releaseOutcome is the outcome of a release in the report, which is a test case in the JUnit format
+type releaseOutcome struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+}
+
+func (o releaseOutcome) ID() string {
+	return listedRelease{Name: o.Name, Namespace: o.Namespace}.ID()
+}
+
+// releaseReport is the report of the release outcomes of an operation on a resource
+type releaseReport struct {
+	ResourceType string           `json:"resource_type"`
+	ID           string           `json:"id"`
+	Operation    string           `json:"operation"`
+	Timestamp    time.Time        `json:"timestamp"`
+	Releases     []releaseOutcome `json:"releases"`
+}
+
+// recordedApply is a call to HelmfileExecutor.Apply or Sync recorded by recordingExecutor
+type recordedApply struct {
+	selectors []interface{}
+	result    *Result
+	err       error
+}
+
+// recordingExecutor records the applies and the releases they matched for the report_file.
+//
+// The releases are listed right after each apply, while the helmfile file of the apply still exists.
+// With continue_on_error, each apply matches a single release.
+type recordingExecutor struct {
+	HelmfileExecutor
+
+	mu      sync.Mutex
+	applies []recordedApply
+	all     []listedRelease
+	matched map[string]listedRelease
+	listErr error
+}
+
+func newRecordingExecutor(executor HelmfileExecutor) *recordingExecutor {
+	return &recordingExecutor{
+		HelmfileExecutor: executor,
+		matched:          map[string]listedRelease{},
+	}
+}
+
+func (e *recordingExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	result, err := e.HelmfileExecutor.Apply(ctx, opts)
+
+	return e.record(ctx, opts.BaseOptions, result, err)
+}
+
+func (e *recordingExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
+	result, err := e.HelmfileExecutor.Sync(ctx, opts)
+
+	return e.record(ctx, opts.BaseOptions, result, err)
+}
+
+// record records the apply or sync with the options and lists the releases it matched
+func (e *recordingExecutor) record(ctx context.Context, opts BaseOptions, result *Result, err error) (*Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.applies = append(e.applies, recordedApply{selectors: opts.Selectors, result: result, err: err})
+
+	if e.listErr != nil {
+		return result, err
+	}
+
+	matched, all, listErr := listReportReleases(ctx, e.HelmfileExecutor, opts, e.all == nil)
+	if listErr != nil {
+		e.listErr = listErr
+		return result, err
+	}
+
+	if e.all == nil {
+		e.all = all
+	}
+
+	for _, r := range matched {
+		e.matched[r.ID()] = r
+	}
+
+	return result, err
+}
+
+// reportingExecutor wraps the executor with a recordingExecutor when report_file is set.
+// The returned recorder is nil otherwise.
+func reportingExecutor(fs *ReleaseSet, executor HelmfileExecutor) (HelmfileExecutor, *recordingExecutor) {
+	if fs.ReportFile == "" {
+		return executor, nil
+	}
+
+	recorder := newRecordingExecutor(executor)
+
+	return recorder, recorder
+}
+
+// listReportReleases lists the releases matching the selectors of the options, and all the releases when listAll is true
+func listReportReleases(ctx context.Context, executor HelmfileExecutor, base BaseOptions, listAll bool) ([]listedRelease, []listedRelease, error) {
+	list := func(base BaseOptions) ([]listedRelease, error) {
+		result, err := executor.List(ctx, &ListOptions{BaseOptions: base})
+		if err != nil {
+			return nil, fmt.Errorf("listing releases: %w", err)
+		}
+		return parseReleaseList(result.Output)
+	}
+
+	matched, err := list(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !listAll {
+		return matched, nil, nil
+	}
+
+	base.Selector = nil
+	base.Selectors = nil
+
+	all, err := list(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return matched, all, nil
+}
+
+// applyOutcomes derives the outcome of each release from the recorded applies.
+//
+// A release failed when it is in a FAILED RELEASES table or its own apply failed with continue_on_error.
+// When the whole apply failed without telling which releases failed, the releases it didn't update are failed too.
+// The other matched releases passed, and the releases that weren't matched by the selectors were skipped.
+func (e *recordingExecutor) applyOutcomes() []releaseOutcome {
+	var (
+		output     strings.Builder
+		failedWith = map[string]string{}
+		applyErr   error
+	)
+
+	for _, a := range e.applies {
+		if a.result != nil {
+			output.WriteString(a.result.Output + "\n")
+		}
+
+		if a.err == nil {
+			continue
+		}
+
+		if len(a.selectors) == 1 {
+			if id, ok := selectorReleaseID(fmt.Sprint(a.selectors[0])); ok {
+				failedWith[id] = a.err.Error()
+				continue
+			}
+		}
+
+		applyErr = a.err
+	}
+
+	tables := parseReleaseTables(output.String())
+
+	return releaseOutcomes(e.all, e.matched, func(id string) (string, string) {
+		if msg, ok := failedWith[id]; ok {
+			return ReleaseOutcomeFailed, msg
+		}
+
+		if tables["FAILED"][id] {
+			if applyErr != nil {
+				return ReleaseOutcomeFailed, applyErr.Error()
+			}
+			return ReleaseOutcomeFailed, "release failed"
+		}
+
+		if tables["UPDATED"][id] || tables["DELETED"][id] {
+			return ReleaseOutcomePassed, "applied"
+		}
+
+		if applyErr != nil {
+			return ReleaseOutcomeFailed, "not applied: " + applyErr.Error()
+		}
+
+		return ReleaseOutcomePassed, "unchanged"
+	})
+}
+
+// diffOutcomes derives the outcome of each release from the diff on plan.
+// The releases pass with their pending changes noted, or fail with the error of the diff.
+func diffOutcomes(all []listedRelease, matched map[string]listedRelease, diff string, diffErr error) []releaseOutcome {
+	changed := map[string]bool{}
+	for _, r := range parseDiff(diff) {
+		if r.changed() {
+			changed[listedRelease{Name: r.Name, Namespace: r.Namespace}.ID()] = true
+		}
+	}
+
+	return releaseOutcomes(all, matched, func(id string) (string, string) {
+		if diffErr != nil {
+			return ReleaseOutcomeFailed, diffErr.Error()
+		}
+
+		if changed[id] {
+			return ReleaseOutcomePassed, "changes pending"
+		}
+
+		return ReleaseOutcomePassed, "unchanged"
+	})
+}
+
+// releaseOutcomes returns the outcomes of all the releases sorted by ID, skipping the ones that weren't matched
+func releaseOutcomes(all []listedRelease, matched map[string]listedRelease, outcome func(id string) (string, string)) []releaseOutcome {
+	releases := map[string]listedRelease{}
+	for _, r := range all {
+		releases[r.ID()] = r
+	}
+	for id, r := range matched {
+		releases[id] = r
+	}
+
+	ids := make([]string, 0, len(releases))
+	for id := range releases {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	outcomes := make([]releaseOutcome, 0, len(ids))
+
+	for _, id := range ids {
+		r := releases[id]
+		o := releaseOutcome{Name: r.Name, Namespace: r.Namespace}
+
+		if _, ok := matched[id]; ok {
+			o.Status, o.Message = outcome(id)
+		} else {
+			o.Status, o.Message = ReleaseOutcomeSkipped, "not matched by the selectors"
+		}
+
+		outcomes = append(outcomes, o)
+	}
+
+	return outcomes
+}
+
+// selectorReleaseID returns the ID of the release that the selector built by listedRelease.Selector matches
+func selectorReleaseID(selector string) (string, bool) {
+	var r listedRelease
+
+	for _, kv := range strings.Split(selector, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return "", false
+		}
+
+		switch parts[0] {
+		case "name":
+			r.Name = parts[1]
+		case "namespace":
+			r.Namespace = parts[1]
+		default:
+			return "", false
+		}
+	}
+
+	if r.Name == "" {
+		return "", false
+	}
+
+	return r.ID(), true
+}
+
+// reportPath namespaces the report_file by the resource, as the plugin SDK doesn't tell the provider the address of the resource.
+// For example, reports/helmfile.xml becomes reports/helmfile.helmfile_release_set.<id>.xml.
+func reportPath(reportFile, resourceType, id string) string {
+	ext := filepath.Ext(reportFile)
+
+	return fmt.Sprintf("%s.%s.%s%s", strings.TrimSuffix(reportFile, ext), resourceType, id, ext)
+}
+
+// reportID identifies the release set in the report. A release set that isn't created yet has no ID,
+// so it is identified by its name, or the hash of its content like the content-hash id_scheme.
+func reportID(id string, fs *ReleaseSet) string {
+	if id != "" {
+		return id
+	}
+
+	if fs.Name != "" {
+		return fs.Name
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fs.Content)))[:16]
+}
+
+// reportApply writes the report of the applies recorded by the recorder, which is nil when report_file is not set
+func reportApply(fs *ReleaseSet, recorder *recordingExecutor, id, operation string) {
+	if recorder == nil || len(recorder.applies) == 0 {
+		return
+	}
+
+	report := releaseReport{
+		ResourceType: resourceTypeReleaseSet,
+		ID:           reportID(id, fs),
+		Operation:    operation,
+		Timestamp:    time.Now(),
+	}
+
+	if recorder.listErr != nil {
+		logf("Warning: failed to write the %s report of %s.%s: %v", operation, report.ResourceType, report.ID, recorder.listErr)
+		return
+	}
+
+	report.Releases = recorder.applyOutcomes()
+
+	writeReport(fs, report)
+}
+
+// reportDiff writes the report of the diff on plan when report_on_plan is enabled
+func reportDiff(fs *ReleaseSet, executor HelmfileExecutor, id, diff string, diffErr error) {
+	if fs.ReportFile == "" || !fs.ReportOnPlan {
+		return
+	}
+
+	report := releaseReport{
+		ResourceType: resourceTypeReleaseSet,
+		ID:           reportID(id, fs),
+		Operation:    operationDiff,
+		Timestamp:    time.Now(),
+	}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		logf("Warning: failed to write the %s report of %s.%s: preparing helmfile file: %v", operationDiff, report.ResourceType, report.ID, err)
+		return
+	}
+	defer files.remove()
+
+	matched, all, err := listReportReleases(context.Background(), executor, *buildBaseOptions(fs, files), true)
+	if err != nil {
+		logf("Warning: failed to write the %s report of %s.%s: %v", operationDiff, report.ResourceType, report.ID, err)
+		return
+	}
+
+	matchedByID := map[string]listedRelease{}
+	for _, r := range matched {
+		matchedByID[r.ID()] = r
+	}
+
+	report.Releases = diffOutcomes(all, matchedByID, diff, diffErr)
+
+	writeReport(fs, report)
+}
+
+// writeReport writes the report to the report_file of the release set.
+// Failing to write it only logs a warning, as it must never fail the operation.
+func writeReport(fs *ReleaseSet, report releaseReport) {
+	if err := writeReportFile(fs.ReportFile, fs.ReportFormat, report); err != nil {
+		logf("Warning: failed to write the %s report of %s.%s to %s: %v", report.Operation, report.ResourceType, report.ID, fs.ReportFile, err)
+	}
+}
+
+func writeReportFile(reportFile, format string, report releaseReport) error {
+	var (
+		bs  []byte
+		err error
+	)
+
+	switch format {
+	case ReportFormatJSON:
+		bs, err = json.MarshalIndent(report, "", "  ")
+	default:
+		bs, err = junitReport(report)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(reportPath(reportFile, report.ResourceType, report.ID), append(bs, '\n'))
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitReport renders the report as a JUnit test suite named after the resource and the operation, with a test case per release
+func junitReport(report releaseReport) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:      fmt.Sprintf("%s.%s %s", report.ResourceType, report.ID, report.Operation),
+		Tests:     len(report.Releases),
+		Timestamp: report.Timestamp.UTC().Format(time.RFC3339),
+	}
+
+	for _, r := range report.Releases {
+		c := junitTestCase{
+			ClassName: report.ResourceType + "." + report.ID,
+			Name:      r.ID(),
+		}
+
+		switch r.Status {
+		case ReleaseOutcomeFailed:
+			suite.Failures++
+			c.Failure = &junitMessage{Message: r.Message}
+		case ReleaseOutcomeSkipped:
+			suite.Skipped++
+			c.Skipped = &junitMessage{Message: r.Message}
+		default:
+			c.SystemOut = r.Message
+		}
+
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	bs, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), bs...), nil
+}
+
+// writeFileAtomic writes the file by renaming a temporary file in the same directory over it,
+// so that readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
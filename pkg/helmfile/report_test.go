@@ -0,0 +1,214 @@
+package helmfile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const reportListAll = `[{"name":"app","namespace":"default"},{"name":"metrics-exporter","namespace":"monitoring"},{"name":"frontend","namespace":"default"}]`
+
+func TestApplyOutcomesContinueOnError(t *testing.T) {
+	executor := &fakeExecutor{
+		listOutput: reportListAll,
+		listOutputBySelectors: map[string]string{
+			"tier=backend":                               `[{"name":"app","namespace":"default"},{"name":"metrics-exporter","namespace":"monitoring"}]`,
+			"name=app,namespace=default":                 `[{"name":"app","namespace":"default"}]`,
+			"name=metrics-exporter,namespace=monitoring": `[{"name":"metrics-exporter","namespace":"monitoring"}]`,
+		},
+		failingSelectors: map[string]bool{"name=metrics-exporter,namespace=monitoring": true},
+	}
+
+	recorder := newRecordingExecutor(executor)
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+	fs := &ReleaseSet{ContinueOnError: true, MaxFailedReleases: 1, Selectors: []interface{}{"tier=backend"}}
+
+	if _, err := applyEachRelease(context.Background(), fs, buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}), d, recorder); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []releaseOutcome{
+		{Name: "app", Namespace: "default", Status: ReleaseOutcomePassed, Message: "unchanged"},
+		{Name: "frontend", Namespace: "default", Status: ReleaseOutcomeSkipped, Message: "not matched by the selectors"},
+		{Name: "metrics-exporter", Namespace: "monitoring", Status: ReleaseOutcomeFailed, Message: "release name=metrics-exporter,namespace=monitoring failed"},
+	}
+
+	if got := recorder.applyOutcomes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected outcomes:\nwant: %+v\ngot:  %+v", want, got)
+	}
+}
+
+func TestApplyOutcomesReleaseTables(t *testing.T) {
+	executor := &fakeExecutor{
+		listOutput: reportListAll,
+		applyOutput: `
+UPDATED RELEASES:
+NAME    NAMESPACE   CHART        VERSION   DURATION
+app     default     charts/app   0.1.0           3s
+
+`,
+	}
+
+	recorder := newRecordingExecutor(executor)
+	fs := &ReleaseSet{}
+
+	if _, err := recorder.Apply(context.Background(), buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []releaseOutcome{
+		{Name: "app", Namespace: "default", Status: ReleaseOutcomePassed, Message: "applied"},
+		{Name: "frontend", Namespace: "default", Status: ReleaseOutcomePassed, Message: "unchanged"},
+		{Name: "metrics-exporter", Namespace: "monitoring", Status: ReleaseOutcomePassed, Message: "unchanged"},
+	}
+
+	if got := recorder.applyOutcomes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected outcomes:\nwant: %+v\ngot:  %+v", want, got)
+	}
+}
+
+func TestApplyOutcomesFailedApply(t *testing.T) {
+	executor := &fakeExecutor{
+		listOutput: reportListAll,
+		listOutputBySelectors: map[string]string{
+			"tier=backend": `[{"name":"app","namespace":"default"}]`,
+		},
+		failingSelectors: map[string]bool{"tier=backend": true},
+	}
+
+	recorder := newRecordingExecutor(executor)
+	fs := &ReleaseSet{Selectors: []interface{}{"tier=backend"}}
+
+	if _, err := recorder.Apply(context.Background(), buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	got := recorder.applyOutcomes()
+
+	if got[0].Status != ReleaseOutcomeFailed || got[0].Message != "not applied: release tier=backend failed" {
+		t.Errorf("expected app to fail with the error of the apply, got %+v", got[0])
+	}
+
+	for _, o := range got[1:] {
+		if o.Status != ReleaseOutcomeSkipped {
+			t.Errorf("expected %s to be skipped, got %+v", o.ID(), o)
+		}
+	}
+}
+
+func TestDiffOutcomes(t *testing.T) {
+	all := []listedRelease{{Name: "large", Namespace: "default"}, {Name: "small", Namespace: "default"}}
+	matched := map[string]listedRelease{"default/large": all[0], "default/small": all[1]}
+
+	diff := syntheticDiff(map[string]int{"large": 1})
+
+	got := diffOutcomes(all, matched, diff, nil)
+	want := []releaseOutcome{
+		{Name: "large", Namespace: "default", Status: ReleaseOutcomePassed, Message: "changes pending"},
+		{Name: "small", Namespace: "default", Status: ReleaseOutcomePassed, Message: "unchanged"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected outcomes:\nwant: %+v\ngot:  %+v", want, got)
+	}
+
+	for _, o := range diffOutcomes(all, matched, "", errors.New("cluster unreachable")) {
+		if o.Status != ReleaseOutcomeFailed || o.Message != "cluster unreachable" {
+			t.Errorf("expected %s to fail with the error of the diff, got %+v", o.ID(), o)
+		}
+	}
+}
+
+func TestWriteReportFile(t *testing.T) {
+	report := releaseReport{
+		ResourceType: resourceTypeReleaseSet,
+		ID:           "abc123",
+		Operation:    operationCreate,
+		Timestamp:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Releases: []releaseOutcome{
+			{Name: "app", Namespace: "default", Status: ReleaseOutcomePassed, Message: "applied"},
+			{Name: "frontend", Namespace: "default", Status: ReleaseOutcomeSkipped, Message: "not matched by the selectors"},
+			{Name: "metrics-exporter", Namespace: "monitoring", Status: ReleaseOutcomeFailed, Message: `timed out waiting for "metrics-exporter"`},
+		},
+	}
+
+	dir := t.TempDir()
+
+	t.Run("junit", func(t *testing.T) {
+		if err := writeReportFile(filepath.Join(dir, "reports", "helmfile.xml"), ReportFormatJUnit, report); err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "reports", "helmfile.helmfile_release_set.abc123.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="helmfile_release_set.abc123 create" tests="3" failures="1" skipped="1" timestamp="2024-01-02T03:04:05Z">
+    <testcase classname="helmfile_release_set.abc123" name="default/app">
+      <system-out>applied</system-out>
+    </testcase>
+    <testcase classname="helmfile_release_set.abc123" name="default/frontend">
+      <skipped message="not matched by the selectors"></skipped>
+    </testcase>
+    <testcase classname="helmfile_release_set.abc123" name="monitoring/metrics-exporter">
+      <failure message="timed out waiting for &#34;metrics-exporter&#34;"></failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+		if string(bs) != want {
+			t.Errorf("unexpected report:\nwant:\n%s\ngot:\n%s", want, bs)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		if err := writeReportFile(filepath.Join(dir, "helmfile.json"), ReportFormatJSON, report); err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, "helmfile.helmfile_release_set.abc123.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got releaseReport
+		if err := json.Unmarshal(bs, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, report) {
+			t.Errorf("unexpected report:\nwant: %+v\ngot:  %+v", report, got)
+		}
+	})
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", ".*.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 0 {
+		t.Errorf("expected no temporary files to be left, got %v", files)
+	}
+}
+
+func TestReportID(t *testing.T) {
+	if got := reportID("abc123", &ReleaseSet{Name: "mystack"}); got != "abc123" {
+		t.Errorf("expected the resource ID, got %q", got)
+	}
+
+	if got := reportID("", &ReleaseSet{Name: "mystack"}); got != "mystack" {
+		t.Errorf("expected the name of a release set that isn't created yet, got %q", got)
+	}
+
+	if got := reportID("", &ReleaseSet{Content: "releases: []"}); len(got) != 16 || strings.Contains(got, "/") {
+		t.Errorf("expected the content hash of a release set that isn't created yet, got %q", got)
+	}
+}
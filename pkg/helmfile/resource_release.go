@@ -118,9 +118,10 @@ func resourceHelmfileRelease() *schema.Resource {
 			},
 			KeyKubeconfig: {
 				Type:     schema.TypeString,
-				Required: true,
+				Optional: true,
 				ForceNew: false,
 			},
+			KeyKubeconfigContent: kubeconfigContentSchema(),
 			KeyKubecontext: {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -156,12 +157,16 @@ func resourceHelmfileRelease() *schema.Resource {
 				Optional: true,
 				Default:  false,
 			},
+			KeyProviderConfigHash: providerConfigHashSchema(),
 		},
 	}
 }
 
 //helpers to unwravel the recursive bits by adding a base condition
 func resourceHelmfileReleaseCreate(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeRelease, operationCreate, d)
+	defer metrics.record(d, &finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -174,11 +179,18 @@ func resourceHelmfileReleaseCreate(d *schema.ResourceData, meta interface{}) (fi
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(rs)
+
+	sweepStaleKubeconfigs(kubeconfigDir(rs.WorkingDirectory), provider.StaleKubeconfigMaxAge)
 
 	if err := CreateReleaseSet(newContext(d), rs, d, provider.Executor); err != nil {
 		return err
 	}
 
+	if err := setProviderConfigHash(d, provider); err != nil {
+		return err
+	}
+
 	d.MarkNewResource()
 
 	//create random uuid for the id
@@ -199,11 +211,15 @@ func resourceHelmfileReleaseRead(d *schema.ResourceData, _ interface{}) (finalEr
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(rs)
 
 	return ReadReleaseSet(newContext(d), rs, d)
 }
 
 func resourceHelmfileReleaseUpdate(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeRelease, operationUpdate, d)
+	defer metrics.record(d, &finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -216,11 +232,21 @@ func resourceHelmfileReleaseUpdate(d *schema.ResourceData, meta interface{}) (fi
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(rs)
+
+	sweepStaleKubeconfigs(kubeconfigDir(rs.WorkingDirectory), provider.StaleKubeconfigMaxAge)
+
+	if err := UpdateReleaseSet(newContext(d), rs, d, provider.Executor); err != nil {
+		return err
+	}
 
-	return UpdateReleaseSet(newContext(d), rs, d, provider.Executor)
+	return setProviderConfigHash(d, provider)
 }
 
-func resourceHelmfileReleaseDiff(d *schema.ResourceDiff, _ interface{}) (finalErr error) {
+func resourceHelmfileReleaseDiff(d *schema.ResourceDiff, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeRelease, operationDiff, d)
+	defer metrics.record(d, &finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -231,6 +257,13 @@ func resourceHelmfileReleaseDiff(d *schema.ResourceDiff, _ interface{}) (finalEr
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(rs)
+
+	// Provider attributes are not resource attributes, so we record their hash
+	// so that a change in them is detected as a change of this resource.
+	if err := setProviderConfigHash(resourceDiffToFields(d), meta.(*ProviderInstance)); err != nil {
+		return err
+	}
 
 	diff, err := DiffReleaseSet(newContext(d), rs, resourceDiffToFields(d))
 	if err != nil {
@@ -239,15 +272,18 @@ func resourceHelmfileReleaseDiff(d *schema.ResourceDiff, _ interface{}) (finalEr
 
 	releaseInputKeys := []string{
 		KeyValues, KeyChart, KeyVersion, KeyWorkingDirectory,
-		KeyKubeconfig, KeyKubecontext, KeyBin, KeyHelmBin,
-		KeyNamespace, KeyName,
+		KeyKubeconfig, KeyKubeconfigContent, KeyKubecontext, KeyBin, KeyHelmBin,
+		KeyNamespace, KeyName, KeyProviderConfigHash,
 	}
-	markDiffOutputs(d, diff, releaseInputKeys)
+	markDiffOutputs(d, diff, releaseInputKeys, outputAttributes{})
 
 	return nil
 }
 
 func resourceHelmfileReleaseDelete(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeRelease, operationDelete, d)
+	defer metrics.record(d, &finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -260,6 +296,7 @@ func resourceHelmfileReleaseDelete(d *schema.ResourceData, meta interface{}) (fi
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(rs)
 
 	if err := DeleteReleaseSet(newContext(d), rs, d, provider.Executor); err != nil {
 		return err
@@ -273,6 +310,16 @@ func resourceHelmfileReleaseDelete(d *schema.ResourceData, meta interface{}) (fi
 func NewReleaseSetWithSingleRelease(d ResourceRead) (*ReleaseSet, error) {
 	r := NewRelease(d)
 
+	kubeconfigContent, _ := d.Get(KeyKubeconfigContent).(string)
+
+	if r.Kubeconfig != "" && kubeconfigContent != "" {
+		return nil, fmt.Errorf("'kubeconfig' and 'kubeconfig_content' cannot both be provided")
+	}
+
+	if r.Kubeconfig == "" && kubeconfigContent == "" {
+		return nil, fmt.Errorf("either 'kubeconfig' or 'kubeconfig_content' must be provided")
+	}
+
 	var values []interface{}
 	for _, v := range r.Values {
 		var vv map[string]interface{}
@@ -313,5 +360,11 @@ func NewReleaseSetWithSingleRelease(d ResourceRead) (*ReleaseSet, error) {
 		Kubeconfig:       r.Kubeconfig,
 	}
 
+	if kubeconfigContent != "" {
+		if err := writeKubeconfigContent(rs, kubeconfigContent); err != nil {
+			return nil, err
+		}
+	}
+
 	return rs, nil
 }
@@ -1,13 +1,14 @@
 package helmfile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk/tfsdk"
 	"github.com/rs/xid"
 	"golang.org/x/xerrors"
-	"log"
-	"os"
 	"runtime/debug"
 	"strings"
 )
@@ -17,6 +18,9 @@ const KeyValues = "values"
 const KeySelector = "selector"
 const KeySelectors = "selectors"
 const KeyEnvironmentVariables = "environment_variables"
+const KeyApplyEnvironmentVariables = "apply_environment_variables"
+const KeyDiffEnvironmentVariables = "diff_environment_variables"
+const KeySensitiveEnvironmentVariables = "sensitive_environment_variables"
 const KeyWorkingDirectory = "working_directory"
 const KeyPath = "path"
 const KeyContent = "content"
@@ -29,10 +33,149 @@ const KeyApplyOutput = "apply_output"
 const KeyDirty = "dirty"
 const KeyConcurrency = "concurrency"
 const KeyReleasesValues = "releases_values"
+const KeyReleasesValuesString = "releases_values_string"
 const KeySkipDiffOnMissingFiles = "skip_diff_on_missing_files"
 const KeyEnableGoTemplate = "enable_go_template"
 const KeyDryRun = "dry_run"
 const KeyTemplateOutput = "template_output"
+const KeyStderrOutput = "stderr_output"
+const KeyStrictDestroy = "strict_destroy"
+const KeyTemplateOutputDir = "template_output_dir"
+const KeyTemplateOutputDirTemplate = "template_output_dir_template"
+const KeyTemplateOutputFileTemplate = "template_output_file_template"
+const KeyIgnoreDefaultSelectors = "ignore_default_selectors"
+const KeyDefaultSelectorsHash = "default_selectors_hash"
+const KeyEffectiveSelectors = "effective_selectors"
+const KeyCommonLabels = "common_labels"
+const KeyContinueOnError = "continue_on_error"
+const KeyMaxFailedReleases = "max_failed_releases"
+const KeyFailedReleases = "failed_releases"
+const KeySummary = "summary"
+const KeySummaryHasChanges = "has_changes"
+const KeySummaryChangedReleaseCount = "changed_release_count"
+const KeySummaryFailed = "failed"
+const KeyApplySummary = "apply_summary"
+const KeyApplySummaryAppliedReleaseCount = "applied_release_count"
+const KeyApplySummaryFailedReleaseCount = "failed_release_count"
+const KeyRequireWritableWorkingDirectory = "require_writable_working_directory"
+const KeyKeepTempFiles = "keep_temp_files"
+const KeyScratchDirectory = "scratch_directory"
+const KeyWorkingDirectoryLockTimeout = "working_directory_lock_timeout"
+const KeyCaptureEnvironmentValues = "capture_environment_values"
+const KeyEnvironmentInfo = "environment_info"
+const KeyPolicyCheck = "policy_check"
+const KeyPolicyCheckCommand = "command"
+const KeyPolicyCheckFailureMode = "failure_mode"
+const KeyPolicyOutput = "policy_output"
+const KeyKustomizePatches = "kustomize_patches"
+const KeyFailOnMissingCRDDiff = "fail_on_missing_crd_diff"
+const KeyIDScheme = "id_scheme"
+const KeyDiffOutputDir = "diff_output_dir"
+const KeySplitBy = "split_by"
+const KeyDiffOutputFiles = "diff_output_files"
+const KeyManagedNamespaces = "managed_namespaces"
+const KeyManagedNamespaceName = "name"
+const KeyManagedNamespaceLabels = "labels"
+const KeyManagedNamespaceAnnotations = "annotations"
+const KeyDeleteManagedNamespaces = "delete_managed_namespaces"
+const KeyKustomizePatchesRelease = "release"
+const KeyKustomizePatchesPatches = "patches"
+const KeyWaitForExternalReleases = "wait_for_external_releases"
+const KeyExternalReleaseName = "name"
+const KeyExternalReleaseNamespace = "namespace"
+const KeyExternalReleaseMinVersion = "min_version"
+const KeyExternalReleaseTimeout = "timeout"
+const KeyExternalReleaseCheckOnPlan = "check_on_plan"
+const KeyValuesHandling = "values_handling"
+const KeyHookResults = "hook_results"
+const KeyHookResultEvent = "event"
+const KeyHookResultName = "name"
+const KeyHookResultRelease = "release"
+const KeyHookResultCommand = "command"
+const KeyHookResultExitStatus = "exit_status"
+const KeyHookResultDurationSeconds = "duration_seconds"
+const KeyValidateValuesAgainstSchema = "validate_values_against_schema"
+const KeyValuesSchemaTimeout = "values_schema_timeout"
+const KeyMaxChangedObjects = "max_changed_objects"
+const KeyMaxDiffLines = "max_diff_lines"
+const KeyDiffThresholdMode = "diff_threshold_mode"
+const KeyHelmTimeoutApply = "helm_timeout_apply"
+const KeyHelmTimeoutDiff = "helm_timeout_diff"
+const KeyHelmTimeoutDestroy = "helm_timeout_destroy"
+const KeyDiffNewResources = "diff_new_resources"
+const KeyDetectDrift = "detect_drift"
+const KeyReportFile = "report_file"
+const KeyReportFormat = "report_format"
+const KeyReportOnPlan = "report_on_plan"
+const KeyEffectiveKubeconfigSource = "effective_kubeconfig_source"
+const KeyEffectiveVersion = "effective_version"
+const KeyVersionWarning = "version_warning"
+const KeyDestroyScope = "destroy_scope"
+const KeyDestroyCascade = "destroy_cascade"
+const KeyDeleteWait = "delete_wait"
+const KeyDeleteTimeout = "delete_timeout"
+const KeyManagedReleases = "managed_releases"
+const KeyReleases = "releases"
+const KeyReleaseName = "name"
+const KeyReleaseNamespace = "namespace"
+const KeyReleaseInstalled = "installed"
+const KeyReleaseVersion = "version"
+const KeyReleaseStatus = "release_status"
+const KeyReleaseChart = "chart"
+const KeyReleaseChanged = "changed"
+const KeyReleaseAction = "action"
+const KeyDiffCacheDir = "diff_cache_dir"
+const KeyDiffCacheTTL = "diff_cache_ttl"
+const KeyChangeReason = "change_reason"
+const KeyEphemeralValues = "ephemeral_values"
+const KeyEphemeralValuesHash = "ephemeral_values_hash"
+const KeyReportChartVersionChanges = "report_chart_version_changes"
+const KeyResolvedChartVersions = "resolved_chart_versions"
+const KeySkipSchemaValidation = "skip_schema_validation"
+const KeyIncludeCRDs = "include_crds"
+const KeySkipCRDs = "skip_crds"
+const KeyNoHooks = "no_hooks"
+const KeySkipTests = "skip_tests"
+const KeyReuseValues = "reuse_values"
+const KeyResetValues = "reset_values"
+const KeyDiffArgs = "diff_args"
+const KeySyncArgs = "sync_args"
+const KeySkipDeps = "skip_deps"
+const KeySkipNeeds = "skip_needs"
+const KeyIncludeNeeds = "include_needs"
+const KeyIncludeTransitiveNeeds = "include_transitive_needs"
+const KeyLintOnPlan = "lint_on_plan"
+const KeyApplyMode = "apply_mode"
+const KeyEnableLiveOutput = "enable_live_output"
+const KeyLogLevel = "log_level"
+const KeyWaitForJobs = "wait_for_jobs"
+const KeyWaitTimeout = "wait_timeout"
+const KeySuppressSecrets = "suppress_secrets"
+const KeySkipDiffOnInstall = "skip_diff_on_install"
+const KeyDiffContext = "diff_context"
+const KeyDiffSuppressLineRegex = "diff_suppress_line_regex"
+const KeyIgnoreDiffRegex = "ignore_diff_regex"
+const KeyRawDiffOutput = "raw_diff_output"
+const KeyKubeVersion = "kube_version"
+const KeyAPIVersions = "api_versions"
+const KeyExtraArgs = "extra_args"
+const KeySet = "set"
+const KeyStateValues = "state_values"
+const KeySetName = "name"
+const KeySetValue = "value"
+const KeySetType = "type"
+const KeySensitiveDiffOutput = "sensitive_diff_output"
+const KeySensitiveApplyOutput = "sensitive_apply_output"
+const KeySensitiveTemplateOutput = "sensitive_template_output"
+const KeySensitiveOutputs = "sensitive_outputs"
+const KeyOutputPath = "output_path"
+const KeyStoreOutputsInState = "store_outputs_in_state"
+const KeyDiffOutputFile = "diff_output_file"
+const KeyDiffOutputSHA256 = "diff_output_sha256"
+const KeyApplyOutputFile = "apply_output_file"
+const KeyApplyOutputSHA256 = "apply_output_sha256"
+const KeyTemplateOutputFile = "template_output_file"
+const KeyTemplateOutputSHA256 = "template_output_sha256"
 
 const HelmfileDefaultPath = "helmfile.yaml"
 
@@ -48,6 +191,12 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		ForceNew: false,
 	},
 	KeyAWSAssumeRole: tfsdk.SchemaAssumeRole(),
+	KeyAWSEndpoint: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Endpoint URL of the AWS APIs that describe the EKS cluster of eks_cluster_name and sign its tokens, like the one of localstack. Defaults to AWS_ENDPOINT_URL",
+	},
 	KeyValuesFiles: {
 		Type:     schema.TypeList,
 		Optional: true,
@@ -61,7 +210,9 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		Optional: true,
 		ForceNew: false,
 		Elem: &schema.Schema{
-			Type: schema.TypeString,
+			Type:             schema.TypeString,
+			DiffSuppressFunc: suppressEquivalentYAML,
+			ValidateFunc:     validation.All(validateYAML, warnContentSize),
 		},
 	},
 	KeySkipDiffOnMissingFiles: {
@@ -85,11 +236,69 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 			Type: schema.TypeString,
 		},
 	},
+	KeyIgnoreDefaultSelectors: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, the provider-level default_selectors are not applied to this resource",
+	},
+	KeyProviderConfigHash: providerConfigHashSchema(),
+	KeyDefaultSelectorsHash: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Hash of the provider-level default_selectors applied to this resource, used to detect changes in them",
+	},
+	KeyEffectiveSelectors: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Description: "Selectors passed to helmfile, which are selector and selectors with the provider-level default_selectors ANDed into each of them. Helmfile ORs them",
+	},
+	KeyEffectiveKubeconfigSource: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "The attribute the kubeconfig came from and the absolute path it was resolved to, for debugging",
+	},
+	KeyEffectiveVersion: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "The version of helmfile that ran the last apply, like 1.4.1",
+	},
+	KeyVersionWarning: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Why the version of helmfile couldn't be detected on the last plan or apply, like a wrapper script of bin printing only a banner, in which case the flags that depend on the version are left out. Empty once detected",
+	},
+	KeyChangeReason: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Why the last plan with changes updates the release set, like values changed or cluster drift detected. Informational only",
+	},
 	KeyEnvironmentVariables: {
 		Type:     schema.TypeMap,
 		Optional: true,
 		Elem:     schema.TypeString,
 	},
+	KeyApplyEnvironmentVariables: {
+		Type:        schema.TypeMap,
+		Optional:    true,
+		Elem:        schema.TypeString,
+		Description: "Environment variables merged over environment_variables only on apply. Changing them triggers an apply without changing diff_output",
+	},
+	KeyDiffEnvironmentVariables: {
+		Type:        schema.TypeMap,
+		Optional:    true,
+		Elem:        schema.TypeString,
+		Description: "Environment variables merged over environment_variables only on diff",
+	},
+	KeySensitiveEnvironmentVariables: {
+		Type:        schema.TypeMap,
+		Optional:    true,
+		Sensitive:   true,
+		Elem:        schema.TypeString,
+		Description: "Environment variables like environment_variables, whose values are masked as *** in apply_output, diff_output and the like, the debug logs and the errors. Values shorter than 4 characters are not masked",
+	},
 	KeyWorkingDirectory: {
 		Type:     schema.TypeString,
 		Optional: true,
@@ -101,8 +310,9 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		Optional:    true,
 		Computed:    true,
 		ForceNew:    false,
-		Description: "Path to kubeconfig file. Optional when eks_cluster_name is provided.",
+		Description: "Path to kubeconfig file. Optional when eks_cluster_name, cluster_auth_exec or kubeconfig_content is provided.",
 	},
+	KeyKubeconfigContent: kubeconfigContentSchema(),
 	KeyPath: {
 		Type:     schema.TypeString,
 		Optional: true,
@@ -110,9 +320,11 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		Default:  "",
 	},
 	KeyContent: {
-		Type:     schema.TypeString,
-		Optional: true,
-		ForceNew: false,
+		Type:             schema.TypeString,
+		Optional:         true,
+		ForceNew:         false,
+		DiffSuppressFunc: suppressEquivalentYAML,
+		ValidateFunc:     warnContentSize,
 	},
 	KeyBin: {
 		Type:     schema.TypeString,
@@ -158,6 +370,148 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		Type:     schema.TypeString,
 		Computed: true,
 	},
+	KeySuppressSecrets: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     true,
+		Description: "When false, the changes of Secrets are diffed with their values, which are redacted from diff_output and apply_output and recorded in sensitive_diff_output and sensitive_apply_output instead",
+	},
+	KeySkipDiffOnInstall: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     true,
+		Description: "When false, the releases that are not installed yet are diffed on apply too, so that apply_output shows what the first apply installs",
+	},
+	KeyDiffContext: {
+		Type:         schema.TypeInt,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      defaultDiffContext,
+		ValidateFunc: validation.IntAtLeast(1),
+		Description:  "Number of unchanged lines shown around the changed lines in diff_output and apply_output",
+	},
+	KeyDiffSuppressLineRegex: {
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: false,
+		Elem: &schema.Schema{
+			Type:         schema.TypeString,
+			ValidateFunc: validateDiffSuppressLineRegex,
+		},
+		Description: "Regexes of the lines removed from diff_output and apply_output, like checksum annotations that change on every render. An object left with no changes is not shown",
+	},
+	KeyIgnoreDiffRegex: {
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: false,
+		Elem: &schema.Schema{
+			Type:         schema.TypeString,
+			ValidateFunc: validateDiffSuppressLineRegex,
+		},
+		Description: "Regexes of the changed lines dropped from the diff on plan by the provider, along with the checksum/, rollme and timestamp lines dropped by default. The release set is changed only when other changed lines are left",
+	},
+	KeyRawDiffOutput: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "helmfile diff output before the noisy lines were dropped, for debugging. Set along with diff_output when changes are left, unless sensitive_outputs is true or store_outputs_in_state is false",
+	},
+	KeyKubeVersion: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validateKubeVersion,
+		Description:  "Kubernetes version of .Capabilities.KubeVersion, like 1.29.0, for rendering the charts without a cluster when dry_run is enabled. Also passed to helm-diff",
+	},
+	KeyAPIVersions: {
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: false,
+		Elem: &schema.Schema{
+			Type:         schema.TypeString,
+			ValidateFunc: validateAPIVersion,
+		},
+		Description: "API versions added to .Capabilities.APIVersions, like monitoring.coreos.com/v1, for rendering the charts without a cluster when dry_run is enabled. Also passed to helm-diff",
+	},
+	KeyExtraArgs: {
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: false,
+		Elem: &schema.Schema{
+			Type:         schema.TypeString,
+			ValidateFunc: validateExtraArg,
+		},
+		Description: "Global flags appended as is to the helmfile binary command, like --allow-no-matching-release, for the flags that have no attribute. --file, --environment and --kubeconfig are set by the provider and can't be passed. With the library executor, they are passed to helm instead",
+	},
+	KeySensitiveDiffOutput: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Sensitive:   true,
+		Description: "diff_output including the values of Secrets when suppress_secrets is false, or the whole diff_output when sensitive_outputs is true",
+	},
+	KeySensitiveApplyOutput: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Sensitive:   true,
+		Description: "apply_output including the values of Secrets when suppress_secrets is false, or the whole apply_output when sensitive_outputs is true",
+	},
+	KeySensitiveTemplateOutput: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Sensitive:   true,
+		Description: "template_output when sensitive_outputs is true",
+	},
+	KeySensitiveOutputs: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, diff_output, apply_output and template_output are recorded in sensitive_diff_output, sensitive_apply_output and sensitive_template_output instead, so that the plan and the logs never print the rendered values, which may include secrets",
+	},
+	KeyOutputPath: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Directory to write diff_output, apply_output and template_output to when store_outputs_in_state is false. The files are named after the SHA-256 hash of their contents",
+	},
+	KeyStoreOutputsInState: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     true,
+		Description: "When false, the outputs are written to files under output_path instead of the state, which records only their paths and hashes. The outputs are written as is, without being truncated",
+	},
+	KeyDiffOutputFile: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Path to the file under output_path with the helmfile diff output, when store_outputs_in_state is false",
+	},
+	KeyDiffOutputSHA256: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "SHA-256 hash of the helmfile diff output written to diff_output_file",
+	},
+	KeyApplyOutputFile: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Path to the file under output_path with the helmfile apply output, when store_outputs_in_state is false",
+	},
+	KeyApplyOutputSHA256: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "SHA-256 hash of the helmfile apply output written to apply_output_file",
+	},
+	KeyTemplateOutputFile: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Path to the file under output_path with the helmfile template output, when store_outputs_in_state is false",
+	},
+	KeyTemplateOutputSHA256: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "SHA-256 hash of the helmfile template output written to template_output_file",
+	},
 	KeyError: {
 		Type:     schema.TypeString,
 		Computed: true,
@@ -177,6 +531,48 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		Optional: true,
 		ForceNew: false,
 	},
+	KeyStateValues: {
+		Type:         schema.TypeMap,
+		Optional:     true,
+		ForceNew:     false,
+		Elem:         &schema.Schema{Type: schema.TypeString},
+		ValidateFunc: validateStateValues,
+		Description:  "State values passed to helmfile like --state-values-set, over all the other state values. Dotted keys like cluster.name set nested values, and true, false, null and integers are coerced like helmfile does",
+	},
+	KeySet: {
+		Type:        schema.TypeList,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Values that override the values of every release like helm --set, after releases_values. Later blocks override earlier ones for the same name",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeySetName: {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "Dot-separated path to the value like image.tag, where \\. escapes a literal dot",
+				},
+				KeySetValue: {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "Value, passed to helm as is. Commas and backslashes are escaped",
+				},
+				KeySetType: {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      SetTypeAuto,
+					ValidateFunc: validation.StringInSlice([]string{SetTypeAuto, SetTypeString}, false),
+					Description:  "Either auto to let helm coerce the value into a number or a boolean like --set, or string to keep it a string like --set-string",
+				},
+			},
+		},
+	},
+	KeyReleasesValuesString: {
+		Type:        schema.TypeMap,
+		Optional:    true,
+		ForceNew:    false,
+		Elem:        schema.TypeString,
+		Description: "Like releases_values but the values are always passed to helm as strings, like --set-string, so that values like 1.20 and true are not coerced. Takes precedence over releases_values for the same key",
+	},
 	KeyEnableGoTemplate: {
 		Type:     schema.TypeBool,
 		Optional: true,
@@ -195,6 +591,723 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		Computed:    true,
 		Description: "Output from helmfile template when dry_run is enabled",
 	},
+	KeyStderrOutput: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Stderr of the last helmfile diff, or of helmfile template when dry_run is enabled, kept out of diff_output and template_output",
+	},
+	KeyContinueOnError: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, applies the releases one by one and continues with the remaining releases when one fails. Failed releases leave drift that the next plan shows",
+	},
+	KeyMaxFailedReleases: {
+		Type:         schema.TypeInt,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      0,
+		ValidateFunc: validation.IntAtLeast(0),
+		Description:  "Number of failed releases tolerated by continue_on_error before the apply fails",
+	},
+	KeyFailedReleases: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Description: "Releases that failed in the last apply with continue_on_error, or failed the schema validation of their charts, as namespace/name",
+	},
+	KeySummary: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Summary of the pending changes, computed from helmfile diff on plan. After apply it reflects the releases left unapplied, while apply_summary tells what the apply did",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeySummaryHasChanges: {
+					Type:     schema.TypeBool,
+					Computed: true,
+				},
+				KeySummaryChangedReleaseCount: {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				KeySummaryFailed: {
+					Type:     schema.TypeBool,
+					Computed: true,
+				},
+			},
+		},
+	},
+	KeyApplySummary: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Summary of the outcome of the last apply. Known after apply on the plans with changes",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyApplySummaryAppliedReleaseCount: {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				KeyApplySummaryFailedReleaseCount: {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+			},
+		},
+	},
+	KeyValuesHandling: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      ValuesHandlingFiles,
+		ValidateFunc: validation.StringInSlice([]string{ValuesHandlingFiles, ValuesHandlingInline}, false),
+		Description:  "Either files to layer values before values_files, or inline to layer values after values_files",
+	},
+	KeyEphemeralValues: {
+		Type:        schema.TypeList,
+		Optional:    true,
+		Sensitive:   true,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Description: "Sensitive values layered after all the other values, which are never written to the working directory or logged",
+	},
+	KeyEphemeralValuesHash: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Hash of ephemeral_values, used to detect changes in them",
+	},
+	KeyReportChartVersionChanges: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: "When true, resolves the chart version of each release on plan and apply, and adds a line to diff_output for each release whose resolved version changed since the last apply",
+	},
+	KeyResolvedChartVersions: {
+		Type:        schema.TypeMap,
+		Computed:    true,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Description: "Chart versions resolved by report_chart_version_changes, by namespace/name of the release",
+	},
+	KeySkipSchemaValidation: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --skip-schema-validation to helm so that the values are not validated against the values.schema.json of the charts",
+	},
+	KeyIncludeCRDs: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     true,
+		Description: "When false, the CRDs of the charts are left out of template_output when dry_run is enabled",
+	},
+	KeySkipCRDs: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --skip-crds to helm so that the CRDs of the charts are not installed, like when another release set manages them",
+	},
+	KeyNoHooks: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --no-hooks to helm so that the hooks of the charts are not run on apply, and are left out of diff_output and template_output",
+	},
+	KeyReuseValues: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --reuse-values to helm upgrade on apply and to helm-diff on plan, so that the values of the last release are kept unless overridden. Can't be true along with reset_values",
+	},
+	KeyResetValues: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --reset-values to helm upgrade on apply and to helm-diff on plan, so that the values of the last release are ignored. Can't be true along with reuse_values",
+	},
+	KeyDiffArgs: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Args passed as is to helm-diff on plan and apply, like --dry-run=server for server-side diffs. Takes precedence over helmDefaults.diffArgs in the helmfile",
+	},
+	KeySyncArgs: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Args passed as is to helm upgrade on apply, like --atomic. Takes precedence over helmDefaults.syncArgs in the helmfile",
+	},
+	KeySkipTests: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, the test hooks of the charts are left out of template_output when dry_run is enabled",
+	},
+	KeySkipDeps: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --skip-deps to helmfile so that helm repo update and helm dependency build are not run, for charts whose dependencies are vendored",
+	},
+	KeySkipNeeds: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --skip-needs to helmfile so that the needs of the releases matching the selectors are not included",
+	},
+	KeyIncludeNeeds: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --include-needs to helmfile so that the needs of the releases matching the selectors are diffed and applied along with them. Takes precedence over skip_needs",
+	},
+	KeyIncludeTransitiveNeeds: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --include-transitive-needs to helmfile so that the needs of the needs are included too. Implies include_needs",
+	},
+	KeyWait: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --wait to helm upgrade so that the apply returns after the workloads of the releases are ready",
+	},
+	KeyWaitForJobs: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, passes --wait-for-jobs to helm upgrade so that the apply returns after the jobs of the releases completed",
+	},
+	KeyWaitTimeout: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 10m passed to helm upgrade as --timeout on apply, which bounds wait and wait_for_jobs. Can't be set along with helm_timeout_apply. Defaults to helmfile's default",
+	},
+	KeyApplyMode: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      ApplyModeApply,
+		ValidateFunc: validation.StringInSlice([]string{ApplyModeApply, ApplyModeSync}, false),
+		Description:  "Either apply to run helmfile apply, or sync to run helmfile sync, which skips the pre-apply diff entirely and upgrades every release matching the selectors, changed or not",
+	},
+	KeyExecutor: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validation.StringInSlice([]string{ExecutorLibrary, ExecutorBinary}, false),
+		Description:  "Either library or binary to override the executor of the provider for this release set. Defaults to the executor of the provider",
+	},
+	KeyEnableLiveOutput: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     true,
+		Description: "When true, forwards the output of helmfile to the debug log line by line as it is produced, so that long applies show progress with TF_LOG. apply_output is unaffected",
+	},
+	KeyLogLevel: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      LogLevelInfo,
+		ValidateFunc: validation.StringInSlice([]string{LogLevelDebug, LogLevelInfo, LogLevelWarn}, false),
+		Description:  "Either debug, info or warn, the level of the helmfile logs captured in apply_output, diff_output and the like with the library executor. debug also dumps the AWS environment of apply to the debug log, with the secrets masked",
+	},
+	KeyLintOnPlan: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, runs helmfile lint on plan and fails the plan with its output when it finds errors. Lint needs no cluster access, so it runs even when the kubeconfig is not yet known",
+	},
+	KeyHookResults: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Results of the helmfile hooks run by the last apply",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyHookResultEvent: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyHookResultName: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyHookResultRelease: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyHookResultCommand: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyHookResultExitStatus: {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				KeyHookResultDurationSeconds: {
+					Type:     schema.TypeFloat,
+					Computed: true,
+				},
+			},
+		},
+	},
+	KeyRequireWritableWorkingDirectory: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, fails instead of falling back to a temporary directory when working_directory is not writable",
+	},
+	KeyKeepTempFiles: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, keeps the temporary helmfile and values files written to working_directory for debugging, instead of removing them after each operation",
+	},
+	KeyScratchDirectory: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		Computed:     true,
+		ForceNew:     false,
+		Description:  "Where the temporary helmfile and values files are written. Either isolated for a directory of the release set under .terraform-helmfile in working_directory, or working_directory for working_directory itself. Defaults to isolated for new resources, and to working_directory for the ones created by earlier versions of the provider",
+		ValidateFunc: validation.StringInSlice([]string{ScratchDirectoryIsolated, ScratchDirectoryWorkingDirectory}, false),
+	},
+	KeyWorkingDirectoryLockTimeout: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      DefaultWorkingDirectoryLockTimeout.String(),
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 30m to wait for the other release sets sharing working_directory to finish running helmfile in it, when scratch_directory is working_directory",
+	},
+	KeyCommonLabels: {
+		Type:        schema.TypeMap,
+		Optional:    true,
+		ForceNew:    false,
+		Elem:        schema.TypeString,
+		Description: "Labels added to the metadata.labels of every object rendered by the releases in content, with a kustomize LabelTransformer applied by helmfile's chartify integration before the transformers of the release. The labels declared in the commonLabels of content or in the labels of a release win, and the releases declaring all of them are not chartified",
+	},
+	KeyManagedNamespaces: {
+		Type:        schema.TypeList,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Namespaces that are created, or whose labels and annotations are reconciled, before each apply",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyManagedNamespaceName: {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				KeyManagedNamespaceLabels: {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem:     schema.TypeString,
+				},
+				KeyManagedNamespaceAnnotations: {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem:     schema.TypeString,
+				},
+			},
+		},
+	},
+	KeyDeleteManagedNamespaces: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, deletes the managed_namespaces that are empty after destroy. A namespace is empty when it has no objects of any namespaced resource, including custom resources, besides its events, the kube-root-ca.crt config map, the default service account and its token secrets",
+	},
+	KeyDiffOutputDir: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Directory to write the helmfile diff output to on plan, split into one file per release or object. Stale .diff files from previous plans are removed",
+	},
+	KeySplitBy: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      SplitByRelease,
+		Description:  "Either release to write release--namespace.diff files, or object to write release--namespace--kind--name.diff files to diff_output_dir",
+		ValidateFunc: validation.StringInSlice([]string{SplitByRelease, SplitByObject}, false),
+	},
+	KeyDiffOutputFiles: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Paths to the files written to diff_output_dir",
+		Elem: &schema.Schema{
+			Type: schema.TypeString,
+		},
+	},
+	KeyIDScheme: {
+		Type:             schema.TypeString,
+		Optional:         true,
+		ForceNew:         false,
+		Default:          IDSchemeInputs,
+		Description:      "How the ID is generated on create. Either inputs to use the hash of working_directory, path or content, environment, selectors, the values and the cluster, random, name to use the name attribute, or content-hash to use the hash of content. Changing it keeps the ID of existing resources, and their id_scheme records the scheme their ID was generated with",
+		ValidateFunc:     validation.StringInSlice([]string{IDSchemeInputs, IDSchemeRandom, IDSchemeName, IDSchemeContentHash}, false),
+		DiffSuppressFunc: suppressIDSchemeChange,
+	},
+	KeyName: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Name of the release set, used as the ID when id_scheme is name",
+	},
+	KeyFailOnMissingCRDDiff: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, fails the plan when helmfile diff fails because the CRDs of custom resources are not yet installed, instead of noting the affected releases after the diff of the other releases in diff_output and leaving them to apply",
+	},
+	KeyWaitForExternalReleases: {
+		Type:        schema.TypeList,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Helm releases installed by other tools that are waited for before apply, like cert-manager",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyExternalReleaseName: {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				KeyExternalReleaseNamespace: {
+					Type:     schema.TypeString,
+					Required: true,
+				},
+				KeyExternalReleaseMinVersion: {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "Minimum chart version of the release, like 1.12.0",
+				},
+				KeyExternalReleaseTimeout: {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Default:     DefaultExternalReleaseTimeout,
+					Description: "Number of seconds to wait for the release before failing the apply",
+				},
+				KeyExternalReleaseCheckOnPlan: {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Default:     false,
+					Description: "When true, checks the release on plan too, only logging a warning when it is not ready",
+				},
+			},
+		},
+	},
+	KeyValidateValuesAgainstSchema: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, validates the effective values of each release against the values.schema.json of its chart on plan. Charts without values.schema.json are skipped",
+	},
+	KeyValuesSchemaTimeout: {
+		Type:        schema.TypeInt,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     DefaultValuesSchemaTimeout,
+		Description: "Number of seconds to wait for the charts to be fetched and the values to be validated with validate_values_against_schema",
+	},
+	KeyMaxChangedObjects: {
+		Type:        schema.TypeInt,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     0,
+		Description: "Maximum number of changed Kubernetes objects in the diff of a plan. Zero means no limit",
+	},
+	KeyMaxDiffLines: {
+		Type:        schema.TypeInt,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     0,
+		Description: "Maximum number of added or removed lines in the diff of a plan. Zero means no limit",
+	},
+	KeyDiffThresholdMode: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      DiffThresholdModeError,
+		ValidateFunc: validation.StringInSlice([]string{DiffThresholdModeError, DiffThresholdModeWarn}, false),
+		Description:  "Either error to fail the plan or warn to only log when the diff exceeds max_changed_objects or max_diff_lines",
+	},
+	KeyDiffNewResources: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, runs helmfile diff on plan for release sets that are not yet created, instead of setting diff_output to a fixed marker",
+	},
+	KeyDetectDrift: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, runs helmfile diff on refresh, and sets diff_output and dirty when the releases in the cluster have drifted, like after kubectl edit",
+	},
+	KeyDiffCacheDir: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Directory to cache the helmfile diff verdict of each release in, so that plans only diff the releases whose spec, values, cluster or revision changed. Use a directory per release set",
+	},
+	KeyDiffCacheTTL: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      DefaultDiffCacheTTL.String(),
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 30m after which the entries in diff_cache_dir expire",
+	},
+	KeyReportFile: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Path to write the report of the release outcomes to after apply, namespaced by the resource like report.helmfile_release_set.<id>.xml. Failing to write it only logs a warning",
+	},
+	KeyReportFormat: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      ReportFormatJUnit,
+		ValidateFunc: validation.StringInSlice([]string{ReportFormatJUnit, ReportFormatJSON}, false),
+		Description:  "Either junit to write each release as a test case, or json",
+	},
+	KeyReportOnPlan: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, writes the report_file after the diff on plan too",
+	},
+	KeyHelmTimeoutApply: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 10m passed to helm upgrade as --timeout on apply, rounded up to seconds. Defaults to helmfile's default",
+	},
+	KeyHelmTimeoutDiff: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 2m after which helmfile diff is killed on plan. helm-diff has no --timeout, so this bounds the whole diff",
+	},
+	KeyHelmTimeoutDestroy: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 5m passed to helm uninstall as --timeout on destroy, rounded up to seconds. Helm also waits for the resources to be deleted",
+	},
+	KeyKustomizePatches: {
+		Type:        schema.TypeList,
+		Optional:    true,
+		ForceNew:    false,
+		Description: "Kustomize patches applied to the rendered manifests of the releases in content with helmfile's chartify integration",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyKustomizePatchesRelease: {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "Name of the release in content to patch",
+				},
+				KeyKustomizePatchesPatches: {
+					Type:        schema.TypeList,
+					Required:    true,
+					MinItems:    1,
+					Description: "Paths to patch files relative to the root module, like \"${path.module}/patches/deployment.yaml\", or inline patches. Patches with a target are JSON patches and the others are strategic merge patches",
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+			},
+		},
+	},
+	KeyTemplateOutputDir: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     "",
+		Description: "Directory to write the rendered manifests to when dry_run is enabled, instead of template_output",
+	},
+	KeyTemplateOutputDirTemplate: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     "",
+		Description: "Go template for the per-release output directory, like {{ .OutputDir }}/{{ .Release.Namespace }}/{{ .Release.Name }}",
+	},
+	KeyTemplateOutputFileTemplate: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     "",
+		Description: "Go template for the per-release output file name. Requires template_output_dir or template_output_dir_template",
+	},
+	KeyCaptureEnvironmentValues: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, captures the resolved environment values into environment_info on plan and apply",
+	},
+	KeyEnvironmentInfo: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Resolved environment values as YAML with sensitive keys redacted, captured when capture_environment_values is enabled",
+	},
+	KeyPolicyCheck: {
+		Type:        schema.TypeList,
+		Optional:    true,
+		MaxItems:    1,
+		Description: "Command that is run against the rendered manifests on plan, like conftest test -",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyPolicyCheckCommand: {
+					Type:        schema.TypeList,
+					Required:    true,
+					MinItems:    1,
+					Description: "The command and its arguments. The rendered manifests are piped to its stdin, and it runs with the environment variables and the kubeconfig of helmfile diff",
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+				KeyPolicyCheckFailureMode: {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      PolicyCheckFailureModeError,
+					Description:  "Either error to fail the plan or warn to only tell the failure in policy_output when the policy check fails",
+					ValidateFunc: validation.StringInSlice([]string{PolicyCheckFailureModeError, PolicyCheckFailureModeWarn}, false),
+				},
+			},
+		},
+	},
+	KeyPolicyOutput: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Output from the policy_check command, preceded by the failure in warn mode",
+	},
+	KeyStrictDestroy: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, fails the delete when helmfile destroy finds no matching releases, instead of treating it as already destroyed, which the metrics_file of the provider records with result=\"nothing_to_destroy\"",
+	},
+	KeyDestroyScope: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      DestroyScopeSelectors,
+		ValidateFunc: validation.StringInSlice([]string{DestroyScopeSelectors, DestroyScopeManaged}, false),
+		Description:  "Either selectors to destroy the releases matching the selectors in the current content, or managed to destroy exactly the releases in managed_releases",
+	},
+	KeyDestroyCascade: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validation.StringInSlice([]string{DestroyCascadeBackground, DestroyCascadeForeground, DestroyCascadeOrphan}, false),
+		Description:  "Either background, foreground or orphan, passed to helm uninstall as --cascade on destroy. Requires helm 3.12.1 or later. Defaults to helm's default, background",
+	},
+	KeyDeleteWait: {
+		Type:        schema.TypeBool,
+		Optional:    true,
+		ForceNew:    false,
+		Default:     false,
+		Description: "When true, helm uninstall waits on destroy until the resources of the releases are deleted",
+	},
+	KeyDeleteTimeout: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validateHelmTimeout,
+		Description:  "Duration like 10m passed to helm uninstall as --timeout on destroy, which bounds delete_wait. Can't be set along with helm_timeout_destroy. Defaults to helmfile's default",
+	},
+	KeyManagedReleases: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Elem:        &schema.Schema{Type: schema.TypeString},
+		Description: "Releases installed by the applies of this resource, as namespace/name. Used by destroy_scope = \"managed\"",
+	},
+	KeyReleases: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Releases matching the selectors that the content declares to be installed, refreshed from the cluster. A release that is not installed makes the next plan reinstall it",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyReleaseName: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyReleaseNamespace: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyReleaseInstalled: {
+					Type:     schema.TypeBool,
+					Computed: true,
+				},
+				KeyReleaseVersion: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	},
+	KeyReleaseStatus: {
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Status of each release after the last apply, in the order helmfile processed them. Known after apply on the plans with changes",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				KeyReleaseName: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyReleaseNamespace: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyReleaseChart: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyReleaseVersion: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				KeyReleaseChanged: {
+					Type:     schema.TypeBool,
+					Computed: true,
+				},
+				KeyReleaseAction: {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	},
 	KeyEKSClusterName: {
 		Type:        schema.TypeString,
 		Optional:    true,
@@ -207,18 +1320,52 @@ var ReleaseSetSchema = map[string]*schema.Schema{
 		ForceNew:    false,
 		Description: "AWS region for EKS cluster (defaults to aws_region if not set)",
 	},
-	KeyEKSClusterEndpoint: {
+	KeyEKSClusterEndpoint: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Computed:    true,
+		Description: "EKS cluster endpoint (auto-discovered from AWS if not provided)",
+	},
+	KeyEKSClusterCA: {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Computed:    true,
+		Sensitive:   true,
+		Description: "EKS cluster certificate authority data (auto-discovered from AWS if not provided)",
+	},
+	KeyEKSAuthMode: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		Default:      EKSAuthModeExec,
+		ValidateFunc: validation.StringInSlice([]string{EKSAuthModeExec, EKSAuthModeNative}, false),
+		Description:  "Either exec to authenticate to the EKS cluster by running aws eks get-token, or native to have the provider generate the token, which needs no aws CLI",
+	},
+	KeyEKSClusterIdentity: {
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Endpoint and CA fingerprint of the EKS cluster discovered for eks_cluster_name by the last apply, used to detect that the cluster was recreated",
+	},
+	KeyEKSExecAPIVersion: {
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     false,
+		ValidateFunc: validation.StringInSlice([]string{ExecAPIVersionV1Alpha1, ExecAPIVersionV1Beta1, ExecAPIVersionV1}, false),
+		Description:  "apiVersion of the aws eks get-token exec plugin of the kubeconfig generated for eks_cluster_name. Detected from aws --version when not set, which is client.authentication.k8s.io/v1beta1 except for the aws CLI versions older than 1.24.0 and 2.7.0",
+	},
+	KeyClusterAuthExec: clusterAuthExecSchema(),
+	KeyClusterEndpoint: {
 		Type:        schema.TypeString,
 		Optional:    true,
-		Computed:    true,
-		Description: "EKS cluster endpoint (auto-discovered from AWS if not provided)",
+		ForceNew:    false,
+		Description: "Endpoint of the non-EKS cluster that cluster_auth_exec authenticates to",
 	},
-	KeyEKSClusterCA: {
+	KeyClusterCA: {
 		Type:        schema.TypeString,
 		Optional:    true,
-		Computed:    true,
+		ForceNew:    false,
 		Sensitive:   true,
-		Description: "EKS cluster certificate authority data (auto-discovered from AWS if not provided)",
+		Description: "Base64-encoded certificate authority data of the cluster of cluster_endpoint",
 	},
 }
 
@@ -232,12 +1379,31 @@ func resourceHelmfileReleaseSet() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: resourceReleaseSetImport,
 		},
-		Schema: ReleaseSetSchema,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(DefaultReleaseSetTimeout),
+			Update: schema.DefaultTimeout(DefaultReleaseSetTimeout),
+			Delete: schema.DefaultTimeout(DefaultReleaseSetTimeout),
+		},
+		Schema:        ReleaseSetSchema,
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				// Version 0 had the same schema, but no id_scheme for the release sets created before it
+				Version: 0,
+				Type:    (&schema.Resource{Schema: ReleaseSetSchema}).CoreConfigSchema().ImpliedType(),
+				Upgrade: upgradeReleaseSetStateV0,
+			},
+		},
 	}
 }
 
 //helpers to unwravel the recursive bits by adding a base condition
 func resourceReleaseSetCreate(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeReleaseSet, operationCreate, d)
+	defer metrics.record(d, &finalErr)
+
+	defer redactSensitiveError(&finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -246,18 +1412,71 @@ func resourceReleaseSetCreate(d *schema.ResourceData, meta interface{}) (finalEr
 
 	provider := meta.(*ProviderInstance)
 
-	fs, err := NewReleaseSet(d)
+	fs, err := NewReleaseSet(d, WithEKSClusterCache(provider.EKSClusterCache))
+	if err != nil {
+		return err
+	}
+	defer removeKubeconfigContent(fs)
+
+	provider.applyDefaults(fs)
+
+	sweepStaleKubeconfigs(kubeconfigDir(fs.WorkingDirectory), provider.StaleKubeconfigMaxAge)
+
+	fs.OperationTimeout = d.Timeout(schema.TimeoutCreate)
+
+	warnHelmTimeout(KeyHelmTimeoutApply, fs.HelmTimeoutApply, "create", fs.OperationTimeout)
+	warnHelmTimeout(KeyWaitTimeout, fs.WaitTimeout, "create", fs.OperationTimeout)
+
+	id, err := releaseSetID(d, fs)
 	if err != nil {
 		return err
 	}
 
-	if err := CreateReleaseSet(newContext(d), fs, d, provider.Executor); err != nil {
-		return fmt.Errorf("creating release set: %w", err)
+	// The ID is known before apply, so that the temporary files of the release set embed it
+	fs.ID = id
+
+	executor, recorder := reportingExecutor(fs, provider.executorFor(fs))
+
+	if err := CreateReleaseSet(newContext(d), fs, d, executor); err != nil {
+		reportApply(fs, recorder, d.Id(), operationCreate)
+		return fmt.Errorf("creating release set: %w", classifyAuthFailure(fs, explainWaitTimeout(fs, err)))
+	}
+
+	if err := setDefaultSelectorsHash(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEffectiveSelectors(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEphemeralValuesHash(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEffectiveKubeconfigSource(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEKSClusterIdentity(d, fs); err != nil {
+		return err
+	}
+
+	setEffectiveVersion(context.Background(), d, executor)
+
+	if err := refreshReleases(context.Background(), d, fs, executor); err != nil {
+		logf("Warning: not refreshing %s: %v", KeyReleases, err)
+	}
+
+	if err := setProviderConfigHash(d, provider); err != nil {
+		return err
 	}
 
 	d.MarkNewResource()
 
-	d.SetId(newId())
+	d.SetId(id)
+
+	reportApply(fs, recorder, id, operationCreate)
 
 	return nil
 }
@@ -270,25 +1489,56 @@ func newId() string {
 }
 
 func resourceReleaseSetRead(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	defer redactSensitiveError(&finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
 		}
 	}()
 
-	fs, err := NewReleaseSet(d)
+	provider := meta.(*ProviderInstance)
+
+	fs, err := NewReleaseSet(d, WithEKSClusterCache(provider.EKSClusterCache))
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(fs)
+
+	provider.applyDefaults(fs)
 
 	if err := ReadReleaseSet(newContext(d), fs, d); err != nil {
 		return fmt.Errorf("reading release set: %w", err)
 	}
 
+	// Recorded on refresh too, so that release sets created before the attribute existed show no change in the plan
+	if err := setEffectiveSelectors(d, fs); err != nil {
+		return err
+	}
+
+	// An unreachable cluster shouldn't fail the refresh, as the plan handles it
+	if err := refreshReleases(context.Background(), d, fs, provider.executorFor(fs)); err != nil {
+		logf("Warning: not refreshing %s: %v", KeyReleases, err)
+	}
+
+	if fs.DetectDrift && !fs.DryRun && fs.Kubeconfig != "" {
+		readDrift(d, fs, provider)
+	}
+
+	// Refreshed here rather than on plan, so that resources created before it existed don't need an apply
+	if err := setEffectiveKubeconfigSource(d, fs); err != nil {
+		logf("Warning: %v", err)
+	}
+
 	return nil
 }
 
 func resourceReleaseSetDiff(d *schema.ResourceDiff, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeReleaseSet, operationDiff, d)
+	defer metrics.record(d, &finalErr)
+
+	defer redactSensitiveError(&finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -296,32 +1546,152 @@ func resourceReleaseSetDiff(d *schema.ResourceDiff, meta interface{}) (finalErr
 	}()
 
 	old, new := d.GetChange(KeyWorkingDirectory)
-	log.Printf("Getting old and new working directories for id %q: old = %v, new = %v, got = %v", d.Id(), old, new, d.Get(KeyWorkingDirectory))
+	logf("Getting old and new working directories for id %q: old = %v, new = %v, got = %v", d.Id(), old, new, d.Get(KeyWorkingDirectory))
+
+	provider := meta.(*ProviderInstance)
 
-	fs, err := NewReleaseSet(d)
+	fs, err := NewReleaseSet(d, WithEKSClusterCache(provider.EKSClusterCache))
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(fs)
+
+	provider.applyDefaults(fs)
+
+	if err := validateContentSize(fs, provider.ContentSizeWarningBytes, provider.MaxContentSizeBytes); err != nil {
+		return err
+	}
+
+	// Content that depends on other resources is unknown until apply, and validated then by helmfile
+	if d.NewValueKnown(KeyContent) && d.NewValueKnown(KeyPath) {
+		path, _ := d.Get(KeyPath).(string)
+		if err := validateContent(fs.Content, path, fs.EnableGoTemplate); err != nil {
+			return err
+		}
+	}
+
+	// Likewise the directories and files created by other resources may not exist until apply
+	if d.NewValueKnown(KeyWorkingDirectory) {
+		if err := validateWorkingDirectory(fs.WorkingDirectory); err != nil {
+			return err
+		}
+
+		if d.NewValueKnown(KeyPath) {
+			if err := validatePath(d.Get(KeyPath).(string), fs.WorkingDirectory); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The ID scheme applies only to new resources. Its inputs can be unknown until apply when they depend on other resources.
+	if d.Id() == "" && d.NewValueKnown(KeyName) && d.NewValueKnown(KeyContent) {
+		if err := validateIDScheme(fs); err != nil {
+			return err
+		}
+	}
+
+	if err := setScratchDirectory(d, fs); err != nil {
+		return err
+	}
+
+	// Provider-level default selectors are not resource attributes, so we record their hash
+	// so that a change in them is detected as a change of this resource.
+	if err := setDefaultSelectorsHash(resourceDiffToFields(d), fs); err != nil {
+		return err
+	}
+
+	// The selectors can be unknown until apply when they depend on other resources
+	if d.NewValueKnown(KeySelector) && d.NewValueKnown(KeySelectors) {
+		if err := validateDefaultSelectors(fs); err != nil {
+			return err
+		}
+
+		if err := setEffectiveSelectors(resourceDiffToFields(d), fs); err != nil {
+			return err
+		}
+	} else {
+		d.SetNewComputed(KeyEffectiveSelectors)
+	}
+
+	// Likewise for the rest of the provider config
+	if err := setProviderConfigHash(resourceDiffToFields(d), provider); err != nil {
+		return err
+	}
+
+	// The ephemeral values are detected to change by their hash, so that the diff never needs the values in the state
+	if !d.NewValueKnown(KeyEphemeralValues) {
+		d.SetNewComputed(KeyEphemeralValuesHash)
+	} else if err := setEphemeralValuesHash(resourceDiffToFields(d), fs); err != nil {
+		return err
+	}
+
+	// The kubeconfig can be generated on apply, which can change how it is resolved,
+	// so a change in the resolution is known only after apply
+	if hasInputChanges(d, []string{KeyKubeconfig, KeyKubeconfigContent, KeyEnvironmentVariables, KeySensitiveEnvironmentVariables, KeyWorkingDirectory}) {
+		if k, err := resolveKubeconfig(fs); err != nil {
+			return err
+		} else if k.String() != d.Get(KeyEffectiveKubeconfigSource).(string) {
+			d.SetNewComputed(KeyEffectiveKubeconfigSource)
+		}
+	}
+
+	// The version is detected on plan too, so that a bin whose version can't be detected shows in the plan rather than
+	// only in the log of the apply leaving out the flags that depend on it
+	if d.NewValueKnown(KeyBin) {
+		_, err := detectVersion(provider.executorFor(fs))
+		setVersionWarning(resourceDiffToFields(d), err)
+	}
+
+	// Lint needs no cluster access, so it runs before anything that needs the kubeconfig
+	if fs.LintOnPlan {
+		if !d.NewValueKnown(KeyContent) {
+			logf("Skipping helmfile-lint because the content is not yet known")
+		} else if err := runLint(fs, provider.executorFor(fs)); err != nil {
+			return fmt.Errorf("linting release set: %w", err)
+		}
+	}
+
+	// print-env needs no cluster access either, so the plan shows the environment values that the apply captures
+	if fs.CaptureEnvironmentValues {
+		planEnvironmentInfo(d, fs, provider.executorFor(fs))
+	}
 
 	// When dry_run is enabled, skip diff entirely
 	// dry_run mode is for validation/testing only, not for managing actual cluster state
 	if fs.DryRun {
 		logf("Skipping helmfile-diff because dry_run is enabled (template validation mode)")
+		// dry_run never deploys anything, so there are no pending changes
+		d.SetNew(KeySummary, Summary{}.toList())
 		return nil
 	}
 
-	kubeconfig, err := getKubeconfig(fs)
+	// Nothing is installed yet for a new release set, so the live diff would only fail or show noise
+	if markNewResourceOutputs(d, fs.DiffNewResources) {
+		logf("Skipping helmfile-diff because the release set is not yet created. Set %s = true to run it", KeyDiffNewResources)
+
+		return runPlanChecks(d, fs, provider)
+	}
+
+	// The kubeconfig generated by another resource in the same apply doesn't exist yet on plan, which the helmfile-diff
+	// error below is tolerated for, so the file is verified to exist only before the commands that need it
+	kubeconfig, err := resolveKubeconfig(fs)
 	if err != nil {
 		return fmt.Errorf("getting kubeconfig: %w", err)
 	}
 
+	// The outputs recorded in the sensitive attributes or in files are unknown along with the outputs
+	outputs := outputAttributesOf(fs)
+
 	if fs.Kubeconfig == "" {
 		logf("Skipping helmfile-diff due to that kubeconfig is empty, which means that this operation has been called on a helmfile resource that depends on in-existent resource")
 
 		// Mark outputs as unknown so that plan expansion doesn't fail when
 		// the dependency becomes available and helmfile diff produces output.
-		d.SetNewComputed(KeyDiffOutput)
-		d.SetNewComputed(KeyApplyOutput)
+		markOutputComputed(d, KeyDiffOutput, outputs)
+		markOutputComputed(d, KeyApplyOutput, outputs)
+		d.SetNewComputed(KeySummary)
+		d.SetNewComputed(KeyReleaseStatus)
+		d.SetNewComputed(KeyApplySummary)
 
 		return nil
 	}
@@ -331,17 +1701,64 @@ func resourceReleaseSetDiff(d *schema.ResourceDiff, meta interface{}) (finalErr
 	} else if !v {
 		logf("Skipping helmfile-diff due to that one or more files listed in skip_diff_on_missing_files were missing")
 
-		d.SetNewComputed(KeyDiffOutput)
-		d.SetNewComputed(KeyApplyOutput)
+		markOutputComputed(d, KeyDiffOutput, outputs)
+		markOutputComputed(d, KeyApplyOutput, outputs)
+		d.SetNewComputed(KeySummary)
+		d.SetNewComputed(KeyReleaseStatus)
+		d.SetNewComputed(KeyApplySummary)
 
 		return nil
 	}
 
-	provider := meta.(*ProviderInstance)
+	checkExternalReleasesOnPlan(context.Background(), fs)
+
+	// A recreated EKS cluster is a change of the API server, which marks the outputs unknown as an input change
+	if _, err := markEKSClusterChange(resourceDiffToFields(d), fs); err != nil {
+		return err
+	}
 
-	diff, err := DiffReleaseSet(newContext(d), fs, resourceDiffToFields(d), WithDiffConfig(DiffConfig{
+	// The releases of an interrupted apply may be left partially upgraded, so the cached verdicts are stale
+	interrupted := hasInterruptedApply(d.Id())
+	if interrupted {
+		logf("Recomputing the plan of the release set because its last apply was interrupted")
+
+		fs.DiffCacheDir = ""
+	}
+
+	// The releases uninstalled out of band since the last refresh are reinstalled by the next apply
+	missing := missingReleases(resourceDiffToFields(d))
+	if len(missing) > 0 {
+		logf("Releases missing from the cluster: %s", strings.Join(missing, ", "))
+
+		fs.DiffCacheDir = ""
+	}
+
+	diffConf := DiffConfig{
 		MaxDiffOutputLen: provider.MaxDiffOutputLen,
-	}))
+	}
+
+	// The drift detected on refresh is the diff of the unchanged inputs, which the plan of the same operation doesn't run again
+	if fs.DetectDrift {
+		if drift, ok := cachedDrift(resourceDiffToFields(d)); ok {
+			logf("[DEBUG] Reusing the helmfile-diff run on refresh of release set %s", d.Id())
+
+			diffConf.Drift = drift
+		}
+	}
+
+	diff, err := DiffReleaseSet(newContext(d), fs, resourceDiffToFields(d), WithDiffConfig(diffConf))
+	var thresholdErr *diffThresholdError
+	if errors.As(err, &thresholdErr) {
+		return err
+	}
+
+	reportDiff(fs, provider.executorFor(fs), d.Id(), diff, err)
+
+	// Exec plugin failures are reported as the cluster being unreachable, but retrying the plan never fixes them
+	if err = classifyAuthFailure(fs, err); errorCategory(err) == ErrorCategoryAuthFailure {
+		return fmt.Errorf("diffing release set: %w", err)
+	}
+
 	if err != nil {
 		// helmfile_release_set.kubeconfig or helmfile_releaset_set.environment_variables.KUBECONFIG can be empty
 		// on `plan` if the value depends on another terraform resource.
@@ -351,37 +1768,116 @@ func resourceReleaseSetDiff(d *schema.ResourceDiff, meta interface{}) (finalErr
 
 		// Also ignore "Kubernetes cluster unreachable" errors which can happen with dummy/test kubeconfigs
 		if strings.Contains(err.Error(), "Kubernetes cluster unreachable") {
-			log.Printf("Ignoring helmfile-diff error because Kubernetes cluster is unreachable (may be using dummy kubeconfig or cluster not available): %v", err)
-			d.SetNewComputed(KeyDiffOutput)
-			d.SetNewComputed(KeyApplyOutput)
-		} else if *kubeconfig != "" {
+			logf("Ignoring helmfile-diff error because Kubernetes cluster is unreachable (may be using dummy kubeconfig or cluster not available): %v", err)
+			markOutputComputed(d, KeyDiffOutput, outputs)
+			markOutputComputed(d, KeyApplyOutput, outputs)
+		} else if kubeconfig.Path != "" {
 			// kubeconfig can be also empty when the kubeconfig path is static but not generated when terraform triggers
 			// diff on this release_set.
 			// We detect that situation by looking for the file.
 			// If the kubeconfig_path is not empty AND the file is in-existent, we may safely say that
 			// the path is static but the file is not yet generated.
-			// In code below, `info == nil` or `os.IsNotExist(err)` means that the file is in-existent.
-			if info, _ := os.Stat(*kubeconfig); info != nil {
+			// In code below, verify fails when any of the kubeconfig files is in-existent.
+			if kubeconfig.verify() == nil {
 				return fmt.Errorf("diffing release set: %w", err)
 			} else {
-				log.Printf("Ignoring helmfile-diff error on plan because kubeconfig file does not exist yet: %v", err)
-				d.SetNewComputed(KeyDiffOutput)
-				d.SetNewComputed(KeyApplyOutput)
+				logf("Ignoring helmfile-diff error on plan because kubeconfig file does not exist yet: %v", err)
+				markOutputComputed(d, KeyDiffOutput, outputs)
+				markOutputComputed(d, KeyApplyOutput, outputs)
 			}
 		} else {
-			log.Printf("Ignoring helmfile-diff error on plan because it may be due to that terraform's behaviour that "+
+			logf("Ignoring helmfile-diff error on plan because it may be due to that terraform's behaviour that "+
 				"helmfile_releaset_set.kubeconfig that depends on another missing resource can be empty: %v", err)
-			d.SetNewComputed(KeyDiffOutput)
-			d.SetNewComputed(KeyApplyOutput)
+			markOutputComputed(d, KeyDiffOutput, outputs)
+			markOutputComputed(d, KeyApplyOutput, outputs)
+		}
+
+		d.SetNewComputed(KeySummary)
+	}
+
+	changed := markDiffOutputs(d, diff, releaseSetInputKeys, outputs)
+
+	// apply_environment_variables don't affect the diff but change what apply does
+	changed = append(changed, markApplyOutput(d, []string{KeyApplyEnvironmentVariables}, outputs)...)
+
+	if interrupted {
+		markInterruptedApply(d, changeReason(changed, diff))
+	} else if len(missing) > 0 {
+		markMissingReleases(d, missing, changeReason(changed, diff))
+	} else {
+		setChangeReason(d, changed, diff)
+	}
+
+	// The summary is consistent with diff_output: unknown until apply when the inputs changed,
+	// as the diff may change when Terraform re-evaluates the plan with resolved values.
+	if err != nil || hasInputChanges(d, releaseSetInputKeys) {
+		d.SetNewComputed(KeySummary)
+	} else {
+		d.SetNew(KeySummary, diffSummary(diff).toList())
+	}
+
+	// release_status is left as is by the plans that don't apply, so that it keeps telling what the last apply did
+	if err != nil || diff != "" || len(changed) > 0 || interrupted || len(missing) > 0 {
+		d.SetNewComputed(KeyReleaseStatus)
+		d.SetNewComputed(KeyApplySummary)
+	}
+
+	return runPlanChecks(d, fs, provider)
+}
+
+// runPlanChecks runs the checks of the rendered manifests and values that don't need the live diff
+func runPlanChecks(d *schema.ResourceDiff, fs *ReleaseSet, provider *ProviderInstance) error {
+	if fs.PolicyCheck != nil {
+		policyOutput, err := runPolicyCheck(fs, provider.executorFor(fs))
+		if err != nil {
+			return fmt.Errorf("running policy check: %w", err)
 		}
+
+		d.SetNew(KeyPolicyOutput, policyOutput)
+	}
+
+	if fs.ValidateValuesAgainstSchema {
+		if err := validateValuesAgainstSchema(newContext(d), fs); err != nil {
+			return fmt.Errorf("validating values against chart schemas: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// setEphemeralValuesHash records the hash of ephemeral_values
+func setEphemeralValuesHash(d ResourceReadWrite, fs *ReleaseSet) error {
+	hash, err := ephemeralValuesHash(fs.EphemeralValues)
+	if err != nil {
+		return fmt.Errorf("computing hash of %s: %w", KeyEphemeralValues, err)
+	}
+
+	if err := d.Set(KeyEphemeralValuesHash, hash); err != nil {
+		return fmt.Errorf("setting %s: %w", KeyEphemeralValuesHash, err)
+	}
+
+	return nil
+}
+
+// setEffectiveSelectors records the selectors passed to helmfile, so that the plan shows the default selectors ANDed into them
+func setEffectiveSelectors(d ResourceReadWrite, fs *ReleaseSet) error {
+	if err := d.Set(KeyEffectiveSelectors, effectiveSelectors(fs)); err != nil {
+		return fmt.Errorf("setting %s: %w", KeyEffectiveSelectors, err)
+	}
+
+	return nil
+}
+
+// setDefaultSelectorsHash records the hash of the provider-level default selectors applied to the release set
+func setDefaultSelectorsHash(d ResourceReadWrite, fs *ReleaseSet) error {
+	hash, err := defaultSelectorsHash(fs)
+	if err != nil {
+		return fmt.Errorf("computing hash of default selectors: %w", err)
 	}
 
-	releaseSetInputKeys := []string{
-		KeyValues, KeyValuesFiles, KeyContent, KeyPath, KeyWorkingDirectory,
-		KeyEnvironment, KeyEnvironmentVariables, KeyBin, KeyHelmBin,
-		KeySelector, KeySelectors, KeyKubeconfig,
+	if err := d.Set(KeyDefaultSelectorsHash, hash); err != nil {
+		return fmt.Errorf("setting %s: %w", KeyDefaultSelectorsHash, err)
 	}
-	markDiffOutputs(d, diff, releaseSetInputKeys)
 
 	return nil
 }
@@ -393,29 +1889,174 @@ type diffChecker interface {
 	SetNewComputed(key string) error
 }
 
+// hasInputChanges returns true when any of the input attributes has changed
+func hasInputChanges(d diffChecker, inputKeys []string) bool {
+	return len(changedInputKeys(d, inputKeys)) > 0
+}
+
+// changedInputKeys returns the input attributes that have changed, in the order of inputKeys
+func changedInputKeys(d diffChecker, inputKeys []string) []string {
+	var changed []string
+	for _, key := range inputKeys {
+		if d.HasChange(key) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
 // markDiffOutputs marks diff_output and apply_output as computed when input attributes
 // have changed, preventing "inconsistent final plan" errors. When Terraform re-evaluates
 // CustomizeDiff during apply's plan expansion with resolved values from dependent
 // resources, the helmfile diff result may change. Marking outputs as computed tells
 // Terraform these values will be determined during apply.
-func markDiffOutputs(d diffChecker, diff string, inputKeys []string) {
-	hasInputChanges := false
-	for _, key := range inputKeys {
-		if d.HasChange(key) {
-			hasInputChanges = true
-			break
+// The sensitive attributes recording the outputs are marked as well, and the paths and hashes of the outputs written
+// to files are marked in place of the outputs, so that they tell the change just as the outputs do.
+// It returns the input attributes that have changed.
+func markDiffOutputs(d diffChecker, diff string, inputKeys []string, outputs outputAttributes) []string {
+	changed := changedInputKeys(d, inputKeys)
+
+	if len(changed) > 0 {
+		markOutputComputed(d, KeyDiffOutput, outputs)
+		markOutputComputed(d, KeyApplyOutput, outputs)
+	} else if diff != "" {
+		markOutputComputed(d, KeyApplyOutput, outputs)
+	}
+
+	return changed
+}
+
+// sensitiveOutputKeys are the sensitive attributes recording the outputs with suppress_secrets = false or sensitive_outputs = true
+var sensitiveOutputKeys = map[string]string{
+	KeyDiffOutput:  KeySensitiveDiffOutput,
+	KeyApplyOutput: KeySensitiveApplyOutput,
+}
+
+// markOutputComputed marks the output as computed, along with the sensitive attribute recording it. When the output is
+// written to a file, only the path and the hash of the file are marked, as the output itself is never recorded.
+func markOutputComputed(d diffChecker, key string, outputs outputAttributes) {
+	if outputs.Files {
+		d.SetNewComputed(outputFileKeys[key][0])
+		d.SetNewComputed(outputFileKeys[key][1])
+		return
+	}
+
+	d.SetNewComputed(key)
+
+	if outputs.Sensitive {
+		d.SetNewComputed(sensitiveOutputKeys[key])
+	}
+}
+
+const (
+	ChangeReasonNewResource    = "new resource"
+	ChangeReasonValues         = "values changed"
+	ChangeReasonContent        = "content changed"
+	ChangeReasonProviderConfig = "provider config changed"
+	ChangeReasonDrift          = "cluster drift detected"
+	ChangeReasonCluster        = "cluster changed"
+)
+
+// changeReasonsByKey groups the input attributes into the change reasons. The other attributes are reported by name.
+var changeReasonsByKey = map[string]string{
+	KeyValues:               ChangeReasonValues,
+	KeyValuesFiles:          ChangeReasonValues,
+	KeyContent:              ChangeReasonContent,
+	KeyPath:                 ChangeReasonContent,
+	KeyKustomizePatches:     ChangeReasonContent,
+	KeyCommonLabels:         ChangeReasonContent,
+	KeyProviderConfigHash:   ChangeReasonProviderConfig,
+	KeyDefaultSelectorsHash: ChangeReasonProviderConfig,
+	KeyEphemeralValuesHash:  ChangeReasonValues,
+	KeyEKSClusterEndpoint:   ChangeReasonCluster,
+	KeyEKSClusterCA:         ChangeReasonCluster,
+	KeyEKSClusterIdentity:   ChangeReasonCluster,
+}
+
+// changeReason returns the single-line reason of the change for the changed input attributes,
+// or for the diff when no input attribute has changed. It is empty when there is no change.
+func changeReason(changed []string, diff string) string {
+	var reasons []string
+
+	seen := map[string]bool{}
+	for _, key := range changed {
+		reason, ok := changeReasonsByKey[key]
+		if !ok {
+			reason = key + " changed"
+		}
+
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
 		}
 	}
 
-	if hasInputChanges {
-		d.SetNewComputed(KeyDiffOutput)
-		d.SetNewComputed(KeyApplyOutput)
-	} else if diff != "" {
-		d.SetNewComputed(KeyApplyOutput)
+	if len(reasons) == 0 && diff != "" {
+		reasons = append(reasons, ChangeReasonDrift)
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// setChangeReason sets change_reason for the plan with changes.
+// It is left as is without changes, so that it never shows up in the plan on its own.
+func setChangeReason(d newResourceDiffChecker, changed []string, diff string) {
+	reason := changeReason(changed, diff)
+	if d.Id() == "" {
+		reason = ChangeReasonNewResource
+	}
+
+	if reason != "" {
+		d.SetNew(KeyChangeReason, reason)
+	}
+}
+
+// NewResourceDiffOutput is the diff_output of a release set that is not yet created, for which helmfile diff is skipped
+const NewResourceDiffOutput = "(new resource — all releases will be installed)"
+
+// newResourceDiffChecker abstracts the methods of schema.ResourceDiff that markNewResourceOutputs uses
+// for testability.
+type newResourceDiffChecker interface {
+	diffChecker
+	Id() string
+	SetNew(key string, value interface{}) error
+}
+
+// markNewResourceOutputs sets diff_output to NewResourceDiffOutput and marks apply_output and summary as computed
+// when the release set is not yet created, unless diff_new_resources is enabled.
+// It returns true when helmfile diff should be skipped.
+func markNewResourceOutputs(d newResourceDiffChecker, diffNewResources bool) bool {
+	if d.Id() != "" || diffNewResources {
+		return false
+	}
+
+	d.SetNew(KeyDiffOutput, NewResourceDiffOutput)
+	d.SetNew(KeyChangeReason, ChangeReasonNewResource)
+	d.SetNewComputed(KeyApplyOutput)
+	d.SetNewComputed(KeySummary)
+
+	return true
+}
+
+// markApplyOutput marks only apply_output as computed when apply-only input attributes have changed,
+// as they trigger an apply that produces a new apply_output while leaving diff_output as is.
+// It returns the apply-only input attributes that have changed.
+func markApplyOutput(d diffChecker, applyOnlyKeys []string, outputs outputAttributes) []string {
+	changed := changedInputKeys(d, applyOnlyKeys)
+
+	if len(changed) > 0 {
+		markOutputComputed(d, KeyApplyOutput, outputs)
 	}
+
+	return changed
 }
 
 func resourceReleaseSetUpdate(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeReleaseSet, operationUpdate, d)
+	defer metrics.record(d, &finalErr)
+
+	defer redactSensitiveError(&finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -424,15 +2065,65 @@ func resourceReleaseSetUpdate(d *schema.ResourceData, meta interface{}) (finalEr
 
 	provider := meta.(*ProviderInstance)
 
-	fs, err := NewReleaseSet(d)
+	fs, err := NewReleaseSet(d, WithEKSClusterCache(provider.EKSClusterCache))
+	if err != nil {
+		return err
+	}
+	defer removeKubeconfigContent(fs)
+
+	provider.applyDefaults(fs)
+
+	sweepStaleKubeconfigs(kubeconfigDir(fs.WorkingDirectory), provider.StaleKubeconfigMaxAge)
+
+	fs.OperationTimeout = d.Timeout(schema.TimeoutUpdate)
+
+	warnHelmTimeout(KeyHelmTimeoutApply, fs.HelmTimeoutApply, "update", fs.OperationTimeout)
+	warnHelmTimeout(KeyWaitTimeout, fs.WaitTimeout, "update", fs.OperationTimeout)
+
+	executor, recorder := reportingExecutor(fs, provider.executorFor(fs))
+
+	err = UpdateReleaseSet(newContext(d), fs, d, executor)
+	reportApply(fs, recorder, d.Id(), operationUpdate)
+	forgetDrift(d.Id())
 	if err != nil {
+		return classifyAuthFailure(fs, explainWaitTimeout(fs, err))
+	}
+
+	if err := setDefaultSelectorsHash(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEffectiveSelectors(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEphemeralValuesHash(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEffectiveKubeconfigSource(d, fs); err != nil {
+		return err
+	}
+
+	if err := setEKSClusterIdentity(d, fs); err != nil {
 		return err
 	}
 
-	return UpdateReleaseSet(newContext(d), fs, d, provider.Executor)
+	setEffectiveVersion(context.Background(), d, executor)
+
+	if err := refreshReleases(context.Background(), d, fs, executor); err != nil {
+		logf("Warning: not refreshing %s: %v", KeyReleases, err)
+	}
+
+	return setProviderConfigHash(d, provider)
 }
 
 func resourceReleaseSetDelete(d *schema.ResourceData, meta interface{}) (finalErr error) {
+	metrics := newOperationMetrics(meta, resourceTypeReleaseSet, operationDelete, d)
+	defer metrics.record(d, &finalErr)
+
+	defer redactSensitiveError(&finalErr)
+
 	defer func() {
 		if err := recover(); err != nil {
 			finalErr = fmt.Errorf("unhandled error: %v\n%s", err, debug.Stack())
@@ -441,15 +2132,31 @@ func resourceReleaseSetDelete(d *schema.ResourceData, meta interface{}) (finalEr
 
 	provider := meta.(*ProviderInstance)
 
-	fs, err := NewReleaseSet(d)
+	fs, err := NewReleaseSet(d, WithEKSClusterCache(provider.EKSClusterCache))
 	if err != nil {
 		return err
 	}
+	defer removeKubeconfigContent(fs)
 
-	if err := DeleteReleaseSet(newContext(d), fs, d, provider.Executor); err != nil {
-		return err
+	provider.applyDefaults(fs)
+
+	fs.OperationTimeout = d.Timeout(schema.TimeoutDelete)
+
+	warnHelmTimeout(KeyHelmTimeoutDestroy, fs.HelmTimeoutDestroy, "delete", fs.OperationTimeout)
+	warnHelmTimeout(KeyDeleteTimeout, fs.DeleteTimeout, "delete", fs.OperationTimeout)
+
+	if err := DeleteReleaseSet(newContext(d), fs, d, provider.executorFor(fs)); err != nil {
+		return classifyAuthFailure(fs, err)
+	}
+
+	// The plugin SDK can't warn on delete, so the releases uninstalled out of band are told by the metrics
+	if fs.nothingToDestroy {
+		metrics.setResult(operationResultNothingToDestroy)
 	}
 
+	clearInterruptedApply(d.Id())
+	forgetDrift(d.Id())
+
 	d.SetId("")
 
 	return nil
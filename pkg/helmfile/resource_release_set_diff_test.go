@@ -1,19 +1,25 @@
 package helmfile
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 )
 
 // mockDiffChecker implements diffChecker for unit testing markDiffOutputs.
 type mockDiffChecker struct {
-	changes     map[string]bool // keys that have changes
-	newComputed map[string]bool // keys marked as computed via SetNewComputed
+	id          string
+	changes     map[string]bool        // keys that have changes
+	newComputed map[string]bool        // keys marked as computed via SetNewComputed
+	newValues   map[string]interface{} // values set via SetNew
 }
 
 func newMockDiffChecker(changedKeys ...string) *mockDiffChecker {
 	m := &mockDiffChecker{
 		changes:     make(map[string]bool),
 		newComputed: make(map[string]bool),
+		newValues:   make(map[string]interface{}),
 	}
 	for _, k := range changedKeys {
 		m.changes[k] = true
@@ -30,6 +36,15 @@ func (m *mockDiffChecker) SetNewComputed(key string) error {
 	return nil
 }
 
+func (m *mockDiffChecker) Id() string {
+	return m.id
+}
+
+func (m *mockDiffChecker) SetNew(key string, value interface{}) error {
+	m.newValues[key] = value
+	return nil
+}
+
 func TestMarkDiffOutputs_InputChanges_MarksBothComputed(t *testing.T) {
 	// When an input attribute has changed, both diff_output and apply_output
 	// must be marked as computed to avoid "inconsistent final plan" errors
@@ -41,7 +56,7 @@ func TestMarkDiffOutputs_InputChanges_MarksBothComputed(t *testing.T) {
 			d := newMockDiffChecker(changedKey)
 
 			// diff is empty (no changes detected during plan), but input changed
-			markDiffOutputs(d, "", inputKeys)
+			markDiffOutputs(d, "", inputKeys, outputAttributes{})
 
 			if !d.newComputed[KeyDiffOutput] {
 				t.Errorf("expected diff_output to be marked computed when %s changed", changedKey)
@@ -59,7 +74,7 @@ func TestMarkDiffOutputs_InputChangesWithDiff_MarksBothComputed(t *testing.T) {
 	d := newMockDiffChecker(KeyValues)
 	inputKeys := []string{KeyValues, KeyContent}
 
-	markDiffOutputs(d, "some diff output", inputKeys)
+	markDiffOutputs(d, "some diff output", inputKeys, outputAttributes{})
 
 	if !d.newComputed[KeyDiffOutput] {
 		t.Error("expected diff_output to be marked computed when inputs changed, even with diff")
@@ -75,7 +90,7 @@ func TestMarkDiffOutputs_NoInputChanges_DiffPresent_MarksOnlyApplyOutput(t *test
 	d := newMockDiffChecker() // no changes
 	inputKeys := []string{KeyValues, KeyContent}
 
-	markDiffOutputs(d, "some diff output", inputKeys)
+	markDiffOutputs(d, "some diff output", inputKeys, outputAttributes{})
 
 	if d.newComputed[KeyDiffOutput] {
 		t.Error("expected diff_output to NOT be marked computed when no inputs changed")
@@ -85,13 +100,45 @@ func TestMarkDiffOutputs_NoInputChanges_DiffPresent_MarksOnlyApplyOutput(t *test
 	}
 }
 
+func TestMarkDiffOutputs_SensitiveOutputs(t *testing.T) {
+	// The outputs recorded in the sensitive attributes are unknown along with the outputs
+	d := newMockDiffChecker(KeyValues)
+
+	markDiffOutputs(d, "", []string{KeyValues}, outputAttributes{Sensitive: true})
+
+	for _, key := range []string{KeyDiffOutput, KeyApplyOutput, KeySensitiveDiffOutput, KeySensitiveApplyOutput} {
+		if !d.newComputed[key] {
+			t.Errorf("expected %s to be marked computed when inputs changed", key)
+		}
+	}
+
+	d = newMockDiffChecker()
+
+	markDiffOutputs(d, "some diff output", []string{KeyValues}, outputAttributes{Sensitive: true})
+
+	if d.newComputed[KeyDiffOutput] || d.newComputed[KeySensitiveDiffOutput] {
+		t.Error("expected diff_output and sensitive_diff_output to NOT be marked computed when no inputs changed")
+	}
+	if !d.newComputed[KeyApplyOutput] || !d.newComputed[KeySensitiveApplyOutput] {
+		t.Error("expected apply_output and sensitive_apply_output to be marked computed when diff is present")
+	}
+
+	d = newMockDiffChecker(KeyApplyEnvironmentVariables)
+
+	markApplyOutput(d, []string{KeyApplyEnvironmentVariables}, outputAttributes{Sensitive: true})
+
+	if !d.newComputed[KeySensitiveApplyOutput] {
+		t.Error("expected sensitive_apply_output to be marked computed when apply_environment_variables changed")
+	}
+}
+
 func TestMarkDiffOutputs_NoInputChanges_NoDiff_MarksNothing(t *testing.T) {
 	// When nothing changed and no diff, no outputs should be marked computed.
 	// This is the steady-state "no changes needed" case.
 	d := newMockDiffChecker() // no changes
 	inputKeys := []string{KeyValues, KeyContent}
 
-	markDiffOutputs(d, "", inputKeys)
+	markDiffOutputs(d, "", inputKeys, outputAttributes{})
 
 	if d.newComputed[KeyDiffOutput] {
 		t.Error("expected diff_output to NOT be marked computed when nothing changed")
@@ -106,7 +153,7 @@ func TestMarkDiffOutputs_MultipleInputChanges(t *testing.T) {
 	d := newMockDiffChecker(KeyValues, KeyContent, KeyKubeconfig)
 	inputKeys := []string{KeyValues, KeyContent, KeyKubeconfig}
 
-	markDiffOutputs(d, "", inputKeys)
+	markDiffOutputs(d, "", inputKeys, outputAttributes{})
 
 	if !d.newComputed[KeyDiffOutput] {
 		t.Error("expected diff_output to be marked computed")
@@ -121,7 +168,7 @@ func TestMarkDiffOutputs_IrrelevantKeyChanged(t *testing.T) {
 	d := newMockDiffChecker("some_other_key")
 	inputKeys := []string{KeyValues, KeyContent}
 
-	markDiffOutputs(d, "", inputKeys)
+	markDiffOutputs(d, "", inputKeys, outputAttributes{})
 
 	if d.newComputed[KeyDiffOutput] {
 		t.Error("expected diff_output to NOT be marked computed for irrelevant key change")
@@ -137,13 +184,15 @@ func TestMarkDiffOutputs_ReleaseSetInputKeys(t *testing.T) {
 	releaseSetInputKeys := []string{
 		KeyValues, KeyValuesFiles, KeyContent, KeyPath, KeyWorkingDirectory,
 		KeyEnvironment, KeyEnvironmentVariables, KeyBin, KeyHelmBin,
-		KeySelector, KeySelectors, KeyKubeconfig,
+		KeySelector, KeySelectors, KeyKubeconfig, KeyDefaultSelectorsHash,
+		KeyCommonLabels, KeyProviderConfigHash, KeyKustomizePatches,
+		KeyDiffEnvironmentVariables,
 	}
 
 	for _, key := range releaseSetInputKeys {
 		t.Run(key, func(t *testing.T) {
 			d := newMockDiffChecker(key)
-			markDiffOutputs(d, "", releaseSetInputKeys)
+			markDiffOutputs(d, "", releaseSetInputKeys, outputAttributes{})
 
 			if !d.newComputed[KeyDiffOutput] {
 				t.Errorf("expected diff_output to be marked computed when %s changed", key)
@@ -155,19 +204,61 @@ func TestMarkDiffOutputs_ReleaseSetInputKeys(t *testing.T) {
 	}
 }
 
+func TestMarkApplyOutput_ApplyOnlyKeyChanged(t *testing.T) {
+	// apply_environment_variables don't affect helmfile diff, so changing them must
+	// not churn diff_output, but the apply they trigger produces a new apply_output.
+	releaseSetInputKeys := []string{KeyValues, KeyContent, KeyEnvironmentVariables, KeyDiffEnvironmentVariables}
+
+	d := newMockDiffChecker(KeyApplyEnvironmentVariables)
+	markDiffOutputs(d, "", releaseSetInputKeys, outputAttributes{})
+	markApplyOutput(d, []string{KeyApplyEnvironmentVariables}, outputAttributes{})
+
+	if d.newComputed[KeyDiffOutput] {
+		t.Error("expected diff_output to NOT be marked computed when only apply_environment_variables changed")
+	}
+	if !d.newComputed[KeyApplyOutput] {
+		t.Error("expected apply_output to be marked computed when apply_environment_variables changed")
+	}
+}
+
+func TestMarkApplyOutput_DiffOnlyKeyChanged(t *testing.T) {
+	// diff_environment_variables change the diff like any other input.
+	releaseSetInputKeys := []string{KeyValues, KeyContent, KeyEnvironmentVariables, KeyDiffEnvironmentVariables}
+
+	d := newMockDiffChecker(KeyDiffEnvironmentVariables)
+	markDiffOutputs(d, "", releaseSetInputKeys, outputAttributes{})
+	markApplyOutput(d, []string{KeyApplyEnvironmentVariables}, outputAttributes{})
+
+	if !d.newComputed[KeyDiffOutput] {
+		t.Error("expected diff_output to be marked computed when diff_environment_variables changed")
+	}
+	if !d.newComputed[KeyApplyOutput] {
+		t.Error("expected apply_output to be marked computed when diff_environment_variables changed")
+	}
+}
+
+func TestMarkApplyOutput_NoChanges(t *testing.T) {
+	d := newMockDiffChecker(KeyValues)
+	markApplyOutput(d, []string{KeyApplyEnvironmentVariables}, outputAttributes{})
+
+	if d.newComputed[KeyApplyOutput] || d.newComputed[KeyDiffOutput] {
+		t.Error("expected nothing to be marked computed when no apply-only keys changed")
+	}
+}
+
 func TestMarkDiffOutputs_ReleaseInputKeys(t *testing.T) {
 	// Verify that the release input keys used in resourceHelmfileReleaseDiff
 	// are all recognized — changing any of them marks outputs computed.
 	releaseInputKeys := []string{
 		KeyValues, KeyChart, KeyVersion, KeyWorkingDirectory,
 		KeyKubeconfig, KeyKubecontext, KeyBin, KeyHelmBin,
-		KeyNamespace, KeyName,
+		KeyNamespace, KeyName, KeyProviderConfigHash,
 	}
 
 	for _, key := range releaseInputKeys {
 		t.Run(key, func(t *testing.T) {
 			d := newMockDiffChecker(key)
-			markDiffOutputs(d, "", releaseInputKeys)
+			markDiffOutputs(d, "", releaseInputKeys, outputAttributes{})
 
 			if !d.newComputed[KeyDiffOutput] {
 				t.Errorf("expected diff_output to be marked computed when %s changed", key)
@@ -178,3 +269,217 @@ func TestMarkDiffOutputs_ReleaseInputKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestMarkDiffOutputs_ProviderConfigHashChanged(t *testing.T) {
+	// A change in the provider config doesn't touch any other resource attribute,
+	// so provider_config_hash alone must mark the outputs computed.
+	inputKeys := []string{KeyValues, KeyContent, KeyProviderConfigHash}
+
+	d := newMockDiffChecker(KeyProviderConfigHash)
+	markDiffOutputs(d, "", inputKeys, outputAttributes{})
+
+	if !d.newComputed[KeyDiffOutput] {
+		t.Error("expected diff_output to be marked computed when provider_config_hash changed")
+	}
+	if !d.newComputed[KeyApplyOutput] {
+		t.Error("expected apply_output to be marked computed when provider_config_hash changed")
+	}
+}
+
+func TestProviderConfigHash(t *testing.T) {
+	hash := func(raw map[string]interface{}) string {
+		t.Helper()
+
+		p, err := New(schema.TestResourceDataRaw(t, Provider().(*schema.Provider).Schema, raw))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return p.ConfigHash
+	}
+
+	if got := hash(map[string]interface{}{}); got != "" {
+		t.Errorf("expected empty hash for the default provider config, got %q", got)
+	}
+
+	explicitDefaults := map[string]interface{}{
+		KeyMaxDiffOutputLen:      DefaultMaxDiffOutputLen,
+		KeyExecutor:              ExecutorLibrary,
+		KeyEKSClusterCacheTTL:    DefaultEKSClusterCacheTTL.String(),
+		KeyStaleKubeconfigMaxAge: DefaultStaleKubeconfigMaxAge.String(),
+	}
+	if got := hash(explicitDefaults); got != "" {
+		t.Errorf("expected empty hash for the defaults set explicitly, got %q", got)
+	}
+
+	custom := map[string]interface{}{KeyMaxDiffOutputLen: 8192, KeyDefaultSelectors: []interface{}{"team=foo"}}
+	first := hash(custom)
+	if first == "" {
+		t.Fatal("expected non-empty hash")
+	}
+
+	if second := hash(custom); first != second {
+		t.Errorf("expected stable hash, got %q and %q", first, second)
+	}
+
+	custom[KeyMaxDiffOutputLen] = 16384
+	if third := hash(custom); third == first {
+		t.Error("expected the hash to change with max_diff_output_len")
+	}
+
+	changed := map[string]bool{}
+	for key, value := range map[string]interface{}{
+		KeyMaxOutputLen:            2048,
+		KeyContentSizeWarningBytes: 1024,
+		KeyMaxContentSizeBytes:     4096,
+		KeyMetricsFile:             "/var/lib/node_exporter/helmfile.prom",
+		KeyExecutor:                ExecutorBinary,
+		KeyEKSClusterCacheTTL:      "0s",
+		KeyStaleKubeconfigMaxAge:   "1h",
+		KeyDefaultConcurrency:      4,
+		KeyMaxParallelOperations:   2,
+	} {
+		got := hash(map[string]interface{}{key: value})
+		if got == "" || changed[got] {
+			t.Errorf("expected the hash to change with %s, got %q", key, got)
+		}
+		changed[got] = true
+	}
+}
+
+func TestNewInvalidDuration(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provider().(*schema.Provider).Schema, map[string]interface{}{})
+	if err := d.Set(KeyEKSClusterCacheTTL, "ten minutes"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(d); err == nil || !strings.Contains(err.Error(), KeyEKSClusterCacheTTL) {
+		t.Errorf("expected an error naming %s, got %v", KeyEKSClusterCacheTTL, err)
+	}
+}
+
+func TestMarkNewResourceOutputs_NewResource_SkipsDiff(t *testing.T) {
+	// A release set without an ID is being created, so the live diff is skipped
+	// and diff_output is set to the fixed marker.
+	d := newMockDiffChecker(KeyContent, KeyValues)
+
+	if !markNewResourceOutputs(d, false) {
+		t.Fatal("expected the diff of a new resource to be skipped")
+	}
+
+	if got := d.newValues[KeyDiffOutput]; got != NewResourceDiffOutput {
+		t.Errorf("expected diff_output to be %q, got %v", NewResourceDiffOutput, got)
+	}
+	if got := d.newValues[KeyChangeReason]; got != ChangeReasonNewResource {
+		t.Errorf("expected change_reason to be %q, got %v", ChangeReasonNewResource, got)
+	}
+	if d.newComputed[KeyDiffOutput] {
+		t.Error("expected diff_output not to be marked computed for a new resource")
+	}
+	if !d.newComputed[KeyApplyOutput] {
+		t.Error("expected apply_output to be marked computed for a new resource")
+	}
+	if !d.newComputed[KeySummary] {
+		t.Error("expected summary to be marked computed for a new resource")
+	}
+}
+
+func TestMarkNewResourceOutputs_DiffNewResources_RunsDiff(t *testing.T) {
+	// diff_new_resources = true keeps the install-time diff on plan
+	d := newMockDiffChecker(KeyContent)
+
+	if markNewResourceOutputs(d, true) {
+		t.Fatal("expected the diff of a new resource to run with diff_new_resources")
+	}
+
+	if len(d.newValues) > 0 || len(d.newComputed) > 0 {
+		t.Errorf("expected nothing to be marked, got values %v and computed %v", d.newValues, d.newComputed)
+	}
+
+	// The usual marking applies to the diff that is run
+	markDiffOutputs(d, "some diff output", []string{KeyContent}, outputAttributes{})
+
+	if !d.newComputed[KeyDiffOutput] || !d.newComputed[KeyApplyOutput] {
+		t.Error("expected diff_output and apply_output to be marked computed when content changed")
+	}
+}
+
+func TestMarkNewResourceOutputs_ExistingResource_RunsDiff(t *testing.T) {
+	d := newMockDiffChecker(KeyValues)
+	d.id = "abc123"
+
+	if markNewResourceOutputs(d, false) {
+		t.Fatal("expected the diff of an existing resource to run")
+	}
+
+	if _, ok := d.newValues[KeyDiffOutput]; ok {
+		t.Error("expected diff_output not to be set for an existing resource")
+	}
+}
+
+func TestMarkDiffOutputs_ReturnsChangedKeys(t *testing.T) {
+	d := newMockDiffChecker(KeyContent, KeyKubeconfig)
+
+	changed := markDiffOutputs(d, "", []string{KeyValues, KeyKubeconfig, KeyContent}, outputAttributes{})
+
+	if len(changed) != 2 || changed[0] != KeyKubeconfig || changed[1] != KeyContent {
+		t.Errorf("expected the changed keys in the order of the input keys, got %v", changed)
+	}
+
+	if changed := markDiffOutputs(newMockDiffChecker(), "some diff output", []string{KeyValues}, outputAttributes{}); len(changed) != 0 {
+		t.Errorf("expected no changed keys for the cluster drift, got %v", changed)
+	}
+}
+
+func TestChangeReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		changed []string
+		diff    string
+		want    string
+	}{
+		{name: "values", changed: []string{KeyValues, KeyValuesFiles}, want: ChangeReasonValues},
+		{name: "content", changed: []string{KeyContent}, diff: "some diff output", want: ChangeReasonContent},
+		{name: "provider config", changed: []string{KeyProviderConfigHash}, want: ChangeReasonProviderConfig},
+		{name: "default selectors", changed: []string{KeyDefaultSelectorsHash}, want: ChangeReasonProviderConfig},
+		{name: "several", changed: []string{KeyValues, KeyContent, KeyValuesFiles}, want: "values changed, content changed"},
+		{name: "other attributes by name", changed: []string{KeyKubeconfig, KeyApplyEnvironmentVariables}, want: "kubeconfig changed, apply_environment_variables changed"},
+		{name: "drift", diff: "some diff output", want: ChangeReasonDrift},
+		{name: "no change", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changeReason(tt.changed, tt.diff); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSetChangeReason(t *testing.T) {
+	d := newMockDiffChecker()
+	d.id = "abc123"
+
+	setChangeReason(d, []string{KeyValues}, "")
+	if got := d.newValues[KeyChangeReason]; got != ChangeReasonValues {
+		t.Errorf("expected change_reason to be %q, got %v", ChangeReasonValues, got)
+	}
+
+	// Without changes, change_reason is left as is so that it doesn't show up in the plan on its own
+	d = newMockDiffChecker()
+	d.id = "abc123"
+
+	setChangeReason(d, nil, "")
+	if _, ok := d.newValues[KeyChangeReason]; ok {
+		t.Error("expected change_reason not to be set without changes")
+	}
+
+	// A new resource run with diff_new_resources
+	d = newMockDiffChecker(KeyContent)
+
+	setChangeReason(d, []string{KeyContent}, "some diff output")
+	if got := d.newValues[KeyChangeReason]; got != ChangeReasonNewResource {
+		t.Errorf("expected change_reason to be %q, got %v", ChangeReasonNewResource, got)
+	}
+}
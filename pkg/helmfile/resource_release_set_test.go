@@ -2,6 +2,11 @@ package helmfile
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -27,6 +32,7 @@ func TestAccHelmfileReleaseSet_basic(t *testing.T) {
 					resource.TestCheckResourceAttr(resourceName, "values.#", "1"),
 					resource.TestCheckResourceAttr(resourceName, "diff_output", wantedHelmfileDiffOutputForReleaseID(releaseID)),
 					resource.TestCheckResourceAttrSet(resourceName, "id"),
+					resource.TestMatchResourceAttr(resourceName, "effective_version", regexp.MustCompile(`^\d+\.\d+\.\d+`)),
 				),
 			},
 		},
@@ -56,6 +62,615 @@ func TestAccHelmfileReleaseSet_binaries(t *testing.T) {
 	})
 }
 
+// TestAccHelmfileReleaseSet_helmGit renders a chart referenced by a git+file:// URL from a local fixture repository,
+// which requires the helm-git downloader plugin to be found in library mode.
+func TestAccHelmfileReleaseSet_helmGit(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	repoDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-helm-git-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			testAccPreCheckHelmGit(t)
+			testAccCreateChartRepo(t, repoDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_helmGit(releaseID, repoDir),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile("name: helm-git-"+releaseID)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_kustomizePatches renders a local chart with a patch file from the test's module directory
+// and an inline patch, which helmfile applies with chartify.
+func TestAccHelmfileReleaseSet_kustomizePatches(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-kustomize-patches-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			testAccPreCheckKustomize(t)
+			testAccCreateKustomizePatchesFixture(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_kustomizePatches(releaseID, chartDir),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`strategic: "patched"`)),
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`json: "patched"`)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_commonLabels renders a chart with common_labels, which should label the rendered objects
+func TestAccHelmfileReleaseSet_commonLabels(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-common-labels-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			testAccPreCheckKustomize(t)
+			testAccCreateKustomizePatchesFixture(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_commonLabels(releaseID, chartDir),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`team: platform`)),
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`terraform.io/resource-address: helmfile_release_set.the_product`)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_executors applies the same release set with the embedded helmfile and the helmfile binary,
+// which should both report the release in apply_output
+func TestAccHelmfileReleaseSet_executors(t *testing.T) {
+	for _, executor := range []string{ExecutorLibrary, ExecutorBinary} {
+		t.Run(executor, func(t *testing.T) {
+			resourceName := "helmfile_release_set.the_product"
+			releaseID := acctest.RandString(8)
+			resource.Test(t, resource.TestCase{
+				PreCheck: func() {
+					testAccPreCheck(t)
+					if _, err := exec.LookPath("helmfile"); executor == ExecutorBinary && err != nil {
+						t.Skip("helmfile is required for this test")
+					}
+				},
+				Providers:    testAccProviders,
+				CheckDestroy: testAccCheckShellScriptDestroy,
+				Steps: []resource.TestStep{
+					{
+						Config: testAccHelmfileReleaseSetConfig_executor(releaseID, executor),
+
+						Check: resource.ComposeTestCheckFunc(
+							resource.TestCheckResourceAttr(resourceName, "executor", executor),
+							resource.TestMatchResourceAttr(resourceName, "apply_output", regexp.MustCompile(`pi-`+releaseID)),
+							resource.TestCheckResourceAttr(resourceName, "diff_output", wantedHelmfileDiffOutputForReleaseID(releaseID)),
+						),
+					},
+				},
+			})
+		})
+	}
+}
+
+// TestAccHelmfileReleaseSet_wait applies a Deployment with wait, which blocks until its pods are ready,
+// and then an image that never pulls, which fails once wait_timeout elapses
+func TestAccHelmfileReleaseSet_wait(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-wait-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_wait(releaseID, chartDir, "nginx:1.25-alpine"),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "wait", "true"),
+					resource.TestMatchResourceAttr(resourceName, "apply_output", regexp.MustCompile(`wait-`+releaseID)),
+				),
+			},
+			{
+				Config:      testAccHelmfileReleaseSetConfig_wait(releaseID, chartDir, "example.invalid/nginx:never"),
+				ExpectError: regexp.MustCompile(`not ready within wait_timeout = 1m`),
+			},
+		},
+	})
+}
+
+func TestAccHelmfileReleaseSet_diffSuppressLineRegex(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-suppress-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_diffSuppressLineRegex(releaseID, chartDir, "nginx:1.25-alpine", "a", `checksum/config`),
+			},
+			{
+				Config: testAccHelmfileReleaseSetConfig_diffSuppressLineRegex(releaseID, chartDir, "nginx:1.27-alpine", "b", `checksum/config`),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "diff_output", regexp.MustCompile(`nginx:1\.27-alpine`)),
+					testAccCheckResourceAttrNotContains(resourceName, "diff_output", "checksum/config"),
+				),
+			},
+			{
+				Config:      testAccHelmfileReleaseSetConfig_diffSuppressLineRegex(releaseID, chartDir, "nginx:1.27-alpine", "b", `checksum/(`),
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`invalid regex "checksum/\("`),
+			},
+		},
+	})
+}
+
+func TestAccHelmfileReleaseSet_set(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-set-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_set(releaseID, chartDir, "1"),
+			},
+			{
+				Config: testAccHelmfileReleaseSetConfig_set(releaseID, chartDir, "2"),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "set.0.name", "replicaCount"),
+					resource.TestMatchResourceAttr(resourceName, "diff_output", regexp.MustCompile(`\+\s+replicas: 2`)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_kubeVersion renders a chart that picks the API version of its PodDisruptionBudget
+// by .Capabilities.KubeVersion, without a kubeconfig
+func TestAccHelmfileReleaseSet_kubeVersion(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-kube-version-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateCapabilitiesChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_kubeVersion(releaseID, chartDir, "1.20.0"),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`apiVersion: policy/v1beta1`)),
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`kind: ServiceMonitor`)),
+				),
+			},
+			{
+				Config: testAccHelmfileReleaseSetConfig_kubeVersion(releaseID, chartDir, "1.29.0"),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`apiVersion: policy/v1\n`)),
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`kind: ServiceMonitor`)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_includeCRDs renders a chart with a CRD in its crds directory with and without include_crds
+func TestAccHelmfileReleaseSet_includeCRDs(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-include-crds-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateCRDChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_includeCRDs(releaseID, chartDir, true),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`kind: CustomResourceDefinition`)),
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`kind: Widget`)),
+				),
+			},
+			{
+				Config: testAccHelmfileReleaseSetConfig_includeCRDs(releaseID, chartDir, false),
+
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckResourceAttrNotContains(resourceName, "template_output", "kind: CustomResourceDefinition"),
+					resource.TestMatchResourceAttr(resourceName, "template_output", regexp.MustCompile(`kind: Widget`)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_includeNeeds applies a release selected by its label along with the release it needs
+func TestAccHelmfileReleaseSet_includeNeeds(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-include-needs-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_includeNeeds(releaseID, chartDir),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(resourceName, "apply_output", regexp.MustCompile(`needs-db-`+releaseID)),
+					resource.TestMatchResourceAttr(resourceName, "apply_output", regexp.MustCompile(`needs-app-`+releaseID)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_diffArgs plans a release whose chart looks up a namespace with diff_args = "--dry-run=server",
+// which lets helm-diff contact the cluster for the lookup
+func TestAccHelmfileReleaseSet_diffArgs(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-diff-args-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			if _, err := exec.LookPath("helm"); err != nil {
+				t.Skip("helm is required for this test")
+			}
+			testAccCreateLookupChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_diffArgs(releaseID, chartDir),
+
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "diff_args", "--dry-run=server"),
+					resource.TestMatchResourceAttr(resourceName, "diff_output", regexp.MustCompile(`kube-system: found`)),
+				),
+			},
+		},
+	})
+}
+
+// TestAccHelmfileReleaseSet_deleteWait destroys a release with delete_wait and checks that its pods are gone by the time
+// the destroy returns, rather than left terminating
+func TestAccHelmfileReleaseSet_deleteWait(t *testing.T) {
+	releaseID := acctest.RandString(8)
+	chartDir := filepath.Join(os.TempDir(), "terraform-provider-helmfile-delete-wait-"+releaseID)
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			for _, bin := range []string{"helm", "kubectl"} {
+				if _, err := exec.LookPath(bin); err != nil {
+					t.Skipf("%s is required for this test", bin)
+				}
+			}
+			testAccCreateDeploymentChart(t, chartDir)
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckReleasePodsDeleted("delete-wait-" + releaseID),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_deleteWait(releaseID, chartDir),
+			},
+		},
+	})
+}
+
+// testAccCheckReleasePodsDeleted checks that no pods of the release made by testAccCreateDeploymentChart are left
+func testAccCheckReleasePodsDeleted(release string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		out, err := exec.Command("kubectl", "--kubeconfig", os.ExpandEnv("$HOME/.kube/config"), "get", "pods", "-l", "app="+release, "-o", "name").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("listing the pods of %s: %v: %s", release, err, out)
+		}
+
+		if pods := strings.TrimSpace(string(out)); pods != "" {
+			return fmt.Errorf("expected the pods of %s to be deleted when the destroy returned, got:\n%s", release, pods)
+		}
+
+		return nil
+	}
+}
+
+// testAccCheckResourceAttrNotContains checks that the attribute of the resource doesn't contain s
+func testAccCheckResourceAttrNotContains(name, key, s string) resource.TestCheckFunc {
+	return func(state *terraform.State) error {
+		rs, ok := state.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("not found: %s", name)
+		}
+
+		if v := rs.Primary.Attributes[key]; strings.Contains(v, s) {
+			return fmt.Errorf("%s: expected %s not to contain %q, got:\n%s", name, key, s, v)
+		}
+
+		return nil
+	}
+}
+
+// testAccCreateDeploymentChart creates a chart at chart with a Deployment of the image in the values
+func testAccCreateDeploymentChart(t *testing.T, dir string) {
+	files := map[string]string{
+		"chart/Chart.yaml":  "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"chart/values.yaml": "image: nginx:1.25-alpine\nchecksum: \"\"\nreplicaCount: 1\n",
+		"chart/templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  replicas: {{ .Values.replicaCount }}
+  selector:
+    matchLabels:
+      app: {{ .Release.Name }}
+  template:
+    metadata:
+      labels:
+        app: {{ .Release.Name }}
+      annotations:
+        checksum/config: {{ .Values.checksum | quote }}
+    spec:
+      containers:
+      - name: app
+        image: {{ .Values.image }}
+        readinessProbe:
+          tcpSocket:
+            port: 80
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testAccCreateCapabilitiesChart creates a chart at chart with a PodDisruptionBudget of the API version for the
+// Kubernetes version, and a ServiceMonitor when the monitoring.coreos.com/v1 API is available
+func testAccCreateCapabilitiesChart(t *testing.T, dir string) {
+	files := map[string]string{
+		"chart/Chart.yaml": "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"chart/templates/pdb.yaml": `{{- if semverCompare ">=1.21-0" .Capabilities.KubeVersion.Version }}
+apiVersion: policy/v1
+{{- else }}
+apiVersion: policy/v1beta1
+{{- end }}
+kind: PodDisruptionBudget
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  minAvailable: 1
+  selector:
+    matchLabels:
+      app: {{ .Release.Name }}
+`,
+		"chart/templates/servicemonitor.yaml": `{{- if .Capabilities.APIVersions.Has "monitoring.coreos.com/v1" }}
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  selector:
+    matchLabels:
+      app: {{ .Release.Name }}
+  endpoints:
+  - port: http
+{{- end }}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testAccCreateLookupChart creates a chart at chart with a ConfigMap telling whether the lookup of the kube-system
+// namespace found it, which it does only when rendered against the cluster
+func testAccCreateLookupChart(t *testing.T, dir string) {
+	files := map[string]string{
+		"chart/Chart.yaml": "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"chart/templates/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+{{- if lookup "v1" "Namespace" "" "kube-system" }}
+  kube-system: found
+{{- else }}
+  kube-system: missing
+{{- end }}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testAccCreateCRDChart creates a chart at chart with the CRD of widgets.example.com in its crds directory and a Widget
+func testAccCreateCRDChart(t *testing.T, dir string) {
+	files := map[string]string{
+		"chart/Chart.yaml": "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"chart/crds/widgets.yaml": `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+`,
+		"chart/templates/widget.yaml": `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: {{ .Release.Name }}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testAccPreCheckKustomize(t *testing.T) {
+	for _, bin := range []string{"helm", "kustomize"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is required for this test", bin)
+		}
+	}
+}
+
+// testAccCreateKustomizePatchesFixture creates a chart at chart and a strategic merge patch at patches/configmap.yaml
+func testAccCreateKustomizePatchesFixture(t *testing.T, dir string) {
+	files := map[string]string{
+		"chart/Chart.yaml":               "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"chart/templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  original: \"true\"\n",
+		"patches/configmap.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  strategic: \"patched\"\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testAccPreCheckHelmGit(t *testing.T) {
+	out, err := exec.Command("helm", "plugin", "list").CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "helm-git") {
+		t.Skip("helm and the helm-git plugin are required for this test")
+	}
+}
+
+// testAccCreateChartRepo creates a git repository with a chart at charts/app
+func testAccCreateChartRepo(t *testing.T, repoDir string) {
+	files := map[string]string{
+		"charts/app/Chart.yaml":               "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+		"charts/app/templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"init", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-m", "Add chart"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
+
 func testAccCheckShellScriptDestroy(s *terraform.State) error {
 	_ = testAccProvider.Meta().(*ProviderInstance)
 
@@ -116,10 +731,323 @@ EOF
   selector = {
     labelkey1 = "value1"
   }
+
+  diff_new_resources = true
 }
 `, randVal, randVal)
 }
 
+func testAccHelmfileReleaseSetConfig_helmGit(randVal, repoDir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: helm-git-%s
+  chart: git+file://%s@charts/app?ref=main
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%s"
+
+  dry_run = true
+}
+`, randVal, repoDir, randVal)
+}
+
+func testAccHelmfileReleaseSetConfig_kustomizePatches(randVal, dir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: kustomize-patches-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  kustomize_patches {
+    release = "kustomize-patches-%[1]s"
+    patches = [
+      "%[2]s/patches/configmap.yaml",
+      <<EOF
+target:
+  kind: ConfigMap
+  name: app
+patch:
+- op: add
+  path: /data/json
+  value: "patched"
+EOF
+    ]
+  }
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  dry_run = true
+}
+`, randVal, dir)
+}
+
+func testAccHelmfileReleaseSetConfig_commonLabels(randVal, dir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: common-labels-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  common_labels = {
+    team                            = "platform"
+    "terraform.io/resource-address" = "helmfile_release_set.the_product"
+  }
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  dry_run = true
+}
+`, randVal, dir)
+}
+
+func testAccHelmfileReleaseSetConfig_wait(randVal, dir, image string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: wait-%[1]s
+  chart: %[2]s/chart
+  values:
+  - image: %[3]s
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  wait = true
+
+  wait_timeout = "1m"
+}
+`, randVal, dir, image)
+}
+
+func testAccHelmfileReleaseSetConfig_diffSuppressLineRegex(randVal, dir, image, checksum, regex string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: suppress-%[1]s
+  chart: %[2]s/chart
+  values:
+  - image: %[3]s
+    checksum: %[4]s
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  diff_context = 1
+
+  diff_suppress_line_regex = ["%[5]s"]
+}
+`, randVal, dir, image, checksum, regex)
+}
+
+func testAccHelmfileReleaseSetConfig_set(randVal, dir, replicaCount string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: set-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  set {
+    name  = "replicaCount"
+    value = "%[3]s"
+  }
+}
+`, randVal, dir, replicaCount)
+}
+
+func testAccHelmfileReleaseSetConfig_kubeVersion(randVal, dir, kubeVersion string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: kube-version-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  helm_binary = "helm"
+
+  working_directory = "%[1]s"
+
+  dry_run = true
+
+  kube_version = "%[3]s"
+
+  api_versions = ["monitoring.coreos.com/v1"]
+}
+`, randVal, dir, kubeVersion)
+}
+
+func testAccHelmfileReleaseSetConfig_includeCRDs(randVal, dir string, includeCRDs bool) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: include-crds-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  helm_binary = "helm"
+
+  working_directory = "%[1]s"
+
+  dry_run = true
+
+  include_crds = %[3]t
+}
+`, randVal, dir, includeCRDs)
+}
+
+func testAccHelmfileReleaseSetConfig_includeNeeds(randVal, dir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: needs-db-%[1]s
+  chart: %[2]s/chart
+  labels:
+    tier: db
+- name: needs-app-%[1]s
+  chart: %[2]s/chart
+  labels:
+    tier: app
+  needs:
+  - needs-db-%[1]s
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  selectors = ["tier=app"]
+
+  include_needs = true
+}
+`, randVal, dir)
+}
+
+func testAccHelmfileReleaseSetConfig_diffArgs(randVal, dir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: diff-args-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  diff_args = "--dry-run=server"
+}
+`, randVal, dir)
+}
+
+func testAccHelmfileReleaseSetConfig_deleteWait(randVal, dir string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+releases:
+- name: delete-wait-%[1]s
+  chart: %[2]s/chart
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  destroy_cascade = "foreground"
+
+  delete_wait = true
+
+  delete_timeout = "3m"
+}
+`, randVal, dir)
+}
+
+func testAccHelmfileReleaseSetConfig_executor(randVal, executor string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+repositories:
+- name: sp
+  url: https://stefanprodan.github.io/podinfo
+
+releases:
+- name: pi-%s
+  chart: sp/podinfo
+  version: 4.0.6
+  values:
+  - image:
+      tag: "123"
+  labels:
+    labelkey1: value1
+EOF
+
+  executor = "%s"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%s"
+
+  environment = "default"
+
+  values = [
+    <<EOF
+{"name": "myapp"}
+EOF
+  ]
+
+  selector = {
+    labelkey1 = "value1"
+  }
+
+  diff_new_resources = true
+}
+`, randVal, executor, randVal)
+}
+
 func testAccHelmfileReleaseSetConfig_binaries(randVal string) string {
 	return fmt.Sprintf(`
 resource "helmfile_release_set" "the_product" {
@@ -161,6 +1089,8 @@ EOF
   selector = {
     labelkey1 = "value1"
   }
+
+  diff_new_resources = true
 }
 `, randVal, randVal)
 }
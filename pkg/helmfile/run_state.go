@@ -0,0 +1,155 @@
+package helmfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/helmfile/helmfile/pkg/app"
+	"github.com/helmfile/helmfile/pkg/state"
+	"github.com/helmfile/helmfile/pkg/yaml"
+)
+
+// helmStateOf returns the state of the helmfile that the run is for.
+// helmfile exposes the state only to its own commands, which print their results to os.Stdout, so the state is read
+// from the unexported field instead of capturing os.Stdout, which is shared by every operation of the provider.
+func helmStateOf(run *app.Run) (*state.HelmState, error) {
+	f := reflect.ValueOf(run).Elem().FieldByName("state")
+	if !f.IsValid() || f.Type() != reflect.TypeOf(&state.HelmState{}) {
+		return nil, fmt.Errorf("[BUG] the helmfile run has no state field of type *state.HelmState")
+	}
+
+	return *(**state.HelmState)(unsafe.Pointer(f.UnsafeAddr())), nil
+}
+
+// printEnvironments returns the environments of every helmfile as YAML documents, like helmfile print-env
+func printEnvironments(helmfileApp *app.App) (string, error) {
+	var (
+		mu   sync.Mutex
+		docs []string
+	)
+
+	err := helmfileApp.ForEachState(func(run *app.Run) (bool, []error) {
+		st, err := helmStateOf(run)
+		if err != nil {
+			return false, []error{err}
+		}
+
+		values, err := st.Env.GetMergedValues()
+		if err != nil {
+			return false, []error{fmt.Errorf("failed to get merged values: %w", err)}
+		}
+
+		// The absolute path tells which helmfile the environment comes from
+		filePath := st.FilePath
+		if fullPath, err := st.FullFilePath(); err == nil {
+			filePath = fullPath
+		}
+
+		env := map[string]interface{}{
+			"filePath": filePath,
+			"name":     st.Env.Name,
+			"values":   values,
+		}
+		if st.Env.KubeContext != "" {
+			env["kubeContext"] = st.Env.KubeContext
+		}
+
+		bs, err := yaml.Marshal(env)
+		if err != nil {
+			return false, []error{fmt.Errorf("failed to marshal to YAML: %w", err)}
+		}
+
+		mu.Lock()
+		docs = append(docs, string(bs))
+		mu.Unlock()
+
+		return false, nil
+	}, false)
+
+	// print-env needs no releases
+	var noMatch *app.NoMatchingHelmfileError
+	if errors.As(err, &noMatch) {
+		err = nil
+	}
+
+	return strings.Join(docs, "---\n"), err
+}
+
+// listReleases returns the releases selected from every helmfile as JSON, like helmfile list --output json --skip-charts
+func listReleases(helmfileApp *app.App) (string, error) {
+	var (
+		mu       sync.Mutex
+		releases []*app.HelmRelease
+	)
+
+	err := helmfileApp.ForEachState(func(run *app.Run) (bool, []error) {
+		st, err := helmStateOf(run)
+		if err != nil {
+			return false, []error{err}
+		}
+
+		for _, r := range st.Releases {
+			labels := map[string]string{}
+			for k, v := range r.Labels {
+				labels[k] = v
+			}
+			for k, v := range st.CommonLabels {
+				labels[k] = v
+			}
+
+			keys := make([]string, 0, len(labels))
+			for k := range labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			pairs := make([]string, 0, len(keys))
+			for _, k := range keys {
+				pairs = append(pairs, k+":"+labels[k])
+			}
+
+			enabled, err := state.ConditionEnabled(r, st.Values())
+			if err != nil {
+				return false, []error{err}
+			}
+
+			mu.Lock()
+			releases = append(releases, &app.HelmRelease{
+				Name:      r.Name,
+				Namespace: r.Namespace,
+				Installed: r.Desired(),
+				Enabled:   enabled,
+				Labels:    strings.Join(pairs, ","),
+				Chart:     r.Chart,
+				Version:   r.Version,
+			})
+			mu.Unlock()
+		}
+
+		return false, nil
+	}, false, app.SetFilter(true))
+	if err != nil {
+		return "", err
+	}
+
+	// The states are visited concurrently, so the releases are sorted like helmfile does
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Namespace != releases[j].Namespace {
+			return releases[i].Namespace < releases[j].Namespace
+		}
+		return releases[i].Name < releases[j].Name
+	})
+
+	bs, err := json.Marshal(releases)
+	if err != nil {
+		return "", fmt.Errorf("error generating json: %w", err)
+	}
+
+	return string(bs) + "\n", nil
+}
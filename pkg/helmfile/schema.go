@@ -1,11 +1,44 @@
 package helmfile
 
+import "github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+
 const (
 	KeyAWSRegion          = "aws_region"
 	KeyAWSProfile         = "aws_profile"
 	KeyAWSAssumeRole      = "aws_assume_role"
+	KeyAWSEndpoint        = "aws_endpoint"
 	KeyEKSClusterName     = "eks_cluster_name"
 	KeyEKSClusterRegion   = "eks_cluster_region"
 	KeyEKSClusterEndpoint = "eks_cluster_endpoint"
 	KeyEKSClusterCA       = "eks_cluster_ca"
+	KeyEKSAuthMode        = "eks_auth_mode"
+	KeyEKSExecAPIVersion  = "eks_exec_api_version"
+	KeyEKSClusterIdentity = "eks_cluster_identity"
+
+	KeyClusterAuthExec = "cluster_auth_exec"
+	KeyClusterEndpoint = "cluster_endpoint"
+	KeyClusterCA       = "cluster_ca"
+
+	KeyKubeconfigContent = "kubeconfig_content"
+
+	KeyProviderConfigHash = "provider_config_hash"
 )
+
+// kubeconfigContentSchema is the schema of kubeconfig_content shared by the resources
+func kubeconfigContentSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "Content of the kubeconfig, like the one generated by another provider, written to a temporary file with 0600 permissions for each operation instead of kubeconfig",
+	}
+}
+
+// providerConfigHashSchema is the schema of provider_config_hash shared by the resources
+func providerConfigHashSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "Hash of the provider attributes, used to detect changes in the provider config",
+	}
+}
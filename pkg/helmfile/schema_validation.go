@@ -0,0 +1,245 @@
+package helmfile
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// schemaValidationFailureMessage starts the error of helm when the values of a release don't meet the values.schema.json of its chart
+const schemaValidationFailureMessage = "values don't meet the specifications of the schema(s) in the following chart(s):"
+
+var (
+	// schemaViolationPattern matches the violations reported by helm >= 3.18, like `- at '/image/tag': got number, want string`
+	schemaViolationPattern = regexp.MustCompile(`^- at '([^']*)': (.*)$`)
+
+	// legacySchemaViolationPattern matches the violations reported by helm < 3.18, like `- image.tag: Invalid type. Expected: string, given: integer`
+	legacySchemaViolationPattern = regexp.MustCompile(`^- (\S+): (.*)$`)
+
+	// schemaChartPattern matches the lines naming the chart whose schema the following violations are for
+	schemaChartPattern = regexp.MustCompile(`^([^\s:-][^\s:]*):$`)
+
+	// failedReleasePattern matches the release helmfile reports a failure for, like `failed processing release myapp: command ...`
+	failedReleasePattern = regexp.MustCompile(`failed processing release ([^\s:]+):`)
+)
+
+// schemaValidationFailure is a release whose values don't meet the values.schema.json of its chart
+type schemaValidationFailure struct {
+	// Release is the name of the release, or empty when it couldn't be determined from the error
+	Release string
+
+	// Namespace is the namespace of the release, or empty when it couldn't be determined from the error
+	Namespace string
+
+	// Charts are the charts, including the subcharts, whose schemas are violated
+	Charts []string
+
+	// Paths are the sorted paths to the values that violate the schemas, like /image/tag
+	Paths []string
+}
+
+// ID returns the namespace-qualified name of the release used in failed_releases
+func (f schemaValidationFailure) ID() string {
+	return listedRelease{Name: f.Release, Namespace: f.Namespace}.ID()
+}
+
+// schemaValidationFailures returns the releases whose helm command failed the schema validation
+func schemaValidationFailures(errMsg string) []schemaValidationFailure {
+	if !strings.Contains(errMsg, schemaValidationFailureMessage) {
+		return nil
+	}
+
+	var failures []schemaValidationFailure
+
+	seen := map[string]bool{}
+
+	// helmfile reports each failed helm command as `command "/path/to/helm" exited with non-zero status:` followed by its details
+	blocks := strings.Split(errMsg, "exited with non-zero status:")
+	for i, b := range blocks {
+		if !strings.Contains(b, schemaValidationFailureMessage) {
+			continue
+		}
+
+		f := schemaViolations(b)
+
+		if len(blocks) > 1 {
+			f.Release, f.Namespace = helmCommandRelease(b)
+
+			// helmfile names the release right before the command of the failure
+			if f.Release == "" && i > 0 {
+				if m := failedReleasePattern.FindAllStringSubmatch(blocks[i-1], -1); m != nil {
+					f.Release = m[len(m)-1][1]
+				}
+			}
+		}
+
+		// STDERR and COMBINED OUTPUT repeat the same failure
+		if seen[f.ID()] {
+			continue
+		}
+		seen[f.ID()] = true
+
+		failures = append(failures, f)
+	}
+
+	return failures
+}
+
+// schemaViolations returns the charts and the paths to the values violating their schemas in the helm error
+func schemaViolations(block string) schemaValidationFailure {
+	var f schemaValidationFailure
+
+	charts := map[string]bool{}
+	paths := map[string]bool{}
+
+	inFailure := false
+
+	for _, l := range strings.Split(block, "\n") {
+		l = strings.TrimSpace(l)
+
+		if strings.Contains(l, schemaValidationFailureMessage) {
+			inFailure = true
+			continue
+		}
+		if !inFailure {
+			continue
+		}
+
+		var path string
+
+		if m := schemaViolationPattern.FindStringSubmatch(l); m != nil {
+			// The violations of the nested schemas are listed below the one that only says the validation failed
+			if m[2] == "validation failed" {
+				continue
+			}
+			path = m[1]
+			if path == "" {
+				path = "/"
+			}
+		} else if m := legacySchemaViolationPattern.FindStringSubmatch(l); m != nil {
+			path = m[1]
+		} else if m := schemaChartPattern.FindStringSubmatch(l); m != nil {
+			if !charts[m[1]] {
+				charts[m[1]] = true
+				f.Charts = append(f.Charts, m[1])
+			}
+			continue
+		} else {
+			inFailure = false
+			continue
+		}
+
+		if !paths[path] {
+			paths[path] = true
+			f.Paths = append(f.Paths, path)
+		}
+	}
+
+	// helm lists the violations in no particular order
+	sort.Strings(f.Paths)
+
+	return f
+}
+
+// helmCommandRelease returns the release name and namespace from the ARGS of a failed helm upgrade, install or template command,
+// including the ones run by helm diff
+func helmCommandRelease(block string) (string, string) {
+	var args []string
+	for _, l := range strings.Split(block, "\n") {
+		if m := helmArgPattern.FindStringSubmatch(l); m != nil {
+			args = append(args, m[1])
+		}
+	}
+
+	var name, namespace string
+
+	for i, a := range args {
+		if a == "--namespace" && i+1 < len(args) {
+			namespace = args[i+1]
+		}
+
+		if name != "" || (a != "upgrade" && a != "install" && a != "template") {
+			continue
+		}
+
+		for _, n := range args[i+1:] {
+			if !strings.HasPrefix(n, "-") {
+				name = n
+				break
+			}
+		}
+	}
+
+	return name, namespace
+}
+
+// describeSchemaValidationFailures renders the failures as a single line that names each release and the violated paths
+func describeSchemaValidationFailures(failures []schemaValidationFailure) string {
+	var descs []string
+
+	for _, f := range failures {
+		release := f.ID()
+		if release == "" {
+			release = "(unknown)"
+		}
+
+		desc := fmt.Sprintf("values of release %s don't meet the schema", release)
+		if len(f.Charts) > 0 {
+			desc += " of chart " + strings.Join(f.Charts, ", ")
+		}
+		if len(f.Paths) > 0 {
+			desc += " at " + strings.Join(f.Paths, ", ")
+		}
+
+		descs = append(descs, desc)
+	}
+
+	return "schema validation failed: " + strings.Join(descs, "; ")
+}
+
+// schemaValidationResultFailures returns the releases that failed the schema validation in the result or the error
+func schemaValidationResultFailures(result *Result, err error) []schemaValidationFailure {
+	if err == nil {
+		return nil
+	}
+
+	var output string
+	if result != nil {
+		output = result.Output
+	}
+
+	return schemaValidationFailures(output + "\n" + err.Error())
+}
+
+// schemaValidationError prefixes the error with the releases and the paths that failed the schema validation, if any
+func schemaValidationError(result *Result, err error) error {
+	failures := schemaValidationResultFailures(result, err)
+	if len(failures) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", describeSchemaValidationFailures(failures), err)
+}
+
+// recordSchemaValidationFailures records the releases that failed the schema validation on apply in failed_releases,
+// and returns the error prefixed with the releases and the paths that failed.
+// It is for the applies without continue_on_error, which records all the failed releases by itself.
+func recordSchemaValidationFailures(d ResourceReadWrite, result *Result, err error) error {
+	failures := schemaValidationResultFailures(result, err)
+
+	failed := make([]string, 0, len(failures))
+	for _, f := range failures {
+		if f.Release != "" {
+			failed = append(failed, f.ID())
+		}
+	}
+
+	d.Set(KeyFailedReleases, failed)
+
+	if len(failures) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", describeSchemaValidationFailures(failures), err)
+}
@@ -0,0 +1,250 @@
+package helmfile
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// fakeSchemaValidatingHelmfile fails like helm does on the invalid values of the fixture chart,
+// unless --skip-schema-validation is passed on its own or to helm-diff
+const fakeSchemaValidatingHelmfile = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/calls"
+for a in "$@"; do
+  [ "$a" = --skip-schema-validation ] && exit 0
+done
+cat "$(dirname "$0")/schema-error.txt" >&2
+exit 1
+`
+
+// renderSchemaValidationError returns the error of helm for the values of the fixture chart, in the format helmfile reports
+// the failure of helm upgrade
+func renderSchemaValidationError(t *testing.T, skipSchemaValidation bool) string {
+	t.Helper()
+
+	chrt, err := loader.Load(filepath.Join("testdata", "schema_validation", "charts", "myapp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "schema_validation", "invalid-values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := chartutil.ReadValues(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = chartutil.ToRenderValuesWithSchemaValidation(chrt, values, chartutil.ReleaseOptions{Name: "myapp", Namespace: "default"}, nil, skipSchemaValidation)
+	if err == nil {
+		return ""
+	}
+
+	stderr := "  Error: UPGRADE FAILED: " + strings.ReplaceAll(strings.TrimSpace(err.Error()), "\n", "\n  ")
+
+	return fmt.Sprintf(`in ./helmfile-a1b2c3.yaml: failed processing release myapp: command "/usr/local/bin/helm" exited with non-zero status:
+
+PATH:
+  /usr/local/bin/helm
+
+ARGS:
+  0: helm (4 bytes)
+  1: upgrade (7 bytes)
+  2: --install (9 bytes)
+  3: myapp (5 bytes)
+  4: ./charts/myapp (14 bytes)
+  5: --namespace (11 bytes)
+  6: default (7 bytes)
+
+ERROR:
+  exit status 1
+
+EXIT STATUS
+  1
+
+STDERR:
+%s
+
+COMBINED OUTPUT:
+%s
+`, stderr, stderr)
+}
+
+func TestSchemaValidationFailures(t *testing.T) {
+	legacy, err := ioutil.ReadFile(filepath.Join("testdata", "schema_validation", "helm_upgrade_legacy.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		errMsg string
+		want   []schemaValidationFailure
+	}{
+		{
+			name:   "helm",
+			errMsg: renderSchemaValidationError(t, false),
+			want: []schemaValidationFailure{
+				{Release: "myapp", Namespace: "default", Charts: []string{"myapp"}, Paths: []string{"/image", "/image/tag", "/replicaCount", "/service/type"}},
+			},
+		},
+		{
+			name:   "helm before 3.18",
+			errMsg: string(legacy),
+			want: []schemaValidationFailure{
+				{Release: "myapp", Namespace: "default", Charts: []string{"myapp"}, Paths: []string{"image", "image.tag", "replicaCount", "service.type"}},
+			},
+		},
+		{
+			name:   "without helmfile",
+			errMsg: "Error: INSTALLATION FAILED: values don't meet the specifications of the schema(s) in the following chart(s):\nmyapp:\n- at '': missing property 'image'\n",
+			want: []schemaValidationFailure{
+				{Charts: []string{"myapp"}, Paths: []string{"/"}},
+			},
+		},
+		{
+			name:   "other error",
+			errMsg: "Error: Kubernetes cluster unreachable: connection refused",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schemaValidationFailures(tt.errMsg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected failures:\nwant: %+v\ngot:  %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSchemaValidationFailuresWithSkipSchemaValidation(t *testing.T) {
+	if errMsg := renderSchemaValidationError(t, true); errMsg != "" {
+		t.Fatalf("expected helm to skip the schema validation, got:\n%s", errMsg)
+	}
+}
+
+func TestRecordSchemaValidationFailures(t *testing.T) {
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	applyErr := errors.New(renderSchemaValidationError(t, false))
+
+	err := recordSchemaValidationFailures(d, &Result{Output: "Upgrading release=myapp, chart=./charts/myapp, namespace=default\n"}, applyErr)
+
+	want := "schema validation failed: values of release default/myapp don't meet the schema of chart myapp at /image, /image/tag, /replicaCount, /service/type: "
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected the error to start with %q, got %v", want, err)
+	}
+	if !errors.Is(err, applyErr) {
+		t.Errorf("expected the error to wrap the error of the apply, got %v", err)
+	}
+
+	if got := d.Get(KeyFailedReleases); !reflect.DeepEqual(got, []string{"default/myapp"}) {
+		t.Errorf("unexpected failed_releases: %v", got)
+	}
+
+	// The next successful apply clears them
+	if err := recordSchemaValidationFailures(d, &Result{}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := d.Get(KeyFailedReleases); !reflect.DeepEqual(got, []string{}) {
+		t.Errorf("expected no failed_releases, got %v", got)
+	}
+}
+
+func TestSkipSchemaValidation(t *testing.T) {
+	dir := t.TempDir()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemaError := renderSchemaValidationError(t, false)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	for name, content := range map[string]string{
+		"helmfile":         fakeSchemaValidatingHelmfile,
+		"kubeconfig":       testKubeconfig,
+		"schema-error.txt": schemaError,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newReleaseSet := func(skip bool) *ReleaseSet {
+		return &ReleaseSet{
+			Bin:                  filepath.Join(dir, "helmfile"),
+			Content:              "releases: []\n",
+			WorkingDirectory:     dir,
+			Kubeconfig:           filepath.Join(dir, "kubeconfig"),
+			SkipSchemaValidation: skip,
+		}
+	}
+
+	calls := func() string {
+		bs, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+		os.Remove(filepath.Join(dir, "calls"))
+		return string(bs)
+	}
+
+	t.Run("diff", func(t *testing.T) {
+		d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+		_, err := DiffReleaseSet(&sdk.Context{}, newReleaseSet(false), d)
+		if err == nil || !strings.Contains(err.Error(), "values of release default/myapp don't meet the schema of chart myapp at /image, /image/tag, /replicaCount") {
+			t.Errorf("expected the diff to fail with the schema validation failure, got %v", err)
+		}
+		calls()
+
+		if _, err := DiffReleaseSet(&sdk.Context{}, newReleaseSet(true), d); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if c := calls(); !strings.Contains(c, "--diff-args --skip-schema-validation") {
+			t.Errorf("expected --skip-schema-validation to be passed to helm-diff, got %s", c)
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		if _, err := runTemplate(&sdk.Context{}, newReleaseSet(false)); err == nil {
+			t.Error("expected the template to fail")
+		}
+		calls()
+
+		if _, err := runTemplate(&sdk.Context{}, newReleaseSet(true)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if c := calls(); !strings.Contains(c, "template --skip-schema-validation") {
+			t.Errorf("expected --skip-schema-validation to be passed to helmfile template, got %s", c)
+		}
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		fs := newReleaseSet(true)
+
+		opts := buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"})
+		config := &applyConfigProvider{baseConfigProvider: newBaseConfigProvider(opts.BaseOptions, nil), skipSchemaValidation: opts.SkipSchemaValidation}
+		if !config.SkipSchemaValidation() {
+			t.Error("expected helmfile apply to pass --skip-schema-validation to helm")
+		}
+
+		if !buildDiffOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}, 0).SkipSchemaValidation || !buildTemplateOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}).SkipSchemaValidation {
+			t.Error("expected helmfile diff and template to pass --skip-schema-validation to helm")
+		}
+	})
+}
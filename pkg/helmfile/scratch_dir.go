@@ -0,0 +1,174 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// ScratchDirectoryIsolated writes the temporary files to a directory of the release set under .terraform-helmfile
+	// in the working directory, so that release sets sharing a working directory never write to the same directory
+	ScratchDirectoryIsolated = "isolated"
+
+	// ScratchDirectoryWorkingDirectory writes the temporary files to the working directory, as earlier versions did
+	ScratchDirectoryWorkingDirectory = "working_directory"
+
+	// isolatedScratchDirName is the directory in the working directory that has the directories of the release sets
+	isolatedScratchDirName = ".terraform-helmfile"
+)
+
+// fallbackLogged records the working directories whose fallback has already been logged, so that we log it only once
+var fallbackLogged sync.Map
+
+// execFallbackLogged records likewise the working directories whose content calling exec has been logged
+var execFallbackLogged sync.Map
+
+// scratchDir returns the directory to write the temporary helmfile and values files to.
+//
+// It is the working directory when it is writable, or .terraform-helmfile/<id> in it with the isolated scratch_directory.
+// When the working directory is on a read-only filesystem, like a module directory mounted read-only in some
+// CI sandboxes, it falls back to a directory under os.TempDir() that is unique to the working directory, unless
+// require_writable_working_directory is set.
+// The files written to any directory but the working directory are referenced by their absolute paths, as helmfile
+// still runs in the working directory.
+func scratchDir(fs *ReleaseSet) (string, error) {
+	dir := fs.WorkingDirectory
+
+	sub := isolatedScratchSubdir(fs)
+	if sub != "" {
+		abs, err := filepath.Abs(filepath.Join(dir, sub))
+		if err != nil {
+			return "", fmt.Errorf("getting absolute path to %s: %w", filepath.Join(dir, sub), err)
+		}
+
+		dir = abs
+	}
+
+	err := probeWritable(dir)
+	if err == nil {
+		return dir, nil
+	}
+
+	if fs.RequireWritableWorkingDirectory || !isReadOnlyError(err) {
+		return "", fmt.Errorf("writing to working directory %q: %w", dir, err)
+	}
+
+	abs, err := filepath.Abs(fs.WorkingDirectory)
+	if err != nil {
+		return "", fmt.Errorf("getting absolute path to %s: %w", fs.WorkingDirectory, err)
+	}
+
+	fallback := filepath.Join(os.TempDir(), "terraform-provider-helmfile", fmt.Sprintf("%x", sha256.Sum256([]byte(abs)))[:16], sub)
+
+	if err := os.MkdirAll(fallback, 0755); err != nil {
+		return "", fmt.Errorf("creating fallback directory %q for read-only working directory %q: %w", fallback, abs, err)
+	}
+
+	if _, logged := fallbackLogged.LoadOrStore(abs, true); !logged {
+		logf("Working directory %s is not writable. Writing temporary files to %s instead. Set require_writable_working_directory = true to make this an error", abs, fallback)
+	}
+
+	return fallback, nil
+}
+
+// setScratchDirectory defaults scratch_directory to isolated for a new release set. The ones created by earlier
+// versions of the provider have no scratch_directory in their state, and keep writing to the working directory.
+func setScratchDirectory(d newResourceDiffChecker, fs *ReleaseSet) error {
+	if d.Id() != "" || fs.ScratchDirectory != "" {
+		return nil
+	}
+
+	if err := d.SetNew(KeyScratchDirectory, ScratchDirectoryIsolated); err != nil {
+		return err
+	}
+
+	fs.ScratchDirectory = ScratchDirectoryIsolated
+
+	return nil
+}
+
+// isolatedScratchSubdir returns the directory of the release set relative to the working directory with the isolated
+// scratch_directory, or an empty string otherwise. The operations run before the ID is known, like the plan of a new
+// release set, write to .terraform-helmfile itself.
+// The content calling exec is written to the working directory regardless, as the command and its arguments would
+// otherwise resolve relative to the scratch directory.
+func isolatedScratchSubdir(fs *ReleaseSet) string {
+	if fs.ScratchDirectory != ScratchDirectoryIsolated {
+		return ""
+	}
+
+	if callsTemplateExec(fs.Content) {
+		if _, logged := execFallbackLogged.LoadOrStore(fs.WorkingDirectory, true); !logged {
+			logf("Writing the temporary files of the content calling exec to working directory %s rather than the isolated %s", fs.WorkingDirectory, KeyScratchDirectory)
+		}
+
+		return ""
+	}
+
+	if fs.ID == "" {
+		return isolatedScratchDirName
+	}
+
+	// IDs from the name ID scheme can be . or .., which would otherwise be the working directory or its parent
+	name := unsafeFileNameChars.ReplaceAllString(fs.ID, "_")
+	if strings.Trim(name, ".") == "" {
+		name = strings.Repeat("_", len(name))
+	}
+
+	return filepath.Join(isolatedScratchDirName, name)
+}
+
+// scratchContent returns the helmfile content to write to dir. helmfile resolves the relative paths in the helmfile
+// relative to the directory of the helmfile, so they are rebased to the working directory when dir is another directory.
+func scratchContent(fs *ReleaseSet, dir string) (string, error) {
+	if dir == fs.WorkingDirectory {
+		return fs.Content, nil
+	}
+
+	return rebaseRelativePaths(fs.Content, fs.WorkingDirectory)
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// scratchFileName returns the name of a temporary file like helmfile-<id>-<sha256>.yaml.
+// The ID of the release set is embedded when known, so that the files of release sets sharing a working directory
+// can be told apart. IDs from the name ID scheme can contain any character, which are replaced to keep the name a single path element.
+func scratchFileName(prefix, id string, sum []byte, extension string) string {
+	if id == "" {
+		return fmt.Sprintf("%s-%x%s", prefix, sum, extension)
+	}
+
+	return fmt.Sprintf("%s-%s-%x%s", prefix, unsafeFileNameChars.ReplaceAllString(id, "_"), sum, extension)
+}
+
+// probeWritable creates the directory if missing and checks that a file can be written into it
+func probeWritable(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".helmfile-write-probe-")
+	if err != nil {
+		return err
+	}
+
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, syscall.EROFS) || os.IsPermission(err)
+}
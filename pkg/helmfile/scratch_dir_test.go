@@ -0,0 +1,170 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSetScratchDirectory(t *testing.T) {
+	testcases := []struct {
+		name    string
+		id      string
+		scratch string
+		want    string
+	}{
+		{name: "new release set", want: ScratchDirectoryIsolated},
+		{name: "new release set with working_directory", scratch: ScratchDirectoryWorkingDirectory, want: ScratchDirectoryWorkingDirectory},
+		{name: "release set created by an earlier version", id: "myapp"},
+		{name: "existing release set", id: "myapp", scratch: ScratchDirectoryIsolated, want: ScratchDirectoryIsolated},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newMockDiffChecker()
+			d.id = tc.id
+
+			fs := &ReleaseSet{ID: tc.id, ScratchDirectory: tc.scratch}
+
+			if err := setScratchDirectory(d, fs); err != nil {
+				t.Fatal(err)
+			}
+
+			if fs.ScratchDirectory != tc.want {
+				t.Errorf("expected scratch directory %q, got %q", tc.want, fs.ScratchDirectory)
+			}
+
+			if v, ok := d.newValues[KeyScratchDirectory]; ok != (tc.scratch == "" && tc.id == "") || ok && v != tc.want {
+				t.Errorf("unexpected %s in the plan: %v", KeyScratchDirectory, d.newValues)
+			}
+		})
+	}
+}
+
+// TestIsolatedScratchDirectoryRelativePaths tests that the relative charts and values files in the content resolve
+// to the working directory when the helmfile is written to the isolated scratch directory
+func TestIsolatedScratchDirectoryRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	// The working directory is relative to the root module, which is the current directory of Terraform
+	for path, content := range map[string]string{
+		"work/charts/app/Chart.yaml": "name: app\nversion: 0.1.0\n",
+		"work/values/app.yaml":       "replicas: 2\n",
+		"work/patches/json.yaml":     "target:\n  kind: ConfigMap\npatch:\n- op: add\n  path: /data/x\n  value: y\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := &ReleaseSet{
+		ID:               "myapp",
+		WorkingDirectory: "work",
+		ScratchDirectory: ScratchDirectoryIsolated,
+		Content:          "releases:\n- name: app\n  chart: ./charts/app\n  values:\n  - values/app.yaml\n  jsonPatches:\n  - patches/json.yaml\n",
+		Values:           []interface{}{"replicas: 3\n"},
+	}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "work", ".terraform-helmfile", "myapp"); filepath.Dir(files.Path) != want {
+		t.Errorf("expected the helmfile to be written to %s, got %s", want, files.Path)
+	}
+	for _, p := range files.ValuesFiles {
+		if filepath.Dir(p) != filepath.Dir(files.Path) {
+			t.Errorf("expected the values file %s to be written along with the helmfile", p)
+		}
+	}
+
+	if opts := buildBaseOptions(fs, files); opts.WorkingDirectory != "work" {
+		t.Errorf("expected helmfile to run in the working directory, got %q", opts.WorkingDirectory)
+	}
+
+	bs, err := ioutil.ReadFile(files.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var helmfile struct {
+		Releases []struct {
+			Chart       string   `yaml:"chart"`
+			Values      []string `yaml:"values"`
+			JSONPatches []string `yaml:"jsonPatches"`
+		} `yaml:"releases"`
+	}
+	if err := yaml.Unmarshal(bs, &helmfile); err != nil {
+		t.Fatal(err)
+	}
+
+	// helmfile resolves the paths relative to the directory of the helmfile
+	release := helmfile.Releases[0]
+	paths := append([]string{release.Chart}, release.Values...)
+	if len(release.JSONPatches) != 1 {
+		t.Fatalf("expected the jsonPatches to be kept, got %v", release.JSONPatches)
+	}
+	for _, p := range append(paths, release.JSONPatches...) {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(files.Path), p)
+		}
+
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to resolve to the working directory: %v", p, err)
+		}
+	}
+
+	files.remove()
+
+	if err := sweepTempFiles(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join("work", ".terraform-helmfile")); !os.IsNotExist(err) {
+		t.Errorf("expected the scratch directory to be removed, got %v", err)
+	}
+}
+
+func TestIsolatedScratchSubdir(t *testing.T) {
+	testcases := []struct {
+		id   string
+		want string
+	}{
+		{id: "", want: ".terraform-helmfile"},
+		{id: "abc123", want: filepath.Join(".terraform-helmfile", "abc123")},
+		{id: "team/app", want: filepath.Join(".terraform-helmfile", "team_app")},
+		{id: "..", want: filepath.Join(".terraform-helmfile", "__")},
+	}
+
+	for _, tc := range testcases {
+		if got := isolatedScratchSubdir(&ReleaseSet{ID: tc.id, ScratchDirectory: ScratchDirectoryIsolated}); got != tc.want {
+			t.Errorf("expected %q for ID %q, got %q", tc.want, tc.id, got)
+		}
+	}
+
+	if got := isolatedScratchSubdir(&ReleaseSet{ID: "abc123"}); got != "" {
+		t.Errorf("expected no subdirectory without the isolated scratch directory, got %q", got)
+	}
+
+	// exec runs the command in the directory of the helmfile, which needs to be the working directory
+	exec := &ReleaseSet{ID: "abc123", ScratchDirectory: ScratchDirectoryIsolated, Content: `{{ exec "./version.sh" (list) }}`}
+	if got := isolatedScratchSubdir(exec); got != "" {
+		t.Errorf("expected no subdirectory for the content calling exec, got %q", got)
+	}
+}
@@ -0,0 +1,86 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// setApplyOutput sets apply_output, with the changes of Secrets redacted into sensitive_apply_output
+// when suppress_secrets is false. The output is truncated unless it is written to output_path.
+func setApplyOutput(d ResourceReadWrite, fs *ReleaseSet, output string) error {
+	if outputsInFiles(fs) {
+		return writeOutputFile(d, fs, KeyApplyOutput, output)
+	}
+
+	output = truncateOutput(output, maxOutputLen(fs), "helmfile-apply output", KeyMaxOutputLen)
+
+	d.Set(KeyApplyOutput, protectOutput(d, fs, output, KeySensitiveApplyOutput, fs.ShowSecrets))
+
+	return nil
+}
+
+// setTemplateOutput sets template_output, which is never redacted as helmfile template has no --suppress-secrets.
+// The output is truncated unless it is written to output_path.
+func setTemplateOutput(d ResourceReadWrite, fs *ReleaseSet, output string) error {
+	if outputsInFiles(fs) {
+		return writeOutputFile(d, fs, KeyTemplateOutput, output)
+	}
+
+	output = truncateOutput(output, maxOutputLen(fs), "helmfile-template output", KeyMaxOutputLen)
+
+	d.Set(KeyTemplateOutput, protectOutput(d, fs, output, KeySensitiveTemplateOutput, false))
+
+	return nil
+}
+
+// protectOutput returns the output to record in the attribute shown in the plan. With sensitive_outputs, the whole
+// output is recorded in the sensitive attribute, leaving a note pointing to it. Otherwise, the changes of Secrets are
+// redacted into the sensitive attribute when redactSecrets is true.
+func protectOutput(d ResourceReadWrite, fs *ReleaseSet, output, sensitiveKey string, redactSecrets bool) string {
+	switch {
+	case fs.SensitiveOutputs:
+		d.Set(sensitiveKey, output)
+		if output == "" {
+			return ""
+		}
+		return fmt.Sprintf("(sensitive, see %s)", sensitiveKey)
+	case redactSecrets:
+		d.Set(sensitiveKey, output)
+		return redactSecretChanges(output, sensitiveKey)
+	}
+
+	return output
+}
+
+// redactSecretChanges replaces the hunks of the Secrets in the helm-diff output with a note pointing to
+// the sensitive attribute that has them. The schema of diff_output and apply_output can't be sensitive
+// only for the release sets that show secrets, and the plan would otherwise print the values of the Secrets.
+func redactSecretChanges(output, sensitiveKey string) string {
+	lines := strings.Split(output, "\n")
+	redacted := make([]string, 0, len(lines))
+
+	var inSecret bool
+
+	for _, l := range lines {
+		if m := diffObjectPattern.FindStringSubmatch(l); m != nil {
+			inSecret = m[3] == "Secret"
+			redacted = append(redacted, l)
+
+			if inSecret {
+				redacted = append(redacted, fmt.Sprintf("  (redacted, see %s)", sensitiveKey))
+			}
+
+			continue
+		}
+
+		// The hunks are indented or prefixed by + and -, and anything else ends the object
+		if inSecret && l != "" && strings.ContainsAny(l[:1], " +-") {
+			continue
+		}
+
+		inSecret = false
+		redacted = append(redacted, l)
+	}
+
+	return strings.Join(redacted, "\n")
+}
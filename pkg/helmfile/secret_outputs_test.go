@@ -0,0 +1,195 @@
+package helmfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+)
+
+const secretDiff = `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+  # Source: app/templates/deployment.yaml
+-       image: app:v1
++       image: app:v2
+default, app-credentials, Secret (v1) has changed:
+  # Source: app/templates/secret.yaml
+  apiVersion: v1
+  data:
+-   password: 'old-password'
++   password: 'new-password'
+
+UPDATED RELEASES:
+`
+
+func TestRedactSecretChanges(t *testing.T) {
+	got := redactSecretChanges(secretDiff, KeySensitiveDiffOutput)
+
+	expected := `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+  # Source: app/templates/deployment.yaml
+-       image: app:v1
++       image: app:v2
+default, app-credentials, Secret (v1) has changed:
+  (redacted, see sensitive_diff_output)
+
+UPDATED RELEASES:
+`
+
+	if got != expected {
+		t.Errorf("unexpected redacted output:\n%s", got)
+	}
+}
+
+func TestSetApplyOutput(t *testing.T) {
+	t.Run("suppress_secrets", func(t *testing.T) {
+		d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+		setApplyOutput(d, &ReleaseSet{}, secretDiff)
+
+		if d.Get(KeyApplyOutput) != secretDiff {
+			t.Errorf("expected apply_output as is, got %v", d.Get(KeyApplyOutput))
+		}
+
+		if v := d.Get(KeySensitiveApplyOutput); v != nil {
+			t.Errorf("expected no sensitive_apply_output, got %v", v)
+		}
+	})
+
+	t.Run("show secrets", func(t *testing.T) {
+		d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+		setApplyOutput(d, &ReleaseSet{ShowSecrets: true}, secretDiff)
+
+		if strings.Contains(d.Get(KeyApplyOutput).(string), "new-password") {
+			t.Errorf("expected the values of the Secret to be redacted from apply_output, got %v", d.Get(KeyApplyOutput))
+		}
+
+		if d.Get(KeySensitiveApplyOutput) != secretDiff {
+			t.Errorf("expected the whole output in sensitive_apply_output, got %v", d.Get(KeySensitiveApplyOutput))
+		}
+	})
+
+	t.Run("sensitive_outputs", func(t *testing.T) {
+		d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+		setApplyOutput(d, &ReleaseSet{ShowSecrets: true, SensitiveOutputs: true}, secretDiff)
+
+		if got, want := d.Get(KeyApplyOutput), "(sensitive, see sensitive_apply_output)"; got != want {
+			t.Errorf("expected apply_output %q, got %v", want, got)
+		}
+
+		if d.Get(KeySensitiveApplyOutput) != secretDiff {
+			t.Errorf("expected the whole output in sensitive_apply_output, got %v", d.Get(KeySensitiveApplyOutput))
+		}
+
+		setApplyOutput(d, &ReleaseSet{SensitiveOutputs: true}, "")
+
+		if d.Get(KeyApplyOutput) != "" || d.Get(KeySensitiveApplyOutput) != "" {
+			t.Errorf("expected no output to leave both attributes empty, got %v and %v", d.Get(KeyApplyOutput), d.Get(KeySensitiveApplyOutput))
+		}
+	})
+}
+
+func TestSuppressSecretsOptions(t *testing.T) {
+	for _, showSecrets := range []bool{false, true} {
+		fs := &ReleaseSet{ShowSecrets: showSecrets}
+
+		if got := buildApplyOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}).SuppressSecrets; got == showSecrets {
+			t.Errorf("expected ApplyOptions.SuppressSecrets %v, got %v", !showSecrets, got)
+		}
+
+		if got := buildDiffOptions(fs, &helmfileFiles{Path: "helmfile.yaml"}, 0).SuppressSecrets; got == showSecrets {
+			t.Errorf("expected DiffOptions.SuppressSecrets %v, got %v", !showSecrets, got)
+		}
+
+		if got, want := diffOutputFlags(fs)[0], secretsFlags(!showSecrets)[0]; got != want {
+			t.Errorf("expected helmfile diff on plan to run with %s, got %s", want, got)
+		}
+	}
+}
+
+func TestSensitiveOutputsSchema(t *testing.T) {
+	s := ReleaseSetSchema
+
+	for _, key := range []string{KeySensitiveDiffOutput, KeySensitiveApplyOutput, KeySensitiveTemplateOutput} {
+		if !s[key].Sensitive {
+			t.Errorf("expected %s to be sensitive", key)
+		}
+	}
+
+	if s[KeySuppressSecrets].Default != true {
+		t.Errorf("expected %s to default to true", KeySuppressSecrets)
+	}
+
+	if s[KeySensitiveOutputs].Default != false {
+		t.Errorf("expected %s to default to false", KeySensitiveOutputs)
+	}
+}
+
+// TestAccHelmfileReleaseSet_sensitiveOutputs plans and applies a release set with sensitive_outputs, and changes its values
+// so that the plan marks the outputs and their sensitive attributes unknown until apply
+func TestAccHelmfileReleaseSet_sensitiveOutputs(t *testing.T) {
+	resourceName := "helmfile_release_set.the_product"
+	releaseID := acctest.RandString(8)
+
+	check := resource.ComposeTestCheckFunc(
+		resource.TestCheckResourceAttr(resourceName, "diff_output", "(sensitive, see sensitive_diff_output)"),
+		resource.TestMatchResourceAttr(resourceName, "sensitive_diff_output", regexp.MustCompile(`pi-`+releaseID)),
+		resource.TestCheckResourceAttr(resourceName, "apply_output", "(sensitive, see sensitive_apply_output)"),
+		resource.TestMatchResourceAttr(resourceName, "sensitive_apply_output", regexp.MustCompile(`pi-`+releaseID)),
+	)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckShellScriptDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHelmfileReleaseSetConfig_sensitiveOutputs(releaseID, "123"),
+				Check:  check,
+			},
+			{
+				Config: testAccHelmfileReleaseSetConfig_sensitiveOutputs(releaseID, "124"),
+				Check:  check,
+			},
+		},
+	})
+}
+
+func testAccHelmfileReleaseSetConfig_sensitiveOutputs(randVal, tag string) string {
+	return fmt.Sprintf(`
+resource "helmfile_release_set" "the_product" {
+  content = <<EOF
+repositories:
+- name: sp
+  url: https://stefanprodan.github.io/podinfo
+
+releases:
+- name: pi-%[1]s
+  chart: sp/podinfo
+  version: 4.0.6
+  values:
+  - image:
+      tag: "{{ .Values.tag }}"
+EOF
+
+  helm_binary = "helm"
+
+  kubeconfig = pathexpand("~/.kube/config")
+
+  working_directory = "%[1]s"
+
+  values = [
+    <<EOF
+{"tag": "%[2]s"}
+EOF
+  ]
+
+  sensitive_outputs = true
+}
+`, randVal, tag)
+}
@@ -0,0 +1,134 @@
+package helmfile
+
+import (
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sensitiveValueMask replaces the sensitive values
+const sensitiveValueMask = "***"
+
+// minSensitiveValueLen is the length of the shortest sensitive value that is masked, as masking shorter ones like
+// "1" or "on" would garble unrelated output
+const minSensitiveValueLen = 4
+
+// sensitiveValues are the values of sensitive_environment_variables of all the release sets that the provider process
+// has read, which is one Terraform operation, so that the logs and the errors of any release set never show them
+var sensitiveValues = struct {
+	sync.RWMutex
+
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}{values: map[string]struct{}{}}
+
+// registerSensitiveValues registers the values of the environment variables to be masked
+func registerSensitiveValues(vars map[string]interface{}) {
+	sensitiveValues.Lock()
+	defer sensitiveValues.Unlock()
+
+	added := false
+
+	for _, v := range vars {
+		s, _ := v.(string)
+		if len(s) < minSensitiveValueLen {
+			continue
+		}
+
+		for _, form := range sensitiveValueForms(s) {
+			if _, ok := sensitiveValues.values[form]; !ok {
+				sensitiveValues.values[form] = struct{}{}
+				added = true
+			}
+		}
+	}
+
+	if !added {
+		return
+	}
+
+	// The longer values come first, so that a value is masked as a whole rather than a value it contains
+	forms := make([]string, 0, len(sensitiveValues.values))
+	for form := range sensitiveValues.values {
+		forms = append(forms, form)
+	}
+	sort.Slice(forms, func(i, j int) bool {
+		if len(forms[i]) != len(forms[j]) {
+			return len(forms[i]) > len(forms[j])
+		}
+		return forms[i] < forms[j]
+	})
+
+	oldnew := make([]string, 0, 2*len(forms))
+	for _, form := range forms {
+		oldnew = append(oldnew, form, sensitiveValueMask)
+	}
+
+	sensitiveValues.replacer = strings.NewReplacer(oldnew...)
+}
+
+// sensitiveValueForms returns the value along with its URL-encoded and base64-encoded forms, as the value can appear
+// encoded in the output, like in a repository URL or in the data of a Secret. The base64 forms are found only when the
+// value is encoded on its own.
+func sensitiveValueForms(v string) []string {
+	forms := []string{
+		v,
+		url.QueryEscape(v),
+		url.PathEscape(v),
+		base64.StdEncoding.EncodeToString([]byte(v)),
+		base64.RawStdEncoding.EncodeToString([]byte(v)),
+		base64.URLEncoding.EncodeToString([]byte(v)),
+		base64.RawURLEncoding.EncodeToString([]byte(v)),
+	}
+
+	unique := forms[:0]
+	seen := map[string]bool{}
+	for _, f := range forms {
+		if !seen[f] {
+			seen[f] = true
+			unique = append(unique, f)
+		}
+	}
+
+	return unique
+}
+
+// redactSensitiveValues replaces the sensitive values in s with ***
+func redactSensitiveValues(s string) string {
+	sensitiveValues.RLock()
+	defer sensitiveValues.RUnlock()
+
+	if sensitiveValues.replacer == nil {
+		return s
+	}
+
+	return sensitiveValues.replacer.Replace(s)
+}
+
+// redactedError is an error whose message has the sensitive values masked, which still unwraps to the original error
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactSensitiveError masks the sensitive values in the error, which is deferred by the resource operations so that
+// the errors shown by Terraform never include them, like the stderr of helm echoing a --set value
+func redactSensitiveError(err *error) {
+	if *err == nil {
+		return
+	}
+
+	if msg := redactSensitiveValues((*err).Error()); msg != (*err).Error() {
+		*err = &redactedError{err: *err, msg: msg}
+	}
+}
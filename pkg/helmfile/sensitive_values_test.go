@@ -0,0 +1,232 @@
+package helmfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+// forgetSensitiveValues clears the registered sensitive values once the test ends, as they are process-wide
+func forgetSensitiveValues(t *testing.T) {
+	t.Cleanup(func() {
+		sensitiveValues.Lock()
+		defer sensitiveValues.Unlock()
+
+		sensitiveValues.values = map[string]struct{}{}
+		sensitiveValues.replacer = nil
+	})
+}
+
+func TestRedactSensitiveValues(t *testing.T) {
+	forgetSensitiveValues(t)
+
+	password := "p@ss word/1234"
+
+	registerSensitiveValues(map[string]interface{}{"DB_PASSWORD": password, "DEBUG": "on"})
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "mid-line", in: "Error: connecting with " + password + " failed", want: "Error: connecting with *** failed"},
+		{name: "URL-encoded", in: "postgres://app:" + url.QueryEscape(password) + "@db", want: "postgres://app:***@db"},
+		{name: "path-encoded", in: "https://registry/" + url.PathEscape(password) + "/charts", want: "https://registry/***/charts"},
+		{name: "base64-encoded", in: "  password: " + base64.StdEncoding.EncodeToString([]byte(password)), want: "  password: ***"},
+		{name: "short values are kept", in: "DEBUG=on", want: "DEBUG=on"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactSensitiveValues(tt.in); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOutputCaptureRedactsSensitiveValues(t *testing.T) {
+	forgetSensitiveValues(t)
+
+	registerSensitiveValues(map[string]interface{}{"REGISTRY_TOKEN": "ghp_0123456789"})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	capture := NewOutputCapture()
+	capture.StreamToLog("")
+
+	// The value is split across the writes, like the output of helm read in chunks
+	capture.Write([]byte("Error: pulling with --password ghp_01234"))
+	capture.Write([]byte("56789 failed\nnext line ghp_0123"))
+
+	logger := CreateCaptureLogger(capture, LogLevelInfo)
+	logger.Infof("authenticating with ghp_0123456789 to the registry")
+
+	got := capture.String()
+
+	for _, want := range []string{"Error: pulling with --password *** failed\n", "authenticating with *** to the registry", "next line ghp_0123"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the output to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "ghp_0123456789") || strings.Contains(logs.String(), "ghp_0123456789") {
+		t.Errorf("expected the value to be masked, got output:\n%s\nlogs:\n%s", got, logs.String())
+	}
+}
+
+func TestBinaryExecutorRedactsSensitiveValues(t *testing.T) {
+	forgetSensitiveValues(t)
+
+	registerSensitiveValues(map[string]interface{}{"DB_PASSWORD": "hunter2-secret"})
+
+	dir, executor := newFakeBinaryHelmfile(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "stdout"), []byte("Error: UPGRADE FAILED: --set db.password=hunter2-secret is invalid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "exit-code"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := executor.Apply(context.Background(), &ApplyOptions{BaseOptions: BaseOptions{FileOrDir: "helmfile.yaml", WorkingDirectory: dir}})
+	if err == nil {
+		t.Fatal("expected the apply to fail")
+	}
+
+	if !strings.Contains(result.Output, "--set db.password=*** is invalid") {
+		t.Errorf("expected the value to be masked mid-line, got:\n%s", result.Output)
+	}
+	for _, s := range []string{result.Output, result.Stdout, result.Stderr, err.Error()} {
+		if strings.Contains(s, "hunter2-secret") {
+			t.Errorf("expected the value to be masked, got:\n%s", s)
+		}
+	}
+}
+
+func TestRedactSensitiveError(t *testing.T) {
+	forgetSensitiveValues(t)
+
+	registerSensitiveValues(map[string]interface{}{"API_KEY": "sk-live-abcdef"})
+
+	errNotFound := errors.New("not found")
+	err := errors.New("creating release set: helm: invalid key sk-live-abcdef: " + errNotFound.Error())
+
+	redactSensitiveError(&err)
+	if err.Error() != "creating release set: helm: invalid key ***: not found" {
+		t.Errorf("expected the value to be masked, got %q", err.Error())
+	}
+
+	// The masked error still unwraps to the original one
+	err = &wrappingError{msg: "reading sk-live-abcdef", err: errNotFound}
+	redactSensitiveError(&err)
+	if err.Error() != "reading ***" || !errors.Is(err, errNotFound) {
+		t.Errorf("expected the value to be masked and the error to unwrap, got %q", err.Error())
+	}
+
+	var none error
+	redactSensitiveError(&none)
+	if none != nil {
+		t.Errorf("expected no error, got %v", none)
+	}
+}
+
+// wrappingError is an error with a message that wraps another error
+type wrappingError struct {
+	msg string
+	err error
+}
+
+func (e *wrappingError) Error() string { return e.msg }
+func (e *wrappingError) Unwrap() error { return e.err }
+
+func TestNewReleaseSetSensitiveEnvironmentVariables(t *testing.T) {
+	forgetSensitiveValues(t)
+
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:                       "releases: []",
+		KeyKubeconfig:                    "/tmp/kubeconfig",
+		KeyEnvironmentVariables:          map[string]interface{}{"AWS_PROFILE": "prod", "DB_PASSWORD": "placeholder"},
+		KeySensitiveEnvironmentVariables: map[string]interface{}{"DB_PASSWORD": "correct-horse-battery"},
+	})
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The sensitive ones are exported along with environment_variables, over them
+	if fs.EnvironmentVariables["AWS_PROFILE"] != "prod" || fs.EnvironmentVariables["DB_PASSWORD"] != "correct-horse-battery" {
+		t.Errorf("expected the sensitive environment variables to be merged over environment_variables, got %v", fs.EnvironmentVariables)
+	}
+
+	if got := redactSensitiveValues("DB_PASSWORD=correct-horse-battery"); got != "DB_PASSWORD=***" {
+		t.Errorf("expected the value to be registered for masking, got %q", got)
+	}
+}
+
+func TestPlanRedactsSensitiveValuesInHelmfileErrors(t *testing.T) {
+	forgetSensitiveValues(t)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	provider, err := New(schema.TestResourceDataRaw(t, Provider().(*schema.Provider).Schema, map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, message := range map[string]string{
+		// The error is only logged, as the plan tolerates an unreachable cluster
+		"logged":   "Error: Kubernetes cluster unreachable: the server rejected the token hunter2-secret",
+		"returned": "Error: UPGRADE FAILED: --set db.password=hunter2-secret is invalid",
+	} {
+		t.Run(name, func(t *testing.T) {
+			logs.Reset()
+
+			dir := t.TempDir()
+
+			bin := filepath.Join(dir, "helmfile")
+			if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\necho '"+message+"' >&2\nexit 1\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			config := terraform.NewResourceConfigRaw(map[string]interface{}{
+				KeyBin:                           bin,
+				KeyContent:                       "releases: []",
+				KeyWorkingDirectory:              dir,
+				KeyKubeconfig:                    writeTestKubeconfig(t),
+				KeyDiffNewResources:              true,
+				KeySensitiveEnvironmentVariables: map[string]interface{}{"DB_PASSWORD": "hunter2-secret"},
+			})
+
+			_, err := resourceHelmfileReleaseSet().Diff(nil, config, provider)
+			if name == "returned" && err == nil {
+				t.Fatal("expected the plan to fail")
+			}
+
+			if name == "logged" && !strings.Contains(logs.String(), "Ignoring helmfile-diff error because Kubernetes cluster is unreachable") {
+				t.Fatalf("expected the error to be logged, got:\n%s", logs.String())
+			}
+
+			for _, s := range []string{logs.String(), fmt.Sprint(err)} {
+				if strings.Contains(s, "hunter2-secret") {
+					t.Errorf("expected the value to be masked, got:\n%s", s)
+				}
+			}
+		})
+	}
+}
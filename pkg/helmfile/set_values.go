@@ -0,0 +1,89 @@
+package helmfile
+
+import (
+	"strings"
+)
+
+const (
+	SetTypeAuto   = "auto"
+	SetTypeString = "string"
+)
+
+// SetValue is a value of the set blocks, which overrides the values of every release like helm --set
+type SetValue struct {
+	// Name is the dot-separated path to the value, where "\." escapes a literal dot
+	Name string
+
+	// Value is the value as is, escaped for helm when passed with --set
+	Value string
+
+	// Type is either auto to let helm coerce the value like --set does, or string to pass it as a string
+	Type string
+}
+
+func newSetValues(v interface{}) []SetValue {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var values []SetValue
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		s := SetValue{Name: m[KeySetName].(string), Value: m[KeySetValue].(string), Type: SetTypeAuto}
+		if t, _ := m[KeySetType].(string); t != "" {
+			s.Type = t
+		}
+
+		values = append(values, s)
+	}
+
+	return values
+}
+
+// setValuesEscaper escapes the characters that helm --set would otherwise interpret in a value,
+// which are the backslash escaping the next character and the comma separating the values
+var setValuesEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`)
+
+// setFlagsOfSetValues returns the auto-typed set blocks as name=value pairs for helm --set, in the order of the blocks
+// so that a later block overrides an earlier one for the same name
+func setFlagsOfSetValues(values []SetValue) []string {
+	var set []string
+	for _, v := range values {
+		if v.Type == SetTypeString {
+			continue
+		}
+		set = append(set, v.Name+"="+setValuesEscaper.Replace(v.Value))
+	}
+	return set
+}
+
+// releasesStringValues returns releases_values_string along with the string-typed set blocks, which are passed
+// to helm in the same values file as strings. The set blocks take precedence for the same name.
+func releasesStringValues(fs *ReleaseSet) map[string]interface{} {
+	var hasString bool
+	for _, v := range fs.SetValues {
+		hasString = hasString || v.Type == SetTypeString
+	}
+
+	if !hasString {
+		return fs.ReleasesValuesString
+	}
+
+	values := map[string]interface{}{}
+	for k, v := range fs.ReleasesValuesString {
+		values[k] = v
+	}
+
+	for _, v := range fs.SetValues {
+		if v.Type == SetTypeString {
+			values[v.Name] = v.Value
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,94 @@
+package helmfile
+
+import (
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+func TestSetFlagsOfSetValues(t *testing.T) {
+	values := []SetValue{
+		{Name: "replicaCount", Value: "3", Type: SetTypeAuto},
+		{Name: "ingress.hosts[0]", Value: "example.com", Type: SetTypeAuto},
+		{Name: "podAnnotations.prometheus\\.io/scrape", Value: "true", Type: SetTypeAuto},
+		{Name: "args", Value: "--a=1,--b=2", Type: SetTypeAuto},
+		{Name: "path", Value: `C:\charts`, Type: SetTypeAuto},
+		{Name: "version", Value: "1.20", Type: SetTypeString},
+	}
+
+	expected := []string{
+		"replicaCount=3",
+		"ingress.hosts[0]=example.com",
+		"podAnnotations.prometheus\\.io/scrape=true",
+		`args=--a=1\,--b=2`,
+		`path=C:\\charts`,
+	}
+
+	got := setFlagsOfSetValues(values)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+
+	// helm parses the escaped values back into the original ones
+	parsed := map[string]interface{}{}
+	for _, s := range got {
+		if err := strvals.ParseInto(s, parsed); err != nil {
+			t.Fatalf("parsing %q: %v", s, err)
+		}
+	}
+
+	if parsed["args"] != "--a=1,--b=2" {
+		t.Errorf("expected the comma to be kept in the value, got %v", parsed["args"])
+	}
+
+	if parsed["path"] != `C:\charts` {
+		t.Errorf("expected the backslash to be kept in the value, got %v", parsed["path"])
+	}
+
+	annotations, _ := parsed["podAnnotations"].(map[string]interface{})
+	if annotations["prometheus.io/scrape"] != true {
+		t.Errorf("expected the escaped dot to be kept in the name, got %v", parsed["podAnnotations"])
+	}
+}
+
+func TestNewSetValues(t *testing.T) {
+	got := newSetValues([]interface{}{
+		map[string]interface{}{KeySetName: "replicaCount", KeySetValue: "3", KeySetType: ""},
+		map[string]interface{}{KeySetName: "image.tag", KeySetValue: "1.20", KeySetType: SetTypeString},
+	})
+
+	expected := []SetValue{
+		{Name: "replicaCount", Value: "3", Type: SetTypeAuto},
+		{Name: "image.tag", Value: "1.20", Type: SetTypeString},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestReleasesStringValuesWithSetValues(t *testing.T) {
+	fs := &ReleaseSet{
+		ReleasesValues:       map[string]interface{}{"image.tag": "1.19", "replicaCount": "2"},
+		ReleasesValuesString: map[string]interface{}{"image.tag": "1.19", "version": "1.0"},
+		SetValues: []SetValue{
+			{Name: "version", Value: "1.20", Type: SetTypeString},
+			{Name: "replicaCount", Value: "3", Type: SetTypeString},
+		},
+	}
+
+	expected := map[string]interface{}{"image.tag": "1.19", "version": "1.20", "replicaCount": "3"}
+	if got := releasesStringValues(fs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The values passed as strings are not passed with --set too
+	if got := releasesSetValues(fs); len(got) != 0 {
+		t.Errorf("expected the releases_values overridden by the string values to be dropped, got %v", got)
+	}
+
+	if got := releasesStringValues(&ReleaseSet{ReleasesValuesString: fs.ReleasesValuesString}); !reflect.DeepEqual(got, fs.ReleasesValuesString) {
+		t.Errorf("expected releases_values_string as is without string-typed set blocks, got %v", got)
+	}
+}
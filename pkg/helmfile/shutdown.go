@@ -0,0 +1,157 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+var (
+	// shutdownCtx is done when Terraform stops the provider or terminates its process,
+	// which stops the running helmfile operations
+	shutdownCtx, stopOperations = context.WithCancel(context.Background())
+
+	// runningOperations are the creates, updates and deletes that are running, which SIGTERM waits for
+	runningOperations sync.WaitGroup
+
+	// shutdownCleanups are the temporary files and generated kubeconfigs of the running operations, removed on SIGTERM
+	shutdownCleanups sync.Map
+
+	handleShutdownSignalsOnce sync.Once
+)
+
+var (
+	// shutdownFlushTimeout is how long a stopped operation gets to exit and flush its output before it is killed
+	// or abandoned
+	shutdownFlushTimeout = 5 * time.Second
+
+	// shutdownGracePeriod is how long SIGTERM waits for the running operations to return their partial output
+	shutdownGracePeriod = 10 * time.Second
+
+	// exitAfterShutdown exits the provider process after SIGTERM. Tests replace it.
+	exitAfterShutdown = os.Exit
+)
+
+// startOperation registers a running operation, returning the context that is done on shutdown or after the timeout
+// of the operation, and the func to call when the operation returns. A zero timeout means no timeout.
+func startOperation(timeout time.Duration) (context.Context, func()) {
+	runningOperations.Add(1)
+
+	if timeout <= 0 {
+		return shutdownCtx, runningOperations.Done
+	}
+
+	ctx, cancel := context.WithTimeout(shutdownCtx, timeout)
+
+	return ctx, func() {
+		cancel()
+		runningOperations.Done()
+	}
+}
+
+// shutdownRequested returns true once the provider is being stopped
+func shutdownRequested() bool {
+	return shutdownCtx.Err() != nil
+}
+
+// removeOnShutdown registers the file to be removed when the provider is terminated before the operation
+// removes it by itself. The returned func unregisters it.
+func removeOnShutdown(path string) func() {
+	if path == "" {
+		return func() {}
+	}
+
+	shutdownCleanups.Store(path, struct{}{})
+
+	return func() { shutdownCleanups.Delete(path) }
+}
+
+// watchProviderStop stops the running operations when Terraform stops the provider, like on Ctrl-C
+func watchProviderStop(p *schema.Provider) {
+	go func() {
+		<-p.StopContext().Done()
+
+		logf("Stopping the running helmfile operations as Terraform stopped the provider")
+
+		stopOperations()
+	}()
+}
+
+// handleShutdownSignals shuts down the provider gracefully on SIGTERM, which Terraform sends when it is interrupted
+// while the provider is still running. go-plugin ignores SIGINT, so that is left to watchProviderStop.
+func handleShutdownSignals() {
+	handleShutdownSignalsOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGTERM)
+
+		go func() {
+			sig := <-ch
+
+			logf("Received %s. Stopping the running helmfile operations", sig)
+
+			shutdown()
+
+			exitAfterShutdown(1)
+		}()
+	})
+}
+
+// shutdown stops the running operations, waits for them to return their partial output within the grace period,
+// and removes the temporary files and generated kubeconfigs they would have removed
+func shutdown() {
+	stopOperations()
+
+	done := make(chan struct{})
+	go func() {
+		runningOperations.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownGracePeriod):
+		logf("Warning: gave up waiting for the running helmfile operations to stop after %s", shutdownGracePeriod)
+	}
+
+	shutdownCleanups.Range(func(k, _ interface{}) bool {
+		if err := os.Remove(k.(string)); err != nil && !os.IsNotExist(err) {
+			logf("Warning: failed to remove %s on shutdown: %v", k, err)
+		}
+		shutdownCleanups.Delete(k)
+
+		return true
+	})
+}
+
+// runInterruptibly runs the operation of the library executor, returning when the context is done even if the
+// operation is still running. helmfile can't be cancelled, so the operation is given shutdownFlushTimeout to finish
+// by itself, and is otherwise abandoned until the provider exits. The operation isn't run when the context is done already.
+func runInterruptibly(ctx context.Context, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("helmfile was interrupted: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(shutdownFlushTimeout):
+		return fmt.Errorf("helmfile was interrupted: %w", ctx.Err())
+	}
+}
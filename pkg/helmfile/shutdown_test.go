@@ -0,0 +1,317 @@
+package helmfile
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// slowHelmfile prints the output of a release, then waits on a helm process that would run for long,
+// flushing a line when it is stopped
+const slowHelmfile = `#!/bin/sh
+trap 'echo "Stopped upgrading release=db"; exit 1' TERM
+echo "Upgrading release=app"
+sleep 30 &
+echo $! > "$(dirname "$0")/helm.pid"
+wait
+`
+
+// resetShutdown gives the test a shutdown context of its own, as stopping the provider is irreversible
+func resetShutdown(t *testing.T) {
+	t.Helper()
+
+	ctx, stop, flush := shutdownCtx, stopOperations, shutdownFlushTimeout
+	t.Cleanup(func() {
+		shutdownCtx, stopOperations, shutdownFlushTimeout = ctx, stop, flush
+	})
+
+	shutdownCtx, stopOperations = context.WithCancel(context.Background())
+	shutdownFlushTimeout = 2 * time.Second
+}
+
+// slowApplyExecutor applies a release and then blocks until the provider is stopped, like an apply stuck on helm --wait
+type slowApplyExecutor struct {
+	*fakeExecutor
+
+	started chan struct{}
+}
+
+func (e *slowApplyExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	close(e.started)
+
+	<-ctx.Done()
+
+	return &Result{Output: "Upgrading release=app\n", ExitCode: 1}, ctx.Err()
+}
+
+// resourceWithID is a release set that is already created
+type resourceWithID struct {
+	*ResourceReadWriteEmbedded
+
+	id string
+}
+
+func (r *resourceWithID) Id() string {
+	return r.id
+}
+
+func TestRunCommandStopsProcessGroup(t *testing.T) {
+	resetShutdown(t)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "helmfile"), []byte(slowHelmfile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(500*time.Millisecond, stopOperations)
+
+	start := time.Now()
+
+	_, err := runCommand(&sdk.Context{}, exec.Command(filepath.Join(dir, "helmfile")), NewState(), false)
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the command to be stopped promptly, took %s", elapsed)
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "Upgrading release=app") || !strings.Contains(err.Error(), "Stopped upgrading release=db") {
+		t.Errorf("expected the error to have the output flushed by the stopped command, got %v", err)
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "helm.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if processRunning(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Error("expected the helm process run by helmfile to be stopped along with it")
+	}
+}
+
+func TestRunInterruptibly(t *testing.T) {
+	resetShutdown(t)
+	shutdownFlushTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// helmfile can't be cancelled, so the operation that doesn't return is abandoned
+	blocked := make(chan struct{})
+	defer close(blocked)
+
+	err := runInterruptibly(ctx, func() error {
+		cancel()
+		<-blocked
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "helmfile was interrupted") || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the operation to be interrupted, got %v", err)
+	}
+
+	// The operation isn't run once the context is done
+	start := time.Now()
+	err = runInterruptibly(ctx, func() error {
+		t.Error("expected the operation not to run")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Errorf("expected the operation to return promptly with context.Canceled, got %v after %s", err, time.Since(start))
+	}
+
+	// The operation that returns within the flush timeout returns its own result
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := runInterruptibly(ctx, func() error { cancel(); return nil }); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStartOperation(t *testing.T) {
+	resetShutdown(t)
+
+	ctx, done := startOperation(0)
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expected no deadline without a timeout")
+	}
+	done()
+
+	ctx, done = startOperation(time.Hour)
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Hour {
+		t.Errorf("expected the deadline of the timeout, got %v", deadline)
+	}
+
+	// The operation is still stopped on shutdown
+	stopOperations()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("expected the operation to be stopped on shutdown, got %v", ctx.Err())
+	}
+	done()
+}
+
+func TestOperationTimeout(t *testing.T) {
+	resetShutdown(t)
+	shutdownFlushTimeout = 100 * time.Millisecond
+
+	f := newDiffCacheFixture(t)
+	f.fs.OperationTimeout = time.Second
+
+	d := &resourceWithID{ResourceReadWriteEmbedded: &ResourceReadWriteEmbedded{m: map[string]interface{}{}}, id: "myapp"}
+
+	executor := &slowApplyExecutor{fakeExecutor: &fakeExecutor{}, started: make(chan struct{})}
+
+	start := time.Now()
+
+	err := UpdateReleaseSet(&sdk.Context{}, f.fs, d, executor)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the apply to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Second {
+		t.Errorf("expected the apply to return promptly after the timeout, took %s", elapsed)
+	}
+
+	// The releases may be left partially upgraded like with an interrupted apply
+	if !hasInterruptedApply("myapp") {
+		t.Error("expected the timed out apply to be recorded as interrupted")
+	}
+	if got := d.Get(KeyApplyOutput); !strings.Contains(got.(string), "Upgrading release=app") {
+		t.Errorf("expected apply_output to have the partial output, got %q", got)
+	}
+
+	clearInterruptedApply("myapp")
+}
+
+func TestInterruptedApply(t *testing.T) {
+	resetShutdown(t)
+
+	f := newDiffCacheFixture(t)
+
+	d := &resourceWithID{ResourceReadWriteEmbedded: &ResourceReadWriteEmbedded{m: map[string]interface{}{}}, id: "myapp"}
+
+	executor := &slowApplyExecutor{fakeExecutor: &fakeExecutor{}, started: make(chan struct{})}
+
+	go func() {
+		<-executor.started
+		stopOperations()
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- UpdateReleaseSet(&sdk.Context{}, f.fs, d, executor)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(30 * time.Second):
+		t.Fatal("expected the apply to return once the provider is stopped")
+	}
+
+	if err == nil {
+		t.Fatal("expected the interrupted apply to fail")
+	}
+
+	if got := d.Get(KeyApplyOutput); !strings.Contains(got.(string), "Upgrading release=app") {
+		t.Errorf("expected apply_output to have the partial output, got %q", got)
+	}
+
+	bs, err := ioutil.ReadFile(interruptedApplyPath("myapp"))
+	if err != nil {
+		t.Fatalf("expected the output of the interrupted apply to be kept: %v", err)
+	}
+	if !strings.Contains(string(bs), "Upgrading release=app") || !strings.Contains(string(bs), "Interrupted: context canceled") {
+		t.Errorf("unexpected output of the interrupted apply:\n%s", bs)
+	}
+
+	// The temporary helmfile of the apply is removed
+	if matches, _ := filepath.Glob(filepath.Join(f.fs.WorkingDirectory, "helmfile-*.yaml")); len(matches) > 0 {
+		t.Errorf("expected the temporary helmfile to be removed, got %v", matches)
+	}
+
+	// The next plan recomputes the outputs
+	if !hasInterruptedApply("myapp") {
+		t.Fatal("expected the next plan to detect the interrupted apply")
+	}
+
+	m := newMockDiffChecker()
+	markInterruptedApply(m, "")
+	for _, k := range []string{KeyDiffOutput, KeyApplyOutput, KeySummary} {
+		if !m.newComputed[k] {
+			t.Errorf("expected %s to be recomputed", k)
+		}
+	}
+	if got := m.newValues[KeyChangeReason]; got != ChangeReasonInterruptedApply {
+		t.Errorf("unexpected change_reason: %v", got)
+	}
+
+	// The next successful apply forgets the interruption
+	resetShutdown(t)
+
+	if err := UpdateReleaseSet(&sdk.Context{}, f.fs, d, &fakeExecutor{applyOutput: "Upgrading release=app\n"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hasInterruptedApply("myapp") {
+		t.Error("expected the successful apply to remove the output of the interrupted apply")
+	}
+}
+
+func TestShutdownRemovesFiles(t *testing.T) {
+	resetShutdown(t)
+
+	dir := t.TempDir()
+
+	tmpFile := filepath.Join(dir, "helmfile-abc.yaml")
+	kubeconfig := filepath.Join(dir, "kubeconfig-eks")
+	kept := filepath.Join(dir, "kept.yaml")
+
+	for _, p := range []string{tmpFile, kubeconfig, kept} {
+		if err := ioutil.WriteFile(p, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeOnShutdown(tmpFile)
+	removeOnShutdown(kubeconfig)
+	removeOnShutdown(kept)()
+
+	shutdown()
+
+	if !shutdownRequested() {
+		t.Error("expected the running operations to be stopped")
+	}
+
+	for _, p := range []string{tmpFile, kubeconfig} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", p)
+		}
+	}
+
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("expected the unregistered file to be kept: %v", err)
+	}
+}
+
+// processRunning returns true when the process exists and is not a zombie left for its parent to reap
+func processRunning(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+
+	return !strings.Contains(string(bs), ") Z ")
+}
@@ -0,0 +1,104 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStaleKubeconfigMaxAge is how old a temporary kubeconfig left behind by a crashed run gets before it is removed
+const DefaultStaleKubeconfigMaxAge = 24 * time.Hour
+
+const (
+	// temporaryKubeconfigPrefix is the prefix of the kubeconfigs written by writeTemporaryKubeconfig
+	temporaryKubeconfigPrefix = ".terraform-helmfile-kubeconfig-"
+
+	// kubeconfigLockSuffix is the suffix of the lockfile that holds the pid of the provider using the kubeconfig
+	kubeconfigLockSuffix = ".lock"
+)
+
+// lockKubeconfig records that the kubeconfig is used by this provider process, so that the sweeps of the other
+// processes leave it alone however old it gets
+func lockKubeconfig(path string) error {
+	return ioutil.WriteFile(path+kubeconfigLockSuffix, []byte(strconv.Itoa(os.Getpid())), 0600)
+}
+
+// unlockKubeconfig removes the lockfile of the kubeconfig
+func unlockKubeconfig(path string) {
+	if err := os.Remove(path + kubeconfigLockSuffix); err != nil && !os.IsNotExist(err) {
+		logf("Warning: failed to remove the lockfile of %s: %v", path, err)
+	}
+}
+
+// kubeconfigInUse returns true when the lockfile of the kubeconfig holds the pid of a running process
+func kubeconfigInUse(path string) bool {
+	bs, err := ioutil.ReadFile(path + kubeconfigLockSuffix)
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if err != nil {
+		return false
+	}
+
+	return processAlive(pid)
+}
+
+// kubeconfigDir returns the directory that writeTemporaryKubeconfig writes the kubeconfigs of the working directory to
+func kubeconfigDir(workingDir string) string {
+	if workingDir == "" || workingDir == "." {
+		return os.TempDir()
+	}
+
+	return workingDir
+}
+
+// sweepStaleKubeconfigs removes the temporary kubeconfigs in the directory that are older than maxAge and not used by
+// a running provider, which are left behind by the runs killed before they removed them. Each of them contains the CA
+// of the cluster, and possibly a token. A zero maxAge disables the sweep.
+func sweepStaleKubeconfigs(dir string, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logf("[DEBUG] Not sweeping stale kubeconfigs in %s: %v", dir, err)
+		return
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, temporaryKubeconfigPrefix) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+
+		// The lockfiles are removed along with their kubeconfigs, unless the kubeconfig was removed on shutdown
+		if strings.HasSuffix(name, kubeconfigLockSuffix) {
+			kubeconfig := strings.TrimSuffix(path, kubeconfigLockSuffix)
+			if _, err := os.Stat(kubeconfig); os.IsNotExist(err) && !kubeconfigInUse(kubeconfig) {
+				unlockKubeconfig(kubeconfig)
+			}
+			continue
+		}
+
+		if time.Since(e.ModTime()) < maxAge || kubeconfigInUse(path) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logf("Warning: failed to remove the stale kubeconfig %s: %v", path, err)
+			continue
+		}
+
+		unlockKubeconfig(path)
+
+		logf("Removed the stale kubeconfig %s, last modified at %s", path, e.ModTime().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,126 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// exitedPid returns the pid of a process that has exited
+func exitedPid(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestSweepStaleKubeconfigs(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-25 * time.Hour)
+	dead := strconv.Itoa(exitedPid(t))
+
+	files := []struct {
+		name  string
+		old   bool
+		lock  string
+		write bool
+		want  bool
+	}{
+		{name: temporaryKubeconfigPrefix + "crashed-1", old: true, write: true, want: false},
+		{name: temporaryKubeconfigPrefix + "crashed-2", old: true, write: true, lock: dead, want: false},
+		{name: temporaryKubeconfigPrefix + "recent-1", write: true, want: true},
+		{name: temporaryKubeconfigPrefix + "running-1", old: true, write: true, lock: strconv.Itoa(os.Getpid()), want: true},
+		// Removed on shutdown, leaving the lockfile behind
+		{name: temporaryKubeconfigPrefix + "shutdown-1", lock: dead, want: false},
+		{name: "kubeconfig", old: true, write: true, want: true},
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+
+		if f.write {
+			if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if f.lock != "" {
+			if err := ioutil.WriteFile(path+kubeconfigLockSuffix, []byte(f.lock), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if f.old {
+			for _, p := range []string{path, path + kubeconfigLockSuffix} {
+				if err := os.Chtimes(p, old, old); err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	sweepStaleKubeconfigs(dir, 24*time.Hour)
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+
+		if f.write {
+			if _, err := os.Stat(path); (err == nil) != f.want {
+				t.Errorf("expected %s to be kept: %v, got %v", f.name, f.want, err)
+			}
+		}
+
+		if f.lock != "" {
+			if _, err := os.Stat(path + kubeconfigLockSuffix); (err == nil) != f.want {
+				t.Errorf("expected the lockfile of %s to be kept: %v, got %v", f.name, f.want, err)
+			}
+		}
+	}
+}
+
+func TestSweepStaleKubeconfigsDisabled(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, temporaryKubeconfigPrefix+"crashed-1")
+
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-25 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	sweepStaleKubeconfigs(dir, 0)
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the kubeconfig to be kept with the sweep disabled, got %v", err)
+	}
+}
+
+func TestTemporaryKubeconfigLock(t *testing.T) {
+	path, err := writeTemporaryKubeconfig(testKubeconfig, t.TempDir(), "my-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !kubeconfigInUse(path) {
+		t.Errorf("expected the kubeconfig to be locked by this process")
+	}
+
+	if err := cleanupKubeconfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + kubeconfigLockSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the lockfile to be removed along with the kubeconfig, got %v", err)
+	}
+}
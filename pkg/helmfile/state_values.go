@@ -0,0 +1,51 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/helmfile/helmfile/pkg/maputil"
+)
+
+// validateStateValues validates that state_values can be passed as --state-values-set key=value, which helmfile
+// splits on commas without a way to escape them
+func validateStateValues(v interface{}, k string) ([]string, []error) {
+	values, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var errs []error
+	for _, key := range sortedKeys(values) {
+		value := fmt.Sprintf("%v", values[key])
+
+		if key == "" || strings.ContainsAny(key, ",=") {
+			errs = append(errs, fmt.Errorf("%s: invalid key %q: keys can't be empty or contain commas and equal signs", k, key))
+		}
+
+		if strings.Contains(value, ",") {
+			errs = append(errs, fmt.Errorf("%s: the value of %q contains a comma, which --state-values-set can't pass. Use values instead", k, key))
+		}
+	}
+
+	return nil, errs
+}
+
+// stateValuesSetFlags returns state_values as the --state-values-set flags of the helmfile binary
+func stateValuesSetFlags(values map[string]interface{}) []string {
+	var flags []string
+	for _, k := range sortedKeys(values) {
+		flags = append(flags, "--state-values-set", fmt.Sprintf("%s=%v", k, values[k]))
+	}
+	return flags
+}
+
+// typedStateValues returns state_values as the state values that the helmfile binary makes of --state-values-set,
+// with the dotted keys nested and the values coerced into booleans, integers and nulls
+func typedStateValues(values map[string]interface{}) map[string]interface{} {
+	typed := map[string]interface{}{}
+	for _, k := range sortedKeys(values) {
+		maputil.Set(typed, maputil.ParseKey(k), fmt.Sprintf("%v", values[k]), false)
+	}
+	return typed
+}
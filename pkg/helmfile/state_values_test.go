@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/helper/acctest"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"go.uber.org/zap"
 )
 
 // TestStateValuesFileCreation tests that state values are correctly written
@@ -71,7 +73,7 @@ func TestStateValuesFileCreation(t *testing.T) {
 			fs := &ReleaseSet{
 				Content:          "test: content",
 				WorkingDirectory: tempDir,
-				Kubeconfig:       "/tmp/kubeconfig",
+				Kubeconfig:       writeTestKubeconfig(t),
 				Values:           tt.values,
 				Bin:              "helmfile",
 				HelmBin:          "helm",
@@ -109,6 +111,95 @@ func TestStateValuesFileCreation(t *testing.T) {
 	}
 }
 
+// TestSplitValuesDocuments tests that multi-document values strings are split into
+// separate documents instead of silently dropping everything after the first separator
+func TestSplitValuesDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   string
+		expected []string
+	}{
+		{
+			name:     "single document is kept as-is",
+			values:   "namespace: foo\nregion: us-west-2",
+			expected: []string{"namespace: foo\nregion: us-west-2"},
+		},
+		{
+			name:     "multiple documents are split in order",
+			values:   "namespace: foo\n---\nregion: us-west-2\n---\nreplicas: 3\n",
+			expected: []string{"namespace: foo\n", "region: us-west-2\n", "replicas: 3\n"},
+		},
+		{
+			name:     "leading separator",
+			values:   "---\nnamespace: foo\n",
+			expected: []string{"namespace: foo\n"},
+		},
+		{
+			name:     "empty documents between separators are dropped",
+			values:   "namespace: foo\n---\n\n---\n# only a comment\n---\nregion: us-west-2\n---\n",
+			expected: []string{"namespace: foo\n", "region: us-west-2\n"},
+		},
+		{
+			name:     "separator with trailing comment",
+			values:   "namespace: foo\n--- # second\nregion: us-west-2",
+			expected: []string{"namespace: foo\n", "region: us-west-2\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitValuesDocuments(tt.values)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %d documents, got %d: %q", len(tt.expected), len(got), got)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("document %d: expected %q, got %q", i, tt.expected[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// TestMultiDocumentValuesFiles tests that each document in a multi-document values string
+// is written to its own state values file, preserving order
+func TestMultiDocumentValuesFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	fs := &ReleaseSet{
+		Content:          "test: content",
+		WorkingDirectory: tempDir,
+		Kubeconfig:       writeTestKubeconfig(t),
+		Values: []interface{}{
+			"namespace: foo\n---\n---\nregion: us-west-2\n",
+			"replicas: 3\n",
+		},
+		Bin:     "helmfile",
+		HelmBin: "helm",
+	}
+
+	files, err := prepareHelmfileFile(fs, nil)
+	if err != nil {
+		t.Fatalf("prepareHelmfileFile failed: %v", err)
+	}
+	defer files.remove()
+
+	if len(files.ValuesFiles) != 3 {
+		t.Fatalf("expected 3 state values files, got %d: %v", len(files.ValuesFiles), files.ValuesFiles)
+	}
+
+	expected := []string{"namespace: foo\n", "region: us-west-2\n", "replicas: 3\n"}
+	for i, f := range files.ValuesFiles {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("reading state values file: %v", err)
+		}
+		if string(content) != expected[i] {
+			t.Errorf("state values file %d: expected %q, got %q", i, expected[i], string(content))
+		}
+	}
+}
+
 // TestStateValuesWithGoTemplate tests that Go template rendering works
 // with StateValues.namespace
 func TestStateValuesWithGoTemplate(t *testing.T) {
@@ -128,7 +219,7 @@ releases:
 	fs := &ReleaseSet{
 		Content:          helmfileContent,
 		WorkingDirectory: tempDir,
-		Kubeconfig:       "/tmp/kubeconfig",
+		Kubeconfig:       writeTestKubeconfig(t),
 		EnableGoTemplate: true,
 		Values: []interface{}{
 			`{"namespace": "production"}`,
@@ -356,3 +447,66 @@ func testCheckOutputContains(resourceName, expectedString string) resource.TestC
 		return nil
 	}
 }
+
+func TestTypedStateValues(t *testing.T) {
+	got := typedStateValues(map[string]interface{}{
+		"name":         "app",
+		"replicas":     "3",
+		"debug":        "true",
+		"version":      "1.20",
+		"cluster.name": "prod",
+		"cluster.zone": "us-east-1a",
+	})
+
+	expected := map[string]interface{}{
+		"name":     "app",
+		"replicas": int64(3),
+		"debug":    true,
+		"version":  "1.20",
+		"cluster":  map[string]interface{}{"name": "prod", "zone": "us-east-1a"},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
+
+func TestStateValuesSetFlags(t *testing.T) {
+	got := stateValuesSetFlags(map[string]interface{}{"replicas": "3", "cluster.name": "prod"})
+
+	expected := []string{"--state-values-set", "cluster.name=prod", "--state-values-set", "replicas=3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestValidateStateValues(t *testing.T) {
+	if _, errs := validateStateValues(map[string]interface{}{"cluster.name": "prod", "url": "http://a?b=c"}, KeyStateValues); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	_, errs := validateStateValues(map[string]interface{}{"hosts": "a,b", "a=b": "c"}, KeyStateValues)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+
+	if !strings.Contains(errs[1].Error(), "Use values instead") {
+		t.Errorf("expected the error to suggest values, got %v", errs[1])
+	}
+}
+
+func TestStateValuesConfigProvider(t *testing.T) {
+	base := newBaseConfigProvider(BaseOptions{
+		StateValuesSet: map[string]interface{}{"cluster": map[string]interface{}{"name": "dev", "region": "us-east-1"}},
+		StateValues:    map[string]interface{}{"cluster.name": "prod", "replicas": "3"},
+	}, zap.NewNop().Sugar())
+
+	expected := map[string]interface{}{
+		"cluster":  map[string]interface{}{"name": "prod", "region": "us-east-1"},
+		"replicas": int64(3),
+	}
+
+	if got := base.StateValuesSet(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected state_values over the other state values, got %#v", got)
+	}
+}
@@ -0,0 +1,187 @@
+package helmfile
+
+import (
+	"strings"
+)
+
+// Summary is the structured summary of the pending changes of a release set.
+// It is designed for cheap aggregation in outputs when the same release set is instantiated per environment with for_each.
+//
+// On plan it is computed from the helmfile diff output. After apply it reflects the releases left unapplied,
+// so that it matches what the next plan computes when nothing else changed.
+type Summary struct {
+	// HasChanges is true when one or more releases have pending changes
+	HasChanges bool
+
+	// ChangedReleaseCount is the number of releases with pending changes
+	ChangedReleaseCount int
+
+	// Failed is true when the last apply failed, including failures tolerated by continue_on_error
+	Failed bool
+}
+
+func (s Summary) toList() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			KeySummaryHasChanges:          s.HasChanges,
+			KeySummaryChangedReleaseCount: s.ChangedReleaseCount,
+			KeySummaryFailed:              s.Failed,
+		},
+	}
+}
+
+// diffSummary summarizes the output of helmfile diff.
+// The output is empty when no releases matched or none of them has changes.
+func diffSummary(diff string) Summary {
+	count := countChangedReleases(diff) + countMissingCRDDiffNotes(diff)
+
+	return Summary{
+		HasChanges:          diff != "",
+		ChangedReleaseCount: count,
+	}
+}
+
+// ApplySummary is the structured summary of the outcome of the last apply of a release set.
+// Unlike Summary, which keeps telling the pending changes after apply, it tells what the apply did.
+type ApplySummary struct {
+	// AppliedReleaseCount is the number of releases that the apply installed or upgraded
+	AppliedReleaseCount int
+
+	// FailedReleaseCount is the number of releases that failed in the apply
+	FailedReleaseCount int
+}
+
+func (s ApplySummary) toList() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			KeyApplySummaryAppliedReleaseCount: s.AppliedReleaseCount,
+			KeyApplySummaryFailedReleaseCount:  s.FailedReleaseCount,
+		},
+	}
+}
+
+// applyResultSummary summarizes what helmfile apply or sync did from its output
+func applyResultSummary(result *Result) ApplySummary {
+	failed := parseReleaseTables(result.Output)["FAILED"]
+
+	var applied int
+
+	for _, s := range parseReleaseStatus(result.Output) {
+		if s.Changed && !failed[listedRelease{Name: s.Name, Namespace: s.Namespace}.ID()] {
+			applied++
+		}
+	}
+
+	return ApplySummary{
+		AppliedReleaseCount: applied,
+		FailedReleaseCount:  len(failed),
+	}
+}
+
+// setApplySummary sets apply_summary to the outcome of the apply
+func setApplySummary(d ResourceReadWrite, result *Result) {
+	if result == nil {
+		return
+	}
+
+	d.Set(KeyApplySummary, applyResultSummary(result).toList())
+}
+
+// applySummary summarizes the pending changes after helmfile apply. The releases that failed are still pending.
+func applySummary(result *Result, failed bool) Summary {
+	var failedReleases int
+
+	if result != nil {
+		failedReleases = countFailedReleases(result.Output)
+		failed = failed || result.ExitCode != 0
+	}
+
+	return Summary{
+		HasChanges:          failed,
+		ChangedReleaseCount: failedReleases,
+		Failed:              failed,
+	}
+}
+
+// countChangedReleases counts the releases in the diff that are followed by any diff
+func countChangedReleases(diff string) int {
+	var count int
+
+	for _, r := range parseDiff(diff) {
+		if r.changed() {
+			count++
+		}
+	}
+
+	return count
+}
+
+// changedReleaseNames returns the names of the releases in the diff that are followed by any diff
+func changedReleaseNames(diff string) []string {
+	var names []string
+
+	for _, r := range parseDiff(diff) {
+		if r.changed() {
+			names = append(names, r.Name)
+		}
+	}
+
+	return names
+}
+
+// countFailedReleases counts the distinct releases in the FAILED RELEASES tables printed by helmfile apply.
+// There can be one table per apply when continue_on_error applies the releases one by one.
+func countFailedReleases(output string) int {
+	return len(parseReleaseTables(output)["FAILED"])
+}
+
+// parseReleaseTables parses the NAME NAMESPACE ... tables printed by helmfile apply, like UPDATED RELEASES and FAILED RELEASES.
+// It returns the IDs of the releases as namespace/name keyed by the first word of the table title.
+func parseReleaseTables(output string) map[string]map[string]bool {
+	tables := map[string]map[string]bool{}
+
+	var (
+		table  string
+		header bool
+	)
+
+	for _, l := range strings.Split(output, "\n") {
+		t := strings.TrimSpace(l)
+
+		if strings.HasSuffix(t, " RELEASES:") {
+			table = strings.TrimSuffix(t, " RELEASES:")
+			header = true
+			continue
+		}
+
+		if table == "" {
+			continue
+		}
+
+		if t == "" {
+			if !header {
+				table = ""
+			}
+			continue
+		}
+
+		// Skip the NAME NAMESPACE CHART ... header row
+		if header {
+			header = false
+			continue
+		}
+
+		fields := strings.Fields(t)
+		r := listedRelease{Name: fields[0]}
+		if len(fields) > 1 {
+			r.Namespace = fields[1]
+		}
+
+		if tables[table] == nil {
+			tables[table] = map[string]bool{}
+		}
+		tables[table][r.ID()] = true
+	}
+
+	return tables
+}
@@ -0,0 +1,150 @@
+package helmfile
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want Summary
+	}{
+		{
+			name: "no releases matched or no changes",
+			diff: "",
+			want: Summary{},
+		},
+		{
+			name: "one of two releases changed",
+			diff: `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+-   replicas: 1
++   replicas: 2
+
+Comparing release=exporter, chart=charts/exporter, namespace=monitoring
+`,
+			want: Summary{HasChanges: true, ChangedReleaseCount: 1},
+		},
+		{
+			name: "all releases changed",
+			diff: `Comparing release=app, chart=charts/app, namespace=default
+default, app, Deployment (apps) has changed:
+Comparing release=exporter, chart=charts/exporter, namespace=monitoring
+monitoring, exporter, Service (v1) has been added:
+`,
+			want: Summary{HasChanges: true, ChangedReleaseCount: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffSummary(tt.diff); got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApplySummary(t *testing.T) {
+	failedOutput := `
+UPDATED RELEASES:
+NAME   NAMESPACE   CHART         VERSION   DURATION
+app    default     charts/app    1.0.0           3s
+
+FAILED RELEASES:
+NAME       NAMESPACE    CHART              VERSION   DURATION
+exporter   monitoring   charts/exporter    1.0.0           1s
+`
+
+	tests := []struct {
+		name   string
+		result *Result
+		failed bool
+		want   Summary
+	}{
+		{
+			name:   "successful apply leaves no pending changes",
+			result: &Result{Output: "\nUPDATED RELEASES:\nNAME   NAMESPACE\napp    default\n"},
+			want:   Summary{},
+		},
+		{
+			name:   "failed apply",
+			result: &Result{Output: failedOutput, ExitCode: 1, Error: errors.New("failed")},
+			failed: true,
+			want:   Summary{HasChanges: true, ChangedReleaseCount: 1, Failed: true},
+		},
+		{
+			name:   "failed apply without result",
+			result: nil,
+			failed: true,
+			want:   Summary{HasChanges: true, Failed: true},
+		},
+		{
+			name:   "failures tolerated by continue_on_error",
+			result: &Result{Output: failedOutput, ExitCode: 1},
+			want:   Summary{HasChanges: true, ChangedReleaseCount: 1, Failed: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applySummary(tt.result, tt.failed); got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSummaryToList(t *testing.T) {
+	want := []interface{}{
+		map[string]interface{}{
+			KeySummaryHasChanges:          true,
+			KeySummaryChangedReleaseCount: 2,
+			KeySummaryFailed:              false,
+		},
+	}
+
+	if got := (Summary{HasChanges: true, ChangedReleaseCount: 2}).toList(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestApplyResultSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   ApplySummary
+	}{
+		{
+			name:   "no changes",
+			output: "Comparing release=app, chart=charts/app, namespace=default\n\nNo affected releases\n",
+			want:   ApplySummary{},
+		},
+		{
+			name: "one release upgraded and one failed",
+			output: `Upgrading release=app, chart=charts/app, namespace=default
+Upgrading release=exporter, chart=charts/exporter, namespace=monitoring
+
+UPDATED RELEASES:
+NAME   NAMESPACE   CHART         VERSION   DURATION
+app    default     charts/app    1.0.0           3s
+
+FAILED RELEASES:
+NAME       NAMESPACE    CHART              VERSION   DURATION
+exporter   monitoring   charts/exporter    1.0.0           1s
+`,
+			want: ApplySummary{AppliedReleaseCount: 1, FailedReleaseCount: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyResultSummary(&Result{Output: tt.output}); got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package helmfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// tempFiles counts the running operations using each temporary file. The files are named after the hashes of their
+// contents, so the operations writing the same contents share them, like the diff run by the apply of a release set.
+var tempFiles = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: map[string]int{}}
+
+// tempFileKey returns the absolute path to the file, as the same file is referenced by both relative and absolute paths
+func tempFileKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+// commandTempFiles are the temporary files of the commands created by NewCommandWithKubeconfig, which are removed
+// once runCommand runs them
+var commandTempFiles sync.Map
+
+// writeTempFile writes a temporary file for an operation, which removeTempFiles removes after the operation
+func writeTempFile(path string, content []byte, perm os.FileMode) error {
+	tempFiles.Lock()
+	tempFiles.refs[tempFileKey(path)]++
+	tempFiles.Unlock()
+
+	if err := ioutil.WriteFile(path, content, perm); err != nil {
+		removeTempFiles(false, path)
+		return err
+	}
+
+	return nil
+}
+
+// removeTempFiles removes the temporary files of an operation that no other running operation uses.
+// With keep, the files are left for debugging, like with keep_temp_files.
+func removeTempFiles(keep bool, paths ...string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+
+	for _, p := range paths {
+		key := tempFileKey(p)
+		if tempFiles.refs[key]--; tempFiles.refs[key] > 0 {
+			continue
+		}
+
+		delete(tempFiles.refs, key)
+
+		if keep {
+			logf("[DEBUG] Keeping temporary file %s", p)
+			continue
+		}
+
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			logf("Warning: removing temporary file %s: %v", p, err)
+		}
+	}
+}
+
+// commandFiles are the temporary files of a command
+type commandFiles struct {
+	keep  bool
+	paths []string
+}
+
+// addCommandTempFiles records the temporary files of the command, to be removed once it is run
+func addCommandTempFiles(cmd *exec.Cmd, keep bool, paths ...string) {
+	files := &commandFiles{keep: keep}
+
+	if v, ok := commandTempFiles.Load(cmd); ok {
+		files = v.(*commandFiles)
+	}
+
+	files.paths = append(files.paths, paths...)
+
+	commandTempFiles.Store(cmd, files)
+}
+
+// removeCommandTempFiles removes the temporary files of the command
+func removeCommandTempFiles(cmd *exec.Cmd) {
+	if v, ok := commandTempFiles.LoadAndDelete(cmd); ok {
+		files := v.(*commandFiles)
+		removeTempFiles(files.keep, files.paths...)
+	}
+}
+
+// sweepTempFiles removes the temporary files of the release set left in its scratch directory, like the ones of
+// the operations stopped along with the provider. The files of other release sets are never removed, as the ones of
+// the release set are told by its ID in their names.
+func sweepTempFiles(fs *ReleaseSet) error {
+	if fs.ID == "" || fs.KeepTempFiles {
+		return nil
+	}
+
+	dir, err := scratchDir(fs)
+	if err != nil {
+		return err
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	pattern := regexp.MustCompile(`^(helmfile|temp\.values)-` + regexp.QuoteMeta(unsafeFileNameChars.ReplaceAllString(fs.ID, "_")) + `-[0-9a-f]{64}\.yaml(\.gotmpl)?$`)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("sweeping temporary files: %w", err)
+	}
+
+	// kept is whether a file in use is kept
+	var kept bool
+
+	for _, e := range entries {
+		if e.IsDir() || !pattern.MatchString(e.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+
+		tempFiles.Lock()
+		inUse := tempFiles.refs[tempFileKey(path)] > 0
+		tempFiles.Unlock()
+
+		if inUse {
+			kept = true
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logf("Warning: removing temporary file %s: %v", path, err)
+		}
+	}
+
+	// The directory of the release set is managed by the provider, so it is removed with anything else left in it,
+	// like the charts fetched for values_schema. .terraform-helmfile goes along with the directory of the last release set.
+	if isolatedScratchSubdir(fs) != "" && !kept {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("removing scratch directory: %w", err)
+		}
+
+		if err := os.Remove(filepath.Dir(dir)); err != nil && !os.IsNotExist(err) {
+			logf("[DEBUG] Keeping directory %s: %v", filepath.Dir(dir), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// listWorkingDirectory returns the names of the files left in the working directory of the fixture
+func listWorkingDirectory(t *testing.T, f *diffCacheFixture) []string {
+	t.Helper()
+
+	entries, err := ioutil.ReadDir(f.fs.WorkingDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		// The lock file of the working directory is kept for the next operations
+		if e.Name() == workingDirectoryLockFile {
+			continue
+		}
+
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newTempFilesFixture(t *testing.T) (*diffCacheFixture, *resourceWithID) {
+	f := newDiffCacheFixture(t)
+	f.fs.ID = "myapp"
+	f.fs.Values = []interface{}{"replicas: 3\n"}
+	f.fs.ReleasesValuesString = map[string]interface{}{"image.tag": "v1"}
+
+	d := &resourceWithID{ResourceReadWriteEmbedded: &ResourceReadWriteEmbedded{m: map[string]interface{}{}}, id: "myapp"}
+
+	return f, d
+}
+
+func TestOperationsRemoveTempFiles(t *testing.T) {
+	t.Run("successful apply", func(t *testing.T) {
+		f, d := newTempFilesFixture(t)
+
+		if err := UpdateReleaseSet(&sdk.Context{}, f.fs, d, &fakeExecutor{applyOutput: "Upgrading release=app\n"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if names := listWorkingDirectory(t, f); len(names) > 0 {
+			t.Errorf("expected the working directory to be clean, got %v", names)
+		}
+	})
+
+	t.Run("failed apply", func(t *testing.T) {
+		f, d := newTempFilesFixture(t)
+
+		executor := &fakeExecutor{failingSelectors: map[string]bool{"tier=backend": true}}
+
+		if err := UpdateReleaseSet(&sdk.Context{}, f.fs, d, executor); err == nil {
+			t.Fatal("expected the apply to fail")
+		}
+
+		if names := listWorkingDirectory(t, f); len(names) > 0 {
+			t.Errorf("expected the working directory to be clean, got %v", names)
+		}
+	})
+
+	t.Run("keep_temp_files", func(t *testing.T) {
+		f, d := newTempFilesFixture(t)
+		f.fs.KeepTempFiles = true
+
+		if err := UpdateReleaseSet(&sdk.Context{}, f.fs, d, &fakeExecutor{applyOutput: "Upgrading release=app\n"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		names := strings.Join(listWorkingDirectory(t, f), " ")
+		for _, prefix := range []string{"helmfile-myapp-", "temp.values-myapp-"} {
+			if !strings.Contains(names, prefix) {
+				t.Errorf("expected a file named %s* to be kept, got %s", prefix, names)
+			}
+		}
+	})
+}
+
+func TestDeleteReleaseSetSweepsTempFiles(t *testing.T) {
+	f, d := newTempFilesFixture(t)
+
+	hash := strings.Repeat("0123456789abcdef", 4)
+
+	stale := []string{
+		"helmfile-myapp-" + hash + ".yaml",
+		"helmfile-myapp-" + hash + ".yaml.gotmpl",
+		"temp.values-myapp-" + hash + ".yaml",
+	}
+
+	// The files of the other release sets in the same working directory, and the ones of the user, are kept
+	others := []string{
+		"helmfile-myapp_2-" + hash + ".yaml",
+		"temp.values-other-" + hash + ".yaml",
+		"helmfile.yaml",
+	}
+
+	for _, name := range append(append([]string{}, stale...), others...) {
+		if err := ioutil.WriteFile(filepath.Join(f.fs.WorkingDirectory, name), []byte("releases: []\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := DeleteReleaseSet(&sdk.Context{}, f.fs, d, &fakeExecutor{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Strings(others)
+	if names := listWorkingDirectory(t, f); strings.Join(names, " ") != strings.Join(others, " ") {
+		t.Errorf("expected only %v to be left, got %v", others, names)
+	}
+}
+
+func TestRemoveTempFilesInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "helmfile-abc.yaml")
+
+	// Two operations writing the same contents share the file
+	for i := 0; i < 2; i++ {
+		if err := writeTempFile(path, []byte("releases: []\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeTempFiles(false, path)
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the file to be kept while the other operation uses it: %v", err)
+	}
+
+	removeTempFiles(false, path)
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed after the last operation, got %v", err)
+	}
+}
+
+func TestReleaseSetSchemaKeepTempFiles(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ReleaseSetSchema, map[string]interface{}{
+		KeyContent:       "releases: []",
+		KeyKubeconfig:    "/tmp/kubeconfig",
+		KeyKeepTempFiles: true,
+	})
+
+	fs, err := NewReleaseSet(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fs.KeepTempFiles {
+		t.Error("expected keep_temp_files to be read")
+	}
+}
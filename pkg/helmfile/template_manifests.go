@@ -0,0 +1,50 @@
+package helmfile
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// manifestIdentity is the part of a manifest that identifies it
+type manifestIdentity struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+// key returns kind/namespace/name, whose namespace is empty when the manifest doesn't set it
+func (m manifestIdentity) key() string {
+	return m.Kind + "/" + m.Metadata.Namespace + "/" + m.Metadata.Name
+}
+
+// templateManifests splits the output of helmfile template into the manifests keyed by kind/namespace/name.
+// helm template leaves the namespace of the release out of the manifests, so it is in the key only when the chart
+// sets metadata.namespace. The documents without a kind or a name, like the empty ones of the templates that render
+// nothing, are skipped. When two manifests have the same key, the later one is kept.
+func templateManifests(output string) (map[string]string, error) {
+	manifests := map[string]string{}
+
+	for _, doc := range splitValuesDocuments(output) {
+		var m manifestIdentity
+		if err := yaml.Unmarshal([]byte(doc), &m); err != nil {
+			return nil, fmt.Errorf("parsing the manifest:\n%s\n%w", doc, err)
+		}
+
+		if m.Kind == "" || m.Metadata.Name == "" {
+			continue
+		}
+
+		key := m.key()
+		if _, ok := manifests[key]; ok {
+			logf("Warning: more than one manifest of %s was rendered. Keeping the last one", key)
+		}
+
+		manifests[key] = strings.TrimLeft(doc, "\n")
+	}
+
+	return manifests, nil
+}
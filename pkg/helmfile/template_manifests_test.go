@@ -0,0 +1,59 @@
+package helmfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateManifests(t *testing.T) {
+	output := `---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  ports:
+  - port: 80
+---
+# Source: app/templates/empty.yaml
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: prod
+`
+
+	got, err := templateManifests(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"Service//app": `# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  ports:
+  - port: 80
+`,
+		"Deployment/prod/app": `# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: prod
+`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected manifests:\nwant: %q\ngot:  %q", want, got)
+	}
+
+	if _, err := templateManifests("kind: [unterminated"); err == nil {
+		t.Error("expected an invalid manifest to fail")
+	}
+}
@@ -2,13 +2,16 @@ package helmfile
 
 import (
 	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
-	"log"
 	"os/exec"
+	"sort"
 )
 
 // State is a wrapper around both the input and output attributes that are relavent for updates
 type State struct {
 	Output string
+
+	// Stderr is the stderr of the command, for the commands whose Output is only the stdout
+	Stderr string
 }
 
 // NewState is the constructor for State
@@ -16,20 +19,37 @@ func NewState() *State {
 	return &State{}
 }
 
+// sortedKeys returns the keys of the map in sorted order, so that the flags and environment variables
+// generated from the map are deterministic across runs
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func readEnvironmentVariables(ev map[string]interface{}, exclude string) []string {
 	var variables []string
-	if ev != nil {
-		for k, v := range ev {
-			if k == exclude {
-				continue
-			}
-			variables = append(variables, k+"="+v.(string))
+	for _, k := range sortedKeys(ev) {
+		if k == exclude {
+			continue
 		}
+		variables = append(variables, k+"="+ev[k].(string))
 	}
 	return variables
 }
 
 func runCommand(ctx *sdk.Context, cmd *exec.Cmd, state *State, diffMode bool) (*State, error) {
+	defer closeExtraFiles(cmd)
+	defer removeCommandTempFiles(cmd)
+
+	// Commands that aren't bounded by a timeout are still stopped when the provider is
+	if cmd.Cancel == nil {
+		cmd = commandWithContext(shutdownCtx, cmd)
+	}
+
 	res, err := ctx.Run(cmd)
 	if err != nil {
 		return nil, err
@@ -39,10 +59,10 @@ func runCommand(ctx *sdk.Context, cmd *exec.Cmd, state *State, diffMode bool) (*
 	if diffMode && res.ExitStatus == 0 {
 		newState.Output = ""
 	} else {
-		newState.Output = res.Output
+		newState.Output = redactSensitiveValues(res.Output)
 	}
 
-	log.Printf("[DEBUG] helmfile command new state: \"%v\"", newState)
+	logf("helmfile command new state: \"%v\"", newState)
 
 	return newState, nil
 }
@@ -0,0 +1,147 @@
+package helmfile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ValuesHandlingFiles passes the values as state values files that are layered before values_files
+	ValuesHandlingFiles = "files"
+
+	// ValuesHandlingInline passes the values as state values that are layered after values_files
+	ValuesHandlingInline = "inline"
+)
+
+// splitValuesDocuments splits a possibly multi-document YAML string into documents.
+//
+// Helmfile reads a state values file as a single YAML document, silently ignoring everything after the first
+// document separator. We split multi-document values into separate documents so that each one can be passed
+// as its own state values file, preserving the order in which they are declared.
+// Documents that are empty or only contain comments are dropped.
+func splitValuesDocuments(s string) []string {
+	lines := strings.Split(s, "\n")
+
+	var hasSeparator bool
+	for _, l := range lines {
+		if isDocumentSeparator(l) {
+			hasSeparator = true
+			break
+		}
+	}
+
+	// Keep single-document values as-is so that the temp file and its hash stay the same
+	if !hasSeparator {
+		return []string{s}
+	}
+
+	var (
+		docs    []string
+		current []string
+	)
+
+	flush := func() {
+		doc := strings.TrimRight(strings.Join(current, "\n"), "\n")
+		if !isEmptyDocument(doc) {
+			docs = append(docs, doc+"\n")
+		}
+		current = nil
+	}
+
+	for _, l := range lines {
+		if isDocumentSeparator(l) {
+			flush()
+			continue
+		}
+		current = append(current, l)
+	}
+	flush()
+
+	return docs
+}
+
+func isDocumentSeparator(l string) bool {
+	l = strings.TrimRight(l, " \t\r")
+
+	return l == "---" || strings.HasPrefix(l, "--- #")
+}
+
+func isEmptyDocument(doc string) bool {
+	for _, l := range strings.Split(doc, "\n") {
+		trimmed := strings.TrimSpace(l)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeTempValuesFiles writes the values to temporary state values files in the working directory
+// and returns their absolute paths in order, to be removed by removeTempFiles after the operation.
+// A multi-document values string results in one file per document.
+// id is the ID of the release set embedded in the file names, if any.
+func writeTempValuesFiles(workingDirectory, id string, values []interface{}) ([]string, error) {
+	var paths []string
+
+	for _, vs := range values {
+		for _, doc := range splitValuesDocuments(fmt.Sprintf("%s", vs)) {
+			js := []byte(doc)
+
+			valuesHash := sha256.New()
+			valuesHash.Write(js)
+
+			relpath := filepath.Join(
+				workingDirectory,
+				scratchFileName("temp.values", id, valuesHash.Sum(nil), ".yaml"),
+			)
+
+			abspath, err := filepath.Abs(relpath)
+			if err != nil {
+				return nil, xerrors.Errorf("getting absolute path to %s: %w", abspath, err)
+			}
+
+			if err := writeTempFile(abspath, js, 0700); err != nil {
+				removeTempFiles(false, paths...)
+				return nil, err
+			}
+
+			paths = append(paths, abspath)
+		}
+	}
+
+	return paths, nil
+}
+
+// inlineStateValues merges the values into the state values that the embedded helmfile layers after the state values files,
+// like --state-values-set but with the values typed as in the YAML
+func inlineStateValues(values []interface{}) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+
+	for i, vs := range values {
+		for _, doc := range splitValuesDocuments(fmt.Sprintf("%s", vs)) {
+			var v interface{}
+			if err := yaml.Unmarshal([]byte(doc), &v); err != nil {
+				return nil, fmt.Errorf("parsing values[%d]: %w", i, err)
+			}
+
+			if v == nil {
+				continue
+			}
+
+			m, ok := stringKeyedValues(v).(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("parsing values[%d]: expected a map, got %T", i, v)
+			}
+
+			merged = mergeValues(merged, m)
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,210 @@
+package helmfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestValuesHandlingPrecedence asserts which value wins for a key defined in environment values, values_files and values
+func TestValuesHandlingPrecedence(t *testing.T) {
+	// The embedded helmfile only needs helm to report its version to print the environment
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "helm"), []byte("#!/bin/sh\necho v3.14.0+g3fc9f4b\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		valuesHandling string
+		want           string
+	}{
+		{
+			valuesHandling: ValuesHandlingFiles,
+			want:           "from-values-files",
+		},
+		{
+			valuesHandling: ValuesHandlingInline,
+			want:           "from-values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.valuesHandling, func(t *testing.T) {
+			dir := t.TempDir()
+
+			valuesFile := filepath.Join(dir, "values-file.yaml")
+			if err := ioutil.WriteFile(valuesFile, []byte("key: from-values-files\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			fs := &ReleaseSet{
+				Content: `environments:
+  default:
+    values:
+    - key: from-environment
+---
+releases: []
+`,
+				WorkingDirectory: dir,
+				Environment:      "default",
+				ValuesFiles:      []interface{}{valuesFile},
+				Values:           []interface{}{"key: from-values\n"},
+				ValuesHandling:   tt.valuesHandling,
+			}
+
+			files, err := prepareHelmfileFile(fs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer files.remove()
+
+			opts := &PrintEnvOptions{
+				BaseOptions: *buildBaseOptions(fs, files),
+			}
+
+			result, err := NewLibraryExecutor(zap.NewNop().Sugar()).PrintEnv(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("print-env failed: %v", err)
+			}
+
+			if !strings.Contains(result.Output, "key: "+tt.want) {
+				t.Errorf("expected %s to win, got:\n%s", tt.want, result.Output)
+			}
+		})
+	}
+}
+
+// TestPrepareHelmfileFileTwice asserts that preparing the same release set twice in an operation, like the diff and
+// the apply, results in the same options without modifying the release set
+func TestPrepareHelmfileFileTwice(t *testing.T) {
+	for _, valuesHandling := range []string{ValuesHandlingFiles, ValuesHandlingInline} {
+		t.Run(valuesHandling, func(t *testing.T) {
+			fs := &ReleaseSet{
+				Content:              "releases: []\n",
+				WorkingDirectory:     t.TempDir(),
+				Kubeconfig:           "/tmp/kubeconfig",
+				ValuesFiles:          []interface{}{"values-file.yaml"},
+				Values:               []interface{}{"key: from-values\n"},
+				ValuesHandling:       valuesHandling,
+				ReleasesValuesString: map[string]interface{}{"image.tag": "v1"},
+			}
+
+			var applies []*ApplyOptions
+
+			for i := 0; i < 2; i++ {
+				files, err := prepareHelmfileFile(fs, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				applies = append(applies, buildApplyOptions(fs, files))
+
+				files.remove()
+
+				for _, p := range append(append([]string{files.Path}, files.ValuesFiles...), files.ReleasesValuesFiles...) {
+					if _, err := os.Stat(p); !os.IsNotExist(err) {
+						t.Errorf("expected %s to be removed after the operation, got %v", p, err)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(applies[0], applies[1]) {
+				t.Errorf("expected the same options on the second run:\nfirst:  %+v\nsecond: %+v", applies[0], applies[1])
+			}
+
+			if want := []interface{}{"values-file.yaml"}; !reflect.DeepEqual(fs.ValuesFiles, want) {
+				t.Errorf("expected values_files to be kept as %v, got %v", want, fs.ValuesFiles)
+			}
+
+			if want := []interface{}{"key: from-values\n"}; !reflect.DeepEqual(fs.Values, want) {
+				t.Errorf("expected values to be kept as %v, got %v", want, fs.Values)
+			}
+
+			opts := applies[0]
+
+			switch valuesHandling {
+			case ValuesHandlingFiles:
+				if len(opts.ValuesFiles) != 2 || opts.ValuesFiles[1] != "values-file.yaml" || len(opts.Values) != 0 {
+					t.Errorf("expected values to be written to a file before values_files, got %v and %v", opts.ValuesFiles, opts.Values)
+				}
+			case ValuesHandlingInline:
+				if len(opts.ValuesFiles) != 1 || opts.StateValuesSet["key"] != "from-values" {
+					t.Errorf("expected values to be passed as state values, got %v and %v", opts.ValuesFiles, opts.StateValuesSet)
+				}
+			}
+
+			if len(opts.ReleasesValuesFiles) != 1 {
+				t.Errorf("expected a releases values file, got %v", opts.ReleasesValuesFiles)
+			}
+		})
+	}
+}
+
+func TestInlineStateValues(t *testing.T) {
+	got, err := inlineStateValues([]interface{}{
+		"a:\n  b: 1\n  c: 2\n---\na:\n  c: 3\n",
+		"d: true\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := got["a"].(map[string]interface{})
+	if a["b"] != 1 || a["c"] != 3 || got["d"] != true {
+		t.Errorf("expected the documents to be merged in order, got %v", got)
+	}
+
+	if _, err := inlineStateValues([]interface{}{"- a\n"}); err == nil {
+		t.Error("expected values that are not a map to fail")
+	}
+}
+
+func TestNewCommandWithKubeconfigValuesOrder(t *testing.T) {
+	tests := []struct {
+		valuesHandling string
+		valuesFirst    bool
+	}{
+		{valuesHandling: ValuesHandlingFiles, valuesFirst: true},
+		{valuesHandling: ValuesHandlingInline, valuesFirst: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.valuesHandling, func(t *testing.T) {
+			fs := &ReleaseSet{
+				Bin:              "helmfile",
+				WorkingDirectory: t.TempDir(),
+				Kubeconfig:       writeTestKubeconfig(t),
+				ValuesFiles:      []interface{}{"values-file.yaml"},
+				Values:           []interface{}{"key: from-values\n"},
+				ValuesHandling:   tt.valuesHandling,
+			}
+
+			cmd, err := NewCommandWithKubeconfig(fs, "diff")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var files []string
+			for i, a := range cmd.Args {
+				if a == "--state-values-file" {
+					files = append(files, cmd.Args[i+1])
+				}
+			}
+
+			if len(files) != 2 {
+				t.Fatalf("expected two state values files, got %v", cmd.Args)
+			}
+
+			if valuesFirst := files[1] == "values-file.yaml"; valuesFirst != tt.valuesFirst {
+				t.Errorf("unexpected order of state values files: %v", files)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package helmfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// DefaultValuesSchemaTimeout is the default number of seconds to wait for the charts to be fetched and validated
+const DefaultValuesSchemaTimeout = 300
+
+// valuesSchemaOutputDirTemplate lays out the fetched charts by release so that the chart of each release can be found
+const valuesSchemaOutputDirTemplate = "{{ .OutputDir }}/{{ .Release.Namespace }}/{{ .Release.Name }}"
+
+// chartSchema is the values.schema.json of a chart compiled along with the default values of the chart
+type chartSchema struct {
+	// Schema is nil when the chart has no values.schema.json
+	Schema *jsonschema.Schema
+
+	Defaults map[string]interface{}
+}
+
+// chartSchemas caches *chartSchema by chart and version, as fetching the charts on every plan is slow
+var chartSchemas sync.Map
+
+// builtRelease is a release in the output of helmfile build --embed-values
+type builtRelease struct {
+	Name      string          `yaml:"name"`
+	Namespace string          `yaml:"namespace"`
+	Chart     string          `yaml:"chart"`
+	Version   string          `yaml:"version"`
+	Installed *bool           `yaml:"installed"`
+	Values    []interface{}   `yaml:"values"`
+	Set       []builtSetValue `yaml:"set"`
+}
+
+type builtSetValue struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+func (r builtRelease) id() string {
+	return fmt.Sprintf("%s/%s", r.Namespace, r.Name)
+}
+
+// chartKey identifies the chart of the release in chartSchemas
+func (r builtRelease) chartKey() string {
+	version := r.Version
+	if version == "" {
+		version = "latest"
+	}
+	return fmt.Sprintf("%s@%s", r.Chart, version)
+}
+
+// validateValuesAgainstSchema validates the effective values of each release against the values.schema.json of its chart,
+// and returns an error naming the release and the JSON pointer of each violation.
+// Charts without values.schema.json are skipped.
+func validateValuesAgainstSchema(ctx *sdk.Context, fs *ReleaseSet) error {
+	timeout := time.Duration(fs.ValuesSchemaTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultValuesSchemaTimeout * time.Second
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- validateReleaseValues(ctx, fs)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("validating values against chart schemas timed out after %s. Increase %s to wait longer", timeout, KeyValuesSchemaTimeout)
+	}
+}
+
+func validateReleaseValues(ctx *sdk.Context, fs *ReleaseSet) error {
+	build, err := runBuild(ctx, fs, "--embed-values")
+	if err != nil {
+		return fmt.Errorf("running helmfile build: %w", err)
+	}
+
+	releases, err := parseBuiltReleases(build.Output)
+	if err != nil {
+		return err
+	}
+
+	if err := fetchChartSchemas(ctx, fs, releases); err != nil {
+		return err
+	}
+
+	var violations []string
+
+	for _, r := range releases {
+		v, ok := chartSchemas.Load(r.chartKey())
+		if !ok {
+			logf("Warning: skipping values schema validation of release %s: chart %s was not fetched", r.id(), r.Chart)
+			continue
+		}
+
+		vs, err := releaseValuesViolations(v.(*chartSchema), r, fs)
+		if err != nil {
+			return fmt.Errorf("validating values of release %s: %w", r.id(), err)
+		}
+
+		violations = append(violations, vs...)
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%d values violate the chart schemas:\n  %s", len(violations), strings.Join(violations, "\n  "))
+	}
+
+	return nil
+}
+
+// parseBuiltReleases parses the releases to be installed out of the multi-document output of helmfile build
+func parseBuiltReleases(output string) ([]builtRelease, error) {
+	s, err := removeNondeterministicBuildLogLines(output)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(strings.NewReader(s))
+
+	var releases []builtRelease
+
+	for {
+		var doc struct {
+			Releases []builtRelease `yaml:"releases"`
+		}
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, fmt.Errorf("decoding helmfile build output: %w", err)
+		}
+
+		for _, r := range doc.Releases {
+			if r.Installed != nil && !*r.Installed {
+				continue
+			}
+			releases = append(releases, r)
+		}
+	}
+
+	return releases, nil
+}
+
+// fetchChartSchemas fetches the charts that are not yet in chartSchemas with helmfile fetch and caches their schemas
+func fetchChartSchemas(ctx *sdk.Context, fs *ReleaseSet, releases []builtRelease) error {
+	var missing []builtRelease
+	for _, r := range releases {
+		if _, ok := chartSchemas.Load(r.chartKey()); !ok {
+			missing = append(missing, r)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	dir, err := scratchDir(fs)
+	if err != nil {
+		return err
+	}
+
+	parent := filepath.Join(dir, ".terraform", "helmfile")
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("creating directory %s: %w", parent, err)
+	}
+
+	outputDir, err := ioutil.TempDir(parent, "charts-")
+	if err != nil {
+		return fmt.Errorf("creating directory to fetch charts to: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	if _, err := runFetch(ctx, fs, outputDir); err != nil {
+		return fmt.Errorf("running helmfile fetch: %w", err)
+	}
+
+	for _, r := range missing {
+		chartDir, err := findChartDir(filepath.Join(outputDir, r.Namespace, r.Name))
+		if err != nil {
+			return fmt.Errorf("finding the chart of release %s: %w", r.id(), err)
+		}
+
+		if chartDir == "" {
+			continue
+		}
+
+		cs, err := loadChartSchema(chartDir)
+		if err != nil {
+			return fmt.Errorf("loading the values schema of chart %s: %w", r.Chart, err)
+		}
+
+		chartSchemas.Store(r.chartKey(), cs)
+	}
+
+	return nil
+}
+
+func runFetch(ctx *sdk.Context, fs *ReleaseSet, outputDir string) (*State, error) {
+	cmd, err := NewCommandWithKubeconfig(fs, "fetch", "--output-dir", outputDir, "--output-dir-template", valuesSchemaOutputDirTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	//obtain exclusive lock
+	mutexKV.Lock(fs.WorkingDirectory)
+	defer mutexKV.Unlock(fs.WorkingDirectory)
+
+	unlock, err := lockWorkingDirectory(shutdownCtx, fs)
+	if err != nil {
+		closeExtraFiles(cmd)
+		removeCommandTempFiles(cmd)
+		return nil, err
+	}
+	defer unlock()
+
+	release, err := fs.Operations.acquire(shutdownCtx)
+	if err != nil {
+		closeExtraFiles(cmd)
+		removeCommandTempFiles(cmd)
+		return nil, err
+	}
+	defer release()
+
+	state := NewState()
+	return runCommand(ctx, cmd, state, false)
+}
+
+// findChartDir returns the shallowest directory containing Chart.yaml under dir, so that subcharts are ignored.
+// Local charts are copied to dir as-is while remote charts are untarred into a subdirectory named after the chart.
+func findChartDir(dir string) (string, error) {
+	var found string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() || info.Name() != "Chart.yaml" {
+			return nil
+		}
+
+		chartDir := filepath.Dir(path)
+		if found == "" || strings.Count(chartDir, string(filepath.Separator)) < strings.Count(found, string(filepath.Separator)) {
+			found = chartDir
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// loadChartSchema compiles the values.schema.json of the chart in chartDir along with its values.yaml
+func loadChartSchema(chartDir string) (*chartSchema, error) {
+	cs := &chartSchema{
+		Defaults: map[string]interface{}{},
+	}
+
+	if bs, err := ioutil.ReadFile(filepath.Join(chartDir, "values.yaml")); err == nil {
+		var defaults interface{}
+		if err := yaml.Unmarshal(bs, &defaults); err != nil {
+			return nil, fmt.Errorf("parsing values.yaml: %w", err)
+		}
+
+		if m, ok := stringKeyedValues(defaults).(map[string]interface{}); ok {
+			cs.Defaults = m
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(chartDir, "values.schema.json"))
+	if os.IsNotExist(err) {
+		return cs, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("parsing values.schema.json: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("values.schema.json", doc); err != nil {
+		return nil, fmt.Errorf("adding values.schema.json: %w", err)
+	}
+
+	cs.Schema, err = c.Compile("values.schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("compiling values.schema.json: %w", err)
+	}
+
+	return cs, nil
+}
+
+// releaseValuesViolations validates the effective values of the release against the chart schema.
+// The effective values are the chart defaults overridden by the release values and sets,
+// and then by releases_values and releases_values_string, as helm merges them.
+func releaseValuesViolations(cs *chartSchema, r builtRelease, fs *ReleaseSet) ([]string, error) {
+	if cs.Schema == nil {
+		return nil, nil
+	}
+
+	values := mergeValues(map[string]interface{}{}, cs.Defaults)
+
+	for _, v := range r.Values {
+		// Values that are not maps are file paths that helmfile build could not embed
+		if m, ok := stringKeyedValues(v).(map[string]interface{}); ok {
+			values = mergeValues(values, m)
+		}
+	}
+
+	for _, s := range r.Set {
+		if err := strvals.ParseInto(fmt.Sprintf("%s=%s", s.Name, s.Value), values); err != nil {
+			return nil, fmt.Errorf("parsing set %s: %w", s.Name, err)
+		}
+	}
+
+	for _, s := range setFlagValues(releasesSetValues(fs)) {
+		if err := strvals.ParseInto(s, values); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", KeyReleasesValues, err)
+		}
+	}
+
+	for _, s := range setFlagsOfSetValues(fs.SetValues) {
+		if err := strvals.ParseInto(s, values); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", KeySet, err)
+		}
+	}
+
+	if stringValues := releasesStringValues(fs); len(stringValues) > 0 {
+		y, err := releasesStringValuesYAML(stringValues)
+		if err != nil {
+			return nil, err
+		}
+
+		var stringValues interface{}
+		if err := yaml.Unmarshal([]byte(y), &stringValues); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", KeyReleasesValuesString, err)
+		}
+
+		if m, ok := stringKeyedValues(stringValues).(map[string]interface{}); ok {
+			values = mergeValues(values, m)
+		}
+	}
+
+	// Round-trip through JSON so that numbers are typed as the schema validator expects
+	bs, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("encoding values: %w", err)
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("decoding values: %w", err)
+	}
+
+	err = cs.Schema.Validate(instance)
+	if err == nil {
+		return nil, nil
+	}
+
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return nil, err
+	}
+
+	p := message.NewPrinter(language.English)
+
+	var violations []string
+	for _, leaf := range validationErrorLeaves(verr) {
+		violations = append(violations, fmt.Sprintf("release %s: %s: %s", r.id(), jsonPointer(leaf.InstanceLocation), leaf.ErrorKind.LocalizedString(p)))
+	}
+
+	sort.Strings(violations)
+
+	return violations, nil
+}
+
+// validationErrorLeaves returns the most specific causes of the validation error
+func validationErrorLeaves(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+
+	var leaves []*jsonschema.ValidationError
+	for _, c := range err.Causes {
+		leaves = append(leaves, validationErrorLeaves(c)...)
+	}
+	return leaves
+}
+
+// jsonPointer formats the instance location as a JSON pointer, using "/" for the root so that it is visible in messages
+func jsonPointer(location []string) string {
+	if len(location) == 0 {
+		return "/"
+	}
+
+	var sb strings.Builder
+	for _, tok := range location {
+		sb.WriteByte('/')
+		sb.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(tok))
+	}
+	return sb.String()
+}
+
+// mergeValues deep-merges src into dst like helm merges values files, where maps are merged and other values are replaced
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeValues(dstMap, srcMap)
+		} else if srcIsMap {
+			dst[k] = mergeValues(map[string]interface{}{}, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+	return dst
+}
+
+// stringKeyedValues converts the map[interface{}]interface{} decoded by yaml.v2 into map[string]interface{}
+func stringKeyedValues(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, item := range typed {
+			m[fmt.Sprintf("%v", k)] = stringKeyedValues(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, 0, len(typed))
+		for _, item := range typed {
+			items = append(items, stringKeyedValues(item))
+		}
+		return items
+	default:
+		return v
+	}
+}
@@ -0,0 +1,129 @@
+package helmfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseBuiltReleases(t *testing.T) {
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "values_schema", "build.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releases, err := parseBuiltReleases(string(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 1 {
+		t.Fatalf("expected the releases that are not installed to be skipped, got %+v", releases)
+	}
+
+	if got := releases[0].chartKey(); got != "./charts/myapp@1.2.3" {
+		t.Errorf("unexpected chart key: %s", got)
+	}
+}
+
+func TestFindChartDir(t *testing.T) {
+	dir := filepath.Join("testdata", "values_schema", "fetched", "default", "myapp")
+
+	got, err := findChartDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "myapp"); got != want {
+		t.Errorf("expected the subchart to be ignored: want %s, got %s", want, got)
+	}
+
+	if got, err := findChartDir(filepath.Join("testdata", "values_schema", "fetched", "default", "missing")); err != nil || got != "" {
+		t.Errorf("expected no chart for a release that was not fetched, got %q, %v", got, err)
+	}
+}
+
+func TestReleaseValuesViolations(t *testing.T) {
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "values_schema", "build.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releases, err := parseBuiltReleases(string(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := loadChartSchema(filepath.Join("testdata", "values_schema", "fetched", "default", "myapp", "myapp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fs   *ReleaseSet
+		want []string
+	}{
+		{
+			name: "release values",
+			fs:   &ReleaseSet{},
+			want: []string{
+				"release default/myapp: /replicaCount: minimum: got 0, want 1",
+				"release default/myapp: /service/type: value must be one of 'ClusterIP', 'NodePort', 'LoadBalancer'",
+			},
+		},
+		{
+			name: "releases_values override release values",
+			fs: &ReleaseSet{
+				ReleasesValues: map[string]interface{}{
+					"replicaCount": "2",
+					"service.type": "NodePort",
+				},
+			},
+		},
+		{
+			name: "releases_values_string are strings",
+			fs: &ReleaseSet{
+				ReleasesValues: map[string]interface{}{
+					"service.type": "NodePort",
+				},
+				ReleasesValuesString: map[string]interface{}{
+					"replicaCount": "2",
+				},
+			},
+			want: []string{
+				"release default/myapp: /replicaCount: got string, want integer",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := releaseValuesViolations(cs, releases[0], tt.fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected violations: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReleaseValuesViolationsWithoutSchema(t *testing.T) {
+	cs, err := loadChartSchema(filepath.Join("testdata", "values_schema", "fetched", "default", "myapp", "myapp", "charts", "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := releaseValuesViolations(cs, builtRelease{Name: "sub", Namespace: "default"}, &ReleaseSet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("expected charts without values.schema.json to be skipped, got %v", got)
+	}
+}
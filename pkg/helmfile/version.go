@@ -0,0 +1,102 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+// semverPattern matches semver-looking tokens like v0.150.0, 3.12.0 or 1.4.1-rc.1+build.1
+var semverPattern = regexp.MustCompile(`\bv?(\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?)\b`)
+
+// parseVersion returns the first semver-looking token in the output of a version command.
+//
+// helm and helmfile binaries are sometimes wrapped by shell scripts that print extra lines like banners,
+// so we scan the whole output rather than assuming it consists only of the version.
+func parseVersion(output string) (*semver.Version, error) {
+	for _, m := range semverPattern.FindAllStringSubmatch(output, -1) {
+		v, err := semver.NewVersion(m[1])
+		if err != nil {
+			continue
+		}
+
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("no version found in the output of the version command:\n%s", output)
+}
+
+// versionProbeTimeout bounds the version command run on plan, so that a hanging wrapper doesn't block the plan
+const versionProbeTimeout = 10 * time.Second
+
+// helmfileModulePath is the path of the helmfile module embedded in the provider
+const helmfileModulePath = "github.com/helmfile/helmfile"
+
+// readBuildInfo returns the build info of the provider. Tests replace it.
+var readBuildInfo = debug.ReadBuildInfo
+
+// embeddedHelmfileVersion returns the version of the helmfile module the provider is built with, like 1.4.1
+func embeddedHelmfileVersion() (string, bool) {
+	info, ok := readBuildInfo()
+	if !ok {
+		return "", false
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != helmfileModulePath {
+			continue
+		}
+
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			dep = dep.Replace
+		}
+
+		// A replacement by a local directory has no version
+		if dep.Version == "" || dep.Version == "(devel)" {
+			return "", false
+		}
+
+		return strings.TrimPrefix(dep.Version, "v"), true
+	}
+
+	return "", false
+}
+
+// setEffectiveVersion records the version of helmfile that applied the release set.
+// Failing to detect it only records a warning, as it is informational.
+func setEffectiveVersion(ctx context.Context, d ResourceReadWrite, executor HelmfileExecutor) {
+	v, err := executor.Version(ctx)
+	setVersionWarning(d, err)
+	if err != nil {
+		return
+	}
+
+	d.Set(KeyEffectiveVersion, v)
+}
+
+// detectVersion returns the version of helmfile the executor runs, which the binary executor detects by running the
+// version command of bin and the library executor from the build info of the provider
+func detectVersion(executor HelmfileExecutor) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), versionProbeTimeout)
+	defer cancel()
+
+	return executor.Version(ctx)
+}
+
+// setVersionWarning records why the version of helmfile couldn't be detected, like a wrapper script of bin printing
+// only a banner, or clears it once detected.
+// SDK v1 reports no warnings from the plan or the apply, so the warning is shown in the plan as an attribute.
+func setVersionWarning(d ResourceReadWrite, err error) {
+	var warning string
+	if err != nil {
+		warning = fmt.Sprintf("failed to detect the helmfile version: %v", err)
+		logf("Warning: %s", warning)
+	}
+
+	d.Set(KeyVersionWarning, warning)
+}
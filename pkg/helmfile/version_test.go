@@ -0,0 +1,130 @@
+package helmfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{
+			name:     "plain helmfile version",
+			output:   "helmfile version v0.150.0\n",
+			expected: "0.150.0",
+		},
+		{
+			name:     "wrapper script printing a banner",
+			output:   "Using helm from /opt/tools (managed by platform-team)\nv3.12.0+gc9f554d\n",
+			expected: "3.12.0+gc9f554d",
+		},
+		{
+			name:     "banner containing a non-semver number",
+			output:   "==> wrapper 2\nversion.BuildInfo{Version:\"v3.14.2\", GitCommit:\"c309b6f0\"}\n",
+			expected: "3.14.2",
+		},
+		{
+			name: "helmfile v1 table output",
+			output: `▓▓▓ helmfile
+
+  Version            1.4.1
+  Git Commit         d3a0b4b
+`,
+			expected: "1.4.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := parseVersion(tt.output)
+			if err != nil {
+				t.Fatalf("parseVersion failed: %v", err)
+			}
+			if v.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, v.String())
+			}
+		})
+	}
+}
+
+func TestParseVersionNotFound(t *testing.T) {
+	output := "wrapper: helm is not installed"
+
+	_, err := parseVersion(output)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// The raw output must be included so that the user can see what the wrapper printed
+	if !strings.Contains(err.Error(), output) {
+		t.Errorf("expected error to contain the raw output, got %v", err)
+	}
+}
+
+// TestVersionWarning tests that a bin whose version can't be detected is recorded in version_warning with the output
+// of the wrapper, and that the warning is cleared once the version is detected
+func TestVersionWarning(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"banner":  "#!/bin/sh\necho 'Welcome to the platform helmfile'\n",
+		"version": "#!/bin/sh\necho 'Welcome to the platform helmfile'\necho 'helmfile version v0.150.0'\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &ResourceReadWriteEmbedded{m: map[string]interface{}{}}
+
+	_, err := detectVersion(NewBinaryExecutor(filepath.Join(dir, "banner")))
+	setVersionWarning(d, err)
+
+	if got := d.Get(KeyVersionWarning).(string); !strings.Contains(got, "Welcome to the platform helmfile") {
+		t.Errorf("expected %s to contain the output of the wrapper, got %q", KeyVersionWarning, got)
+	}
+
+	setEffectiveVersion(context.Background(), d, NewBinaryExecutor(filepath.Join(dir, "version")))
+
+	if got := d.Get(KeyVersionWarning).(string); got != "" {
+		t.Errorf("expected %s to be cleared, got %q", KeyVersionWarning, got)
+	}
+	if got := d.Get(KeyEffectiveVersion); got != "0.150.0" {
+		t.Errorf("expected %s 0.150.0, got %v", KeyEffectiveVersion, got)
+	}
+}
+
+// TestReleaseSetSchemaValidatesBinWithoutRunningIt tests that validating bin never runs it, as the validation must
+// have no side effects
+func TestReleaseSetSchemaValidatesBinWithoutRunningIt(t *testing.T) {
+	dir := t.TempDir()
+
+	bin := filepath.Join(dir, "helmfile")
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\ntouch "+filepath.Join(dir, "ran")+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		KeyContent: "releases: []",
+		KeyBin:     bin,
+		KeyHelmBin: bin,
+	})
+
+	if warns, errs := (&schema.Resource{Schema: ReleaseSetSchema}).Validate(config); len(warns) != 0 || len(errs) != 0 {
+		t.Fatalf("unexpected warnings %v and errors %v", warns, errs)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("expected the validation not to run bin")
+	}
+}
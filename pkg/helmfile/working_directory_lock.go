@@ -0,0 +1,178 @@
+package helmfile
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultWorkingDirectoryLockTimeout is the default duration to wait for the lock on a working directory
+const DefaultWorkingDirectoryLockTimeout = 30 * time.Minute
+
+// workingDirectoryLockFile is the name of the lock file in the working directory
+const workingDirectoryLockFile = ".terraform-helmfile.lock"
+
+// workingDirectoryLockPollInterval is how often a held lock is tried again
+var workingDirectoryLockPollInterval = 100 * time.Millisecond
+
+// lockWorkingDirectory waits for the advisory lock on the working directory of the release set, so that the release sets
+// sharing the working directory never run helmfile in it at the same time, as the helm repository cache, the chartify
+// temporary directories and the .helmfile.d artifacts would otherwise step on each other. It returns the func that
+// releases the lock.
+//
+// The lock is an flock on Unix and LockFileEx on Windows, which the OS releases along with a stopped provider. It is
+// skipped when the files are written to the isolated scratch_directory, which is never shared with other release sets.
+func lockWorkingDirectory(ctx context.Context, fs *ReleaseSet) (func(), error) {
+	if isolatedScratchSubdir(fs) != "" {
+		return func() {}, nil
+	}
+
+	// The lock file falls back to the temporary directory along with the temporary files when the working directory is read-only
+	dir, err := scratchDir(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, workingDirectoryLockFile)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file of working directory: %w", err)
+	}
+
+	timeout := fs.WorkingDirectoryLockTimeout
+	if timeout == 0 {
+		timeout = DefaultWorkingDirectoryLockTimeout
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for waiting := false; ; waiting = true {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking working directory %s: %w", dir, err)
+		}
+
+		if locked {
+			break
+		}
+
+		if !waiting {
+			logf("Waiting for %s to release the lock on working directory %s", lockHolder(path), dir)
+		}
+
+		select {
+		case <-time.After(workingDirectoryLockPollInterval):
+		case <-deadline.C:
+			f.Close()
+			return nil, fmt.Errorf("timed out after %s waiting for %s to release the lock on working directory %s. Increase %s, or set %s = %q so that the release sets never share a directory",
+				timeout, lockHolder(path), dir, KeyWorkingDirectoryLockTimeout, KeyScratchDirectory, ScratchDirectoryIsolated)
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("waiting for %s to release the lock on working directory %s: %w", lockHolder(path), dir, ctx.Err())
+		}
+	}
+
+	// The holder is recorded for the error of the release sets waiting for the lock
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(lockOwner(fs)), 0)
+	}
+
+	return func() {
+		f.Truncate(0)
+
+		if err := unlockFile(f); err != nil {
+			logf("Warning: unlocking working directory %s: %v", dir, err)
+		}
+
+		f.Close()
+	}, nil
+}
+
+// lockOwner describes the release set holding the lock
+func lockOwner(fs *ReleaseSet) string {
+	if fs.ID == "" {
+		return fmt.Sprintf("a release set being created (pid %d)", os.Getpid())
+	}
+
+	return fmt.Sprintf("release set %s (pid %d)", fs.ID, os.Getpid())
+}
+
+// lockHolder returns the release set holding the lock of the lock file, as recorded by lockOwner
+func lockHolder(path string) string {
+	bs, err := ioutil.ReadFile(path)
+	if holder := strings.TrimSpace(string(bs)); err == nil && holder != "" {
+		return holder
+	}
+
+	return "another release set"
+}
+
+// lockedExecutor holds the lock on the working directory of the release set while each operation of the executor runs
+type lockedExecutor struct {
+	HelmfileExecutor
+
+	fs *ReleaseSet
+}
+
+// lockExecutor wraps the executor with a lockedExecutor unless the lock is skipped for the release set
+func lockExecutor(executor HelmfileExecutor, fs *ReleaseSet) HelmfileExecutor {
+	if isolatedScratchSubdir(fs) != "" {
+		return executor
+	}
+
+	return &lockedExecutor{HelmfileExecutor: executor, fs: fs}
+}
+
+// run runs the operation while holding the lock
+func (e *lockedExecutor) run(ctx context.Context, op func() (*Result, error)) (*Result, error) {
+	unlock, err := lockWorkingDirectory(ctx, e.fs)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return op()
+}
+
+func (e *lockedExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Apply(ctx, opts) })
+}
+
+func (e *lockedExecutor) Sync(ctx context.Context, opts *SyncOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Sync(ctx, opts) })
+}
+
+func (e *lockedExecutor) Diff(ctx context.Context, opts *DiffOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Diff(ctx, opts) })
+}
+
+func (e *lockedExecutor) Template(ctx context.Context, opts *TemplateOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Template(ctx, opts) })
+}
+
+func (e *lockedExecutor) Destroy(ctx context.Context, opts *DestroyOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Destroy(ctx, opts) })
+}
+
+func (e *lockedExecutor) Build(ctx context.Context, opts *BuildOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Build(ctx, opts) })
+}
+
+func (e *lockedExecutor) PrintEnv(ctx context.Context, opts *PrintEnvOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.PrintEnv(ctx, opts) })
+}
+
+func (e *lockedExecutor) List(ctx context.Context, opts *ListOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.List(ctx, opts) })
+}
+
+func (e *lockedExecutor) Lint(ctx context.Context, opts *LintOptions) (*Result, error) {
+	return e.run(ctx, func() (*Result, error) { return e.HelmfileExecutor.Lint(ctx, opts) })
+}
@@ -0,0 +1,169 @@
+package helmfile
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/terraform-provider-eksctl/pkg/sdk"
+)
+
+// overlapExecutor records how many applies run at once. Each apply waits a while for another one to start.
+type overlapExecutor struct {
+	HelmfileExecutor
+
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func (e *overlapExecutor) Apply(ctx context.Context, opts *ApplyOptions) (*Result, error) {
+	e.mu.Lock()
+	e.active++
+	if e.active > e.max {
+		e.max = e.active
+	}
+	e.mu.Unlock()
+
+	for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		e.mu.Lock()
+		overlapped := e.max > 1
+		e.mu.Unlock()
+
+		if overlapped {
+			break
+		}
+	}
+
+	e.mu.Lock()
+	e.active--
+	e.mu.Unlock()
+
+	return &Result{}, nil
+}
+
+// applyConcurrently drives Apply of the release sets from a goroutine each, returning the most applies run at once
+func applyConcurrently(t *testing.T, sets ...*ReleaseSet) int {
+	t.Helper()
+
+	executor := &overlapExecutor{}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(sets))
+
+	for _, fs := range sets {
+		wg.Add(1)
+		go func(fs *ReleaseSet) {
+			defer wg.Done()
+
+			if _, err := lockExecutor(executor, fs).Apply(context.Background(), &ApplyOptions{}); err != nil {
+				errs <- err
+			}
+		}(fs)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	return executor.max
+}
+
+func TestLockWorkingDirectory(t *testing.T) {
+	t.Run("shared working directory", func(t *testing.T) {
+		dir := t.TempDir()
+
+		if max := applyConcurrently(t, &ReleaseSet{ID: "a", WorkingDirectory: dir}, &ReleaseSet{ID: "b", WorkingDirectory: dir}); max != 1 {
+			t.Errorf("expected the applies to be serialized, got %d at once", max)
+		}
+	})
+
+	t.Run("different working directories", func(t *testing.T) {
+		if max := applyConcurrently(t, &ReleaseSet{ID: "a", WorkingDirectory: t.TempDir()}, &ReleaseSet{ID: "b", WorkingDirectory: t.TempDir()}); max != 2 {
+			t.Errorf("expected the applies to run at once, got %d at once", max)
+		}
+	})
+
+	t.Run("isolated scratch directory", func(t *testing.T) {
+		dir := t.TempDir()
+
+		a := &ReleaseSet{ID: "a", WorkingDirectory: dir, ScratchDirectory: ScratchDirectoryIsolated}
+		b := &ReleaseSet{ID: "b", WorkingDirectory: dir, ScratchDirectory: ScratchDirectoryIsolated}
+
+		if max := applyConcurrently(t, a, b); max != 2 {
+			t.Errorf("expected the lock to be skipped, got %d at once", max)
+		}
+	})
+}
+
+func TestLockWorkingDirectoryTimeout(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := lockWorkingDirectory(context.Background(), &ReleaseSet{ID: "myapp", WorkingDirectory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lockWorkingDirectory(context.Background(), &ReleaseSet{ID: "other", WorkingDirectory: dir, WorkingDirectoryLockTimeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected the lock to time out")
+	}
+
+	for _, want := range []string{"timed out after 200ms", "release set myapp (pid", KeyWorkingDirectoryLockTimeout} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to contain %q, got %q", want, err.Error())
+		}
+	}
+
+	unlock()
+
+	// The lock is taken once released
+	unlock, err = lockWorkingDirectory(context.Background(), &ReleaseSet{ID: "other", WorkingDirectory: dir, WorkingDirectoryLockTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unlock()
+}
+
+func TestLegacyCommandsLockWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := lockWorkingDirectory(context.Background(), &ReleaseSet{ID: "myapp", WorkingDirectory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	fs := &ReleaseSet{
+		ID:                          "other",
+		Bin:                         "helmfile",
+		Content:                     "releases: []\n",
+		WorkingDirectory:            dir,
+		Kubeconfig:                  writeTestKubeconfig(t),
+		WorkingDirectoryLockTimeout: 100 * time.Millisecond,
+	}
+
+	for name, run := range map[string]func() error{
+		"diff": func() error {
+			_, err := runDiff(&sdk.Context{}, fs, DiffConfig{})
+			return err
+		},
+		"template": func() error {
+			_, err := runTemplate(&sdk.Context{}, fs)
+			return err
+		},
+		"build": func() error {
+			_, err := runBuild(&sdk.Context{}, fs)
+			return err
+		},
+	} {
+		if err := run(); err == nil || !strings.Contains(err.Error(), "waiting for release set myapp (pid") {
+			t.Errorf("%s: expected the command to wait for the lock held by myapp, got %v", name, err)
+		}
+	}
+}
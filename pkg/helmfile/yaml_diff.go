@@ -0,0 +1,60 @@
+package helmfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"gopkg.in/yaml.v2"
+)
+
+// suppressEquivalentYAML suppresses the diff of content and values when the old and the new value are the same YAML
+// documents, like the ones with the keys reordered, the strings quoted differently or the trailing newline changed.
+// Any of them that is not valid YAML, like a helmfile template that isn't YAML before it is rendered, falls back to
+// comparing the strings, as does an empty one so that the resource is never created without them.
+func suppressEquivalentYAML(k, old, new string, d *schema.ResourceData) bool {
+	if old == new {
+		return true
+	}
+
+	if old == "" || new == "" {
+		return false
+	}
+
+	oldDocs, err := decodeYAMLDocuments(old)
+	if err != nil {
+		return false
+	}
+
+	newDocs, err := decodeYAMLDocuments(new)
+	if err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(oldDocs, newDocs)
+}
+
+// decodeYAMLDocuments decodes all the documents in s, as a change in any document but the first would otherwise be suppressed
+func decodeYAMLDocuments(s string) (docs []interface{}, err error) {
+	// yaml.v2 panics on some invalid inputs, like mapping keys that are mappings themselves
+	defer func() {
+		if r := recover(); r != nil {
+			docs, err = nil, fmt.Errorf("decoding YAML: %v", r)
+		}
+	}()
+
+	dec := yaml.NewDecoder(bytes.NewReader([]byte(s)))
+
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, doc)
+	}
+}
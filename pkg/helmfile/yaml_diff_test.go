@@ -0,0 +1,117 @@
+package helmfile
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestSuppressEquivalentYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		suppress bool
+	}{
+		{
+			name:     "reordered maps",
+			old:      "image:\n  repository: nginx\n  tag: \"1.25\"\nreplicaCount: 2\n",
+			new:      "replicaCount: 2\nimage:\n  tag: \"1.25\"\n  repository: nginx\n",
+			suppress: true,
+		},
+		{
+			name:     "quoted vs unquoted scalars",
+			old:      "name: myapp\nenv: 'prod'\n",
+			new:      "name: \"myapp\"\nenv: prod",
+			suppress: true,
+		},
+		{
+			name:     "flow vs block sequences",
+			old:      "args: [--verbose, --port=80]\n",
+			new:      "args:\n- --verbose\n- --port=80\n",
+			suppress: true,
+		},
+		{
+			name:     "quoted number",
+			old:      "tag: 1.20\n",
+			new:      "tag: \"1.20\"\n",
+			suppress: false,
+		},
+		{
+			name:     "reordered sequence",
+			old:      "args: [a, b]\n",
+			new:      "args: [b, a]\n",
+			suppress: false,
+		},
+		{
+			name:     "change in a later document",
+			old:      "releases: []\n---\nhelmDefaults:\n  wait: true\n",
+			new:      "releases: []\n---\nhelmDefaults:\n  wait: false\n",
+			suppress: false,
+		},
+		{
+			name:     "invalid YAML",
+			old:      "releases:\n{{ range .Values.apps }}\n- name: {{ . }}\n{{ end }}\n",
+			new:      "releases:\n{{ range .Values.apps }}\n- name: {{ . }}\n{{ end }}",
+			suppress: false,
+		},
+		{
+			name:     "new value",
+			old:      "",
+			new:      "# no releases yet\n",
+			suppress: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suppressEquivalentYAML(KeyContent, tt.old, tt.new, nil); got != tt.suppress {
+				t.Errorf("expected suppress %v, got %v", tt.suppress, got)
+			}
+		})
+	}
+}
+
+func TestReleaseSetSchemaSuppressesEquivalentValues(t *testing.T) {
+	state := &terraform.InstanceState{
+		ID: "test",
+		Attributes: map[string]string{
+			KeyContent:       "releases:\n- name: app\n  chart: charts/app\n",
+			KeyValues + ".#": "1",
+			KeyValues + ".0": "a: 1\nb: 2\n",
+		},
+	}
+
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		KeyContent: "releases:\n- chart: charts/app\n  name: app",
+		KeyValues:  []interface{}{"{b: 2, a: 1}"},
+	})
+
+	// The schema alone, as the plan of the resource would run helmfile diff
+	diff, err := (&schema.Resource{Schema: ReleaseSetSchema}).Diff(state, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff != nil {
+		for _, key := range []string{KeyContent, KeyValues + ".0"} {
+			if attr, ok := diff.Attributes[key]; ok && attr.Old != attr.New {
+				t.Errorf("expected the diff of %s to be suppressed, got %q -> %q", key, attr.Old, attr.New)
+			}
+		}
+	}
+
+	config = terraform.NewResourceConfigRaw(map[string]interface{}{
+		KeyContent: "releases:\n- chart: charts/app\n  name: app",
+		KeyValues:  []interface{}{"{b: 3, a: 1}"},
+	})
+
+	diff, err = (&schema.Resource{Schema: ReleaseSetSchema}).Diff(state, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff == nil || diff.Attributes[KeyValues+".0"] == nil {
+		t.Errorf("expected the changed values to be diffed, got %v", diff)
+	}
+}